@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// command runs a subcommand with the arguments after its name, writing its
+// report to out
+type command func(m *cenv.Manager, args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"list":           listCenvs,
+	"migrate":        migrateCenvs,
+	"vacuum":         vacuumCenvs,
+	"check":          checkCenvs,
+	"reset-password": resetPassword,
+	"purge-trash":    purgeTrash,
+	"backup":         backupCenvs,
+	"verify-backup":  verifyBackup,
+}
+
+// minPasswordLength matches the server's password rule
+const minPasswordLength = 8
+
+// listCenvs prints every cenv with its database size, archived cenvs last
+func listCenvs(m *cenv.Manager, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		return fmt.Errorf("list takes no arguments")
+	}
+	ids, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CENV\tSIZE\tSTATE")
+	for _, cenvID := range ids {
+		size := "-"
+		if bytes, err := m.DatabaseSize(cenvID); err == nil {
+			size = fmt.Sprint(bytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\tactive\n", cenvID, size)
+	}
+	for _, cenvID := range m.ArchivedCenvs() {
+		fmt.Fprintf(w, "%s\t-\tarchived\n", cenvID)
+	}
+	return w.Flush()
+}
+
+// migrateCenvs applies the current schema to the named cenvs, or all
+func migrateCenvs(m *cenv.Manager, args []string, out io.Writer) error {
+	return forEachCenv(m, args, out, func(cenvID string) error {
+		if err := m.Migrate(cenvID); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: migrated\n", cenvID)
+		return nil
+	})
+}
+
+// vacuumCenvs vacuums the named cenvs, or all, reporting the space returned
+func vacuumCenvs(m *cenv.Manager, args []string, out io.Writer) error {
+	return forEachCenv(m, args, out, func(cenvID string) error {
+		before, sizeErr := m.DatabaseSize(cenvID)
+		if err := m.Vacuum(cenvID); err != nil {
+			return err
+		}
+		after, _ := m.DatabaseSize(cenvID)
+		if sizeErr != nil {
+			fmt.Fprintf(out, "%s: vacuumed\n", cenvID)
+		} else {
+			fmt.Fprintf(out, "%s: vacuumed, %d -> %d bytes\n", cenvID, before, after)
+		}
+		return nil
+	})
+}
+
+// checkCenvs checks the named cenvs, or all, failing if any has problems
+func checkCenvs(m *cenv.Manager, args []string, out io.Writer) error {
+	return forEachCenv(m, args, out, func(cenvID string) error {
+		problems, err := m.CheckIntegrity(cenvID)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(out, "%s: ok\n", cenvID)
+			return nil
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(out, "%s: %s\n", cenvID, problem)
+		}
+		return fmt.Errorf("%d problems found", len(problems))
+	})
+}
+
+// resetPassword sets a new password for a cenv's owner, or the user named by
+// -user, generating one unless -password is given, and revokes the user's
+// sessions
+func resetPassword(m *cenv.Manager, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	flags.SetOutput(out)
+	username := flags.String("user", "", "User to reset, the cenv's owner by default")
+	password := flags.String("password", "", "New password, generated when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("reset-password takes one cenv id")
+	}
+	cenvID := flags.Arg(0)
+
+	generated := *password == ""
+	if generated {
+		buf := make([]byte, 18)
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Errorf("failed to generate password: %w", err)
+		}
+		*password = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	if len(*password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	db, err := openCenv(m, cenvID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var user *auth.User
+	if *username != "" {
+		if user, err = auth.GetUserByUsername(db, *username); err != nil {
+			return fmt.Errorf("user %s not found", *username)
+		}
+	} else {
+		users, err := auth.ListUsers(db)
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			if users[i].Role != auth.RoleOwner {
+				continue
+			}
+			if user != nil {
+				return fmt.Errorf("cenv has several owners, name one with -user")
+			}
+			user = &users[i]
+		}
+		if user == nil {
+			return fmt.Errorf("cenv has no owner, name a user with -user")
+		}
+	}
+
+	if err := auth.SetPassword(db, user, *password); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: password of %s reset, sessions revoked\n", cenvID, user.Username)
+	if generated {
+		fmt.Fprintf(out, "New password: %s\n", *password)
+	}
+	return nil
+}
+
+// purgeTrash permanently removes soft deleted rows from every soft delete
+// table of the named cenvs, those deleted longer than -older-than ago only
+// when it is set
+func purgeTrash(m *cenv.Manager, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	flags.SetOutput(out)
+	olderThan := flags.Duration("older-than", 0, "Only purge rows deleted longer ago than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("purge-trash needs the cenv ids to purge")
+	}
+	if *olderThan < 0 {
+		return fmt.Errorf("-older-than cannot be negative")
+	}
+	var before int64
+	if *olderThan > 0 {
+		before = time.Now().Add(-*olderThan).Unix()
+	}
+
+	return forEachCenv(m, flags.Args(), out, func(cenvID string) error {
+		db, err := openCenv(m, cenvID)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		names, err := tables.SoftDeleteTables(db)
+		if err != nil {
+			return err
+		}
+		var total int64
+		for _, table := range names {
+			purged, err := tables.PurgeDeleted(db, table, before)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			total += purged
+		}
+		fmt.Fprintf(out, "%s: purged %d rows from %d tables\n", cenvID, total, len(names))
+		return nil
+	})
+}
+
+// forEachCenv runs fn on the named cenvs, or every cenv when none are named.
+// A failure is reported and the remaining cenvs still run.
+func forEachCenv(m *cenv.Manager, ids []string, out io.Writer, fn func(cenvID string) error) error {
+	if len(ids) == 0 {
+		var err error
+		if ids, err = m.List(); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, cenvID := range ids {
+		err := checkCenv(m, cenvID)
+		if err == nil {
+			err = fn(cenvID)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", cenvID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cenvs failed", failed, len(ids))
+	}
+	return nil
+}
+
+// checkCenv verifies that a cenv id names a database that can be opened
+func checkCenv(m *cenv.Manager, cenvID string) error {
+	if !cenv.IsValidUUID(cenvID) {
+		return fmt.Errorf("invalid cenv id")
+	}
+	if m.IsArchived(cenvID) {
+		return fmt.Errorf("cenv is archived")
+	}
+	if !m.Exists(cenvID) {
+		return fmt.Errorf("cenv does not exist")
+	}
+	return nil
+}
+
+// openCenv checks a cenv id and opens a connection to its database
+func openCenv(m *cenv.Manager, cenvID string) (*sql.DB, error) {
+	if err := checkCenv(m, cenvID); err != nil {
+		return nil, err
+	}
+	return m.Open(cenvID)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+const testCenvID = "123e4567-e89b-12d3-a456-426614174000"
+
+func setupManager(t *testing.T) *cenv.Manager {
+	t.Helper()
+
+	manager := cenv.NewManager(t.TempDir())
+	if err := manager.Create(testCenvID); err != nil {
+		t.Fatalf("Failed to create cenv: %v", err)
+	}
+	db, err := manager.Open(testCenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	defer db.Close()
+	if _, err := auth.CreateUser(db, "admin", "adminpass123", auth.RoleOwner, "", ""); err != nil {
+		t.Fatalf("Failed to create owner: %v", err)
+	}
+	return manager
+}
+
+func runCommand(t *testing.T, m *cenv.Manager, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := commands[args[0]](m, args[1:], &out)
+	return out.String(), err
+}
+
+func TestMaintenanceCommands(t *testing.T) {
+	manager := setupManager(t)
+
+	out, err := runCommand(t, manager, "list")
+	if err != nil || !strings.Contains(out, testCenvID) || !strings.Contains(out, "active") {
+		t.Errorf("Unexpected list: %q (%v)", out, err)
+	}
+
+	for _, name := range []string{"migrate", "vacuum", "check"} {
+		if out, err := runCommand(t, manager, name); err != nil || !strings.HasPrefix(out, testCenvID+": ") {
+			t.Errorf("%s: unexpected output %q (%v)", name, out, err)
+		}
+	}
+
+	out, err = runCommand(t, manager, "check", testCenvID, "not-a-cenv")
+	if err == nil || !strings.Contains(out, "not-a-cenv: invalid cenv id") {
+		t.Errorf("Expected an invalid id to fail the check, got %q (%v)", out, err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	manager := setupManager(t)
+
+	out, err := runCommand(t, manager, "reset-password", testCenvID)
+	if err != nil {
+		t.Fatalf("reset-password failed: %v", err)
+	}
+	_, password, ok := strings.Cut(strings.TrimSpace(out), "New password: ")
+	if !ok {
+		t.Fatalf("Expected a generated password, got %q", out)
+	}
+
+	db, _ := manager.Open(testCenvID)
+	defer db.Close()
+	user, _ := auth.GetUserByUsername(db, "admin")
+	if auth.VerifyPassword(password, user.PasswordHash) != nil {
+		t.Error("Expected the generated password to be set")
+	}
+
+	if _, err := runCommand(t, manager, "reset-password", "-user", "admin", "-password", "newpass123", testCenvID); err != nil {
+		t.Fatalf("reset-password failed: %v", err)
+	}
+	user, _ = auth.GetUserByUsername(db, "admin")
+	if auth.VerifyPassword("newpass123", user.PasswordHash) != nil {
+		t.Error("Expected the given password to be set")
+	}
+
+	for _, args := range [][]string{
+		{"-password", "short", testCenvID},
+		{"-user", "nobody", testCenvID},
+		{"223e4567-e89b-12d3-a456-426614174000"},
+		{},
+	} {
+		if _, err := runCommand(t, manager, append([]string{"reset-password"}, args...)...); err == nil {
+			t.Errorf("Expected %v to fail", args)
+		}
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	manager := setupManager(t)
+
+	db, _ := manager.Open(testCenvID)
+	defer db.Close()
+	db.Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)`)
+	if err := tables.EnableSoftDelete(db, "notes", ""); err != nil {
+		t.Fatalf("EnableSoftDelete failed: %v", err)
+	}
+	db.Exec(`INSERT INTO notes (body, deleted_at) VALUES ('old', 1), ('live', NULL)`)
+
+	out, err := runCommand(t, manager, "purge-trash", testCenvID)
+	if err != nil || !strings.Contains(out, "purged 1 rows from 1 tables") {
+		t.Errorf("Unexpected purge: %q (%v)", out, err)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected the live row to remain, got %d rows", count)
+	}
+
+	if _, err := runCommand(t, manager, "purge-trash"); err == nil {
+		t.Error("Expected purge-trash without cenvs to fail")
+	}
+}
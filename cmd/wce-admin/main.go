@@ -0,0 +1,81 @@
+// Command wce-admin maintains cenv databases directly in a storage directory,
+// for recovery when the HTTP plane is down or locked out. It reads the same
+// storage.json registry as the server, so it finds cenvs on every volume and
+// in libSQL, and works on databases the server may also have open.
+//
+// Usage:
+//
+//	wce-admin -storage /var/lib/wce list
+//	wce-admin -storage /var/lib/wce migrate [cenvID...]
+//	wce-admin -storage /var/lib/wce vacuum [cenvID...]
+//	wce-admin -storage /var/lib/wce check [cenvID...]
+//	wce-admin -storage /var/lib/wce reset-password [-user name] [-password pw] cenvID
+//	wce-admin -storage /var/lib/wce purge-trash [-older-than 720h] cenvID...
+//	wce-admin -storage /var/lib/wce backup -out /var/backups/wce [cenvID...]
+//	wce-admin verify-backup -backups /var/backups/wce [-max-age 168h]
+//
+// Commands that take cenv IDs run on every cenv when none are given, except
+// reset-password and purge-trash, which change data and need them named.
+// verify-backup works on backup files alone and needs no storage directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func main() {
+	storageDir := flag.String("storage", "", "Storage directory of the WCE server")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	var manager *cenv.Manager
+	if flag.Arg(0) != "verify-backup" {
+		if *storageDir == "" {
+			usage()
+			os.Exit(2)
+		}
+		manager = cenv.NewManager(*storageDir)
+		if err := manager.LoadRegistry(); err != nil {
+			log.Fatalf("Failed to load registry: %v", err)
+		}
+		defer manager.CloseAll()
+	}
+
+	if err := run(manager, flag.Args()[1:], os.Stdout); err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: wce-admin -storage dir <command> [arguments]
+
+Commands:
+  list                       List cenvs with their size and state
+  migrate [cenvID...]        Create system tables and settings missing from older cenvs
+  vacuum [cenvID...]         Return space freed by deletes to the filesystem
+  check [cenvID...]          Run SQLite's integrity and foreign key checks
+  reset-password cenvID      Set a new password for the owner, or -user, and revoke their sessions
+  purge-trash cenvID...      Permanently remove soft deleted rows
+  backup -out dir [cenvID...]  Write gzip-compressed snapshots of cenvs
+  verify-backup              Restore a random recent backup into a temporary cenv and smoke test it
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
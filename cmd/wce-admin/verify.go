@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/template"
+)
+
+// smokeTemplate is the page rendered to check a restored cenv, the one served
+// at the root of its pages
+const smokeTemplate = "templates/pages/index.html"
+
+// backup is a database snapshot file found in the backup directory
+type backup struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+// backupCenvs writes a gzip-compressed snapshot of the named cenvs, or all,
+// to -out as {cenvID}-{UTC time}.db.gz
+func backupCenvs(m *cenv.Manager, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("out", "", "Directory to write backups to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("backup needs -out")
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	return forEachCenv(m, flags.Args(), out, func(cenvID string) error {
+		backupPath := filepath.Join(*dir, cenvID+"-"+stamp+".db.gz")
+		file, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create backup: %w", err)
+		}
+		compressed := gzip.NewWriter(file)
+		err = m.Snapshot(cenvID, compressed)
+		if closeErr := compressed.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(backupPath)
+			return err
+		}
+		fmt.Fprintf(out, "%s: backed up to %s\n", cenvID, backupPath)
+		return nil
+	})
+}
+
+// verifyBackup restores a random recent backup, or the one named with
+// -file, into a temporary cenv and checks that it is usable: SQLite's
+// integrity checks pass, its users and documents can be read, and its index
+// page renders. Backups are SQLite snapshots (*.db), optionally
+// gzip-compressed (*.db.gz) as the backup command writes them. The temporary
+// cenv is removed afterwards; the storage directory is not touched.
+func verifyBackup(m *cenv.Manager, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("verify-backup", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("backups", "", "Directory holding backup snapshots")
+	file := flags.String("file", "", "Backup to verify instead of a random recent one")
+	maxAge := flags.Duration("max-age", 7*24*time.Hour, "Only pick backups modified within this long")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || (*dir == "") == (*file == "") {
+		return fmt.Errorf("verify-backup takes either -backups or -file")
+	}
+
+	var chosen backup
+	if *file != "" {
+		info, err := os.Stat(*file)
+		if err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		chosen = backup{Path: *file, ModTime: info.ModTime(), Size: info.Size()}
+	} else {
+		recent, err := recentBackups(*dir, time.Now().Add(-*maxAge))
+		if err != nil {
+			return err
+		}
+		if len(recent) == 0 {
+			return fmt.Errorf("no backups in %s modified within %s", *dir, *maxAge)
+		}
+		chosen = recent[rand.Intn(len(recent))]
+	}
+	fmt.Fprintf(out, "backup: %s (%d bytes, modified %s)\n", chosen.Path, chosen.Size, chosen.ModTime.UTC().Format(time.RFC3339))
+
+	tmpDir, err := os.MkdirTemp("", "wce-restore-test-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cenvID, err := auth.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	scratch := cenv.NewManager(tmpDir)
+	defer scratch.CloseAll()
+	if err := restoreBackup(chosen.Path, scratch.GetDatabasePath(cenvID)); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "restored into temporary cenv %s\n", cenvID)
+
+	if err := smokeTest(scratch, cenvID, out); err != nil {
+		fmt.Fprintln(out, "backup is NOT restorable")
+		return err
+	}
+	fmt.Fprintln(out, "backup is restorable")
+	return nil
+}
+
+// recentBackups lists the backups in dir modified after since
+func recentBackups(dir string, since time.Time) ([]backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".db") || strings.HasSuffix(name, ".db.gz")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		backups = append(backups, backup{Path: filepath.Join(dir, name), ModTime: info.ModTime(), Size: info.Size()})
+	}
+	return backups, nil
+}
+
+// restoreBackup writes a backup to dbPath, decompressing gzip backups
+func restoreBackup(backupPath, dbPath string) error {
+	in, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer in.Close()
+
+	var content io.Reader = in
+	if strings.HasSuffix(backupPath, ".gz") {
+		compressed, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to decompress backup: %w", err)
+		}
+		defer compressed.Close()
+		content = compressed
+	}
+
+	out, err := os.OpenFile(dbPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	if _, err := io.Copy(out, content); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	return out.Close()
+}
+
+// smokeTest checks a restored cenv's integrity, reads its users and
+// documents and renders its index page when it has one
+func smokeTest(m *cenv.Manager, cenvID string, out io.Writer) error {
+	problems, err := m.CheckIntegrity(cenvID)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(out, "integrity: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d integrity problems found", len(problems))
+	}
+	fmt.Fprintln(out, "integrity: ok")
+
+	db, err := m.Open(cenvID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	users, err := auth.ListUsers(db)
+	if err != nil {
+		return err
+	}
+	var documents int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM _wce_documents`).Scan(&documents); err != nil {
+		return fmt.Errorf("failed to count documents: %w", err)
+	}
+	// Reading the latest documents decodes their content, compressed or not
+	if _, err := document.ListRecentDocuments(db, "", true, 10); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "data: %d users, %d documents\n", len(users), documents)
+
+	if _, err := document.GetDocument(db, smokeTemplate); err != nil {
+		fmt.Fprintf(out, "render: skipped, no %s\n", smokeTemplate)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rendered, err := template.RenderDocument(ctx, db, smokeTemplate, map[string]interface{}{
+		"request":  map[string]interface{}{"path": "/" + cenvID + "/pages/", "method": "GET", "query": map[string]interface{}{}},
+		"identity": (*auth.Identity)(nil).Map(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render %s: %w", smokeTemplate, err)
+	}
+	fmt.Fprintf(out, "render: %s ok, %d bytes of %s\n", smokeTemplate, len(rendered.Content), rendered.ContentType)
+	return nil
+}
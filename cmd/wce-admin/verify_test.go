@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	manager := setupManager(t)
+	backups := t.TempDir()
+
+	db, _ := manager.Open(testCenvID)
+	owner, _ := auth.GetUserByUsername(db, "admin")
+	if _, err := document.CreateDocument(db, smokeTemplate, "<h1>{{ 1 + 1 }}</h1>", "text/html", owner.UserID, false, false); err != nil {
+		t.Fatalf("Failed to create page: %v", err)
+	}
+	db.Close()
+
+	out, err := runCommand(t, manager, "backup", "-out", backups)
+	if err != nil || !strings.Contains(out, "backed up to") {
+		t.Fatalf("backup failed: %q (%v)", out, err)
+	}
+
+	out, err = runCommand(t, nil, "verify-backup", "-backups", backups)
+	if err != nil {
+		t.Fatalf("verify-backup failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{"integrity: ok", "data: 1 users, 1 documents", "render: " + smokeTemplate + " ok", "backup is restorable"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+
+	// Backups older than -max-age are not picked
+	matches, _ := filepath.Glob(filepath.Join(backups, "*.db.gz"))
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	os.Chtimes(matches[0], old, old)
+	if _, err := runCommand(t, nil, "verify-backup", "-backups", backups); err == nil {
+		t.Error("Expected no recent backups to fail")
+	}
+
+	// A corrupt backup is reported
+	corrupt := filepath.Join(backups, "corrupt.db")
+	os.WriteFile(corrupt, []byte("not a database"), 0600)
+	out, err = runCommand(t, nil, "verify-backup", "-file", corrupt)
+	if err == nil || strings.Contains(out, "backup is restorable") {
+		t.Errorf("Expected a corrupt backup to fail, got %q", out)
+	}
+}
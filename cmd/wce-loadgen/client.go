@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// client sends requests to a WCE server
+type client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func newClient(baseURL string) *client {
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			// Keep a connection per worker instead of reconnecting
+			Transport: &http.Transport{MaxIdleConnsPerHost: 256},
+		},
+	}
+}
+
+// request describes one API call
+type request struct {
+	method string
+	path   string
+	token  string
+	body   interface{} // Encoded as JSON when set
+	signed bool        // Sign as a high-impact admin request
+}
+
+// do sends req and returns the response status and body. The body is read in
+// full so the connection can be reused.
+func (c *client) do(ctx context.Context, req request) (int, []byte, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return 0, nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if req.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.token)
+	}
+	if req.signed {
+		if err := sign(httpReq, req.token, body); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// call sends req and decodes a JSON response into out, failing unless the
+// server answers with the expected status
+func (c *client) call(ctx context.Context, req request, expected int, out interface{}) error {
+	status, body, err := c.do(ctx, req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", req.method, req.path, err)
+	}
+	if status != expected {
+		return fmt.Errorf("%s %s: expected %d, got %d: %s", req.method, req.path, expected, status, bytes.TrimSpace(body))
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("%s %s: invalid response: %w", req.method, req.path, err)
+		}
+	}
+	return nil
+}
+
+// sign adds the signed admin request headers the server requires for
+// mutations such as deploying endpoints:
+//
+//	HMAC-SHA256(token, METHOD "\n" REQUEST-URI "\n" TIMESTAMP "\n" NONCE "\n" hex(SHA-256(body)))
+func sign(req *http.Request, token string, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + nonceHex + "\n" + hex.EncodeToString(bodyHash[:])))
+
+	req.Header.Set("X-WCE-Timestamp", timestamp)
+	req.Header.Set("X-WCE-Nonce", nonceHex)
+	req.Header.Set("X-WCE-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
@@ -0,0 +1,72 @@
+// Command wce-loadgen drives realistic multi-tenant load against a running
+// WCE server. It provisions a number of cenvs, seeds each with documents, a
+// page template and a Starlark endpoint, then runs a mix of document reads,
+// document writes, page renders and endpoint calls from concurrent workers
+// and reports throughput and latency percentiles per operation.
+//
+// Usage:
+//
+//	wce-loadgen -url http://localhost:5309 -cenvs 10 -docs 100 -concurrency 32 -duration 1m
+//	wce-loadgen -mix read=50,write=30,render=10,star=10 -json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	var opts options
+	var mix string
+	flag.StringVar(&opts.baseURL, "url", "http://localhost:5309", "Base URL of the WCE server")
+	flag.IntVar(&opts.cenvs, "cenvs", 5, "Number of cenvs to provision")
+	flag.IntVar(&opts.docs, "docs", 50, "Documents seeded per cenv")
+	flag.IntVar(&opts.docSize, "doc-size", 1024, "Size of seeded and written documents in bytes")
+	flag.IntVar(&opts.concurrency, "concurrency", 16, "Concurrent workers")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "How long to drive traffic")
+	flag.Int64Var(&opts.seed, "seed", time.Now().UnixNano(), "Random seed, for repeatable runs")
+	flag.StringVar(&mix, "mix", "read=60,write=20,render=10,star=10", "Relative weights of the operations")
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON")
+	flag.Parse()
+
+	var err error
+	if opts.mix, err = parseMix(mix); err != nil {
+		log.Fatalf("Invalid -mix: %v", err)
+	}
+	if opts.cenvs < 1 || opts.docs < 1 || opts.docSize < 1 || opts.concurrency < 1 {
+		log.Fatal("-cenvs, -docs, -doc-size and -concurrency must be positive")
+	}
+
+	// Stop early on Ctrl-C and still report what was measured
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := newClient(opts.baseURL)
+
+	log.Printf("Provisioning %d cenvs with %d documents each", opts.cenvs, opts.docs)
+	start := time.Now()
+	tenants, err := provision(ctx, client, opts)
+	if err != nil {
+		log.Fatalf("Provisioning failed: %v", err)
+	}
+	log.Printf("Provisioned in %s", time.Since(start).Round(time.Millisecond))
+
+	log.Printf("Driving %d workers for %s", opts.concurrency, opts.duration)
+	report := run(ctx, client, tenants, opts)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		return
+	}
+	fmt.Print(report.String())
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations in the traffic mix
+const (
+	opRead   = "read"   // GET a seeded document
+	opWrite  = "write"  // PUT new content into a seeded document
+	opRender = "render" // GET the seeded page template
+	opStar   = "star"   // Call the seeded Starlark endpoint
+)
+
+// Seeded fixtures, the same in every cenv
+const (
+	docPrefix    = "loadgen/docs/"
+	pageTemplate = "templates/pages/loadgen.html"
+	pagePartial  = "templates/partials/loadgen.html"
+	starPath     = "/loadgen/recent"
+	loadPassword = "loadgen-password"
+)
+
+const pageSource = `<html><head><title>{{ request.path }}</title></head><body>
+{% if identity.authenticated %}<p>Signed in as {{ identity.username }}</p>{% endif %}
+{% if request.query.tab %}<p>Tab {{ request.query.tab|upper }}</p>{% endif %}
+{% include "` + pagePartial + `" %}
+</body></html>`
+
+const partialSource = `<footer>Rendered for {{ request.method|lower }} {{ request.path|default("/") }}</footer>`
+
+const starScript = `def handle_request(req):
+    rows = db.query("SELECT id, version FROM _wce_documents WHERE id LIKE 'loadgen/docs/%' ORDER BY modified_at DESC LIMIT 10", [])
+    return response({"recent": rows, "count": len(rows)})
+`
+
+// options configure a load test run
+type options struct {
+	baseURL     string
+	cenvs       int
+	docs        int
+	docSize     int
+	concurrency int
+	duration    time.Duration
+	seed        int64
+	mix         []weightedOp
+}
+
+// weightedOp is an operation and its relative share of the traffic
+type weightedOp struct {
+	name   string
+	weight int
+}
+
+// parseMix parses operation weights such as "read=60,write=20,render=10,star=10".
+// Operations left out are not run.
+func parseMix(spec string) ([]weightedOp, error) {
+	known := map[string]bool{opRead: true, opWrite: true, opRender: true, opStar: true}
+	seen := map[string]bool{}
+	var mix []weightedOp
+	total := 0
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected op=weight, got %q", part)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown operation %q (want read, write, render or star)", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("operation %q given twice", name)
+		}
+		seen[name] = true
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight of %s must be a non-negative integer", name)
+		}
+		if weight > 0 {
+			mix = append(mix, weightedOp{name, weight})
+			total += weight
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one operation needs a positive weight")
+	}
+	return mix, nil
+}
+
+// pick chooses an operation with probability proportional to its weight
+func pick(mix []weightedOp, rng *rand.Rand) string {
+	total := 0
+	for _, op := range mix {
+		total += op.weight
+	}
+	n := rng.Intn(total)
+	for _, op := range mix {
+		if n < op.weight {
+			return op.name
+		}
+		n -= op.weight
+	}
+	return mix[len(mix)-1].name
+}
+
+// tenant is a provisioned cenv and the owner token used to drive it
+type tenant struct {
+	cenvID string
+	token  string
+}
+
+// provision creates the cenvs and seeds their fixtures, several at a time
+func provision(ctx context.Context, c *client, opts options) ([]tenant, error) {
+	tenants := make([]tenant, opts.cenvs)
+	errs := make([]error, opts.cenvs)
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, min(opts.concurrency, 8))
+	for i := range tenants {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			tenants[i], errs[i] = provisionTenant(ctx, c, opts, i)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("cenv %d: %w", i, err)
+		}
+	}
+	return tenants, nil
+}
+
+// provisionTenant creates one cenv, logs in as its owner and seeds it
+func provisionTenant(ctx context.Context, c *client, opts options, n int) (tenant, error) {
+	username := fmt.Sprintf("loadgen%d", n)
+
+	var created struct {
+		CenvID string `json:"cenv_id"`
+	}
+	err := c.call(ctx, request{method: http.MethodPost, path: "/new", body: map[string]string{
+		"username": username, "password": loadPassword,
+	}}, http.StatusCreated, &created)
+	if err != nil {
+		return tenant{}, err
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	err = c.call(ctx, request{method: http.MethodPost, path: "/" + created.CenvID + "/login", body: map[string]string{
+		"username": username, "password": loadPassword,
+	}}, http.StatusOK, &login)
+	if err != nil {
+		return tenant{}, err
+	}
+	t := tenant{cenvID: created.CenvID, token: login.Token}
+
+	rng := rand.New(rand.NewSource(opts.seed + int64(n)))
+	for i := 0; i < opts.docs; i++ {
+		err := t.createDocument(ctx, c, docPrefix+strconv.Itoa(i), content(rng, opts.docSize), "text/plain")
+		if err != nil {
+			return tenant{}, err
+		}
+	}
+	if err := t.createDocument(ctx, c, pagePartial, partialSource, "text/html"); err != nil {
+		return tenant{}, err
+	}
+	if err := t.createDocument(ctx, c, pageTemplate, pageSource, "text/html"); err != nil {
+		return tenant{}, err
+	}
+
+	err = c.call(ctx, request{method: http.MethodPost, path: "/" + t.cenvID + "/admin/endpoints", token: t.token, signed: true,
+		body: map[string]string{"path": starPath, "method": http.MethodGet, "script": starScript},
+	}, http.StatusCreated, nil)
+	if err != nil {
+		return tenant{}, err
+	}
+
+	return t, nil
+}
+
+func (t tenant) createDocument(ctx context.Context, c *client, id, content, contentType string) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/" + t.cenvID + "/documents", token: t.token,
+		body: map[string]string{"id": id, "content": content, "content_type": contentType},
+	}, http.StatusCreated, nil)
+}
+
+// content generates size bytes of lowercase words
+func content(rng *rand.Rand, size int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	var sb strings.Builder
+	sb.Grow(size)
+	for sb.Len() < size {
+		if sb.Len() > 0 && rng.Intn(6) == 0 {
+			sb.WriteByte(' ')
+			continue
+		}
+		sb.WriteByte(letters[rng.Intn(len(letters))])
+	}
+	return sb.String()
+}
+
+// run drives the traffic mix against the tenants until the duration passes
+// or ctx is cancelled
+func run(ctx context.Context, c *client, tenants []tenant, opts options) *Report {
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	recorders := make([]*recorder, opts.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range recorders {
+		recorders[w] = newRecorder()
+		wg.Add(1)
+		go func(rec *recorder, rng *rand.Rand) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				op := pick(opts.mix, rng)
+				t := tenants[rng.Intn(len(tenants))]
+				req, expected := t.operation(op, rng, opts)
+
+				began := time.Now()
+				status, _, err := c.do(ctx, req)
+				elapsed := time.Since(began)
+				if ctx.Err() != nil {
+					return // Interrupted by the deadline, not a failure
+				}
+				rec.record(op, elapsed, status, expected, err)
+			}
+		}(recorders[w], rand.New(rand.NewSource(opts.seed+int64(opts.cenvs+w))))
+	}
+	wg.Wait()
+
+	return buildReport(recorders, time.Since(start), opts)
+}
+
+// operation builds the request for op against the tenant and the status it
+// should succeed with
+func (t tenant) operation(op string, rng *rand.Rand, opts options) (request, int) {
+	docPath := "/" + t.cenvID + "/documents/" + docPrefix + strconv.Itoa(rng.Intn(opts.docs))
+	switch op {
+	case opWrite:
+		return request{method: http.MethodPut, path: docPath, token: t.token,
+			body: map[string]string{"content": content(rng, opts.docSize)}}, http.StatusOK
+	case opRender:
+		return request{method: http.MethodGet, path: "/" + t.cenvID + "/pages/loadgen?tab=" + strconv.Itoa(rng.Intn(5)),
+			token: t.token}, http.StatusOK
+	case opStar:
+		return request{method: http.MethodGet, path: "/" + t.cenvID + "/star" + starPath, token: t.token}, http.StatusOK
+	default:
+		return request{method: http.MethodGet, path: docPath, token: t.token}, http.StatusOK
+	}
+}
+
+// opNames lists the operations of a mix in a stable order
+func opNames(mix []weightedOp) []string {
+	names := make([]string, 0, len(mix))
+	for _, op := range mix {
+		names = append(names, op.name)
+	}
+	sort.Strings(names)
+	return names
+}
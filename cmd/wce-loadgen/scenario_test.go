@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("read=3, write=1,render=0")
+	if err != nil {
+		t.Fatalf("parseMix failed: %v", err)
+	}
+	if len(mix) != 2 || mix[0] != (weightedOp{opRead, 3}) || mix[1] != (weightedOp{opWrite, 1}) {
+		t.Errorf("Unexpected mix: %+v", mix)
+	}
+
+	for _, spec := range []string{"", "read", "read=x", "read=-1", "delete=1", "read=1,read=2", "read=0"} {
+		if _, err := parseMix(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	mix, _ := parseMix("read=3,write=1")
+	rng := rand.New(rand.NewSource(1))
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		counts[pick(mix, rng)]++
+	}
+	if counts[opRead] < 2800 || counts[opRead] > 3200 || counts[opRead]+counts[opWrite] != 4000 {
+		t.Errorf("Expected picks in proportion to the weights, got %v", counts)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// recorder collects one worker's results, so workers never contend on a lock
+type recorder struct {
+	latencies map[string][]time.Duration
+	failures  map[string]map[string]int // Operation to failure kind to count
+}
+
+func newRecorder() *recorder {
+	return &recorder{latencies: map[string][]time.Duration{}, failures: map[string]map[string]int{}}
+}
+
+// record notes the outcome of one operation. Failures are counted by status
+// code, or as "transport" when no response arrived; only successful calls
+// contribute latencies.
+func (r *recorder) record(op string, elapsed time.Duration, status, expected int, err error) {
+	kind := ""
+	switch {
+	case err != nil:
+		kind = "transport"
+	case status != expected:
+		kind = strconv.Itoa(status)
+	}
+	if kind == "" {
+		r.latencies[op] = append(r.latencies[op], elapsed)
+		return
+	}
+	if r.failures[op] == nil {
+		r.failures[op] = map[string]int{}
+	}
+	r.failures[op][kind]++
+}
+
+// OpStats summarizes one operation of a run. Latencies are in milliseconds
+// and cover successful calls.
+type OpStats struct {
+	Op        string         `json:"op"`
+	Requests  int            `json:"requests"`
+	Failures  int            `json:"failures"`
+	FailedBy  map[string]int `json:"failed_by,omitempty"` // Status code or "transport" to count
+	PerSecond float64        `json:"per_second"`
+	P50       float64        `json:"p50_ms"`
+	P90       float64        `json:"p90_ms"`
+	P99       float64        `json:"p99_ms"`
+	Max       float64        `json:"max_ms"`
+}
+
+// Report is the outcome of a load test run
+type Report struct {
+	Cenvs       int       `json:"cenvs"`
+	Concurrency int       `json:"concurrency"`
+	Seconds     float64   `json:"seconds"`
+	Ops         []OpStats `json:"ops"`
+	Total       OpStats   `json:"total"`
+}
+
+// buildReport merges the workers' recorders into per-operation statistics
+func buildReport(recorders []*recorder, elapsed time.Duration, opts options) *Report {
+	report := &Report{Cenvs: opts.cenvs, Concurrency: opts.concurrency, Seconds: elapsed.Seconds()}
+
+	var all []time.Duration
+	allFailures := map[string]int{}
+	for _, op := range opNames(opts.mix) {
+		var latencies []time.Duration
+		failures := map[string]int{}
+		for _, rec := range recorders {
+			latencies = append(latencies, rec.latencies[op]...)
+			for kind, n := range rec.failures[op] {
+				failures[kind] += n
+				allFailures[kind] += n
+			}
+		}
+		all = append(all, latencies...)
+		report.Ops = append(report.Ops, summarize(op, latencies, failures, elapsed))
+	}
+	report.Total = summarize("total", all, allFailures, elapsed)
+
+	return report
+}
+
+// summarize computes an operation's throughput and latency percentiles
+func summarize(op string, latencies []time.Duration, failures map[string]int, elapsed time.Duration) OpStats {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats := OpStats{Op: op}
+	for _, n := range failures {
+		stats.Failures += n
+	}
+	if stats.Failures > 0 {
+		stats.FailedBy = failures
+	}
+	stats.Requests = len(latencies) + stats.Failures
+	if elapsed > 0 {
+		stats.PerSecond = float64(stats.Requests) / elapsed.Seconds()
+	}
+	stats.P50 = millis(percentile(latencies, 50))
+	stats.P90 = millis(percentile(latencies, 90))
+	stats.P99 = millis(percentile(latencies, 99))
+	if len(latencies) > 0 {
+		stats.Max = millis(latencies[len(latencies)-1])
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies, 0
+// when there are none
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	return sorted[max(rank, 1)-1]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// String renders the report as a table
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d cenvs, %d workers, %.1fs\n\n", r.Cenvs, r.Concurrency, r.Seconds)
+
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\tfailed\treq/s\tp50 ms\tp90 ms\tp99 ms\tmax ms\t")
+	for _, stats := range append(r.Ops, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			stats.Op, stats.Requests, stats.Failures, stats.PerSecond, stats.P50, stats.P90, stats.P99, stats.Max)
+	}
+	tw.Flush()
+
+	for _, stats := range r.Ops {
+		if len(stats.FailedBy) == 0 {
+			continue
+		}
+		kinds := make([]string, 0, len(stats.FailedBy))
+		for kind, n := range stats.FailedBy {
+			kinds = append(kinds, fmt.Sprintf("%s x%d", kind, n))
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(&sb, "\n%s failures: %s", stats.Op, strings.Join(kinds, ", "))
+	}
+	if r.Total.Failures > 0 {
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
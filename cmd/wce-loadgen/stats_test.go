@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	cases := map[int]time.Duration{50: 50 * time.Millisecond, 90: 90 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond}
+	for p, want := range cases {
+		if got := percentile(latencies, p); got != want {
+			t.Errorf("p%d: expected %s, got %s", p, want, got)
+		}
+	}
+
+	if got := percentile(latencies[:1], 50); got != time.Millisecond {
+		t.Errorf("Expected the only latency, got %s", got)
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Errorf("Expected 0 without latencies, got %s", got)
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	a, b := newRecorder(), newRecorder()
+	a.record(opRead, 10*time.Millisecond, 200, 200, nil)
+	a.record(opRead, 30*time.Millisecond, 200, 200, nil)
+	b.record(opRead, 20*time.Millisecond, 200, 200, nil)
+	b.record(opWrite, 5*time.Millisecond, 409, 200, nil)
+	b.record(opWrite, time.Second, 0, 200, errors.New("connection reset"))
+
+	mix, _ := parseMix("read=1,write=1")
+	report := buildReport([]*recorder{a, b}, 2*time.Second, options{cenvs: 1, concurrency: 2, mix: mix})
+
+	read := report.Ops[0]
+	if read.Op != opRead || read.Requests != 3 || read.Failures != 0 || read.P50 != 20 || read.Max != 30 || read.PerSecond != 1.5 {
+		t.Errorf("Unexpected read stats: %+v", read)
+	}
+	write := report.Ops[1]
+	if write.Requests != 2 || write.Failures != 2 || write.FailedBy["409"] != 1 || write.FailedBy["transport"] != 1 || write.Max != 0 {
+		t.Errorf("Unexpected write stats: %+v", write)
+	}
+	if report.Total.Requests != 5 || report.Total.Failures != 2 {
+		t.Errorf("Unexpected total: %+v", report.Total)
+	}
+
+	if out := report.String(); !strings.Contains(out, "write failures: 409 x1, transport x1") {
+		t.Errorf("Expected failures in the table, got:\n%s", out)
+	}
+}
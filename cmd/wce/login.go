@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/keychain"
+	"github.com/thetanil/wce/internal/server"
+)
+
+// defaultPollInterval is how often the token endpoint is polled when the
+// server names no interval (RFC 8628 section 3.2)
+const defaultPollInterval = 5 * time.Second
+
+// slowDownStep is added to the poll interval on each slow_down response
+const slowDownStep = 5 * time.Second
+
+// oauthErrorResponse is the body of a failed device flow request
+type oauthErrorResponse struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// runLogin signs in to a cenv with the device flow and stores the token in
+// the OS keychain, under the server URL and cenv ID
+func runLogin(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	flags.SetOutput(out)
+	serverURL := flags.String("server", envOr("WCE_URL", "http://localhost:"+strconv.Itoa(DefaultPort)),
+		"URL of the WCE server ($WCE_URL)")
+	scope := flags.String("scope", "", "Space-separated scopes to limit the token to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("login takes one cenv id")
+	}
+	cenvID := flags.Arg(0)
+	if !cenv.IsValidUUID(cenvID) {
+		return fmt.Errorf("invalid cenv id %q", cenvID)
+	}
+	base := strings.TrimRight(*serverURL, "/")
+	if err := keychain.Available(); err != nil {
+		return err
+	}
+
+	token, err := deviceLogin(context.Background(), http.DefaultClient, base, cenvID, *scope, out)
+	if err != nil {
+		return err
+	}
+	account := base + "/" + cenvID
+	if err := keychain.Store(account, token.AccessToken); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	fmt.Fprintf(out, "Logged in. The token is stored in the keychain as %s %s and expires in %s.\n",
+		keychain.Service, account, time.Duration(token.ExpiresIn)*time.Second)
+	return nil
+}
+
+// deviceLogin starts a device authorization, shows the user where to
+// approve it and polls until they do. Denied and expired codes are errors.
+func deviceLogin(ctx context.Context, client *http.Client, base, cenvID, scope string, out io.Writer) (*server.TokenResponse, error) {
+	var code server.DeviceCodeResponse
+	if err := postJSON(ctx, client, base+"/"+cenvID+"/device/code", server.DeviceCodeRequest{Scope: scope}, &code); err != nil {
+		return nil, fmt.Errorf("failed to start device login: %w", err)
+	}
+
+	fmt.Fprintf(out, "Open %s in a browser and enter the code %s\n", code.VerificationURI, code.UserCode)
+	fmt.Fprintf(out, "or open %s\n", code.VerificationURIComplete)
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var token server.TokenResponse
+		err := postJSON(ctx, client, base+"/"+cenvID+"/device/token", server.DeviceTokenRequest{DeviceCode: code.DeviceCode}, &token)
+		if err == nil {
+			return &token, nil
+		}
+		var oauthErr *oauthErrorResponse
+		if !errors.As(err, &oauthErr) {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+		switch oauthErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownStep
+		case "access_denied":
+			return nil, fmt.Errorf("login was denied")
+		case "expired_token":
+			return nil, fmt.Errorf("the code expired before it was approved")
+		default:
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+		if code.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("the code expired before it was approved")
+		}
+	}
+}
+
+// postJSON posts body as JSON and decodes a 200 response into result. Other
+// responses are returned as an *oauthErrorResponse.
+func postJSON(ctx context.Context, client *http.Client, url string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &oauthErrorResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(oauthErr); err != nil || oauthErr.Code == "" {
+			return fmt.Errorf("server returned %s", resp.Status)
+		}
+		return oauthErr
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Error implements error, so failed requests can carry the OAuth error code
+func (e *oauthErrorResponse) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
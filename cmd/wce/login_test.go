@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/server"
+)
+
+const testCenvID = "123e4567-e89b-12d3-a456-426614174000"
+
+// deviceServer answers a device authorization with each of results in turn,
+// an OAuth error code or "" for the token
+func deviceServer(t *testing.T, results ...string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{cenvID}/device/code", func(w http.ResponseWriter, r *http.Request) {
+		var req server.DeviceCodeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Scope != "documents:read" {
+			t.Errorf("Expected the requested scope, got %q", req.Scope)
+		}
+		json.NewEncoder(w).Encode(server.DeviceCodeResponse{
+			DeviceCode:      "device-code",
+			UserCode:        "ABCD-EFGH",
+			VerificationURI: "http://wce.test/" + r.PathValue("cenvID") + "/device",
+			ExpiresIn:       600,
+			Interval:        1,
+		})
+	})
+	mux.HandleFunc("POST /{cenvID}/device/token", func(w http.ResponseWriter, r *http.Request) {
+		var req server.DeviceTokenRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.DeviceCode != "device-code" {
+			t.Errorf("Expected the device code, got %q", req.DeviceCode)
+		}
+		if len(results) == 0 {
+			t.Errorf("Polled after the flow ended")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		result := results[0]
+		results = results[1:]
+		if result != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": result})
+			return
+		}
+		json.NewEncoder(w).Encode(server.TokenResponse{AccessToken: "token", TokenType: "Bearer", ExpiresIn: 3600})
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestDeviceLogin(t *testing.T) {
+	t.Run("Approved", func(t *testing.T) {
+		ts := deviceServer(t, "authorization_pending", "")
+		var out bytes.Buffer
+		token, err := deviceLogin(context.Background(), ts.Client(), ts.URL, testCenvID, "documents:read", &out)
+		if err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		if token.AccessToken != "token" {
+			t.Errorf("Expected the issued token, got %q", token.AccessToken)
+		}
+		if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), "/"+testCenvID+"/device") {
+			t.Errorf("Expected the code and verification URI in the output, got %q", out.String())
+		}
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		ts := deviceServer(t, "access_denied")
+		_, err := deviceLogin(context.Background(), ts.Client(), ts.URL, testCenvID, "documents:read", &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "denied") {
+			t.Errorf("Expected a denied login, got %v", err)
+		}
+	})
+
+	t.Run("UnknownCenv", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+		if _, err := deviceLogin(context.Background(), ts.Client(), ts.URL, testCenvID, "", &bytes.Buffer{}); err == nil {
+			t.Errorf("Expected an error from a server without the cenv")
+		}
+	})
+}
@@ -0,0 +1,339 @@
+// Command wce runs the WCE server, or signs in to one.
+//
+// Usage:
+//
+//	wce login [-server url] [-scope scopes] cenvID
+//	wce [-storage dir] [-port 5309] [-reuse-port] [-read-only] [-sentry-dsn dsn]
+//	    [-smtp url -mail-from address [-public-url url] [-verify-email]]
+//	    [-jwt-secret secret[,previous...]] [-egress-allow prefix[,prefix...]]
+//	    [-operator-token token] [-clamd-addr address | -scan-url url]
+//	    [-cluster [-instance-id id] [-advertise-url url] [-redis-addr host:port]]
+//
+// wce login signs in to a cenv with the OAuth device flow: it shows a code
+// to approve in a browser, so no password is typed into the terminal, and
+// stores the token it receives in the OS keychain (security on macOS,
+// secret-tool on Linux) under the service "wce" and the account
+// "<server>/<cenvID>". -server defaults to $WCE_URL, or the local server.
+//
+// The storage directory holds the cenv databases, the storage.json registry,
+// the secrets master key and the JWT signing keys. It defaults to $WCE_STORAGE, or ./data when
+// that is unset, and is created on first start. Runtime assets are built
+// into the binary, so it runs the same from any working directory.
+//
+// -reuse-port binds the port with SO_REUSEPORT, so an upgraded process can
+// start listening before the old one, sent SIGTERM, finishes draining.
+//
+// -read-only suits containers with a read-only root filesystem: temporary
+// files, SQLite's included, go to a tmp directory inside the storage
+// directory, which is then the only place written to besides volumes named
+// in storage.json. The server refuses to start if it is not writable.
+//
+// -sentry-dsn reports handler panics to Sentry or a compatible tracker.
+//
+// -smtp, an smtp:// or smtps:// URL with optional user:password, sends
+// password reset and email verification messages from -mail-from. Links in
+// them start with -public-url, the address users reach the server at.
+// -verify-email makes new cenvs name an owner email, verified before the
+// owner can log in.
+//
+// -jwt-secret signs tokens with a configured secret instead of the keys in
+// the storage directory. Secrets after the first, comma-separated, are still
+// accepted, so tokens outlive a change of secret until they expire.
+//
+// -egress-allow lets proxy routes and alert webhooks reach the listed
+// internal addresses or CIDR prefixes, which are otherwise refused.
+//
+// -operator-token enables the operator API under /operator/, which takes the
+// token as a bearer token: cenv usage, job health, metrics and JWT key
+// rotation. Without it those routes answer 404.
+//
+// -clamd-addr scans binary document uploads with clamd, at a unix socket path
+// or a host:port; -scan-url posts them to an HTTP scanning service instead.
+// Uploads are served once the scanner clears them.
+//
+// -cluster lets several processes serve one storage directory. Each cenv's
+// writes go to the instance holding its write lease, kept in lease files in
+// the storage directory. -instance-id names this process (hostname-pid by
+// default) and -advertise-url is the address other instances send clients
+// to. -redis-addr publishes write events over Redis, authenticating with
+// $WCE_REDIS_PASSWORD when set; without it events stay in the process.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/cluster"
+	"github.com/thetanil/wce/internal/egress"
+	"github.com/thetanil/wce/internal/mail"
+	"github.com/thetanil/wce/internal/reporting"
+	"github.com/thetanil/wce/internal/scan"
+	"github.com/thetanil/wce/internal/server"
+)
+
+// DefaultPort is the port served when neither -port nor $WCE_PORT is set
+const DefaultPort = 5309
+
+// minOperatorTokenLength is the shortest -operator-token accepted
+const minOperatorTokenLength = 32
+
+// tempDirName is the directory under storage that holds temporary files in
+// read-only mode
+const tempDirName = "tmp"
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		if err := runLogin(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("login: %v", err)
+		}
+		return
+	}
+
+	opts, err := parseOptions(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
+	srv, manager, err := newServer(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = srv.Start()
+	manager.CloseAll()
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// options are the server's command-line settings
+type options struct {
+	storageDir    string
+	port          int
+	reusePort     bool
+	readOnly      bool
+	sentryDSN     string
+	smtpURL       string
+	mailFrom      string
+	publicURL     string
+	verifyEmail   bool
+	jwtSecret     string
+	egressAllow   string
+	operatorToken string
+	clamdAddr     string
+	scanURL       string
+	cluster       bool
+	instanceID    string
+	advertiseURL  string
+	redisAddr     string
+}
+
+// parseOptions reads the server flags from args, with defaults from the
+// environment
+func parseOptions(args []string) (*options, error) {
+	opts := &options{}
+	flags := flag.NewFlagSet("wce", flag.ContinueOnError)
+	flags.StringVar(&opts.storageDir, "storage", envOr("WCE_STORAGE", "data"), "Storage directory for cenv databases ($WCE_STORAGE)")
+	flags.IntVar(&opts.port, "port", defaultPort(), "Port to listen on ($WCE_PORT)")
+	flags.BoolVar(&opts.reusePort, "reuse-port", os.Getenv("WCE_REUSE_PORT") == "true",
+		"Bind with SO_REUSEPORT so a new process can take over the port ($WCE_REUSE_PORT=true)")
+	flags.BoolVar(&opts.readOnly, "read-only", os.Getenv("WCE_READ_ONLY") == "true",
+		"Write nothing outside the storage directory, for read-only root filesystems ($WCE_READ_ONLY=true)")
+	flags.StringVar(&opts.sentryDSN, "sentry-dsn", os.Getenv("WCE_SENTRY_DSN"), "Sentry-compatible DSN to report panics to ($WCE_SENTRY_DSN)")
+	flags.StringVar(&opts.smtpURL, "smtp", os.Getenv("WCE_SMTP_URL"), "SMTP server URL, smtp://[user:password@]host[:port] or smtps://... ($WCE_SMTP_URL)")
+	flags.StringVar(&opts.mailFrom, "mail-from", os.Getenv("WCE_MAIL_FROM"), "Sender address of emails ($WCE_MAIL_FROM)")
+	flags.StringVar(&opts.publicURL, "public-url", os.Getenv("WCE_PUBLIC_URL"), "Base URL of links in emails, e.g. https://wce.example.com ($WCE_PUBLIC_URL)")
+	flags.BoolVar(&opts.verifyEmail, "verify-email", os.Getenv("WCE_VERIFY_EMAIL") == "true",
+		"Require a verified owner email for new cenvs; needs -smtp ($WCE_VERIFY_EMAIL=true)")
+	flags.StringVar(&opts.jwtSecret, "jwt-secret", os.Getenv("WCE_JWT_SECRET"),
+		"Secret that signs tokens, then comma-separated previous secrets still accepted ($WCE_JWT_SECRET)")
+	flags.StringVar(&opts.egressAllow, "egress-allow", os.Getenv("WCE_EGRESS_ALLOW"),
+		"Comma-separated internal addresses or CIDR prefixes proxy routes and webhooks may reach ($WCE_EGRESS_ALLOW)")
+	flags.StringVar(&opts.operatorToken, "operator-token", os.Getenv("WCE_OPERATOR_TOKEN"),
+		"Bearer token that enables the /operator/ API ($WCE_OPERATOR_TOKEN)")
+	flags.StringVar(&opts.clamdAddr, "clamd-addr", os.Getenv("WCE_CLAMD_ADDR"),
+		"clamd socket path or host:port to scan binary uploads with ($WCE_CLAMD_ADDR)")
+	flags.StringVar(&opts.scanURL, "scan-url", os.Getenv("WCE_SCAN_URL"),
+		"URL of an HTTP service to scan binary uploads with ($WCE_SCAN_URL)")
+	flags.BoolVar(&opts.cluster, "cluster", os.Getenv("WCE_CLUSTER") == "true",
+		"Share the storage directory with other instances through write leases ($WCE_CLUSTER=true)")
+	flags.StringVar(&opts.instanceID, "instance-id", os.Getenv("WCE_INSTANCE_ID"),
+		"Unique name of this instance with -cluster, hostname-pid by default ($WCE_INSTANCE_ID)")
+	flags.StringVar(&opts.advertiseURL, "advertise-url", os.Getenv("WCE_ADVERTISE_URL"),
+		"Base URL other instances route this instance's cenvs to with -cluster ($WCE_ADVERTISE_URL)")
+	flags.StringVar(&opts.redisAddr, "redis-addr", os.Getenv("WCE_REDIS_ADDR"),
+		"Redis host:port that carries write events between instances with -cluster ($WCE_REDIS_ADDR)")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "Unexpected arguments: %v\n", flags.Args())
+		flags.Usage()
+		return nil, fmt.Errorf("unexpected arguments")
+	}
+	return opts, nil
+}
+
+// newServer prepares the storage directory and a server configured by opts
+func newServer(opts *options) (*server.Server, *cenv.Manager, error) {
+	if opts.verifyEmail && opts.smtpURL == "" {
+		return nil, nil, fmt.Errorf("-verify-email needs -smtp to send verification emails")
+	}
+
+	if err := os.MkdirAll(opts.storageDir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	if opts.readOnly {
+		if err := confineTempFiles(opts.storageDir); err != nil {
+			return nil, nil, fmt.Errorf("failed to set up read-only mode: %w", err)
+		}
+	}
+
+	manager := cenv.NewManager(opts.storageDir)
+	srv := server.New(opts.port, manager)
+	srv.SetReusePort(opts.reusePort)
+	if opts.egressAllow != "" {
+		prefixes, err := egress.ParsePrefixes(opts.egressAllow)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -egress-allow: %w", err)
+		}
+		srv.SetEgressAllowlist(prefixes...)
+	}
+	if opts.jwtSecret != "" {
+		if err := srv.SetJWTSecrets(strings.Split(opts.jwtSecret, ",")...); err != nil {
+			return nil, nil, fmt.Errorf("invalid -jwt-secret: %w", err)
+		}
+	}
+	if opts.operatorToken != "" {
+		if len(opts.operatorToken) < minOperatorTokenLength {
+			return nil, nil, fmt.Errorf("-operator-token must be at least %d characters", minOperatorTokenLength)
+		}
+		srv.SetOperatorToken(opts.operatorToken)
+	}
+	if opts.cluster {
+		coordinator, err := newCoordinator(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		srv.SetCoordinator(coordinator)
+	} else if opts.instanceID != "" || opts.advertiseURL != "" || opts.redisAddr != "" {
+		return nil, nil, fmt.Errorf("-instance-id, -advertise-url and -redis-addr need -cluster")
+	}
+	if opts.clamdAddr != "" || opts.scanURL != "" {
+		scanner, err := newScanner(opts.clamdAddr, opts.scanURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		srv.SetScanner(scanner)
+	}
+	if opts.sentryDSN != "" {
+		sink, err := reporting.NewSentrySink(opts.sentryDSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to configure error reporting: %w", err)
+		}
+		srv.SetErrorSinks(sink)
+	}
+	if opts.smtpURL != "" {
+		mailer, err := mail.ParseSMTPURL(opts.smtpURL, opts.mailFrom)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to configure email: %w", err)
+		}
+		srv.SetMailer(mailer)
+		srv.SetPublicURL(opts.publicURL)
+		srv.SetEmailVerification(opts.verifyEmail)
+	}
+	return srv, manager, nil
+}
+
+// newCoordinator returns the multi-instance coordinator opts describe, with
+// write leases in the storage directory
+func newCoordinator(opts *options) (*cluster.Coordinator, error) {
+	instance := cluster.Instance{ID: opts.instanceID, Address: opts.advertiseURL}
+	if instance.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to name instance, set -instance-id: %w", err)
+		}
+		instance.ID = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+
+	var bus cluster.Bus
+	if opts.redisAddr != "" {
+		if _, _, err := net.SplitHostPort(opts.redisAddr); err != nil {
+			return nil, fmt.Errorf("invalid -redis-addr: %w", err)
+		}
+		redis := cluster.NewRedisBus(opts.redisAddr)
+		redis.Password = os.Getenv("WCE_REDIS_PASSWORD")
+		bus = redis
+	}
+	return cluster.NewCoordinator(instance, cluster.NewFileLeases(opts.storageDir), bus), nil
+}
+
+// newScanner returns the malware scanner for a clamd address, a path for a
+// unix socket or host:port, or for the URL of an HTTP scanning service
+func newScanner(clamdAddr, scanURL string) (scan.Scanner, error) {
+	switch {
+	case clamdAddr != "" && scanURL != "":
+		return nil, fmt.Errorf("-clamd-addr and -scan-url cannot be combined")
+	case strings.HasPrefix(clamdAddr, "/"):
+		return scan.NewClamdScanner("unix", clamdAddr), nil
+	case clamdAddr != "":
+		if _, _, err := net.SplitHostPort(clamdAddr); err != nil {
+			return nil, fmt.Errorf("invalid -clamd-addr: %w", err)
+		}
+		return scan.NewClamdScanner("tcp", clamdAddr), nil
+	}
+	parsed, err := url.Parse(scanURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid -scan-url: %s", scanURL)
+	}
+	return scan.NewHTTPScanner(scanURL), nil
+}
+
+// confineTempFiles points Go's and SQLite's temporary files at a directory
+// inside storageDir, emptied of files left by an earlier run
+func confineTempFiles(storageDir string) error {
+	dir, err := filepath.Abs(filepath.Join(storageDir, tempDirName))
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	// os.TempDir reads TMPDIR; SQLite prefers SQLITE_TMPDIR
+	os.Setenv("TMPDIR", dir)
+	os.Setenv("SQLITE_TMPDIR", dir)
+	log.Printf("Read-only mode: temporary files in %s", dir)
+	return nil
+}
+
+// defaultPort returns $WCE_PORT, or DefaultPort when it is unset or invalid
+func defaultPort() int {
+	if port, err := strconv.Atoi(os.Getenv("WCE_PORT")); err == nil {
+		return port
+	}
+	return DefaultPort
+}
+
+// envOr returns the environment variable name, or fallback when it is unset
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/scan"
+)
+
+func TestConfineTempFiles(t *testing.T) {
+	storage := t.TempDir()
+	t.Setenv("TMPDIR", "/nonexistent")
+	t.Setenv("SQLITE_TMPDIR", "")
+
+	leftover := filepath.Join(storage, tempDirName, "leftover")
+	os.MkdirAll(filepath.Dir(leftover), 0700)
+	os.WriteFile(leftover, []byte("x"), 0600)
+
+	if err := confineTempFiles(storage); err != nil {
+		t.Fatalf("confineTempFiles failed: %v", err)
+	}
+
+	want := filepath.Join(storage, tempDirName)
+	if os.TempDir() != want || os.Getenv("SQLITE_TMPDIR") != want {
+		t.Errorf("Expected temporary files in %s, got TMPDIR=%s SQLITE_TMPDIR=%s", want, os.TempDir(), os.Getenv("SQLITE_TMPDIR"))
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Error("Expected files from an earlier run to be removed")
+	}
+	file, err := os.CreateTemp("", "probe-*")
+	if err != nil {
+		t.Fatalf("Expected the temporary directory to be writable: %v", err)
+	}
+	file.Close()
+}
+
+// operatorGet sends a GET to the operator API of a server configured by opts
+func operatorGet(t *testing.T, opts *options, path, bearer string) *httptest.ResponseRecorder {
+	t.Helper()
+	srv, manager, err := newServer(opts)
+	if err != nil {
+		t.Fatalf("newServer failed: %v", err)
+	}
+	defer manager.CloseAll()
+	req := httptest.NewRequest("GET", path, nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func TestOperatorToken(t *testing.T) {
+	const token = "operator-token-0123456789abcdefghij"
+
+	jobs := func(t *testing.T, opts *options, bearer string) int {
+		t.Helper()
+		return operatorGet(t, opts, "/operator/jobs", bearer).Code
+	}
+
+	t.Run("Flag", func(t *testing.T) {
+		opts, err := parseOptions([]string{"-storage", t.TempDir(), "-operator-token", token})
+		if err != nil {
+			t.Fatalf("parseOptions failed: %v", err)
+		}
+		if code := jobs(t, opts, token); code != http.StatusOK {
+			t.Errorf("Expected the operator API with the token, got %d", code)
+		}
+		if code := jobs(t, opts, "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("Expected a wrong token to be refused, got %d", code)
+		}
+	})
+
+	t.Run("CenvUsage", func(t *testing.T) {
+		opts, _ := parseOptions([]string{"-storage", t.TempDir(), "-operator-token", token})
+		w := operatorGet(t, opts, "/operator/cenvs", token)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"cenvs"`) {
+			t.Errorf("Expected the cenv usage list, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("JobHealth", func(t *testing.T) {
+		opts, _ := parseOptions([]string{"-storage", t.TempDir(), "-operator-token", token})
+		w := operatorGet(t, opts, "/operator/metrics", token)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "wce_job_runs_total") {
+			t.Errorf("Expected job metrics, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		t.Setenv("WCE_OPERATOR_TOKEN", token)
+		opts, err := parseOptions([]string{"-storage", t.TempDir()})
+		if err != nil {
+			t.Fatalf("parseOptions failed: %v", err)
+		}
+		if code := jobs(t, opts, token); code != http.StatusOK {
+			t.Errorf("Expected $WCE_OPERATOR_TOKEN to enable the operator API, got %d", code)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Setenv("WCE_OPERATOR_TOKEN", "")
+		opts, _ := parseOptions([]string{"-storage", t.TempDir()})
+		if code := jobs(t, opts, token); code != http.StatusNotFound {
+			t.Errorf("Expected the operator API to be off without a token, got %d", code)
+		}
+	})
+
+	t.Run("TooShort", func(t *testing.T) {
+		opts, _ := parseOptions([]string{"-storage", t.TempDir(), "-operator-token", "short"})
+		if _, _, err := newServer(opts); err == nil {
+			t.Error("Expected a short operator token to be refused")
+		}
+	})
+}
+
+func TestNewScanner(t *testing.T) {
+	tests := []struct {
+		clamdAddr, scanURL string
+		want               scan.Scanner
+	}{
+		{"/run/clamav/clamd.ctl", "", scan.NewClamdScanner("unix", "/run/clamav/clamd.ctl")},
+		{"clamav:3310", "", scan.NewClamdScanner("tcp", "clamav:3310")},
+		{"", "https://scanner.internal/scan", scan.NewHTTPScanner("https://scanner.internal/scan")},
+		{"clamav", "", nil},
+		{"", "scanner.internal", nil},
+		{"clamav:3310", "https://scanner.internal/scan", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := newScanner(tt.clamdAddr, tt.scanURL)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("newScanner(%q, %q) = %#v, want an error", tt.clamdAddr, tt.scanURL, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newScanner(%q, %q) failed: %v", tt.clamdAddr, tt.scanURL, err)
+			continue
+		}
+		switch want := tt.want.(type) {
+		case *scan.ClamdScanner:
+			if c, ok := got.(*scan.ClamdScanner); !ok || c.Network != want.Network || c.Address != want.Address {
+				t.Errorf("newScanner(%q, %q) = %#v, want %#v", tt.clamdAddr, tt.scanURL, got, want)
+			}
+		case *scan.HTTPScanner:
+			if h, ok := got.(*scan.HTTPScanner); !ok || h.URL != want.URL {
+				t.Errorf("newScanner(%q, %q) = %#v, want %#v", tt.clamdAddr, tt.scanURL, got, want)
+			}
+		}
+	}
+}
+
+func TestClusterOptions(t *testing.T) {
+	instance := func(t *testing.T, args ...string) string {
+		t.Helper()
+		opts, err := parseOptions(append([]string{"-storage", t.TempDir()}, args...))
+		if err != nil {
+			t.Fatalf("parseOptions failed: %v", err)
+		}
+		srv, manager, err := newServer(opts)
+		if err != nil {
+			t.Fatalf("newServer failed: %v", err)
+		}
+		defer manager.CloseAll()
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		return w.Header().Get("X-WCE-Instance")
+	}
+
+	if got := instance(t, "-cluster", "-instance-id", "wce-a", "-advertise-url", "http://wce-a:5309"); got != "wce-a" {
+		t.Errorf("Expected responses from instance wce-a, got %q", got)
+	}
+	if got := instance(t, "-cluster"); !strings.HasSuffix(got, "-"+strconv.Itoa(os.Getpid())) {
+		t.Errorf("Expected a hostname-pid instance id, got %q", got)
+	}
+	if got := instance(t); got != "" {
+		t.Errorf("Expected no instance header without -cluster, got %q", got)
+	}
+
+	opts, _ := parseOptions([]string{"-storage", t.TempDir(), "-redis-addr", "redis:6379"})
+	if _, _, err := newServer(opts); err == nil {
+		t.Error("Expected -redis-addr without -cluster to be refused")
+	}
+}
+
+func TestReusePortOption(t *testing.T) {
+	opts, err := parseOptions([]string{"-reuse-port"})
+	if err != nil || !opts.reusePort {
+		t.Errorf("Expected -reuse-port to be set, got %v %v", opts, err)
+	}
+	t.Setenv("WCE_REUSE_PORT", "true")
+	if opts, _ := parseOptions(nil); !opts.reusePort {
+		t.Error("Expected $WCE_REUSE_PORT=true to set -reuse-port")
+	}
+}
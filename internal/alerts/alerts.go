@@ -0,0 +1,339 @@
+// Package alerts watches a cenv's authentication activity and raises alerts
+// when it looks unusual: a burst of failed logins, a login from a country the
+// user has not logged in from before, or a user gaining privileges. Alerts are
+// stored in _wce_alerts and, when the cenv sets 'alert_webhook_url', posted to
+// that webhook. Thresholds are read from the cenv's configuration.
+package alerts
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"time"
+
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/egress"
+)
+
+// Alert kinds
+const (
+	KindFailedLogins         = "failed_logins"
+	KindNewCountry           = "new_country"
+	KindPermissionEscalation = "permission_escalation"
+)
+
+// Alert is one raised alert
+type Alert struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Message   string          `json:"message"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+// GeoIP resolves an IP address to an ISO 3166 country code, or "" when the
+// address cannot be located. It is a plug-in, e.g. backed by a MaxMind database.
+type GeoIP interface {
+	Country(ip string) (string, error)
+}
+
+// LoginAttempt describes one login. UserID is empty when the username does
+// not exist.
+type LoginAttempt struct {
+	Username  string
+	UserID    string
+	IPAddress string
+	Succeeded bool
+}
+
+// roleRank orders roles by privilege
+var roleRank = map[string]int{"viewer": 1, "editor": 2, "admin": 3, "owner": 4}
+
+// Monitor detects unusual activity and delivers alerts. Without a GeoIP
+// plug-in no new-country alerts are raised. SendMail defaults to
+// smtp.SendMail.
+type Monitor struct {
+	GeoIP    GeoIP
+	Client   *http.Client
+	SendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	deliveries deliveryTracker
+}
+
+// NewMonitor creates a monitor that delivers webhooks with a 10 second
+// timeout, refusing internal addresses
+func NewMonitor() *Monitor {
+	return &Monitor{Client: NewWebhookClient(egress.NewGuard())}
+}
+
+// NewWebhookClient creates a webhook client that dials through guard, as
+// webhook URLs are set by cenv admins
+func NewWebhookClient(guard *egress.Guard) *http.Client {
+	return &http.Client{Timeout: 10 * time.Second, Transport: guard.Transport()}
+}
+
+// RecordLogin stores a login attempt and raises an alert when it completes a
+// burst of failed logins for the username or address, or is a successful
+// login from a new country
+func (m *Monitor) RecordLogin(db *sql.DB, cenvID string, attempt LoginAttempt) error {
+	ip := hostOnly(attempt.IPAddress)
+	country := ""
+	if attempt.Succeeded && m.GeoIP != nil && ip != "" {
+		c, err := m.GeoIP.Country(ip)
+		if err != nil {
+			log.Printf("GeoIP lookup for %s failed: %v", ip, err)
+		}
+		country = c
+	}
+
+	now := time.Now().Unix()
+	if _, err := db.Exec(`
+		INSERT INTO _wce_login_attempts (username, user_id, ip_address, country, succeeded, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, attempt.Username, nullIfEmpty(attempt.UserID), ip, nullIfEmpty(country), attempt.Succeeded, now); err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	if !attempt.Succeeded {
+		return m.checkFailedLogins(db, cenvID, attempt.Username, ip, now)
+	}
+	if country != "" && attempt.UserID != "" && config.GetBool(db, "alert_on_new_country", true) {
+		return m.checkNewCountry(db, cenvID, attempt, ip, country)
+	}
+	return nil
+}
+
+// checkFailedLogins raises an alert when the failures for username or ip
+// within the window reach the threshold. Only the failure that reaches it
+// alerts, so a sustained attack raises one alert per window.
+func (m *Monitor) checkFailedLogins(db *sql.DB, cenvID, username, ip string, now int64) error {
+	threshold := config.GetInt(db, "alert_failed_logins", 5)
+	if threshold <= 0 {
+		return nil
+	}
+	window := config.GetInt(db, "alert_failed_login_window_minutes", 15)
+	since := now - window*60
+
+	for _, subject := range []struct{ column, value string }{
+		{"username", username},
+		{"ip_address", ip},
+	} {
+		if subject.value == "" {
+			continue
+		}
+
+		var count int64
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM _wce_login_attempts
+			WHERE `+subject.column+` = ? AND succeeded = 0 AND timestamp > ?
+		`, subject.value, since).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to count failed logins: %w", err)
+		}
+
+		if count == threshold {
+			message := fmt.Sprintf("%d failed logins for %s %q in %d minutes", count, subject.column, subject.value, window)
+			if err := m.raise(db, cenvID, KindFailedLogins, message, map[string]interface{}{
+				subject.column:   subject.value,
+				"failures":       count,
+				"window_minutes": window,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNewCountry raises an alert when a user with earlier located logins
+// logs in from a country none of them came from
+func (m *Monitor) checkNewCountry(db *sql.DB, cenvID string, attempt LoginAttempt, ip, country string) error {
+	var known, seen int
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(country = ?), 0) FROM _wce_login_attempts
+		WHERE user_id = ? AND succeeded = 1 AND country IS NOT NULL
+	`, country, attempt.UserID).Scan(&known, &seen)
+	if err != nil {
+		return fmt.Errorf("failed to check login countries: %w", err)
+	}
+
+	// The attempt itself was just recorded; alert only if it is the first
+	// from this country and not the user's first located login
+	if seen != 1 || known == 1 {
+		return nil
+	}
+
+	return m.raise(db, cenvID, KindNewCountry,
+		fmt.Sprintf("user %q logged in from a new country: %s", attempt.Username, country),
+		map[string]interface{}{
+			"username":   attempt.Username,
+			"user_id":    attempt.UserID,
+			"ip_address": ip,
+			"country":    country,
+		})
+}
+
+// RecordRoleChange raises an alert when actorID promotes a user. oldRole is
+// empty for a new user, which alerts only when created as admin.
+func (m *Monitor) RecordRoleChange(db *sql.DB, cenvID, actorID, username, oldRole, newRole string) error {
+	escalated := roleRank[newRole] > roleRank[oldRole]
+	if oldRole == "" {
+		escalated = roleRank[newRole] >= roleRank["admin"]
+	}
+	if !escalated || !config.GetBool(db, "alert_on_permission_escalation", true) {
+		return nil
+	}
+
+	message := fmt.Sprintf("user %q was given the %s role", username, newRole)
+	if oldRole != "" {
+		message = fmt.Sprintf("user %q was promoted from %s to %s", username, oldRole, newRole)
+	}
+	return m.raise(db, cenvID, KindPermissionEscalation, message, map[string]interface{}{
+		"username":   username,
+		"old_role":   oldRole,
+		"new_role":   newRole,
+		"granted_by": actorID,
+	})
+}
+
+// RecordGrantOption raises an alert when actorID allows a user to grant
+// permissions on a table to others
+func (m *Monitor) RecordGrantOption(db *sql.DB, cenvID, actorID, userID, table string) error {
+	if !config.GetBool(db, "alert_on_permission_escalation", true) {
+		return nil
+	}
+
+	return m.raise(db, cenvID, KindPermissionEscalation,
+		fmt.Sprintf("user %s may now grant permissions on table %q", userID, table),
+		map[string]interface{}{
+			"user_id":    userID,
+			"table_name": table,
+			"can_grant":  true,
+			"granted_by": actorID,
+		})
+}
+
+// raise stores an alert and posts it to the cenv's webhook in the background
+func (m *Monitor) raise(db *sql.DB, cenvID, kind, message string, details map[string]interface{}) error {
+	detailsJSON, err := jsonDetails(details)
+	if err != nil {
+		return err
+	}
+
+	alert := Alert{
+		Kind:      kind,
+		Message:   message,
+		Details:   detailsJSON,
+		CreatedAt: time.Now().Unix(),
+	}
+	result, err := db.Exec(`
+		INSERT INTO _wce_alerts (kind, message, details, created_at) VALUES (?, ?, ?, ?)
+	`, alert.Kind, alert.Message, string(alert.Details), alert.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store alert: %w", err)
+	}
+	alert.ID, _ = result.LastInsertId()
+
+	log.Printf("Alert in cenv %s: %s", cenvID, message)
+
+	if url := config.GetString(db, "alert_webhook_url", ""); url != "" {
+		m.dispatch(func() error { return m.deliver(url, cenvID, alert) })
+	}
+	return nil
+}
+
+// jsonDetails encodes alert details
+func jsonDetails(details map[string]interface{}) (json.RawMessage, error) {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode alert details: %w", err)
+	}
+	return encoded, nil
+}
+
+// deliver posts an alert to a webhook. Failures are logged and returned; the
+// alert stays listed in the cenv either way.
+func (m *Monitor) deliver(url, cenvID string, alert Alert) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"cenv_id": cenvID,
+		"alert":   alert,
+	})
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to build alert webhook request: %v", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wce-alerts")
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		log.Printf("Failed to deliver alert %d to webhook: %v", alert.ID, err)
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Alert webhook answered %d for alert %d", resp.StatusCode, alert.ID)
+		return fmt.Errorf("alert webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// List returns the most recent alerts first, optionally of one kind
+func List(db *sql.DB, kind string, limit int) ([]Alert, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := db.Query(`
+		SELECT id, kind, message, COALESCE(details, ''), created_at FROM _wce_alerts
+		WHERE ? = '' OR kind = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, kind, kind, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var alert Alert
+		var details string
+		if err := rows.Scan(&alert.ID, &alert.Kind, &alert.Message, &details, &alert.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		if details != "" {
+			alert.Details = json.RawMessage(details)
+		}
+		alerts = append(alerts, alert)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating alerts: %w", err)
+	}
+
+	return alerts, nil
+}
+
+// hostOnly strips the port from a "host:port" remote address
+func hostOnly(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
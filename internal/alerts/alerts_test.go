@@ -0,0 +1,294 @@
+package alerts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/egress"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	return conn
+}
+
+// staticGeoIP locates addresses from a fixed table
+type staticGeoIP map[string]string
+
+func (g staticGeoIP) Country(ip string) (string, error) {
+	return g[ip], nil
+}
+
+func kinds(t *testing.T, conn *sql.DB) []string {
+	t.Helper()
+	list, err := List(conn, "", 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var kinds []string
+	for _, alert := range list {
+		kinds = append(kinds, alert.Kind)
+	}
+	return kinds
+}
+
+func TestFailedLogins(t *testing.T) {
+	conn := setupTestDB(t)
+	defer conn.Close()
+	config.Set(conn, "alert_failed_logins", "3", "")
+
+	m := NewMonitor()
+	fail := func(username, ip string) {
+		t.Helper()
+		if err := m.RecordLogin(conn, "cenv", LoginAttempt{Username: username, IPAddress: ip}); err != nil {
+			t.Fatalf("RecordLogin failed: %v", err)
+		}
+	}
+
+	fail("alice", "192.0.2.1:1000")
+	fail("alice", "192.0.2.2:1000")
+	if got := kinds(t, conn); len(got) != 0 {
+		t.Fatalf("Expected no alerts below threshold, got %v", got)
+	}
+
+	fail("alice", "192.0.2.3:1000")
+	list, _ := List(conn, KindFailedLogins, 0)
+	if len(list) != 1 {
+		t.Fatalf("Expected one alert at threshold, got %d", len(list))
+	}
+	var details map[string]interface{}
+	json.Unmarshal(list[0].Details, &details)
+	if details["username"] != "alice" || details["failures"] != float64(3) {
+		t.Errorf("Unexpected details: %s", list[0].Details)
+	}
+
+	// Further failures in the same burst do not alert again
+	fail("alice", "192.0.2.4:1000")
+	if got := kinds(t, conn); len(got) != 1 {
+		t.Errorf("Expected one alert per burst, got %v", got)
+	}
+
+	// Password spraying from one address alerts on the address
+	fail("bob", "198.51.100.7:1")
+	fail("carol", "198.51.100.7:2")
+	fail("dave", "198.51.100.7:3")
+	list, _ = List(conn, KindFailedLogins, 0)
+	if len(list) != 2 {
+		t.Fatalf("Expected an alert for the address, got %d alerts", len(list))
+	}
+	json.Unmarshal(list[0].Details, &details)
+	if details["ip_address"] != "198.51.100.7" {
+		t.Errorf("Unexpected details: %s", list[0].Details)
+	}
+
+	// Old failures fall out of the window
+	conn.Exec(`UPDATE _wce_login_attempts SET timestamp = ?`, time.Now().Add(-time.Hour).Unix())
+	fail("alice", "192.0.2.1:1000")
+	if got := kinds(t, conn); len(got) != 2 {
+		t.Errorf("Expected expired failures not to count, got %v", got)
+	}
+
+	// A threshold of 0 disables the alert
+	config.Set(conn, "alert_failed_logins", "0", "")
+	for i := 0; i < 5; i++ {
+		fail("erin", "203.0.113.1:1")
+	}
+	if got := kinds(t, conn); len(got) != 2 {
+		t.Errorf("Expected disabled alert not to fire, got %v", got)
+	}
+}
+
+func TestNewCountry(t *testing.T) {
+	conn := setupTestDB(t)
+	defer conn.Close()
+
+	m := NewMonitor()
+	m.GeoIP = staticGeoIP{"192.0.2.1": "DE", "192.0.2.2": "DE", "198.51.100.1": "BR"}
+
+	login := func(ip string) {
+		t.Helper()
+		if err := m.RecordLogin(conn, "cenv", LoginAttempt{Username: "alice", UserID: "user-1", IPAddress: ip, Succeeded: true}); err != nil {
+			t.Fatalf("RecordLogin failed: %v", err)
+		}
+	}
+
+	login("192.0.2.1:1")
+	login("192.0.2.2:1")
+	login("203.0.113.9:1") // Not located
+	if got := kinds(t, conn); len(got) != 0 {
+		t.Fatalf("Expected no alerts from known or unknown countries, got %v", got)
+	}
+
+	login("198.51.100.1:1")
+	list, _ := List(conn, KindNewCountry, 0)
+	if len(list) != 1 {
+		t.Fatalf("Expected a new country alert, got %d", len(list))
+	}
+
+	// The country is known from now on
+	login("198.51.100.1:1")
+	if got := kinds(t, conn); len(got) != 1 {
+		t.Errorf("Expected no repeat alert, got %v", got)
+	}
+}
+
+func TestPermissionEscalation(t *testing.T) {
+	conn := setupTestDB(t)
+	defer conn.Close()
+
+	m := NewMonitor()
+	changes := []struct {
+		oldRole, newRole string
+		alert            bool
+	}{
+		{"", "viewer", false},
+		{"", "admin", true},
+		{"viewer", "editor", true},
+		{"admin", "viewer", false},
+		{"editor", "editor", false},
+	}
+	for _, c := range changes {
+		before := len(kinds(t, conn))
+		if err := m.RecordRoleChange(conn, "cenv", "user-1", "bob", c.oldRole, c.newRole); err != nil {
+			t.Fatalf("RecordRoleChange failed: %v", err)
+		}
+		if raised := len(kinds(t, conn)) > before; raised != c.alert {
+			t.Errorf("%q -> %q: expected alert=%v", c.oldRole, c.newRole, c.alert)
+		}
+	}
+
+	if err := m.RecordGrantOption(conn, "cenv", "user-1", "user-2", "orders"); err != nil {
+		t.Fatalf("RecordGrantOption failed: %v", err)
+	}
+	if list, _ := List(conn, KindPermissionEscalation, 0); len(list) != 3 {
+		t.Errorf("Expected 3 escalation alerts, got %d", len(list))
+	}
+
+	config.Set(conn, "alert_on_permission_escalation", "false", "")
+	m.RecordRoleChange(conn, "cenv", "user-1", "bob", "viewer", "admin")
+	if list, _ := List(conn, KindPermissionEscalation, 0); len(list) != 3 {
+		t.Errorf("Expected disabled alert not to fire, got %d", len(list))
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer hook.Close()
+
+	conn := setupTestDB(t)
+	defer conn.Close()
+	config.Set(conn, "alert_webhook_url", hook.URL, "")
+
+	m := NewMonitor()
+	m.Client = hook.Client() // The test webhook is on loopback
+	if err := m.RecordRoleChange(conn, "cenv-1", "user-1", "bob", "viewer", "admin"); err != nil {
+		t.Fatalf("RecordRoleChange failed: %v", err)
+	}
+
+	select {
+	case payload := <-received:
+		alert, _ := payload["alert"].(map[string]interface{})
+		if payload["cenv_id"] != "cenv-1" || alert["kind"] != KindPermissionEscalation {
+			t.Errorf("Unexpected payload: %v", payload)
+		}
+		if fmt.Sprint(alert["message"]) != `user "bob" was promoted from viewer to admin` {
+			t.Errorf("Unexpected message: %v", alert["message"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not called")
+	}
+}
+
+func TestDeliveryStats(t *testing.T) {
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer hook.Close()
+
+	m := NewMonitor()
+	m.Client = hook.Client()
+	m.NotifyOperator(OperatorChannels{WebhookURL: hook.URL}, "cenv-1", KindSizeThreshold, "too big", nil)
+	if stats := m.DeliveryStats(); stats.Pending != 1 {
+		t.Errorf("Expected one pending delivery, got %+v", stats)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.DeliveryStats().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := m.DeliveryStats()
+	if stats.Pending != 0 || stats.Failed != 1 || stats.Delivered != 0 || stats.LastAttemptAt == 0 {
+		t.Errorf("Expected one failed delivery, got %+v", stats)
+	}
+	if stats.LastError != "alert webhook answered 502" {
+		t.Errorf("Unexpected last error: %q", stats.LastError)
+	}
+}
+
+func TestWebhookInternalAddressRefused(t *testing.T) {
+	called := make(chan struct{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- struct{}{}
+	}))
+	defer hook.Close()
+
+	conn := setupTestDB(t)
+	defer conn.Close()
+	config.Set(conn, "alert_webhook_url", hook.URL, "")
+
+	m := NewMonitor()
+	if err := m.RecordRoleChange(conn, "cenv-1", "user-1", "bob", "viewer", "admin"); err != nil {
+		t.Fatalf("RecordRoleChange failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.DeliveryStats().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := m.DeliveryStats(); stats.Failed != 1 || !strings.Contains(stats.LastError, "not allowed") {
+		t.Errorf("Expected the loopback webhook refused, got %+v", stats)
+	}
+	select {
+	case <-called:
+		t.Error("Webhook on loopback was called")
+	default:
+	}
+
+	// An operator can allow an internal webhook
+	m.Client = NewWebhookClient(egress.NewGuard(netip.MustParsePrefix("127.0.0.0/8")))
+	m.RecordRoleChange(conn, "cenv-1", "user-1", "carol", "viewer", "admin")
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Allowed webhook was not called")
+	}
+}
@@ -0,0 +1,53 @@
+package alerts
+
+import (
+	"sync"
+	"time"
+)
+
+// DeliveryStats counts the webhook and email deliveries of a monitor since it
+// was created
+type DeliveryStats struct {
+	Pending       int64  // Started and not yet finished
+	Delivered     int64  // Finished successfully
+	Failed        int64  // Finished with an error
+	LastAttemptAt int64  // Unix time the last delivery finished, 0 before the first
+	LastError     string // Error of the last failed delivery
+}
+
+// deliveryTracker keeps a monitor's DeliveryStats
+type deliveryTracker struct {
+	mu    sync.Mutex
+	stats DeliveryStats
+}
+
+// dispatch runs send in the background, counting it as pending until it
+// returns and then as delivered or failed
+func (m *Monitor) dispatch(send func() error) {
+	m.deliveries.mu.Lock()
+	m.deliveries.stats.Pending++
+	m.deliveries.mu.Unlock()
+
+	go func() {
+		err := send()
+
+		m.deliveries.mu.Lock()
+		defer m.deliveries.mu.Unlock()
+		stats := &m.deliveries.stats
+		stats.Pending--
+		stats.LastAttemptAt = time.Now().Unix()
+		if err != nil {
+			stats.Failed++
+			stats.LastError = err.Error()
+		} else {
+			stats.Delivered++
+		}
+	}()
+}
+
+// DeliveryStats reports the monitor's alert deliveries
+func (m *Monitor) DeliveryStats() DeliveryStats {
+	m.deliveries.mu.Lock()
+	defer m.deliveries.mu.Unlock()
+	return m.deliveries.stats
+}
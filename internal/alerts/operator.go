@@ -0,0 +1,72 @@
+package alerts
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Operator alert kinds, raised about a cenv to the server operator rather
+// than to the cenv's admins
+const (
+	KindSizeThreshold = "size_threshold"
+	KindSizeGrowth    = "size_growth"
+)
+
+// OperatorChannels are where operator alerts are delivered. Email goes
+// through an unauthenticated SMTP relay at SMTPAddr (host:port).
+type OperatorChannels struct {
+	WebhookURL string
+	SMTPAddr   string
+	From       string
+	To         []string
+}
+
+// NotifyOperator logs an alert about a cenv and delivers it to the
+// operator's webhook and email in the background
+func (m *Monitor) NotifyOperator(channels OperatorChannels, cenvID, kind, message string, details map[string]interface{}) {
+	alert := Alert{
+		Kind:      kind,
+		Message:   message,
+		CreatedAt: time.Now().Unix(),
+	}
+	if encoded, err := jsonDetails(details); err == nil {
+		alert.Details = encoded
+	}
+
+	log.Printf("Operator alert for cenv %s: %s", cenvID, message)
+
+	if channels.WebhookURL != "" {
+		m.dispatch(func() error { return m.deliver(channels.WebhookURL, cenvID, alert) })
+	}
+	if len(channels.To) > 0 {
+		m.dispatch(func() error { return m.mail(channels, cenvID, alert) })
+	}
+}
+
+// mail sends an alert as a plain-text email. Failures are logged and
+// returned.
+func (m *Monitor) mail(channels OperatorChannels, cenvID string, alert Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", channels.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(channels.To, ", "))
+	fmt.Fprintf(&body, "Subject: [wce] %s\r\n", alert.Message)
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Unix(alert.CreatedAt, 0).UTC().Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n\r\nCenv: %s\r\nKind: %s\r\n", alert.Message, cenvID, alert.Kind)
+	if len(alert.Details) > 0 {
+		fmt.Fprintf(&body, "Details: %s\r\n", alert.Details)
+	}
+
+	sendMail := m.SendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+	if err := sendMail(channels.SMTPAddr, nil, channels.From, channels.To, []byte(body.String())); err != nil {
+		log.Printf("Failed to email operator alert for cenv %s: %v", cenvID, err)
+		return err
+	}
+	return nil
+}
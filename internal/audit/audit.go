@@ -0,0 +1,126 @@
+// Package audit records security-relevant actions in _wce_audit_log and
+// tracks the one-time nonces that protect signed admin requests from replay.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Entry is one audit log record
+type Entry struct {
+	ID           int64           `json:"id"`
+	Timestamp    int64           `json:"timestamp"`
+	UserID       string          `json:"user_id"`
+	Username     string          `json:"username"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type,omitempty"`
+	ResourceID   string          `json:"resource_id,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"`
+	IPAddress    string          `json:"ip_address,omitempty"`
+	UserAgent    string          `json:"user_agent,omitempty"`
+}
+
+// Record appends an entry. The timestamp defaults to now and the username is
+// looked up from UserID when not given.
+func Record(db *sql.DB, entry Entry) error {
+	if entry.UserID == "" || entry.Action == "" {
+		return fmt.Errorf("user id and action are required")
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().Unix()
+	}
+
+	var details interface{}
+	if len(entry.Details) > 0 {
+		details = string(entry.Details)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_audit_log (
+			timestamp, user_id, username, action, resource_type, resource_id,
+			details, ip_address, user_agent
+		) VALUES (?, ?, COALESCE(NULLIF(?, ''), (SELECT username FROM _wce_users WHERE user_id = ?), ''), ?, ?, ?, ?, ?, ?)
+	`, entry.Timestamp, entry.UserID, entry.Username, entry.UserID, entry.Action,
+		nullIfEmpty(entry.ResourceType), nullIfEmpty(entry.ResourceID), details,
+		nullIfEmpty(entry.IPAddress), nullIfEmpty(entry.UserAgent))
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the most recent entries first, optionally for one action
+func List(db *sql.DB, action string, limit int) ([]Entry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := db.Query(`
+		SELECT id, timestamp, user_id, username, action,
+		       COALESCE(resource_type, ''), COALESCE(resource_id, ''), COALESCE(details, ''),
+		       COALESCE(ip_address, ''), COALESCE(user_agent, '')
+		FROM _wce_audit_log
+		WHERE ? = '' OR action = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, action, action, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var details string
+		if err := rows.Scan(
+			&entry.ID, &entry.Timestamp, &entry.UserID, &entry.Username, &entry.Action,
+			&entry.ResourceType, &entry.ResourceID, &details, &entry.IPAddress, &entry.UserAgent,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if details != "" {
+			entry.Details = json.RawMessage(details)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// UseNonce records a request nonce until expiresAt. It reports false when the
+// nonce was already used, which means the request is a replay. Expired nonces
+// are removed as a side effect.
+func UseNonce(db *sql.DB, nonce, userID string, expiresAt int64) (bool, error) {
+	if _, err := db.Exec("DELETE FROM _wce_request_nonces WHERE expires_at < ?", time.Now().Unix()); err != nil {
+		return false, fmt.Errorf("failed to expire nonces: %w", err)
+	}
+
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO _wce_request_nonces (nonce, user_id, expires_at)
+		VALUES (?, ?, ?)
+	`, nonce, userID, expiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record nonce: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return inserted == 1, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
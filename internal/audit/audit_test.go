@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	if _, err := conn.Exec(`
+		INSERT INTO _wce_users (user_id, username, password_hash, role, created_at)
+		VALUES ('user-1', 'alice', 'x', 'owner', 0)
+	`); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	return conn
+}
+
+func TestRecordAndList(t *testing.T) {
+	conn := setupTestDB(t)
+	defer conn.Close()
+
+	if err := Record(conn, Entry{Action: "grant_permission"}); err == nil {
+		t.Error("Expected error without user id")
+	}
+
+	if err := Record(conn, Entry{
+		UserID:       "user-1",
+		Action:       "grant_permission",
+		ResourceType: "permission",
+		Details:      json.RawMessage(`{"body":{"table_name":"posts"}}`),
+		IPAddress:    "127.0.0.1:1234",
+	}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if err := Record(conn, Entry{UserID: "user-1", Action: "set_config", ResourceID: "slow_query_ms"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	entries, err := List(conn, "", 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != "set_config" {
+		t.Fatalf("Expected newest entry first, got %+v", entries)
+	}
+	if entries[1].Username != "alice" || string(entries[1].Details) != `{"body":{"table_name":"posts"}}` {
+		t.Errorf("Unexpected entry: %+v", entries[1])
+	}
+
+	entries, err = List(conn, "grant_permission", 10)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected one grant_permission entry, got %d (%v)", len(entries), err)
+	}
+}
+
+func TestUseNonce(t *testing.T) {
+	conn := setupTestDB(t)
+	defer conn.Close()
+
+	expires := time.Now().Add(time.Minute).Unix()
+
+	fresh, err := UseNonce(conn, "nonce-1", "user-1", expires)
+	if err != nil || !fresh {
+		t.Fatalf("Expected first use to be fresh, got %v (%v)", fresh, err)
+	}
+	fresh, err = UseNonce(conn, "nonce-1", "user-1", expires)
+	if err != nil || fresh {
+		t.Errorf("Expected reuse to be rejected, got %v (%v)", fresh, err)
+	}
+
+	// Expired nonces are forgotten
+	if _, err := UseNonce(conn, "nonce-2", "user-1", time.Now().Add(-time.Minute).Unix()); err != nil {
+		t.Fatalf("UseNonce failed: %v", err)
+	}
+	fresh, err = UseNonce(conn, "nonce-2", "user-1", expires)
+	if err != nil || !fresh {
+		t.Errorf("Expected expired nonce to be usable again, got %v (%v)", fresh, err)
+	}
+}
@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GenesisHash is the previous hash of the first compliance record
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Capture is one record of the compliance log: a redacted summary of a
+// request and its response. Each record's Hash covers its content and the
+// Hash of the record before it, so removing or altering a record breaks
+// the chain from that point on.
+type Capture struct {
+	ID        int64           `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+	RequestID string          `json:"request_id,omitempty"`
+	UserID    string          `json:"user_id,omitempty"`
+	Username  string          `json:"username,omitempty"`
+	Method    string          `json:"method"`
+	Route     string          `json:"route"`
+	Path      string          `json:"path"`
+	Query     string          `json:"query,omitempty"`
+	Status    int             `json:"status"`
+	Request   json.RawMessage `json:"request,omitempty"`  // Redacted headers and body
+	Response  json.RawMessage `json:"response,omitempty"` // Redacted body summary
+	IPAddress string          `json:"ip_address,omitempty"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// appendMu serializes appends within the process; the unique prev_hash
+// column rejects a fork made by another process sharing the database
+var appendMu sync.Mutex
+
+// AppendCapture adds a record to the end of the compliance log, filling in
+// its timestamp (when zero), previous hash and hash
+func AppendCapture(db *sql.DB, capture *Capture) error {
+	if capture.Method == "" || capture.Path == "" {
+		return fmt.Errorf("method and path are required")
+	}
+	if capture.Timestamp == 0 {
+		capture.Timestamp = time.Now().Unix()
+	}
+
+	appendMu.Lock()
+	defer appendMu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = appendCapture(db, capture); err == nil || !strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to append compliance record: %w", err)
+	}
+	return nil
+}
+
+func appendCapture(db *sql.DB, capture *Capture) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prev := GenesisHash
+	err = tx.QueryRow("SELECT hash FROM _wce_compliance_log ORDER BY id DESC LIMIT 1").Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	capture.PrevHash = prev
+	capture.Hash = capture.computeHash()
+
+	result, err := tx.Exec(`
+		INSERT INTO _wce_compliance_log (
+			timestamp, request_id, user_id, username, method, route, path, query,
+			status, request, response, ip_address, prev_hash, hash
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, capture.Timestamp, capture.RequestID, capture.UserID, capture.Username, capture.Method,
+		capture.Route, capture.Path, capture.Query, capture.Status, string(capture.Request),
+		string(capture.Response), capture.IPAddress, capture.PrevHash, capture.Hash)
+	if err != nil {
+		return err
+	}
+	if capture.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// computeHash returns the hex SHA-256 of the previous hash and the record's
+// content, each field length-prefixed so no two records encode alike
+func (c *Capture) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		c.PrevHash,
+		fmt.Sprint(c.Timestamp),
+		c.RequestID,
+		c.UserID,
+		c.Username,
+		c.Method,
+		c.Route,
+		c.Path,
+		c.Query,
+		fmt.Sprint(c.Status),
+		string(c.Request),
+		string(c.Response),
+		c.IPAddress,
+	} {
+		fmt.Fprintf(h, "%d:%s\n", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ListCaptures returns up to limit records with ids above afterID, oldest
+// first, for export
+func ListCaptures(db *sql.DB, afterID int64, limit int) ([]Capture, error) {
+	if limit <= 0 || limit > 10000 {
+		limit = 1000
+	}
+
+	rows, err := db.Query(`
+		SELECT id, timestamp, request_id, user_id, username, method, route, path, query,
+		       status, request, response, ip_address, prev_hash, hash
+		FROM _wce_compliance_log
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compliance log: %w", err)
+	}
+	defer rows.Close()
+
+	captures := []Capture{}
+	for rows.Next() {
+		var c Capture
+		var request, response string
+		if err := rows.Scan(
+			&c.ID, &c.Timestamp, &c.RequestID, &c.UserID, &c.Username, &c.Method, &c.Route, &c.Path,
+			&c.Query, &c.Status, &request, &response, &c.IPAddress, &c.PrevHash, &c.Hash,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan compliance record: %w", err)
+		}
+		if request != "" {
+			c.Request = json.RawMessage(request)
+		}
+		if response != "" {
+			c.Response = json.RawMessage(response)
+		}
+		captures = append(captures, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating compliance log: %w", err)
+	}
+
+	return captures, nil
+}
+
+// ChainStatus is the outcome of verifying the compliance log
+type ChainStatus struct {
+	Valid    bool   `json:"valid"`
+	Records  int    `json:"records"`
+	HeadHash string `json:"head_hash"`           // Hash of the last record, to compare with later exports
+	BrokenAt int64  `json:"broken_at,omitempty"` // First record whose chain does not hold
+	Reason   string `json:"reason,omitempty"`
+}
+
+// VerifyChain walks the compliance log from its first record and checks
+// that each one links to the record before it and matches its hash
+func VerifyChain(db *sql.DB) (*ChainStatus, error) {
+	status := &ChainStatus{Valid: true, HeadHash: GenesisHash}
+	var afterID int64
+	for {
+		captures, err := ListCaptures(db, afterID, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for i := range captures {
+			c := &captures[i]
+			switch {
+			case c.PrevHash != status.HeadHash:
+				status.Valid, status.BrokenAt, status.Reason = false, c.ID, "previous hash does not match"
+			case c.computeHash() != c.Hash:
+				status.Valid, status.BrokenAt, status.Reason = false, c.ID, "content does not match hash"
+			}
+			if !status.Valid {
+				return status, nil
+			}
+			status.Records++
+			status.HeadHash = c.Hash
+			afterID = c.ID
+		}
+		if len(captures) < 1000 {
+			return status, nil
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestComplianceChain(t *testing.T) {
+	conn := setupTestDB(t)
+	defer conn.Close()
+
+	if err := AppendCapture(conn, &Capture{Method: "PUT"}); err == nil {
+		t.Error("Expected error without path")
+	}
+
+	status, err := VerifyChain(conn)
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !status.Valid || status.Records != 0 || status.HeadHash != GenesisHash {
+		t.Errorf("Expected an empty valid chain, got %+v", status)
+	}
+
+	var hashes []string
+	for _, path := range []string{"/c/admin/users/bob", "/c/admin/config/slow_query_ms", "/c/admin/permissions"} {
+		record := &Capture{
+			UserID:  "user-1",
+			Method:  "PUT",
+			Route:   "PUT /{cenvID}/admin/...",
+			Path:    path,
+			Status:  200,
+			Request: json.RawMessage(`{"body":{"json":{"role":"editor"}}}`),
+		}
+		if err := AppendCapture(conn, record); err != nil {
+			t.Fatalf("AppendCapture failed: %v", err)
+		}
+		hashes = append(hashes, record.Hash)
+	}
+
+	records, err := ListCaptures(conn, 0, 0)
+	if err != nil {
+		t.Fatalf("ListCaptures failed: %v", err)
+	}
+	if len(records) != 3 || records[0].PrevHash != GenesisHash || records[1].PrevHash != hashes[0] || records[2].Hash != hashes[2] {
+		t.Fatalf("Unexpected records: %+v", records)
+	}
+	if string(records[0].Request) != `{"body":{"json":{"role":"editor"}}}` {
+		t.Errorf("Expected the request summary stored as given, got %s", records[0].Request)
+	}
+	if page, _ := ListCaptures(conn, records[0].ID, 1); len(page) != 1 || page[0].ID != records[1].ID {
+		t.Errorf("Expected paging after an id, got %+v", page)
+	}
+
+	status, _ = VerifyChain(conn)
+	if !status.Valid || status.Records != 3 || status.HeadHash != hashes[2] {
+		t.Errorf("Expected a valid chain of 3, got %+v", status)
+	}
+
+	// The log is append-only
+	if _, err := conn.Exec("UPDATE _wce_compliance_log SET status = 500"); err == nil || !strings.Contains(err.Error(), "append-only") {
+		t.Errorf("Expected updates to be refused, got %v", err)
+	}
+	if _, err := conn.Exec("DELETE FROM _wce_compliance_log"); err == nil {
+		t.Error("Expected deletes to be refused")
+	}
+
+	// Tampering behind the triggers' back breaks the chain where it happened
+	if _, err := conn.Exec("DROP TRIGGER _wce_compliance_log_no_update"); err != nil {
+		t.Fatalf("Failed to drop trigger: %v", err)
+	}
+	if _, err := conn.Exec("UPDATE _wce_compliance_log SET status = 403 WHERE id = ?", records[1].ID); err != nil {
+		t.Fatalf("Failed to tamper: %v", err)
+	}
+	status, _ = VerifyChain(conn)
+	if status.Valid || status.BrokenAt != records[1].ID || status.Records != 1 {
+		t.Errorf("Expected the chain broken at record %d, got %+v", records[1].ID, status)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/thetanil/wce/internal/clock"
 )
 
 const (
@@ -39,14 +41,15 @@ type User struct {
 
 // Session represents an active session
 type Session struct {
-	SessionID string
-	UserID    string
-	TokenHash string
-	CreatedAt int64
-	ExpiresAt int64
-	LastUsed  int64
-	IPAddress string
-	UserAgent string
+	SessionID         string
+	UserID            string
+	TokenHash         string
+	CreatedAt         int64
+	ExpiresAt         int64
+	LastUsed          int64
+	IPAddress         string
+	UserAgent         string
+	ClientFingerprint string
 }
 
 // HashPassword hashes a password using bcrypt
@@ -66,7 +69,7 @@ func VerifyPassword(password, hash string) error {
 // GenerateUUID generates a random UUID (v4)
 func GenerateUUID() (string, error) {
 	uuid := make([]byte, 16)
-	if _, err := rand.Read(uuid); err != nil {
+	if _, err := clock.ReadID(uuid); err != nil {
 		return "", fmt.Errorf("failed to generate UUID: %w", err)
 	}
 
@@ -79,7 +82,9 @@ func GenerateUUID() (string, error) {
 		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
 }
 
-// GenerateSessionID generates a random session ID
+// GenerateSessionID generates a random session ID. Its result also serves
+// as a bearer secret (refresh, reset and share tokens), so it always comes
+// from crypto/rand, never from the installed id generator.
 func GenerateSessionID() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -103,7 +108,7 @@ func CreateUser(db *sql.DB, username, password, role, email, invitedBy string) (
 	}
 
 	// Insert user
-	createdAt := time.Now().Unix()
+	createdAt := clock.Now().Unix()
 	query := `
 		INSERT INTO _wce_users (user_id, username, password_hash, role, email, created_at, invited_by, enabled)
 		VALUES (?, ?, ?, ?, ?, ?, ?, 1)
@@ -142,17 +147,39 @@ func CreateUser(db *sql.DB, username, password, role, email, invitedBy string) (
 
 // GetUserByUsername retrieves a user by username
 func GetUserByUsername(db *sql.DB, username string) (*User, error) {
+	return getUser(db, "username = ?", username)
+}
+
+// GetUserByID retrieves a user by user ID
+func GetUserByID(db *sql.DB, userID string) (*User, error) {
+	return getUser(db, "user_id = ?", userID)
+}
+
+// getUser retrieves the single user matching a WHERE condition
+func getUser(db *sql.DB, condition string, arg interface{}) (*User, error) {
 	query := `
 		SELECT user_id, username, password_hash, role, email, created_at, invited_by, last_login, enabled
 		FROM _wce_users
-		WHERE username = ?
-	`
+		WHERE ` + condition
+
+	user, err := scanUser(db.QueryRow(query, arg))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
 
+// scanUser scans a user row selected with the columns used by getUser
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
 	var user User
 	var email, invitedBy sql.NullString
 	var lastLogin sql.NullInt64
 
-	err := db.QueryRow(query, username).Scan(
+	err := row.Scan(
 		&user.UserID,
 		&user.Username,
 		&user.PasswordHash,
@@ -163,12 +190,8 @@ func GetUserByUsername(db *sql.DB, username string) (*User, error) {
 		&lastLogin,
 		&user.Enabled,
 	)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
-	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, err
 	}
 
 	if email.Valid {
@@ -187,7 +210,7 @@ func GetUserByUsername(db *sql.DB, username string) (*User, error) {
 // UpdateLastLogin updates the last login timestamp for a user
 func UpdateLastLogin(db *sql.DB, userID string) error {
 	query := `UPDATE _wce_users SET last_login = ? WHERE user_id = ?`
-	_, err := db.Exec(query, time.Now().Unix(), userID)
+	_, err := db.Exec(query, clock.Now().Unix(), userID)
 	if err != nil {
 		return fmt.Errorf("failed to update last login: %w", err)
 	}
@@ -201,29 +224,31 @@ func CreateSession(db *sql.DB, userID, tokenHash, ipAddress, userAgent string, e
 		return nil, err
 	}
 
-	now := time.Now()
+	now := clock.Now()
 	createdAt := now.Unix()
 	expiresAt := now.Add(expiresIn).Unix()
 
 	query := `
-		INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at, last_used, ip_address, user_agent)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at, last_used, ip_address, user_agent, client_fingerprint)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err = db.Exec(query, sessionID, userID, tokenHash, createdAt, expiresAt, createdAt, ipAddress, userAgent)
+	fingerprint := ClientFingerprint(ipAddress, userAgent)
+	_, err = db.Exec(query, sessionID, userID, tokenHash, createdAt, expiresAt, createdAt, ipAddress, userAgent, fingerprint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
 	return &Session{
-		SessionID: sessionID,
-		UserID:    userID,
-		TokenHash: tokenHash,
-		CreatedAt: createdAt,
-		ExpiresAt: expiresAt,
-		LastUsed:  createdAt,
-		IPAddress: ipAddress,
-		UserAgent: userAgent,
+		SessionID:         sessionID,
+		UserID:            userID,
+		TokenHash:         tokenHash,
+		CreatedAt:         createdAt,
+		ExpiresAt:         expiresAt,
+		LastUsed:          createdAt,
+		IPAddress:         ipAddress,
+		UserAgent:         userAgent,
+		ClientFingerprint: fingerprint,
 	}, nil
 }
 
@@ -235,7 +260,7 @@ func IsSessionValid(db *sql.DB, tokenHash string) (bool, error) {
 	`
 
 	var count int
-	err := db.QueryRow(query, tokenHash, time.Now().Unix()).Scan(&count)
+	err := db.QueryRow(query, tokenHash, clock.Now().Unix()).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check session: %w", err)
 	}
@@ -263,10 +288,91 @@ func RevokeAllUserSessions(db *sql.DB, userID string) error {
 	return nil
 }
 
-// CleanupExpiredSessions removes expired sessions from the database
+// SessionTouchInterval is how stale a session's last_used may get before a
+// request updates it, so busy clients do not write on every request
+const SessionTouchInterval = time.Minute
+
+// TouchSession records that the session behind tokenHash was just used.
+// last_used is read first so requests within the interval never write.
+func TouchSession(db *sql.DB, tokenHash string) error {
+	now := clock.Now().Unix()
+	stale := now - int64(SessionTouchInterval/time.Second)
+
+	var lastUsed sql.NullInt64
+	err := db.QueryRow(`SELECT last_used FROM _wce_sessions WHERE token_hash = ?`, tokenHash).Scan(&lastUsed)
+	if err == sql.ErrNoRows || (err == nil && lastUsed.Valid && lastUsed.Int64 > stale) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read session: %w", err)
+	}
+
+	// Concurrent requests may both see a stale time; only one updates it
+	_, err = db.Exec(`
+		UPDATE _wce_sessions SET last_used = ?
+		WHERE token_hash = ? AND (last_used IS NULL OR last_used <= ?)
+	`, now, tokenHash, stale)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// ListUserSessions returns a user's live sessions, most recently used first.
+// Sessions whose token expired are live while their refresh token is.
+func ListUserSessions(db *sql.DB, userID string) ([]Session, error) {
+	now := clock.Now().Unix()
+	rows, err := db.Query(`
+		SELECT session_id, user_id, token_hash, created_at, expires_at, last_used, ip_address, user_agent, client_fingerprint
+		FROM _wce_sessions
+		WHERE user_id = ? AND (expires_at > ? OR refresh_expires_at > ?)
+		ORDER BY COALESCE(last_used, created_at) DESC, created_at DESC
+	`, userID, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var lastUsed sql.NullInt64
+		var ipAddress, userAgent, fingerprint sql.NullString
+		if err := rows.Scan(&session.SessionID, &session.UserID, &session.TokenHash, &session.CreatedAt, &session.ExpiresAt,
+			&lastUsed, &ipAddress, &userAgent, &fingerprint); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		session.LastUsed = lastUsed.Int64
+		session.IPAddress = ipAddress.String
+		session.UserAgent = userAgent.String
+		session.ClientFingerprint = fingerprint.String
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeUserSession revokes one of a user's sessions by id. Sessions of other
+// users are reported as not found.
+func RevokeUserSession(db *sql.DB, userID, sessionID string) error {
+	result, err := db.Exec(`DELETE FROM _wce_sessions WHERE session_id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("session not found")
+	}
+	return nil
+}
+
+// CleanupExpiredSessions removes expired sessions from the database.
+// Sessions whose refresh token is still valid are kept.
 func CleanupExpiredSessions(db *sql.DB) error {
-	query := `DELETE FROM _wce_sessions WHERE expires_at <= ?`
-	_, err := db.Exec(query, time.Now().Unix())
+	query := `
+		DELETE FROM _wce_sessions
+		WHERE expires_at <= ? AND (refresh_expires_at IS NULL OR refresh_expires_at <= ?)
+	`
+	now := clock.Now().Unix()
+	_, err := db.Exec(query, now, now)
 	if err != nil {
 		return fmt.Errorf("failed to cleanup expired sessions: %w", err)
 	}
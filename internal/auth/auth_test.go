@@ -5,12 +5,13 @@ import (
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 // setupTestDB creates an in-memory SQLite database for testing
 func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
@@ -43,13 +44,63 @@ func setupTestDB(t *testing.T) *sql.DB {
 			expires_at INTEGER NOT NULL,
 			last_used INTEGER,
 			ip_address TEXT,
-			user_agent TEXT
+			user_agent TEXT,
+			client_fingerprint TEXT,
+			refresh_token_hash TEXT,
+			refresh_expires_at INTEGER
 		)
 	`)
 	if err != nil {
 		t.Fatalf("Failed to create _wce_sessions table: %v", err)
 	}
 
+	// Create the _wce_device_codes table
+	_, err = db.Exec(`
+		CREATE TABLE _wce_device_codes (
+			device_code_hash TEXT PRIMARY KEY,
+			user_code TEXT NOT NULL UNIQUE,
+			scope TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			approved_by TEXT,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			last_poll INTEGER
+		)
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create _wce_device_codes table: %v", err)
+	}
+
+	// Create the _wce_password_resets table
+	_, err = db.Exec(`
+		CREATE TABLE _wce_password_resets (
+			token_hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL UNIQUE,
+			created_by TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create _wce_password_resets table: %v", err)
+	}
+
+	// Create the _wce_email_verifications table
+	_, err = db.Exec(`
+		CREATE TABLE _wce_email_verifications (
+			user_id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			token_hash TEXT UNIQUE,
+			required INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			verified_at INTEGER
+		)
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create _wce_email_verifications table: %v", err)
+	}
+
 	return db
 }
 
@@ -380,6 +431,54 @@ func TestIsSessionValid_Expired(t *testing.T) {
 	}
 }
 
+func TestSessionExpiry_FakeClock(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	defer clock.Set(fake)()
+	defer clock.SetIDs(clock.NewSequence("sessions"))()
+
+	user, err := CreateUser(db, "testuser", "password123", RoleAdmin, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	session, err := CreateSession(db, user.UserID, "hash", "127.0.0.1", "test-agent", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if session.CreatedAt != 1_700_000_000 || session.ExpiresAt != 1_700_003_600 {
+		t.Errorf("Expected session times from the fake clock, got %d and %d", session.CreatedAt, session.ExpiresAt)
+	}
+
+	fake.Advance(59 * time.Minute)
+	if valid, _ := IsSessionValid(db, "hash"); !valid {
+		t.Error("Session should be valid before it expires")
+	}
+	fake.Advance(time.Minute)
+	if valid, _ := IsSessionValid(db, "hash"); valid {
+		t.Error("Session should be invalid once it expires")
+	}
+
+	// The same sequence produces the same ids
+	clock.SetIDs(clock.NewSequence("ids"))
+	first, _ := GenerateUUID()
+	clock.SetIDs(clock.NewSequence("ids"))
+	second, _ := GenerateUUID()
+	if first != second {
+		t.Errorf("Expected the same UUID from the same sequence, got %s and %s", first, second)
+	}
+
+	// Secrets never come from the installed generator
+	clock.SetIDs(clock.NewSequence("ids"))
+	first, _ = GenerateSessionID()
+	clock.SetIDs(clock.NewSequence("ids"))
+	second, _ = GenerateSessionID()
+	if first == second {
+		t.Error("Expected session ids from crypto/rand, not the id sequence")
+	}
+}
+
 func TestRevokeSession(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -496,3 +595,32 @@ func TestCleanupExpiredSessions(t *testing.T) {
 		t.Error("Valid session should not be cleaned up")
 	}
 }
+
+func TestClientFingerprint(t *testing.T) {
+	base := ClientFingerprint("192.0.2.10:1234", "wce-cli/1.0")
+
+	tests := []struct {
+		name      string
+		ipAddress string
+		userAgent string
+		same      bool
+	}{
+		{"SameNetworkIPv4", "192.0.2.200:9999", "wce-cli/1.0", true},
+		{"BareAddress", "192.0.2.10", "wce-cli/1.0", true},
+		{"OtherNetworkIPv4", "192.0.3.10:1234", "wce-cli/1.0", false},
+		{"OtherUserAgent", "192.0.2.10:1234", "Mozilla/5.0", false},
+		{"IPv6", "[2001:db8::1]:1234", "wce-cli/1.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClientFingerprint(tt.ipAddress, tt.userAgent); (got == base) != tt.same {
+				t.Errorf("Expected same=%v for %s %q", tt.same, tt.ipAddress, tt.userAgent)
+			}
+		})
+	}
+
+	if ClientFingerprint("[2001:db8:1:2::1]:80", "x") != ClientFingerprint("[2001:db8:1:ffff::9]:443", "x") {
+		t.Error("Expected IPv6 addresses in the same /48 to match")
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ClientFingerprint summarises the context a request came from: the network
+// prefix of ipAddress (/24 for IPv4, /48 for IPv6) and the user agent, hashed
+// with SHA-256. Using the prefix rather than the full address lets a client
+// move within its network without losing its session.
+func ClientFingerprint(ipAddress, userAgent string) string {
+	sum := sha256.Sum256([]byte(ClientPrefix(ipAddress) + "\n" + strings.TrimSpace(userAgent)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientPrefix returns the network prefix of a client address, with or
+// without a port: /24 for IPv4 and /48 for IPv6. Unparseable addresses are
+// returned as they are.
+func ClientPrefix(ipAddress string) string {
+	host := ipAddress
+	if h, _, err := net.SplitHostPort(ipAddress); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// GetSessionFingerprint returns the client fingerprint recorded when the
+// session was created, or "" for sessions that predate fingerprinting
+func GetSessionFingerprint(db *sql.DB, tokenHash string) (string, error) {
+	var fingerprint sql.NullString
+	err := db.QueryRow(`
+		SELECT client_fingerprint FROM _wce_sessions WHERE token_hash = ?
+	`, tokenHash).Scan(&fingerprint)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("session not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get session fingerprint: %w", err)
+	}
+	return fingerprint.String, nil
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+const (
+	// DeviceCodeLifetime is how long a device has to be approved
+	DeviceCodeLifetime = 10 * time.Minute
+
+	// DevicePollInterval is the minimum time between token polls
+	DevicePollInterval = 5 * time.Second
+)
+
+// Device flow polling outcomes, named after the RFC 8628 error codes
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+)
+
+// userCodeAlphabet omits vowels and look-alike characters so codes are easy
+// to type and cannot spell words
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// DeviceCode is a pending device authorization. DeviceCode is the secret the
+// device polls with; UserCode is what the user confirms in a browser.
+type DeviceCode struct {
+	DeviceCode string
+	UserCode   string
+	Scope      string
+	ExpiresAt  int64
+}
+
+// PendingDevice describes a device authorization awaiting confirmation
+type PendingDevice struct {
+	UserCode  string
+	Scope     string
+	ExpiresAt int64
+}
+
+// CreateDeviceCode starts a device authorization. scope is stored for the
+// token issued on approval; empty requests an unrestricted token.
+func CreateDeviceCode(db *sql.DB, scope string) (*DeviceCode, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate device code: %w", err)
+	}
+	deviceCode := hex.EncodeToString(secret)
+
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	expiresAt := now.Add(DeviceCodeLifetime).Unix()
+
+	// Expired codes are dropped so their user codes can be reused
+	if _, err := db.Exec(`DELETE FROM _wce_device_codes WHERE expires_at <= ?`, now.Unix()); err != nil {
+		return nil, fmt.Errorf("failed to clean up device codes: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO _wce_device_codes (device_code_hash, user_code, scope, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, hashDeviceCode(deviceCode), userCode, scope, now.Unix(), expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store device code: %w", err)
+	}
+
+	return &DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		Scope:      scope,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// GetPendingDevice looks up an unexpired, undecided device authorization by
+// its user code
+func GetPendingDevice(db *sql.DB, userCode string) (*PendingDevice, error) {
+	device := &PendingDevice{}
+	err := db.QueryRow(`
+		SELECT user_code, scope, expires_at FROM _wce_device_codes
+		WHERE user_code = ? AND status = 'pending' AND expires_at > ?
+	`, NormalizeUserCode(userCode), clock.Now().Unix()).Scan(&device.UserCode, &device.Scope, &device.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown or expired code")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device code: %w", err)
+	}
+	return device, nil
+}
+
+// ApproveDeviceCode grants a pending device authorization to userID
+func ApproveDeviceCode(db *sql.DB, userCode, userID string) error {
+	return decideDeviceCode(db, userCode, "approved", userID)
+}
+
+// DenyDeviceCode refuses a pending device authorization
+func DenyDeviceCode(db *sql.DB, userCode string) error {
+	return decideDeviceCode(db, userCode, "denied", "")
+}
+
+func decideDeviceCode(db *sql.DB, userCode, status, userID string) error {
+	var approvedBy interface{}
+	if userID != "" {
+		approvedBy = userID
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_device_codes SET status = ?, approved_by = ?
+		WHERE user_code = ? AND status = 'pending' AND expires_at > ?
+	`, status, approvedBy, NormalizeUserCode(userCode), clock.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to update device code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("unknown or expired code")
+	}
+
+	return nil
+}
+
+// PollDeviceCode checks a device authorization. Once approved it returns the
+// approving user and requested scope, and the code cannot be used again.
+// Otherwise it returns one of the Err* polling outcomes.
+func PollDeviceCode(db *sql.DB, deviceCode string) (userID, scope string, err error) {
+	hash := hashDeviceCode(deviceCode)
+	now := clock.Now()
+
+	var status string
+	var approvedBy sql.NullString
+	var expiresAt int64
+	var lastPoll sql.NullInt64
+	err = db.QueryRow(`
+		SELECT status, approved_by, scope, expires_at, last_poll FROM _wce_device_codes
+		WHERE device_code_hash = ?
+	`, hash).Scan(&status, &approvedBy, &scope, &expiresAt, &lastPoll)
+	if err == sql.ErrNoRows {
+		return "", "", ErrExpiredToken
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get device code: %w", err)
+	}
+
+	if now.Unix() >= expiresAt {
+		db.Exec(`DELETE FROM _wce_device_codes WHERE device_code_hash = ?`, hash)
+		return "", "", ErrExpiredToken
+	}
+
+	switch status {
+	case "approved":
+		// Deleting claims the code, so concurrent polls get one token between them
+		result, err := db.Exec(`DELETE FROM _wce_device_codes WHERE device_code_hash = ?`, hash)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to consume device code: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return "", "", ErrExpiredToken
+		}
+		return approvedBy.String, scope, nil
+	case "denied":
+		db.Exec(`DELETE FROM _wce_device_codes WHERE device_code_hash = ?`, hash)
+		return "", "", ErrAccessDenied
+	}
+
+	db.Exec(`UPDATE _wce_device_codes SET last_poll = ? WHERE device_code_hash = ?`, now.Unix(), hash)
+	if lastPoll.Valid && now.Sub(time.Unix(lastPoll.Int64, 0)) < DevicePollInterval {
+		return "", "", ErrSlowDown
+	}
+	return "", "", ErrAuthorizationPending
+}
+
+// NormalizeUserCode uppercases a user code and restores its hyphen, so codes
+// typed as "bcdf ghjk" or "BCDFGHJK" match
+func NormalizeUserCode(code string) string {
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+// generateUserCode returns a random code of the form "BCDF-GHJK"
+func generateUserCode() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate user code: %w", err)
+	}
+	code := make([]byte, 8)
+	for i, b := range random {
+		code[i] = userCodeAlphabet[int(b)%len(userCodeAlphabet)]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// hashDeviceCode is the stored form of a device code
+func hashDeviceCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	code, err := CreateDeviceCode(db, "documents:read")
+	if err != nil {
+		t.Fatalf("CreateDeviceCode failed: %v", err)
+	}
+	if !regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`).MatchString(code.UserCode) {
+		t.Errorf("Unexpected user code format: %s", code.UserCode)
+	}
+
+	if _, _, err := PollDeviceCode(db, code.DeviceCode); !errors.Is(err, ErrAuthorizationPending) {
+		t.Errorf("Expected authorization_pending, got %v", err)
+	}
+	if _, _, err := PollDeviceCode(db, code.DeviceCode); !errors.Is(err, ErrSlowDown) {
+		t.Errorf("Expected slow_down for a fast poll, got %v", err)
+	}
+
+	// Codes are accepted however the user types them
+	typed := code.UserCode[:4] + " " + code.UserCode[5:]
+	device, err := GetPendingDevice(db, typed)
+	if err != nil || device.Scope != "documents:read" {
+		t.Fatalf("GetPendingDevice failed: %+v %v", device, err)
+	}
+	if err := ApproveDeviceCode(db, typed, "user-1"); err != nil {
+		t.Fatalf("ApproveDeviceCode failed: %v", err)
+	}
+	if err := DenyDeviceCode(db, code.UserCode); err == nil {
+		t.Error("Expected a decided code to stay decided")
+	}
+
+	userID, scope, err := PollDeviceCode(db, code.DeviceCode)
+	if err != nil || userID != "user-1" || scope != "documents:read" {
+		t.Fatalf("Expected approval, got %q %q %v", userID, scope, err)
+	}
+	if _, _, err := PollDeviceCode(db, code.DeviceCode); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Expected a used code to be gone, got %v", err)
+	}
+
+	denied, _ := CreateDeviceCode(db, "")
+	if err := DenyDeviceCode(db, denied.UserCode); err != nil {
+		t.Fatalf("DenyDeviceCode failed: %v", err)
+	}
+	if _, _, err := PollDeviceCode(db, denied.DeviceCode); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected access_denied, got %v", err)
+	}
+
+	expired, _ := CreateDeviceCode(db, "")
+	db.Exec(`UPDATE _wce_device_codes SET expires_at = 0`)
+	if _, _, err := PollDeviceCode(db, expired.DeviceCode); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Expected expired_token, got %v", err)
+	}
+	if err := ApproveDeviceCode(db, expired.UserCode, "user-1"); err == nil {
+		t.Error("Expected expired code to be refused")
+	}
+}
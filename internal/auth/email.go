@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// DefaultVerificationTimeout is how long an email verification token works
+const DefaultVerificationTimeout = 48 * time.Hour
+
+// ErrInvalidVerificationToken is returned for verification tokens that are
+// unknown, used, expired or for an address the user no longer has
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+// CreateEmailVerification issues a token proving the user receives mail at
+// email, replacing any earlier one and any earlier verification. When
+// required, the user cannot log in until the address is verified; a
+// verification once required stays required. Only the token's hash is stored.
+func CreateEmailVerification(db *sql.DB, userID, email string, required bool, expiresIn time.Duration) (string, error) {
+	token, err := GenerateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	now := clock.Now()
+	_, err = db.Exec(`
+		INSERT INTO _wce_email_verifications (user_id, email, token_hash, required, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			email = excluded.email,
+			token_hash = excluded.token_hash,
+			required = MAX(required, excluded.required),
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at,
+			verified_at = NULL
+	`, userID, email, GetTokenHash(token), required, now.Unix(), now.Add(expiresIn).Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to create email verification: %w", err)
+	}
+	return token, nil
+}
+
+// VerifyEmail marks the address a token was issued for as verified and
+// returns its user. The token works once.
+func VerifyEmail(db *sql.DB, token string) (*User, error) {
+	var userID, email string
+	err := db.QueryRow(`
+		UPDATE _wce_email_verifications SET token_hash = NULL, verified_at = ?
+		WHERE token_hash = ? AND expires_at > ?
+		RETURNING user_id, email
+	`, clock.Now().Unix(), GetTokenHash(token), clock.Now().Unix()).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(user.Email, email) {
+		return nil, ErrInvalidVerificationToken
+	}
+	return user, nil
+}
+
+// IsEmailVerified reports whether a user has verified their current address
+func IsEmailVerified(db *sql.DB, user *User) (bool, error) {
+	if user.Email == "" {
+		return false, nil
+	}
+	var email string
+	err := db.QueryRow(`
+		SELECT email FROM _wce_email_verifications WHERE user_id = ? AND verified_at IS NOT NULL
+	`, user.UserID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check email verification: %w", err)
+	}
+	return strings.EqualFold(email, user.Email), nil
+}
+
+// PendingEmailVerification reports whether a user must verify their address
+// before logging in, and whether the token sent for it has expired
+func PendingEmailVerification(db *sql.DB, userID string) (pending, expired bool, err error) {
+	var expiresAt int64
+	err = db.QueryRow(`
+		SELECT expires_at FROM _wce_email_verifications
+		WHERE user_id = ? AND required = 1 AND verified_at IS NULL
+	`, userID).Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to check email verification: %w", err)
+	}
+	return true, expiresAt <= clock.Now().Unix(), nil
+}
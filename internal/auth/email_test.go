@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+func TestEmailVerification(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	defer clock.Set(fake)()
+
+	user, err := CreateUser(db, "testuser", "password123", RoleOwner, "Test@Example.com", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if pending, _, _ := PendingEmailVerification(db, user.UserID); pending {
+		t.Error("Expected no verification pending before one is sent")
+	}
+	token, err := CreateEmailVerification(db, user.UserID, user.Email, true, DefaultVerificationTimeout)
+	if err != nil {
+		t.Fatalf("CreateEmailVerification failed: %v", err)
+	}
+	if pending, expired, _ := PendingEmailVerification(db, user.UserID); !pending || expired {
+		t.Errorf("Expected a pending verification, got pending=%v expired=%v", pending, expired)
+	}
+
+	// Reissuing replaces the token but a required verification stays required
+	fake.Advance(DefaultVerificationTimeout)
+	if pending, expired, _ := PendingEmailVerification(db, user.UserID); !pending || !expired {
+		t.Errorf("Expected an expired verification, got pending=%v expired=%v", pending, expired)
+	}
+	if _, err := VerifyEmail(db, token); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Expected an expired token to be invalid, got %v", err)
+	}
+	token, _ = CreateEmailVerification(db, user.UserID, user.Email, false, DefaultVerificationTimeout)
+	if pending, _, _ := PendingEmailVerification(db, user.UserID); !pending {
+		t.Error("Expected the verification to stay required")
+	}
+
+	verified, err := VerifyEmail(db, token)
+	if err != nil || verified.UserID != user.UserID {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if ok, _ := IsEmailVerified(db, user); !ok {
+		t.Error("Expected the address verified")
+	}
+	if pending, _, _ := PendingEmailVerification(db, user.UserID); pending {
+		t.Error("Expected no verification pending once verified")
+	}
+	if _, err := VerifyEmail(db, token); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Expected a used token to be invalid, got %v", err)
+	}
+
+	// Changing the address undoes the verification, and old links stop working
+	token, _ = CreateEmailVerification(db, user.UserID, user.Email, false, DefaultVerificationTimeout)
+	if err := UpdateUser(db, user.UserID, user.Role, "other@example.com", true); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	user, _ = GetUserByID(db, user.UserID)
+	if ok, _ := IsEmailVerified(db, user); ok {
+		t.Error("Expected a changed address to be unverified")
+	}
+	if _, err := VerifyEmail(db, token); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Expected a token for the old address to be invalid, got %v", err)
+	}
+}
+
+func TestFindUsersByEmail(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	alice, _ := CreateUser(db, "alice", "password123", RoleEditor, "shared@example.com", "")
+	bob, _ := CreateUser(db, "bob", "password123", RoleEditor, "Shared@Example.com", "")
+	CreateUser(db, "carol", "password123", RoleEditor, "carol@example.com", "")
+	UpdateUser(db, bob.UserID, bob.Role, bob.Email, false)
+
+	users, err := FindUsersByEmail(db, "SHARED@example.com")
+	if err != nil {
+		t.Fatalf("FindUsersByEmail failed: %v", err)
+	}
+	if len(users) != 1 || users[0].UserID != alice.UserID {
+		t.Errorf("Expected only the enabled match, got %+v", users)
+	}
+}
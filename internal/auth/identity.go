@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// ContextKeyIdentity is the context key for the Identity of a request
+const ContextKeyIdentity contextKey = "identity"
+
+// Identity is who a request is made by, built once from its validated token
+// and handed to every subsystem that needs to know: handlers, Starlark
+// scripts and templates
+type Identity struct {
+	UserID    string   `json:"id"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role"`
+	Scopes    []string `json:"scopes"` // Empty for unrestricted tokens
+	SessionID string   `json:"session_id"`
+}
+
+// NewIdentity returns the identity carried by validated claims
+func NewIdentity(claims *Claims) *Identity {
+	return &Identity{
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Role:      claims.Role,
+		Scopes:    strings.Fields(claims.Scope),
+		SessionID: claims.SessionID,
+	}
+}
+
+// IsScoped reports whether the identity's token is limited to its Scopes
+func (id *Identity) IsScoped() bool {
+	return len(id.Scopes) > 0
+}
+
+// Map returns the identity as template variables. A nil identity is the
+// anonymous one, with authenticated false and every other field empty.
+func (id *Identity) Map() map[string]interface{} {
+	if id == nil {
+		id = &Identity{}
+	}
+	scopes := make([]interface{}, len(id.Scopes))
+	for i, scope := range id.Scopes {
+		scopes[i] = scope
+	}
+	return map[string]interface{}{
+		"authenticated": id.UserID != "",
+		"id":            id.UserID,
+		"username":      id.Username,
+		"role":          id.Role,
+		"scopes":        scopes,
+		"session_id":    id.SessionID,
+	}
+}
+
+// WithIdentity returns a copy of ctx carrying identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, ContextKeyIdentity, identity)
+}
+
+// IdentityFromContext retrieves the identity of a request from its context
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(ContextKeyIdentity).(*Identity)
+	return identity, ok && identity != nil
+}
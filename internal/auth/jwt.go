@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/thetanil/wce/internal/clock"
 )
 
 // JWT claims structure
@@ -19,23 +21,41 @@ type Claims struct {
 	IssuedAt int64  `json:"iat"`
 	ExpiresAt int64  `json:"exp"`
 	SessionID string `json:"jti"` // JWT ID for session tracking
+	Scope     string `json:"scope,omitempty"` // Space-separated scopes; empty is unrestricted
 }
 
 // JWTManager handles JWT token operations
 type JWTManager struct {
-	secret []byte
+	keys *JWTKeySet
 }
 
 // NewJWTManager creates a new JWT manager with the given secret
 func NewJWTManager(secret string) *JWTManager {
+	return NewJWTManagerWithKeys(StaticJWTKeys(secret))
+}
+
+// NewJWTManagerWithKeys creates a JWT manager signing with the key set's
+// current key and accepting tokens signed by any of its keys
+func NewJWTManagerWithKeys(keys *JWTKeySet) *JWTManager {
 	return &JWTManager{
-		secret: []byte(secret),
+		keys: keys,
 	}
 }
 
+// Keys returns the manager's key set
+func (j *JWTManager) Keys() *JWTKeySet {
+	return j.keys
+}
+
 // GenerateToken creates a new JWT token for the given claims
 func (j *JWTManager) GenerateToken(userID, username, cenvID, role, sessionID string, expiresIn time.Duration) (string, error) {
-	now := time.Now()
+	return j.GenerateScopedToken(userID, username, cenvID, role, sessionID, nil, expiresIn)
+}
+
+// GenerateScopedToken creates a JWT limited to scopes. A nil scopes list
+// creates an unrestricted token.
+func (j *JWTManager) GenerateScopedToken(userID, username, cenvID, role, sessionID string, scopes []string, expiresIn time.Duration) (string, error) {
+	now := clock.Now()
 	claims := Claims{
 		UserID:    userID,
 		Username:  username,
@@ -44,12 +64,15 @@ func (j *JWTManager) GenerateToken(userID, username, cenvID, role, sessionID str
 		SessionID: sessionID,
 		IssuedAt:  now.Unix(),
 		ExpiresAt: now.Add(expiresIn).Unix(),
+		Scope:     strings.Join(scopes, " "),
 	}
 
 	// Create header
+	kid, secret := j.keys.signingKey()
 	header := map[string]string{
 		"alg": "HS256",
 		"typ": "JWT",
+		"kid": kid,
 	}
 
 	// Encode header
@@ -68,7 +91,7 @@ func (j *JWTManager) GenerateToken(userID, username, cenvID, role, sessionID str
 
 	// Create signature
 	message := headerEncoded + "." + payloadEncoded
-	signature := j.sign(message)
+	signature := sign(secret, message)
 	signatureEncoded := base64.RawURLEncoding.EncodeToString(signature)
 
 	// Combine all parts
@@ -89,9 +112,29 @@ func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	payloadEncoded := parts[1]
 	signatureEncoded := parts[2]
 
+	// Find the signing key the header names
+	headerJSON, err := base64.RawURLEncoding.DecodeString(headerEncoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal header: %w", err)
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported token algorithm")
+	}
+	secret, ok := j.keys.verifyingKey(header.Kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown token signing key")
+	}
+
 	// Verify signature
 	message := headerEncoded + "." + payloadEncoded
-	expectedSignature := j.sign(message)
+	expectedSignature := sign(secret, message)
 	expectedSignatureEncoded := base64.RawURLEncoding.EncodeToString(expectedSignature)
 
 	if !hmac.Equal([]byte(signatureEncoded), []byte(expectedSignatureEncoded)) {
@@ -111,7 +154,7 @@ func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	// Check expiration
-	if time.Now().Unix() > claims.ExpiresAt {
+	if clock.Now().Unix() > claims.ExpiresAt {
 		return nil, fmt.Errorf("token has expired")
 	}
 
@@ -119,8 +162,8 @@ func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 }
 
 // sign creates HMAC-SHA256 signature for the given message
-func (j *JWTManager) sign(message string) []byte {
-	h := hmac.New(sha256.New, j.secret)
+func sign(secret []byte, message string) []byte {
+	h := hmac.New(sha256.New, secret)
 	h.Write([]byte(message))
 	return h.Sum(nil)
 }
@@ -1,9 +1,13 @@
 package auth
 
 import (
+	"encoding/base64"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/thetanil/wce/internal/clock"
 )
 
 func TestGenerateToken(t *testing.T) {
@@ -134,3 +138,123 @@ func TestGetTokenHash(t *testing.T) {
 		t.Error("Different tokens produced same hash")
 	}
 }
+
+func TestScopedToken(t *testing.T) {
+	jwtManager := NewJWTManager("test-secret-key")
+
+	token, err := jwtManager.GenerateScopedToken("user-123", "testuser", "cenv-456", RoleAdmin, "session-789",
+		[]string{ScopeDocumentsWrite, ScopeAdminNone}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := jwtManager.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	if !claims.IsScoped() {
+		t.Error("Expected token to be scoped")
+	}
+	if !claims.HasScope(ScopeDocumentsWrite) || !claims.HasScope(ScopeDocumentsRead) {
+		t.Error("Expected documents:write to include documents:read")
+	}
+	if claims.HasScope(ScopeStarExecute) || claims.HasScope(ScopeAdminRead) {
+		t.Error("Expected scopes outside the claim to be refused")
+	}
+
+	unscoped := &Claims{}
+	if unscoped.IsScoped() || !unscoped.HasScope(ScopeAdminWrite) {
+		t.Error("Expected unscoped tokens to be unrestricted")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("documents:read  star:execute documents:read")
+	if err != nil {
+		t.Fatalf("ParseScopes failed: %v", err)
+	}
+	if strings.Join(scopes, " ") != "documents:read star:execute" {
+		t.Errorf("Unexpected scopes: %v", scopes)
+	}
+
+	for _, scope := range []string{"", "documents:delete", "admin:none admin:read"} {
+		if _, err := ParseScopes(scope); err == nil {
+			t.Errorf("Expected ParseScopes(%q) to fail", scope)
+		}
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt.keys")
+	keys, err := LoadOrCreateJWTKeys(path)
+	if err != nil {
+		t.Fatalf("Failed to create keys: %v", err)
+	}
+	jwtManager := NewJWTManagerWithKeys(keys)
+	before, _ := jwtManager.GenerateToken("user-123", "testuser", "cenv-456", RoleAdmin, "session-1", time.Hour)
+
+	// A second instance sharing the key file, e.g. after a restart
+	reloaded, err := LoadOrCreateJWTKeys(path)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	other := NewJWTManagerWithKeys(reloaded)
+	if _, err := other.ValidateToken(before); err != nil {
+		t.Errorf("Expected the token valid after reloading the keys: %v", err)
+	}
+
+	first := keys.List()[0].ID
+	second, err := keys.Rotate(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	after, _ := jwtManager.GenerateToken("user-123", "testuser", "cenv-456", RoleAdmin, "session-2", time.Hour)
+	if !strings.Contains(after, ".") || strings.Split(after, ".")[0] == strings.Split(before, ".")[0] {
+		t.Error("Expected the new token to name the new key")
+	}
+	for _, token := range []string{before, after} {
+		if _, err := jwtManager.ValidateToken(token); err != nil {
+			t.Errorf("Expected tokens of both keys valid: %v", err)
+		}
+	}
+	// The other instance picks up the rotation from the file
+	if _, err := other.ValidateToken(after); err != nil {
+		t.Errorf("Expected the other instance to reload the rotated keys: %v", err)
+	}
+
+	// Retired keys are dropped once retained long enough
+	fake := clock.NewFake(time.Now().Add(48 * time.Hour))
+	defer clock.Set(fake)()
+	keys.Rotate(24 * time.Hour)
+	list := keys.List()
+	if len(list) != 2 || list[1].ID != second || list[0].ID == first {
+		t.Errorf("Expected the first key dropped, got %+v", list)
+	}
+	if _, err := jwtManager.ValidateToken(before); err == nil {
+		t.Error("Expected a token of a dropped key refused")
+	}
+
+	if _, err := StaticJWTKeys("configured-secret").Rotate(time.Hour); err != ErrStaticJWTKeys {
+		t.Errorf("Expected configured keys not rotatable, got %v", err)
+	}
+}
+
+func TestStaticJWTKeys(t *testing.T) {
+	previous := NewJWTManager("old-secret")
+	token, _ := previous.GenerateToken("user-123", "testuser", "cenv-456", RoleAdmin, "session-1", time.Hour)
+
+	jwtManager := NewJWTManagerWithKeys(StaticJWTKeys("new-secret", "old-secret"))
+	if _, err := jwtManager.ValidateToken(token); err != nil {
+		t.Errorf("Expected a previous secret still accepted: %v", err)
+	}
+	if _, err := NewJWTManager("new-secret").ValidateToken(token); err == nil {
+		t.Error("Expected a dropped secret refused")
+	}
+
+	// Tokens must not choose their own algorithm
+	parts := strings.Split(token, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+	if _, err := jwtManager.ValidateToken(unsigned); err == nil {
+		t.Error("Expected an unsigned token refused")
+	}
+}
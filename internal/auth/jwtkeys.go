@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// ErrStaticJWTKeys is returned when rotating keys that come from
+// configuration rather than a key file
+var ErrStaticJWTKeys = errors.New("JWT keys are set by configuration")
+
+// JWTKeySet holds the HMAC keys that sign and verify tokens. The current key
+// signs; keys it replaced still verify tokens issued before a rotation until
+// they are pruned. Each token names its key in the kid header.
+type JWTKeySet struct {
+	path string // Key file; empty for keys from configuration
+
+	mu      sync.RWMutex
+	current string
+	keys    map[string]jwtKey
+	checked time.Time // When the key file was last read for an unknown key
+}
+
+// jwtReloadInterval limits how often tokens naming unknown keys make a key
+// set re-read its file
+const jwtReloadInterval = time.Second
+
+type jwtKey struct {
+	secret    []byte
+	createdAt int64
+	retiredAt int64 // 0 while current
+}
+
+// JWTKeyInfo describes a key without its secret
+type JWTKeyInfo struct {
+	ID        string `json:"id"`
+	Current   bool   `json:"current"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	RetiredAt int64  `json:"retired_at,omitempty"`
+}
+
+// jwtKeyFile is the on-disk format of a JWTKeySet
+type jwtKeyFile struct {
+	Current string                `json:"current"`
+	Keys    map[string]jwtFileKey `json:"keys"`
+}
+
+type jwtFileKey struct {
+	Secret    string `json:"secret"` // Hex
+	CreatedAt int64  `json:"created_at"`
+	RetiredAt int64  `json:"retired_at,omitempty"`
+}
+
+// StaticJWTKeys returns a key set of configured secrets. The first signs and
+// the rest only verify, so a secret being replaced can be listed after its
+// successor until the tokens it signed expire. Key ids are derived from the
+// secrets, so instances configured alike agree on them.
+func StaticJWTKeys(secrets ...string) *JWTKeySet {
+	k := &JWTKeySet{keys: map[string]jwtKey{}}
+	for i, secret := range secrets {
+		sum := sha256.Sum256([]byte(secret))
+		id := hex.EncodeToString(sum[:4])
+		if _, ok := k.keys[id]; ok {
+			continue
+		}
+		k.keys[id] = jwtKey{secret: []byte(secret)}
+		if i == 0 {
+			k.current = id
+		}
+	}
+	return k
+}
+
+// LoadOrCreateJWTKeys reads the key file at path, creating it with a new key
+// if it does not exist
+func LoadOrCreateJWTKeys(path string) (*JWTKeySet, error) {
+	k := &JWTKeySet{path: path, keys: map[string]jwtKey{}}
+
+	current, keys, err := readJWTKeys(path)
+	if os.IsNotExist(err) {
+		if _, err := k.Rotate(0); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.current, k.keys = current, keys
+	return k, nil
+}
+
+// readJWTKeys reads a key file, returning its current key id and its keys
+func readJWTKeys(path string) (string, map[string]jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read JWT key file: %w", err)
+	}
+
+	var file jwtKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return "", nil, fmt.Errorf("invalid JWT key file: %w", err)
+	}
+	keys := make(map[string]jwtKey, len(file.Keys))
+	for id, stored := range file.Keys {
+		secret, err := hex.DecodeString(stored.Secret)
+		if err != nil || len(secret) < 32 {
+			return "", nil, fmt.Errorf("invalid JWT key %s", id)
+		}
+		keys[id] = jwtKey{secret: secret, createdAt: stored.CreatedAt, retiredAt: stored.RetiredAt}
+	}
+	if _, ok := keys[file.Current]; !ok {
+		return "", nil, fmt.Errorf("JWT key file has no current key")
+	}
+	return file.Current, keys, nil
+}
+
+// reload re-reads the key file, at most once per jwtReloadInterval, and
+// reports whether it did
+func (k *JWTKeySet) reload() bool {
+	now := clock.Now()
+	k.mu.Lock()
+	if now.Sub(k.checked) < jwtReloadInterval && !now.Before(k.checked) {
+		k.mu.Unlock()
+		return false
+	}
+	k.checked = now
+	k.mu.Unlock()
+
+	current, keys, err := readJWTKeys(k.path)
+	if err != nil {
+		return false
+	}
+	k.mu.Lock()
+	k.current, k.keys = current, keys
+	k.mu.Unlock()
+	return true
+}
+
+// Rotate makes a new key current and saves the key file. The key it replaces
+// keeps verifying tokens; keys retired more than retain ago are dropped, so
+// retain should be at least the longest token lifetime. Rotation starts from
+// the key file as it is now, in case another instance sharing it rotated.
+func (k *JWTKeySet) Rotate(retain time.Duration) (string, error) {
+	if k.path == "" {
+		return "", ErrStaticJWTKeys
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate JWT key: %w", err)
+	}
+	idBytes := make([]byte, 4)
+	rand.Read(idBytes)
+	id := hex.EncodeToString(idBytes)
+	now := clock.Now().Unix()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	current, previous := k.current, k.keys
+	if fileCurrent, fileKeys, err := readJWTKeys(k.path); err == nil {
+		current, previous = fileCurrent, fileKeys
+	}
+	keys := make(map[string]jwtKey, len(previous)+1)
+	for oldID, key := range previous {
+		if oldID == current {
+			key.retiredAt = now
+		}
+		if key.retiredAt == 0 || key.retiredAt >= now-int64(retain/time.Second) {
+			keys[oldID] = key
+		}
+	}
+	keys[id] = jwtKey{secret: secret, createdAt: now}
+
+	if err := saveJWTKeys(k.path, id, keys); err != nil {
+		return "", err
+	}
+	k.current, k.keys = id, keys
+	return id, nil
+}
+
+// saveJWTKeys writes the key file, readable by the owner only
+func saveJWTKeys(path, current string, keys map[string]jwtKey) error {
+	file := jwtKeyFile{Current: current, Keys: map[string]jwtFileKey{}}
+	for id, key := range keys {
+		file.Keys[id] = jwtFileKey{
+			Secret:    hex.EncodeToString(key.secret),
+			CreatedAt: key.createdAt,
+			RetiredAt: key.retiredAt,
+		}
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JWT key file: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write JWT key file: %w", err)
+	}
+	return nil
+}
+
+// Rotatable reports whether the keys come from a key file, and so can be
+// rotated
+func (k *JWTKeySet) Rotatable() bool {
+	return k.path != ""
+}
+
+// List describes the keys, the current one first
+func (k *JWTKeySet) List() []JWTKeyInfo {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	list := make([]JWTKeyInfo, 0, len(k.keys))
+	for id, key := range k.keys {
+		list = append(list, JWTKeyInfo{
+			ID:        id,
+			Current:   id == k.current,
+			CreatedAt: key.createdAt,
+			RetiredAt: key.retiredAt,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Current != list[j].Current {
+			return list[i].Current
+		}
+		return list[i].RetiredAt > list[j].RetiredAt
+	})
+	return list
+}
+
+// signingKey returns the current key and its id
+func (k *JWTKeySet) signingKey() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current].secret
+}
+
+// verifyingKey returns the key id names, or the current key for tokens
+// issued without a kid. A key file is re-read for an unknown id, as another
+// instance sharing it may have rotated.
+func (k *JWTKeySet) verifyingKey(id string) ([]byte, bool) {
+	k.mu.RLock()
+	if id == "" {
+		id = k.current
+	}
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if ok || k.path == "" || !k.reload() {
+		return key.secret, ok
+	}
+
+	k.mu.RLock()
+	key, ok = k.keys[id]
+	k.mu.RUnlock()
+	return key.secret, ok
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// MinPasswordLength is the shortest password accepted
+const MinPasswordLength = 8
+
+// DefaultResetTimeout is how long a password reset token can be used
+const DefaultResetTimeout = time.Hour
+
+// Password change and reset failures
+var (
+	ErrWrongPassword     = errors.New("current password is incorrect")
+	ErrPasswordTooShort  = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrPasswordUnchanged = errors.New("new password must differ from the current one")
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+)
+
+// ChangePassword sets a user's password after checking their current one,
+// and revokes every session but the one behind keepTokenHash, so a leaked
+// password or token stops working everywhere else
+func ChangePassword(db *sql.DB, user *User, currentPassword, newPassword, keepTokenHash string) error {
+	if VerifyPassword(currentPassword, user.PasswordHash) != nil {
+		return ErrWrongPassword
+	}
+	if len(newPassword) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if newPassword == currentPassword {
+		return ErrPasswordUnchanged
+	}
+
+	if err := storePassword(db, user, newPassword); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM _wce_sessions WHERE user_id = ? AND token_hash != ?`, user.UserID, keepTokenHash); err != nil {
+		return fmt.Errorf("failed to revoke other sessions: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordReset issues a one-time token that sets userID's password,
+// replacing any reset pending for them. Only its hash is stored.
+func CreatePasswordReset(db *sql.DB, userID, createdBy string, expiresIn time.Duration) (string, int64, error) {
+	token, err := GenerateSessionID()
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := clock.Now()
+	expiresAt := now.Add(expiresIn).Unix()
+	_, err = db.Exec(`
+		INSERT OR REPLACE INTO _wce_password_resets (token_hash, user_id, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, GetTokenHash(token), userID, createdBy, now.Unix(), expiresAt)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// LastPasswordReset returns when the pending reset of a user was issued, or
+// zero when there is none
+func LastPasswordReset(db *sql.DB, userID string) (int64, error) {
+	var createdAt int64
+	err := db.QueryRow(`SELECT created_at FROM _wce_password_resets WHERE user_id = ?`, userID).Scan(&createdAt)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to look up password reset: %w", err)
+	}
+	return createdAt, nil
+}
+
+// ResetPassword uses a reset token to set its user's password. The token is
+// consumed and the user's sessions are revoked. Unknown, used and expired
+// tokens give ErrInvalidResetToken.
+func ResetPassword(db *sql.DB, token, newPassword string) (*User, error) {
+	if len(newPassword) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	// Deleting claims the token, so it works once even under concurrent use
+	var userID string
+	err := db.QueryRow(`
+		DELETE FROM _wce_password_resets WHERE token_hash = ? AND expires_at > ?
+		RETURNING user_id
+	`, GetTokenHash(token), clock.Now().Unix()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up reset token: %w", err)
+	}
+
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := storePassword(db, user, newPassword); err != nil {
+		return nil, err
+	}
+	if err := RevokeAllUserSessions(db, user.UserID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// storePassword hashes and saves a user's new password
+func storePassword(db *sql.DB, user *User, password string) error {
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE _wce_users SET password_hash = ? WHERE user_id = ?`, passwordHash, user.UserID); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	user.PasswordHash = passwordHash
+	return nil
+}
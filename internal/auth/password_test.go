@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+func TestChangePassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user, err := CreateUser(db, "testuser", "password123", RoleEditor, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	for _, hash := range []string{"current", "other-1", "other-2"} {
+		if _, err := CreateSession(db, user.UserID, hash, "", "", time.Hour); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		current, next string
+		want          error
+	}{
+		{"wrongpass", "newpassword1", ErrWrongPassword},
+		{"password123", "short", ErrPasswordTooShort},
+		{"password123", "password123", ErrPasswordUnchanged},
+	} {
+		if err := ChangePassword(db, user, tc.current, tc.next, "current"); !errors.Is(err, tc.want) {
+			t.Errorf("ChangePassword(%q, %q) = %v, want %v", tc.current, tc.next, err, tc.want)
+		}
+	}
+
+	if err := ChangePassword(db, user, "password123", "newpassword1", "current"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	stored, _ := GetUserByID(db, user.UserID)
+	if VerifyPassword("newpassword1", stored.PasswordHash) != nil {
+		t.Error("Expected the new password stored")
+	}
+
+	sessions, _ := ListUserSessions(db, user.UserID)
+	if len(sessions) != 1 || sessions[0].TokenHash != "current" {
+		t.Errorf("Expected only the current session kept, got %+v", sessions)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	defer clock.Set(fake)()
+
+	admin, _ := CreateUser(db, "admin", "adminpass123", RoleAdmin, "", "")
+	user, err := CreateUser(db, "testuser", "password123", RoleEditor, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	CreateSession(db, user.UserID, "stolen", "", "", time.Hour)
+
+	first, _, err := CreatePasswordReset(db, user.UserID, admin.UserID, DefaultResetTimeout)
+	if err != nil {
+		t.Fatalf("CreatePasswordReset failed: %v", err)
+	}
+	token, expiresAt, err := CreatePasswordReset(db, user.UserID, admin.UserID, DefaultResetTimeout)
+	if err != nil || expiresAt != fake.Now().Add(DefaultResetTimeout).Unix() {
+		t.Fatalf("CreatePasswordReset failed: %v (expires %d)", err, expiresAt)
+	}
+
+	// A new token replaces the unused one
+	if _, err := ResetPassword(db, first, "newpassword1"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Expected the replaced token to be invalid, got %v", err)
+	}
+	if _, err := ResetPassword(db, token, "short"); !errors.Is(err, ErrPasswordTooShort) {
+		t.Errorf("Expected a short password refused, got %v", err)
+	}
+
+	reset, err := ResetPassword(db, token, "newpassword1")
+	if err != nil || reset.UserID != user.UserID {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	stored, _ := GetUserByID(db, user.UserID)
+	if VerifyPassword("newpassword1", stored.PasswordHash) != nil {
+		t.Error("Expected the new password stored")
+	}
+	if valid, _ := IsSessionValid(db, "stolen"); valid {
+		t.Error("Expected the user's sessions revoked")
+	}
+
+	// Tokens work once, and not after they expire
+	if _, err := ResetPassword(db, token, "anotherpass1"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Expected a used token to be invalid, got %v", err)
+	}
+	token, _, _ = CreatePasswordReset(db, user.UserID, admin.UserID, DefaultResetTimeout)
+	fake.Advance(DefaultResetTimeout)
+	if _, err := ResetPassword(db, token, "anotherpass1"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Expected an expired token to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// DefaultRefreshTimeout is how long a refresh token can be exchanged for a
+// new token. Every exchange issues a new refresh token with a fresh timeout,
+// so a client in regular use stays logged in.
+const DefaultRefreshTimeout = 30 * 24 * time.Hour
+
+// ErrInvalidRefreshToken is returned for refresh tokens that are unknown,
+// expired or already exchanged
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// IssueRefreshToken generates a refresh token for the session behind
+// tokenHash, replacing any it had. Only its hash is stored.
+func IssueRefreshToken(db *sql.DB, tokenHash string, expiresIn time.Duration) (string, error) {
+	refreshToken, err := GenerateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_sessions SET refresh_token_hash = ?, refresh_expires_at = ?
+		WHERE token_hash = ?
+	`, GetTokenHash(refreshToken), clock.Now().Add(expiresIn).Unix(), tokenHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", fmt.Errorf("session not found")
+	}
+	return refreshToken, nil
+}
+
+// GetSessionByRefreshToken returns the session a live refresh token belongs
+// to, or ErrInvalidRefreshToken
+func GetSessionByRefreshToken(db *sql.DB, refreshToken string) (*Session, error) {
+	var session Session
+	var lastUsed sql.NullInt64
+	var ipAddress, userAgent, fingerprint sql.NullString
+	err := db.QueryRow(`
+		SELECT session_id, user_id, token_hash, created_at, expires_at, last_used, ip_address, user_agent, client_fingerprint
+		FROM _wce_sessions
+		WHERE refresh_token_hash = ? AND refresh_expires_at > ?
+	`, GetTokenHash(refreshToken), clock.Now().Unix()).Scan(
+		&session.SessionID, &session.UserID, &session.TokenHash, &session.CreatedAt, &session.ExpiresAt,
+		&lastUsed, &ipAddress, &userAgent, &fingerprint,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	session.LastUsed = lastUsed.Int64
+	session.IPAddress = ipAddress.String
+	session.UserAgent = userAgent.String
+	session.ClientFingerprint = fingerprint.String
+	return &session, nil
+}
+
+// RotateSession exchanges a session's refresh token: the session takes the
+// new access token behind tokenHash and a new refresh token, which is
+// returned. The previous token and refresh token stop working at once. A
+// refresh token that was exchanged concurrently gives ErrInvalidRefreshToken.
+func RotateSession(db *sql.DB, refreshToken, tokenHash string, expiresIn, refreshExpiresIn time.Duration) (string, error) {
+	newRefreshToken, err := GenerateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	now := clock.Now()
+	result, err := db.Exec(`
+		UPDATE _wce_sessions
+		SET token_hash = ?, expires_at = ?, last_used = ?, refresh_token_hash = ?, refresh_expires_at = ?
+		WHERE refresh_token_hash = ? AND refresh_expires_at > ?
+	`, tokenHash, now.Add(expiresIn).Unix(), now.Unix(), GetTokenHash(newRefreshToken), now.Add(refreshExpiresIn).Unix(),
+		GetTokenHash(refreshToken), now.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", ErrInvalidRefreshToken
+	}
+	return newRefreshToken, nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	defer clock.Set(fake)()
+
+	user, err := CreateUser(db, "testuser", "password123", RoleEditor, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := CreateSession(db, user.UserID, "token-1", "127.0.0.1", "test-agent", time.Hour); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, err := IssueRefreshToken(db, "missing", DefaultRefreshTimeout); err == nil {
+		t.Error("Expected issuing for a missing session to fail")
+	}
+	refresh, err := IssueRefreshToken(db, "token-1", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+
+	session, err := GetSessionByRefreshToken(db, refresh)
+	if err != nil || session.UserID != user.UserID || session.TokenHash != "token-1" {
+		t.Fatalf("Unexpected session: %+v (%v)", session, err)
+	}
+
+	// The session outlives its access token while the refresh token is valid
+	fake.Advance(2 * time.Hour)
+	CleanupExpiredSessions(db)
+	if _, err := GetSessionByRefreshToken(db, refresh); err != nil {
+		t.Fatalf("Expected the session to be kept: %v", err)
+	}
+
+	next, err := RotateSession(db, refresh, "token-2", time.Hour, 24*time.Hour)
+	if err != nil || next == refresh {
+		t.Fatalf("RotateSession failed: %q (%v)", next, err)
+	}
+	if valid, _ := IsSessionValid(db, "token-2"); !valid {
+		t.Error("Expected the new token to be valid")
+	}
+	if valid, _ := IsSessionValid(db, "token-1"); valid {
+		t.Error("Expected the old token to be replaced")
+	}
+
+	// An exchanged refresh token cannot be used again
+	if _, err := RotateSession(db, refresh, "token-3", time.Hour, 24*time.Hour); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected reuse to fail, got %v", err)
+	}
+	if _, err := GetSessionByRefreshToken(db, refresh); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected the old refresh token to be unknown, got %v", err)
+	}
+
+	// Expired refresh tokens are refused and their sessions cleaned up
+	fake.Advance(25 * time.Hour)
+	if _, err := GetSessionByRefreshToken(db, next); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected an expired refresh token to fail, got %v", err)
+	}
+	CleanupExpiredSessions(db)
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM _wce_sessions`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected the expired session to be removed, %d left", count)
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	defer clock.Set(fake)()
+
+	user, _ := CreateUser(db, "testuser", "password123", RoleEditor, "", "")
+	other, _ := CreateUser(db, "otheruser", "password123", RoleEditor, "", "")
+	laptop, _ := CreateSession(db, user.UserID, "token-1", "10.0.0.1", "laptop", time.Hour)
+	CreateSession(db, user.UserID, "token-2", "10.0.0.2", "phone", time.Hour)
+	CreateSession(db, other.UserID, "token-3", "10.0.0.3", "other", time.Hour)
+
+	// Use is recorded at most once per SessionTouchInterval, and a touch
+	// within it does not write at all
+	db.SetMaxOpenConns(1)
+	fake.Advance(30 * time.Second)
+	db.Exec(`PRAGMA query_only = ON`)
+	if err := TouchSession(db, "token-2"); err != nil {
+		t.Errorf("Expected a touch within the interval not to write: %v", err)
+	}
+	db.Exec(`PRAGMA query_only = OFF`)
+	sessions, err := ListUserSessions(db, user.UserID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v (%v)", sessions, err)
+	}
+	if sessions[0].LastUsed != laptop.LastUsed {
+		t.Errorf("Expected a touch within the interval to be skipped, got %d", sessions[0].LastUsed)
+	}
+	fake.Advance(SessionTouchInterval)
+	TouchSession(db, "token-2")
+	sessions, _ = ListUserSessions(db, user.UserID)
+	if sessions[0].TokenHash != "token-2" || sessions[0].LastUsed != fake.Now().Unix() {
+		t.Errorf("Expected the touched session first, got %+v", sessions[0])
+	}
+
+	if err := RevokeUserSession(db, other.UserID, laptop.SessionID); err == nil {
+		t.Error("Expected revoking another user's session to fail")
+	}
+	if err := RevokeUserSession(db, user.UserID, laptop.SessionID); err != nil {
+		t.Fatalf("RevokeUserSession failed: %v", err)
+	}
+
+	// Expired sessions are not listed
+	fake.Advance(2 * time.Hour)
+	if sessions, _ := ListUserSessions(db, user.UserID); len(sessions) != 0 {
+		t.Errorf("Expected no live sessions, got %+v", sessions)
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scopes limit what a token may do, on top of its user's role. Login tokens
+// carry no scope claim and are unrestricted; tokens issued for third-party
+// clients list the route groups they may use.
+const (
+	ScopeDocumentsRead  = "documents:read"
+	ScopeDocumentsWrite = "documents:write"
+	ScopeTablesRead     = "tables:read"
+	ScopeTablesWrite    = "tables:write"
+	ScopeStarExecute    = "star:execute"
+	ScopePagesRead      = "pages:read"
+	ScopeProxyUse       = "proxy:use"
+	ScopeAdminRead      = "admin:read"
+	ScopeAdminWrite     = "admin:write"
+	ScopeAdminNone      = "admin:none" // Explicitly grants no admin access
+	ScopeAccountRead    = "account:read"
+	ScopeAccountWrite   = "account:write"
+)
+
+// validScopes lists every scope a token may be issued with
+var validScopes = map[string]bool{
+	ScopeDocumentsRead:  true,
+	ScopeDocumentsWrite: true,
+	ScopeTablesRead:     true,
+	ScopeTablesWrite:    true,
+	ScopeStarExecute:    true,
+	ScopePagesRead:      true,
+	ScopeProxyUse:       true,
+	ScopeAdminRead:      true,
+	ScopeAdminWrite:     true,
+	ScopeAdminNone:      true,
+	ScopeAccountRead:    true,
+	ScopeAccountWrite:   true,
+}
+
+// impliedScopes maps write scopes to the read scope they include
+var impliedScopes = map[string]string{
+	ScopeDocumentsWrite: ScopeDocumentsRead,
+	ScopeTablesWrite:    ScopeTablesRead,
+	ScopeAdminWrite:     ScopeAdminRead,
+	ScopeAccountWrite:   ScopeAccountRead,
+}
+
+// ParseScopes splits a space-separated OAuth scope string, rejecting unknown
+// scopes and contradictions. Duplicates are dropped and order is kept.
+func ParseScopes(scope string) ([]string, error) {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, s := range strings.Fields(scope) {
+		if !validScopes[s] {
+			return nil, fmt.Errorf("unknown scope: %s", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	if seen[ScopeAdminNone] && (seen[ScopeAdminRead] || seen[ScopeAdminWrite]) {
+		return nil, fmt.Errorf("%s cannot be combined with other admin scopes", ScopeAdminNone)
+	}
+
+	return scopes, nil
+}
+
+// IsScoped reports whether the token is limited to the scopes it lists
+func (c *Claims) IsScoped() bool {
+	return c.Scope != ""
+}
+
+// HasScope reports whether the token grants scope. Unscoped tokens grant
+// every scope.
+func (c *Claims) HasScope(scope string) bool {
+	if !c.IsScoped() {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope || impliedScopes[s] == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUserNotFound is returned when looking up, updating or deleting a user
+// that does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserReferenced is returned when deleting a user that is still recorded
+// as the author of other records
+var ErrUserReferenced = errors.New("user is referenced by existing records, disable it instead")
+
+// IsValidRole reports whether role is one of the built-in roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleOwner, RoleAdmin, RoleEditor, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// ListUsers returns all users ordered by username
+func ListUsers(db *sql.DB) ([]User, error) {
+	rows, err := db.Query(`
+		SELECT user_id, username, password_hash, role, email, created_at, invited_by, last_login, enabled
+		FROM _wce_users
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return users, nil
+}
+
+// FindUsersByEmail returns the enabled users with an email address,
+// compared case-insensitively
+func FindUsersByEmail(db *sql.DB, email string) ([]User, error) {
+	users, err := ListUsers(db)
+	if err != nil {
+		return nil, err
+	}
+	found := []User{}
+	for _, user := range users {
+		if user.Enabled && user.Email != "" && strings.EqualFold(user.Email, email) {
+			found = append(found, user)
+		}
+	}
+	return found, nil
+}
+
+// UpdateUser sets a user's role, email and enabled flag. Disabling a user or
+// changing their role revokes their sessions and refresh tokens, as tokens
+// carry the role they were issued with.
+func UpdateUser(db *sql.DB, userID, role, email string, enabled bool) error {
+	var currentRole string
+	err := db.QueryRow(`SELECT role FROM _wce_users WHERE user_id = ?`, userID).Scan(&currentRole)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var emailParam interface{}
+	if email != "" {
+		emailParam = email
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_users SET role = ?, email = ?, enabled = ? WHERE user_id = ?
+	`, role, emailParam, enabled, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+
+	if !enabled || role != currentRole {
+		return RevokeAllUserSessions(db, userID)
+	}
+	return nil
+}
+
+// SetPassword replaces a user's password and revokes their sessions.
+// Setting the current password again is a no-op, so declarative clients can
+// resend it without logging the user out.
+func SetPassword(db *sql.DB, user *User, password string) error {
+	if VerifyPassword(password, user.PasswordHash) == nil {
+		return nil
+	}
+
+	if err := storePassword(db, user, password); err != nil {
+		return err
+	}
+	return RevokeAllUserSessions(db, user.UserID)
+}
+
+// DeleteUser removes a user. Users still recorded as the author of
+// documents, endpoints or other records cannot be deleted; disable them instead.
+func DeleteUser(db *sql.DB, userID string) error {
+	result, err := db.Exec(`DELETE FROM _wce_users WHERE user_id = ?`, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "FOREIGN KEY") {
+			return ErrUserReferenced
+		}
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
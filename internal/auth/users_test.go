@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user, err := CreateUser(db, "alice", "password123", RoleViewer, "", "")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := CreateSession(db, user.UserID, "hash-1", "", "", time.Hour); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	if err := UpdateUser(db, user.UserID, RoleEditor, "alice@example.com", false); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+
+	got, err := GetUserByID(db, user.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if got.Role != RoleEditor || got.Email != "alice@example.com" || got.Enabled {
+		t.Errorf("Unexpected user after update: %+v", got)
+	}
+
+	if valid, _ := IsSessionValid(db, "hash-1"); valid {
+		t.Error("Expected disabling to revoke sessions")
+	}
+
+	// Tokens carry the role, so changing it logs the user out
+	if _, err := CreateSession(db, user.UserID, "hash-2", "", "", time.Hour); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if err := UpdateUser(db, user.UserID, RoleEditor, "alice@example.org", true); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if valid, _ := IsSessionValid(db, "hash-2"); !valid {
+		t.Error("Expected an email change to keep sessions")
+	}
+	if err := UpdateUser(db, user.UserID, RoleViewer, "alice@example.org", true); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if valid, _ := IsSessionValid(db, "hash-2"); valid {
+		t.Error("Expected a role change to revoke sessions")
+	}
+
+	if err := UpdateUser(db, "missing", RoleViewer, "", true); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for missing user, got %v", err)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user, err := CreateUser(db, "alice", "password123", RoleViewer, "", "")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := CreateSession(db, user.UserID, "hash-1", "", "", time.Hour); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	// Resending the current password keeps sessions
+	if err := SetPassword(db, user, "password123"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	if valid, _ := IsSessionValid(db, "hash-1"); !valid {
+		t.Error("Expected unchanged password to keep sessions")
+	}
+
+	if err := SetPassword(db, user, "newpassword456"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	got, _ := GetUserByUsername(db, "alice")
+	if VerifyPassword("newpassword456", got.PasswordHash) != nil {
+		t.Error("Expected new password to verify")
+	}
+	if valid, _ := IsSessionValid(db, "hash-1"); valid {
+		t.Error("Expected password change to revoke sessions")
+	}
+}
+
+func TestListAndDeleteUsers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, name := range []string{"bob", "alice"} {
+		if _, err := CreateUser(db, name, "password123", RoleViewer, "", ""); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	users, err := ListUsers(db)
+	if err != nil || len(users) != 2 || users[0].Username != "alice" {
+		t.Fatalf("Expected users ordered by name, got %+v (%v)", users, err)
+	}
+
+	if err := DeleteUser(db, users[0].UserID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if err := DeleteUser(db, users[0].UserID); err == nil {
+		t.Error("Expected error deleting a missing user")
+	}
+	if _, err := GetUserByUsername(db, "alice"); err == nil {
+		t.Error("Expected deleted user to be gone")
+	}
+}
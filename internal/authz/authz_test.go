@@ -4,12 +4,12 @@ import (
 	"database/sql"
 	"testing"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 // setupTestDB creates an in-memory database with WCE schema
 func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
@@ -45,6 +45,12 @@ func setupTestDB(t *testing.T) *sql.DB {
 		created_by TEXT
 	);
 
+	CREATE TABLE _wce_soft_delete_tables (
+		table_name TEXT PRIMARY KEY COLLATE NOCASE,
+		enabled_at INTEGER NOT NULL,
+		enabled_by TEXT
+	);
+
 	CREATE TABLE test_data (
 		id INTEGER PRIMARY KEY,
 		owner_id TEXT,
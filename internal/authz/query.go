@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/thetanil/wce/internal/tables"
 )
 
 // QueryType represents the type of SQL operation
@@ -211,6 +213,36 @@ func ApplyRowPolicies(sqlQuery, userID string, policies []RowPolicy) (string, er
 	return strings.TrimSpace(rewritten), nil
 }
 
+// softDeleteCondition restricts a soft delete table to live rows
+var softDeleteCondition = tables.SoftDeleteColumn + " IS NULL"
+
+// ApplySoftDelete rewrites a query on a soft delete table: SELECT and UPDATE
+// only see live rows, and DELETE becomes an UPDATE that sets deleted_at
+func ApplySoftDelete(sqlQuery string) (string, error) {
+	parsed, err := ParseQuery(sqlQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	rewritten := parsed.RawSQL
+	switch parsed.Type {
+	case QueryTypeSelect, QueryTypeUpdate:
+		// Only the live row condition is needed
+	case QueryTypeDelete:
+		re := regexp.MustCompile(`(?i)^DELETE\s+FROM\s+[a-zA-Z_][a-zA-Z0-9_]*`)
+		loc := re.FindStringIndex(rewritten)
+		if loc == nil {
+			return "", fmt.Errorf("could not rewrite delete on table %s", parsed.TableName)
+		}
+		rewritten = fmt.Sprintf("UPDATE %s SET %s = strftime('%%s', 'now')%s",
+			parsed.TableName, tables.SoftDeleteColumn, rewritten[loc[1]:])
+	default:
+		return sqlQuery, nil
+	}
+
+	return ApplyRowPolicies(rewritten, "", []RowPolicy{{SQLCondition: softDeleteCondition}})
+}
+
 // ValidateAndRewriteQuery validates permissions and applies row policies
 func ValidateAndRewriteQuery(db *sql.DB, userID, role, sqlQuery string) (string, error) {
 	// First validate permissions
@@ -224,6 +256,18 @@ func ValidateAndRewriteQuery(db *sql.DB, userID, role, sqlQuery string) (string,
 		return "", fmt.Errorf("failed to parse query: %w", err)
 	}
 
+	// Soft delete is part of the table's data model, so it applies to every role
+	softDelete, err := tables.SoftDeleteEnabled(db, parsed.TableName)
+	if err != nil {
+		return "", err
+	}
+	if softDelete {
+		sqlQuery, err = ApplySoftDelete(sqlQuery)
+		if err != nil {
+			return "", fmt.Errorf("failed to apply soft delete: %w", err)
+		}
+	}
+
 	// Owner and Admin bypass row policies
 	if role == RoleOwner || role == RoleAdmin {
 		return sqlQuery, nil
@@ -1,6 +1,7 @@
 package authz
 
 import (
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -384,3 +385,125 @@ func TestValidateAndRewriteQuery_OwnerBypassesPolicies(t *testing.T) {
 		t.Error("Owner queries should not be rewritten with policies")
 	}
 }
+
+func TestApplySoftDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{
+			name:     "select",
+			sql:      "SELECT * FROM notes ORDER BY id",
+			expected: "SELECT * FROM notes WHERE (deleted_at IS NULL)  ORDER BY id",
+		},
+		{
+			name:     "update",
+			sql:      "UPDATE notes SET body = 'x' WHERE id = 1",
+			expected: "UPDATE notes SET body = 'x' WHERE ((deleted_at IS NULL)) AND (id = 1)",
+		},
+		{
+			name:     "delete",
+			sql:      "DELETE FROM notes WHERE id = 1",
+			expected: "UPDATE notes SET deleted_at = strftime('%s', 'now') WHERE ((deleted_at IS NULL)) AND (id = 1)",
+		},
+		{
+			name:     "delete all",
+			sql:      "delete from notes",
+			expected: "UPDATE notes SET deleted_at = strftime('%s', 'now') WHERE (deleted_at IS NULL)",
+		},
+		{
+			name:     "insert",
+			sql:      "INSERT INTO notes (body) VALUES ('x')",
+			expected: "INSERT INTO notes (body) VALUES ('x')",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewritten, err := ApplySoftDelete(tt.sql)
+			if err != nil {
+				t.Fatalf("ApplySoftDelete failed: %v", err)
+			}
+			if rewritten != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, rewritten)
+			}
+		})
+	}
+}
+
+func TestValidateAndRewriteQuery_SoftDelete(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec(`INSERT INTO _wce_soft_delete_tables (table_name, enabled_at) VALUES ('test_data', 0)`)
+	if err != nil {
+		t.Fatalf("Failed to enable soft delete: %v", err)
+	}
+
+	// Soft delete applies to owners too
+	rewritten, err := ValidateAndRewriteQuery(db, "owner-id", RoleOwner, "DELETE FROM test_data WHERE id = 1")
+	if err != nil {
+		t.Fatalf("ValidateAndRewriteQuery failed: %v", err)
+	}
+	if !strings.HasPrefix(rewritten, "UPDATE test_data SET deleted_at") {
+		t.Errorf("Expected delete converted to update, got %q", rewritten)
+	}
+
+	// Row policies still apply on top of the live row filter
+	userID := "test-user"
+	GrantPermission(db, userID, "test_data", true, false, false, false)
+	CreateRowPolicy(db, "test_data", userID, "read", "owner_id = $user_id", "admin")
+
+	rewritten, err = ValidateAndRewriteQuery(db, userID, RoleEditor, "SELECT * FROM test_data")
+	if err != nil {
+		t.Fatalf("ValidateAndRewriteQuery failed: %v", err)
+	}
+	if !strings.Contains(rewritten, "deleted_at IS NULL") || !strings.Contains(rewritten, "owner_id = 'test-user'") {
+		t.Errorf("Expected both conditions, got %q", rewritten)
+	}
+}
+
+// FuzzParseQuery checks that ParseQuery never panics and that any table it
+// extracts is an identifier taken from the query itself
+func FuzzParseQuery(f *testing.F) {
+	for _, seed := range []string{
+		"SELECT * FROM users",
+		"select id from products where price > 10",
+		"INSERT INTO orders (id) VALUES (1)",
+		"UPDATE users SET name = 'x' WHERE id = 1",
+		"DELETE FROM sessions",
+		"SELECT 'FROM a' FROM b",
+		"SELECT * FROM",
+		"DROP TABLE users",
+		"  \tselect\n*\nfrom\tt  ",
+	} {
+		f.Add(seed)
+	}
+
+	identifier := regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	f.Fuzz(func(t *testing.T, sql string) {
+		parsed, err := ParseQuery(sql)
+		if err != nil {
+			return
+		}
+		if parsed.RawSQL != strings.TrimSpace(sql) {
+			t.Errorf("RawSQL %q does not match the trimmed query", parsed.RawSQL)
+		}
+		if parsed.Type == QueryTypeUnknown {
+			if parsed.TableName != "" {
+				t.Errorf("Unknown query %q reported table %q", sql, parsed.TableName)
+			}
+			return
+		}
+		if !identifier.MatchString(parsed.TableName) {
+			t.Errorf("Query %q reported table %q, which is not an identifier", sql, parsed.TableName)
+		}
+		if !strings.Contains(strings.ToUpper(sql), strings.ToUpper(parsed.TableName)) {
+			t.Errorf("Query %q reported table %q, which it does not mention", sql, parsed.TableName)
+		}
+		if !strings.HasPrefix(strings.ToUpper(parsed.RawSQL), string(parsed.Type)) {
+			t.Errorf("Query %q reported as %s", sql, parsed.Type)
+		}
+	})
+}
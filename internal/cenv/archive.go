@@ -0,0 +1,262 @@
+package cenv
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ArchivePolicy moves cenvs idle for IdleDays into gzip-compressed files in
+// Path, typically on cheaper cold storage
+type ArchivePolicy struct {
+	Path     string `json:"path"`
+	IdleDays int    `json:"idle_days"`
+}
+
+// archivePolicy returns the registry's archive policy, or nil. Only database
+// files in the local store can be archived.
+func (m *Manager) archivePolicy() *ArchivePolicy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.registry == nil || !m.isLocal() {
+		return nil
+	}
+	return m.registry.Archive
+}
+
+// archivePath returns the compressed archive file of a cenv
+func (p *ArchivePolicy) archivePath(cenvID string) string {
+	return filepath.Join(p.Path, cenvID+".db.gz")
+}
+
+// IsArchived reports whether a cenv is in cold storage awaiting Restore
+func (m *Manager) IsArchived(cenvID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.registry == nil {
+		return false
+	}
+	_, archived := m.registry.Archived[cenvID]
+	return archived
+}
+
+// ArchivedCenvs returns the IDs of the cenvs in cold storage
+func (m *Manager) ArchivedCenvs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	if m.registry != nil {
+		for cenvID := range m.registry.Archived {
+			ids = append(ids, cenvID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Touch records that a cenv is in use, deferring its archiving. Background
+// work such as document sweeps should not touch cenvs.
+func (m *Manager) Touch(cenvID string) {
+	m.lastAccess.Store(cenvID, time.Now())
+}
+
+// LastUsed returns when a cenv was last touched by this process or its
+// database last written, whichever is later
+func (m *Manager) LastUsed(cenvID string) time.Time {
+	var last time.Time
+	if accessed, ok := m.lastAccess.Load(cenvID); ok {
+		last = accessed.(time.Time)
+	}
+
+	dbPath := m.GetDatabasePath(cenvID)
+	for _, path := range []string{dbPath, dbPath + "-wal"} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// IdleCenvs returns the cenvs unused for the archive policy's IdleDays as of
+// now. Without an archive policy none are idle.
+func (m *Manager) IdleCenvs(now time.Time) ([]string, error) {
+	policy := m.archivePolicy()
+	if policy == nil {
+		return nil, nil
+	}
+
+	cenvIDs, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	idleFor := time.Duration(policy.IdleDays) * 24 * time.Hour
+	var idle []string
+	for _, cenvID := range cenvIDs {
+		if now.Sub(m.LastUsed(cenvID)) >= idleFor {
+			idle = append(idle, cenvID)
+		}
+	}
+	return idle, nil
+}
+
+// Archive compresses a cenv's database into cold storage, marks it archived
+// in the registry and removes it from its volume. The mark is saved first so
+// callers checking IsArchived stop using the database; requests already
+// holding a connection should be finished, which idleness makes likely.
+func (m *Manager) Archive(cenvID string) error {
+	policy := m.archivePolicy()
+	if policy == nil {
+		return fmt.Errorf("archiving is not configured")
+	}
+
+	m.cold.Lock()
+	defer m.cold.Unlock()
+
+	if m.IsArchived(cenvID) {
+		return nil
+	}
+	if !m.Exists(cenvID) {
+		return fmt.Errorf("cenv %s does not exist", cenvID)
+	}
+
+	err := m.updateRegistry(func(registry *Registry) {
+		registry.Archived[cenvID] = time.Now().Unix()
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := m.compress(cenvID, policy.archivePath(cenvID)); err != nil {
+		m.updateRegistry(func(registry *Registry) {
+			delete(registry.Archived, cenvID)
+		})
+		return fmt.Errorf("failed to archive cenv %s: %w", cenvID, err)
+	}
+
+	dbPath := m.GetDatabasePath(cenvID)
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove archived database: %w", err)
+		}
+	}
+	m.lastAccess.Delete(cenvID)
+
+	return nil
+}
+
+// compress checkpoints a cenv's WAL into its database and writes the
+// database, gzip-compressed, to archivePath
+func (m *Manager) compress(cenvID, archivePath string) error {
+	if err := m.CloseConnection(cenvID); err != nil {
+		return err
+	}
+
+	connection, err := m.Open(cenvID)
+	if err != nil {
+		return err
+	}
+	_, err = connection.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	connection.Close()
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+
+	in, err := os.Open(m.GetDatabasePath(cenvID))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(archivePath, func(out io.Writer) error {
+		compressed := gzip.NewWriter(out)
+		if _, err := io.Copy(compressed, in); err != nil {
+			return err
+		}
+		return compressed.Close()
+	})
+}
+
+// Restore decompresses an archived cenv back onto the volume its attributes
+// select and clears its archived mark. Restoring a cenv that is not archived
+// does nothing.
+func (m *Manager) Restore(cenvID string) error {
+	m.cold.Lock()
+	defer m.cold.Unlock()
+
+	if !m.IsArchived(cenvID) {
+		return nil
+	}
+	policy := m.archivePolicy()
+	archivePath := policy.archivePath(cenvID)
+
+	in, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive of cenv %s: %w", cenvID, err)
+	}
+	defer in.Close()
+
+	compressed, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to read archive of cenv %s: %w", cenvID, err)
+	}
+
+	err = writeFileAtomic(m.GetDatabasePath(cenvID), func(out io.Writer) error {
+		_, err := io.Copy(out, compressed)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore cenv %s: %w", cenvID, err)
+	}
+
+	err = m.updateRegistry(func(registry *Registry) {
+		delete(registry.Archived, cenvID)
+	})
+	if err != nil {
+		return err
+	}
+	m.Touch(cenvID)
+
+	if err := os.Remove(archivePath); err != nil {
+		return fmt.Errorf("failed to remove archive of cenv %s: %w", cenvID, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file with owner-only permissions through a
+// temporary file, so path only ever holds complete content
+func writeFileAtomic(path string, write func(out io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,134 @@
+package cenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveAndRestore(t *testing.T) {
+	storageDir := t.TempDir()
+	coldDir := filepath.Join(t.TempDir(), "cold")
+	manager := NewManager(storageDir)
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := manager.Archive(cenvID); err == nil {
+		t.Error("Expected archiving without a policy to fail")
+	}
+
+	writeRegistry(t, storageDir, `{"archive": {"path": "`+coldDir+`", "idle_days": 30}}`)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+
+	conn, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	if _, err := conn.Exec(`INSERT INTO _wce_config (key, value, updated_at) VALUES ('marker', 'kept', 0)`); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+
+	if err := manager.Archive(cenvID); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	if !manager.IsArchived(cenvID) || manager.Exists(cenvID) {
+		t.Error("Expected cenv to be archived and its database removed")
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, cenvID+".db.gz")); err != nil {
+		t.Errorf("Expected compressed archive: %v", err)
+	}
+	if err := manager.Create(cenvID); err == nil {
+		t.Error("Expected creating an archived cenv to fail")
+	}
+	if ids, _ := manager.List(); len(ids) != 0 {
+		t.Errorf("Expected archived cenv not to be listed, got %v", ids)
+	}
+
+	// The archived mark is saved in the registry
+	restarted := NewManager(storageDir)
+	if err := restarted.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	if !restarted.IsArchived(cenvID) {
+		t.Error("Expected archived mark to survive a restart")
+	}
+
+	if err := restarted.Restore(cenvID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restarted.IsArchived(cenvID) || !restarted.Exists(cenvID) {
+		t.Error("Expected cenv to be restored")
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, cenvID+".db.gz")); !os.IsNotExist(err) {
+		t.Error("Expected archive to be removed after restore")
+	}
+
+	conn, err = restarted.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open restored cenv: %v", err)
+	}
+	var value string
+	if err := conn.QueryRow(`SELECT value FROM _wce_config WHERE key = 'marker'`).Scan(&value); err != nil || value != "kept" {
+		t.Errorf("Expected restored data, got %q: %v", value, err)
+	}
+	restarted.CloseAll()
+}
+
+func TestIdleCenvs(t *testing.T) {
+	storageDir := t.TempDir()
+	manager := NewManager(storageDir)
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if idle, _ := manager.IdleCenvs(time.Now().Add(365 * 24 * time.Hour)); len(idle) != 0 {
+		t.Errorf("Expected no idle cenvs without an archive policy, got %v", idle)
+	}
+
+	writeRegistry(t, storageDir, `{"archive": {"path": "`+t.TempDir()+`", "idle_days": 7}}`)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+
+	if idle, _ := manager.IdleCenvs(time.Now().Add(6 * 24 * time.Hour)); len(idle) != 0 {
+		t.Errorf("Expected no idle cenvs after 6 days, got %v", idle)
+	}
+	if idle, _ := manager.IdleCenvs(time.Now().Add(8 * 24 * time.Hour)); len(idle) != 1 {
+		t.Errorf("Expected the cenv to be idle after 8 days, got %v", idle)
+	}
+
+	// Use counts even when the database is not written
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	os.Chtimes(manager.GetDatabasePath(cenvID), old, old)
+	if idle, _ := manager.IdleCenvs(time.Now()); len(idle) != 1 {
+		t.Errorf("Expected an untouched cenv to be idle, got %v", idle)
+	}
+	manager.Touch(cenvID)
+	if idle, _ := manager.IdleCenvs(time.Now()); len(idle) != 0 {
+		t.Errorf("Expected a touched cenv not to be idle, got %v", idle)
+	}
+}
+
+func TestLoadRegistry_InvalidArchive(t *testing.T) {
+	registries := map[string]string{
+		"NoPath":         `{"archive": {"idle_days": 7}}`,
+		"NoIdleDays":     `{"archive": {"path": "/cold"}}`,
+		"ArchivedNoPath": `{"archived": {"123e4567-e89b-12d3-a456-426614174000": 1}}`,
+	}
+
+	for name, registry := range registries {
+		t.Run(name, func(t *testing.T) {
+			storageDir := t.TempDir()
+			writeRegistry(t, storageDir, registry)
+			if err := NewManager(storageDir).LoadRegistry(); err == nil {
+				t.Error("Expected invalid registry to be rejected")
+			}
+		})
+	}
+}
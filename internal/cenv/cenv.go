@@ -3,14 +3,14 @@ package cenv
 import (
 	"database/sql"
 	"fmt"
-	"os"
+	"io"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/thetanil/wce/internal/db"
-	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 // UUID regex pattern (RFC 4122 compliant)
@@ -20,13 +20,48 @@ var uuidRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f
 type Manager struct {
 	storageDir  string
 	connections sync.Map // map[string]*sql.DB - cenvID -> connection pool
+
+	mu       sync.RWMutex
+	registry *Registry // Volume placement and archiving, nil without a registry file
+
+	cold       sync.Mutex // Serializes Archive and Restore
+	lastAccess sync.Map   // map[string]time.Time - cenvID -> last Touch
+
+	driver string // database/sql driver for Open, sqlite.DriverName when empty
+	store  Store  // Where databases are kept, local files unless SetStore was called
+
+	ephemeralMu sync.RWMutex
+	ephemeral   map[string]*ephemeralCenv // cenvID -> in-memory cenv, see CreateEphemeral
 }
 
-// NewManager creates a new cenv manager
+// NewManager creates a new cenv manager keeping databases as SQLite files in
+// storageDir
 func NewManager(storageDir string) *Manager {
-	return &Manager{
+	m := &Manager{
 		storageDir: storageDir,
 	}
+	m.store = &localStore{m: m}
+	return m
+}
+
+// SetStore replaces the store holding cenv databases. It must be called
+// before the manager is used. Volume placement, archiving and size history
+// work on database files and apply only to the default local store.
+func (m *Manager) SetStore(store Store) {
+	m.store = store
+}
+
+// isLocal reports whether databases are files managed by the local store
+func (m *Manager) isLocal() bool {
+	_, ok := m.store.(*localStore)
+	return ok
+}
+
+// SetDriver sets the database/sql driver Open uses for cenv databases. It
+// must accept SQLite DSNs; tests register wrappers around SQLite that inject
+// faults. Connections opened earlier are unaffected.
+func (m *Manager) SetDriver(name string) {
+	m.driver = name
 }
 
 // IsValidUUID checks if a string is a valid UUID
@@ -64,54 +99,86 @@ func ParsePath(path string) (cenvID string, remainingPath string, ok bool) {
 	return cenvID, remainingPath, true
 }
 
-// GetDatabasePath returns the filesystem path for a cenv's database
+// StorageDir returns the directory holding the cenv databases
+func (m *Manager) StorageDir() string {
+	return m.storageDir
+}
+
+// GetDatabasePath returns the filesystem path for a cenv's database, on the
+// volume its registry attributes select
 func (m *Manager) GetDatabasePath(cenvID string) string {
-	return fmt.Sprintf("%s/%s.db", m.storageDir, cenvID)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	root := m.storageDir
+	if m.registry != nil {
+		if volume := m.registry.volumeFor(cenvID); volume != nil {
+			root = volume.Path
+		}
+	}
+	return fmt.Sprintf("%s/%s.db", root, cenvID)
+}
+
+// List returns the IDs of all cenvs in the store, sorted. Ephemeral cenvs
+// are not listed.
+func (m *Manager) List() ([]string, error) {
+	return m.store.List()
 }
 
-// Exists checks if a cenv database file exists
+// Exists checks if a cenv database exists, in the store or in memory
 func (m *Manager) Exists(cenvID string) bool {
-	dbPath := m.GetDatabasePath(cenvID)
-	_, err := os.Stat(dbPath)
-	return err == nil
+	return m.isEphemeral(cenvID) || m.store.Exists(cenvID)
 }
 
-// Create creates a new cenv database file with proper permissions and initializes schema
+// Create creates a new cenv database and initializes its schema
 func (m *Manager) Create(cenvID string) error {
-	dbPath := m.GetDatabasePath(cenvID)
-
-	// Check if database already exists
-	if m.Exists(cenvID) {
+	// Check if database already exists, possibly in cold storage
+	if m.Exists(cenvID) || m.IsArchived(cenvID) {
 		return fmt.Errorf("cenv %s already exists", cenvID)
 	}
 
-	// Create the database file
-	connection, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return fmt.Errorf("failed to create database: %w", err)
-	}
-	defer connection.Close()
-
-	// Verify we can connect
-	if err := connection.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	// Set file permissions to 600 (owner read/write only)
-	if err := os.Chmod(dbPath, 0600); err != nil {
-		return fmt.Errorf("failed to set permissions: %w", err)
+	if err := m.store.Create(cenvID); err != nil {
+		return err
 	}
 
 	// Initialize schema
 	if err := m.Initialize(cenvID); err != nil {
-		// Clean up database file if initialization fails
-		os.Remove(dbPath)
+		// Clean up database if initialization fails
+		m.store.Delete(cenvID)
 		return fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
 	return nil
 }
 
+// Delete closes a cenv's pooled connection and removes its database from the
+// store. Callers must ensure no request is still using the cenv.
+func (m *Manager) Delete(cenvID string) error {
+	if m.isEphemeral(cenvID) {
+		return m.dropEphemeral(cenvID)
+	}
+	if !m.Exists(cenvID) {
+		return fmt.Errorf("cenv %s does not exist", cenvID)
+	}
+	if err := m.CloseConnection(cenvID); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	m.lastAccess.Delete(cenvID)
+	return m.store.Delete(cenvID)
+}
+
+// Snapshot writes a consistent copy of a cenv's database to w as an SQLite
+// file, without blocking writers
+func (m *Manager) Snapshot(cenvID string, w io.Writer) error {
+	if m.isEphemeral(cenvID) {
+		return fmt.Errorf("ephemeral cenvs cannot be snapshotted")
+	}
+	if !m.Exists(cenvID) {
+		return fmt.Errorf("cenv %s does not exist", cenvID)
+	}
+	return m.store.Snapshot(cenvID, w)
+}
+
 // Initialize creates the WCE system tables in a cenv database
 func (m *Manager) Initialize(cenvID string) error {
 	if !m.Exists(cenvID) {
@@ -161,33 +228,13 @@ func (m *Manager) Initialize(cenvID string) error {
 // Returns an error if the database doesn't exist
 // Note: This creates a new connection. For pooled connections, use GetConnection()
 func (m *Manager) Open(cenvID string) (*sql.DB, error) {
+	if m.isEphemeral(cenvID) {
+		return sql.Open(sqlite.DriverName, ephemeralDSN(cenvID))
+	}
 	if !m.Exists(cenvID) {
 		return nil, fmt.Errorf("cenv %s does not exist", cenvID)
 	}
-
-	dbPath := m.GetDatabasePath(cenvID)
-
-	// Open database connection
-	connection, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-
-	// Configure SQLite pragmas
-	pragmas := []string{
-		"PRAGMA foreign_keys = ON",
-		"PRAGMA journal_mode = WAL",
-		"PRAGMA synchronous = NORMAL",
-	}
-
-	for _, pragma := range pragmas {
-		if _, err := connection.Exec(pragma); err != nil {
-			connection.Close()
-			return nil, fmt.Errorf("failed to set pragma: %w", err)
-		}
-	}
-
-	return connection, nil
+	return m.store.Open(cenvID)
 }
 
 // GetConnection returns a pooled connection to a cenv database
@@ -299,3 +299,28 @@ func TestConnectionPooling(t *testing.T) {
 		t.Errorf("Failed to close all connections: %v", err)
 	}
 }
+
+func TestList(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewManager(tempDir)
+
+	ids := []string{
+		"123e4567-e89b-12d3-a456-426614174000",
+		"223e4567-e89b-12d3-a456-426614174000",
+	}
+	for _, id := range ids {
+		if err := manager.Create(id); err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+	}
+	os.WriteFile(tempDir+"/master.key", []byte("key"), 0600)
+	os.WriteFile(tempDir+"/not-a-cenv.db", []byte(""), 0600)
+
+	listed, err := manager.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 2 || listed[0] != ids[0] || listed[1] != ids[1] {
+		t.Errorf("Expected %v, got %v", ids, listed)
+	}
+}
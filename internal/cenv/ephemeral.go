@@ -0,0 +1,136 @@
+package cenv
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// Limits on ephemeral cenvs, which hold their whole database in memory
+const (
+	DefaultEphemeralTTL = time.Hour
+	MaxEphemeralTTL     = 24 * time.Hour
+	maxEphemeralCenvs   = 100
+	maxEphemeralPages   = 8192 // 32 MiB at SQLite's default 4 KiB page size
+)
+
+// ErrEphemeralLimit is returned when creating an ephemeral cenv would pass
+// the number a process may hold at once
+var ErrEphemeralLimit = errors.New("too many ephemeral cenvs")
+
+// ephemeralCenv is a cenv whose database lives only in memory. The keeper
+// connection holds the shared-cache database open; it is gone once the
+// keeper and every pooled connection are closed.
+type ephemeralCenv struct {
+	keeper    *sql.DB
+	expiresAt time.Time
+}
+
+// ephemeralDSN names a cenv's in-memory database, shared by every
+// connection of this process
+func ephemeralDSN(cenvID string) string {
+	return "file:wce-ephemeral-" + cenvID + "?mode=memory&cache=shared&" + sqlite.ForeignKeysParam
+}
+
+// CreateEphemeral creates a cenv that lives in memory for ttl, for previews,
+// demos and tests. It is never written to disk, is served only by this
+// process and is dropped by ExpireEphemeral or on restart. A ttl of 0 uses
+// DefaultEphemeralTTL.
+func (m *Manager) CreateEphemeral(cenvID string, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = DefaultEphemeralTTL
+	}
+	if ttl < 0 || ttl > MaxEphemeralTTL {
+		return fmt.Errorf("ephemeral ttl must be between 0 and %s", MaxEphemeralTTL)
+	}
+
+	m.ephemeralMu.Lock()
+	defer m.ephemeralMu.Unlock()
+	if m.ephemeral == nil {
+		m.ephemeral = map[string]*ephemeralCenv{}
+	}
+	if len(m.ephemeral) >= maxEphemeralCenvs {
+		return ErrEphemeralLimit
+	}
+	if m.ephemeral[cenvID] != nil || m.store.Exists(cenvID) {
+		return fmt.Errorf("cenv %s already exists", cenvID)
+	}
+
+	keeper, err := sql.Open(sqlite.DriverName, ephemeralDSN(cenvID))
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	keeper.SetMaxOpenConns(1)
+	keeper.SetConnMaxLifetime(0)
+	keeper.SetConnMaxIdleTime(0)
+	// Connections share the keeper's pager, so its page cap bounds every
+	// write; past it SQLite reports the database full
+	if _, err := keeper.Exec(fmt.Sprintf("PRAGMA max_page_count = %d", maxEphemeralPages)); err != nil {
+		keeper.Close()
+		return fmt.Errorf("failed to limit database size: %w", err)
+	}
+	if _, err := keeper.Exec(db.Schema); err != nil {
+		keeper.Close()
+		return fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	m.ephemeral[cenvID] = &ephemeralCenv{keeper: keeper, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// EphemeralExpiry returns when an ephemeral cenv expires, and false for
+// cenvs that are not ephemeral
+func (m *Manager) EphemeralExpiry(cenvID string) (time.Time, bool) {
+	m.ephemeralMu.RLock()
+	defer m.ephemeralMu.RUnlock()
+	if e := m.ephemeral[cenvID]; e != nil {
+		return e.expiresAt, true
+	}
+	return time.Time{}, false
+}
+
+// isEphemeral reports whether a cenv lives in memory
+func (m *Manager) isEphemeral(cenvID string) bool {
+	_, ok := m.EphemeralExpiry(cenvID)
+	return ok
+}
+
+// ExpireEphemeral drops the ephemeral cenvs whose TTL has passed as of now,
+// freeing their memory, and returns their IDs
+func (m *Manager) ExpireEphemeral(now time.Time) []string {
+	m.ephemeralMu.Lock()
+	var expired []string
+	for cenvID, e := range m.ephemeral {
+		if !now.Before(e.expiresAt) {
+			expired = append(expired, cenvID)
+		}
+	}
+	m.ephemeralMu.Unlock()
+
+	sort.Strings(expired)
+	for _, cenvID := range expired {
+		m.dropEphemeral(cenvID)
+	}
+	return expired
+}
+
+// dropEphemeral closes every connection to an ephemeral cenv, which frees
+// its database
+func (m *Manager) dropEphemeral(cenvID string) error {
+	m.ephemeralMu.Lock()
+	e := m.ephemeral[cenvID]
+	delete(m.ephemeral, cenvID)
+	m.ephemeralMu.Unlock()
+	if e == nil {
+		return fmt.Errorf("cenv %s does not exist", cenvID)
+	}
+
+	m.CloseConnection(cenvID)
+	m.lastAccess.Delete(cenvID)
+	return e.keeper.Close()
+}
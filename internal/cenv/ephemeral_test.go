@@ -0,0 +1,112 @@
+package cenv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEphemeralCenv(t *testing.T) {
+	storageDir := t.TempDir()
+	manager := NewManager(storageDir)
+	defer manager.CloseAll()
+
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	if err := manager.CreateEphemeral(cenvID, time.Minute); err != nil {
+		t.Fatalf("CreateEphemeral failed: %v", err)
+	}
+	if err := manager.Create(cenvID); err == nil {
+		t.Error("Expected Create to refuse an ephemeral cenv's id")
+	}
+	if !manager.Exists(cenvID) {
+		t.Fatal("Expected the ephemeral cenv to exist")
+	}
+	expiresAt, ok := manager.EphemeralExpiry(cenvID)
+	if !ok || time.Until(expiresAt) <= 0 || time.Until(expiresAt) > time.Minute {
+		t.Errorf("Expected expiry within a minute, got %v (%v)", expiresAt, ok)
+	}
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("GetConnection failed: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO _wce_config (key, value, updated_at) VALUES ('probe', 'x', 0)"); err != nil {
+		t.Fatalf("Expected the schema initialized, got %v", err)
+	}
+
+	// Connections opened later share the same in-memory database
+	other, err := manager.Open(cenvID)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	var value string
+	if err := other.QueryRow("SELECT value FROM _wce_config WHERE key = 'probe'").Scan(&value); err != nil || value != "x" {
+		t.Errorf("Expected the probe through a new connection, got %q (%v)", value, err)
+	}
+	other.Close()
+
+	// The database cannot grow past its page cap
+	big := strings.Repeat("x", 1<<20)
+	var fullErr error
+	for i := 0; i < 64 && fullErr == nil; i++ {
+		_, fullErr = db.Exec("INSERT INTO _wce_config (key, value, updated_at) VALUES (?, ?, 0)", fmt.Sprint("big", i), big)
+	}
+	if fullErr == nil || !strings.Contains(fullErr.Error(), "full") {
+		t.Errorf("Expected the database to fill up, got %v", fullErr)
+	}
+	db.Exec("DELETE FROM _wce_config WHERE key LIKE 'big%'")
+
+	entries, _ := os.ReadDir(storageDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected nothing written to disk, found %d entries", len(entries))
+	}
+	if ids, _ := manager.List(); len(ids) != 0 {
+		t.Errorf("Expected ephemeral cenvs unlisted, got %v", ids)
+	}
+
+	if expired := manager.ExpireEphemeral(time.Now()); len(expired) != 0 {
+		t.Errorf("Expected nothing expired yet, got %v", expired)
+	}
+	expired := manager.ExpireEphemeral(time.Now().Add(time.Minute))
+	if len(expired) != 1 || expired[0] != cenvID {
+		t.Fatalf("Expected %s expired, got %v", cenvID, expired)
+	}
+	if manager.Exists(cenvID) {
+		t.Error("Expected the expired cenv gone")
+	}
+
+	// The memory is released, so recreating starts empty
+	if err := manager.CreateEphemeral(cenvID, 0); err != nil {
+		t.Fatalf("CreateEphemeral failed: %v", err)
+	}
+	db, _ = manager.GetConnection(cenvID)
+	if err := db.QueryRow("SELECT value FROM _wce_config WHERE key = 'probe'").Scan(&value); err == nil {
+		t.Error("Expected a fresh database after expiry")
+	}
+	if err := manager.Delete(cenvID); err != nil || manager.Exists(cenvID) {
+		t.Errorf("Expected Delete to drop the ephemeral cenv, got %v", err)
+	}
+}
+
+func TestEphemeralCenv_Limits(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	defer manager.CloseAll()
+
+	if err := manager.CreateEphemeral("123e4567-e89b-12d3-a456-426614174000", MaxEphemeralTTL+time.Second); err == nil {
+		t.Error("Expected a ttl over the maximum to be rejected")
+	}
+
+	// Stand-ins fill the table without holding databases
+	manager.ephemeral = map[string]*ephemeralCenv{}
+	for i := 0; i < maxEphemeralCenvs; i++ {
+		manager.ephemeral[fmt.Sprintf("placeholder-%d", i)] = &ephemeralCenv{}
+	}
+	err := manager.CreateEphemeral("123e4567-e89b-12d3-a456-426614174001", time.Minute)
+	if !errors.Is(err, ErrEphemeralLimit) {
+		t.Errorf("Expected ErrEphemeralLimit, got %v", err)
+	}
+	manager.ephemeral = nil
+}
@@ -0,0 +1,409 @@
+//go:build libsql
+
+package cenv
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// LibSQLStore is a Store keeping each cenv in its own libSQL database.
+// Create and Delete provision databases through the sqld admin API when an
+// AdminURL is set. Snapshots are taken with the server's /dump endpoint and
+// loaded into a local SQLite file. Exists caches cenvs it has seen, so a
+// cenv deleted by another node fails on Open rather than on Exists.
+type LibSQLStore struct {
+	config    LibSQLConfig
+	authToken string
+	catalog   *sql.DB
+	client    *http.Client
+	known     sync.Map // cenvID -> struct{}, cenvs found in the catalog
+}
+
+var _ Store = (*LibSQLStore)(nil)
+
+// NewLibSQLStore connects to the catalog database, creating its table if
+// needed. The auth token is read from the environment variable the config
+// names.
+func NewLibSQLStore(config LibSQLConfig) (*LibSQLStore, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	s := &LibSQLStore{
+		config:    config,
+		authToken: os.Getenv(config.AuthTokenEnv),
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+
+	catalog, err := s.connect(config.CatalogURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	_, err = catalog.Exec(`CREATE TABLE IF NOT EXISTS _wce_cenvs (
+		id TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		catalog.Close()
+		return nil, fmt.Errorf("failed to initialize catalog: %w", err)
+	}
+	s.catalog = catalog
+	return s, nil
+}
+
+// openLibSQLStore connects to the store a registry configures
+func openLibSQLStore(config LibSQLConfig) (Store, error) {
+	store, err := NewLibSQLStore(config)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Close closes the catalog connection
+func (s *LibSQLStore) Close() error {
+	return s.catalog.Close()
+}
+
+// databaseURL returns the URL of a cenv's database
+func (s *LibSQLStore) databaseURL(cenvID string) string {
+	return strings.ReplaceAll(s.config.URL, "{cenv}", cenvID)
+}
+
+// connect opens a connection pool to a libSQL database URL
+func (s *LibSQLStore) connect(rawURL string) (*sql.DB, error) {
+	var opts []libsql.Option
+	if s.authToken != "" {
+		opts = append(opts, libsql.WithAuthToken(s.authToken))
+	}
+	connector, err := libsql.NewConnector(rawURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(foreignKeysConnector{connector}), nil
+}
+
+// foreignKeysConnector enables foreign keys on every new connection, as the
+// local store's DSN does
+type foreignKeysConnector struct {
+	driver.Connector
+}
+
+func (c foreignKeysConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		if _, err := execer.ExecContext(ctx, "PRAGMA foreign_keys = ON", nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// admin calls the sqld admin API for a cenv's namespace
+func (s *LibSQLStore) admin(method, cenvID, action string) error {
+	endpoint := strings.TrimSuffix(s.config.AdminURL, "/") + "/v1/namespaces/" + cenvID + action
+	req, err := http.NewRequest(method, endpoint, strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.authToken)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("admin API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *LibSQLStore) Create(cenvID string) error {
+	if s.Exists(cenvID) {
+		return fmt.Errorf("cenv %s already exists", cenvID)
+	}
+	if s.config.AdminURL != "" {
+		if err := s.admin(http.MethodPost, cenvID, "/create"); err != nil {
+			return fmt.Errorf("failed to create database: %w", err)
+		}
+	}
+	if _, err := s.catalog.Exec("INSERT INTO _wce_cenvs (id, created_at) VALUES (?, ?)", cenvID, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to record cenv: %w", err)
+	}
+	s.known.Store(cenvID, struct{}{})
+	return nil
+}
+
+func (s *LibSQLStore) Open(cenvID string) (*sql.DB, error) {
+	connection, err := s.connect(s.databaseURL(cenvID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return connection, nil
+}
+
+func (s *LibSQLStore) Exists(cenvID string) bool {
+	if _, ok := s.known.Load(cenvID); ok {
+		return true
+	}
+	var one int
+	if err := s.catalog.QueryRow("SELECT 1 FROM _wce_cenvs WHERE id = ?", cenvID).Scan(&one); err != nil {
+		return false
+	}
+	s.known.Store(cenvID, struct{}{})
+	return true
+}
+
+func (s *LibSQLStore) Delete(cenvID string) error {
+	if s.config.AdminURL != "" {
+		if err := s.admin(http.MethodDelete, cenvID, ""); err != nil {
+			return fmt.Errorf("failed to delete database: %w", err)
+		}
+	}
+	if _, err := s.catalog.Exec("DELETE FROM _wce_cenvs WHERE id = ?", cenvID); err != nil {
+		return fmt.Errorf("failed to remove cenv from catalog: %w", err)
+	}
+	s.known.Delete(cenvID)
+	return nil
+}
+
+// Snapshot loads the database's SQL dump into a temporary SQLite file and
+// copies that. Databases given by file: URLs are vacuumed into it instead.
+func (s *LibSQLStore) Snapshot(cenvID string, w io.Writer) error {
+	dir, err := os.MkdirTemp("", "wce-snapshot-")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotPath := filepath.Join(dir, cenvID+".db")
+
+	if strings.HasPrefix(s.databaseURL(cenvID), "file:") {
+		connection, err := s.Open(cenvID)
+		if err != nil {
+			return err
+		}
+		defer connection.Close()
+		if _, err := connection.Exec("VACUUM INTO ?", snapshotPath); err != nil {
+			return fmt.Errorf("failed to snapshot database: %w", err)
+		}
+	} else {
+		dumpPath := filepath.Join(dir, "dump.sql")
+		if err := s.dump(cenvID, dumpPath); err != nil {
+			return fmt.Errorf("failed to dump database: %w", err)
+		}
+		if err := loadDump(dumpPath, snapshotPath); err != nil {
+			return fmt.Errorf("failed to load database dump: %w", err)
+		}
+	}
+
+	in, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	defer in.Close()
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// dump downloads a database's SQL dump from the server's /dump endpoint to
+// a file at path
+func (s *LibSQLStore) dump(cenvID, path string) error {
+	u, err := url.Parse(s.databaseURL(cenvID))
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "libsql", "wss":
+		u.Scheme = "https"
+	case "ws":
+		u.Scheme = "http"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/dump"
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if s.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.authToken)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// maxDumpStatement bounds a single statement of a dump, the only part of it
+// held in memory while loading
+const maxDumpStatement = 64 << 20
+
+// loadDump runs the SQL dump at dumpPath against a new SQLite database at
+// dbPath one statement at a time, on one connection so the dump's own
+// transaction spans them
+func loadDump(dumpPath, dbPath string) error {
+	in, err := os.Open(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	local, err := sql.Open(sqlite.DriverName, dbPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	conn, err := local.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(in)
+	var statement strings.Builder
+	var state dumpScanner
+	for {
+		line, err := reader.ReadString('\n')
+		if statement.Len()+len(line) > maxDumpStatement {
+			return fmt.Errorf("statement exceeds %d bytes", maxDumpStatement)
+		}
+		statement.WriteString(line)
+		if state.scan(line) || (err == io.EOF && strings.TrimSpace(statement.String()) != "") {
+			if _, err := conn.ExecContext(context.Background(), statement.String()); err != nil {
+				return err
+			}
+			statement.Reset()
+			state = dumpScanner{}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dumpScanner finds where the statements of an SQL dump end. A statement
+// ends at a semicolon outside quotes and comments, except that a CREATE
+// TRIGGER statement's body holds semicolons and ends at "; END;", as
+// sqlite3_complete decides.
+type dumpScanner struct {
+	quote   byte // Closing quote character while inside a quoted token
+	comment bool // Inside a /* */ comment
+	text    strings.Builder
+}
+
+// scan consumes the next line of a statement and reports whether the
+// statement is complete
+func (d *dumpScanner) scan(line string) bool {
+	complete := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case d.comment:
+			if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+				d.comment = false
+				i++
+			}
+			continue
+		case d.quote != 0:
+			if c == d.quote {
+				d.quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			d.quote = c
+		case c == '[':
+			d.quote = ']'
+		case c == '-' && i+1 < len(line) && line[i+1] == '-':
+			i = len(line) // Comment to the end of the line
+			continue
+		case c == '/' && i+1 < len(line) && line[i+1] == '*':
+			d.comment = true
+			i++
+			continue
+		case c == ';':
+			d.text.WriteByte(c)
+			complete = d.statementEnds()
+			continue
+		}
+		d.text.WriteByte(c)
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			complete = false
+		}
+	}
+	return complete
+}
+
+// statementEnds reports whether the semicolon just scanned ends the statement
+func (d *dumpScanner) statementEnds() bool {
+	text := strings.ToUpper(d.text.String())
+	fields := strings.Fields(strings.SplitN(text, "(", 2)[0])
+	trigger := len(fields) >= 2 && fields[0] == "CREATE" &&
+		(fields[1] == "TRIGGER" || (len(fields) >= 3 && (fields[1] == "TEMP" || fields[1] == "TEMPORARY") && fields[2] == "TRIGGER"))
+	if !trigger {
+		return true
+	}
+	body := strings.TrimSpace(strings.TrimSuffix(text, ";"))
+	before, found := strings.CutSuffix(body, "END")
+	return found && strings.HasSuffix(strings.TrimSpace(before), ";")
+}
+
+func (s *LibSQLStore) List() ([]string, error) {
+	rows, err := s.catalog.Query("SELECT id FROM _wce_cenvs ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan catalog: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating catalog: %w", err)
+	}
+	return ids, nil
+}
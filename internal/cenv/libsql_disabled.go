@@ -0,0 +1,11 @@
+//go:build !libsql
+
+package cenv
+
+import "errors"
+
+// openLibSQLStore fails in builds without the libsql tag, which leave out
+// the libSQL client and its dependencies
+func openLibSQLStore(config LibSQLConfig) (Store, error) {
+	return nil, errors.New("this build has no libSQL support, rebuild with -tags libsql")
+}
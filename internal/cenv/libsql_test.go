@@ -0,0 +1,193 @@
+//go:build libsql
+
+package cenv
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestLibSQLStore(t *testing.T) {
+	dataDir := t.TempDir()
+
+	var mu sync.Mutex
+	var calls []string
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer admin.Close()
+
+	t.Setenv("WCE_TEST_LIBSQL_TOKEN", "secret-token")
+	storageDir := t.TempDir()
+	writeRegistry(t, storageDir, `{"libsql": {
+		"url": "file://`+dataDir+`/{cenv}.db",
+		"catalog_url": "file://`+dataDir+`/catalog.db",
+		"admin_url": "`+admin.URL+`",
+		"auth_token_env": "WCE_TEST_LIBSQL_TOKEN"
+	}}`)
+
+	manager := NewManager(storageDir)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	defer manager.CloseAll()
+	if _, ok := manager.store.(*LibSQLStore); !ok {
+		t.Fatalf("Expected a libSQL store, got %T", manager.store)
+	}
+
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := manager.Create(cenvID); err == nil {
+		t.Error("Expected creating an existing cenv to fail")
+	}
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("GetConnection failed: %v", err)
+	}
+	var foreignKeys int
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Errorf("Expected foreign keys enabled, got %d (%v)", foreignKeys, err)
+	}
+	if _, err := db.Exec("INSERT INTO _wce_config (key, value, updated_at) VALUES ('probe', 'x', 0)"); err != nil {
+		t.Fatalf("Expected the schema initialized, got %v", err)
+	}
+
+	// A second node sharing the catalog sees the cenv
+	other, err := NewLibSQLStore(LibSQLConfig{URL: "file://" + dataDir + "/{cenv}.db", CatalogURL: "file://" + dataDir + "/catalog.db"})
+	if err != nil {
+		t.Fatalf("NewLibSQLStore failed: %v", err)
+	}
+	defer other.Close()
+	if ids, err := other.List(); err != nil || len(ids) != 1 || ids[0] != cenvID {
+		t.Errorf("Expected [%s] in the shared catalog, got %v (%v)", cenvID, ids, err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := manager.Snapshot(cenvID, &snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if !bytes.HasPrefix(snapshot.Bytes(), []byte("SQLite format 3\x00")) {
+		t.Error("Expected the snapshot to be an SQLite database")
+	}
+
+	if err := manager.Delete(cenvID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if manager.Exists(cenvID) || other.Exists(cenvID) {
+		t.Error("Expected cenv gone after Delete")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"POST /v1/namespaces/" + cenvID + "/create Bearer secret-token",
+		"DELETE /v1/namespaces/" + cenvID + " Bearer secret-token",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected admin calls %v, got %v", want, calls)
+	}
+}
+
+func TestLibSQLStore_DumpSnapshot(t *testing.T) {
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db/"+cenvID+"/dump" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("BEGIN TRANSACTION;\nCREATE TABLE notes (body TEXT);\nINSERT INTO notes VALUES('dumped');\nCOMMIT;\n"))
+	}))
+	defer server.Close()
+
+	store, err := NewLibSQLStore(LibSQLConfig{
+		URL:        server.URL + "/db/{cenv}",
+		CatalogURL: "file://" + filepath.Join(t.TempDir(), "catalog.db"),
+	})
+	if err != nil {
+		t.Fatalf("NewLibSQLStore failed: %v", err)
+	}
+	defer store.Close()
+
+	var snapshot bytes.Buffer
+	if err := store.Snapshot(cenvID, &snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "copy.db")
+	os.WriteFile(path, snapshot.Bytes(), 0600)
+	copied, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer copied.Close()
+	var body string
+	if err := copied.QueryRow("SELECT body FROM notes").Scan(&body); err != nil || body != "dumped" {
+		t.Errorf("Expected the dump loaded, got %q (%v)", body, err)
+	}
+
+	if err := store.Snapshot("00000000-0000-0000-0000-000000000000", &snapshot); err == nil {
+		t.Error("Expected a failed dump to fail the snapshot")
+	}
+}
+
+func TestLoadDump(t *testing.T) {
+	dir := t.TempDir()
+	dumpPath := filepath.Join(dir, "dump.sql")
+	// Statements split across lines, semicolons in strings and comments, and
+	// a trigger whose body holds statements and a CASE ... END
+	dump := `PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE notes (body TEXT, kind TEXT); -- trailing; comment
+CREATE TABLE log (entry TEXT);
+CREATE TRIGGER notes_log AFTER INSERT ON notes BEGIN
+  INSERT INTO log VALUES (CASE WHEN new.kind = 'a' THEN 'first;' ELSE 'other' END);
+  INSERT INTO log VALUES ('second');
+END;
+INSERT INTO notes VALUES('line one;
+line two', 'a');
+/* block; comment */ INSERT INTO notes VALUES('it''s', "b");
+COMMIT;
+`
+	if err := os.WriteFile(dumpPath, []byte(dump), 0600); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+
+	dbPath := filepath.Join(dir, "loaded.db")
+	if err := loadDump(dumpPath, dbPath); err != nil {
+		t.Fatalf("loadDump failed: %v", err)
+	}
+	db, err := sql.Open(sqlite.DriverName, dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	var body string
+	if err := db.QueryRow("SELECT body FROM notes WHERE kind = 'a'").Scan(&body); err != nil || body != "line one;\nline two" {
+		t.Errorf("Expected the multi-line string, got %q (%v)", body, err)
+	}
+	var entries int
+	if err := db.QueryRow("SELECT COUNT(*) FROM log").Scan(&entries); err != nil || entries != 4 {
+		t.Errorf("Expected the trigger to log 4 entries, got %d (%v)", entries, err)
+	}
+
+	if err := os.WriteFile(dumpPath, []byte("CREATE TABLE broken (;\n"), 0600); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+	if err := loadDump(dumpPath, filepath.Join(dir, "broken.db")); err == nil {
+		t.Error("Expected an invalid dump to fail")
+	}
+}
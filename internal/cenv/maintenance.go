@@ -0,0 +1,102 @@
+package cenv
+
+import (
+	"fmt"
+
+	"github.com/thetanil/wce/internal/db"
+)
+
+// Migrate applies the current schema to a cenv database, creating the system
+// tables, indexes and default settings added since the cenv was created.
+// The schema only creates what is missing, so migrating again is harmless.
+func (m *Manager) Migrate(cenvID string) error {
+	connection, err := m.Open(cenvID)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	tx, err := connection.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(db.Schema); err != nil {
+		return fmt.Errorf("failed to execute schema: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schema: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds a cenv database, returning the space freed by deletes to
+// the filesystem. It needs free space for a full copy of the database and
+// blocks writers while it runs.
+func (m *Manager) Vacuum(cenvID string) error {
+	connection, err := m.Open(cenvID)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	if _, err := connection.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// CheckIntegrity runs SQLite's integrity and foreign key checks on a cenv
+// database and returns the problems they report, none for a sound database
+func (m *Manager) CheckIntegrity(cenvID string) ([]string, error) {
+	connection, err := m.Open(cenvID)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	problems := []string{}
+	rows, err := connection.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check integrity: %w", err)
+	}
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		if message != "ok" {
+			problems = append(problems, message)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating integrity check: %w", err)
+	}
+
+	rows, err = connection.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowID *int64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key check: %w", err)
+		}
+		row := "a row"
+		if rowID != nil {
+			row = fmt.Sprintf("row %d", *rowID)
+		}
+		problems = append(problems, fmt.Sprintf("%s of %s references a missing %s row", row, table, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating foreign key check: %w", err)
+	}
+
+	return problems, nil
+}
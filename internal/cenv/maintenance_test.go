@@ -0,0 +1,59 @@
+package cenv
+
+import (
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	connection, err := manager.Open(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer connection.Close()
+	connection.SetMaxOpenConns(1)
+
+	// A table added to the schema after the cenv was created comes back
+	if _, err := connection.Exec("DROP TABLE _wce_feature_flag_overrides"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	if err := manager.Migrate(cenvID); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	var count int
+	connection.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = '_wce_feature_flag_overrides'").Scan(&count)
+	if count != 1 {
+		t.Error("Expected Migrate to recreate the missing table")
+	}
+	if err := manager.Migrate(cenvID); err != nil {
+		t.Errorf("Expected migrating twice to succeed: %v", err)
+	}
+
+	if err := manager.Vacuum(cenvID); err != nil {
+		t.Errorf("Vacuum failed: %v", err)
+	}
+
+	problems, err := manager.CheckIntegrity(cenvID)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("Expected a sound database, got %v: %v", problems, err)
+	}
+
+	// Rows written with foreign keys off are reported
+	connection.Exec("PRAGMA foreign_keys = OFF")
+	if _, err := connection.Exec(`INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at) VALUES ('s', 'nobody', 'h', 0, 0)`); err != nil {
+		t.Fatalf("Failed to insert orphan: %v", err)
+	}
+	problems, err = manager.CheckIntegrity(cenvID)
+	if err != nil || len(problems) != 1 {
+		t.Errorf("Expected one problem, got %v: %v", problems, err)
+	}
+
+	if err := manager.Vacuum("223e4567-e89b-12d3-a456-426614174000"); err == nil {
+		t.Error("Expected vacuuming a missing cenv to fail")
+	}
+}
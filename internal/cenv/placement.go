@@ -0,0 +1,378 @@
+package cenv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RegistryFile is the operator-maintained registry in the storage directory
+// that assigns cenvs attributes and maps attributes to storage volumes
+const RegistryFile = "storage.json"
+
+// Registry places cenvs on storage volumes by attribute, e.g. for data
+// residency ("region": "eu") or hot/cold tiering ("tier": "cold"). A cenv is
+// stored on the first volume whose Match attributes it all has, and in the
+// storage directory when none match. With an Archive policy, idle cenvs are
+// compressed into cold storage and listed in Archived until restored. The
+// registry also keeps each cenv's size history for growth alerts. With a
+// LibSQL store, databases live on a libSQL server instead of any volume.
+type Registry struct {
+	Volumes  []Volume                     `json:"volumes"`
+	Cenvs    map[string]map[string]string `json:"cenvs"`              // cenvID -> attributes
+	Archive  *ArchivePolicy               `json:"archive,omitempty"`  // nil disables archiving
+	Archived map[string]int64             `json:"archived,omitempty"` // cenvID -> Unix timestamp archived
+
+	SizeAlerts *SizeAlertPolicy        `json:"size_alerts,omitempty"`
+	Sizes      map[string][]SizeSample `json:"sizes,omitempty"` // cenvID -> size history, see RecordSizes
+
+	LibSQL *LibSQLConfig `json:"libsql,omitempty"` // nil keeps databases in local files
+}
+
+// Volume is a storage root for cenvs with matching attributes
+type Volume struct {
+	Name  string            `json:"name"`
+	Path  string            `json:"path"`
+	Match map[string]string `json:"match"`
+}
+
+// validate checks that every volume has a name, a path and attributes to
+// match, that archive and size alert policies are complete and that a libSQL
+// store replaces volumes rather than adding to them
+func (r *Registry) validate() error {
+	names := map[string]bool{}
+	for _, volume := range r.Volumes {
+		if volume.Name == "" {
+			return fmt.Errorf("volume name cannot be empty")
+		}
+		if names[volume.Name] {
+			return fmt.Errorf("duplicate volume %s", volume.Name)
+		}
+		names[volume.Name] = true
+		if volume.Path == "" {
+			return fmt.Errorf("volume %s has no path", volume.Name)
+		}
+		if len(volume.Match) == 0 {
+			return fmt.Errorf("volume %s has no attributes to match", volume.Name)
+		}
+	}
+	for cenvID := range r.Cenvs {
+		if !IsValidUUID(cenvID) {
+			return fmt.Errorf("invalid cenv id %s", cenvID)
+		}
+	}
+	if r.Archive != nil {
+		if r.Archive.Path == "" {
+			return fmt.Errorf("archive has no path")
+		}
+		if r.Archive.IdleDays < 1 {
+			return fmt.Errorf("archive idle_days must be at least 1")
+		}
+	}
+	if r.SizeAlerts != nil {
+		if err := r.SizeAlerts.validate(); err != nil {
+			return err
+		}
+	}
+	if r.LibSQL != nil {
+		if len(r.Volumes) > 0 || r.Archive != nil {
+			return fmt.Errorf("volumes and archiving need local storage and cannot be combined with libsql")
+		}
+		if err := r.LibSQL.validate(); err != nil {
+			return err
+		}
+	}
+	if len(r.Archived) > 0 && r.Archive == nil {
+		return fmt.Errorf("archived cenvs need an archive policy to restore from")
+	}
+	for cenvID := range r.Archived {
+		if !IsValidUUID(cenvID) {
+			return fmt.Errorf("invalid archived cenv id %s", cenvID)
+		}
+	}
+	return nil
+}
+
+// volumeFor returns the volume whose attributes the cenv has, or nil for
+// the storage directory
+func (r *Registry) volumeFor(cenvID string) *Volume {
+	attributes := r.Cenvs[cenvID]
+	for i, volume := range r.Volumes {
+		matched := true
+		for key, value := range volume.Match {
+			if attributes[key] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &r.Volumes[i]
+		}
+	}
+	return nil
+}
+
+// LoadRegistry reads RegistryFile from the storage directory and moves every
+// cenv stored on the wrong volume to the one its attributes select, or
+// switches to a libSQL store if the registry configures one. Without a
+// registry file every cenv stays in the storage directory.
+func (m *Manager) LoadRegistry() error {
+	data, err := os.ReadFile(filepath.Join(m.storageDir, RegistryFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	var registry Registry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return fmt.Errorf("failed to parse registry: %w", err)
+	}
+	if err := registry.validate(); err != nil {
+		return fmt.Errorf("invalid registry: %w", err)
+	}
+	if registry.LibSQL != nil {
+		store, err := openLibSQLStore(*registry.LibSQL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to libsql: %w", err)
+		}
+		m.SetStore(store)
+	}
+
+	m.mu.Lock()
+	m.registry = &registry
+	m.mu.Unlock()
+
+	return m.applyPlacement()
+}
+
+// Attributes returns the registry attributes of a cenv
+func (m *Manager) Attributes(cenvID string) map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attributes := map[string]string{}
+	if m.registry != nil {
+		for key, value := range m.registry.Cenvs[cenvID] {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+// SetAttributes replaces a cenv's registry attributes, saves the registry and
+// moves the cenv's database if it now belongs on another volume. The cenv's
+// pooled connections are closed first; writes in flight on them fail.
+func (m *Manager) SetAttributes(cenvID string, attributes map[string]string) error {
+	if !IsValidUUID(cenvID) {
+		return fmt.Errorf("invalid cenv id %s", cenvID)
+	}
+
+	err := m.updateRegistry(func(registry *Registry) {
+		if len(attributes) == 0 {
+			delete(registry.Cenvs, cenvID)
+			return
+		}
+		registry.Cenvs[cenvID] = map[string]string{}
+		for key, value := range attributes {
+			registry.Cenvs[cenvID][key] = value
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.relocate(cenvID)
+}
+
+// updateRegistry applies change to a copy of the registry, saves it to
+// RegistryFile and makes it current
+func (m *Manager) updateRegistry(change func(registry *Registry)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	registry := Registry{
+		Cenvs:    map[string]map[string]string{},
+		Archived: map[string]int64{},
+		Sizes:    map[string][]SizeSample{},
+	}
+	if m.registry != nil {
+		registry.Volumes = m.registry.Volumes
+		registry.Archive = m.registry.Archive
+		registry.SizeAlerts = m.registry.SizeAlerts
+		for id, attributes := range m.registry.Cenvs {
+			registry.Cenvs[id] = attributes
+		}
+		for id, archivedAt := range m.registry.Archived {
+			registry.Archived[id] = archivedAt
+		}
+		for id, history := range m.registry.Sizes {
+			registry.Sizes[id] = history
+		}
+	}
+	change(&registry)
+
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode registry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.storageDir, RegistryFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to save registry: %w", err)
+	}
+
+	m.registry = &registry
+	return nil
+}
+
+// roots returns the storage directory followed by every volume path
+func (m *Manager) roots() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roots := []string{m.storageDir}
+	if m.registry != nil {
+		for _, volume := range m.registry.Volumes {
+			roots = append(roots, volume.Path)
+		}
+	}
+	return roots
+}
+
+// locate returns the paths of every copy of a cenv's database across roots
+func (m *Manager) locate(cenvID string) []string {
+	var found []string
+	for _, root := range m.roots() {
+		path := filepath.Join(root, cenvID+".db")
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+	return found
+}
+
+// applyPlacement relocates every cenv to the volume its attributes select.
+// Volumes only hold the local store's files.
+func (m *Manager) applyPlacement() error {
+	if !m.isLocal() {
+		return nil
+	}
+
+	cenvIDs, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, cenvID := range cenvIDs {
+		if err := m.relocate(cenvID); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to place %d cenvs: %v", len(failed), failed)
+	}
+	return nil
+}
+
+// relocate moves a cenv's database, with its WAL and shared memory files, to
+// the path GetDatabasePath selects. A cenv found on several roots is left
+// alone, since either copy may be the current one.
+func (m *Manager) relocate(cenvID string) error {
+	target := filepath.Clean(m.GetDatabasePath(cenvID))
+
+	found := m.locate(cenvID)
+	if len(found) == 0 || (len(found) == 1 && found[0] == target) {
+		return nil
+	}
+	if len(found) > 1 {
+		return fmt.Errorf("cenv %s is stored on several volumes: %v", cenvID, found)
+	}
+
+	if err := m.CloseConnection(cenvID); err != nil {
+		return fmt.Errorf("failed to close cenv %s: %w", cenvID, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return fmt.Errorf("failed to create volume directory: %w", err)
+	}
+
+	// Move the database last so it only appears at the target once its
+	// journal files are already there
+	for _, suffix := range []string{"-wal", "-shm", ""} {
+		source := found[0] + suffix
+		if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := moveFile(source, target+suffix); err != nil {
+			return fmt.Errorf("failed to move cenv %s: %w", cenvID, err)
+		}
+	}
+	return nil
+}
+
+// moveFile renames a file, falling back to copy and remove across filesystems
+func moveFile(source, target string) error {
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	return os.Remove(source)
+}
+
+// LibSQLConfig places cenv databases on a libSQL server (sqld) or Turso
+// instead of local files, so WCE nodes can share replicated storage. A
+// catalog database lists the cenvs the store holds.
+type LibSQLConfig struct {
+	URL          string `json:"url"`                      // Database URL, {cenv} is replaced by the cenv ID
+	CatalogURL   string `json:"catalog_url"`              // Database listing the store's cenvs
+	AdminURL     string `json:"admin_url,omitempty"`      // sqld admin API; without it databases are provisioned outside WCE
+	AuthTokenEnv string `json:"auth_token_env,omitempty"` // Environment variable holding the auth token
+}
+
+// validate checks that the URLs are usable
+func (c *LibSQLConfig) validate() error {
+	if !strings.Contains(c.URL, "{cenv}") {
+		return fmt.Errorf("libsql url must contain {cenv}")
+	}
+	for name, raw := range map[string]string{"url": c.URL, "catalog_url": c.CatalogURL, "admin_url": c.AdminURL} {
+		if raw == "" && name != "admin_url" {
+			return fmt.Errorf("libsql %s cannot be empty", name)
+		}
+		if _, err := url.Parse(raw); err != nil {
+			return fmt.Errorf("invalid libsql %s: %w", name, err)
+		}
+	}
+	return nil
+}
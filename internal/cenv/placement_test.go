@@ -0,0 +1,149 @@
+package cenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRegistry(t *testing.T, dir, registry string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, RegistryFile), []byte(registry), 0600); err != nil {
+		t.Fatalf("Failed to write registry: %v", err)
+	}
+}
+
+func TestLoadRegistry(t *testing.T) {
+	storageDir := t.TempDir()
+	euDir := filepath.Join(t.TempDir(), "eu")
+	coldDir := filepath.Join(t.TempDir(), "cold")
+	manager := NewManager(storageDir)
+
+	euCenv := "123e4567-e89b-12d3-a456-426614174000"
+	coldCenv := "223e4567-e89b-12d3-a456-426614174000"
+	plainCenv := "323e4567-e89b-12d3-a456-426614174000"
+	for _, id := range []string{euCenv, coldCenv, plainCenv} {
+		if err := manager.Create(id); err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+	}
+
+	// No registry file leaves every cenv in the storage directory
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry without a file failed: %v", err)
+	}
+
+	writeRegistry(t, storageDir, `{
+		"volumes": [
+			{"name": "eu", "path": "`+euDir+`", "match": {"region": "eu"}},
+			{"name": "cold", "path": "`+coldDir+`", "match": {"tier": "cold"}}
+		],
+		"cenvs": {
+			"`+euCenv+`": {"region": "eu", "tier": "cold"},
+			"`+coldCenv+`": {"tier": "cold"}
+		}
+	}`)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+
+	// The first matching volume wins
+	expected := map[string]string{
+		euCenv:    filepath.Join(euDir, euCenv+".db"),
+		coldCenv:  filepath.Join(coldDir, coldCenv+".db"),
+		plainCenv: filepath.Join(storageDir, plainCenv+".db"),
+	}
+	for id, path := range expected {
+		if got := manager.GetDatabasePath(id); got != path {
+			t.Errorf("Expected %s at %s, got %s", id, path, got)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be moved to %s: %v", id, path, err)
+		}
+		conn, err := manager.GetConnection(id)
+		if err != nil {
+			t.Fatalf("Failed to open relocated cenv: %v", err)
+		}
+		var count int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM _wce_users").Scan(&count); err != nil {
+			t.Errorf("Relocated cenv %s is unreadable: %v", id, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, euCenv+".db")); !os.IsNotExist(err) {
+		t.Error("Expected the original database file to be gone")
+	}
+
+	ids, err := manager.List()
+	if err != nil || len(ids) != 3 {
+		t.Errorf("Expected 3 cenvs across volumes, got %v: %v", ids, err)
+	}
+	manager.CloseAll()
+}
+
+func TestSetAttributes(t *testing.T) {
+	storageDir := t.TempDir()
+	hotDir := filepath.Join(t.TempDir(), "hot")
+	manager := NewManager(storageDir)
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+
+	writeRegistry(t, storageDir, `{"volumes": [{"name": "hot", "path": "`+hotDir+`", "match": {"tier": "hot"}}]}`)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if _, err := manager.GetConnection(cenvID); err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+
+	if err := manager.SetAttributes(cenvID, map[string]string{"tier": "hot"}); err != nil {
+		t.Fatalf("SetAttributes failed: %v", err)
+	}
+	if !manager.Exists(cenvID) || manager.GetDatabasePath(cenvID) != filepath.Join(hotDir, cenvID+".db") {
+		t.Errorf("Expected cenv on the hot volume, got %s", manager.GetDatabasePath(cenvID))
+	}
+	if attrs := manager.Attributes(cenvID); attrs["tier"] != "hot" {
+		t.Errorf("Expected tier attribute, got %v", attrs)
+	}
+
+	// The saved registry survives a restart
+	restarted := NewManager(storageDir)
+	if err := restarted.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	if !restarted.Exists(cenvID) {
+		t.Error("Expected the saved registry to place the cenv on the hot volume")
+	}
+
+	if err := manager.SetAttributes(cenvID, nil); err != nil {
+		t.Fatalf("SetAttributes failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, cenvID+".db")); err != nil {
+		t.Errorf("Expected cenv back in the storage directory: %v", err)
+	}
+	manager.CloseAll()
+}
+
+func TestLoadRegistry_Invalid(t *testing.T) {
+	registries := map[string]string{
+		"NotJSON":         `{`,
+		"NoPath":          `{"volumes": [{"name": "eu", "match": {"region": "eu"}}]}`,
+		"NoMatch":         `{"volumes": [{"name": "eu", "path": "/data/eu"}]}`,
+		"DuplicateVolume": `{"volumes": [{"name": "eu", "path": "/a", "match": {"a": "1"}}, {"name": "eu", "path": "/b", "match": {"b": "1"}}]}`,
+		"InvalidCenv":     `{"cenvs": {"not-a-uuid": {"region": "eu"}}}`,
+		"LibSQLNoCenv":    `{"libsql": {"url": "libsql://db.example.com", "catalog_url": "libsql://catalog.example.com"}}`,
+		"LibSQLNoCatalog": `{"libsql": {"url": "libsql://{cenv}.example.com"}}`,
+		"LibSQLVolumes":   `{"libsql": {"url": "libsql://{cenv}.example.com", "catalog_url": "libsql://c.example.com"}, "volumes": [{"name": "eu", "path": "/a", "match": {"a": "1"}}]}`,
+	}
+
+	for name, registry := range registries {
+		t.Run(name, func(t *testing.T) {
+			storageDir := t.TempDir()
+			writeRegistry(t, storageDir, registry)
+			if err := NewManager(storageDir).LoadRegistry(); err == nil {
+				t.Error("Expected invalid registry to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package cenv
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// Store holds cenv databases. The Manager reaches databases only through its
+// store, so handlers work unchanged whichever backend keeps them. Schema
+// setup, connection pooling and cold storage stay with the Manager.
+type Store interface {
+	// Create creates an empty database, failing if it already exists
+	Create(cenvID string) error
+	// Open returns a new connection pool to an existing database
+	Open(cenvID string) (*sql.DB, error)
+	// Exists reports whether a database exists
+	Exists(cenvID string) bool
+	// Delete removes a database and everything stored alongside it
+	Delete(cenvID string) error
+	// Snapshot writes a consistent copy of a database as an SQLite file
+	Snapshot(cenvID string, w io.Writer) error
+	// List returns the IDs of every database, sorted
+	List() ([]string, error)
+}
+
+// localStore is the default Store: one SQLite file per cenv in the storage
+// directory, or on the volume the registry places it on
+type localStore struct {
+	m *Manager
+}
+
+var _ Store = (*localStore)(nil)
+
+func (s *localStore) Create(cenvID string) error {
+	dbPath := s.m.GetDatabasePath(cenvID)
+
+	// Volumes are created on first use
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0700); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	// Create the database file
+	connection, err := sql.Open(sqlite.DriverName, dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	defer connection.Close()
+
+	// Verify we can connect
+	if err := connection.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Set file permissions to 600 (owner read/write only)
+	if err := os.Chmod(dbPath, 0600); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	return nil
+}
+
+func (s *localStore) Open(cenvID string) (*sql.DB, error) {
+	dbPath := s.m.GetDatabasePath(cenvID)
+
+	// Open database connection
+	// Foreign keys are enabled in the DSN so every pooled connection enforces them
+	driver := s.m.driver
+	if driver == "" {
+		driver = sqlite.DriverName
+	}
+	connection, err := sql.Open(driver, dbPath+"?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Configure SQLite pragmas
+	pragmas := []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+	}
+
+	for _, pragma := range pragmas {
+		if _, err := connection.Exec(pragma); err != nil {
+			connection.Close()
+			return nil, fmt.Errorf("failed to set pragma: %w", err)
+		}
+	}
+
+	return connection, nil
+}
+
+func (s *localStore) Exists(cenvID string) bool {
+	_, err := os.Stat(s.m.GetDatabasePath(cenvID))
+	return err == nil
+}
+
+func (s *localStore) Delete(cenvID string) error {
+	dbPath := s.m.GetDatabasePath(cenvID)
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove database: %w", err)
+		}
+	}
+	return nil
+}
+
+// Snapshot vacuums the database into a temporary file beside it, which
+// includes committed WAL content without blocking writers, and copies that
+func (s *localStore) Snapshot(cenvID string, w io.Writer) error {
+	connection, err := s.Open(cenvID)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.m.GetDatabasePath(cenvID)), "."+cenvID+"-snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath) // VACUUM INTO requires that the target not exist
+	defer os.Remove(tmpPath)
+
+	if _, err := connection.Exec("VACUUM INTO ?", tmpPath); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+
+	in, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	defer in.Close()
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// List returns the cenvs in the storage directory and on the registry's
+// volumes
+func (s *localStore) List() ([]string, error) {
+	seen := map[string]bool{}
+	var ids []string
+	for i, root := range s.m.roots() {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			// A volume that was never used has no directory yet
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read storage directory: %w", err)
+		}
+
+		for _, entry := range entries {
+			id, ok := strings.CutSuffix(entry.Name(), ".db")
+			if ok && !entry.IsDir() && IsValidUUID(id) && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,182 @@
+package cenv
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// memoryStore keeps databases in shared-cache memory for as long as a keeper
+// connection to each stays open
+type memoryStore struct {
+	mu      sync.Mutex
+	name    string
+	keepers map[string]*sql.DB
+}
+
+func newMemoryStore(t *testing.T) *memoryStore {
+	return &memoryStore{name: t.Name(), keepers: map[string]*sql.DB{}}
+}
+
+func (s *memoryStore) dsn(cenvID string) string {
+	return fmt.Sprintf("file:%s-%s?mode=memory&cache=shared&%s", s.name, cenvID, sqlite.ForeignKeysParam)
+}
+
+func (s *memoryStore) Create(cenvID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keeper, err := sql.Open(sqlite.DriverName, s.dsn(cenvID))
+	if err != nil {
+		return err
+	}
+	keeper.SetMaxIdleConns(1)
+	if err := keeper.Ping(); err != nil {
+		return err
+	}
+	s.keepers[cenvID] = keeper
+	return nil
+}
+
+func (s *memoryStore) Open(cenvID string) (*sql.DB, error) {
+	return sql.Open(sqlite.DriverName, s.dsn(cenvID))
+}
+
+func (s *memoryStore) Exists(cenvID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keepers[cenvID]
+	return ok
+}
+
+func (s *memoryStore) Delete(cenvID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keeper, ok := s.keepers[cenvID]; ok {
+		delete(s.keepers, cenvID)
+		return keeper.Close()
+	}
+	return nil
+}
+
+func (s *memoryStore) Snapshot(cenvID string, w io.Writer) error {
+	return fmt.Errorf("not supported")
+}
+
+func (s *memoryStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.keepers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func TestManagerWithStore(t *testing.T) {
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	store := newMemoryStore(t)
+	defer store.Delete(cenvID)
+
+	manager := NewManager(t.TempDir())
+	manager.SetStore(store)
+	defer manager.CloseAll()
+
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := manager.Create(cenvID); err == nil {
+		t.Error("Expected creating an existing cenv to fail")
+	}
+	if _, err := os.Stat(manager.GetDatabasePath(cenvID)); !os.IsNotExist(err) {
+		t.Errorf("Expected no database file with a custom store, got %v", err)
+	}
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("GetConnection failed: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = '_wce_users'").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the schema initialized through the store, got %d (%v)", count, err)
+	}
+
+	ids, err := manager.List()
+	if err != nil || len(ids) != 1 || ids[0] != cenvID {
+		t.Errorf("Expected [%s], got %v (%v)", cenvID, ids, err)
+	}
+	if _, err := manager.DatabaseSize(cenvID); err == nil {
+		t.Error("Expected no database size for a custom store")
+	}
+
+	if err := manager.Delete(cenvID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if manager.Exists(cenvID) {
+		t.Error("Expected cenv gone after Delete")
+	}
+	if _, err := manager.Open(cenvID); err == nil {
+		t.Error("Expected Open to fail after Delete")
+	}
+}
+
+func TestLocalStoreDeleteAndSnapshot(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	if err := manager.Create(cenvID); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("GetConnection failed: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE notes (body TEXT); INSERT INTO notes VALUES ('kept')"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := manager.Snapshot(cenvID, &snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if !bytes.HasPrefix(snapshot.Bytes(), []byte("SQLite format 3\x00")) {
+		t.Fatal("Expected the snapshot to be an SQLite database")
+	}
+	copyPath := filepath.Join(t.TempDir(), "copy.db")
+	if err := os.WriteFile(copyPath, snapshot.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	copied, err := sql.Open(sqlite.DriverName, copyPath)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer copied.Close()
+	var body string
+	if err := copied.QueryRow("SELECT body FROM notes").Scan(&body); err != nil || body != "kept" {
+		t.Errorf("Expected the snapshot to hold the row, got %q (%v)", body, err)
+	}
+
+	// The snapshot's temporary file is not mistaken for a cenv
+	if ids, _ := manager.List(); len(ids) != 1 {
+		t.Errorf("Expected one cenv, got %v", ids)
+	}
+
+	if err := manager.Delete(cenvID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(manager.StorageDir(), "*"))
+	if len(matches) != 0 {
+		t.Errorf("Expected database files removed, found %v", matches)
+	}
+	if err := manager.Snapshot(cenvID, io.Discard); err == nil {
+		t.Error("Expected Snapshot of a deleted cenv to fail")
+	}
+}
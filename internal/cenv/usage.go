@@ -0,0 +1,175 @@
+package cenv
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// SizeSampleInterval is the least time between recorded size samples of a cenv
+const SizeSampleInterval = time.Hour
+
+// MaxSizeSamples is how many size samples the registry keeps per cenv, a
+// week of hourly samples
+const MaxSizeSamples = 168
+
+// SizeSample is the size of a cenv's database, WAL included, at a time
+type SizeSample struct {
+	Time  int64 `json:"time"` // Unix timestamp
+	Bytes int64 `json:"bytes"`
+}
+
+// SizeAlertPolicy alerts the operator when a cenv crosses MaxBytes or grows
+// by more than MaxGrowthPerDay bytes within a day. Alerts are posted to
+// WebhookURL and mailed to EmailTo through the SMTP relay at SMTPAddr.
+type SizeAlertPolicy struct {
+	MaxBytes        int64    `json:"max_bytes,omitempty"`          // 0 disables
+	MaxGrowthPerDay int64    `json:"max_growth_per_day,omitempty"` // 0 disables
+	WebhookURL      string   `json:"webhook_url,omitempty"`
+	SMTPAddr        string   `json:"smtp_addr,omitempty"` // host:port
+	EmailFrom       string   `json:"email_from,omitempty"`
+	EmailTo         []string `json:"email_to,omitempty"`
+}
+
+// validate checks the limits and delivery settings
+func (p *SizeAlertPolicy) validate() error {
+	if p.MaxBytes < 0 || p.MaxGrowthPerDay < 0 {
+		return fmt.Errorf("size alert limits cannot be negative")
+	}
+	if p.WebhookURL != "" {
+		u, err := url.Parse(p.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("size alert webhook_url must be an absolute http or https URL")
+		}
+	}
+	if len(p.EmailTo) > 0 && (p.SMTPAddr == "" || p.EmailFrom == "") {
+		return fmt.Errorf("size alert email needs smtp_addr and email_from")
+	}
+	return nil
+}
+
+// SizeAlerts returns the registry's size alert policy, or nil
+func (m *Manager) SizeAlerts() *SizeAlertPolicy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.registry == nil {
+		return nil
+	}
+	return m.registry.SizeAlerts
+}
+
+// DatabaseSize returns the size of a cenv's database and WAL files
+func (m *Manager) DatabaseSize(cenvID string) (int64, error) {
+	if !m.isLocal() {
+		return 0, fmt.Errorf("database size is only known for local files")
+	}
+	dbPath := m.GetDatabasePath(cenvID)
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat database: %w", err)
+	}
+	size := info.Size()
+	if wal, err := os.Stat(dbPath + "-wal"); err == nil {
+		size += wal.Size()
+	}
+	return size, nil
+}
+
+// SizeHistory returns the recorded size samples of a cenv, oldest first
+func (m *Manager) SizeHistory(cenvID string) []SizeSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.registry == nil {
+		return nil
+	}
+	return append([]SizeSample(nil), m.registry.Sizes[cenvID]...)
+}
+
+// RecordSizes samples the database size of every cenv last sampled at least
+// SizeSampleInterval before now, keeping the newest MaxSizeSamples per cenv,
+// and saves them in the registry. History of deleted cenvs is dropped;
+// archived cenvs keep theirs. Returns the cenvs sampled.
+func (m *Manager) RecordSizes(now time.Time) ([]string, error) {
+	cenvIDs, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	due := map[string]int64{}
+	for _, cenvID := range cenvIDs {
+		history := m.SizeHistory(cenvID)
+		if len(history) > 0 && now.Unix()-history[len(history)-1].Time < int64(SizeSampleInterval/time.Second) {
+			continue
+		}
+		size, err := m.DatabaseSize(cenvID)
+		if err != nil {
+			continue // Archived or deleted since listing
+		}
+		due[cenvID] = size
+	}
+
+	live := map[string]bool{}
+	for _, cenvID := range cenvIDs {
+		live[cenvID] = true
+	}
+
+	// Only rewrite the registry when there is something to change
+	stale := false
+	m.mu.RLock()
+	if m.registry != nil {
+		for cenvID := range m.registry.Sizes {
+			if _, archived := m.registry.Archived[cenvID]; !live[cenvID] && !archived {
+				stale = true
+			}
+		}
+	}
+	m.mu.RUnlock()
+	if len(due) == 0 && !stale {
+		return nil, nil
+	}
+
+	var sampled []string
+	err = m.updateRegistry(func(registry *Registry) {
+		for cenvID := range registry.Sizes {
+			if _, archived := registry.Archived[cenvID]; !live[cenvID] && !archived {
+				delete(registry.Sizes, cenvID)
+			}
+		}
+		for _, cenvID := range cenvIDs {
+			size, ok := due[cenvID]
+			if !ok {
+				continue
+			}
+			history := append(append([]SizeSample(nil), registry.Sizes[cenvID]...), SizeSample{Time: now.Unix(), Bytes: size})
+			if len(history) > MaxSizeSamples {
+				history = history[len(history)-MaxSizeSamples:]
+			}
+			registry.Sizes[cenvID] = history
+			sampled = append(sampled, cenvID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sampled, nil
+}
+
+// GrowthPerDay returns how many bytes a cenv grew between the newest sample
+// at least a day older than samples[end] and samples[end]. ok is false when
+// the history does not reach back a day.
+func GrowthPerDay(samples []SizeSample, end int) (growth int64, ok bool) {
+	if end < 0 || end >= len(samples) {
+		return 0, false
+	}
+	dayAgo := samples[end].Time - int64(24*time.Hour/time.Second)
+	for i := end - 1; i >= 0; i-- {
+		if samples[i].Time <= dayAgo {
+			return samples[end].Bytes - samples[i].Bytes, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package cenv
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordSizes(t *testing.T) {
+	storageDir := t.TempDir()
+	manager := NewManager(storageDir)
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	goneID := "223e4567-e89b-12d3-a456-426614174000"
+
+	for _, id := range []string{cenvID, goneID} {
+		if err := manager.Create(id); err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+	}
+
+	start := time.Now()
+	sampled, err := manager.RecordSizes(start)
+	if err != nil || len(sampled) != 2 {
+		t.Fatalf("Expected both cenvs sampled, got %v: %v", sampled, err)
+	}
+	size, _ := manager.DatabaseSize(cenvID)
+	if history := manager.SizeHistory(cenvID); len(history) != 1 || history[0].Bytes != size {
+		t.Errorf("Expected one sample of %d bytes, got %v", size, history)
+	}
+
+	// Samples are at least SizeSampleInterval apart
+	if sampled, _ := manager.RecordSizes(start.Add(time.Minute)); len(sampled) != 0 {
+		t.Errorf("Expected no samples within the interval, got %v", sampled)
+	}
+
+	// History survives a restart and is dropped for deleted cenvs
+	os.Remove(manager.GetDatabasePath(goneID))
+	restarted := NewManager(storageDir)
+	if err := restarted.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	if sampled, _ := restarted.RecordSizes(start.Add(time.Hour)); len(sampled) != 1 {
+		t.Errorf("Expected one cenv sampled, got %v", sampled)
+	}
+	if history := restarted.SizeHistory(cenvID); len(history) != 2 {
+		t.Errorf("Expected two samples, got %v", history)
+	}
+	if history := restarted.SizeHistory(goneID); len(history) != 0 {
+		t.Errorf("Expected deleted cenv history to be dropped, got %v", history)
+	}
+
+	for i := 2; i <= MaxSizeSamples+5; i++ {
+		restarted.RecordSizes(start.Add(time.Duration(i) * time.Hour))
+	}
+	if history := restarted.SizeHistory(cenvID); len(history) != MaxSizeSamples {
+		t.Errorf("Expected %d samples kept, got %d", MaxSizeSamples, len(history))
+	}
+}
+
+func TestGrowthPerDay(t *testing.T) {
+	day := int64(24 * 60 * 60)
+	samples := []SizeSample{
+		{Time: 0, Bytes: 100},
+		{Time: day / 2, Bytes: 150},
+		{Time: day, Bytes: 400},
+		{Time: day + day/2, Bytes: 1000},
+	}
+
+	tests := []struct {
+		end    int
+		growth int64
+		ok     bool
+	}{
+		{0, 0, false},
+		{1, 0, false},
+		{2, 300, true},
+		{3, 850, true},
+		{4, 0, false},
+	}
+	for _, tt := range tests {
+		growth, ok := GrowthPerDay(samples, tt.end)
+		if growth != tt.growth || ok != tt.ok {
+			t.Errorf("GrowthPerDay(%d) = %d, %v; expected %d, %v", tt.end, growth, ok, tt.growth, tt.ok)
+		}
+	}
+}
+
+func TestLoadRegistry_InvalidSizeAlerts(t *testing.T) {
+	registries := map[string]string{
+		"NegativeLimit": `{"size_alerts": {"max_bytes": -1}}`,
+		"BadWebhook":    `{"size_alerts": {"webhook_url": "ftp://example.com"}}`,
+		"EmailNoSMTP":   `{"size_alerts": {"email_to": ["ops@example.com"]}}`,
+	}
+
+	for name, registry := range registries {
+		t.Run(name, func(t *testing.T) {
+			storageDir := t.TempDir()
+			writeRegistry(t, storageDir, registry)
+			if err := NewManager(storageDir).LoadRegistry(); err == nil {
+				t.Error("Expected invalid registry to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package clock
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSet(t *testing.T) {
+	fake := NewFake(time.Unix(1000, 0))
+	restore := Set(fake)
+
+	if got := Now().Unix(); got != 1000 {
+		t.Errorf("Expected fake time 1000, got %d", got)
+	}
+	fake.Advance(time.Minute)
+	if got := Now().Unix(); got != 1060 {
+		t.Errorf("Expected advanced time 1060, got %d", got)
+	}
+
+	restore()
+	if time.Since(Now()) > time.Minute {
+		t.Error("Expected restore to reinstall the system clock")
+	}
+}
+
+func TestSequence(t *testing.T) {
+	read := func(seed string) []byte {
+		restore := SetIDs(NewSequence(seed))
+		defer restore()
+
+		b := make([]byte, 40)
+		if _, err := ReadID(b); err != nil {
+			t.Fatalf("ReadID failed: %v", err)
+		}
+		next := make([]byte, 40)
+		ReadID(next)
+		if bytes.Equal(b, next) {
+			t.Error("Expected successive reads to differ")
+		}
+		return b
+	}
+
+	if !bytes.Equal(read("a"), read("a")) {
+		t.Error("Expected the same seed to produce the same ids")
+	}
+	if bytes.Equal(read("a"), read("b")) {
+		t.Error("Expected different seeds to produce different ids")
+	}
+}
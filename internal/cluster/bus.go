@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bus carries events between WCE instances. Adapters for other brokers (for
+// example NATS subjects) implement the same two methods.
+type Bus interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+
+	// Subscribe calls handler for every message on topic until cancel is called.
+	// Handlers run on the bus's delivery goroutine and should not block.
+	Subscribe(topic string, handler func(data []byte)) (cancel func(), err error)
+}
+
+// LocalBus delivers events within a single process. It is the default for
+// single-instance deployments and a stand-in for a broker in tests.
+type LocalBus struct {
+	mu       sync.RWMutex
+	handlers map[string]map[int]func([]byte)
+	next     int
+}
+
+// NewLocalBus creates an in-process bus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{handlers: map[string]map[int]func([]byte){}}
+}
+
+// Publish delivers data to the current subscribers of topic
+func (b *LocalBus) Publish(ctx context.Context, topic string, data []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers[topic] {
+		handler(data)
+	}
+	return nil
+}
+
+// Subscribe registers handler for topic
+func (b *LocalBus) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.handlers[topic] == nil {
+		b.handlers[topic] = map[int]func([]byte){}
+	}
+	id := b.next
+	b.next++
+	b.handlers[topic][id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers[topic], id)
+	}, nil
+}
+
+// RedisBus publishes and subscribes with Redis pub/sub, speaking RESP directly.
+// Each subscription holds its own connection and is not re-established if the
+// connection drops.
+type RedisBus struct {
+	Address  string // host:port
+	Password string // Sent with AUTH when set
+	Timeout  time.Duration
+}
+
+// NewRedisBus creates a bus for the Redis server at address
+func NewRedisBus(address string) *RedisBus {
+	return &RedisBus{
+		Address: address,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// dial connects and authenticates
+func (b *RedisBus) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	dialer := net.Dialer{Timeout: b.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", b.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+
+	if b.Password != "" {
+		if _, err := redisCommand(conn, reader, "AUTH", b.Password); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	return conn, reader, nil
+}
+
+// Publish sends data to topic
+func (b *RedisBus) Publish(ctx context.Context, topic string, data []byte) error {
+	conn, reader, err := b.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if b.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(b.Timeout))
+	}
+
+	_, err = redisCommand(conn, reader, "PUBLISH", topic, string(data))
+	return err
+}
+
+// Subscribe listens for messages on topic in a background goroutine
+func (b *RedisBus) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	conn, reader, err := b.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(b.Timeout))
+	if _, err := redisCommand(conn, reader, "SUBSCRIBE", topic); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	go func() {
+		for {
+			reply, err := readRESP(reader)
+			if err != nil {
+				return // Connection closed by cancel or by the server
+			}
+			// Messages arrive as ["message", topic, data]
+			parts, ok := reply.([]interface{})
+			if !ok || len(parts) != 3 || parts[0] != "message" {
+				continue
+			}
+			if data, ok := parts[2].(string); ok {
+				handler([]byte(data))
+			}
+		}
+	}()
+
+	return func() { conn.Close() }, nil
+}
+
+// redisCommand sends a command as an array of bulk strings and reads one reply
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (interface{}, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to send redis command: %w", err)
+	}
+	return readRESP(r)
+}
+
+// readRESP reads one RESP value: simple strings, errors, integers, bulk
+// strings (nil when absent) and arrays of those
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid redis reply: %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk length: %q", body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis array length: %q", body)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unsupported redis reply: %q", line)
+	}
+}
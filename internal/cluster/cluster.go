@@ -0,0 +1,115 @@
+// Package cluster coordinates several WCE processes serving the same storage
+// directory: per-cenv write leases keep a single writer per cenv, and an event
+// bus tells the other instances what changed.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is how long a write lease lasts without renewal
+const DefaultLeaseTTL = 15 * time.Second
+
+// WritesTopic is the bus topic carrying write events
+const WritesTopic = "wce.writes"
+
+// Event reports a completed write to a cenv
+type Event struct {
+	CenvID   string `json:"cenv_id"`
+	Instance string `json:"instance"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Time     int64  `json:"time"` // Unix timestamp
+}
+
+// Coordinator holds this instance's identity, leases and bus
+type Coordinator struct {
+	Instance Instance
+	Leases   Leases
+	Bus      Bus
+	TTL      time.Duration
+
+	mu   sync.Mutex
+	held map[string]time.Time // cenvID -> expiry of a lease this instance holds
+}
+
+// NewCoordinator creates a coordinator. A nil bus uses a LocalBus.
+func NewCoordinator(instance Instance, leases Leases, bus Bus) *Coordinator {
+	if bus == nil {
+		bus = NewLocalBus()
+	}
+	return &Coordinator{
+		Instance: instance,
+		Leases:   leases,
+		Bus:      bus,
+		TTL:      DefaultLeaseTTL,
+		held:     map[string]time.Time{},
+	}
+}
+
+// Claim makes sure this instance holds the write lease on cenvID. Leases are
+// renewed once half the TTL has passed, so a steady stream of writes touches
+// the lease store at most twice per TTL. When another instance holds the lease
+// Claim returns its lease and ErrLeaseHeld.
+func (c *Coordinator) Claim(cenvID string) (*Lease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if expiry, ok := c.held[cenvID]; ok && time.Until(expiry) > c.TTL/2 {
+		return &Lease{CenvID: cenvID, Holder: c.Instance, ExpiresAt: expiry}, nil
+	}
+
+	lease, err := c.Leases.Acquire(cenvID, c.Instance, c.TTL)
+	if err != nil {
+		delete(c.held, cenvID)
+		return lease, err
+	}
+
+	c.held[cenvID] = lease.ExpiresAt
+	return lease, nil
+}
+
+// Publish reports a completed write on the bus
+func (c *Coordinator) Publish(ctx context.Context, event Event) error {
+	event.Instance = c.Instance.ID
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	return c.Bus.Publish(ctx, WritesTopic, data)
+}
+
+// Subscribe calls handler for write events from other instances
+func (c *Coordinator) Subscribe(handler func(Event)) (func(), error) {
+	return c.Bus.Subscribe(WritesTopic, func(data []byte) {
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Printf("Ignoring malformed cluster event: %v", err)
+			return
+		}
+		if event.Instance != c.Instance.ID {
+			handler(event)
+		}
+	})
+}
+
+// Close releases every lease this instance holds so another instance can
+// take over without waiting for expiry
+func (c *Coordinator) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var lastErr error
+	for cenvID := range c.held {
+		if err := c.Leases.Release(cenvID, c.Instance.ID); err != nil {
+			lastErr = err
+		}
+		delete(c.held, cenvID)
+	}
+	return lastErr
+}
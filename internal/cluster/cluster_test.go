@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileLeases(t *testing.T) {
+	leases := NewFileLeases(t.TempDir())
+	a := Instance{ID: "a", Address: "http://a:5309"}
+	b := Instance{ID: "b"}
+
+	lease, err := leases.Acquire("cenv-1", a, time.Minute)
+	if err != nil || lease.Holder.ID != "a" {
+		t.Fatalf("Expected a to acquire, got %+v (%v)", lease, err)
+	}
+
+	// Renewal by the holder succeeds
+	if _, err := leases.Acquire("cenv-1", a, time.Minute); err != nil {
+		t.Fatalf("Expected renewal, got %v", err)
+	}
+
+	held, err := leases.Acquire("cenv-1", b, time.Minute)
+	if !errors.Is(err, ErrLeaseHeld) || held.Holder.Address != "http://a:5309" {
+		t.Fatalf("Expected lease held by a, got %+v (%v)", held, err)
+	}
+
+	// Leases are per cenv
+	if _, err := leases.Acquire("cenv-2", b, time.Minute); err != nil {
+		t.Errorf("Expected b to acquire another cenv, got %v", err)
+	}
+
+	// Release by a non-holder is ignored; by the holder it frees the lease
+	leases.Release("cenv-1", "b")
+	if _, err := leases.Acquire("cenv-1", b, time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Error("Expected non-holder release to be ignored")
+	}
+	if err := leases.Release("cenv-1", "a"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := leases.Acquire("cenv-1", b, time.Minute); err != nil {
+		t.Errorf("Expected b to acquire after release, got %v", err)
+	}
+}
+
+func TestFileLeases_Expiry(t *testing.T) {
+	leases := NewFileLeases(t.TempDir())
+
+	if _, err := leases.Acquire("cenv-1", Instance{ID: "a"}, time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := leases.Acquire("cenv-1", Instance{ID: "b"}, time.Minute); err != nil {
+		t.Errorf("Expected takeover of an expired lease, got %v", err)
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	dir := t.TempDir()
+	bus := NewLocalBus()
+	a := NewCoordinator(Instance{ID: "a"}, NewFileLeases(dir), bus)
+	b := NewCoordinator(Instance{ID: "b"}, NewFileLeases(dir), bus)
+
+	var received []Event
+	cancel, err := b.Subscribe(func(e Event) { received = append(received, e) })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer cancel()
+
+	if _, err := a.Claim("cenv-1"); err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if lease, err := b.Claim("cenv-1"); !errors.Is(err, ErrLeaseHeld) || lease.Holder.ID != "a" {
+		t.Fatalf("Expected b to be refused, got %+v (%v)", lease, err)
+	}
+
+	a.Publish(context.Background(), Event{CenvID: "cenv-1", Method: "POST", Path: "/documents"})
+	b.Publish(context.Background(), Event{CenvID: "cenv-2"}) // Own events are not delivered
+	if len(received) != 1 || received[0].Instance != "a" || received[0].CenvID != "cenv-1" {
+		t.Errorf("Unexpected events: %+v", received)
+	}
+
+	// Closing hands the lease over immediately
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := b.Claim("cenv-1"); err != nil {
+		t.Errorf("Expected b to claim after a closed, got %v", err)
+	}
+}
+
+// fakeRedis accepts connections and relays PUBLISH to SUBSCRIBE connections
+func fakeRedis(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	subscribers := map[string][]net.Conn{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					value, err := readRESP(reader)
+					if err != nil {
+						conn.Close()
+						return
+					}
+					args, _ := value.([]interface{})
+					switch args[0] {
+					case "SUBSCRIBE":
+						topic := args[1].(string)
+						mu.Lock()
+						subscribers[topic] = append(subscribers[topic], conn)
+						mu.Unlock()
+						conn.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$" + strconv.Itoa(len(topic)) + "\r\n" + topic + "\r\n:1\r\n"))
+					case "PUBLISH":
+						topic, data := args[1].(string), args[2].(string)
+						mu.Lock()
+						for _, sub := range subscribers[topic] {
+							sub.Write([]byte("*3\r\n$7\r\nmessage\r\n$" + strconv.Itoa(len(topic)) + "\r\n" + topic + "\r\n$" + strconv.Itoa(len(data)) + "\r\n" + data + "\r\n"))
+						}
+						count := len(subscribers[topic])
+						mu.Unlock()
+						conn.Write([]byte(":" + strconv.Itoa(count) + "\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestRedisBus(t *testing.T) {
+	bus := NewRedisBus(fakeRedis(t))
+
+	messages := make(chan string, 1)
+	cancel, err := bus.Subscribe("wce.writes", func(data []byte) { messages <- string(data) })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer cancel()
+
+	if err := bus.Publish(context.Background(), "wce.writes", []byte("hello\r\nworld")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	select {
+	case msg := <-messages:
+		if msg != "hello\r\nworld" {
+			t.Errorf("Unexpected message %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+}
@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLeaseHeld is returned when another live instance holds a cenv's write lease
+var ErrLeaseHeld = errors.New("write lease held by another instance")
+
+// Instance identifies a WCE process
+type Instance struct {
+	ID      string `json:"id"`
+	Address string `json:"address,omitempty"` // Advertised base URL, used as a routing hint
+}
+
+// Lease is a time-limited claim by one instance on writing to a cenv
+type Lease struct {
+	CenvID    string    `json:"cenv_id"`
+	Holder    Instance  `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Leases grants per-cenv write leases. Implementations must be safe to use
+// from several processes at once.
+type Leases interface {
+	// Acquire claims or renews the lease on cenvID for holder. When another
+	// instance holds an unexpired lease it returns that lease and ErrLeaseHeld.
+	Acquire(cenvID string, holder Instance, ttl time.Duration) (*Lease, error)
+
+	// Release gives up the lease if holderID still holds it
+	Release(cenvID, holderID string) error
+}
+
+// FileLeases stores leases as {cenvID}.lease files next to the cenv databases.
+// Updates are serialized with an advisory lock on the lease file, so every
+// instance must see the same filesystem with working locks.
+type FileLeases struct {
+	Dir string
+}
+
+// NewFileLeases creates a lease store in dir
+func NewFileLeases(dir string) *FileLeases {
+	return &FileLeases{Dir: dir}
+}
+
+// Acquire claims or renews the lease on cenvID
+func (f *FileLeases) Acquire(cenvID string, holder Instance, ttl time.Duration) (*Lease, error) {
+	var result *Lease
+	err := f.update(cenvID, func(current *Lease) (*Lease, error) {
+		if current != nil && current.Holder.ID != holder.ID && time.Now().Before(current.ExpiresAt) {
+			result = current
+			return nil, ErrLeaseHeld
+		}
+		result = &Lease{CenvID: cenvID, Holder: holder, ExpiresAt: time.Now().Add(ttl)}
+		return result, nil
+	})
+	return result, err
+}
+
+// Release clears the lease on cenvID if holderID holds it
+func (f *FileLeases) Release(cenvID, holderID string) error {
+	return f.update(cenvID, func(current *Lease) (*Lease, error) {
+		if current == nil || current.Holder.ID != holderID {
+			return current, nil
+		}
+		return nil, nil
+	})
+}
+
+// update reads the lease file under an exclusive lock and writes back the
+// lease returned by fn. A nil lease empties the file; an error leaves it unchanged.
+func (f *FileLeases) update(cenvID string, fn func(*Lease) (*Lease, error)) error {
+	file, err := os.OpenFile(filepath.Join(f.Dir, cenvID+".lease"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open lease: %w", err)
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return fmt.Errorf("failed to lock lease: %w", err)
+	}
+	defer unlockFile(file)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read lease: %w", err)
+	}
+
+	var current *Lease
+	if len(data) > 0 {
+		current = &Lease{}
+		if err := json.Unmarshal(data, current); err != nil {
+			// A torn write from a crashed process is treated as no lease
+			current = nil
+		}
+	}
+
+	next, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	if next == nil {
+		return nil
+	}
+
+	data, err = json.Marshal(next)
+	if err != nil {
+		return fmt.Errorf("failed to encode lease: %w", err)
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+
+	return nil
+}
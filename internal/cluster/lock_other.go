@@ -0,0 +1,17 @@
+//go:build !unix
+
+package cluster
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile is unsupported here; use a Leases implementation backed by a registry
+func lockFile(file *os.File) error {
+	return fmt.Errorf("file leases are not supported on this platform")
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+// Package config provides access to per-cenv configuration stored in _wce_config.
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Queryer is satisfied by *sql.DB and *sql.Tx, so values can be read inside
+// a transaction
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Get retrieves a configuration value.
+// Returns found=false if the key is not set.
+func Get(db Queryer, key string) (string, bool, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM _wce_config WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get config %s: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// GetString retrieves a configuration value, falling back to a default if unset
+func GetString(db Queryer, key, defaultValue string) string {
+	value, found, err := Get(db, key)
+	if err != nil || !found {
+		return defaultValue
+	}
+	return value
+}
+
+// GetBool retrieves a boolean configuration value, falling back to a default if unset or invalid
+func GetBool(db Queryer, key string, defaultValue bool) bool {
+	value, found, err := Get(db, key)
+	if err != nil || !found {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return b
+}
+
+// GetInt retrieves an integer configuration value, falling back to a default if unset or invalid
+func GetInt(db Queryer, key string, defaultValue int64) int64 {
+	value, found, err := Get(db, key)
+	if err != nil || !found {
+		return defaultValue
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+// Set creates or updates a configuration value
+func Set(db *sql.DB, key, value, userID string) error {
+	if key == "" {
+		return fmt.Errorf("config key cannot be empty")
+	}
+
+	var updatedBy interface{}
+	if userID != "" {
+		updatedBy = userID
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_config (key, value, updated_at, updated_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			value = excluded.value,
+			updated_at = excluded.updated_at,
+			updated_by = excluded.updated_by
+	`, key, value, time.Now().Unix(), updatedBy)
+
+	if err != nil {
+		return fmt.Errorf("failed to set config %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Entry is a single configuration value
+type Entry struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	UpdatedAt int64  `json:"updated_at"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// List returns all configuration values ordered by key
+func List(db *sql.DB) ([]Entry, error) {
+	rows, err := db.Query(`SELECT key, value, updated_at, updated_by FROM _wce_config ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var updatedBy sql.NullString
+		if err := rows.Scan(&entry.Key, &entry.Value, &entry.UpdatedAt, &updatedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan config: %w", err)
+		}
+		entry.UpdatedBy = updatedBy.String
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating config: %w", err)
+	}
+
+	return entries, nil
+}
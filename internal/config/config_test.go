@@ -0,0 +1,111 @@
+package config
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// setupTestDB creates an in-memory database with the config table
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+	CREATE TABLE _wce_config (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		updated_by TEXT
+	);
+	INSERT INTO _wce_config (key, value, updated_at) VALUES
+		('max_users', '10', 0),
+		('allow_registration', 'false', 0),
+		('broken_number', 'ten', 0);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	return db
+}
+
+func TestGet(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	value, found, err := Get(db, "max_users")
+	if err != nil || !found || value != "10" {
+		t.Errorf("Expected '10', got '%s' (found=%v, err=%v)", value, found, err)
+	}
+
+	_, found, err = Get(db, "missing")
+	if err != nil || found {
+		t.Errorf("Expected missing key to be not found (found=%v, err=%v)", found, err)
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if v := GetInt(db, "max_users", 5); v != 10 {
+		t.Errorf("Expected 10, got %d", v)
+	}
+	if v := GetInt(db, "broken_number", 5); v != 5 {
+		t.Errorf("Expected default 5 for invalid value, got %d", v)
+	}
+	if v := GetBool(db, "allow_registration", true); v {
+		t.Error("Expected false")
+	}
+	if v := GetBool(db, "missing", true); !v {
+		t.Error("Expected default true")
+	}
+	if v := GetString(db, "missing", "fallback"); v != "fallback" {
+		t.Errorf("Expected 'fallback', got '%s'", v)
+	}
+}
+
+func TestSet(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := Set(db, "max_users", "20", ""); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	if v := GetInt(db, "max_users", 0); v != 20 {
+		t.Errorf("Expected 20, got %d", v)
+	}
+
+	if err := Set(db, "new_key", "value", ""); err != nil {
+		t.Fatalf("Failed to set new key: %v", err)
+	}
+	if v := GetString(db, "new_key", ""); v != "value" {
+		t.Errorf("Expected 'value', got '%s'", v)
+	}
+
+	if err := Set(db, "", "value", ""); err == nil {
+		t.Error("Expected error for empty key")
+	}
+}
+
+func TestList(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	entries, err := List(db)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Key != "allow_registration" {
+		t.Errorf("Expected entries ordered by key, got %s first", entries[0].Key)
+	}
+}
@@ -41,12 +41,51 @@ CREATE TABLE IF NOT EXISTS _wce_sessions (
     last_used INTEGER,                  -- Unix timestamp
     ip_address TEXT,
     user_agent TEXT,
+    client_fingerprint TEXT,            -- SHA256 of IP prefix + user agent, for bind_sessions_to_client
+    refresh_token_hash TEXT,            -- SHA256 of the refresh token, replaced on every refresh
+    refresh_expires_at INTEGER,         -- Unix timestamp; the session lives until then when set
     FOREIGN KEY (user_id) REFERENCES _wce_users(user_id) ON DELETE CASCADE
 );
 
 CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON _wce_sessions(user_id);
 CREATE INDEX IF NOT EXISTS idx_sessions_token_hash ON _wce_sessions(token_hash);
 CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON _wce_sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_refresh_token_hash ON _wce_sessions(refresh_token_hash);
+
+-- Pending OAuth device authorizations (RFC 8628) for CLI login
+CREATE TABLE IF NOT EXISTS _wce_device_codes (
+    device_code_hash TEXT PRIMARY KEY,  -- SHA256 of the code the device polls with
+    user_code TEXT NOT NULL UNIQUE,     -- Short code the user confirms, e.g. 'BCDF-GHJK'
+    scope TEXT NOT NULL DEFAULT '',     -- Requested token scope; empty is unrestricted
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'approved', 'denied')),
+    approved_by TEXT,
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    expires_at INTEGER NOT NULL,        -- Unix timestamp
+    last_poll INTEGER,                  -- Unix timestamp, for slow_down
+    FOREIGN KEY (approved_by) REFERENCES _wce_users(user_id) ON DELETE CASCADE
+);
+
+-- One-time password reset tokens; a user has at most one pending
+CREATE TABLE IF NOT EXISTS _wce_password_resets (
+    token_hash TEXT PRIMARY KEY,        -- SHA256 of the reset token
+    user_id TEXT NOT NULL UNIQUE,
+    created_by TEXT NOT NULL,           -- The admin who issued it, or the user for an emailed reset
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    expires_at INTEGER NOT NULL,        -- Unix timestamp
+    FOREIGN KEY (user_id) REFERENCES _wce_users(user_id) ON DELETE CASCADE
+);
+
+-- Email address verification, one row per user
+CREATE TABLE IF NOT EXISTS _wce_email_verifications (
+    user_id TEXT PRIMARY KEY,
+    email TEXT NOT NULL,                -- The address being or been verified
+    token_hash TEXT UNIQUE,             -- SHA256 of the pending token, NULL once verified
+    required INTEGER NOT NULL DEFAULT 0, -- 1 = login refused until verified
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    expires_at INTEGER NOT NULL,        -- Unix timestamp
+    verified_at INTEGER,                -- Unix timestamp
+    FOREIGN KEY (user_id) REFERENCES _wce_users(user_id) ON DELETE CASCADE
+);
 
 -- ----------------------------------------------------------------------------
 -- Table-Level Permissions
@@ -121,6 +160,75 @@ CREATE INDEX IF NOT EXISTS idx_audit_timestamp ON _wce_audit_log(timestamp);
 CREATE INDEX IF NOT EXISTS idx_audit_user ON _wce_audit_log(user_id);
 CREATE INDEX IF NOT EXISTS idx_audit_action ON _wce_audit_log(action);
 
+-- Compliance log: redacted summaries of admin and permission-changing
+-- requests, hash chained and append-only (compliance_capture)
+CREATE TABLE IF NOT EXISTS _wce_compliance_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp INTEGER NOT NULL,         -- Unix timestamp
+    request_id TEXT NOT NULL DEFAULT '',
+    user_id TEXT NOT NULL DEFAULT '',   -- Empty for unauthenticated requests
+    username TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    route TEXT NOT NULL,                -- Route pattern matched
+    path TEXT NOT NULL,
+    query TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL,
+    request TEXT NOT NULL DEFAULT '',   -- JSON: redacted headers and body
+    response TEXT NOT NULL DEFAULT '',  -- JSON: redacted body summary
+    ip_address TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL UNIQUE,     -- hash of the record before, zeros for the first
+    hash TEXT NOT NULL UNIQUE           -- hex SHA-256 of prev_hash and the fields above
+);
+
+CREATE TRIGGER IF NOT EXISTS _wce_compliance_log_no_update BEFORE UPDATE ON _wce_compliance_log BEGIN
+    SELECT RAISE(ABORT, 'compliance log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS _wce_compliance_log_no_delete BEFORE DELETE ON _wce_compliance_log BEGIN
+    SELECT RAISE(ABORT, 'compliance log is append-only');
+END;
+
+-- Reports of users erased on request, kept as the record of each erasure
+CREATE TABLE IF NOT EXISTS _wce_erasures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,              -- Pseudonymous id of the erased user
+    erased_by TEXT NOT NULL,            -- user_id of the admin who erased them
+    erased_at INTEGER NOT NULL,         -- Unix timestamp
+    report TEXT NOT NULL                -- JSON: rows deleted, anonymized and retained, flagged documents
+);
+
+-- Nonces of signed admin requests, kept until their timestamp leaves the
+-- acceptance window so a captured request cannot be replayed
+CREATE TABLE IF NOT EXISTS _wce_request_nonces (
+    nonce TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at INTEGER NOT NULL         -- Unix timestamp
+);
+
+-- Login attempts, for brute-force and new-country detection
+CREATE TABLE IF NOT EXISTS _wce_login_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,             -- As submitted; may not exist
+    user_id TEXT,                       -- NULL for unknown usernames
+    ip_address TEXT,                    -- Without port
+    country TEXT,                       -- ISO 3166 code from the GeoIP plug-in
+    succeeded INTEGER NOT NULL,
+    timestamp INTEGER NOT NULL          -- Unix timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON _wce_login_attempts(username, timestamp);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON _wce_login_attempts(ip_address, timestamp);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user ON _wce_login_attempts(user_id);
+
+-- Alerts raised for unusual auth activity, also posted to alert_webhook_url
+CREATE TABLE IF NOT EXISTS _wce_alerts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,                 -- 'failed_logins', 'new_country', 'permission_escalation'
+    message TEXT NOT NULL,
+    details TEXT,                       -- JSON with additional context
+    created_at INTEGER NOT NULL         -- Unix timestamp
+);
+
 -- ----------------------------------------------------------------------------
 -- Document Store (LiteStore-inspired with user tracking and versioning)
 -- Each cenv has its own isolated document store
@@ -137,6 +245,13 @@ CREATE TABLE IF NOT EXISTS _wce_documents (
     created_by TEXT NOT NULL,           -- user_id
     modified_by TEXT NOT NULL,          -- user_id
     version INTEGER DEFAULT 1,          -- Incremental version number
+    metadata TEXT NOT NULL DEFAULT '{}', -- Arbitrary JSON object, filterable with json_extract
+    schema_id TEXT,                     -- Document holding a JSON Schema the content must match
+    expires_at INTEGER,                 -- Unix timestamp after which the sweeper removes it; NULL = never
+    compression TEXT,                   -- 'gzip' when content holds compressed bytes; NULL = stored verbatim
+    pinned INTEGER NOT NULL DEFAULT 0,  -- 1 = listed first in position order (BOOLEAN)
+    sort_order INTEGER,                 -- Position within its prefix; NULL = after the ordered documents
+    content_hash TEXT,                  -- Hex SHA-256 of the content, set on write; NULL = not yet computed
     FOREIGN KEY (created_by) REFERENCES _wce_users(user_id),
     FOREIGN KEY (modified_by) REFERENCES _wce_users(user_id)
 );
@@ -144,6 +259,8 @@ CREATE TABLE IF NOT EXISTS _wce_documents (
 CREATE INDEX IF NOT EXISTS idx_documents_content_type ON _wce_documents(content_type);
 CREATE INDEX IF NOT EXISTS idx_documents_created_by ON _wce_documents(created_by);
 CREATE INDEX IF NOT EXISTS idx_documents_modified_at ON _wce_documents(modified_at);
+CREATE INDEX IF NOT EXISTS idx_documents_schema_id ON _wce_documents(schema_id);
+CREATE INDEX IF NOT EXISTS idx_documents_expires_at ON _wce_documents(expires_at);
 
 -- Document tags for categorization
 CREATE TABLE IF NOT EXISTS _wce_document_tags (
@@ -157,6 +274,125 @@ CREATE TABLE IF NOT EXISTS _wce_document_tags (
 CREATE INDEX IF NOT EXISTS idx_document_tags_doc ON _wce_document_tags(document_id);
 CREATE INDEX IF NOT EXISTS idx_document_tags_tag ON _wce_document_tags(tag);
 
+-- Wiki-style [[id]] links between documents, rebuilt from content on every
+-- write. Targets may not exist yet.
+CREATE TABLE IF NOT EXISTS _wce_document_links (
+    source_id TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    PRIMARY KEY (source_id, target_id),
+    FOREIGN KEY (source_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_links_target ON _wce_document_links(target_id);
+
+-- Documents that template documents {% include %} or {% extends %}, rebuilt
+-- from content on every write. Deleting a referenced document is refused
+-- unless forced.
+CREATE TABLE IF NOT EXISTS _wce_document_references (
+    source_id TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    kind TEXT NOT NULL,                 -- 'include' or 'extends'
+    PRIMARY KEY (source_id, target_id, kind),
+    FOREIGN KEY (source_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_references_target ON _wce_document_references(target_id);
+
+-- Prior revisions of documents, archived on every update
+CREATE TABLE IF NOT EXISTS _wce_document_versions (
+    document_id TEXT NOT NULL,
+    version INTEGER NOT NULL,           -- Version number the content had in _wce_documents
+    content TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    is_binary INTEGER DEFAULT 0,        -- BOOLEAN
+    modified_at INTEGER NOT NULL,       -- Unix timestamp the revision was written
+    modified_by TEXT NOT NULL,          -- user_id who wrote the revision
+    compression TEXT,                   -- Compression of content, as in _wce_documents
+    PRIMARY KEY (document_id, version),
+    FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+);
+
+-- Malware scan status for binary documents
+-- Documents with a pending or quarantined scan are not served
+CREATE TABLE IF NOT EXISTS _wce_document_scans (
+    document_id TEXT PRIMARY KEY,
+    version INTEGER NOT NULL,           -- Document version that was scanned
+    status TEXT NOT NULL,               -- 'pending', 'clean', 'quarantined', 'released'
+    signature TEXT,                     -- Scanner signature or error for flagged documents
+    scanned_at INTEGER,                 -- Unix timestamp
+    reviewed_by TEXT,                   -- user_id of admin who released the document
+    reviewed_at INTEGER,                -- Unix timestamp
+    FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_scans_status ON _wce_document_scans(status);
+
+-- Content of binary documents uploaded as raw bodies, split into fixed-size
+-- chunks so uploads and downloads never hold the whole file in memory.
+-- The document row keeps empty content; earlier revisions are not retained.
+CREATE TABLE IF NOT EXISTS _wce_document_blobs (
+    document_id TEXT NOT NULL,
+    seq INTEGER NOT NULL,               -- Chunk position, from 0
+    data BLOB NOT NULL,
+    PRIMARY KEY (document_id, seq),
+    FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+);
+
+-- Thumbnails of image documents, generated on request and kept per width.
+-- A thumbnail is stale once the source content hash changes.
+CREATE TABLE IF NOT EXISTS _wce_document_thumbnails (
+    document_id TEXT NOT NULL,
+    width INTEGER NOT NULL,             -- Requested width in pixels
+    source_hash TEXT NOT NULL,          -- SHA-256 of the content it was made from
+    content_type TEXT NOT NULL,
+    data BLOB NOT NULL,
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    PRIMARY KEY (document_id, width),
+    FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+);
+
+-- Seed fixtures are JSON documents under 'seeds/'; this records the last
+-- application of each so unchanged fixtures are not re-applied on write
+CREATE TABLE IF NOT EXISTS _wce_seed_runs (
+    fixture_id TEXT PRIMARY KEY,        -- Document id of the fixture
+    checksum TEXT NOT NULL,             -- SHA-256 of the fixture content that was applied
+    status TEXT NOT NULL,               -- 'applied', 'failed'
+    error TEXT,                         -- Failure reason
+    rows_inserted INTEGER DEFAULT 0,
+    rows_updated INTEGER DEFAULT 0,
+    documents_created INTEGER DEFAULT 0,
+    documents_updated INTEGER DEFAULT 0,
+    applied_at INTEGER NOT NULL,        -- Unix timestamp
+    applied_by TEXT,                    -- user_id who applied the fixture
+    FOREIGN KEY (fixture_id) REFERENCES _wce_documents(id) ON DELETE CASCADE,
+    FOREIGN KEY (applied_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+-- Serving policy per content type for raw document (asset) responses
+-- content_type may be exact ('image/svg+xml'), a wildcard ('text/*') or '*/*'
+CREATE TABLE IF NOT EXISTS _wce_mime_policies (
+    content_type TEXT PRIMARY KEY,
+    disposition TEXT NOT NULL,          -- 'inline', 'attachment', 'reject'
+    updated_at INTEGER NOT NULL,        -- Unix timestamp
+    updated_by TEXT,                    -- user_id who updated
+    FOREIGN KEY (updated_by) REFERENCES _wce_users(user_id)
+);
+
+-- Saved searches, run by name at /{cenvID}/collections/{name} and from
+-- templates and scripts
+CREATE TABLE IF NOT EXISTS _wce_collections (
+    name TEXT PRIMARY KEY,
+    query TEXT NOT NULL DEFAULT '',     -- Full-text search, in search syntax
+    tags TEXT NOT NULL DEFAULT '[]',    -- JSON array; documents must carry every tag
+    prefix TEXT NOT NULL DEFAULT '',    -- Document id prefix
+    sort TEXT NOT NULL DEFAULT '',      -- 'rank', 'id', 'created_at', 'modified_at', '-' reverses
+    page_size INTEGER NOT NULL DEFAULT 20, -- Results per page unless the caller asks for a limit
+    schema_id TEXT,                     -- Document holding the JSON Schema of items; set for typed collections
+    modified_at INTEGER NOT NULL,       -- Unix timestamp
+    modified_by TEXT,                   -- user_id who saved the definition
+    FOREIGN KEY (modified_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
 -- Full-text search index (FTS5)
 -- Using external content table for better performance
 CREATE VIRTUAL TABLE IF NOT EXISTS _wce_document_search USING fts5(
@@ -164,23 +400,7 @@ CREATE VIRTUAL TABLE IF NOT EXISTS _wce_document_search USING fts5(
     content
 );
 
--- Triggers to keep FTS5 index synchronized
-CREATE TRIGGER IF NOT EXISTS _wce_documents_ai AFTER INSERT ON _wce_documents BEGIN
-    INSERT INTO _wce_document_search(document_id, content)
-    VALUES (NEW.id, CASE WHEN NEW.searchable = 1 THEN NEW.content ELSE '' END);
-END;
-
-CREATE TRIGGER IF NOT EXISTS _wce_documents_ad AFTER DELETE ON _wce_documents BEGIN
-    DELETE FROM _wce_document_search WHERE document_id = OLD.id;
-END;
-
-CREATE TRIGGER IF NOT EXISTS _wce_documents_au AFTER UPDATE ON _wce_documents BEGIN
-    UPDATE _wce_document_search
-    SET document_id = NEW.id,
-        content = CASE WHEN NEW.searchable = 1 THEN NEW.content ELSE '' END
-    WHERE document_id = OLD.id;
-END;
-
+` + SearchTriggers + `
 -- ----------------------------------------------------------------------------
 -- Starlark Endpoints (Phase 6)
 -- Dynamic HTTP endpoints defined via Starlark scripts
@@ -192,6 +412,13 @@ CREATE TABLE IF NOT EXISTS _wce_endpoints (
     method TEXT NOT NULL,               -- HTTP method (GET, POST, PUT, DELETE, *)
     script TEXT NOT NULL,               -- Starlark script content
     description TEXT,                   -- Optional description
+    request_schema TEXT,                -- Optional JSON Schema for the request body (OpenAPI)
+    response_schema TEXT,               -- Optional JSON Schema for the response body (OpenAPI)
+    mock_enabled INTEGER DEFAULT 0,     -- 1 = serve mock response instead of running script (BOOLEAN)
+    mock_status INTEGER DEFAULT 200,    -- HTTP status of the mock response
+    mock_body TEXT,                     -- JSON-encoded mock response body
+    mock_content_type TEXT,             -- Optional Content-Type of the mock response
+    capabilities TEXT NOT NULL DEFAULT '["db_write","http","kv","mail"]', -- JSON array of granted builtins
     enabled INTEGER DEFAULT 1,          -- 1 = enabled, 0 = disabled (BOOLEAN)
     created_at INTEGER NOT NULL,        -- Unix timestamp
     modified_at INTEGER NOT NULL,       -- Unix timestamp
@@ -205,6 +432,237 @@ CREATE TABLE IF NOT EXISTS _wce_endpoints (
 CREATE INDEX IF NOT EXISTS idx_endpoints_path ON _wce_endpoints(path);
 CREATE INDEX IF NOT EXISTS idx_endpoints_enabled ON _wce_endpoints(enabled);
 
+-- Documents endpoint scripts render with template.render("id"), rebuilt from
+-- the script on every save
+CREATE TABLE IF NOT EXISTS _wce_endpoint_references (
+    endpoint_id INTEGER NOT NULL,
+    target_id TEXT NOT NULL,
+    PRIMARY KEY (endpoint_id, target_id),
+    FOREIGN KEY (endpoint_id) REFERENCES _wce_endpoints(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_endpoint_references_target ON _wce_endpoint_references(target_id);
+
+-- Structured log entries emitted by endpoint scripts via log.debug/info/warn/error
+CREATE TABLE IF NOT EXISTS _wce_endpoint_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    endpoint_id INTEGER NOT NULL,
+    request_id TEXT NOT NULL,           -- Correlates entries from one execution (X-Request-Id)
+    level TEXT NOT NULL,                -- 'debug', 'info', 'warn', 'error'
+    message TEXT NOT NULL,
+    fields TEXT,                        -- JSON object of structured fields
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    FOREIGN KEY (endpoint_id) REFERENCES _wce_endpoints(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_endpoint_logs_endpoint ON _wce_endpoint_logs(endpoint_id, id);
+CREATE INDEX IF NOT EXISTS idx_endpoint_logs_request ON _wce_endpoint_logs(request_id);
+
+-- Endpoint changes proposed by editors, applied only after owner/admin approval
+CREATE TABLE IF NOT EXISTS _wce_endpoint_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    path TEXT NOT NULL,
+    method TEXT NOT NULL,
+    script TEXT NOT NULL,               -- Proposed script
+    description TEXT,
+    request_schema TEXT,
+    response_schema TEXT,
+    capabilities TEXT,                  -- Proposed JSON array of builtins (NULL = keep current grant)
+    base_script TEXT,                   -- Live script when submitted (NULL = new endpoint)
+    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'approved', 'rejected'
+    submitted_by TEXT NOT NULL,         -- user_id
+    submitted_at INTEGER NOT NULL,      -- Unix timestamp
+    reviewed_by TEXT,                   -- user_id
+    reviewed_at INTEGER,                -- Unix timestamp
+    review_comment TEXT,
+    FOREIGN KEY (submitted_by) REFERENCES _wce_users(user_id),
+    FOREIGN KEY (reviewed_by) REFERENCES _wce_users(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_endpoint_changes_status ON _wce_endpoint_changes(status);
+
+-- Redacted captures of failed endpoint requests for replay
+-- Only recorded when 'capture_failed_requests' is enabled
+CREATE TABLE IF NOT EXISTS _wce_request_captures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    endpoint_id INTEGER NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,                 -- Endpoint path (after /star)
+    query TEXT,                         -- Raw query string
+    headers TEXT,                       -- JSON object of allowlisted headers
+    body TEXT,                          -- Request body (truncated)
+    user_id TEXT,                       -- Authenticated user, if any
+    error TEXT NOT NULL,                -- Execution error message
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    FOREIGN KEY (endpoint_id) REFERENCES _wce_endpoints(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_captures_endpoint ON _wce_request_captures(endpoint_id);
+
+-- Starlark database calls slower than 'slow_query_ms', used by the index advisor
+CREATE TABLE IF NOT EXISTS _wce_slow_queries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    endpoint_id INTEGER,
+    sql TEXT NOT NULL,
+    duration_ms REAL NOT NULL,
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    FOREIGN KEY (endpoint_id) REFERENCES _wce_endpoints(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_slow_queries_sql ON _wce_slow_queries(sql);
+
+-- Before/after images of rows in user tables with history enabled
+-- Written by _wce_history_* triggers installed through the schema builder
+CREATE TABLE IF NOT EXISTS _wce_row_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    table_name TEXT NOT NULL,
+    row_id TEXT NOT NULL,               -- Primary key (or rowid) of the changed row
+    operation TEXT NOT NULL,            -- 'INSERT', 'UPDATE', 'DELETE'
+    before_image TEXT,                  -- JSON object of column values (NULL for INSERT)
+    after_image TEXT,                   -- JSON object of column values (NULL for DELETE)
+    changed_at INTEGER NOT NULL         -- Unix timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_row_history_row ON _wce_row_history(table_name, row_id, id);
+
+-- User tables that treat their deleted_at column as a soft delete marker
+-- Deletes through the query rewriter set deleted_at and reads skip those rows
+CREATE TABLE IF NOT EXISTS _wce_soft_delete_tables (
+    table_name TEXT PRIMARY KEY COLLATE NOCASE,
+    enabled_at INTEGER NOT NULL,        -- Unix timestamp
+    enabled_by TEXT,                    -- user_id who enabled soft delete
+    FOREIGN KEY (enabled_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+-- ----------------------------------------------------------------------------
+-- Cross-cenv Sharing
+-- A sharing cenv grants another cenv's scripts read access to one table or
+-- document prefix; the grantee stores the token and the server presents it
+-- ----------------------------------------------------------------------------
+
+-- Shares granted by this cenv
+CREATE TABLE IF NOT EXISTS _wce_shares (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    grantee_cenv TEXT NOT NULL,         -- cenv whose scripts may read
+    scope_type TEXT NOT NULL,           -- 'table' or 'documents'
+    scope TEXT NOT NULL,                -- Table name or document id prefix
+    token_hash TEXT UNIQUE NOT NULL,    -- SHA256 of the share token
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    created_by TEXT,                    -- user_id who granted the share
+    expires_at INTEGER,                 -- Unix timestamp; NULL = no expiry
+    revoked_at INTEGER,                 -- Unix timestamp; NULL = active
+    FOREIGN KEY (created_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_shares_grantee ON _wce_shares(grantee_cenv);
+
+-- Share tokens this cenv has been given by other cenvs
+CREATE TABLE IF NOT EXISTS _wce_remote_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_cenv TEXT NOT NULL,          -- cenv that granted the share
+    token TEXT NOT NULL,                -- Share token, never exposed to scripts
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    created_by TEXT,                    -- user_id who stored the token
+    FOREIGN KEY (created_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL,
+    UNIQUE(source_cenv, token)
+);
+
+-- ----------------------------------------------------------------------------
+-- Secrets and Upstream Proxy Routes
+-- ----------------------------------------------------------------------------
+
+CREATE TABLE IF NOT EXISTS _wce_secrets (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,                -- Encrypted with a data key; never returned by the API
+    updated_at INTEGER NOT NULL,        -- Unix timestamp
+    updated_by TEXT,                    -- user_id who set the value
+    FOREIGN KEY (updated_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+-- Per-cenv data keys, wrapped by the server master key. The newest encrypts
+-- new values; rotation re-encrypts every secret and deletes the rest.
+CREATE TABLE IF NOT EXISTS _wce_secret_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    wrapped_key BLOB NOT NULL,
+    master_key_id TEXT NOT NULL,        -- Master key that wrapped it
+    created_at INTEGER NOT NULL,        -- Unix timestamp
+    created_by TEXT
+);
+
+CREATE TABLE IF NOT EXISTS _wce_proxy_routes (
+    name TEXT PRIMARY KEY,              -- Served at /{cenvID}/ext/{name}/...
+    upstream_url TEXT NOT NULL,         -- Base URL requests are forwarded to
+    auth_header TEXT,                   -- Header the secret is injected into
+    auth_prefix TEXT NOT NULL DEFAULT '', -- e.g. 'Bearer '
+    secret_name TEXT,
+    timeout_ms INTEGER NOT NULL,
+    max_response_bytes INTEGER NOT NULL,
+    cache_ttl_seconds INTEGER NOT NULL DEFAULT 0, -- 0 disables caching
+    enabled INTEGER NOT NULL DEFAULT 1,
+    modified_at INTEGER NOT NULL,       -- Unix timestamp
+    modified_by TEXT,
+    FOREIGN KEY (secret_name) REFERENCES _wce_secrets(name),
+    FOREIGN KEY (modified_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS _wce_proxy_cache (
+    route_name TEXT NOT NULL,
+    cache_key TEXT NOT NULL,            -- Upstream path and query
+    status INTEGER NOT NULL,
+    headers TEXT NOT NULL,              -- JSON object of forwarded headers
+    body BLOB NOT NULL,
+    expires_at INTEGER NOT NULL,        -- Unix timestamp
+    PRIMARY KEY (route_name, cache_key),
+    FOREIGN KEY (route_name) REFERENCES _wce_proxy_routes(name) ON DELETE CASCADE
+);
+
+-- Feature flags gate parts of cenv apps. A flag is on for the rollout
+-- percentage of users, picked by a stable hash of flag and user, unless a
+-- per-user override says otherwise.
+CREATE TABLE IF NOT EXISTS _wce_feature_flags (
+    name TEXT PRIMARY KEY,              -- As used in flags.enabled(name)
+    description TEXT NOT NULL DEFAULT '',
+    enabled INTEGER NOT NULL DEFAULT 0, -- Off for everyone but overrides when 0
+    rollout INTEGER NOT NULL DEFAULT 100, -- Percentage of users, 0-100
+    modified_at INTEGER NOT NULL,       -- Unix timestamp
+    modified_by TEXT,
+    FOREIGN KEY (modified_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS _wce_feature_flag_overrides (
+    flag_name TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    enabled INTEGER NOT NULL,           -- Wins over the flag's state and rollout
+    PRIMARY KEY (flag_name, user_id),
+    FOREIGN KEY (flag_name) REFERENCES _wce_feature_flags(name) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES _wce_users(user_id) ON DELETE CASCADE
+);
+
+-- Hook scripts run after document writes. Each defines handle_event(event)
+-- and runs for one event; hooks of an event run in name order.
+CREATE TABLE IF NOT EXISTS _wce_hooks (
+    name TEXT PRIMARY KEY,
+    event TEXT NOT NULL,                -- on_document_created, on_document_updated or on_document_deleted
+    script TEXT NOT NULL,               -- Starlark script content
+    description TEXT NOT NULL DEFAULT '',
+    capabilities TEXT NOT NULL DEFAULT '[]', -- JSON array of granted builtins
+    enabled INTEGER NOT NULL DEFAULT 1, -- 1 = enabled, 0 = disabled (BOOLEAN)
+    last_run_at INTEGER,                -- Unix timestamp of the latest run
+    last_error TEXT,                    -- Error of the latest run, NULL when it succeeded
+    modified_at INTEGER NOT NULL,       -- Unix timestamp
+    modified_by TEXT,
+    FOREIGN KEY (modified_by) REFERENCES _wce_users(user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_hooks_event ON _wce_hooks(event);
+
+-- Key-value store of the Starlark kv module
+CREATE TABLE IF NOT EXISTS _wce_kv (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,                -- JSON-encoded value
+    modified_at INTEGER NOT NULL        -- Unix timestamp
+);
+
 -- ----------------------------------------------------------------------------
 -- Default Configuration Values
 -- ----------------------------------------------------------------------------
@@ -214,5 +672,51 @@ INSERT OR IGNORE INTO _wce_config (key, value, updated_at) VALUES
     ('allow_registration', 'false', strftime('%s', 'now')),
     ('max_users', '10', strftime('%s', 'now')),
     ('max_document_size_mb', '10', strftime('%s', 'now')),
-    ('starlark_timeout_seconds', '5', strftime('%s', 'now'));
+    ('storage_quota_mb', '0', strftime('%s', 'now')),
+    ('starlark_timeout_seconds', '5', strftime('%s', 'now')),
+    ('capture_failed_requests', 'false', strftime('%s', 'now')),
+    ('compliance_capture', 'false', strftime('%s', 'now')),
+    ('retention_audit_days', '0', strftime('%s', 'now')),
+    ('retention_session_days', '0', strftime('%s', 'now')),
+    ('retention_analytics_days', '0', strftime('%s', 'now')),
+    ('allow_editor_endpoints', 'false', strftime('%s', 'now')),
+    ('editor_endpoint_capabilities', '[]', strftime('%s', 'now')),
+    ('endpoint_log_level', 'info', strftime('%s', 'now')),
+    ('slow_query_ms', '100', strftime('%s', 'now')),
+    ('require_signed_admin_requests', 'true', strftime('%s', 'now')),
+    ('bind_sessions_to_client', 'false', strftime('%s', 'now')),
+    ('alert_failed_logins', '5', strftime('%s', 'now')),
+    ('alert_failed_login_window_minutes', '15', strftime('%s', 'now')),
+    ('alert_on_new_country', 'true', strftime('%s', 'now')),
+    ('alert_on_permission_escalation', 'true', strftime('%s', 'now')),
+    ('alert_webhook_url', '', strftime('%s', 'now')),
+    ('expired_documents', 'delete', strftime('%s', 'now')),
+    ('document_compression', 'off', strftime('%s', 'now')),
+    ('document_compression_threshold_kb', '64', strftime('%s', 'now')),
+    ('markdown_template', '', strftime('%s', 'now')),
+    ('feed_prefix', '', strftime('%s', 'now')),
+    ('feed_title', '', strftime('%s', 'now')),
+    ('site_url', '', strftime('%s', 'now'));
+`
+
+// SearchTriggers keeps _wce_document_search in step with _wce_documents. It
+// is part of Schema and is reinstalled when the search index is rebuilt.
+const SearchTriggers = `
+-- Triggers to keep FTS5 index synchronized
+-- Compressed content is indexed as empty here and written by the Go code
+CREATE TRIGGER IF NOT EXISTS _wce_documents_ai AFTER INSERT ON _wce_documents BEGIN
+    INSERT INTO _wce_document_search(document_id, content)
+    VALUES (NEW.id, CASE WHEN NEW.searchable = 1 AND NEW.compression IS NULL THEN NEW.content ELSE '' END);
+END;
+
+CREATE TRIGGER IF NOT EXISTS _wce_documents_ad AFTER DELETE ON _wce_documents BEGIN
+    DELETE FROM _wce_document_search WHERE document_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS _wce_documents_au AFTER UPDATE OF id, content, searchable, compression ON _wce_documents BEGIN
+    UPDATE _wce_document_search
+    SET document_id = NEW.id,
+        content = CASE WHEN NEW.searchable = 1 AND NEW.compression IS NULL THEN NEW.content ELSE '' END
+    WHERE document_id = OLD.id;
+END;
 `
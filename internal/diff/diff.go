@@ -0,0 +1,214 @@
+// Package diff computes line-based differences between texts and structural
+// differences between JSON documents.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind identifies the type of a diff line
+type Kind byte
+
+const (
+	Equal  Kind = ' '
+	Insert Kind = '+'
+	Delete Kind = '-'
+)
+
+// Line is a single line of a diff
+type Line struct {
+	Kind Kind   `json:"kind"`
+	Text string `json:"text"`
+}
+
+// maxCells bounds the LCS table size; larger inputs are diffed as a full replacement
+const maxCells = 4 * 1024 * 1024
+
+// splitLines splits text into lines without their trailing newlines
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines returns the line-by-line edit script that turns a into b
+func Lines(a, b string) []Line {
+	return diffLines(splitLines(a), splitLines(b))
+}
+
+// diffLines computes the edit script using a longest common subsequence table
+func diffLines(a, b []string) []Line {
+	// Trim the common prefix and suffix to keep the table small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, Line{Equal, text})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+
+	if len(midA)*len(midB) > maxCells {
+		for _, text := range midA {
+			lines = append(lines, Line{Delete, text})
+		}
+		for _, text := range midB {
+			lines = append(lines, Line{Insert, text})
+		}
+	} else {
+		lines = append(lines, lcsDiff(midA, midB)...)
+	}
+
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, Line{Equal, text})
+	}
+
+	return lines
+}
+
+// lcsDiff diffs two slices with a dynamic programming LCS table
+func lcsDiff(a, b []string) []Line {
+	n, m := len(a), len(b)
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]Line, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Delete, a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		lines = append(lines, Line{Delete, a[i]})
+	}
+	for ; j < m; j++ {
+		lines = append(lines, Line{Insert, b[j]})
+	}
+
+	return lines
+}
+
+// Unified renders the difference between a and b in unified diff format
+// with the given number of context lines. Returns "" if the texts are equal.
+func Unified(fromName, toName, a, b string, context int) string {
+	lines := Lines(a, b)
+
+	changed := false
+	for _, line := range lines {
+		if line.Kind != Equal {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers (1-based) in a and b at the start of each diff line
+	aLine := make([]int, len(lines)+1)
+	bLine := make([]int, len(lines)+1)
+	aLine[0], bLine[0] = 1, 1
+	for i, line := range lines {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if line.Kind != Insert {
+			aLine[i+1]++
+		}
+		if line.Kind != Delete {
+			bLine[i+1]++
+		}
+	}
+
+	for start := 0; start < len(lines); {
+		// Find the next change
+		for start < len(lines) && lines[start].Kind == Equal {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other
+		end := start
+		for end < len(lines) {
+			if lines[end].Kind != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Kind == Equal {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+
+		hunkStart := max(start-context, 0)
+		hunkEnd := min(end+context, len(lines))
+
+		aCount := aLine[hunkEnd] - aLine[hunkStart]
+		bCount := bLine[hunkEnd] - bLine[hunkStart]
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(aLine[hunkStart], aCount), hunkRange(bLine[hunkStart], bCount))
+
+		for _, line := range lines[hunkStart:hunkEnd] {
+			sb.WriteByte(byte(line.Kind))
+			sb.WriteString(line.Text)
+			sb.WriteByte('\n')
+		}
+
+		start = hunkEnd
+	}
+
+	return sb.String()
+}
+
+// hunkRange formats a hunk range; empty ranges refer to the line before
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
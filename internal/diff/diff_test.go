@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	lines := Lines("a\nb\nc\n", "a\nx\nc\n")
+
+	want := []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %d: %v", len(want), len(lines), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Line %d: expected %v, got %v", i, want[i], lines[i])
+		}
+	}
+}
+
+func TestLines_Empty(t *testing.T) {
+	lines := Lines("", "new\n")
+	if len(lines) != 1 || lines[0].Kind != Insert {
+		t.Errorf("Expected single insert, got %v", lines)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n"
+
+	got := Unified("old", "new", a, b, 1)
+	want := "--- old\n+++ new\n" +
+		"@@ -4,3 +4,3 @@\n 4\n-5\n+five\n 6\n" +
+		"@@ -10 +10,2 @@\n 10\n+11\n"
+
+	if got != want {
+		t.Errorf("Unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnified_MergesNearbyHunks(t *testing.T) {
+	got := Unified("a", "b", "1\n2\n3\n4\n", "x\n2\n3\ny\n", 1)
+	want := "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n-4\n+y\n"
+
+	if got != want {
+		t.Errorf("Unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnified_NoChanges(t *testing.T) {
+	if got := Unified("a", "b", "same\n", "same\n", 3); got != "" {
+		t.Errorf("Expected empty diff, got %q", got)
+	}
+}
+
+func TestUnified_NewFile(t *testing.T) {
+	got := Unified("a", "b", "", "x\ny\n", 3)
+	want := "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+
+	if got != want {
+		t.Errorf("Unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	a := `{"title": "Post", "tags": ["a", "b"], "meta": {"views": 1, "a/b": true}, "draft": true}`
+	b := `{"title": "Post 2", "tags": ["a"], "meta": {"views": 2, "a/b": true}, "author": "alice"}`
+
+	changes, err := JSON(a, b)
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+
+	want := []Change{
+		{Op: OpAdd, Path: "/author", To: "alice"},
+		{Op: OpRemove, Path: "/draft", From: true},
+		{Op: OpReplace, Path: "/meta/views", From: json.Number("1"), To: json.Number("2")},
+		{Op: OpRemove, Path: "/tags/1", From: "b"},
+		{Op: OpReplace, Path: "/title", From: "Post", To: "Post 2"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Unexpected changes:\n got %v\nwant %v", changes, want)
+	}
+}
+
+func TestJSON_TypeChangeAndEscaping(t *testing.T) {
+	changes, err := JSON(`{"a/b": [1], "c~d": 1}`, `{"a/b": {"x": 1}, "c~d": 1}`)
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Op != OpReplace || changes[0].Path != "/a~1b" {
+		t.Errorf("Expected a replace at /a~1b, got %v", changes)
+	}
+
+	changes, err = JSON(`[1, 2]`, `[1, 2]`)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v, %v", changes, err)
+	}
+
+	if _, err := JSON(`{`, `{}`); err == nil {
+		t.Error("Expected an error for invalid JSON")
+	}
+}
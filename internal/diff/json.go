@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operations of a structural JSON diff
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// Change is one difference between two JSON values, addressed by the JSON
+// Pointer of the value that changed. From is null for additions and To is
+// null for removals.
+type Change struct {
+	Op   string      `json:"op"`
+	Path string      `json:"path"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// JSON returns the changes that turn JSON document a into b. Object members
+// are compared by key in sorted order and array elements by index, so an
+// element inserted into an array also shows as a change to every element
+// after it.
+func JSON(a, b string) ([]Change, error) {
+	from, err := decodeJSON(a)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON in old document: %w", err)
+	}
+	to, err := decodeJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON in new document: %w", err)
+	}
+
+	changes := []Change{}
+	diffValues("", from, to, &changes)
+	return changes, nil
+}
+
+// decodeJSON decodes text keeping numbers exact
+func decodeJSON(text string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// diffValues appends the changes from a to b at path to changes
+func diffValues(path string, a, b interface{}, changes *[]Change) {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for key := range a {
+				keys = append(keys, key)
+			}
+			for key := range b {
+				if _, ok := a[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				child := path + "/" + escapePointer(key)
+				from, inA := a[key]
+				to, inB := b[key]
+				switch {
+				case !inA:
+					*changes = append(*changes, Change{Op: OpAdd, Path: child, To: to})
+				case !inB:
+					*changes = append(*changes, Change{Op: OpRemove, Path: child, From: from})
+				default:
+					diffValues(child, from, to, changes)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			for i := 0; i < max(len(a), len(b)); i++ {
+				child := path + "/" + strconv.Itoa(i)
+				switch {
+				case i >= len(a):
+					*changes = append(*changes, Change{Op: OpAdd, Path: child, To: b[i]})
+				case i >= len(b):
+					*changes = append(*changes, Change{Op: OpRemove, Path: child, From: a[i]})
+				default:
+					diffValues(child, a[i], b[i], changes)
+				}
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, Change{Op: OpReplace, Path: path, From: a, To: b})
+	}
+}
+
+// escapePointer escapes a key for use as a JSON Pointer reference token
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
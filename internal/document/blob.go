@@ -0,0 +1,245 @@
+package document
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// BlobChunkSize is the size of each stored chunk of a streamed document
+const BlobChunkSize = 256 * 1024
+
+// IsBlob reports whether a document's content lives in _wce_document_blobs.
+// Streamed documents are binary with empty inline content.
+func (d *Document) IsBlob() bool {
+	return d.IsBinary && d.Content == ""
+}
+
+// WriteBlob stores the content read from r as binary document id, creating
+// the document or replacing the content of an existing binary document.
+// Content is read and stored one chunk at a time inside a single write
+// transaction, so other writers wait for the upload to finish. Content over
+// the document size limit or the storage quota is refused with a *LimitError.
+// Reports whether the document was created.
+func WriteBlob(db *sql.DB, id, contentType, userID string, r io.Reader) (*Document, bool, error) {
+	if id == "" {
+		return nil, false, fmt.Errorf("document id cannot be empty")
+	}
+	if contentType == "" {
+		return nil, false, fmt.Errorf("content type cannot be empty")
+	}
+	if userID == "" {
+		return nil, false, fmt.Errorf("user id cannot be empty")
+	}
+
+	limits := readLimits(db)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := clock.Now().Unix()
+
+	var isBinaryInt, version int
+	err = tx.QueryRow("SELECT is_binary, version FROM _wce_documents WHERE id = ?", id).Scan(&isBinaryInt, &version)
+	created := err == sql.ErrNoRows
+	switch {
+	case created:
+		if err := checkID(id); err != nil {
+			return nil, false, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO _wce_documents (
+				id, content, content_type, is_binary, searchable,
+				created_at, modified_at, created_by, modified_by, version
+			) VALUES (?, '', ?, 1, 0, ?, ?, ?, ?, 1)
+		`, id, contentType, now, now, userID, userID)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to insert document: %w", err)
+		}
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to check document existence: %w", err)
+	case isBinaryInt != 1:
+		return nil, false, fmt.Errorf("document %s is not binary", id)
+	default:
+		_, err = tx.Exec(`
+			UPDATE _wce_documents
+			SET content = '', compression = NULL, content_type = ?, searchable = 0, modified_at = ?, modified_by = ?, version = ?
+			WHERE id = ?
+		`, contentType, now, userID, version+1, id)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to update document: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM _wce_document_blobs WHERE document_id = ?", id); err != nil {
+			return nil, false, fmt.Errorf("failed to replace document content: %w", err)
+		}
+	}
+
+	// Storage used by everything but this document's new content
+	var used int64
+	if limits.quota > 0 {
+		if used, err = usedBytes(tx); err != nil {
+			return nil, false, err
+		}
+	}
+
+	h := sha256.New()
+	r = io.TeeReader(r, h)
+	buf := make([]byte, BlobChunkSize)
+	var size int64
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if size+int64(n) > limits.maxDocument {
+				return nil, false, &LimitError{Limit: LimitDocumentSize, Size: size + int64(n), Max: limits.maxDocument}
+			}
+			if limits.quota > 0 && used+size+int64(n) > limits.quota {
+				return nil, false, &LimitError{Limit: LimitStorageQuota, Size: used + size + int64(n), Max: limits.quota}
+			}
+			if _, err := tx.Exec(
+				"INSERT INTO _wce_document_blobs (document_id, seq, data) VALUES (?, ?, ?)",
+				id, seq, buf[:n],
+			); err != nil {
+				return nil, false, fmt.Errorf("failed to store document content: %w", err)
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read document content: %w", err)
+		}
+	}
+
+	if size == 0 {
+		return nil, false, fmt.Errorf("document content cannot be empty")
+	}
+	if _, err := tx.Exec("UPDATE _wce_documents SET content_hash = ? WHERE id = ?", hex.EncodeToString(h.Sum(nil)), id); err != nil {
+		return nil, false, fmt.Errorf("failed to store document hash: %w", err)
+	}
+
+	op := ChangeUpdate
+	if created {
+		op = ChangeCreate
+	}
+	if err := recordChange(tx, op, id, userID); err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	doc, err := GetDocument(db, id)
+	if err != nil {
+		return nil, false, err
+	}
+	return doc, created, nil
+}
+
+// BlobSize returns the total content length of a streamed document
+func BlobSize(db DB, id string) (int64, error) {
+	var size int64
+	err := db.QueryRow(
+		"SELECT COALESCE(SUM(length(data)), 0) FROM _wce_document_blobs WHERE document_id = ?", id,
+	).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get document size: %w", err)
+	}
+	return size, nil
+}
+
+// BlobReader reads a streamed document's content one chunk at a time from a
+// read transaction, so a concurrent replacement is never seen half-way.
+// Close must be called to end the transaction.
+type BlobReader struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	id     string
+	seq    int
+	chunk  []byte
+	offset int64 // Position of the next byte read
+	skip   int64 // Bytes to drop from the next chunk loaded, after a seek
+}
+
+// OpenBlob opens a streamed document's content for reading. Reads fail once
+// ctx is done.
+func OpenBlob(ctx context.Context, db *sql.DB, id string) (*BlobReader, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return &BlobReader{ctx: ctx, tx: tx, id: id}, nil
+}
+
+// Read implements io.Reader
+func (b *BlobReader) Read(p []byte) (int, error) {
+	if len(b.chunk) == 0 {
+		err := b.tx.QueryRowContext(b.ctx,
+			"SELECT data FROM _wce_document_blobs WHERE document_id = ? AND seq = ?", b.id, b.seq,
+		).Scan(&b.chunk)
+		if err == sql.ErrNoRows {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read document content: %w", err)
+		}
+		b.seq++
+		if b.skip > 0 {
+			b.chunk = b.chunk[min(b.skip, int64(len(b.chunk))):]
+			b.skip = 0
+			if len(b.chunk) == 0 {
+				return 0, io.EOF
+			}
+		}
+	}
+
+	n := copy(p, b.chunk)
+	b.chunk = b.chunk[n:]
+	b.offset += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker, so ranges of streamed content can be served.
+// Every chunk but the last is BlobChunkSize long, which maps an offset
+// straight to the chunk holding it.
+func (b *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		var size int64
+		err := b.tx.QueryRowContext(b.ctx,
+			"SELECT COALESCE(SUM(length(data)), 0) FROM _wce_document_blobs WHERE document_id = ?", b.id,
+		).Scan(&size)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get document size: %w", err)
+		}
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+
+	b.seq = int(offset / BlobChunkSize)
+	b.skip = offset % BlobChunkSize
+	b.chunk = nil
+	b.offset = offset
+	return offset, nil
+}
+
+// Close ends the read transaction
+func (b *BlobReader) Close() error {
+	return b.tx.Rollback()
+}
@@ -0,0 +1,204 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+// readBlob reads a streamed document's full content
+func readBlob(t *testing.T, db *sql.DB, id string) []byte {
+	t.Helper()
+
+	reader, err := OpenBlob(context.Background(), db, id)
+	if err != nil {
+		t.Fatalf("OpenBlob failed: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	return data
+}
+
+func TestWriteBlob(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Spans three chunks, the last one partial
+	data := bytes.Repeat([]byte("0123456789"), BlobChunkSize/5+7)
+
+	doc, created, err := WriteBlob(db, "files/big.bin", "application/octet-stream", "user-1", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+	if !created || !doc.IsBlob() || doc.Version != 1 || doc.Size != int64(len(data)) {
+		t.Errorf("Unexpected document: created=%v %+v", created, doc)
+	}
+	if got := readBlob(t, db, "files/big.bin"); !bytes.Equal(got, data) {
+		t.Errorf("Content mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+
+	doc, created, err = WriteBlob(db, "files/big.bin", "image/png", "user-1", bytes.NewReader([]byte("small")))
+	if err != nil {
+		t.Fatalf("WriteBlob replace failed: %v", err)
+	}
+	if created || doc.Version != 2 || doc.ContentType != "image/png" || doc.Size != 5 {
+		t.Errorf("Unexpected replaced document: created=%v %+v", created, doc)
+	}
+	if got := readBlob(t, db, "files/big.bin"); string(got) != "small" {
+		t.Errorf("Expected replaced content, got %q", got)
+	}
+
+	if _, _, err := WriteBlob(db, "files/empty.bin", "application/octet-stream", "user-1", bytes.NewReader(nil)); err == nil {
+		t.Error("Expected error for empty content")
+	}
+	if _, err := GetDocument(db, "files/empty.bin"); err == nil {
+		t.Error("Expected failed upload to leave no document")
+	}
+
+	if _, err := CreateDocument(db, "notes.txt", "text", "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, _, err := WriteBlob(db, "notes.txt", "application/octet-stream", "user-1", bytes.NewReader(data)); err == nil {
+		t.Error("Expected error streaming into a text document")
+	}
+}
+
+func TestBlobMoveCopyDelete(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, _, err := WriteBlob(db, "a.bin", "application/octet-stream", "user-1", bytes.NewReader([]byte("payload"))); err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+
+	if _, err := CopyDocument(db, "a.bin", "b.bin", "user-1"); err != nil {
+		t.Fatalf("CopyDocument failed: %v", err)
+	}
+	if _, err := MoveDocument(db, "a.bin", "c.bin", "user-1"); err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+	for _, id := range []string{"b.bin", "c.bin"} {
+		if got := readBlob(t, db, id); string(got) != "payload" {
+			t.Errorf("Expected payload at %s, got %q", id, got)
+		}
+	}
+
+	// Inline content replaces the streamed chunks
+	if _, err := UpdateDocument(db, "b.bin", "aW5saW5l", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if size, _ := BlobSize(db, "b.bin"); size != 0 {
+		t.Errorf("Expected chunks removed after inline update, got %d bytes", size)
+	}
+
+	if err := DeleteDocument(db, "c.bin", "user-1"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM _wce_document_blobs").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no chunks left, got %d", count)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	data := bytes.Repeat([]byte("x"), BlobChunkSize+1)
+	blob, _, err := WriteBlob(db, "a.bin", "application/octet-stream", "user-1", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+	text, err := CreateDocument(db, "a.txt", string(data), "text/plain", "user-1", false, false)
+	if err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	for _, doc := range []*Document{blob, text} {
+		got, err := ContentHash(context.Background(), db, doc)
+		if err != nil {
+			t.Fatalf("ContentHash failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Unexpected hash for %s: %s", doc.ID, got)
+		}
+
+		// The hash is stored on write, so reads need not hash the content
+		var stored string
+		db.QueryRow("SELECT content_hash FROM _wce_documents WHERE id = ?", doc.ID).Scan(&stored)
+		if stored != want {
+			t.Errorf("Expected the hash of %s to be stored, got %q", doc.ID, stored)
+		}
+	}
+
+	// Rows written without a hash are hashed once and the hash stored
+	db.Exec("UPDATE _wce_documents SET content_hash = NULL")
+	doc, err := GetDocument(db, "a.bin")
+	if err != nil {
+		t.Fatalf("GetDocument failed: %v", err)
+	}
+	if doc.Hash != "" {
+		t.Fatalf("Expected no stored hash, got %q", doc.Hash)
+	}
+	if got, err := ContentHash(context.Background(), db, doc); err != nil || got != want {
+		t.Fatalf("Expected %s, got %s: %v", want, got, err)
+	}
+	if doc, _ = GetDocument(db, "a.bin"); doc.Hash != want {
+		t.Errorf("Expected the computed hash to be stored, got %q", doc.Hash)
+	}
+}
+
+func TestBlobReaderSeek(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), BlobChunkSize/5+7)
+	if _, _, err := WriteBlob(db, "files/big.bin", "application/octet-stream", "user-1", bytes.NewReader(data)); err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+
+	reader, err := OpenBlob(context.Background(), db, "files/big.bin")
+	if err != nil {
+		t.Fatalf("OpenBlob failed: %v", err)
+	}
+	defer reader.Close()
+
+	if size, err := reader.Seek(0, io.SeekEnd); err != nil || size != int64(len(data)) {
+		t.Fatalf("Seek to end = %d, %v; want %d", size, err, len(data))
+	}
+
+	// Ranges within a chunk, across a chunk boundary and at the end
+	for _, r := range [][2]int64{{5, 20}, {BlobChunkSize - 3, 10}, {int64(len(data)) - 4, 4}, {2*BlobChunkSize + 1, 60}} {
+		if _, err := reader.Seek(r[0], io.SeekStart); err != nil {
+			t.Fatalf("Seek(%d) failed: %v", r[0], err)
+		}
+		got := make([]byte, r[1])
+		if _, err := io.ReadFull(reader, got); err != nil {
+			t.Fatalf("Read at %d failed: %v", r[0], err)
+		}
+		if !bytes.Equal(got, data[r[0]:r[0]+r[1]]) {
+			t.Errorf("Read at %d = %q, want %q", r[0], got, data[r[0]:r[0]+r[1]])
+		}
+	}
+
+	if pos, err := reader.Seek(-4, io.SeekCurrent); err != nil || pos != 2*BlobChunkSize+57 {
+		t.Errorf("Seek relative = %d, %v", pos, err)
+	}
+	if _, err := reader.Seek(int64(len(data)), io.SeekStart); err != nil {
+		t.Fatalf("Seek to end failed: %v", err)
+	}
+	if n, err := reader.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Expected EOF at end, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,88 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Operations recorded in the change feed
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// changeActionPrefix prefixes the audit log action of document changes, so
+// creates are logged as document_create
+const changeActionPrefix = "document_"
+
+// Change is one entry of the document change feed. Seq increases with every
+// write and is the cursor for fetching the changes after it.
+type Change struct {
+	Seq       int64  `json:"seq"`
+	ID        string `json:"id"`
+	Op        string `json:"op"`
+	Version   int    `json:"version"`
+	Actor     string `json:"actor"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// recordChange logs a write to document id in _wce_audit_log, with the
+// version the document row has at the time of the call: after the write for
+// creates and updates, before it for deletes. An empty userID attributes the
+// change to the document's last modifier, for writes no user asked for such
+// as the expiry sweeper's.
+func recordChange(db execer, op, id, userID string) error {
+	result, err := db.Exec(`
+		INSERT INTO _wce_audit_log (timestamp, user_id, username, action, resource_type, resource_id, details)
+		SELECT ?, actor, COALESCE((SELECT username FROM _wce_users WHERE user_id = actor), ''), ?, 'document', id,
+		       json_object('version', version)
+		FROM (SELECT COALESCE(NULLIF(?, ''), modified_by) AS actor, id, version FROM _wce_documents WHERE id = ?)
+	`, clock.Now().Unix(), changeActionPrefix+op, userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to record document change: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("document not found: %s", id)
+	}
+	return nil
+}
+
+// ListChanges returns up to limit document changes after sequence number
+// since, oldest first. Deleted documents stay in the feed, so a client that
+// applies every change in order ends up with the current set of documents.
+func ListChanges(db *sql.DB, since int64, limit int) ([]Change, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := db.Query(`
+		SELECT id, resource_id, substr(action, ?), COALESCE(json_extract(details, '$.version'), 0), user_id, timestamp
+		FROM _wce_audit_log
+		WHERE id > ? AND action IN (?, ?, ?)
+		ORDER BY id
+		LIMIT ?
+	`, len(changeActionPrefix)+1, since,
+		changeActionPrefix+ChangeCreate, changeActionPrefix+ChangeUpdate, changeActionPrefix+ChangeDelete, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		var change Change
+		if err := rows.Scan(&change.Seq, &change.ID, &change.Op, &change.Version, &change.Actor, &change.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan document change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating document changes: %w", err)
+	}
+
+	return changes, nil
+}
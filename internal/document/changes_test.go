@@ -0,0 +1,89 @@
+package document
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListChanges(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := CreateDocument(db, "pages/home", "v1", "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := UpdateDocument(db, "pages/home", "v2", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if _, err := MoveDocument(db, "pages/home", "pages/index", "user-1"); err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+	if err := DeleteDocument(db, "pages/index", "user-1"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+
+	changes, err := ListChanges(db, 0, 0)
+	if err != nil {
+		t.Fatalf("ListChanges failed: %v", err)
+	}
+	want := []Change{
+		{ID: "pages/home", Op: ChangeCreate, Version: 1},
+		{ID: "pages/home", Op: ChangeUpdate, Version: 2},
+		{ID: "pages/home", Op: ChangeDelete, Version: 2},
+		{ID: "pages/index", Op: ChangeCreate, Version: 2},
+		{ID: "pages/index", Op: ChangeDelete, Version: 2},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %+v", len(want), changes)
+	}
+	for i, change := range changes {
+		if change.ID != want[i].ID || change.Op != want[i].Op || change.Version != want[i].Version {
+			t.Errorf("Change %d = %+v, want %+v", i, change, want[i])
+		}
+		if change.Actor != "user-1" || change.Timestamp < time.Now().Unix()-60 {
+			t.Errorf("Unexpected actor or timestamp: %+v", change)
+		}
+		if i > 0 && change.Seq <= changes[i-1].Seq {
+			t.Errorf("Expected increasing sequence numbers, got %+v", changes)
+		}
+	}
+
+	// The feed resumes after a sequence number
+	rest, err := ListChanges(db, changes[2].Seq, 1)
+	if err != nil || len(rest) != 1 || rest[0] != changes[3] {
+		t.Errorf("Expected the change after %d, got %+v, %v", changes[2].Seq, rest, err)
+	}
+
+	// Failed writes leave no change behind
+	if err := DeleteDocument(db, "pages/missing", "user-1"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found, got %v", err)
+	}
+	if after, _ := ListChanges(db, changes[4].Seq, 0); len(after) != 0 {
+		t.Errorf("Expected no new changes, got %+v", after)
+	}
+}
+
+func TestListChanges_Sweeper(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := CreateDocument(db, "tmp/note", "bye", "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := SetDocumentExpiry(db, "tmp/note", 1, "user-1"); err != nil {
+		t.Fatalf("SetDocumentExpiry failed: %v", err)
+	}
+	if _, err := SweepExpiredDocuments(db, time.Now().Unix(), false); err != nil {
+		t.Fatalf("SweepExpiredDocuments failed: %v", err)
+	}
+
+	// Removals nobody asked for are attributed to the last modifier
+	changes, err := ListChanges(db, 0, 0)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v, %v", changes, err)
+	}
+	if changes[1].Op != ChangeDelete || changes[1].Actor != "user-1" {
+		t.Errorf("Unexpected sweeper change: %+v", changes[1])
+	}
+}
@@ -0,0 +1,194 @@
+package document
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// validItemID matches the ids of typed collection items, one path segment
+// below the collection's prefix
+var validItemID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// CollectionItem is an item of a typed collection: the JSON document
+// Collection.Prefix + ID
+type CollectionItem struct {
+	ID         string          `json:"id"`
+	Data       json.RawMessage `json:"data"`
+	Version    int             `json:"version"`
+	CreatedAt  int64           `json:"created_at"`
+	ModifiedAt int64           `json:"modified_at"`
+	CreatedBy  string          `json:"created_by"`
+	ModifiedBy string          `json:"modified_by"`
+}
+
+// IsValidItemID checks if a collection item id is allowed
+func IsValidItemID(id string) bool {
+	return validItemID.MatchString(id)
+}
+
+// typedCollection returns the named collection, which must have a schema
+func typedCollection(db *sql.DB, name string) (*Collection, error) {
+	c, err := GetCollection(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if c.SchemaID == "" {
+		return nil, fmt.Errorf("collection %s has no schema", name)
+	}
+	return c, nil
+}
+
+// itemFromDocument returns doc as an item of c, and false when doc is not
+// one: items are JSON documents directly under the collection's prefix
+func itemFromDocument(c *Collection, doc *Document) (*CollectionItem, bool) {
+	id, ok := strings.CutPrefix(doc.ID, c.Prefix)
+	if !ok || !IsValidItemID(id) || doc.IsBinary || !isJSONContentType(doc.ContentType) {
+		return nil, false
+	}
+	return &CollectionItem{
+		ID:         id,
+		Data:       json.RawMessage(doc.Content),
+		Version:    doc.Version,
+		CreatedAt:  doc.CreatedAt,
+		ModifiedAt: doc.ModifiedAt,
+		CreatedBy:  doc.CreatedBy,
+		ModifiedBy: doc.ModifiedBy,
+	}, true
+}
+
+// newItemID generates a random item id
+func newItemID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := clock.ReadID(b); err != nil {
+		return "", fmt.Errorf("failed to generate item id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateCollectionItem stores data as a new item of the typed collection
+// name, validated against its schema. An empty id generates one. Invalid
+// data is rejected with a *SchemaError, as for documents.
+func CreateCollectionItem(db *sql.DB, name, id string, data []byte, userID string) (*CollectionItem, error) {
+	c, err := typedCollection(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if id, err = newItemID(); err != nil {
+			return nil, err
+		}
+	}
+	if !IsValidItemID(id) {
+		return nil, fmt.Errorf("invalid item id: must be letters, digits, '_', '.' and '-'")
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("item data must be valid JSON")
+	}
+
+	doc, err := CreateDocumentWithSchema(db, c.Prefix+id, string(data), "application/json", userID, c.SchemaID, false, true)
+	if err != nil {
+		return nil, err
+	}
+	item, _ := itemFromDocument(c, doc)
+	return item, nil
+}
+
+// GetCollectionItem returns an item of the typed collection name
+func GetCollectionItem(db *sql.DB, name, id string) (*CollectionItem, error) {
+	c, err := typedCollection(db, name)
+	if err != nil {
+		return nil, err
+	}
+	return getItem(db, c, id)
+}
+
+// getItem reads item id of c
+func getItem(db *sql.DB, c *Collection, id string) (*CollectionItem, error) {
+	if !IsValidItemID(id) {
+		return nil, fmt.Errorf("item not found: %s", id)
+	}
+	doc, err := GetDocument(db, c.Prefix+id)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, fmt.Errorf("item not found: %s", id)
+		}
+		return nil, err
+	}
+	item, ok := itemFromDocument(c, doc)
+	if !ok {
+		return nil, fmt.Errorf("item not found: %s", id)
+	}
+	return item, nil
+}
+
+// ListCollectionItems lists the items of the typed collection name by id,
+// returning the page after cursor ("" for the first) and the cursor of the
+// next page as ListDocumentsAfter does. A limit of 0 uses the collection's
+// page size.
+func ListCollectionItems(db *sql.DB, name, cursor string, limit int) ([]CollectionItem, string, error) {
+	c, err := typedCollection(db, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 {
+		limit = c.PageSize
+	}
+
+	docs, next, err := ListDocumentsAfter(db, c.Prefix, nil, true, cursor, min(limit, maxCollectionPageSize))
+	if err != nil {
+		return nil, "", err
+	}
+	items := []CollectionItem{}
+	for i := range docs {
+		// Documents nested deeper than the prefix are not items
+		if item, ok := itemFromDocument(c, &docs[i]); ok {
+			items = append(items, *item)
+		}
+	}
+	return items, next, nil
+}
+
+// UpdateCollectionItem replaces the data of an item of the typed collection
+// name, validated against the collection's schema
+func UpdateCollectionItem(db *sql.DB, name, id string, data []byte, userID string) (*CollectionItem, error) {
+	c, err := typedCollection(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := getItem(db, c, id); err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("item data must be valid JSON")
+	}
+	// Documents placed under the prefix directly may carry another schema or
+	// none, so the collection's schema is checked here as well
+	if err := validateAgainstSchema(db, c.SchemaID, string(data)); err != nil {
+		return nil, err
+	}
+
+	doc, err := UpdateDocument(db, c.Prefix+id, string(data), userID)
+	if err != nil {
+		return nil, err
+	}
+	item, _ := itemFromDocument(c, doc)
+	return item, nil
+}
+
+// DeleteCollectionItem deletes an item of the typed collection name
+func DeleteCollectionItem(db *sql.DB, name, id, userID string) error {
+	c, err := typedCollection(db, name)
+	if err != nil {
+		return err
+	}
+	if _, err := getItem(db, c, id); err != nil {
+		return err
+	}
+	return DeleteDocument(db, c.Prefix+id, userID)
+}
@@ -0,0 +1,224 @@
+package document
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// validCollectionName matches collection names as they appear in the URL
+var validCollectionName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// maxCollectionPageSize bounds the page size a collection may be saved with
+const maxCollectionPageSize = 100
+
+// Collection is a saved search: a named set of Query criteria that listing
+// pages run by name instead of hardcoding them. A collection with a schema is
+// typed: its items are the JSON documents under its prefix, created and
+// updated through the collection and validated against the schema.
+type Collection struct {
+	Name       string   `json:"name"`
+	Query      string   `json:"query"`               // Full-text search in BuildSearchQuery syntax
+	Tags       []string `json:"tags"`                // Documents must carry every tag
+	Prefix     string   `json:"prefix"`              // Document id prefix
+	Sort       string   `json:"sort"`                // QueryOptions.Sort order
+	PageSize   int      `json:"page_size"`           // Results per page when no limit is given
+	SchemaID   string   `json:"schema_id,omitempty"` // Document holding the JSON Schema of items
+	ModifiedAt int64    `json:"modified_at"`
+	ModifiedBy string   `json:"modified_by,omitempty"`
+}
+
+// Options returns the query that runs the collection, for the page after
+// cursor ("" for the first). A limit of 0 uses the collection's page size.
+func (c *Collection) Options(cursor string, limit int) QueryOptions {
+	if limit <= 0 {
+		limit = c.PageSize
+	}
+	return QueryOptions{
+		Text:   c.Query,
+		Tags:   c.Tags,
+		Prefix: c.Prefix,
+		Sort:   c.Sort,
+		Limit:  min(limit, maxCollectionPageSize),
+		Cursor: cursor,
+	}
+}
+
+// IsValidCollectionName checks if a collection name is allowed
+func IsValidCollectionName(name string) bool {
+	return validCollectionName.MatchString(name)
+}
+
+// SaveCollection creates or replaces the collection named c.Name. At least
+// one of the query, tags and prefix must be set, so a collection never
+// lists the whole store by accident. A typed collection's prefix defaults to
+// its name followed by '/' and must end in '/'.
+func SaveCollection(db *sql.DB, c Collection, userID string) (*Collection, error) {
+	if !IsValidCollectionName(c.Name) {
+		return nil, fmt.Errorf("invalid collection name: must be lowercase letters, digits, '_' and '-'")
+	}
+	if c.SchemaID != "" {
+		if c.Prefix == "" {
+			c.Prefix = c.Name + "/"
+		}
+		if !strings.HasSuffix(c.Prefix, "/") {
+			return nil, fmt.Errorf("the prefix of a typed collection must end in '/'")
+		}
+		if _, err := loadSchema(db, c.SchemaID); err != nil {
+			return nil, err
+		}
+	}
+	c.Tags = normalizeTags(c.Tags)
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	if c.Query == "" && len(c.Tags) == 0 && c.Prefix == "" {
+		return nil, fmt.Errorf("collection requires a query, tags or a prefix")
+	}
+	if c.Query != "" {
+		if _, err := BuildSearchQuery(c.Query); err != nil {
+			return nil, fmt.Errorf("invalid search query: %w", err)
+		}
+	}
+	if err := ValidateSort(c.Sort, c.Query != ""); err != nil {
+		return nil, err
+	}
+	if c.PageSize == 0 {
+		c.PageSize = 20
+	}
+	if c.PageSize < 0 || c.PageSize > maxCollectionPageSize {
+		return nil, fmt.Errorf("page_size must be between 1 and %d", maxCollectionPageSize)
+	}
+
+	tags, _ := json.Marshal(c.Tags)
+	c.ModifiedAt = clock.Now().Unix()
+	c.ModifiedBy = userID
+	_, err := db.Exec(`
+		INSERT INTO _wce_collections (name, query, tags, prefix, sort, page_size, schema_id, modified_at, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''))
+		ON CONFLICT(name) DO UPDATE SET
+			query = excluded.query, tags = excluded.tags, prefix = excluded.prefix, sort = excluded.sort,
+			page_size = excluded.page_size, schema_id = excluded.schema_id,
+			modified_at = excluded.modified_at, modified_by = excluded.modified_by
+	`, c.Name, c.Query, string(tags), c.Prefix, c.Sort, c.PageSize, c.SchemaID, c.ModifiedAt, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save collection: %w", err)
+	}
+	return &c, nil
+}
+
+// GetCollection returns the collection with the given name
+func GetCollection(db *sql.DB, name string) (*Collection, error) {
+	row := db.QueryRow(`
+		SELECT name, query, tags, prefix, sort, page_size, COALESCE(schema_id, ''), modified_at, COALESCE(modified_by, '')
+		FROM _wce_collections WHERE name = ?
+	`, name)
+	c, err := scanCollection(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("collection not found: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	return c, nil
+}
+
+// ListCollections lists every collection by name
+func ListCollections(db *sql.DB) ([]Collection, error) {
+	rows, err := db.Query(`
+		SELECT name, query, tags, prefix, sort, page_size, COALESCE(schema_id, ''), modified_at, COALESCE(modified_by, '')
+		FROM _wce_collections ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collections: %w", err)
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan collection: %w", err)
+		}
+		collections = append(collections, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating collections: %w", err)
+	}
+	return collections, nil
+}
+
+// DeleteCollection removes a collection definition; its documents are not
+// affected
+func DeleteCollection(db *sql.DB, name string) error {
+	result, err := db.Exec("DELETE FROM _wce_collections WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("collection not found: %s", name)
+	}
+	return nil
+}
+
+// RunCollection runs the named collection, returning the page after cursor
+// and the cursor of the next page as QueryPage does. A limit of 0 uses the
+// collection's page size.
+func RunCollection(db *sql.DB, name, cursor string, limit int) (*Collection, []SearchResult, string, error) {
+	c, err := GetCollection(db, name)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	results, next, err := QueryPage(db, c.Options(cursor, limit))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	return c, results, next, nil
+}
+
+// Map returns a result as plain values for templates and scripts, with its
+// metadata and front matter decoded
+func (r SearchResult) Map() map[string]interface{} {
+	values := map[string]interface{}{
+		"id":           r.ID,
+		"content":      r.Content,
+		"content_type": r.ContentType,
+		"is_binary":    r.IsBinary,
+		"created_at":   r.CreatedAt,
+		"modified_at":  r.ModifiedAt,
+		"created_by":   r.CreatedBy,
+		"modified_by":  r.ModifiedBy,
+		"version":      r.Version,
+		"rank":         r.Rank,
+		"pinned":       r.Pinned,
+	}
+	if r.SortOrder != nil {
+		values["sort_order"] = *r.SortOrder
+	}
+	for key, raw := range map[string]json.RawMessage{"metadata": r.Metadata, "front_matter": r.FrontMatter} {
+		var decoded interface{}
+		if len(raw) > 0 && json.Unmarshal(raw, &decoded) == nil {
+			values[key] = decoded
+		}
+	}
+	return values
+}
+
+func scanCollection(row interface{ Scan(...interface{}) error }) (*Collection, error) {
+	var c Collection
+	var tags string
+	if err := row.Scan(&c.Name, &c.Query, &tags, &c.Prefix, &c.Sort, &c.PageSize, &c.SchemaID, &c.ModifiedAt, &c.ModifiedBy); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &c.Tags); err != nil || c.Tags == nil {
+		c.Tags = []string{}
+	}
+	return &c, nil
+}
@@ -0,0 +1,183 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestCollections(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for i, id := range []string{"posts/a", "posts/b", "posts/c", "notes/d"} {
+		if _, err := CreateDocument(db, id, "a post about golang", "text/plain", "user-1", false, true); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+		// Created in reverse id order
+		if _, err := db.Exec("UPDATE _wce_documents SET created_at = ? WHERE id = ?", 1000-i, id); err != nil {
+			t.Fatalf("Failed to set created_at: %v", err)
+		}
+	}
+	AddDocumentTag(db, "posts/a", "published")
+	AddDocumentTag(db, "posts/c", "published")
+	AddDocumentTag(db, "notes/d", "published")
+
+	invalid := []Collection{
+		{Name: "Bad Name", Prefix: "posts/"},
+		{Name: "everything"},
+		{Name: "bad-query", Query: "NOT golang"},
+		{Name: "bad-sort", Prefix: "posts/", Sort: "title"},
+		{Name: "rank-without-query", Prefix: "posts/", Sort: "rank"},
+		{Name: "huge", Prefix: "posts/", PageSize: 1000},
+	}
+	for _, c := range invalid {
+		if _, err := SaveCollection(db, c, "user-1"); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+
+	saved, err := SaveCollection(db, Collection{Name: "recent-posts", Prefix: "posts/", Tags: []string{"Published"}, Sort: "-created_at", PageSize: 1}, "user-1")
+	if err != nil {
+		t.Fatalf("SaveCollection failed: %v", err)
+	}
+	if saved.Tags[0] != "published" || saved.ModifiedBy != "user-1" {
+		t.Errorf("Unexpected saved collection: %+v", saved)
+	}
+
+	// Pages follow the saved order and page size
+	var ids []string
+	cursor := ""
+	for {
+		_, results, next, err := RunCollection(db, "recent-posts", cursor, 0)
+		if err != nil {
+			t.Fatalf("RunCollection failed: %v", err)
+		}
+		if len(results) > 1 {
+			t.Fatalf("Expected pages of 1, got %d", len(results))
+		}
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(ids) != 2 || ids[0] != "posts/a" || ids[1] != "posts/c" {
+		t.Errorf("Expected [posts/a posts/c], got %v", ids)
+	}
+
+	// Saving again replaces the definition
+	if _, err := SaveCollection(db, Collection{Name: "recent-posts", Query: "golang", Prefix: "notes/"}, "user-1"); err != nil {
+		t.Fatalf("SaveCollection failed: %v", err)
+	}
+	c, results, _, err := RunCollection(db, "recent-posts", "", 10)
+	if err != nil {
+		t.Fatalf("RunCollection failed: %v", err)
+	}
+	if c.PageSize != 20 || len(results) != 1 || results[0].ID != "notes/d" {
+		t.Errorf("Unexpected results of replaced collection: %+v %d", c, len(results))
+	}
+	if results[0].Map()["id"] != "notes/d" {
+		t.Errorf("Unexpected result map: %v", results[0].Map())
+	}
+
+	collections, err := ListCollections(db)
+	if err != nil || len(collections) != 1 || collections[0].Name != "recent-posts" {
+		t.Fatalf("Unexpected collections: %+v %v", collections, err)
+	}
+
+	if err := DeleteCollection(db, "recent-posts"); err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	if err := DeleteCollection(db, "recent-posts"); err == nil {
+		t.Error("Expected deleting a missing collection to fail")
+	}
+	if _, _, _, err := RunCollection(db, "recent-posts", "", 0); err == nil {
+		t.Error("Expected running a deleted collection to fail")
+	}
+}
+
+func TestCollectionItems(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	schema := `{"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}, "done": {"type": "boolean"}}}`
+	if _, err := CreateDocument(db, "schemas/task", schema, "application/json", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	if _, err := SaveCollection(db, Collection{Name: "tasks", SchemaID: "schemas/missing"}, "user-1"); err == nil {
+		t.Error("Expected a missing schema to be rejected")
+	}
+	if _, err := SaveCollection(db, Collection{Name: "tasks", SchemaID: "schemas/task", Prefix: "tasks"}, "user-1"); err == nil {
+		t.Error("Expected a prefix without a trailing '/' to be rejected")
+	}
+	c, err := SaveCollection(db, Collection{Name: "tasks", SchemaID: "schemas/task"}, "user-1")
+	if err != nil {
+		t.Fatalf("SaveCollection failed: %v", err)
+	}
+	if c.Prefix != "tasks/" {
+		t.Errorf("Expected the prefix to default to tasks/, got %q", c.Prefix)
+	}
+
+	if _, err := CreateCollectionItem(db, "tasks", "a", []byte(`{"done": true}`), "user-1"); err == nil {
+		t.Error("Expected an item missing a required property to be rejected")
+	}
+	if _, err := CreateCollectionItem(db, "tasks", "a/b", []byte(`{"title": "x"}`), "user-1"); err == nil {
+		t.Error("Expected an item id with a '/' to be rejected")
+	}
+	item, err := CreateCollectionItem(db, "tasks", "a", []byte(`{"title": "write docs"}`), "user-1")
+	if err != nil {
+		t.Fatalf("CreateCollectionItem failed: %v", err)
+	}
+	if item.ID != "a" || item.Version != 1 {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	generated, err := CreateCollectionItem(db, "tasks", "", []byte(`{"title": "review"}`), "user-1")
+	if err != nil || !IsValidItemID(generated.ID) {
+		t.Fatalf("Expected a generated id, got %+v (%v)", generated, err)
+	}
+	doc, err := GetDocument(db, "tasks/a")
+	if err != nil || doc.SchemaID != "schemas/task" {
+		t.Errorf("Expected the item stored as a document with the schema, got %+v (%v)", doc, err)
+	}
+
+	// Documents nested under the prefix are not items
+	if _, err := CreateDocument(db, "tasks/archive/old", `{"title": "old"}`, "application/json", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	items, next, err := ListCollectionItems(db, "tasks", "", 0)
+	if err != nil || len(items) != 2 || next != "" {
+		t.Errorf("Expected 2 items, got %+v %q (%v)", items, next, err)
+	}
+
+	if _, err := UpdateCollectionItem(db, "tasks", "a", []byte(`{"title": 5}`), "user-1"); err == nil {
+		t.Error("Expected an invalid update to be rejected")
+	}
+	item, err = UpdateCollectionItem(db, "tasks", "a", []byte(`{"title": "write docs", "done": true}`), "user-1")
+	if err != nil || item.Version != 2 {
+		t.Errorf("Unexpected update: %+v (%v)", item, err)
+	}
+	if _, err := GetCollectionItem(db, "tasks", "archive"); err == nil {
+		t.Error("Expected a missing item to be reported")
+	}
+
+	// A schema in use by a collection stays
+	if err := DeleteDocument(db, "schemas/task", "user-1"); err == nil {
+		t.Error("Expected deleting the collection's schema to fail")
+	}
+
+	if err := DeleteCollectionItem(db, "tasks", "a", "user-1"); err != nil {
+		t.Fatalf("DeleteCollectionItem failed: %v", err)
+	}
+	if _, err := GetCollectionItem(db, "tasks", "a"); err == nil {
+		t.Error("Expected the deleted item to be gone")
+	}
+
+	if _, err := SaveCollection(db, Collection{Name: "plain", Prefix: "tasks/"}, "user-1"); err != nil {
+		t.Fatalf("SaveCollection failed: %v", err)
+	}
+	if _, _, err := ListCollectionItems(db, "plain", "", 0); err == nil {
+		t.Error("Expected a collection without a schema to have no items")
+	}
+}
@@ -0,0 +1,111 @@
+package document
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/thetanil/wce/internal/config"
+)
+
+// CompressionGzip is the compression column value of gzip-compressed content
+const CompressionGzip = "gzip"
+
+// Config keys controlling transparent compression of text documents
+const (
+	CompressionConfigKey          = "document_compression"              // 'off' or 'gzip'
+	CompressionThresholdConfigKey = "document_compression_threshold_kb" // Minimum content size to compress
+)
+
+// defaultCompressionThresholdKB applies when the threshold is unset or invalid
+const defaultCompressionThresholdKB = 64
+
+// IsValidCompression reports whether name can be set as document_compression
+func IsValidCompression(name string) bool {
+	return name == "off" || name == CompressionGzip
+}
+
+// encodeContent returns the value to store in the content column and the
+// compression it was stored with. Text content at or above the configured
+// threshold is compressed; binary content is already base64 and is kept as is.
+// A nil compression means the content is stored verbatim.
+func encodeContent(db DB, content string, isBinary bool) (interface{}, interface{}, error) {
+	if isBinary || config.GetString(db, CompressionConfigKey, "off") != CompressionGzip {
+		return content, nil, nil
+	}
+
+	thresholdKB := config.GetInt(db, CompressionThresholdConfigKey, defaultCompressionThresholdKB)
+	if thresholdKB < 0 {
+		thresholdKB = defaultCompressionThresholdKB
+	}
+	if int64(len(content)) < thresholdKB*1024 {
+		return content, nil, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		return nil, nil, fmt.Errorf("failed to compress document content: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to compress document content: %w", err)
+	}
+
+	// Content that doesn't shrink is not worth decompressing on every read
+	if buf.Len() >= len(content) {
+		return content, nil, nil
+	}
+	return buf.Bytes(), CompressionGzip, nil
+}
+
+// DecodeContent returns the original content of a content column value
+// stored with compression, which is empty for uncompressed content
+func DecodeContent(stored, compression string) (string, error) {
+	switch compression {
+	case "":
+		return stored, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(strings.NewReader(stored))
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress document content: %w", err)
+		}
+		defer zr.Close()
+
+		content, err := io.ReadAll(zr)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress document content: %w", err)
+		}
+		return string(content), nil
+	default:
+		return "", fmt.Errorf("unsupported document compression: %s", compression)
+	}
+}
+
+// indexCompressed writes the original content of a compressed document into
+// the search index. The triggers index compressed documents as empty, as
+// SQL cannot decompress them.
+func indexCompressed(tx DB, id string) error {
+	var stored, compression string
+	var searchable int
+	err := tx.QueryRow(`
+		SELECT content, COALESCE(compression, ''), searchable FROM _wce_documents WHERE id = ?
+	`, id).Scan(&stored, &compression, &searchable)
+	if err != nil {
+		return fmt.Errorf("failed to index document: %w", err)
+	}
+	if compression == "" || searchable != 1 {
+		return nil
+	}
+
+	content, err := DecodeContent(stored, compression)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE _wce_document_search SET content = ? WHERE document_id = ?`, content, id); err != nil {
+		return fmt.Errorf("failed to index document: %w", err)
+	}
+	return nil
+}
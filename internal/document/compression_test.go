@@ -0,0 +1,110 @@
+package document
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/config"
+)
+
+func TestDocumentCompression(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	config.Set(db, CompressionConfigKey, CompressionGzip, "")
+	config.Set(db, CompressionThresholdConfigKey, "1", "")
+
+	large := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 100)
+	if _, err := CreateDocument(db, "notes/large", large, "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := CreateDocument(db, "notes/small", "tiny note", "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	var compression string
+	var storedLen int
+	db.QueryRow(`SELECT COALESCE(compression, ''), length(CAST(content AS BLOB)) FROM _wce_documents WHERE id = ?`,
+		"notes/large").Scan(&compression, &storedLen)
+	if compression != CompressionGzip || storedLen >= len(large) {
+		t.Errorf("Expected large document stored gzip-compressed, got %q with %d bytes", compression, storedLen)
+	}
+	db.QueryRow(`SELECT COALESCE(compression, '') FROM _wce_documents WHERE id = ?`, "notes/small").Scan(&compression)
+	if compression != "" {
+		t.Errorf("Expected document below the threshold stored verbatim, got %q", compression)
+	}
+
+	doc, err := GetDocument(db, "notes/large")
+	if err != nil || doc.Content != large {
+		t.Fatalf("Expected GetDocument to decompress content, got error %v", err)
+	}
+
+	docs, err := ListDocuments(db, "notes/", 10, 0)
+	if err != nil || len(docs) != 2 || docs[0].Content != large {
+		t.Fatalf("Expected ListDocuments to decompress content, got %d documents: %v", len(docs), err)
+	}
+
+	results, err := SearchDocuments(db, "lazy", 10)
+	if err != nil || len(results) != 1 || results[0].ID != "notes/large" || results[0].Content != large {
+		t.Fatalf("Expected compressed document to be searchable, got %+v: %v", results, err)
+	}
+
+	// Metadata updates leave the index alone; content updates re-index it
+	if _, err := SetDocumentExpiry(db, "notes/large", 5000, "user-1"); err != nil {
+		t.Fatalf("SetDocumentExpiry failed: %v", err)
+	}
+	if results, _ := SearchDocuments(db, "lazy", 10); len(results) != 1 {
+		t.Errorf("Expected index to survive a metadata update, got %d results", len(results))
+	}
+
+	updated := strings.Repeat("a slow green turtle crawls past the sleeping cat\n", 100)
+	if _, err := UpdateDocument(db, "notes/large", updated, "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if results, _ := SearchDocuments(db, "turtle", 10); len(results) != 1 {
+		t.Errorf("Expected updated content to be indexed, got %d results", len(results))
+	}
+	if results, _ := SearchDocuments(db, "lazy", 10); len(results) != 0 {
+		t.Errorf("Expected replaced content to leave the index, got %d results", len(results))
+	}
+
+	v, err := GetDocumentVersion(db, "notes/large", 1)
+	if err != nil || v.Content != large {
+		t.Fatalf("Expected archived version to decompress, got error %v", err)
+	}
+
+	if _, err := MoveDocument(db, "notes/large", "notes/moved", "user-1"); err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+	if results, _ := SearchDocuments(db, "turtle", 10); len(results) != 1 || results[0].ID != "notes/moved" {
+		t.Errorf("Expected moved document to stay searchable, got %+v", results)
+	}
+}
+
+func TestDocumentCompressionDisabled(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	config.Set(db, CompressionThresholdConfigKey, "0", "")
+
+	large := strings.Repeat("x", 4096)
+	CreateDocument(db, "notes/large", large, "text/plain", "user-1", false, true)
+
+	var compression string
+	db.QueryRow(`SELECT COALESCE(compression, '') FROM _wce_documents WHERE id = ?`, "notes/large").Scan(&compression)
+	if compression != "" {
+		t.Errorf("Expected no compression while document_compression is off, got %q", compression)
+	}
+}
+
+func TestDecodeContent(t *testing.T) {
+	if content, err := DecodeContent("plain", ""); err != nil || content != "plain" {
+		t.Errorf("Expected uncompressed content unchanged, got %q: %v", content, err)
+	}
+	if _, err := DecodeContent("data", "zstd"); err == nil {
+		t.Error("Expected unsupported compression to fail")
+	}
+	if _, err := DecodeContent("not gzip", CompressionGzip); err == nil {
+		t.Error("Expected corrupt content to fail")
+	}
+}
@@ -0,0 +1,85 @@
+package document
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// pageCursor is the position after the last document of a page. Listings
+// are ordered by id or position, search results by rank, a timestamp or
+// position and then id, so the next page starts strictly after that key
+// however rows before it change.
+type pageCursor struct {
+	ID       string    `json:"id"`
+	Rank     *float64  `json:"rank,omitempty"`     // Set for ranked search results
+	Time     *int64    `json:"time,omitempty"`     // Set for results sorted by created_at or modified_at
+	Position *[2]int64 `json:"position,omitempty"` // Set in position order, as the values of positionKey
+}
+
+// encodeCursor returns the opaque cursor for the page after key
+func encodeCursor(key pageCursor) string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned with a previous page. ranked
+// reports whether the listing being resumed is ordered by search rank.
+func decodeCursor(cursor string, ranked bool) (pageCursor, error) {
+	var key pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &key) != nil || key.ID == "" || (key.Rank != nil) != ranked {
+		return pageCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return key, nil
+}
+
+// nextDocumentsCursor returns the cursor following a full page of
+// documents listed by id, or in position order when positioned is set, or ""
+// when the page is the last
+func nextDocumentsCursor(docs []Document, limit int, positioned bool) string {
+	if len(docs) == 0 || len(docs) < limit {
+		return ""
+	}
+	last := docs[len(docs)-1]
+	key := pageCursor{ID: last.ID}
+	if positioned {
+		key.Position = last.position()
+	}
+	return encodeCursor(key)
+}
+
+// nextResultsCursor returns the cursor following a full page of query
+// results sorted by sortColumn ("" for the search rank, see parseSort), or
+// "" when the page is the last
+func nextResultsCursor(results []SearchResult, limit int, sortColumn string) string {
+	if len(results) == 0 || len(results) < limit {
+		return ""
+	}
+	last := results[len(results)-1]
+	key := pageCursor{ID: last.ID}
+	switch sortColumn {
+	case "":
+		key.Rank = &last.Rank
+	case "d.created_at":
+		key.Time = &last.CreatedAt
+	case "d.modified_at":
+		key.Time = &last.ModifiedAt
+	case positionKey:
+		key.Position = last.position()
+	}
+	return encodeCursor(key)
+}
+
+// position returns the values of positionKey for the document
+func (d *Document) position() *[2]int64 {
+	key := [2]int64{1, math.MaxInt64}
+	if d.Pinned {
+		key[0] = 0
+	}
+	if d.SortOrder != nil {
+		key[1] = *d.SortOrder
+	}
+	return &key
+}
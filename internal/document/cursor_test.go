@@ -0,0 +1,110 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestListDocumentsAfter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for i := 1; i <= 5; i++ {
+		CreateDocument(db, fmt.Sprintf("notes/%d", i), "note", "text/plain", "user-1", false, true)
+	}
+
+	page1, cursor, err := ListDocumentsAfter(db, "notes/", nil, true, "", 2)
+	if err != nil || len(page1) != 2 || cursor == "" {
+		t.Fatalf("Unexpected first page: %d documents, cursor %q: %v", len(page1), cursor, err)
+	}
+
+	// A document created before the cursor does not shift the next page
+	CreateDocument(db, "notes/0", "note", "text/plain", "user-1", false, true)
+
+	var ids []string
+	for _, doc := range page1 {
+		ids = append(ids, doc.ID)
+	}
+	for cursor != "" {
+		var page []Document
+		page, cursor, err = ListDocumentsAfter(db, "notes/", nil, true, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListDocumentsAfter failed: %v", err)
+		}
+		for _, doc := range page {
+			ids = append(ids, doc.ID)
+		}
+	}
+	if strings.Join(ids, ",") != "notes/1,notes/2,notes/3,notes/4,notes/5" {
+		t.Errorf("Expected every document exactly once, got %v", ids)
+	}
+
+	if _, _, err := ListDocumentsAfter(db, "", nil, true, "bogus", 2); err == nil || err.Error() != "invalid cursor" {
+		t.Errorf("Expected invalid cursor error, got: %v", err)
+	}
+}
+
+func TestListDocumentsByTagAfter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		CreateDocument(db, id, "content", "text/plain", "user-1", false, true)
+		AddDocumentTag(db, id, "golang")
+	}
+
+	page, cursor, err := ListDocumentsByTagAfter(db, "golang", "", 2)
+	if err != nil || len(page) != 2 || cursor == "" {
+		t.Fatalf("Unexpected first page: %d documents, cursor %q: %v", len(page), cursor, err)
+	}
+	page, cursor, err = ListDocumentsByTagAfter(db, "golang", cursor, 2)
+	if err != nil || len(page) != 1 || page[0].ID != "c" || cursor != "" {
+		t.Errorf("Unexpected last page: %+v, cursor %q: %v", page, cursor, err)
+	}
+}
+
+func TestSearchDocumentsAfter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Varying repetitions give distinct ranks; equal ones tie and fall back to id order
+	for i, content := range []string{"golang", "golang golang", "golang golang golang", "golang", "unrelated"} {
+		CreateDocument(db, fmt.Sprintf("doc%d", i), content, "text/plain", "user-1", false, true)
+	}
+
+	all, err := SearchDocuments(db, "golang", 10)
+	if err != nil || len(all) != 4 {
+		t.Fatalf("Expected 4 matches, got %d: %v", len(all), err)
+	}
+
+	var paged []string
+	cursor := ""
+	for {
+		var page []SearchResult
+		page, cursor, err = SearchDocumentsAfter(db, "golang", cursor, 1)
+		if err != nil {
+			t.Fatalf("SearchDocumentsAfter failed: %v", err)
+		}
+		for _, result := range page {
+			paged = append(paged, result.ID)
+		}
+		if cursor == "" {
+			break
+		}
+	}
+
+	var want []string
+	for _, result := range all {
+		want = append(want, result.ID)
+	}
+	if strings.Join(paged, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected pages to follow rank order %v, got %v", want, paged)
+	}
+
+	// A listing cursor cannot resume a ranked search
+	_, listCursor, _ := ListDocumentsAfter(db, "", nil, false, "", 1)
+	if _, _, err := SearchDocumentsAfter(db, "golang", listCursor, 1); err == nil {
+		t.Error("Expected a listing cursor to be rejected by search")
+	}
+}
@@ -3,24 +3,35 @@ package document
 import (
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
+	"unicode/utf8"
+
+	"github.com/thetanil/wce/internal/clock"
 )
 
 // Document represents a stored document
 type Document struct {
-	ID          string   `json:"id"`
-	Content     string   `json:"content"`
-	ContentType string   `json:"content_type"`
-	IsBinary    bool     `json:"is_binary"`
-	Searchable  bool     `json:"searchable"`
-	CreatedAt   int64    `json:"created_at"`
-	ModifiedAt  int64    `json:"modified_at"`
-	CreatedBy   string   `json:"created_by"`
-	ModifiedBy  string   `json:"modified_by"`
-	Version     int      `json:"version"`
-	Tags        []string `json:"tags,omitempty"`
+	ID          string          `json:"id"`
+	Content     string          `json:"content"`
+	ContentType string          `json:"content_type"`
+	IsBinary    bool            `json:"is_binary"`
+	Searchable  bool            `json:"searchable"`
+	CreatedAt   int64           `json:"created_at"`
+	ModifiedAt  int64           `json:"modified_at"`
+	CreatedBy   string          `json:"created_by"`
+	ModifiedBy  string          `json:"modified_by"`
+	Version     int             `json:"version"`
+	Tags        []string        `json:"tags,omitempty"`
+	Metadata    json.RawMessage `json:"metadata"`               // Arbitrary JSON object, "{}" when unset
+	FrontMatter json.RawMessage `json:"front_matter,omitempty"` // YAML front matter of markdown content, as JSON
+	SchemaID    string          `json:"schema_id,omitempty"`    // Document holding the JSON Schema content must match
+	ExpiresAt   int64           `json:"expires_at,omitempty"`   // Unix time after which the expiry sweeper removes it
+	Size        int64           `json:"size,omitempty"`         // Content length of streamed documents, see IsBlob
+	Pinned      bool            `json:"pinned,omitempty"`       // Listed before unpinned documents in position order
+	SortOrder   *int64          `json:"sort_order,omitempty"`   // Position within its prefix, see ReorderDocuments
+	Hash        string          `json:"-"`                      // Stored hex SHA-256 of the content, see ContentHash
 }
 
 // SearchResult represents a search result with ranking
@@ -29,11 +40,43 @@ type SearchResult struct {
 	Rank float64 `json:"rank"`
 }
 
+// reservedSegments are the sub-resources the HTTP API addresses as
+// {docID}/{segment}. A document whose id ends in one could not be read back.
+var reservedSegments = map[string]bool{
+	"versions": true, "diff": true, "links": true, "backlinks": true, "tags": true,
+	"move": true, "copy": true, "pin": true, "unpin": true,
+}
+
+// checkID validates the id of a new document. Only an id's last segment can
+// collide with a sub-resource, except "versions", which is followed by a
+// version number and so is reserved anywhere after the first segment.
+func checkID(id string) error {
+	if id == "" {
+		return fmt.Errorf("document id cannot be empty")
+	}
+	segments := strings.Split(id, "/")
+	for i, segment := range segments[1:] {
+		if segment == "versions" || (i == len(segments)-2 && reservedSegments[segment]) {
+			return fmt.Errorf("document id cannot contain the reserved path segment %q", segment)
+		}
+	}
+	return nil
+}
+
 // CreateDocument creates a new document in the database
-func CreateDocument(db *sql.DB, id, content, contentType, userID string, isBinary, searchable bool) (*Document, error) {
+func CreateDocument(db DB, id, content, contentType, userID string, isBinary, searchable bool) (*Document, error) {
+	return CreateDocumentWithSchema(db, id, content, contentType, userID, "", isBinary, searchable)
+}
+
+// CreateDocumentWithSchema creates a new document whose content must match
+// the JSON Schema stored as document schemaID. Only application/json
+// documents can have a schema; an empty schemaID creates an unvalidated
+// document. Invalid content is rejected with a *SchemaError, and content over
+// the document size limit or the storage quota with a *LimitError.
+func CreateDocumentWithSchema(db DB, id, content, contentType, userID, schemaID string, isBinary, searchable bool) (*Document, error) {
 	// Validate inputs
-	if id == "" {
-		return nil, fmt.Errorf("document id cannot be empty")
+	if err := checkID(id); err != nil {
+		return nil, err
 	}
 	if content == "" {
 		return nil, fmt.Errorf("document content cannot be empty")
@@ -66,21 +109,85 @@ func CreateDocument(db *sql.DB, id, content, contentType, userID string, isBinar
 		finalContent = content
 	}
 
-	now := time.Now().Unix()
+	limits := readLimits(db)
+	if err := limits.checkSize(finalContent, isBinary); err != nil {
+		return nil, err
+	}
+
+	var schemaParam interface{}
+	if schemaID != "" {
+		if err := checkSchemaAttachment(id, contentType, schemaID, isBinary); err != nil {
+			return nil, err
+		}
+		if err := validateAgainstSchema(db, schemaID, finalContent); err != nil {
+			return nil, err
+		}
+		schemaParam = schemaID
+	}
+
+	frontMatter, err := frontMatterJSON(finalContent, contentType, isBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, compression, err := encodeContent(db, finalContent, isBinary)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashContent(finalContent)
+
+	now := clock.Now().Unix()
+
+	tx, err := begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
 	// Insert document
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO _wce_documents (
 			id, content, content_type, is_binary, searchable,
-			created_at, modified_at, created_by, modified_by, version
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
-	`, id, finalContent, contentType, boolToInt(isBinary), boolToInt(searchable),
-		now, now, userID, userID)
+			created_at, modified_at, created_by, modified_by, version, schema_id, compression, content_hash
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?)
+	`, id, stored, contentType, boolToInt(isBinary), boolToInt(searchable),
+		now, now, userID, userID, schemaParam, compression, hash)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert document: %w", err)
 	}
 
+	if compression != nil {
+		if err := indexCompressed(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setLinks(tx, id, finalContent, isBinary); err != nil {
+		return nil, err
+	}
+	if err := setReferences(tx, id, finalContent, isBinary); err != nil {
+		return nil, err
+	}
+
+	if frontMatter != nil {
+		if err := setFrontMatter(tx, id, frontMatter); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := recordChange(tx, ChangeCreate, id, userID); err != nil {
+		return nil, err
+	}
+
+	if err := limits.checkQuota(tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return &Document{
 		ID:          id,
 		Content:     finalContent,
@@ -92,26 +199,33 @@ func CreateDocument(db *sql.DB, id, content, contentType, userID string, isBinar
 		CreatedBy:   userID,
 		ModifiedBy:  userID,
 		Version:     1,
+		Metadata:    json.RawMessage("{}"),
+		FrontMatter: frontMatter,
+		SchemaID:    schemaID,
+		Hash:        hash,
 	}, nil
 }
 
 // GetDocument retrieves a document by ID
-func GetDocument(db *sql.DB, id string) (*Document, error) {
+func GetDocument(db DB, id string) (*Document, error) {
 	if id == "" {
 		return nil, fmt.Errorf("document id cannot be empty")
 	}
 
 	var doc Document
 	var isBinaryInt, searchableInt int
+	var metadata, compression string
 
 	err := db.QueryRow(`
 		SELECT id, content, content_type, is_binary, searchable,
-		       created_at, modified_at, created_by, modified_by, version
+		       created_at, modified_at, created_by, modified_by, version, metadata, COALESCE(schema_id, ''), COALESCE(expires_at, 0), pinned, sort_order,
+		       COALESCE(compression, ''), COALESCE(content_hash, '')
 		FROM _wce_documents
 		WHERE id = ?
 	`, id).Scan(
 		&doc.ID, &doc.Content, &doc.ContentType, &isBinaryInt, &searchableInt,
-		&doc.CreatedAt, &doc.ModifiedAt, &doc.CreatedBy, &doc.ModifiedBy, &doc.Version,
+		&doc.CreatedAt, &doc.ModifiedAt, &doc.CreatedBy, &doc.ModifiedBy, &doc.Version, &metadata, &doc.SchemaID, &doc.ExpiresAt, &doc.Pinned, &doc.SortOrder,
+		&compression, &doc.Hash,
 	)
 
 	if err == sql.ErrNoRows {
@@ -121,8 +235,13 @@ func GetDocument(db *sql.DB, id string) (*Document, error) {
 		return nil, fmt.Errorf("failed to query document: %w", err)
 	}
 
+	if doc.Content, err = DecodeContent(doc.Content, compression); err != nil {
+		return nil, err
+	}
 	doc.IsBinary = isBinaryInt == 1
 	doc.Searchable = searchableInt == 1
+	doc.Metadata = json.RawMessage(metadata)
+	doc.splitMetadata()
 
 	// Load tags
 	tags, err := GetDocumentTags(db, id)
@@ -130,11 +249,18 @@ func GetDocument(db *sql.DB, id string) (*Document, error) {
 		doc.Tags = tags
 	}
 
+	if doc.IsBlob() {
+		if size, err := BlobSize(db, id); err == nil {
+			doc.Size = size
+		}
+	}
+
 	return &doc, nil
 }
 
-// UpdateDocument updates an existing document
-func UpdateDocument(db *sql.DB, id, content, userID string) (*Document, error) {
+// UpdateDocument updates an existing document. Like creates, updates are
+// refused with a *LimitError when over the size limit or storage quota.
+func UpdateDocument(db DB, id, content, userID string) (*Document, error) {
 	if id == "" {
 		return nil, fmt.Errorf("document id cannot be empty")
 	}
@@ -159,15 +285,72 @@ func UpdateDocument(db *sql.DB, id, content, userID string) (*Document, error) {
 		}
 	}
 
-	now := time.Now().Unix()
+	limits := readLimits(db)
+	if err := limits.checkSize(content, existing.IsBinary); err != nil {
+		return nil, err
+	}
+
+	if existing.SchemaID != "" {
+		if err := validateAgainstSchema(db, existing.SchemaID, content); err != nil {
+			return nil, err
+		}
+	}
+
+	// A schema other documents are validated against must stay a valid schema
+	users, err := schemaUsers(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if users > 0 {
+		if _, err := compileSchema([]byte(content)); err != nil {
+			return nil, fmt.Errorf("document %s is the schema of %d documents or collections: %w", id, users, err)
+		}
+	}
+
+	frontMatter, err := frontMatterJSON(content, existing.ContentType, existing.IsBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, compression, err := encodeContent(db, content, existing.IsBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashContent(content)
+	now := clock.Now().Unix()
 	newVersion := existing.Version + 1
 
-	// Update document
-	result, err := db.Exec(`
+	tx, err := begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Archive the revision being replaced; streamed content is not archived
+	// and is dropped in favour of the new inline content
+	if existing.IsBlob() {
+		_, err = tx.Exec("DELETE FROM _wce_document_blobs WHERE document_id = ?", id)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO _wce_document_versions (
+				document_id, version, content, content_type, is_binary, modified_at, modified_by, compression
+			)
+			SELECT id, version, content, content_type, is_binary, modified_at, modified_by, compression
+			FROM _wce_documents
+			WHERE id = ? AND version = ?
+		`, id, existing.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive document version: %w", err)
+	}
+
+	// Update document, guarding against a concurrent update of the same version
+	result, err := tx.Exec(`
 		UPDATE _wce_documents
-		SET content = ?, modified_at = ?, modified_by = ?, version = ?
-		WHERE id = ?
-	`, content, now, userID, newVersion, id)
+		SET content = ?, compression = ?, content_hash = ?, modified_at = ?, modified_by = ?, version = ?
+		WHERE id = ? AND version = ?
+	`, stored, compression, hash, now, userID, newVersion, id, existing.Version)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to update document: %w", err)
@@ -178,35 +361,90 @@ func UpdateDocument(db *sql.DB, id, content, userID string) (*Document, error) {
 		return nil, fmt.Errorf("failed to check rows affected: %w", err)
 	}
 	if rowsAffected == 0 {
-		return nil, fmt.Errorf("document not found: %s", id)
+		return nil, fmt.Errorf("document %s was modified concurrently", id)
+	}
+
+	if compression != nil {
+		if err := indexCompressed(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setLinks(tx, id, content, existing.IsBinary); err != nil {
+		return nil, err
+	}
+	if err := setReferences(tx, id, content, existing.IsBinary); err != nil {
+		return nil, err
+	}
+
+	if err := setFrontMatter(tx, id, frontMatter); err != nil {
+		return nil, err
+	}
+
+	if err := recordChange(tx, ChangeUpdate, id, userID); err != nil {
+		return nil, err
+	}
+
+	if err := limits.checkQuota(tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	// Return updated document
 	existing.Content = content
+	existing.Hash = hash
 	existing.ModifiedAt = now
 	existing.ModifiedBy = userID
 	existing.Version = newVersion
+	existing.FrontMatter = frontMatter
 
 	return existing, nil
 }
 
-// DeleteDocument removes a document from the database
-func DeleteDocument(db *sql.DB, id string) error {
+// DeleteDocument removes a document from the database. The deletion is
+// attributed to userID in the change feed.
+func DeleteDocument(db DB, id, userID string) error {
+	if userID == "" {
+		return fmt.Errorf("user id cannot be empty")
+	}
+	return deleteDocument(db, id, userID)
+}
+
+// deleteDocument removes a document, attributing the deletion to userID or,
+// when it is empty, to the document's last modifier
+func deleteDocument(db DB, id, userID string) error {
 	if id == "" {
 		return fmt.Errorf("document id cannot be empty")
 	}
 
-	result, err := db.Exec("DELETE FROM _wce_documents WHERE id = ?", id)
+	users, err := schemaUsers(db, id)
 	if err != nil {
-		return fmt.Errorf("failed to delete document: %w", err)
+		return err
+	}
+	if users > 0 {
+		return fmt.Errorf("document %s is in use as the schema of %d documents or collections", id, users)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	tx, err := begin(db)
 	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("document not found: %s", id)
+	defer tx.Rollback()
+
+	// Recorded first, while the version being deleted can still be read
+	if err := recordChange(tx, ChangeDelete, id, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM _wce_documents WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
@@ -214,6 +452,64 @@ func DeleteDocument(db *sql.DB, id string) error {
 
 // ListDocuments lists documents with optional prefix filter and pagination
 func ListDocuments(db *sql.DB, prefix string, limit, offset int) ([]Document, error) {
+	return ListDocumentsFiltered(db, prefix, nil, true, limit, offset)
+}
+
+// ListDocumentsFiltered lists documents under an optional prefix whose
+// metadata matches every filter, with pagination. Without includeContent
+// only metadata columns are read and Content is left empty, which keeps
+// listings of large documents cheap; IsBlob is meaningless on such results.
+func ListDocumentsFiltered(db *sql.DB, prefix string, filters []MetadataFilter, includeContent bool, limit, offset int) ([]Document, error) {
+	docs, _, err := listDocuments(db, prefix, filters, includeContent, false, "", limit, offset)
+	return docs, err
+}
+
+// ListDocumentsAfter is ListDocumentsFiltered with keyset pagination: it
+// returns the page following cursor ("" for the first page) and the cursor
+// of the next page, "" after the last. Unlike offsets, cursors neither skip
+// nor repeat documents when others are created or deleted between pages.
+func ListDocumentsAfter(db *sql.DB, prefix string, filters []MetadataFilter, includeContent bool, cursor string, limit int) ([]Document, string, error) {
+	return listDocuments(db, prefix, filters, includeContent, false, cursor, limit, 0)
+}
+
+// ListDocumentsByPosition is ListDocumentsAfter in position order: pinned
+// documents first, then by the order ReorderDocuments set, then the
+// unordered documents by id
+func ListDocumentsByPosition(db *sql.DB, prefix string, filters []MetadataFilter, includeContent bool, cursor string, limit int) ([]Document, string, error) {
+	return listDocuments(db, prefix, filters, includeContent, true, cursor, limit, 0)
+}
+
+// ListRecentDocuments lists the text documents under prefix, most recently
+// modified first, for sitemaps and feeds
+func ListRecentDocuments(db *sql.DB, prefix string, includeContent bool, limit int) ([]Document, error) {
+	contentColumn := "d.content"
+	compressionColumn := "COALESCE(d.compression, '')"
+	if !includeContent {
+		contentColumn = "''"
+		compressionColumn = "''"
+	}
+
+	rows, err := db.Query(`
+		SELECT d.id, `+contentColumn+`, d.content_type, d.is_binary, d.searchable,
+		       d.created_at, d.modified_at, d.created_by, d.modified_by, d.version, d.metadata, COALESCE(d.schema_id, ''), COALESCE(d.expires_at, 0), d.pinned, d.sort_order,
+		       `+compressionColumn+`
+		FROM _wce_documents d
+		WHERE d.id LIKE ? || '%' AND d.is_binary = 0
+		ORDER BY d.modified_at DESC, d.id
+		LIMIT ?
+	`, prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	return scanDocuments(rows)
+}
+
+// listDocuments lists documents ordered by id, or by position when
+// positioned is set, starting after cursor when one is given and skipping
+// offset documents
+func listDocuments(db *sql.DB, prefix string, filters []MetadataFilter, includeContent, positioned bool, cursor string, limit, offset int) ([]Document, string, error) {
 	if limit <= 0 {
 		limit = 50 // Default limit
 	}
@@ -224,64 +520,113 @@ func ListDocuments(db *sql.DB, prefix string, limit, offset int) ([]Document, er
 		offset = 0
 	}
 
-	var rows *sql.Rows
-	var err error
+	conditions := []string{}
+	args := []interface{}{}
 
 	if prefix != "" {
-		// List documents with prefix
-		rows, err = db.Query(`
-			SELECT id, content, content_type, is_binary, searchable,
-			       created_at, modified_at, created_by, modified_by, version
-			FROM _wce_documents
-			WHERE id LIKE ? || '%'
-			ORDER BY id
-			LIMIT ? OFFSET ?
-		`, prefix, limit, offset)
-	} else {
-		// List all documents
-		rows, err = db.Query(`
-			SELECT id, content, content_type, is_binary, searchable,
-			       created_at, modified_at, created_by, modified_by, version
-			FROM _wce_documents
-			ORDER BY id
-			LIMIT ? OFFSET ?
-		`, limit, offset)
+		conditions = append(conditions, "d.id LIKE ? || '%'")
+		args = append(args, prefix)
+	}
+	if cursor != "" {
+		key, err := decodeCursor(cursor, false)
+		if err != nil {
+			return nil, "", err
+		}
+		if (key.Position != nil) != positioned {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		if positioned {
+			conditions = append(conditions, "("+positionKey+", d.id) > (?, ?, ?)")
+			args = append(args, key.Position[0], key.Position[1], key.ID)
+		} else {
+			conditions = append(conditions, "d.id > ?")
+			args = append(args, key.ID)
+		}
+	}
+	for _, filter := range filters {
+		condition, filterArgs, err := filter.condition()
+		if err != nil {
+			return nil, "", err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, filterArgs...)
 	}
 
+	contentColumn := "d.content"
+	compressionColumn := "COALESCE(d.compression, '')"
+	if !includeContent {
+		contentColumn = "''"
+		compressionColumn = "''"
+	}
+
+	query := `
+		SELECT d.id, ` + contentColumn + `, d.content_type, d.is_binary, d.searchable,
+		       d.created_at, d.modified_at, d.created_by, d.modified_by, d.version, d.metadata, COALESCE(d.schema_id, ''), COALESCE(d.expires_at, 0), d.pinned, d.sort_order,
+		       ` + compressionColumn + `
+		FROM _wce_documents d`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	orderBy := "d.id"
+	if positioned {
+		orderBy = positionKey + ", d.id"
+	}
+	query += " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query documents: %w", err)
+		return nil, "", fmt.Errorf("failed to query documents: %w", err)
 	}
 	defer rows.Close()
 
+	docs, err := scanDocuments(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	return docs, nextDocumentsCursor(docs, limit, positioned), nil
+}
+
+// scanDocuments reads document rows selected with their metadata and
+// compression columns
+func scanDocuments(rows *sql.Rows) ([]Document, error) {
 	var documents []Document
 	for rows.Next() {
 		var doc Document
 		var isBinaryInt, searchableInt int
+		var metadata, compression string
 
 		err := rows.Scan(
 			&doc.ID, &doc.Content, &doc.ContentType, &isBinaryInt, &searchableInt,
-			&doc.CreatedAt, &doc.ModifiedAt, &doc.CreatedBy, &doc.ModifiedBy, &doc.Version,
+			&doc.CreatedAt, &doc.ModifiedAt, &doc.CreatedBy, &doc.ModifiedBy, &doc.Version, &metadata, &doc.SchemaID, &doc.ExpiresAt, &doc.Pinned, &doc.SortOrder,
+			&compression,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan document: %w", err)
 		}
+		if doc.Content, err = DecodeContent(doc.Content, compression); err != nil {
+			return nil, err
+		}
 
 		doc.IsBinary = isBinaryInt == 1
 		doc.Searchable = searchableInt == 1
+		doc.Metadata = json.RawMessage(metadata)
+		doc.splitMetadata()
 
 		documents = append(documents, doc)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating documents: %w", err)
 	}
 
 	return documents, nil
 }
 
-// SearchDocuments performs full-text search on documents
+// SearchDocuments performs full-text search on documents. The query uses the
+// syntax accepted by BuildSearchQuery.
 func SearchDocuments(db *sql.DB, query string, limit int) ([]SearchResult, error) {
-	if query == "" {
+	if strings.TrimSpace(query) == "" {
 		return nil, fmt.Errorf("search query cannot be empty")
 	}
 	if limit <= 0 {
@@ -291,44 +636,55 @@ func SearchDocuments(db *sql.DB, query string, limit int) ([]SearchResult, error
 		limit = 100 // Max limit for search
 	}
 
-	// Use FTS5 for full-text search
-	rows, err := db.Query(`
-		SELECT d.id, d.content, d.content_type, d.is_binary, d.searchable,
-		       d.created_at, d.modified_at, d.created_by, d.modified_by, d.version,
-		       s.rank
-		FROM _wce_document_search s
-		JOIN _wce_documents d ON d.id = s.document_id
-		WHERE _wce_document_search MATCH ?
-		ORDER BY s.rank
-		LIMIT ?
-	`, query, limit)
+	return Query(db, QueryOptions{Text: query, Limit: limit})
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to search documents: %w", err)
+// SearchDocumentsAfter is SearchDocuments in pages following cursor ("" for
+// the first page), returning the cursor of the next page
+func SearchDocumentsAfter(db *sql.DB, query, cursor string, limit int) ([]SearchResult, string, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, "", fmt.Errorf("search query cannot be empty")
+	}
+	if limit <= 0 {
+		limit = 20 // Default limit
+	}
+	if limit > 100 {
+		limit = 100 // Max limit for search
 	}
-	defer rows.Close()
 
+	return QueryPage(db, QueryOptions{Text: query, Cursor: cursor, Limit: limit})
+}
+
+// scanSearchResults reads document rows selected with their metadata,
+// compression and rank
+func scanSearchResults(rows *sql.Rows) ([]SearchResult, error) {
 	var results []SearchResult
 	for rows.Next() {
 		var result SearchResult
 		var isBinaryInt, searchableInt int
+		var metadata, compression string
 
 		err := rows.Scan(
 			&result.ID, &result.Content, &result.ContentType, &isBinaryInt, &searchableInt,
 			&result.CreatedAt, &result.ModifiedAt, &result.CreatedBy, &result.ModifiedBy,
-			&result.Version, &result.Rank,
+			&result.Version, &metadata, &result.SchemaID, &result.ExpiresAt, &result.Pinned, &result.SortOrder, &compression, &result.Rank,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan search result: %w", err)
 		}
+		if result.Content, err = DecodeContent(result.Content, compression); err != nil {
+			return nil, err
+		}
 
 		result.IsBinary = isBinaryInt == 1
 		result.Searchable = searchableInt == 1
+		result.Metadata = json.RawMessage(metadata)
+		result.splitMetadata()
 
 		results = append(results, result)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating search results: %w", err)
 	}
 
@@ -383,7 +739,7 @@ func RemoveDocumentTag(db *sql.DB, documentID, tag string) error {
 }
 
 // GetDocumentTags retrieves all tags for a document
-func GetDocumentTags(db *sql.DB, documentID string) ([]string, error) {
+func GetDocumentTags(db DB, documentID string) ([]string, error) {
 	if documentID == "" {
 		return nil, fmt.Errorf("document id cannot be empty")
 	}
@@ -417,8 +773,19 @@ func GetDocumentTags(db *sql.DB, documentID string) ([]string, error) {
 
 // ListDocumentsByTag lists all documents with a specific tag
 func ListDocumentsByTag(db *sql.DB, tag string, limit, offset int) ([]Document, error) {
+	docs, _, err := listDocumentsByTag(db, tag, "", limit, offset)
+	return docs, err
+}
+
+// ListDocumentsByTagAfter lists the documents with a tag in pages following
+// cursor, like ListDocumentsAfter
+func ListDocumentsByTagAfter(db *sql.DB, tag, cursor string, limit int) ([]Document, string, error) {
+	return listDocumentsByTag(db, tag, cursor, limit, 0)
+}
+
+func listDocumentsByTag(db *sql.DB, tag, cursor string, limit, offset int) ([]Document, string, error) {
 	if tag == "" {
-		return nil, fmt.Errorf("tag cannot be empty")
+		return nil, "", fmt.Errorf("tag cannot be empty")
 	}
 	if limit <= 0 {
 		limit = 50
@@ -432,45 +799,43 @@ func ListDocumentsByTag(db *sql.DB, tag string, limit, offset int) ([]Document,
 
 	tag = strings.ToLower(strings.TrimSpace(tag))
 
+	after := ""
+	if cursor != "" {
+		key, err := decodeCursor(cursor, false)
+		if err != nil {
+			return nil, "", err
+		}
+		after = key.ID
+	}
+
 	rows, err := db.Query(`
 		SELECT d.id, d.content, d.content_type, d.is_binary, d.searchable,
-		       d.created_at, d.modified_at, d.created_by, d.modified_by, d.version
+		       d.created_at, d.modified_at, d.created_by, d.modified_by, d.version, d.metadata, COALESCE(d.schema_id, ''), COALESCE(d.expires_at, 0), d.pinned, d.sort_order,
+		       COALESCE(d.compression, '')
 		FROM _wce_documents d
 		JOIN _wce_document_tags t ON d.id = t.document_id
-		WHERE t.tag = ?
+		WHERE t.tag = ? AND d.id > ?
 		ORDER BY d.id
 		LIMIT ? OFFSET ?
-	`, tag, limit, offset)
+	`, tag, after, limit, offset)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to query documents by tag: %w", err)
+		return nil, "", fmt.Errorf("failed to query documents by tag: %w", err)
 	}
 	defer rows.Close()
 
-	var documents []Document
-	for rows.Next() {
-		var doc Document
-		var isBinaryInt, searchableInt int
-
-		err := rows.Scan(
-			&doc.ID, &doc.Content, &doc.ContentType, &isBinaryInt, &searchableInt,
-			&doc.CreatedAt, &doc.ModifiedAt, &doc.CreatedBy, &doc.ModifiedBy, &doc.Version,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan document: %w", err)
-		}
-
-		doc.IsBinary = isBinaryInt == 1
-		doc.Searchable = searchableInt == 1
-
-		documents = append(documents, doc)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating documents: %w", err)
+	docs, err := scanDocuments(rows)
+	if err != nil {
+		return nil, "", err
 	}
+	return docs, nextDocumentsCursor(docs, limit, false), nil
+}
 
-	return documents, nil
+// prefixCondition returns an SQL condition matching rows whose column starts
+// with prefix, and its arguments. substr rather than LIKE, so '%' and '_'
+// match literally and case matters; substr counts characters, not bytes.
+func prefixCondition(column, prefix string) (string, []interface{}) {
+	return "substr(" + column + ", 1, ?) = ?", []interface{}{utf8.RuneCountInString(prefix), prefix}
 }
 
 // boolToInt converts bool to integer for SQLite
@@ -3,14 +3,16 @@ package document
 import (
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"testing"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 // setupTestDB creates an in-memory database with document tables
-func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+func setupTestDB(t testing.TB) *sql.DB {
+	db, err := sql.Open(sqlite.DriverName, "file::memory:?cache=shared")
 	if err != nil {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
@@ -41,6 +43,13 @@ func setupTestDB(t *testing.T) *sql.DB {
 		created_by TEXT NOT NULL,
 		modified_by TEXT NOT NULL,
 		version INTEGER DEFAULT 1,
+		metadata TEXT NOT NULL DEFAULT '{}',
+		schema_id TEXT,
+		expires_at INTEGER,
+		compression TEXT,
+		pinned INTEGER NOT NULL DEFAULT 0,
+		sort_order INTEGER,
+		content_hash TEXT,
 		FOREIGN KEY (created_by) REFERENCES _wce_users(user_id),
 		FOREIGN KEY (modified_by) REFERENCES _wce_users(user_id)
 	);
@@ -60,6 +69,104 @@ func setupTestDB(t *testing.T) *sql.DB {
 	CREATE INDEX idx_document_tags_doc ON _wce_document_tags(document_id);
 	CREATE INDEX idx_document_tags_tag ON _wce_document_tags(tag);
 
+	CREATE TABLE _wce_document_links (
+		source_id TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		PRIMARY KEY (source_id, target_id),
+		FOREIGN KEY (source_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE _wce_document_references (
+		source_id TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		PRIMARY KEY (source_id, target_id, kind),
+		FOREIGN KEY (source_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE _wce_document_versions (
+		document_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		is_binary INTEGER DEFAULT 0,
+		modified_at INTEGER NOT NULL,
+		modified_by TEXT NOT NULL,
+		compression TEXT,
+		PRIMARY KEY (document_id, version),
+		FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE _wce_document_scans (
+		document_id TEXT PRIMARY KEY,
+		version INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		signature TEXT,
+		scanned_at INTEGER,
+		reviewed_by TEXT,
+		reviewed_at INTEGER,
+		FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE _wce_document_blobs (
+		document_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (document_id, seq),
+		FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE _wce_document_thumbnails (
+		document_id TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		source_hash TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		data BLOB NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (document_id, width),
+		FOREIGN KEY (document_id) REFERENCES _wce_documents(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE _wce_audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		user_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		action TEXT NOT NULL,
+		resource_type TEXT,
+		resource_id TEXT,
+		details TEXT,
+		ip_address TEXT,
+		user_agent TEXT,
+		FOREIGN KEY (user_id) REFERENCES _wce_users(user_id)
+	);
+
+	CREATE TABLE _wce_config (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		updated_by TEXT
+	);
+
+	CREATE TABLE _wce_mime_policies (
+		content_type TEXT PRIMARY KEY,
+		disposition TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		updated_by TEXT
+	);
+
+	CREATE TABLE _wce_collections (
+		name TEXT PRIMARY KEY,
+		query TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '[]',
+		prefix TEXT NOT NULL DEFAULT '',
+		sort TEXT NOT NULL DEFAULT '',
+		page_size INTEGER NOT NULL DEFAULT 20,
+		schema_id TEXT,
+		modified_at INTEGER NOT NULL,
+		modified_by TEXT
+	);
+
 	CREATE VIRTUAL TABLE _wce_document_search USING fts5(
 		document_id UNINDEXED,
 		content
@@ -67,17 +174,17 @@ func setupTestDB(t *testing.T) *sql.DB {
 
 	CREATE TRIGGER _wce_documents_ai AFTER INSERT ON _wce_documents BEGIN
 		INSERT INTO _wce_document_search(document_id, content)
-		VALUES (NEW.id, CASE WHEN NEW.searchable = 1 THEN NEW.content ELSE '' END);
+		VALUES (NEW.id, CASE WHEN NEW.searchable = 1 AND NEW.compression IS NULL THEN NEW.content ELSE '' END);
 	END;
 
 	CREATE TRIGGER _wce_documents_ad AFTER DELETE ON _wce_documents BEGIN
 		DELETE FROM _wce_document_search WHERE document_id = OLD.id;
 	END;
 
-	CREATE TRIGGER _wce_documents_au AFTER UPDATE ON _wce_documents BEGIN
+	CREATE TRIGGER _wce_documents_au AFTER UPDATE OF id, content, searchable, compression ON _wce_documents BEGIN
 		UPDATE _wce_document_search
 		SET document_id = NEW.id,
-			content = CASE WHEN NEW.searchable = 1 THEN NEW.content ELSE '' END
+			content = CASE WHEN NEW.searchable = 1 AND NEW.compression IS NULL THEN NEW.content ELSE '' END
 		WHERE document_id = OLD.id;
 	END;
 	`
@@ -145,6 +252,38 @@ func TestCreateDocument_Duplicate(t *testing.T) {
 	}
 }
 
+func TestCreateDocument_ReservedID(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Each of these would be read back as a sub-resource of another document
+	for _, id := range []string{
+		"notes/versions", "notes/versions/2", "notes/diff", "notes/links", "notes/backlinks",
+		"notes/tags", "notes/move", "notes/copy", "notes/pin", "notes/unpin",
+	} {
+		if _, err := CreateDocument(db, id, "content", "text/plain", "user-1", false, true); err == nil {
+			t.Errorf("Expected %s to be refused", id)
+		}
+	}
+
+	// The words are fine as a first segment or as part of a segment
+	for _, id := range []string{"links", "versions/2", "notes/link-list", "diff/notes"} {
+		if _, err := CreateDocument(db, id, "content", "text/plain", "user-1", false, true); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", id, err)
+		}
+	}
+
+	if _, err := MoveDocument(db, "links", "notes/links", "user-1"); err == nil {
+		t.Error("Expected a move to a reserved id to be refused")
+	}
+	if _, err := CopyDocument(db, "links", "notes/backlinks", "user-1"); err == nil {
+		t.Error("Expected a copy to a reserved id to be refused")
+	}
+	if _, _, err := WriteBlob(db, "files/diff", "application/octet-stream", "user-1", strings.NewReader("data")); err == nil {
+		t.Error("Expected a blob with a reserved id to be refused")
+	}
+}
+
 func TestCreateDocument_Binary(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -287,7 +426,7 @@ func TestDeleteDocument(t *testing.T) {
 	}
 
 	// Delete document
-	err = DeleteDocument(db, "temp/doc")
+	err = DeleteDocument(db, "temp/doc", "user-1")
 	if err != nil {
 		t.Fatalf("DeleteDocument failed: %v", err)
 	}
@@ -303,7 +442,7 @@ func TestDeleteDocument_NotFound(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	err := DeleteDocument(db, "nonexistent")
+	err := DeleteDocument(db, "nonexistent", "user-1")
 	if err == nil {
 		t.Error("DeleteDocument should fail for nonexistent document")
 	}
@@ -355,6 +494,25 @@ func TestListDocuments_WithPrefix(t *testing.T) {
 	}
 }
 
+func TestListDocuments_WithoutContent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "pages/home", "home", "text/html", "user-1", false, true)
+	SetDocumentMetadata(db, "pages/home", json.RawMessage(`{"title":"Home"}`), "user-1")
+
+	docs, err := ListDocumentsFiltered(db, "pages/", nil, false, 10, 0)
+	if err != nil {
+		t.Fatalf("ListDocumentsFiltered failed: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Content != "" {
+		t.Fatalf("Expected metadata only, got %+v", docs)
+	}
+	if docs[0].ContentType != "text/html" || string(docs[0].Metadata) != `{"title":"Home"}` {
+		t.Errorf("Expected metadata columns to be listed, got %+v", docs[0])
+	}
+}
+
 func TestListDocuments_Pagination(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -575,7 +733,7 @@ func TestDeleteDocument_CascadeTags(t *testing.T) {
 	AddDocumentTag(db, "posts/cascade", "tag2")
 
 	// Delete document
-	err := DeleteDocument(db, "posts/cascade")
+	err := DeleteDocument(db, "posts/cascade", "user-1")
 	if err != nil {
 		t.Fatalf("DeleteDocument failed: %v", err)
 	}
@@ -0,0 +1,92 @@
+package document
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DuplicateGroup is a set of documents with identical content
+type DuplicateGroup struct {
+	Hash        string   `json:"hash"`         // Hex SHA-256 of the content
+	Size        int64    `json:"size"`         // Content bytes of each copy
+	IDs         []string `json:"ids"`          // Documents holding the content, by id
+	WastedBytes int64    `json:"wasted_bytes"` // Space taken by every copy but one
+}
+
+// FindDuplicates hashes the content of every document under prefix ("" for
+// all) and returns the groups of two or more documents whose content is
+// identical, those wasting the most space first. Empty blobs are not
+// reported. Documents are read one at a time, so memory use is bounded by
+// the largest inline document whatever the size of the store.
+func FindDuplicates(ctx context.Context, db *sql.DB, prefix string) ([]DuplicateGroup, error) {
+	condition, args := prefixCondition("id", prefix)
+	rows, err := db.QueryContext(ctx, "SELECT id FROM _wce_documents WHERE "+condition+" ORDER BY id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan document id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating documents: %w", err)
+	}
+
+	groups := map[string]*DuplicateGroup{}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		doc, err := GetDocument(db, id)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				continue // Deleted since it was listed
+			}
+			return nil, err
+		}
+		size := int64(len(doc.Content))
+		if doc.IsBlob() {
+			size = doc.Size
+		}
+		if size == 0 {
+			continue
+		}
+
+		hash, err := ContentHash(ctx, db, doc)
+		if err != nil {
+			return nil, err
+		}
+		group, ok := groups[hash]
+		if !ok {
+			group = &DuplicateGroup{Hash: hash, Size: size}
+			groups[hash] = group
+		}
+		group.IDs = append(group.IDs, id)
+	}
+
+	duplicates := []DuplicateGroup{}
+	for _, group := range groups {
+		if len(group.IDs) < 2 {
+			continue
+		}
+		group.WastedBytes = group.Size * int64(len(group.IDs)-1)
+		duplicates = append(duplicates, *group)
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].WastedBytes != duplicates[j].WastedBytes {
+			return duplicates[i].WastedBytes > duplicates[j].WastedBytes
+		}
+		return duplicates[i].IDs[0] < duplicates[j].IDs[0]
+	})
+	return duplicates, nil
+}
@@ -0,0 +1,61 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for id, content := range map[string]string{
+		"a.txt": "hello", "b.txt": "hello", "c.txt": "unique",
+	} {
+		if _, err := CreateDocument(db, id, content, "text/plain", "user-1", false, false); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+	}
+	// A blob with the same content as inline documents is a copy too
+	for _, id := range []string{"x.bin", "y.bin"} {
+		if _, _, err := WriteBlob(db, id, "application/octet-stream", "user-1", bytes.NewReader(make([]byte, 1000))); err != nil {
+			t.Fatalf("WriteBlob failed: %v", err)
+		}
+	}
+	if _, err := CreateDocument(db, "z.txt", "hello", "text/plain", "user-1", false, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	groups, err := FindDuplicates(context.Background(), db, "")
+	if err != nil {
+		t.Fatalf("FindDuplicates failed: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 duplicate groups, got %+v", groups)
+	}
+	if groups[0].Size != 1000 || groups[0].WastedBytes != 1000 || len(groups[0].IDs) != 2 || groups[0].IDs[0] != "x.bin" {
+		t.Errorf("Expected blob group first, got %+v", groups[0])
+	}
+	if groups[1].Size != 5 || groups[1].WastedBytes != 10 || len(groups[1].IDs) != 3 {
+		t.Errorf("Expected 3 copies of hello, got %+v", groups[1])
+	}
+	if groups[0].Hash == "" || groups[0].Hash == groups[1].Hash {
+		t.Errorf("Expected distinct hashes, got %q and %q", groups[0].Hash, groups[1].Hash)
+	}
+
+	groups, err = FindDuplicates(context.Background(), db, "c")
+	if err != nil || len(groups) != 0 {
+		t.Errorf("Expected no duplicates under c, got %+v (%v)", groups, err)
+	}
+	groups, err = FindDuplicates(context.Background(), db, "_")
+	if err != nil || len(groups) != 0 {
+		t.Errorf("Expected _ to match literally, got %+v (%v)", groups, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FindDuplicates(ctx, db, ""); err == nil {
+		t.Error("Expected an error for a cancelled scan")
+	}
+}
@@ -0,0 +1,159 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// ArchivePrefix is the id prefix ArchiveDocument moves expired documents under
+const ArchivePrefix = "archive/"
+
+// SetDocumentExpiry sets the Unix time after which the expiry sweeper
+// removes a document; 0 keeps it indefinitely. The content version is
+// unchanged; the modification time and author are updated.
+func SetDocumentExpiry(db DB, id string, expiresAt int64, userID string) (*Document, error) {
+	if id == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+	if expiresAt < 0 {
+		return nil, fmt.Errorf("expires_at must be a Unix timestamp, or 0 for no expiry")
+	}
+
+	var expiresParam interface{}
+	if expiresAt != 0 {
+		expiresParam = expiresAt
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_documents
+		SET expires_at = ?, modified_at = ?, modified_by = ?
+		WHERE id = ?
+	`, expiresParam, clock.Now().Unix(), userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update expiry: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("document not found: %s", id)
+	}
+
+	return GetDocument(db, id)
+}
+
+// ExpiredDocuments returns the ids of up to limit documents whose expiry time
+// is at or before now, oldest expiry first
+func ExpiredDocuments(db *sql.DB, now int64, limit int) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT id FROM _wce_documents
+		WHERE expires_at IS NOT NULL AND expires_at <= ?
+		ORDER BY expires_at, id
+		LIMIT ?
+	`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired documents: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan expired document: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired documents: %w", err)
+	}
+
+	return ids, nil
+}
+
+// ArchiveDocument moves a document to ArchivePrefix + id and clears its
+// expiry, replacing any copy archived earlier under the same id. The change
+// feed attributes the move to the document's last modifier.
+func ArchiveDocument(db *sql.DB, id string) (*Document, error) {
+	if id == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+	archiveID := ArchivePrefix + id
+
+	if err := deleteDocument(db, archiveID, ""); err != nil && !strings.Contains(err.Error(), "not found") {
+		return nil, fmt.Errorf("failed to replace archived document: %w", err)
+	}
+	if _, err := moveDocument(db, id, archiveID, ""); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`UPDATE _wce_documents SET expires_at = NULL WHERE id = ?`, archiveID); err != nil {
+		return nil, fmt.Errorf("failed to clear expiry: %w", err)
+	}
+
+	return GetDocument(db, archiveID)
+}
+
+// SweepExpiredDocuments removes every document that expired at or before
+// now, archiving it with ArchiveDocument when archive is set and deleting it
+// otherwise. Documents already under ArchivePrefix are always deleted. A
+// document that cannot be removed, such as a schema still in use, is skipped
+// and reported in the returned error; the rest are still swept. Removals are
+// attributed to each document's last modifier, normally whoever set its
+// expiry. Returns the number of documents removed.
+func SweepExpiredDocuments(db *sql.DB, now int64, archive bool) (int, error) {
+	const batchSize = 100
+
+	removed := 0
+	skipped := map[string]error{}
+	for {
+		// Skipped documents stay expired, so fetch past them
+		limit := batchSize + len(skipped)
+		ids, err := ExpiredDocuments(db, now, limit)
+		if err != nil {
+			return removed, err
+		}
+
+		progress := false
+		for _, id := range ids {
+			if _, ok := skipped[id]; ok {
+				continue
+			}
+			if archive && !strings.HasPrefix(id, ArchivePrefix) {
+				_, err = ArchiveDocument(db, id)
+			} else {
+				err = deleteDocument(db, id, "")
+			}
+			if err != nil {
+				skipped[id] = err
+				continue
+			}
+			removed++
+			progress = true
+		}
+
+		if !progress || len(ids) < limit {
+			break
+		}
+	}
+
+	if len(skipped) > 0 {
+		var failures []string
+		for id, err := range skipped {
+			failures = append(failures, fmt.Sprintf("%s: %v", id, err))
+		}
+		sort.Strings(failures)
+		return removed, fmt.Errorf("failed to sweep %d expired documents: %s", len(skipped), strings.Join(failures, "; "))
+	}
+
+	return removed, nil
+}
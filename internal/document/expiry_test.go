@@ -0,0 +1,161 @@
+package document
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+func TestSetDocumentExpiry(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "previews/a", "preview", "text/plain", "user-1", false, true)
+
+	doc, err := SetDocumentExpiry(db, "previews/a", 1000, "user-1")
+	if err != nil {
+		t.Fatalf("SetDocumentExpiry failed: %v", err)
+	}
+	if doc.ExpiresAt != 1000 || doc.Version != 1 {
+		t.Errorf("Expected expiry 1000 at version 1, got %d at %d", doc.ExpiresAt, doc.Version)
+	}
+
+	docs, _ := ListDocuments(db, "previews/", 10, 0)
+	if len(docs) != 1 || docs[0].ExpiresAt != 1000 {
+		t.Errorf("Expected listed document to carry its expiry, got %+v", docs)
+	}
+
+	if doc, _ = SetDocumentExpiry(db, "previews/a", 0, "user-1"); doc.ExpiresAt != 0 {
+		t.Errorf("Expected expiry to be cleared, got %d", doc.ExpiresAt)
+	}
+
+	if _, err := SetDocumentExpiry(db, "previews/a", -1, "user-1"); err == nil {
+		t.Error("Expected negative expiry to be rejected")
+	}
+	if _, err := SetDocumentExpiry(db, "previews/missing", 1000, "user-1"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
+
+func TestSweepExpiredDocuments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"exports/old", "exports/new", "exports/kept", "caches/old"} {
+		CreateDocument(db, id, "data", "text/plain", "user-1", false, true)
+	}
+	SetDocumentExpiry(db, "exports/old", 100, "user-1")
+	SetDocumentExpiry(db, "caches/old", 150, "user-1")
+	SetDocumentExpiry(db, "exports/new", 300, "user-1")
+
+	expired, err := ExpiredDocuments(db, 200, 10)
+	if err != nil {
+		t.Fatalf("ExpiredDocuments failed: %v", err)
+	}
+	if len(expired) != 2 || expired[0] != "exports/old" || expired[1] != "caches/old" {
+		t.Errorf("Expected expired documents oldest first, got %v", expired)
+	}
+
+	removed, err := SweepExpiredDocuments(db, 200, false)
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 documents deleted, got %d: %v", removed, err)
+	}
+	for _, id := range []string{"exports/old", "caches/old"} {
+		if _, err := GetDocument(db, id); err == nil {
+			t.Errorf("Expected %s to be deleted", id)
+		}
+	}
+
+	// Archiving moves the document aside without an expiry
+	AddDocumentTag(db, "exports/new", "report")
+	removed, err = SweepExpiredDocuments(db, 300, true)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 document archived, got %d: %v", removed, err)
+	}
+	archived, err := GetDocument(db, "archive/exports/new")
+	if err != nil {
+		t.Fatalf("Expected archived document: %v", err)
+	}
+	if archived.ExpiresAt != 0 || len(archived.Tags) != 1 {
+		t.Errorf("Expected archive without expiry and with its tags, got %+v", archived)
+	}
+	if _, err := GetDocument(db, "exports/kept"); err != nil {
+		t.Errorf("Expected unexpiring document to be kept: %v", err)
+	}
+
+	// A newer expiry of the same id replaces the archived copy, and archived
+	// documents given an expiry are deleted
+	CreateDocument(db, "exports/new", "newer", "text/plain", "user-1", false, true)
+	SetDocumentExpiry(db, "exports/new", 400, "user-1")
+	if removed, err = SweepExpiredDocuments(db, 400, true); err != nil || removed != 1 {
+		t.Fatalf("Expected 1 document archived, got %d: %v", removed, err)
+	}
+	if archived, _ = GetDocument(db, "archive/exports/new"); archived.Content != "newer" {
+		t.Errorf("Expected newer archived content, got %q", archived.Content)
+	}
+	SetDocumentExpiry(db, "archive/exports/new", 500, "user-1")
+	if removed, err = SweepExpiredDocuments(db, 500, true); err != nil || removed != 1 {
+		t.Fatalf("Expected archived document deleted, got %d: %v", removed, err)
+	}
+	if _, err := GetDocument(db, "archive/archive/exports/new"); err == nil {
+		t.Error("Expected archived document not to be archived again")
+	}
+}
+
+func TestSweepExpiredDocuments_SchemaInUse(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "schemas/product", productSchema, "application/json", "user-1", false, false)
+	CreateDocumentWithSchema(db, "products/a", `{"sku": "A1", "price": 1}`, "application/json", "user-1", "schemas/product", false, true)
+	CreateDocument(db, "exports/old", "data", "text/plain", "user-1", false, true)
+	SetDocumentExpiry(db, "schemas/product", 100, "user-1")
+	SetDocumentExpiry(db, "exports/old", 100, "user-1")
+
+	removed, err := SweepExpiredDocuments(db, 200, false)
+	if err == nil || !strings.Contains(err.Error(), "schemas/product") {
+		t.Errorf("Expected schema in use to be reported, got %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected the other document to be swept, got %d", removed)
+	}
+	if _, err := GetDocument(db, "schemas/product"); err != nil {
+		t.Errorf("Expected schema in use to be kept: %v", err)
+	}
+}
+
+func TestExpiry_FakeClock(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	fake := clock.NewFake(time.Unix(5000, 0))
+	defer clock.Set(fake)()
+
+	doc, err := CreateDocument(db, "previews/a", "preview", "text/plain", "user-1", false, true)
+	if err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if doc.CreatedAt != 5000 {
+		t.Errorf("Expected created_at from the fake clock, got %d", doc.CreatedAt)
+	}
+	SetDocumentExpiry(db, "previews/a", clock.Now().Add(time.Hour).Unix(), "user-1")
+
+	fake.Advance(time.Minute)
+	updated, err := UpdateDocument(db, "previews/a", "preview 2", "user-1")
+	if err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if updated.ModifiedAt != 5060 || updated.Version != 2 {
+		t.Errorf("Expected version 2 modified at 5060, got version %d at %d", updated.Version, updated.ModifiedAt)
+	}
+
+	if removed, _ := SweepExpiredDocuments(db, clock.Now().Unix(), false); removed != 0 {
+		t.Errorf("Expected nothing to expire yet, removed %d", removed)
+	}
+	fake.Advance(time.Hour)
+	if removed, _ := SweepExpiredDocuments(db, clock.Now().Unix(), false); removed != 1 {
+		t.Errorf("Expected the document to expire, removed %d", removed)
+	}
+}
@@ -0,0 +1,299 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Export archive formats
+const (
+	ExportFormatZip = "zip"
+	ExportFormatTar = "tar" // gzip-compressed tarball
+)
+
+// ExportManifestName is the archive entry holding the export manifest.
+// ImportZip skips it, so an export can be imported back as is.
+const ExportManifestName = "wce-manifest.json"
+
+// ExportedDocument is the manifest record of one exported document; its
+// content is the archive entry named by ID
+type ExportedDocument struct {
+	ID          string          `json:"id"`
+	ContentType string          `json:"content_type"`
+	IsBinary    bool            `json:"is_binary"`
+	Searchable  bool            `json:"searchable"`
+	CreatedAt   int64           `json:"created_at"`
+	ModifiedAt  int64           `json:"modified_at"`
+	CreatedBy   string          `json:"created_by"`
+	ModifiedBy  string          `json:"modified_by"`
+	Version     int             `json:"version"`
+	Tags        []string        `json:"tags,omitempty"`
+	Metadata    json.RawMessage `json:"metadata"`
+	SchemaID    string          `json:"schema_id,omitempty"`
+	ExpiresAt   int64           `json:"expires_at,omitempty"`
+	Size        int64           `json:"size"` // Bytes written to the archive
+}
+
+// SkippedDocument is a document left out of an export
+type SkippedDocument struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// ExportManifest describes the documents of an export archive
+type ExportManifest struct {
+	Prefix     string             `json:"prefix"`
+	Author     string             `json:"author,omitempty"` // user_id whose documents were exported
+	ExportedAt int64              `json:"exported_at"`
+	Documents  []ExportedDocument `json:"documents"`
+	Skipped    []SkippedDocument  `json:"skipped,omitempty"`
+}
+
+// ExportFile is a file other than a document added to an export archive
+type ExportFile struct {
+	Name    string
+	Content []byte
+}
+
+// IsValidExportFormat reports whether format is a supported export format
+func IsValidExportFormat(format string) bool {
+	return format == ExportFormatZip || format == ExportFormatTar
+}
+
+// ExportDocuments writes every document whose id starts with prefix to w as
+// an archive in the given format, followed by ExportManifestName. Each
+// document is an entry named by its id: text as stored, binary content
+// decoded. Binary documents not cleared by the malware scanner, and ids that
+// are not usable as file paths, are left out and listed in the manifest.
+// Documents are read one at a time, so the archive is streamed rather than
+// built in memory.
+func ExportDocuments(ctx context.Context, db *sql.DB, w io.Writer, prefix, format string) (*ExportManifest, error) {
+	if !IsValidExportFormat(format) {
+		return nil, fmt.Errorf("invalid export format: %s", format)
+	}
+
+	condition, args := prefixCondition("id", prefix)
+	ids, err := exportIDs(db, "SELECT id FROM _wce_documents WHERE "+condition+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	return writeExport(ctx, db, w, format, ids, &ExportManifest{Prefix: prefix})
+}
+
+// ExportAuthoredDocuments writes the documents created by userID to w as
+// ExportDocuments does, after files. Documents named like one of the files
+// are left out.
+func ExportAuthoredDocuments(ctx context.Context, db *sql.DB, w io.Writer, userID, format string, files ...ExportFile) (*ExportManifest, error) {
+	if !IsValidExportFormat(format) {
+		return nil, fmt.Errorf("invalid export format: %s", format)
+	}
+
+	ids, err := exportIDs(db, "SELECT id FROM _wce_documents WHERE created_by = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return writeExport(ctx, db, w, format, ids, &ExportManifest{Author: userID}, files...)
+}
+
+// writeExport writes files and the documents with the given ids to w, and
+// finishes with the manifest
+func writeExport(ctx context.Context, db *sql.DB, w io.Writer, format string, ids []string, manifest *ExportManifest, files ...ExportFile) (*ExportManifest, error) {
+	archive := newArchiveWriter(w, format)
+	manifest.ExportedAt = clock.Now().Unix()
+	manifest.Documents = []ExportedDocument{}
+
+	reserved := map[string]bool{ExportManifestName: true}
+	for _, file := range files {
+		if err := archive.add(file.Name, int64(len(file.Content)), time.Unix(manifest.ExportedAt, 0), bytes.NewReader(file.Content)); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+		reserved[file.Name] = true
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if reason := exportSkipReason(db, id, reserved); reason != "" {
+			manifest.Skipped = append(manifest.Skipped, SkippedDocument{ID: id, Reason: reason})
+			continue
+		}
+
+		doc, err := GetDocument(db, id)
+		if err != nil {
+			// Deleted since the ids were listed
+			if strings.Contains(err.Error(), "not found") {
+				continue
+			}
+			return nil, err
+		}
+
+		size, err := exportDocument(ctx, db, archive, doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", id, err)
+		}
+
+		manifest.Documents = append(manifest.Documents, ExportedDocument{
+			ID:          doc.ID,
+			ContentType: doc.ContentType,
+			IsBinary:    doc.IsBinary,
+			Searchable:  doc.Searchable,
+			CreatedAt:   doc.CreatedAt,
+			ModifiedAt:  doc.ModifiedAt,
+			CreatedBy:   doc.CreatedBy,
+			ModifiedBy:  doc.ModifiedBy,
+			Version:     doc.Version,
+			Tags:        doc.Tags,
+			Metadata:    doc.Metadata,
+			SchemaID:    doc.SchemaID,
+			ExpiresAt:   doc.ExpiresAt,
+			Size:        size,
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := archive.add(ExportManifestName, int64(len(data)), time.Unix(manifest.ExportedAt, 0), bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// exportIDs lists the ids of the documents a query selects
+func exportIDs(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan document id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating documents: %w", err)
+	}
+	return ids, nil
+}
+
+// exportSkipReason explains why a document cannot be exported, or returns "".
+// reserved names the other entries of the archive.
+func exportSkipReason(db *sql.DB, id string, reserved map[string]bool) string {
+	if path, err := importID("", id); err != nil || path != id || reserved[id] {
+		return "id is not a portable file path"
+	}
+	record, err := GetScanRecord(db, id)
+	if err != nil {
+		return "failed to check scan status"
+	}
+	if !record.IsServable() {
+		return "document is " + record.Status
+	}
+	return ""
+}
+
+// exportDocument writes one document's content to the archive and returns
+// the number of bytes written
+func exportDocument(ctx context.Context, db *sql.DB, archive archiveWriter, doc *Document) (int64, error) {
+	modTime := time.Unix(doc.ModifiedAt, 0)
+
+	if doc.IsBlob() {
+		blob, err := OpenBlob(ctx, db, doc.ID)
+		if err != nil {
+			return 0, err
+		}
+		defer blob.Close()
+		return doc.Size, archive.add(doc.ID, doc.Size, modTime, blob)
+	}
+
+	content := []byte(doc.Content)
+	if doc.IsBinary {
+		decoded, err := base64.StdEncoding.DecodeString(doc.Content)
+		if err != nil {
+			return 0, fmt.Errorf("invalid base64 content: %w", err)
+		}
+		content = decoded
+	}
+	return int64(len(content)), archive.add(doc.ID, int64(len(content)), modTime, bytes.NewReader(content))
+}
+
+// archiveWriter adds files to a ZIP or tar archive
+type archiveWriter interface {
+	add(name string, size int64, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format string) archiveWriter {
+	if format == ExportFormatTar {
+		gz := gzip.NewWriter(w)
+		return &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipArchive{zw: zip.NewWriter(w)}
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	f, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchive) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
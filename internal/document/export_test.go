@@ -0,0 +1,183 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExportDocumentsZip(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "site/index.html", "<h1>Home</h1>", "text/html", "user-1", false, true)
+	CreateDocument(db, "site/logo.png", base64.StdEncoding.EncodeToString([]byte("\x89PNG\x00")), "image/png", "user-1", true, false)
+	CreateDocument(db, "site/evil.exe", base64.StdEncoding.EncodeToString([]byte("MZ")), "application/octet-stream", "user-1", true, false)
+	CreateDocument(db, "other/note.txt", "not exported", "text/plain", "user-1", false, true)
+	AddDocumentTag(db, "site/index.html", "home")
+	WriteBlob(db, "site/video.bin", "application/octet-stream", "user-1", strings.NewReader("streamed"))
+	MarkScanPending(db, "site/evil.exe", 1)
+	RecordScanResult(db, "site/evil.exe", 1, true, "EICAR")
+
+	var buf bytes.Buffer
+	manifest, err := ExportDocuments(context.Background(), db, &buf, "site/", ExportFormatZip)
+	if err != nil {
+		t.Fatalf("ExportDocuments failed: %v", err)
+	}
+	if len(manifest.Documents) != 3 || len(manifest.Skipped) != 1 || manifest.Skipped[0].ID != "site/evil.exe" {
+		t.Fatalf("Unexpected manifest: %+v", manifest)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Export is not a valid ZIP: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	want := map[string]string{
+		"site/index.html": "<h1>Home</h1>",
+		"site/logo.png":   "\x89PNG\x00",
+		"site/video.bin":  "streamed",
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("Expected %s to hold %q, got %q", name, content, files[name])
+		}
+	}
+	if _, ok := files["site/evil.exe"]; ok {
+		t.Error("Expected quarantined document to be left out")
+	}
+
+	var written ExportManifest
+	if err := json.Unmarshal([]byte(files[ExportManifestName]), &written); err != nil {
+		t.Fatalf("Invalid manifest entry: %v", err)
+	}
+	if len(written.Documents) != 3 || written.Documents[0].ID != "site/index.html" || len(written.Documents[0].Tags) != 1 {
+		t.Errorf("Unexpected manifest entry: %+v", written)
+	}
+
+	// The export imports back without the manifest
+	report, err := ImportZip(db, archive, "user-1", ImportOptions{Prefix: "restored"})
+	if err != nil || report.Created != 3 || len(report.Files) != 3 {
+		t.Fatalf("Expected export to import back, got %+v: %v", report, err)
+	}
+}
+
+func TestExportDocumentsTar(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "notes/a.txt", "first", "text/plain", "user-1", false, true)
+	CreateDocument(db, "notes/b.txt", "second", "text/plain", "user-1", false, true)
+
+	var buf bytes.Buffer
+	if _, err := ExportDocuments(context.Background(), db, &buf, "notes/", ExportFormatTar); err != nil {
+		t.Fatalf("ExportDocuments failed: %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("Export is not gzip-compressed: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid tarball: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	if strings.Join(names, ",") != "notes/a.txt,notes/b.txt,"+ExportManifestName {
+		t.Errorf("Unexpected tarball entries: %v", names)
+	}
+
+	if _, err := ExportDocuments(context.Background(), db, &buf, "", "rar"); err == nil {
+		t.Error("Expected unsupported format to fail")
+	}
+}
+
+func TestExportDocumentsPrefixIsLiteral(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"50%_off/a.txt", "50xxoff/b.txt", "Notes/c.txt", "notes/d.txt", "café/e.txt"} {
+		if _, err := CreateDocument(db, id, "content", "text/plain", "user-1", false, true); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+	}
+
+	tests := map[string]string{
+		"50%_off/": "50%_off/a.txt",
+		"notes/":   "notes/d.txt",
+		"café/":    "café/e.txt",
+	}
+	for prefix, want := range tests {
+		manifest, err := ExportDocuments(context.Background(), db, io.Discard, prefix, ExportFormatZip)
+		if err != nil {
+			t.Fatalf("ExportDocuments failed: %v", err)
+		}
+		if len(manifest.Documents) != 1 || manifest.Documents[0].ID != want {
+			t.Errorf("Prefix %q: expected only %s, got %+v", prefix, want, manifest.Documents)
+		}
+	}
+}
+
+func TestExportAuthoredDocuments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.Exec(`INSERT INTO _wce_users (user_id, username) VALUES ('user-2', 'other')`)
+
+	CreateDocument(db, "notes/mine.txt", "mine", "text/plain", "user-1", false, true)
+	CreateDocument(db, "wce-user.json", "clashes with the data file", "text/plain", "user-1", false, true)
+	CreateDocument(db, "notes/theirs.txt", "theirs", "text/plain", "user-2", false, true)
+
+	var buf bytes.Buffer
+	file := ExportFile{Name: "wce-user.json", Content: []byte(`{"user":"user-1"}`)}
+	manifest, err := ExportAuthoredDocuments(context.Background(), db, &buf, "user-1", ExportFormatTar, file)
+	if err != nil {
+		t.Fatalf("ExportAuthoredDocuments failed: %v", err)
+	}
+	if manifest.Author != "user-1" || len(manifest.Documents) != 1 || len(manifest.Skipped) != 1 {
+		t.Fatalf("Unexpected manifest: %+v", manifest)
+	}
+
+	gz, _ := gzip.NewReader(&buf)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid tarball: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[header.Name] = string(data)
+		names = append(names, header.Name)
+	}
+	if strings.Join(names, ",") != "wce-user.json,notes/mine.txt,"+ExportManifestName {
+		t.Errorf("Unexpected tarball entries: %v", names)
+	}
+	if files["wce-user.json"] != `{"user":"user-1"}` {
+		t.Errorf("Expected the data file kept, got %q", files["wce-user.json"])
+	}
+}
@@ -0,0 +1,641 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// frontMatterKey is the metadata key a document's parsed front matter is
+// stored under. It is maintained from content on every write, so metadata
+// updates cannot change it, and is returned as Document.FrontMatter rather
+// than as part of Metadata.
+const frontMatterKey = "front_matter"
+
+// hasFrontMatter reports whether documents of a content type carry YAML
+// front matter. Only markdown does; a YAML or plain text document starting
+// with "---" is content, not front matter.
+func hasFrontMatter(contentType string, isBinary bool) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && !isBinary && (mediaType == "text/markdown" || mediaType == "text/x-markdown")
+}
+
+// SplitFrontMatter separates YAML front matter from the rest of content.
+// Front matter opens with a "---" line at the very start and closes with a
+// "---" or "..." line; without a closing line there is none and ok is false.
+func SplitFrontMatter(content string) (frontMatter, body string, ok bool) {
+	rest, found := strings.CutPrefix(content, "---\n")
+	if !found {
+		if rest, found = strings.CutPrefix(content, "---\r\n"); !found {
+			return "", content, false
+		}
+	}
+	for start := 0; start <= len(rest); {
+		end := strings.IndexByte(rest[start:], '\n')
+		next := len(rest) + 1
+		if end >= 0 {
+			end += start
+			next = end + 1
+		} else {
+			end = len(rest)
+		}
+		if line := strings.TrimRight(rest[start:end], " \t\r"); line == "---" || line == "..." {
+			if next > len(rest) {
+				next = len(rest)
+			}
+			return rest[:start], rest[next:], true
+		}
+		start = next
+	}
+	return "", content, false
+}
+
+// ParseFrontMatter parses the YAML front matter of content into a map, or
+// returns nil when content has none. The YAML supported is what front matter
+// uses in practice: block mappings and sequences, flow [lists] and {maps},
+// plain, quoted and block (| and >) scalars, and comments. Plain scalars
+// become null, booleans, integers, floats or strings; dates stay strings.
+// Anchors, tags and multiple documents are not supported.
+func ParseFrontMatter(content string) (map[string]interface{}, error) {
+	frontMatter, _, ok := SplitFrontMatter(content)
+	if !ok {
+		return nil, nil
+	}
+
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(frontMatter, "\r\n", "\n"), "\n")}
+	if p.skipBlank() {
+		return map[string]interface{}{}, nil
+	}
+	if indent, _ := p.line(); indent != 0 {
+		return nil, p.errorf("unexpected indentation")
+	}
+	value, err := p.parseNode(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.skipBlank() {
+		return nil, p.errorf("unexpected content")
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid front matter: must be a mapping of keys to values")
+	}
+	return object, nil
+}
+
+// frontMatterJSON parses content's front matter for storage, returning nil
+// when the content type has none or the content does not start with it
+func frontMatterJSON(content, contentType string, isBinary bool) ([]byte, error) {
+	if !hasFrontMatter(contentType, isBinary) {
+		return nil, nil
+	}
+	frontMatter, err := ParseFrontMatter(content)
+	if err != nil || frontMatter == nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(frontMatter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+	return encoded, nil
+}
+
+// setFrontMatter stores parsed front matter in a document's metadata, or
+// removes what was stored when frontMatter is nil
+func setFrontMatter(db execer, id string, frontMatter []byte) error {
+	var err error
+	if frontMatter == nil {
+		_, err = db.Exec(`UPDATE _wce_documents SET metadata = json_remove(metadata, '$.front_matter') WHERE id = ?`, id)
+	} else {
+		_, err = db.Exec(`UPDATE _wce_documents SET metadata = json_set(metadata, '$.front_matter', json(?)) WHERE id = ?`, string(frontMatter), id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store front matter: %w", err)
+	}
+	return nil
+}
+
+// splitMetadata moves front matter stored in a document's metadata to
+// FrontMatter
+func (doc *Document) splitMetadata() {
+	if !bytes.Contains(doc.Metadata, []byte(`"`+frontMatterKey+`"`)) {
+		return
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(doc.Metadata, &object); err != nil {
+		return
+	}
+	frontMatter, ok := object[frontMatterKey]
+	if !ok {
+		return
+	}
+	delete(object, frontMatterKey)
+	metadata, err := json.Marshal(object)
+	if err != nil {
+		return
+	}
+	doc.Metadata = metadata
+	doc.FrontMatter = frontMatter
+}
+
+// yamlParser parses the block structure of front matter line by line
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid front matter: line %d: %s", p.pos+2, fmt.Sprintf(format, args...))
+}
+
+// skipBlank moves past blank and comment-only lines, reporting whether the
+// end was reached
+func (p *yamlParser) skipBlank() bool {
+	for ; p.pos < len(p.lines); p.pos++ {
+		if text := strings.TrimSpace(p.lines[p.pos]); text != "" && text[0] != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// line returns the indentation and text, without comment, of the current
+// line. Indenting with tabs leaves a text that is never a key or item.
+func (p *yamlParser) line() (int, string) {
+	raw := p.lines[p.pos]
+	text := strings.TrimLeft(raw, " ")
+	return len(raw) - len(text), stripComment(text)
+}
+
+// parseNode parses the block node starting at the next line, which must be
+// indented at least indent; a less indented line means the node is empty
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if p.skipBlank() {
+		return nil, nil
+	}
+	lineIndent, text := p.line()
+	if lineIndent < indent {
+		return nil, nil
+	}
+	if isSequenceItem(text) {
+		return p.parseSequence(lineIndent)
+	}
+	if _, _, ok := splitKey(text); ok {
+		return p.parseMapping(lineIndent)
+	}
+	p.pos++
+	return p.parseScalarLines(text, indent-1)
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+	for !p.skipBlank() {
+		lineIndent, text := p.line()
+		if lineIndent < indent {
+			break
+		}
+		if lineIndent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		key, rest, ok := splitKey(text)
+		if !ok {
+			return nil, p.errorf("expected a key: value pair, got %q", text)
+		}
+		if _, dup := object[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		var value interface{}
+		var err error
+		switch {
+		case rest != "":
+			value, err = p.parseValue(rest, indent)
+		case p.sequenceAt(indent):
+			// A sequence may sit at its key's indentation
+			value, err = p.parseSequence(indent)
+		default:
+			value, err = p.parseNode(indent + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+	}
+	return object, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	list := []interface{}{}
+	for !p.skipBlank() {
+		lineIndent, text := p.line()
+		if lineIndent < indent {
+			break
+		}
+		if lineIndent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if !isSequenceItem(text) {
+			break
+		}
+
+		item := strings.TrimLeft(text[1:], " ")
+		if item == "" {
+			p.pos++
+			value, err := p.parseNode(indent + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			continue
+		}
+
+		// A nested mapping or sequence starts on the item's own line: parse
+		// it as if it began on a line of its own at the item's column
+		if _, _, ok := splitKey(item); ok || isSequenceItem(item) {
+			column := lineIndent + len(text) - len(item)
+			p.lines[p.pos] = strings.Repeat(" ", column) + strings.TrimLeft(p.lines[p.pos][lineIndent+1:], " ")
+			value, err := p.parseNode(column)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			continue
+		}
+
+		p.pos++
+		value, err := p.parseValue(item, indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+// parseValue parses the value following a key or sequence dash on a line
+// of the given indentation, which may continue on more indented lines
+func (p *yamlParser) parseValue(text string, indent int) (interface{}, error) {
+	switch text[0] {
+	case '|', '>':
+		return p.parseBlockScalar(text, indent)
+	case '[', '{':
+		f := &flowParser{s: text}
+		value, err := f.parseValue()
+		if err == nil && f.skipSpaces() < len(f.s) {
+			err = fmt.Errorf("unexpected %q after flow collection", f.s[f.pos:])
+		}
+		if err != nil {
+			p.pos--
+			return nil, p.errorf("%v", err)
+		}
+		return value, nil
+	case '&', '*', '!':
+		p.pos--
+		return nil, p.errorf("anchors, aliases and tags are not supported")
+	}
+	return p.parseScalarLines(text, indent)
+}
+
+// parseScalarLines parses a scalar, joining plain scalars continued on
+// lines indented more than indent with spaces
+func (p *yamlParser) parseScalarLines(text string, indent int) (interface{}, error) {
+	if text[0] == '"' || text[0] == '\'' {
+		value, end, err := parseQuoted(text)
+		if err == nil && strings.TrimSpace(text[end:]) != "" {
+			err = fmt.Errorf("unexpected %q after quoted string", text[end:])
+		}
+		if err != nil {
+			p.pos--
+			return nil, p.errorf("%v", err)
+		}
+		return value, nil
+	}
+
+	continued := false
+	for !p.skipBlank() {
+		lineIndent, next := p.line()
+		if lineIndent <= indent {
+			break
+		}
+		if _, _, ok := splitKey(next); ok {
+			return nil, p.errorf("unexpected indentation")
+		}
+		text += " " + next
+		continued = true
+		p.pos++
+	}
+	if continued {
+		return text, nil
+	}
+	return resolvePlain(text), nil
+}
+
+// parseBlockScalar parses a literal (|) or folded (>) block scalar whose
+// lines are indented more than indent
+func (p *yamlParser) parseBlockScalar(header string, indent int) (string, error) {
+	chomp := header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		p.pos--
+		return "", p.errorf("unsupported block scalar header %q", header)
+	}
+
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := strings.TrimRight(p.lines[p.pos], " \t")
+		text := strings.TrimLeft(raw, " ")
+		lineIndent := len(raw) - len(text)
+		if text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if blockIndent < 0 {
+			if lineIndent <= indent {
+				break
+			}
+			blockIndent = lineIndent
+		}
+		if lineIndent < blockIndent {
+			break
+		}
+		lines = append(lines, raw[blockIndent:])
+	}
+
+	// Trailing blank lines belong to the block only for keep chomping, and
+	// are left for the next node otherwise
+	trailing := 0
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+	lines = lines[:len(lines)-trailing]
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	var value string
+	if header[0] == '|' {
+		value = strings.Join(lines, "\n")
+	} else {
+		// Folding joins lines with spaces; a blank line stands for a line
+		// break, and more indented lines keep theirs
+		var b strings.Builder
+		for i, line := range lines {
+			if i > 0 {
+				prev := lines[i-1]
+				switch {
+				case prev == "":
+					b.WriteByte('\n')
+				case line == "":
+					if prev[0] == ' ' {
+						b.WriteByte('\n')
+					}
+				case prev[0] == ' ' || line[0] == ' ':
+					b.WriteByte('\n')
+				default:
+					b.WriteByte(' ')
+				}
+			}
+			b.WriteString(line)
+		}
+		value = b.String()
+	}
+
+	switch chomp {
+	case "-":
+		return value, nil
+	case "+":
+		return value + strings.Repeat("\n", trailing+1), nil
+	}
+	return value + "\n", nil
+}
+
+// sequenceAt reports whether the next line is a sequence item at indent
+func (p *yamlParser) sequenceAt(indent int) bool {
+	if p.skipBlank() {
+		return false
+	}
+	lineIndent, text := p.line()
+	return lineIndent == indent && isSequenceItem(text)
+}
+
+// isSequenceItem reports whether a line is a "- item" sequence entry
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a "key: value" line, the key plain or quoted
+func splitKey(text string) (key, rest string, ok bool) {
+	if text == "" {
+		return "", "", false
+	}
+	var end int
+	switch text[0] {
+	case '"', '\'':
+		value, n, err := parseQuoted(text)
+		if err != nil {
+			return "", "", false
+		}
+		key, end = value, n
+		for end < len(text) && text[end] == ' ' {
+			end++
+		}
+		if end >= len(text) || text[end] != ':' {
+			return "", "", false
+		}
+	case '[', '{', '-', '|', '>', '#', '&', '*', '!', '\t':
+		if text[0] != '-' || len(text) < 2 || text[1] == ' ' {
+			return "", "", false
+		}
+		fallthrough
+	default:
+		end = strings.Index(text, ": ")
+		if end < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			end = len(text) - 1
+		}
+		key = strings.TrimRight(text[:end], " ")
+	}
+	if end+1 < len(text) && text[end+1] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[end+1:]), true
+}
+
+// stripComment removes a trailing "# comment" that is outside quotes
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" :[{,-", text[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return strings.TrimRight(text[:i], " \t")
+		}
+	}
+	return strings.TrimRight(text, " \t")
+}
+
+// parseQuoted parses the double- or single-quoted string at the start of
+// text, returning its value and the index after the closing quote
+func parseQuoted(text string) (string, int, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			if quote == '\'' {
+				return strings.ReplaceAll(text[1:i], "''", "'"), i + 1, nil
+			}
+			value, err := strconv.Unquote(strings.ReplaceAll(text[:i+1], `\/`, "/"))
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid double-quoted string %s", text[:i+1])
+			}
+			return value, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolvePlain gives a plain scalar its YAML core schema type
+func resolvePlain(text string) interface{} {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlInt.MatchString(text) {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+	}
+	if yamlFloat.MatchString(text) {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	}
+	return text
+}
+
+// flowParser parses a single-line flow collection such as [a, "b", {c: 1}]
+type flowParser struct {
+	s   string
+	pos int
+}
+
+func (f *flowParser) skipSpaces() int {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+	return f.pos
+}
+
+func (f *flowParser) parseValue() (interface{}, error) {
+	if f.skipSpaces() >= len(f.s) {
+		return nil, fmt.Errorf("unterminated flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		f.pos++
+		list := []interface{}{}
+		for {
+			if f.skipSpaces() < len(f.s) && f.s[f.pos] == ']' {
+				f.pos++
+				return list, nil
+			}
+			value, err := f.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		object := map[string]interface{}{}
+		for {
+			if f.skipSpaces() < len(f.s) && f.s[f.pos] == '}' {
+				f.pos++
+				return object, nil
+			}
+			key, err := f.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if f.skipSpaces() >= len(f.s) || f.s[f.pos] != ':' {
+				return nil, fmt.Errorf("expected ':' after key in flow mapping")
+			}
+			f.pos++
+			var value interface{}
+			if f.skipSpaces() < len(f.s) && f.s[f.pos] != ',' && f.s[f.pos] != '}' {
+				if value, err = f.parseValue(); err != nil {
+					return nil, err
+				}
+			}
+			object[fmt.Sprint(key)] = value
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		value, n, err := parseQuoted(f.s[f.pos:])
+		if err != nil {
+			return nil, err
+		}
+		f.pos += n
+		return value, nil
+	}
+
+	start := f.pos
+	for f.pos < len(f.s) && strings.IndexByte(",[]{}", f.s[f.pos]) < 0 {
+		if f.s[f.pos] == ':' && (f.pos+1 == len(f.s) || strings.IndexByte(" ,]}", f.s[f.pos+1]) >= 0) {
+			break
+		}
+		f.pos++
+	}
+	return resolvePlain(strings.TrimSpace(f.s[start:f.pos])), nil
+}
+
+// separator consumes the comma between flow entries, leaving the closing
+// bracket for the caller
+func (f *flowParser) separator(closing byte) error {
+	if f.skipSpaces() >= len(f.s) {
+		return fmt.Errorf("unterminated flow collection")
+	}
+	switch f.s[f.pos] {
+	case ',':
+		f.pos++
+		return nil
+	case closing:
+		return nil
+	}
+	return fmt.Errorf("unexpected %q in flow collection", f.s[f.pos])
+}
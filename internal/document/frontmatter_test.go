@@ -0,0 +1,170 @@
+package document
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	content := `---
+title: "Getting: started" # the title
+date: 2025-03-01
+draft: false
+weight: 10
+ratio: 1.5
+empty:
+author: {name: Alice, email: 'a''s@example.com'}
+tags: [go, "web dev", 3]
+aliases:
+- /old
+- /older
+authors:
+  - name: Bob
+    roles: [editor]
+  - - nested
+summary: >
+  Folded
+  onto one line
+
+  and another
+notes: |-
+  line one
+    indented
+description: a plain
+  scalar over lines
+---
+# Body
+`
+	got, err := ParseFrontMatter(content)
+	if err != nil {
+		t.Fatalf("ParseFrontMatter failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"title":   "Getting: started",
+		"date":    "2025-03-01",
+		"draft":   false,
+		"weight":  int64(10),
+		"ratio":   1.5,
+		"empty":   nil,
+		"author":  map[string]interface{}{"name": "Alice", "email": "a's@example.com"},
+		"tags":    []interface{}{"go", "web dev", int64(3)},
+		"aliases": []interface{}{"/old", "/older"},
+		"authors": []interface{}{
+			map[string]interface{}{"name": "Bob", "roles": []interface{}{"editor"}},
+			[]interface{}{"nested"},
+		},
+		"summary":     "Folded onto one line\nand another\n",
+		"notes":       "line one\n  indented",
+		"description": "a plain scalar over lines",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseFrontMatter =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestParseFrontMatter_None(t *testing.T) {
+	for _, content := range []string{"# Title\n", "---\nunclosed: true\n", " ---\na: 1\n---\n", ""} {
+		got, err := ParseFrontMatter(content)
+		if err != nil || got != nil {
+			t.Errorf("ParseFrontMatter(%q) = %v, %v; want no front matter", content, got, err)
+		}
+	}
+
+	got, err := ParseFrontMatter("---\n---\nbody")
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("Expected empty front matter, got %v, %v", got, err)
+	}
+}
+
+func TestParseFrontMatter_Invalid(t *testing.T) {
+	tests := []string{
+		"---\njust a scalar\n---\n",
+		"---\n- a list\n---\n",
+		"---\na: 1\na: 2\n---\n",
+		"---\na: 1\n  b: 2\n---\n",
+		"---\ntitle: \"unterminated\n---\n",
+		"---\ntags: [a, b\n---\n",
+		"---\nbase: &anchor 1\n---\n",
+		"---\n\ttabbed: 1\n---\n",
+	}
+	for _, content := range tests {
+		if got, err := ParseFrontMatter(content); err == nil {
+			t.Errorf("ParseFrontMatter(%q) = %v, expected an error", content, got)
+		} else if !strings.HasPrefix(err.Error(), "invalid front matter") {
+			t.Errorf("Unexpected error for %q: %v", content, err)
+		}
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	frontMatter, body, ok := SplitFrontMatter("---\r\na: 1\r\n...\r\nbody\n")
+	if !ok || frontMatter != "a: 1\r\n" || body != "body\n" {
+		t.Errorf("SplitFrontMatter = %q, %q, %v", frontMatter, body, ok)
+	}
+	if _, body, ok := SplitFrontMatter("---\na: 1\n---"); !ok || body != "" {
+		t.Errorf("Expected front matter closed at end of content, got %q, %v", body, ok)
+	}
+}
+
+func TestDocumentFrontMatter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	content := "---\ntitle: Hello\nauthor: alice\n---\n# Hello\n"
+	doc, err := CreateDocument(db, "posts/hello", content, "text/markdown", "user-1", false, true)
+	if err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if string(doc.FrontMatter) != `{"author":"alice","title":"Hello"}` {
+		t.Errorf("Unexpected front matter on create: %s", doc.FrontMatter)
+	}
+
+	// Plain text is not parsed
+	if doc, err := CreateDocument(db, "notes.txt", content, "text/plain", "user-1", false, true); err != nil || doc.FrontMatter != nil {
+		t.Errorf("Expected no front matter for text/plain, got %s, %v", doc.FrontMatter, err)
+	}
+	if _, err := CreateDocument(db, "posts/bad", "---\na: [\n---\n", "text/markdown", "user-1", false, true); err == nil {
+		t.Error("Expected invalid front matter to be rejected")
+	}
+
+	// Metadata updates keep the parsed front matter and cannot replace it
+	doc, err = SetDocumentMetadata(db, "posts/hello", json.RawMessage(`{"series":"intro","front_matter":{"title":"Forged"}}`), "user-1")
+	if err != nil {
+		t.Fatalf("SetDocumentMetadata failed: %v", err)
+	}
+	if string(doc.Metadata) != `{"series":"intro"}` {
+		t.Errorf("Unexpected metadata: %s", doc.Metadata)
+	}
+	var frontMatter map[string]interface{}
+	json.Unmarshal(doc.FrontMatter, &frontMatter)
+	if frontMatter["title"] != "Hello" {
+		t.Errorf("Expected front matter to be kept, got %s", doc.FrontMatter)
+	}
+
+	// Front matter is filterable like other metadata
+	docs, err := ListDocumentsFiltered(db, "", []MetadataFilter{{Key: "front_matter.author", Value: "alice"}}, false, 10, 0)
+	if err != nil || len(docs) != 1 || docs[0].ID != "posts/hello" || docs[0].FrontMatter == nil {
+		t.Errorf("Expected filter on front matter to find posts/hello, got %v, %v", docs, err)
+	}
+
+	// Updating content re-parses, and removing front matter clears it
+	if doc, err = UpdateDocument(db, "posts/hello", "---\ntitle: Changed\n---\n", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if string(doc.FrontMatter) != `{"title":"Changed"}` {
+		t.Errorf("Unexpected front matter after update: %s", doc.FrontMatter)
+	}
+	if _, err := UpdateDocument(db, "posts/hello", "# No front matter\n", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	doc, err = GetDocument(db, "posts/hello")
+	if err != nil {
+		t.Fatalf("GetDocument failed: %v", err)
+	}
+	if doc.FrontMatter != nil || string(doc.Metadata) != `{"series":"intro"}` {
+		t.Errorf("Expected front matter to be cleared, got %s and metadata %s", doc.FrontMatter, doc.Metadata)
+	}
+}
@@ -0,0 +1,61 @@
+package document
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// ContentHash returns the hex SHA-256 of a document's stored content. Writes
+// through this package store the hash with the content, so this normally
+// returns the document's Hash. Rows written some other way, such as through
+// raw SQL, have none: their content is hashed once and the result stored.
+func ContentHash(ctx context.Context, db *sql.DB, doc *Document) (string, error) {
+	if doc.Hash != "" {
+		return doc.Hash, nil
+	}
+
+	hash, err := hashStoredContent(ctx, db, doc)
+	if err != nil {
+		return "", err
+	}
+
+	// Only fill in a missing hash, and only for the version that was hashed
+	_, err = db.ExecContext(ctx, `
+		UPDATE _wce_documents SET content_hash = ? WHERE id = ? AND version = ? AND content_hash IS NULL
+	`, hash, doc.ID, doc.Version)
+	if err != nil {
+		return "", fmt.Errorf("failed to store document hash: %w", err)
+	}
+	doc.Hash = hash
+	return hash, nil
+}
+
+// hashStoredContent hashes a document's content. Inline content is hashed as
+// stored; streamed content is read one chunk at a time.
+func hashStoredContent(ctx context.Context, db *sql.DB, doc *Document) (string, error) {
+	if !doc.IsBlob() {
+		return hashContent(doc.Content), nil
+	}
+
+	reader, err := OpenBlob(ctx, db, doc.ID)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", fmt.Errorf("failed to hash document content: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashContent returns the hex SHA-256 of inline content
+func hashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,220 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// ImportOptions control how ImportZip maps archive entries to documents
+type ImportOptions struct {
+	Prefix    string // Folder the file paths are placed under, e.g. 'site/'
+	Overwrite bool   // Replace the content of existing documents instead of skipping them
+	DryRun    bool   // Report what would happen without writing anything
+	MaxSize   int64  // Largest file accepted, in bytes; 0 means no limit
+
+	// OnWrite, when set, is called with each document created or updated
+	OnWrite func(doc *Document)
+}
+
+// Import actions reported per file
+const (
+	ImportCreate = "create"
+	ImportUpdate = "update"
+	ImportSkip   = "skip"
+	ImportError  = "error"
+)
+
+// ImportedFile is the outcome of importing one archive entry
+type ImportedFile struct {
+	Path        string `json:"path"`
+	ID          string `json:"id,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	IsBinary    bool   `json:"is_binary"`
+	Size        int64  `json:"size"`
+	Action      string `json:"action"` // 'create', 'update', 'skip' or 'error'
+	Reason      string `json:"reason,omitempty"`
+}
+
+// ImportReport summarizes an archive import. In a dry run the actions are
+// those that would have been taken.
+type ImportReport struct {
+	DryRun     bool           `json:"dry_run"`
+	RolledBack bool           `json:"rolled_back,omitempty"` // Set when an atomic import wrote nothing
+	Created    int            `json:"created"`
+	Updated    int            `json:"updated"`
+	Skipped    int            `json:"skipped"`
+	Failed     int            `json:"failed"`
+	Files      []ImportedFile `json:"files"`
+}
+
+// ImportZip creates one document per file in a ZIP archive. The file path,
+// after opts.Prefix, becomes the document id and the extension the content
+// type. Files that are not valid UTF-8 text are stored base64-encoded as
+// binary documents; text files are searchable. Each file is written on its
+// own, so a failure part way leaves the files before it imported; the
+// report lists the outcome of every entry.
+func ImportZip(db DB, archive *zip.Reader, userID string, opts ImportOptions) (*ImportReport, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun, Files: []ImportedFile{}}
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || isArchiveJunk(entry.Name) {
+			continue
+		}
+
+		file := importFile(db, entry, userID, opts)
+		switch file.Action {
+		case ImportCreate:
+			report.Created++
+		case ImportUpdate:
+			report.Updated++
+		case ImportSkip:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+		report.Files = append(report.Files, file)
+	}
+	return report, nil
+}
+
+// importFile imports a single archive entry
+func importFile(db DB, entry *zip.File, userID string, opts ImportOptions) ImportedFile {
+	file := ImportedFile{Path: entry.Name, Size: int64(entry.UncompressedSize64)}
+	fail := func(reason string) ImportedFile {
+		file.Action = ImportError
+		file.Reason = reason
+		return file
+	}
+
+	id, err := importID(opts.Prefix, entry.Name)
+	if err != nil {
+		return fail(err.Error())
+	}
+	file.ID = id
+
+	data, err := readEntry(entry, opts.MaxSize)
+	if err != nil {
+		return fail(err.Error())
+	}
+	file.Size = int64(len(data))
+	if len(data) == 0 {
+		file.Action = ImportSkip
+		file.Reason = "file is empty"
+		return file
+	}
+
+	file.ContentType = importContentType(entry.Name, data)
+	file.IsBinary = !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0
+	content := string(data)
+	if file.IsBinary {
+		content = base64.StdEncoding.EncodeToString(data)
+	}
+
+	existing, err := GetDocument(db, id)
+	switch {
+	case err != nil && !strings.Contains(err.Error(), "not found"):
+		return fail(err.Error())
+	case existing == nil:
+		file.Action = ImportCreate
+	case !opts.Overwrite:
+		file.Action = ImportSkip
+		file.Reason = "document already exists"
+		return file
+	case existing.IsBinary != file.IsBinary || existing.IsBlob():
+		return fail("existing document has a different storage type")
+	default:
+		file.Action = ImportUpdate
+	}
+
+	if opts.DryRun {
+		return file
+	}
+
+	var doc *Document
+	if file.Action == ImportCreate {
+		doc, err = CreateDocument(db, id, content, file.ContentType, userID, file.IsBinary, !file.IsBinary)
+	} else {
+		doc, err = UpdateDocument(db, id, content, userID)
+	}
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	if opts.OnWrite != nil {
+		opts.OnWrite(doc)
+	}
+	return file
+}
+
+// importID maps an archive path to a document id, rejecting paths that
+// would escape the prefix
+func importID(prefix, name string) (string, error) {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return "", fmt.Errorf("invalid path in archive")
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid path in archive")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + cleaned, nil
+}
+
+// readEntry reads an archive entry, refusing entries that decompress to
+// more than maxSize bytes whatever size their header claims
+func readEntry(entry *zip.File, maxSize int64) ([]byte, error) {
+	if maxSize > 0 && entry.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("file exceeds the maximum document size")
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file in archive: %w", err)
+	}
+	defer rc.Close()
+
+	var reader io.Reader = rc
+	if maxSize > 0 {
+		reader = io.LimitReader(rc, maxSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file in archive: %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file exceeds the maximum document size")
+	}
+	return data, nil
+}
+
+// importContentType picks a content type from the file extension, falling
+// back to sniffing the content. Parameters such as charset are dropped.
+func importContentType(name string, data []byte) string {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// isArchiveJunk reports whether an entry is metadata added by archivers, or
+// the manifest of an export, rather than a file of the site
+func isArchiveJunk(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || path.Base(name) == ".DS_Store" || name == ExportManifestName
+}
@@ -0,0 +1,109 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// buildZip returns a ZIP archive holding files, in the order given
+func buildZip(t *testing.T, files [][2]string) *zip.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		f, err := zw.Create(file[0])
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", file[0], err)
+		}
+		f.Write([]byte(file[1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	return archive
+}
+
+func TestImportZip(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "site/about.html", "<p>old</p>", "text/html", "user-1", false, true)
+
+	archive := buildZip(t, [][2]string{
+		{"index.html", "<h1>Home</h1>"},
+		{"css/site.css", "body { margin: 0 }"},
+		{"img/logo.png", "\x89PNG\r\n\x1a\n\x00\x00"},
+		{"about.html", "<p>new</p>"},
+		{"empty.txt", ""},
+		{"../escape.txt", "nope"},
+		{"__MACOSX/._index.html", "junk"},
+	})
+
+	report, err := ImportZip(db, archive, "user-1", ImportOptions{Prefix: "site", DryRun: true})
+	if err != nil {
+		t.Fatalf("ImportZip failed: %v", err)
+	}
+	if report.Created != 3 || report.Skipped != 2 || report.Failed != 1 || len(report.Files) != 6 {
+		t.Fatalf("Unexpected dry run report: %+v", report)
+	}
+	if _, err := GetDocument(db, "site/index.html"); err == nil {
+		t.Fatal("Expected dry run to write nothing")
+	}
+
+	report, err = ImportZip(db, archive, "user-1", ImportOptions{Prefix: "site/", Overwrite: true})
+	if err != nil {
+		t.Fatalf("ImportZip failed: %v", err)
+	}
+	if report.Created != 3 || report.Updated != 1 || report.Failed != 1 {
+		t.Fatalf("Unexpected import report: %+v", report)
+	}
+
+	doc, err := GetDocument(db, "site/index.html")
+	if err != nil || doc.ContentType != "text/html" || doc.IsBinary || !doc.Searchable {
+		t.Errorf("Unexpected imported page: %+v (%v)", doc, err)
+	}
+	doc, err = GetDocument(db, "site/css/site.css")
+	if err != nil || doc.ContentType != "text/css" {
+		t.Errorf("Unexpected imported stylesheet: %+v (%v)", doc, err)
+	}
+	doc, err = GetDocument(db, "site/img/logo.png")
+	if err != nil || !doc.IsBinary || doc.ContentType != "image/png" {
+		t.Fatalf("Expected logo to import as a binary image, got %+v (%v)", doc, err)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(doc.Content); string(data) != "\x89PNG\r\n\x1a\n\x00\x00" {
+		t.Errorf("Binary content did not round-trip: %q", data)
+	}
+	if doc, _ := GetDocument(db, "site/about.html"); doc.Content != "<p>new</p>" || doc.Version != 2 {
+		t.Errorf("Expected existing document to be overwritten, got %+v", doc)
+	}
+}
+
+func TestImportZipMaxSize(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	archive := buildZip(t, [][2]string{{"big.txt", "0123456789"}, {"small.txt", "ok"}})
+
+	var written []string
+	report, err := ImportZip(db, archive, "user-1", ImportOptions{
+		MaxSize: 5,
+		OnWrite: func(doc *Document) { written = append(written, doc.ID) },
+	})
+	if err != nil {
+		t.Fatalf("ImportZip failed: %v", err)
+	}
+	if report.Failed != 1 || report.Files[0].Action != ImportError || report.Created != 1 {
+		t.Errorf("Expected oversized file to fail, got %+v", report)
+	}
+	if len(written) != 1 || written[0] != "small.txt" {
+		t.Errorf("Expected OnWrite for the imported file only, got %v", written)
+	}
+}
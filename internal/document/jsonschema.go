@@ -0,0 +1,591 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON Schema support covers the validation vocabulary used in practice:
+// type, enum, const, properties, patternProperties, additionalProperties,
+// required, min/maxProperties, items, min/maxItems, uniqueItems, min/maxLength,
+// pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+// allOf, anyOf, oneOf, not, and $ref to "#..." pointers within the schema
+// ($defs or definitions). Other keywords, such as format, are annotations and
+// are ignored. Patterns use Go's RE2 syntax.
+
+// maxSchemaDepth bounds validation recursion, so a $ref cycle that never
+// descends into the content fails instead of looping
+const maxSchemaDepth = 128
+
+// maxValidationErrors caps the errors reported for one document
+const maxValidationErrors = 50
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// ValidationError is one way content fails its schema. Path is a JSON
+// Pointer into the content; "" is the whole document.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaError reports content that does not match its JSON Schema
+type SchemaError struct {
+	SchemaID string
+	Errors   []ValidationError
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, ve := range e.Errors {
+		path := ve.Path
+		if path == "" {
+			path = "(root)"
+		}
+		messages[i] = path + ": " + ve.Message
+	}
+	return fmt.Sprintf("content does not match schema %s: %s", e.SchemaID, strings.Join(messages, "; "))
+}
+
+// jsonSchema is a checked schema ready for validation
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// compileSchema parses a JSON Schema and checks its keywords, patterns and
+// references
+func compileSchema(raw []byte) (*jsonSchema, error) {
+	root, err := decodeJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+
+	s := &jsonSchema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := s.check(root, "#"); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return s, nil
+}
+
+// ValidateJSON checks content against a JSON Schema. It returns the
+// validation errors, or an error if the schema itself is invalid.
+func ValidateJSON(schema, content []byte) ([]ValidationError, error) {
+	s, err := compileSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	return s.validateContent(content), nil
+}
+
+// validateContent decodes content and checks it against the schema
+func (s *jsonSchema) validateContent(content []byte) []ValidationError {
+	instance, err := decodeJSON(content)
+	if err != nil {
+		return []ValidationError{{Path: "", Message: "content is not valid JSON: " + err.Error()}}
+	}
+
+	var errs []ValidationError
+	s.validate(s.root, instance, "", 0, &errs)
+	return errs
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers exact
+func decodeJSON(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// check verifies the schema at node, found at the JSON Pointer fragment at
+func (s *jsonSchema) check(node interface{}, at string) error {
+	if _, ok := node.(bool); ok {
+		return nil
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: schema must be an object or boolean", at)
+	}
+
+	for key, value := range m {
+		where := at + "/" + escapePointer(key)
+		switch key {
+		case "type":
+			types, ok := stringList(value)
+			if !ok || len(types) == 0 {
+				return fmt.Errorf("%s: must be a type name or a list of them", where)
+			}
+			for _, t := range types {
+				if !schemaTypes[t] {
+					return fmt.Errorf("%s: unknown type %q", where, t)
+				}
+			}
+		case "enum":
+			if _, ok := value.([]interface{}); !ok {
+				return fmt.Errorf("%s: must be an array", where)
+			}
+		case "required":
+			if _, isArray := value.([]interface{}); !isArray {
+				return fmt.Errorf("%s: must be an array of strings", where)
+			}
+			if _, ok := stringList(value); !ok {
+				return fmt.Errorf("%s: must be an array of strings", where)
+			}
+		case "properties", "patternProperties", "$defs", "definitions":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: must be an object", where)
+			}
+			for name, sub := range props {
+				if key == "patternProperties" {
+					if err := s.compilePattern(name, where); err != nil {
+						return err
+					}
+				}
+				if err := s.check(sub, where+"/"+escapePointer(name)); err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "items", "not":
+			if err := s.check(value, where); err != nil {
+				return err
+			}
+		case "allOf", "anyOf", "oneOf":
+			subs, ok := value.([]interface{})
+			if !ok || len(subs) == 0 {
+				return fmt.Errorf("%s: must be a non-empty array of schemas", where)
+			}
+			for i, sub := range subs {
+				if err := s.check(sub, where+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if n, ok := number(value); !ok || n < 0 || n != math.Trunc(n) {
+				return fmt.Errorf("%s: must be a non-negative integer", where)
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			if _, ok := number(value); !ok {
+				return fmt.Errorf("%s: must be a number", where)
+			}
+		case "multipleOf":
+			if n, ok := number(value); !ok || n <= 0 {
+				return fmt.Errorf("%s: must be a number greater than 0", where)
+			}
+		case "uniqueItems":
+			if _, ok := value.(bool); !ok {
+				return fmt.Errorf("%s: must be a boolean", where)
+			}
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s: must be a string", where)
+			}
+			if err := s.compilePattern(pattern, where); err != nil {
+				return err
+			}
+		case "$ref":
+			ref, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s: must be a string", where)
+			}
+			if _, err := s.resolve(ref); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *jsonSchema) compilePattern(pattern, where string) error {
+	if _, ok := s.patterns[pattern]; ok {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("%s: invalid pattern %q: %w", where, pattern, err)
+	}
+	s.patterns[pattern] = re
+	return nil
+}
+
+// resolve follows a "#/..." reference from the schema root
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("only references within the schema are supported: %q", ref)
+	}
+
+	node := s.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer == "" {
+		return node, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("unresolvable reference %q", ref)
+	}
+
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch current := node.(type) {
+		case map[string]interface{}:
+			next, ok := current[token]
+			if !ok {
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(current) {
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+			node = current[i]
+		default:
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+	}
+	return node, nil
+}
+
+// validate checks instance, found at path in the content, against the schema
+// node, appending any failures to errs
+func (s *jsonSchema) validate(node, instance interface{}, path string, depth int, errs *[]ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		if len(*errs) < maxValidationErrors {
+			*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	if depth > maxSchemaDepth {
+		fail("schema nesting too deep")
+		return
+	}
+
+	if allowed, ok := node.(bool); ok {
+		if !allowed {
+			fail("is not allowed")
+		}
+		return
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		// A $ref resolved to something other than a schema
+		fail("schema is not an object or boolean")
+		return
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		if target, err := s.resolve(ref); err == nil {
+			s.validate(target, instance, path, depth+1, errs)
+		}
+	}
+
+	if value, ok := m["type"]; ok {
+		types, _ := stringList(value)
+		matched := false
+		for _, t := range types {
+			if hasType(instance, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must be of type %s, not %s", strings.Join(types, " or "), typeName(instance))
+		}
+	}
+
+	if values, ok := m["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range values {
+			if jsonEqual(instance, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compactJSON(values))
+		}
+	}
+
+	if value, ok := m["const"]; ok && !jsonEqual(instance, value) {
+		fail("must be %s", compactJSON(value))
+	}
+
+	switch v := instance.(type) {
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if n, ok := number(m["minLength"]); ok && length < n {
+			fail("must be at least %v characters long", n)
+		}
+		if n, ok := number(m["maxLength"]); ok && length > n {
+			fail("must be at most %v characters long", n)
+		}
+		if pattern, ok := m["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			fail("must match pattern %q", pattern)
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if n, ok := number(m["minimum"]); ok && f < n {
+			fail("must be >= %v", n)
+		}
+		if n, ok := number(m["maximum"]); ok && f > n {
+			fail("must be <= %v", n)
+		}
+		if n, ok := number(m["exclusiveMinimum"]); ok && f <= n {
+			fail("must be > %v", n)
+		}
+		if n, ok := number(m["exclusiveMaximum"]); ok && f >= n {
+			fail("must be < %v", n)
+		}
+		if n, ok := number(m["multipleOf"]); ok {
+			if q := f / n; math.IsInf(q, 0) || q != math.Trunc(q) {
+				fail("must be a multiple of %v", n)
+			}
+		}
+
+	case []interface{}:
+		count := float64(len(v))
+		if n, ok := number(m["minItems"]); ok && count < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := number(m["maxItems"]); ok && count > n {
+			fail("must have at most %v items", n)
+		}
+		if unique, _ := m["uniqueItems"].(bool); unique {
+		duplicates:
+			for i := range v {
+				for j := i + 1; j < len(v); j++ {
+					if jsonEqual(v[i], v[j]) {
+						fail("items %d and %d must be unique", i, j)
+						break duplicates
+					}
+				}
+			}
+		}
+		if items, ok := m["items"]; ok {
+			for i, item := range v {
+				s.validate(items, item, path+"/"+strconv.Itoa(i), depth+1, errs)
+			}
+		}
+
+	case map[string]interface{}:
+		count := float64(len(v))
+		if n, ok := number(m["minProperties"]); ok && count < n {
+			fail("must have at least %v properties", n)
+		}
+		if n, ok := number(m["maxProperties"]); ok && count > n {
+			fail("must have at most %v properties", n)
+		}
+		required, _ := stringList(m["required"])
+		for _, name := range required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+
+		properties, _ := m["properties"].(map[string]interface{})
+		patternProperties, _ := m["patternProperties"].(map[string]interface{})
+		additional, hasAdditional := m["additionalProperties"]
+
+		// Visit properties in a stable order so errors are reproducible
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := v[name]
+			childPath := path + "/" + escapePointer(name)
+			matched := false
+			if sub, ok := properties[name]; ok {
+				matched = true
+				s.validate(sub, value, childPath, depth+1, errs)
+			}
+			for pattern, sub := range patternProperties {
+				if s.patterns[pattern].MatchString(name) {
+					matched = true
+					s.validate(sub, value, childPath, depth+1, errs)
+				}
+			}
+			if !matched && hasAdditional {
+				if allowed, ok := additional.(bool); ok && !allowed {
+					fail("property %q is not allowed", name)
+				} else {
+					s.validate(additional, value, childPath, depth+1, errs)
+				}
+			}
+		}
+	}
+
+	if subs, ok := m["allOf"].([]interface{}); ok {
+		for _, sub := range subs {
+			s.validate(sub, instance, path, depth+1, errs)
+		}
+	}
+
+	if subs, ok := m["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range subs {
+			if s.matches(sub, instance, path, depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one schema in anyOf")
+		}
+	}
+
+	if subs, ok := m["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range subs {
+			if s.matches(sub, instance, path, depth) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", matches)
+		}
+	}
+
+	if sub, ok := m["not"]; ok && s.matches(sub, instance, path, depth) {
+		fail("must not match the schema in not")
+	}
+}
+
+// matches reports whether instance is valid against node, without recording errors
+func (s *jsonSchema) matches(node, instance interface{}, path string, depth int) bool {
+	var errs []ValidationError
+	s.validate(node, instance, path, depth+1, &errs)
+	return len(errs) == 0
+}
+
+// hasType reports whether instance is of the JSON Schema type t. Integers are
+// numbers with no fractional part, so 1.0 is an integer.
+func hasType(instance interface{}, t string) bool {
+	if t == "integer" {
+		n, ok := instance.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	}
+	return typeName(instance) == t
+}
+
+func typeName(instance interface{}) string {
+	switch instance.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// jsonEqual compares decoded JSON values, treating numbers by value
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, _ := av.Float64()
+		bf, _ := bv.Float64()
+		return af == bf
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			other, ok := bv[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// number returns a schema keyword's numeric value
+func number(value interface{}) (float64, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// stringList accepts a string or an array of strings
+func stringList(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, s)
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+func compactJSON(value interface{}) string {
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+// escapePointer escapes a key for use as a JSON Pointer token
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
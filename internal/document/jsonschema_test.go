@@ -0,0 +1,96 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	const person = `{
+		"type": "object",
+		"required": ["name", "age"],
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 2},
+			"role": {"enum": ["admin", "user"]},
+			"address": {"$ref": "#/$defs/address"}
+		},
+		"additionalProperties": false,
+		"$defs": {
+			"address": {"type": "object", "required": ["city"], "properties": {"city": {"type": "string"}}}
+		}
+	}`
+
+	tests := []struct {
+		name    string
+		schema  string
+		content string
+		want    []string // "path: message" prefixes, in order
+	}{
+		{"Valid", person, `{"name": "Ann", "age": 30, "tags": ["a", "b"], "role": "admin", "address": {"city": "Oslo"}}`, nil},
+		{"IntegerAsFloat", person, `{"name": "Ann", "age": 30.0}`, nil},
+		{"MissingRequired", person, `{"name": "Ann"}`, []string{`: missing required property "age"`}},
+		{"WrongType", person, `{"name": 7, "age": "old"}`, []string{"/age: must be of type integer, not string", "/name: must be of type string, not number"}},
+		{"Bounds", person, `{"name": "Annabel", "age": 150}`, []string{"/age: must be < 150", "/name: must be at most 5 characters long"}},
+		{"Pattern", person, `{"name": "Ann", "age": 1, "email": "nope"}`, []string{`/email: must match pattern`}},
+		{"Items", person, `{"name": "Ann", "age": 1, "tags": ["a", "a", 3]}`, []string{"/tags: must have at most 2 items", "/tags: items 0 and 1 must be unique", "/tags/2: must be of type string"}},
+		{"Enum", person, `{"name": "Ann", "age": 1, "role": "root"}`, []string{`/role: must be one of ["admin","user"]`}},
+		{"Ref", person, `{"name": "Ann", "age": 1, "address": {}}`, []string{`/address: missing required property "city"`}},
+		{"AdditionalProperties", person, `{"name": "Ann", "age": 1, "x": 1}`, []string{`: property "x" is not allowed`}},
+		{"NotJSON", person, `{"name":`, []string{": content is not valid JSON"}},
+		{"TrailingData", person, `{"name": "Ann", "age": 1} {}`, []string{": content is not valid JSON"}},
+		{"FalseSchema", `false`, `1`, []string{": is not allowed"}},
+		{"TypeList", `{"type": ["string", "null"]}`, `null`, nil},
+		{"Const", `{"const": {"a": [1, 2]}}`, `{"a": [1, 2.0]}`, nil},
+		{"MultipleOf", `{"multipleOf": 0.5}`, `1.25`, []string{": must be a multiple of 0.5"}},
+		{"AnyOf", `{"anyOf": [{"type": "string"}, {"minimum": 10}]}`, `5`, []string{": must match at least one schema in anyOf"}},
+		{"OneOf", `{"oneOf": [{"type": "number"}, {"minimum": 10}]}`, `20`, []string{": must match exactly one schema in oneOf, matched 2"}},
+		{"AllOfAndNot", `{"allOf": [{"type": "number"}], "not": {"maximum": 0}}`, `-1`, []string{": must not match the schema in not"}},
+		{"PatternProperties", `{"patternProperties": {"^n_": {"type": "number"}}, "additionalProperties": {"type": "string"}}`, `{"n_a": "x", "b": 1}`, []string{"/b: must be of type string", "/n_a: must be of type number"}},
+		{"EscapedPath", `{"properties": {"a/b": {"type": "string"}}}`, `{"a/b": 1}`, []string{"/a~1b: must be of type string"}},
+		{"RefCycle", `{"$ref": "#"}`, `1`, []string{": schema nesting too deep"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, err := ValidateJSON([]byte(tt.schema), []byte(tt.content))
+			if err != nil {
+				t.Fatalf("ValidateJSON failed: %v", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Expected %d errors, got %v", len(tt.want), errs)
+			}
+			for i, want := range tt.want {
+				if got := errs[i].Path + ": " + errs[i].Message; !strings.HasPrefix(got, want) {
+					t.Errorf("Error %d: expected %q, got %q", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJSON_InvalidSchema(t *testing.T) {
+	schemas := map[string]string{
+		"NotJSON":        `{`,
+		"NotObject":      `"string"`,
+		"UnknownType":    `{"type": "text"}`,
+		"BadPattern":     `{"pattern": "("}`,
+		"BadRequired":    `{"required": "name"}`,
+		"NegativeLength": `{"minLength": -1}`,
+		"ZeroMultipleOf": `{"multipleOf": 0}`,
+		"EmptyAnyOf":     `{"anyOf": []}`,
+		"RemoteRef":      `{"$ref": "https://example.com/schema.json"}`,
+		"MissingRef":     `{"$ref": "#/$defs/missing"}`,
+		"NestedBadType":  `{"properties": {"a": {"items": {"type": 1}}}}`,
+	}
+
+	for name, schema := range schemas {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ValidateJSON([]byte(schema), []byte(`{}`)); err == nil {
+				t.Errorf("Expected schema %s to be rejected", schema)
+			}
+		})
+	}
+}
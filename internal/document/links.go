@@ -0,0 +1,105 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// wikiLink matches [[target]], [[target|label]] and [[target#section]]
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]|#\n]+)(?:#[^\[\]|\n]*)?(?:\|[^\[\]\n]*)?\]\]`)
+
+// Link is an edge in the document link graph. Target may name a document
+// that does not exist yet.
+type Link struct {
+	SourceID     string `json:"source_id"`
+	TargetID     string `json:"target_id"`
+	TargetExists bool   `json:"target_exists"`
+}
+
+// ExtractLinks returns the distinct document ids content links to with
+// wiki-style [[id]] links, in order of first appearance
+func ExtractLinks(content string) []string {
+	var targets []string
+	seen := map[string]bool{}
+	for _, match := range wikiLink.FindAllStringSubmatch(content, -1) {
+		target := strings.Trim(strings.TrimSpace(match[1]), "/")
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// setLinks replaces a document's outbound links with those in content.
+// Binary documents have no links.
+func setLinks(db execer, sourceID, content string, isBinary bool) error {
+	if _, err := db.Exec(`DELETE FROM _wce_document_links WHERE source_id = ?`, sourceID); err != nil {
+		return fmt.Errorf("failed to clear document links: %w", err)
+	}
+	if isBinary {
+		return nil
+	}
+
+	for _, target := range ExtractLinks(content) {
+		if _, err := db.Exec(`
+			INSERT OR IGNORE INTO _wce_document_links (source_id, target_id) VALUES (?, ?)
+		`, sourceID, target); err != nil {
+			return fmt.Errorf("failed to store document link: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// GetOutboundLinks lists the documents id links to, ordered by target
+func GetOutboundLinks(db *sql.DB, id string) ([]Link, error) {
+	return queryLinks(db, `
+		SELECT l.source_id, l.target_id, d.id IS NOT NULL
+		FROM _wce_document_links l
+		LEFT JOIN _wce_documents d ON d.id = l.target_id
+		WHERE l.source_id = ?
+		ORDER BY l.target_id
+	`, id)
+}
+
+// GetBacklinks lists the documents that link to id, ordered by source
+func GetBacklinks(db *sql.DB, id string) ([]Link, error) {
+	return queryLinks(db, `
+		SELECT l.source_id, l.target_id, d.id IS NOT NULL
+		FROM _wce_document_links l
+		LEFT JOIN _wce_documents d ON d.id = l.target_id
+		WHERE l.target_id = ?
+		ORDER BY l.source_id
+	`, id)
+}
+
+func queryLinks(db *sql.DB, query, id string) ([]Link, error) {
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []Link{}
+	for rows.Next() {
+		var link Link
+		if err := rows.Scan(&link.SourceID, &link.TargetID, &link.TargetExists); err != nil {
+			return nil, fmt.Errorf("failed to scan document link: %w", err)
+		}
+		links = append(links, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating document links: %w", err)
+	}
+
+	return links, nil
+}
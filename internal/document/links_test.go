@@ -0,0 +1,107 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"None", "plain text with [single] brackets", nil},
+		{"Simple", "see [[docs/intro]]", []string{"docs/intro"}},
+		{"LabelAndSection", "[[docs/a|the A page]] and [[docs/b#setup]]", []string{"docs/a", "docs/b"}},
+		{"Deduplicated", "[[x]] [[ x ]] [[/x/]] [[y]]", []string{"x", "y"}},
+		{"Empty", "[[]] [[ |label]]", nil},
+		{"NoNewlines", "[[broken\nlink]]", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractLinks(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDocumentLinks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "wiki/home", "Start at [[wiki/guide]] or [[wiki/missing]]", "text/markdown", "user-1", false, true)
+	CreateDocument(db, "wiki/guide", "Back to [[wiki/home]]", "text/markdown", "user-1", false, true)
+
+	links, err := GetOutboundLinks(db, "wiki/home")
+	if err != nil {
+		t.Fatalf("GetOutboundLinks failed: %v", err)
+	}
+	want := []Link{
+		{SourceID: "wiki/home", TargetID: "wiki/guide", TargetExists: true},
+		{SourceID: "wiki/home", TargetID: "wiki/missing", TargetExists: false},
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("Expected %v, got %v", want, links)
+	}
+
+	backlinks, err := GetBacklinks(db, "wiki/missing")
+	if err != nil {
+		t.Fatalf("GetBacklinks failed: %v", err)
+	}
+	if len(backlinks) != 1 || backlinks[0].SourceID != "wiki/home" {
+		t.Errorf("Expected backlink from wiki/home, got %v", backlinks)
+	}
+
+	// Updating replaces the outbound links
+	if _, err := UpdateDocument(db, "wiki/home", "Only [[wiki/guide]] now", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	backlinks, _ = GetBacklinks(db, "wiki/missing")
+	if len(backlinks) != 0 {
+		t.Errorf("Expected no backlinks after update, got %v", backlinks)
+	}
+
+	// Copies carry their links; moves keep them under the new id
+	if _, err := CopyDocument(db, "wiki/home", "wiki/home-copy", "user-1"); err != nil {
+		t.Fatalf("CopyDocument failed: %v", err)
+	}
+	if _, err := MoveDocument(db, "wiki/guide", "wiki/manual", "user-1"); err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+
+	backlinks, _ = GetBacklinks(db, "wiki/guide")
+	if len(backlinks) != 2 {
+		t.Fatalf("Expected 2 backlinks to wiki/guide, got %v", backlinks)
+	}
+	for _, link := range backlinks {
+		if link.TargetExists {
+			t.Errorf("Expected wiki/guide to be missing after move, got %v", link)
+		}
+	}
+
+	links, _ = GetOutboundLinks(db, "wiki/manual")
+	if len(links) != 1 || links[0].TargetID != "wiki/home" || !links[0].TargetExists {
+		t.Errorf("Expected moved document to keep its link to wiki/home, got %v", links)
+	}
+}
+
+func TestDocumentLinks_Binary(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// "[[x]]" is not valid base64, so encode content that decodes to a link
+	if _, err := CreateDocument(db, "files/blob", "W1t3aWtpL2hvbWVdXQ==", "application/octet-stream", "user-1", true, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	links, err := GetOutboundLinks(db, "files/blob")
+	if err != nil {
+		t.Fatalf("GetOutboundLinks failed: %v", err)
+	}
+	if len(links) != 0 {
+		t.Errorf("Expected binary documents to have no links, got %v", links)
+	}
+}
@@ -0,0 +1,105 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// MetadataFilter matches documents whose metadata value at Key equals Value.
+// Key is a dot-separated path into the metadata object ("author" or
+// "seo.title"); values compare as text, with JSON booleans as "true"/"false".
+type MetadataFilter struct {
+	Key   string
+	Value string
+}
+
+// NormalizeMetadata checks that metadata is a JSON object and returns it in
+// compact form. Empty input becomes "{}".
+func NormalizeMetadata(metadata json.RawMessage) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(metadata)) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(metadata, &object); err != nil || object == nil {
+		return nil, fmt.Errorf("metadata must be a JSON object")
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, metadata); err != nil {
+		return nil, fmt.Errorf("metadata must be a JSON object")
+	}
+	return compact.Bytes(), nil
+}
+
+// SetDocumentMetadata replaces a document's metadata. The content version is
+// unchanged; the modification time and author are updated. Front matter
+// parsed from the content is kept, and a front_matter key in metadata is
+// ignored.
+func SetDocumentMetadata(db DB, id string, metadata json.RawMessage, userID string) (*Document, error) {
+	if id == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+
+	metadata, err := NormalizeMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_documents
+		SET metadata = CASE
+				WHEN json_type(metadata, '$.front_matter') IS NULL THEN json_remove(?1, '$.front_matter')
+				ELSE json_set(?1, '$.front_matter', json(json_extract(metadata, '$.front_matter')))
+			END,
+			modified_at = ?2, modified_by = ?3
+		WHERE id = ?4
+	`, string(metadata), clock.Now().Unix(), userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("document not found: %s", id)
+	}
+
+	return GetDocument(db, id)
+}
+
+// jsonPath turns a dot-separated metadata key into a JSON path with every
+// segment quoted, so keys are matched literally
+func (f MetadataFilter) jsonPath() (string, error) {
+	segments := strings.Split(f.Key, ".")
+	for i, segment := range segments {
+		if segment == "" || strings.ContainsAny(segment, `"\`) {
+			return "", fmt.Errorf("invalid metadata key: %q", f.Key)
+		}
+		segments[i] = `"` + segment + `"`
+	}
+	return "$." + strings.Join(segments, "."), nil
+}
+
+// condition returns the SQL condition and arguments for a filter on
+// the documents table aliased as d
+func (f MetadataFilter) condition() (string, []interface{}, error) {
+	path, err := f.jsonPath()
+	if err != nil {
+		return "", nil, err
+	}
+	return `CASE json_type(d.metadata, ?)
+			WHEN 'true' THEN 'true'
+			WHEN 'false' THEN 'false'
+			ELSE CAST(json_extract(d.metadata, ?) AS TEXT)
+		END = ?`, []interface{}{path, path, f.Value}, nil
+}
@@ -0,0 +1,107 @@
+package document
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeMetadata(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"", "{}", false},
+		{`{ "author": "alice" }`, `{"author":"alice"}`, false},
+		{`[1, 2]`, "", true},
+		{`"text"`, "", true},
+		{`null`, "", true},
+		{`{"broken"`, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeMetadata(json.RawMessage(tt.input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeMetadata(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && string(got) != tt.want {
+			t.Errorf("NormalizeMetadata(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataFilters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	docs := map[string]string{
+		"posts/a": `{"author": "alice", "draft": true, "rating": 5, "seo": {"title": "A"}}`,
+		"posts/b": `{"author": "bob", "draft": false, "rating": 3}`,
+		"pages/c": `{"author": "alice"}`,
+		"pages/d": ``,
+	}
+	for id, metadata := range docs {
+		if _, err := CreateDocument(db, id, "content", "text/plain", "user-1", false, false); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+		doc, err := SetDocumentMetadata(db, id, json.RawMessage(metadata), "user-1")
+		if err != nil {
+			t.Fatalf("SetDocumentMetadata failed: %v", err)
+		}
+		if doc.Version != 1 {
+			t.Errorf("Expected metadata update to keep version 1, got %d", doc.Version)
+		}
+	}
+
+	tests := []struct {
+		prefix  string
+		filters []MetadataFilter
+		want    []string
+	}{
+		{"", []MetadataFilter{{"author", "alice"}}, []string{"pages/c", "posts/a"}},
+		{"posts/", []MetadataFilter{{"author", "alice"}}, []string{"posts/a"}},
+		{"", []MetadataFilter{{"draft", "false"}}, []string{"posts/b"}},
+		{"", []MetadataFilter{{"rating", "5"}}, []string{"posts/a"}},
+		{"", []MetadataFilter{{"seo.title", "A"}}, []string{"posts/a"}},
+		{"", []MetadataFilter{{"author", "alice"}, {"draft", "true"}}, []string{"posts/a"}},
+		{"", []MetadataFilter{{"missing", "x"}}, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ListDocumentsFiltered(db, tt.prefix, tt.filters, true, 0, 0)
+		if err != nil {
+			t.Fatalf("ListDocumentsFiltered(%v) failed: %v", tt.filters, err)
+		}
+		ids := []string{}
+		for _, doc := range got {
+			ids = append(ids, doc.ID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("ListDocumentsFiltered(%q, %v) = %v, want %v", tt.prefix, tt.filters, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("ListDocumentsFiltered(%q, %v) = %v, want %v", tt.prefix, tt.filters, ids, tt.want)
+				break
+			}
+		}
+	}
+
+	if _, err := ListDocumentsFiltered(db, "", []MetadataFilter{{`a"b`, "x"}}, true, 0, 0); err == nil {
+		t.Error("Expected error for key containing a quote")
+	}
+	if _, err := SetDocumentMetadata(db, "missing", json.RawMessage(`{}`), "user-1"); err == nil {
+		t.Error("Expected error for missing document")
+	}
+
+	// Copies carry metadata with them
+	copied, err := CopyDocument(db, "posts/a", "posts/a-copy", "user-1")
+	if err != nil {
+		t.Fatalf("CopyDocument failed: %v", err)
+	}
+	if string(copied.Metadata) != `{"author":"alice","draft":true,"rating":5,"seo":{"title":"A"}}` {
+		t.Errorf("Expected metadata to be copied, got %s", copied.Metadata)
+	}
+}
@@ -0,0 +1,204 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// MoveDocument renames a document. Tags, version history, scan status,
+// streamed content, outbound links, template references and its schema move
+// with it, and documents validated against it follow it to the new id.
+// Content, version and modification metadata are unchanged. The change feed records the move by
+// userID as a delete of id and a create of newID.
+func MoveDocument(db *sql.DB, id, newID, userID string) (*Document, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+	return moveDocument(db, id, newID, userID)
+}
+
+// moveDocument renames a document, attributing the move to userID or, when it
+// is empty, to the document's last modifier
+func moveDocument(db *sql.DB, id, newID, userID string) (*Document, error) {
+	tx, err := beginRelocation(db, id, newID)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Insert the new row first so dependents can be repointed before the old
+	// row is deleted; the insert and delete triggers keep the search index in step
+	_, err = tx.Exec(`
+		INSERT INTO _wce_documents (
+			id, content, content_type, is_binary, searchable,
+			created_at, modified_at, created_by, modified_by, version, metadata, schema_id, expires_at, compression, pinned, sort_order, content_hash
+		)
+		SELECT ?, content, content_type, is_binary, searchable,
+		       created_at, modified_at, created_by, modified_by, version, metadata, schema_id, expires_at, compression, pinned, sort_order, content_hash
+		FROM _wce_documents
+		WHERE id = ?
+	`, newID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move document: %w", err)
+	}
+	if err := indexCompressed(tx, newID); err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"_wce_document_tags", "_wce_document_versions", "_wce_document_scans", "_wce_document_blobs", "_wce_document_thumbnails"} {
+		if _, err := tx.Exec(`UPDATE `+table+` SET document_id = ? WHERE document_id = ?`, newID, id); err != nil {
+			return nil, fmt.Errorf("failed to move document %s: %w", table, err)
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE _wce_document_links SET source_id = ? WHERE source_id = ?`, newID, id); err != nil {
+		return nil, fmt.Errorf("failed to move document links: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE _wce_document_references SET source_id = ? WHERE source_id = ?`, newID, id); err != nil {
+		return nil, fmt.Errorf("failed to move document references: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE _wce_documents SET schema_id = ? WHERE schema_id = ?`, newID, id); err != nil {
+		return nil, fmt.Errorf("failed to move schema references: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE _wce_collections SET schema_id = ? WHERE schema_id = ?`, newID, id); err != nil {
+		return nil, fmt.Errorf("failed to move schema references: %w", err)
+	}
+
+	if err := recordChange(tx, ChangeDelete, id, userID); err != nil {
+		return nil, err
+	}
+	if err := recordChange(tx, ChangeCreate, newID, userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM _wce_documents WHERE id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to move document: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return GetDocument(db, newID)
+}
+
+// CopyDocument duplicates a document under a new id, including its tags,
+// version history, scan status, streamed content, outbound links, template
+// references and schema.
+// The copy is attributed to userID.
+func CopyDocument(db *sql.DB, id, newID, userID string) (*Document, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+
+	limits := readLimits(db)
+
+	tx, err := beginRelocation(db, id, newID)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := clock.Now().Unix()
+
+	// The copy keeps the version number so its history lines up
+	_, err = tx.Exec(`
+		INSERT INTO _wce_documents (
+			id, content, content_type, is_binary, searchable,
+			created_at, modified_at, created_by, modified_by, version, metadata, schema_id, expires_at, compression, pinned, sort_order, content_hash
+		)
+		SELECT ?, content, content_type, is_binary, searchable, ?, ?, ?, ?, version, metadata, schema_id, expires_at, compression, pinned, sort_order, content_hash
+		FROM _wce_documents
+		WHERE id = ?
+	`, newID, now, now, userID, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy document: %w", err)
+	}
+	if err := indexCompressed(tx, newID); err != nil {
+		return nil, err
+	}
+
+	copies := []string{
+		`INSERT INTO _wce_document_tags (document_id, tag)
+		 SELECT ?, tag FROM _wce_document_tags WHERE document_id = ?`,
+		`INSERT INTO _wce_document_versions (
+			document_id, version, content, content_type, is_binary, modified_at, modified_by, compression
+		 )
+		 SELECT ?, version, content, content_type, is_binary, modified_at, modified_by, compression
+		 FROM _wce_document_versions WHERE document_id = ?`,
+		`INSERT INTO _wce_document_scans (
+			document_id, version, status, signature, scanned_at, reviewed_by, reviewed_at
+		 )
+		 SELECT ?, version, status, signature, scanned_at, reviewed_by, reviewed_at
+		 FROM _wce_document_scans WHERE document_id = ?`,
+		`INSERT INTO _wce_document_blobs (document_id, seq, data)
+		 SELECT ?, seq, data FROM _wce_document_blobs WHERE document_id = ?`,
+		`INSERT INTO _wce_document_links (source_id, target_id)
+		 SELECT ?, target_id FROM _wce_document_links WHERE source_id = ?`,
+		`INSERT INTO _wce_document_references (source_id, target_id, kind)
+		 SELECT ?, target_id, kind FROM _wce_document_references WHERE source_id = ?`,
+	}
+	for _, query := range copies {
+		if _, err := tx.Exec(query, newID, id); err != nil {
+			return nil, fmt.Errorf("failed to copy document: %w", err)
+		}
+	}
+
+	if err := recordChange(tx, ChangeCreate, newID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := limits.checkQuota(tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return GetDocument(db, newID)
+}
+
+// beginRelocation validates a move or copy and starts its transaction
+func beginRelocation(db *sql.DB, id, newID string) (*sql.Tx, error) {
+	if id == "" || newID == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+	if id == newID {
+		return nil, fmt.Errorf("source and destination are the same document")
+	}
+	if err := checkID(newID); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM _wce_documents WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, fmt.Errorf("document not found: %s", id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to check document existence: %w", err)
+	}
+
+	err = tx.QueryRow("SELECT 1 FROM _wce_documents WHERE id = ?", newID).Scan(&exists)
+	if err == nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("document with id %s already exists", newID)
+	}
+	if err != sql.ErrNoRows {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to check document existence: %w", err)
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,103 @@
+package document
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// setupHistory creates pages/home at version 2 with a tag and a search term
+func setupHistory(t *testing.T) *sql.DB {
+	t.Helper()
+	db := setupTestDB(t)
+
+	if _, err := CreateDocument(db, "pages/home", "first draft", "text/html", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := UpdateDocument(db, "pages/home", "welcome aboard", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if err := AddDocumentTag(db, "pages/home", "nav"); err != nil {
+		t.Fatalf("AddDocumentTag failed: %v", err)
+	}
+	if _, err := CreateDocument(db, "pages/about", "about", "text/html", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	return db
+}
+
+func TestMoveDocument(t *testing.T) {
+	db := setupHistory(t)
+	defer db.Close()
+
+	if _, err := MoveDocument(db, "pages/home", "pages/about", "user-1"); err == nil {
+		t.Error("Expected error moving onto an existing document")
+	}
+	if _, err := MoveDocument(db, "pages/missing", "pages/new", "user-1"); err == nil {
+		t.Error("Expected error moving a missing document")
+	}
+	if _, err := MoveDocument(db, "pages/home", "pages/home", "user-1"); err == nil {
+		t.Error("Expected error moving onto itself")
+	}
+
+	doc, err := MoveDocument(db, "pages/home", "pages/index", "user-1")
+	if err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+	if doc.ID != "pages/index" || doc.Content != "welcome aboard" || doc.Version != 2 {
+		t.Errorf("Unexpected moved document: %+v", doc)
+	}
+	if len(doc.Tags) != 1 || doc.Tags[0] != "nav" {
+		t.Errorf("Expected tags to move, got %v", doc.Tags)
+	}
+
+	if _, err := GetDocument(db, "pages/home"); err == nil {
+		t.Error("Expected old id to be gone")
+	}
+
+	v, err := GetDocumentVersion(db, "pages/index", 1)
+	if err != nil || v.Content != "first draft" {
+		t.Errorf("Expected history to move, got %+v (%v)", v, err)
+	}
+
+	results, err := SearchDocuments(db, "welcome", 10)
+	if err != nil || len(results) != 1 || results[0].ID != "pages/index" {
+		t.Errorf("Expected search to find the moved document, got %v (%v)", results, err)
+	}
+}
+
+func TestCopyDocument(t *testing.T) {
+	db := setupHistory(t)
+	defer db.Close()
+
+	if _, err := CopyDocument(db, "pages/home", "pages/about", "user-1"); err == nil {
+		t.Error("Expected error copying onto an existing document")
+	}
+
+	doc, err := CopyDocument(db, "pages/home", "pages/home-copy", "user-1")
+	if err != nil {
+		t.Fatalf("CopyDocument failed: %v", err)
+	}
+	if doc.Content != "welcome aboard" || doc.Version != 2 || len(doc.Tags) != 1 {
+		t.Errorf("Unexpected copy: %+v", doc)
+	}
+
+	versions, err := ListDocumentVersions(db, "pages/home-copy")
+	if err != nil || len(versions) != 2 {
+		t.Errorf("Expected copied history, got %d versions (%v)", len(versions), err)
+	}
+
+	// The original is untouched and the copies are independent
+	if _, err := UpdateDocument(db, "pages/home-copy", "changed", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	original, err := GetDocument(db, "pages/home")
+	if err != nil || original.Content != "welcome aboard" || original.Version != 2 {
+		t.Errorf("Expected original unchanged, got %+v (%v)", original, err)
+	}
+
+	results, _ := SearchDocuments(db, "welcome", 10)
+	if len(results) != 1 || results[0].ID != "pages/home" {
+		t.Errorf("Expected only the original to match, got %v", results)
+	}
+}
@@ -0,0 +1,72 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ReorderDocuments sets the position order of the documents under prefix, as
+// returned by ListDocumentsByPosition and SortPosition: ids take positions
+// 1, 2, ... in the order given and every other document under prefix loses
+// its position, sorting after them by id. Pinning is left unchanged, and so
+// are the documents' versions and modification times.
+func ReorderDocuments(db *sql.DB, prefix string, ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("ids cannot be empty")
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !strings.HasPrefix(id, prefix) || id == prefix {
+			return fmt.Errorf("document %s is not under prefix %q", id, prefix)
+		}
+		if seen[id] {
+			return fmt.Errorf("document %s is listed more than once", id)
+		}
+		seen[id] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// substr rather than LIKE so '%' and '_' in prefixes match literally
+	_, err = tx.Exec(`UPDATE _wce_documents SET sort_order = NULL WHERE substr(id, 1, ?) = ?`, len(prefix), prefix)
+	if err != nil {
+		return fmt.Errorf("failed to clear positions: %w", err)
+	}
+	for i, id := range ids {
+		result, err := tx.Exec(`UPDATE _wce_documents SET sort_order = ? WHERE id = ?`, i+1, id)
+		if err != nil {
+			return fmt.Errorf("failed to set position: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("document not found: %s", id)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit positions: %w", err)
+	}
+	return nil
+}
+
+// SetDocumentPinned pins a document, listing it before unpinned documents in
+// position order, or unpins it. Like ReorderDocuments, it leaves the version
+// and modification time unchanged.
+func SetDocumentPinned(db *sql.DB, id string, pinned bool) (*Document, error) {
+	if id == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+
+	result, err := db.Exec(`UPDATE _wce_documents SET pinned = ? WHERE id = ?`, boolToInt(pinned), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set pinned: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("document not found: %s", id)
+	}
+	return GetDocument(db, id)
+}
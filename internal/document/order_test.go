@@ -0,0 +1,100 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDocumentOrder(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"nav/about", "nav/blog", "nav/contact", "nav/home", "pages/x"} {
+		if _, err := CreateDocument(db, id, "link to "+id, "text/plain", "user-1", false, true); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+	}
+
+	listed := func(cursor string, limit int) ([]string, string) {
+		t.Helper()
+		docs, next, err := ListDocumentsByPosition(db, "nav/", nil, false, cursor, limit)
+		if err != nil {
+			t.Fatalf("ListDocumentsByPosition failed: %v", err)
+		}
+		var ids []string
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		return ids, next
+	}
+
+	// Without positions the order is by id
+	if ids, _ := listed("", 10); !reflect.DeepEqual(ids, []string{"nav/about", "nav/blog", "nav/contact", "nav/home"}) {
+		t.Errorf("Unexpected initial order: %v", ids)
+	}
+
+	invalid := [][]string{nil, {"pages/x"}, {"nav/home", "nav/home"}, {"nav/missing"}}
+	for _, ids := range invalid {
+		if err := ReorderDocuments(db, "nav/", ids); err == nil {
+			t.Errorf("Expected reorder of %v to fail", ids)
+		}
+	}
+
+	if err := ReorderDocuments(db, "nav/", []string{"nav/home", "nav/contact"}); err != nil {
+		t.Fatalf("ReorderDocuments failed: %v", err)
+	}
+	if ids, _ := listed("", 10); !reflect.DeepEqual(ids, []string{"nav/home", "nav/contact", "nav/about", "nav/blog"}) {
+		t.Errorf("Expected ordered documents first, got %v", ids)
+	}
+
+	doc, err := SetDocumentPinned(db, "nav/blog", true)
+	if err != nil || !doc.Pinned || doc.Version != 1 {
+		t.Fatalf("Unexpected pinned document: %+v (%v)", doc, err)
+	}
+	if _, err := SetDocumentPinned(db, "nav/missing", true); err == nil {
+		t.Error("Expected pinning a missing document to fail")
+	}
+
+	// Pages continue through pinned, ordered and unordered documents
+	var ids []string
+	cursor := ""
+	for {
+		page, next := listed(cursor, 1)
+		ids = append(ids, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !reflect.DeepEqual(ids, []string{"nav/blog", "nav/home", "nav/contact", "nav/about"}) {
+		t.Errorf("Unexpected paged order: %v", ids)
+	}
+	if _, _, err := ListDocumentsAfter(db, "nav/", nil, false, cursor, 1); err == nil {
+		t.Error("Expected a position cursor to be rejected by id listings")
+	}
+
+	// Query sorts by position too; a new order replaces the old one
+	if err := ReorderDocuments(db, "nav/", []string{"nav/about"}); err != nil {
+		t.Fatalf("ReorderDocuments failed: %v", err)
+	}
+	results, err := Query(db, QueryOptions{Prefix: "nav/", Sort: SortPosition})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	ids = nil
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"nav/blog", "nav/about", "nav/contact", "nav/home"}) {
+		t.Errorf("Unexpected query order: %v", ids)
+	}
+	if err := ValidateSort("-position", false); err == nil {
+		t.Error("Expected position order not to be reversible")
+	}
+
+	// Moving keeps the position
+	moved, err := MoveDocument(db, "nav/about", "nav/about-us", "user-1")
+	if err != nil || moved.SortOrder == nil || *moved.SortOrder != 1 {
+		t.Errorf("Expected the position to move with the document, got %+v (%v)", moved, err)
+	}
+}
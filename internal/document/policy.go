@@ -0,0 +1,146 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Disposition values controlling how raw documents are served
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+	DispositionReject     = "reject"
+)
+
+// MIMEPolicy controls how documents of a content type are served raw
+type MIMEPolicy struct {
+	ContentType string `json:"content_type"`
+	Disposition string `json:"disposition"`
+	UpdatedAt   int64  `json:"updated_at"`
+	UpdatedBy   string `json:"updated_by,omitempty"`
+}
+
+// IsValidDisposition checks if a disposition value is supported
+func IsValidDisposition(disposition string) bool {
+	switch disposition {
+	case DispositionInline, DispositionAttachment, DispositionReject:
+		return true
+	}
+	return false
+}
+
+// normalizeMediaType strips parameters and lowercases a content type
+func normalizeMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	}
+	return strings.ToLower(mediaType)
+}
+
+// SetMIMEPolicy creates or replaces the serving policy for a content type pattern
+func SetMIMEPolicy(db *sql.DB, contentType, disposition, userID string) error {
+	if contentType == "" {
+		return fmt.Errorf("content type cannot be empty")
+	}
+	if !IsValidDisposition(disposition) {
+		return fmt.Errorf("invalid disposition: %s", disposition)
+	}
+
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_mime_policies (content_type, disposition, updated_at, updated_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(content_type) DO UPDATE SET
+			disposition = excluded.disposition,
+			updated_at = excluded.updated_at,
+			updated_by = excluded.updated_by
+	`, contentType, disposition, clock.Now().Unix(), nullIfEmpty(userID))
+
+	if err != nil {
+		return fmt.Errorf("failed to set mime policy: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteMIMEPolicy removes the serving policy for a content type pattern
+func DeleteMIMEPolicy(db *sql.DB, contentType string) error {
+	result, err := db.Exec(`DELETE FROM _wce_mime_policies WHERE content_type = ?`,
+		strings.ToLower(strings.TrimSpace(contentType)))
+	if err != nil {
+		return fmt.Errorf("failed to delete mime policy: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("mime policy not found: %s", contentType)
+	}
+
+	return nil
+}
+
+// ListMIMEPolicies lists all configured serving policies
+func ListMIMEPolicies(db *sql.DB) ([]MIMEPolicy, error) {
+	rows, err := db.Query(`
+		SELECT content_type, disposition, updated_at, updated_by
+		FROM _wce_mime_policies
+		ORDER BY content_type
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mime policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []MIMEPolicy{}
+	for rows.Next() {
+		var policy MIMEPolicy
+		var updatedBy sql.NullString
+		if err := rows.Scan(&policy.ContentType, &policy.Disposition, &policy.UpdatedAt, &updatedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan mime policy: %w", err)
+		}
+		policy.UpdatedBy = updatedBy.String
+		policies = append(policies, policy)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mime policies: %w", err)
+	}
+
+	return policies, nil
+}
+
+// ResolveDisposition returns how a content type should be served.
+// The most specific policy wins: exact type, then "major/*", then "*/*".
+// Content types without a policy are served inline.
+func ResolveDisposition(db *sql.DB, contentType string) (string, error) {
+	mediaType := normalizeMediaType(contentType)
+
+	candidates := []string{mediaType}
+	if idx := strings.Index(mediaType, "/"); idx > 0 {
+		candidates = append(candidates, mediaType[:idx]+"/*")
+	}
+	candidates = append(candidates, "*/*")
+
+	for _, candidate := range candidates {
+		var disposition string
+		err := db.QueryRow(`SELECT disposition FROM _wce_mime_policies WHERE content_type = ?`, candidate).Scan(&disposition)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to query mime policy: %w", err)
+		}
+		return disposition, nil
+	}
+
+	return DispositionInline, nil
+}
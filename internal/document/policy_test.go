@@ -0,0 +1,77 @@
+package document
+
+import "testing"
+
+func TestResolveDisposition(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// No policies: everything is inline
+	disposition, err := ResolveDisposition(db, "text/html")
+	if err != nil {
+		t.Fatalf("Failed to resolve disposition: %v", err)
+	}
+	if disposition != DispositionInline {
+		t.Errorf("Expected inline by default, got %s", disposition)
+	}
+
+	SetMIMEPolicy(db, "*/*", DispositionAttachment, "user-1")
+	SetMIMEPolicy(db, "image/*", DispositionInline, "user-1")
+	SetMIMEPolicy(db, "image/svg+xml", DispositionReject, "user-1")
+
+	tests := []struct {
+		contentType string
+		expected    string
+	}{
+		{"image/png", DispositionInline},
+		{"image/svg+xml", DispositionReject},
+		{"IMAGE/SVG+XML", DispositionReject},
+		{"text/html; charset=utf-8", DispositionAttachment},
+		{"application/pdf", DispositionAttachment},
+	}
+
+	for _, tt := range tests {
+		disposition, err := ResolveDisposition(db, tt.contentType)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", tt.contentType, err)
+		}
+		if disposition != tt.expected {
+			t.Errorf("ResolveDisposition(%q) = %s, expected %s", tt.contentType, disposition, tt.expected)
+		}
+	}
+}
+
+func TestSetMIMEPolicy_Invalid(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := SetMIMEPolicy(db, "text/html", "execute", "user-1"); err == nil {
+		t.Error("Expected error for invalid disposition")
+	}
+	if err := SetMIMEPolicy(db, "", DispositionReject, "user-1"); err == nil {
+		t.Error("Expected error for empty content type")
+	}
+}
+
+func TestListAndDeleteMIMEPolicies(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	SetMIMEPolicy(db, "text/html", DispositionAttachment, "user-1")
+	SetMIMEPolicy(db, "text/html", DispositionReject, "user-1") // Update
+
+	policies, err := ListMIMEPolicies(db)
+	if err != nil {
+		t.Fatalf("Failed to list policies: %v", err)
+	}
+	if len(policies) != 1 || policies[0].Disposition != DispositionReject {
+		t.Fatalf("Expected single reject policy, got %+v", policies)
+	}
+
+	if err := DeleteMIMEPolicy(db, "text/html"); err != nil {
+		t.Fatalf("Failed to delete policy: %v", err)
+	}
+	if err := DeleteMIMEPolicy(db, "text/html"); err == nil {
+		t.Error("Expected error deleting missing policy")
+	}
+}
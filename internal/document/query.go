@@ -0,0 +1,241 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// QueryOptions combines document filters. Every non-empty criterion must
+// match.
+type QueryOptions struct {
+	Text         string           // Full-text search in BuildSearchQuery syntax
+	Tags         []string         // Documents must carry every tag
+	ContentTypes []string         // Any of these; "image/*" matches a whole type
+	Prefix       string           // Document id prefix
+	Metadata     []MetadataFilter // Metadata values, all of which must match
+	Sort         string           // One of the Sort orders; "" ranks text searches and orders others by id
+	Limit        int
+	Offset       int
+	Cursor       string // Resume after the page that returned this cursor
+}
+
+// Orders for QueryOptions.Sort. A leading "-" reverses the id and time
+// orders, as in "-modified_at" for the most recently modified first. Ties
+// are broken by id in the same direction.
+const (
+	SortRank     = "rank" // Best search rank first; requires Text
+	SortID       = "id"
+	SortCreated  = "created_at"
+	SortModified = "modified_at"
+	SortPosition = "position" // Pinned first, then by sort_order, unordered last
+)
+
+// positionKey is the position order before the id tie-break: pinned
+// documents first, then by sort_order with unordered documents last
+const positionKey = "1 - d.pinned, COALESCE(d.sort_order, 9223372036854775807)"
+
+// ValidateSort checks that sort is an order QueryOptions.Sort accepts, for
+// queries with full-text search when hasText is set
+func ValidateSort(sort string, hasText bool) error {
+	_, _, err := parseSort(sort, hasText)
+	return err
+}
+
+// parseSort resolves a Sort order to the column it orders by ("" for the
+// search rank) and its direction
+func parseSort(sort string, hasText bool) (column string, desc bool, err error) {
+	if sort == "" {
+		if hasText {
+			sort = SortRank
+		} else {
+			sort = SortID
+		}
+	}
+
+	name, desc := strings.CutPrefix(sort, "-")
+	switch name {
+	case SortRank, SortPosition:
+		if desc {
+			return "", false, fmt.Errorf("sort %q cannot be reversed", name)
+		}
+		if name == SortPosition {
+			return positionKey, false, nil
+		}
+		if !hasText {
+			return "", false, fmt.Errorf("sort %q requires a search query", SortRank)
+		}
+		return "", false, nil
+	case SortID, SortCreated, SortModified:
+		return "d." + name, desc, nil
+	}
+	return "", false, fmt.Errorf("invalid sort %q: must be %s, %s, %s, %s or %s, optionally prefixed with '-'", sort, SortRank, SortID, SortCreated, SortModified, SortPosition)
+}
+
+// Query selects documents matching opts in a single statement, so limit and
+// offset apply to the combined result. Results are ordered by opts.Sort:
+// by search rank when Text is set, and by id otherwise.
+func Query(db *sql.DB, opts QueryOptions) ([]SearchResult, error) {
+	results, _, err := QueryPage(db, opts)
+	return results, err
+}
+
+// QueryPage is Query that also returns the cursor of the next page, "" after
+// the last. Passing it back as opts.Cursor continues strictly after the last
+// result, so concurrent writes never skip or repeat a result.
+func QueryPage(db *sql.DB, opts QueryOptions) ([]SearchResult, string, error) {
+	limit, offset := opts.Limit, opts.Offset
+	if limit <= 0 {
+		limit = 50 // Default limit
+	}
+	if limit > 1000 {
+		limit = 1000 // Max limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := `
+		SELECT d.id, d.content, d.content_type, d.is_binary, d.searchable,
+		       d.created_at, d.modified_at, d.created_by, d.modified_by, d.version, d.metadata, COALESCE(d.schema_id, ''), COALESCE(d.expires_at, 0), d.pinned, d.sort_order,
+		       COALESCE(d.compression, ''), %s
+		FROM _wce_documents d`
+	conditions := []string{}
+	args := []interface{}{}
+
+	sortColumn, desc, err := parseSort(opts.Sort, opts.Text != "")
+	if err != nil {
+		return nil, "", err
+	}
+	ranked := sortColumn == ""
+	positioned := sortColumn == positionKey
+	timeSorted := !ranked && !positioned && sortColumn != "d.id"
+
+	direction, after := "", ">"
+	if desc {
+		direction, after = " DESC", "<"
+	}
+	orderBy := "d.id" + direction
+	switch {
+	case ranked:
+		orderBy = "s.rank, d.id"
+	case positioned:
+		orderBy = positionKey + ", d.id"
+	case timeSorted:
+		orderBy = sortColumn + direction + ", d.id" + direction
+	}
+
+	if opts.Text != "" {
+		match, err := BuildSearchQuery(opts.Text)
+		if err != nil {
+			return nil, "", err
+		}
+		query = fmt.Sprintf(query, "s.rank") + `
+		JOIN _wce_document_search s ON s.document_id = d.id`
+		conditions = append(conditions, "_wce_document_search MATCH ?")
+		args = append(args, match)
+	} else {
+		query = fmt.Sprintf(query, "0")
+	}
+
+	if opts.Cursor != "" {
+		key, err := decodeCursor(opts.Cursor, ranked)
+		if err != nil || (key.Time != nil) != timeSorted || (key.Position != nil) != positioned {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		switch {
+		case ranked:
+			conditions = append(conditions, "(s.rank > ? OR (s.rank = ? AND d.id > ?))")
+			args = append(args, *key.Rank, *key.Rank, key.ID)
+		case positioned:
+			conditions = append(conditions, "("+positionKey+", d.id) > (?, ?, ?)")
+			args = append(args, key.Position[0], key.Position[1], key.ID)
+		case timeSorted:
+			conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND d.id %[2]s ?))", sortColumn, after))
+			args = append(args, *key.Time, *key.Time, key.ID)
+		default:
+			conditions = append(conditions, "d.id "+after+" ?")
+			args = append(args, key.ID)
+		}
+	}
+
+	if opts.Prefix != "" {
+		condition, prefixArgs := prefixCondition("d.id", opts.Prefix)
+		conditions = append(conditions, condition)
+		args = append(args, prefixArgs...)
+	}
+
+	if tags := normalizeTags(opts.Tags); len(tags) > 0 {
+		conditions = append(conditions, `d.id IN (
+			SELECT document_id FROM _wce_document_tags
+			WHERE tag IN (`+placeholders(len(tags))+`)
+			GROUP BY document_id
+			HAVING COUNT(DISTINCT tag) = ?)`)
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(tags))
+	}
+
+	if len(opts.ContentTypes) > 0 {
+		var alternatives []string
+		for _, contentType := range opts.ContentTypes {
+			contentType = strings.ToLower(strings.TrimSpace(contentType))
+			if family, ok := strings.CutSuffix(contentType, "/*"); ok {
+				alternatives = append(alternatives, "lower(substr(d.content_type, 1, ?)) = ?")
+				args = append(args, len(family)+1, family+"/")
+				continue
+			}
+			// Also match the type with parameters, e.g. "text/plain; charset=utf-8"
+			alternatives = append(alternatives, "(lower(d.content_type) = ? OR lower(substr(d.content_type, 1, ?)) = ?)")
+			args = append(args, contentType, len(contentType)+1, contentType+";")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	for _, filter := range opts.Metadata {
+		condition, filterArgs, err := filter.condition()
+		if err != nil {
+			return nil, "", err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, filterArgs...)
+	}
+
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanSearchResults(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	return results, nextResultsCursor(results, limit, sortColumn), nil
+}
+
+// normalizeTags lowercases and de-duplicates tags, dropping empty ones
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// placeholders returns n comma-separated SQL parameters
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
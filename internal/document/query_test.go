@@ -0,0 +1,154 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "docs/golang", "Golang is a programming language", "text/markdown", "user-1", false, true)
+	CreateDocument(db, "docs/python", "Python is a programming language", "text/plain; charset=utf-8", "user-1", false, true)
+	CreateDocument(db, "docs/rust", "Rust is a systems programming language", "text/plain", "user-1", false, true)
+	CreateDocument(db, "notes/go", "Notes about golang", "text/plain", "user-1", false, true)
+	CreateDocument(db, "img/logo", "iVBORw0KGgo=", "image/png", "user-1", true, false)
+
+	AddDocumentTag(db, "docs/golang", "lang")
+	AddDocumentTag(db, "docs/golang", "compiled")
+	AddDocumentTag(db, "docs/python", "lang")
+	AddDocumentTag(db, "docs/rust", "lang")
+	AddDocumentTag(db, "docs/rust", "compiled")
+	AddDocumentTag(db, "notes/go", "compiled")
+	SetDocumentMetadata(db, "docs/rust", []byte(`{"level":"advanced"}`), "user-1")
+
+	ids := func(results []SearchResult) []string {
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		opts QueryOptions
+		want []string
+	}{
+		{"TextAndTag", QueryOptions{Text: "golang", Tags: []string{"lang"}}, []string{"docs/golang"}},
+		{"AllTags", QueryOptions{Tags: []string{"LANG", "compiled", "lang"}}, []string{"docs/golang", "docs/rust"}},
+		{"ContentType", QueryOptions{ContentTypes: []string{"text/plain"}}, []string{"docs/python", "docs/rust", "notes/go"}},
+		{"ContentTypeFamily", QueryOptions{ContentTypes: []string{"image/*", "text/markdown"}}, []string{"docs/golang", "img/logo"}},
+		{"PrefixAndText", QueryOptions{Text: "golang", Prefix: "notes/"}, []string{"notes/go"}},
+		{"PrefixIsLiteral", QueryOptions{Prefix: "d_cs/"}, nil},
+		{"PrefixIsCaseSensitive", QueryOptions{Prefix: "DOCS/"}, nil},
+		{"Metadata", QueryOptions{Text: "programming", Metadata: []MetadataFilter{{Key: "level", Value: "advanced"}}}, []string{"docs/rust"}},
+		{"Everything", QueryOptions{Text: "prog*", Tags: []string{"compiled"}, ContentTypes: []string{"text/*"}, Prefix: "docs/"}, []string{"docs/golang", "docs/rust"}},
+		{"NoMatch", QueryOptions{Text: "golang", ContentTypes: []string{"image/*"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := Query(db, tt.opts)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			got := ids(results)
+			if tt.opts.Text != "" {
+				// Ranked results: compare as sets
+				seen := map[string]bool{}
+				for _, id := range got {
+					seen[id] = true
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+				for _, id := range tt.want {
+					if !seen[id] {
+						t.Errorf("Expected %v, got %v", tt.want, got)
+					}
+				}
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	// Pagination applies after every filter
+	page, err := Query(db, QueryOptions{Tags: []string{"compiled"}, Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := ids(page); len(got) != 1 || got[0] != "notes/go" {
+		t.Errorf("Expected last page [notes/go], got %v", got)
+	}
+
+	if _, err := Query(db, QueryOptions{Metadata: []MetadataFilter{{Key: `a"b`, Value: "x"}}}); err == nil {
+		t.Error("Expected invalid metadata key to fail")
+	}
+}
+
+func TestQuerySort(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for i, id := range []string{"a", "b", "c", "d"} {
+		CreateDocument(db, id, "golang notes", "text/plain", "user-1", false, true)
+		// b and c share a timestamp, so ties fall back to the id
+		modified := map[string]int{"a": 30, "b": 20, "c": 20, "d": 10}[id]
+		db.Exec("UPDATE _wce_documents SET modified_at = ?, created_at = ? WHERE id = ?", modified, i, id)
+	}
+
+	tests := []struct {
+		sort string
+		text string
+		want string
+	}{
+		{"", "", "a b c d"},
+		{"-id", "", "d c b a"},
+		{"modified_at", "", "d b c a"},
+		{"-modified_at", "golang", "a c b d"},
+		{"-created_at", "", "d c b a"},
+	}
+	for _, tt := range tests {
+		// Page by two to check the cursor follows the order
+		var got []string
+		cursor := ""
+		for {
+			results, next, err := QueryPage(db, QueryOptions{Text: tt.text, ContentTypes: []string{"text/plain"}, Sort: tt.sort, Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("QueryPage(sort %q) failed: %v", tt.sort, err)
+			}
+			for _, r := range results {
+				got = append(got, r.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("Sort %q: expected %s, got %v", tt.sort, tt.want, got)
+		}
+	}
+
+	for _, sort := range []string{"title", "-rank", "rank"} {
+		if _, err := Query(db, QueryOptions{Prefix: "a", Sort: sort}); err == nil {
+			t.Errorf("Expected sort %q to be rejected", sort)
+		}
+	}
+
+	// Cursors only continue the order they were made for
+	_, next, _ := QueryPage(db, QueryOptions{ContentTypes: []string{"text/plain"}, Sort: "-modified_at", Limit: 2})
+	if _, err := Query(db, QueryOptions{ContentTypes: []string{"text/plain"}, Cursor: next}); err == nil {
+		t.Error("Expected a time cursor to be rejected for an id order")
+	}
+}
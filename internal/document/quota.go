@@ -0,0 +1,129 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/thetanil/wce/internal/config"
+)
+
+// Config keys limiting the space documents take
+const (
+	MaxDocumentSizeConfigKey = "max_document_size_mb" // Largest content a single document may hold
+	StorageQuotaConfigKey    = "storage_quota_mb"     // Total document storage, 0 for no quota
+)
+
+// defaultMaxDocumentSizeMB applies when the limit is unset or invalid
+const defaultMaxDocumentSizeMB = 10
+
+// Limits a LimitError can report
+const (
+	LimitDocumentSize = "document_size"
+	LimitStorageQuota = "storage_quota"
+)
+
+// LimitError reports a write refused because it would exceed the document
+// size limit or the cenv's storage quota
+type LimitError struct {
+	Limit string // LimitDocumentSize or LimitStorageQuota
+	Size  int64  // Size of the document, or storage used after the write
+	Max   int64  // The limit in bytes
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == LimitStorageQuota {
+		return fmt.Sprintf("storage quota exceeded: the write would use %d of %d bytes", e.Size, e.Max)
+	}
+	return fmt.Sprintf("document too large: %d bytes exceeds the limit of %d bytes", e.Size, e.Max)
+}
+
+// Usage reports how much of its storage a cenv's documents use
+type Usage struct {
+	Documents        int64 `json:"documents"`
+	UsedBytes        int64 `json:"used_bytes"`         // Stored content, streamed chunks and saved versions
+	QuotaBytes       int64 `json:"quota_bytes"`        // 0 when there is no quota
+	AvailableBytes   int64 `json:"available_bytes"`    // Left under the quota, 0 when there is no quota
+	MaxDocumentBytes int64 `json:"max_document_bytes"` // Largest content a single document may hold
+}
+
+// StorageUsage measures document storage against the configured limits.
+// Usage is counted like LargestDocuments does, as stored and so after
+// compression.
+func StorageUsage(db *sql.DB) (*Usage, error) {
+	limits := readLimits(db)
+	usage := &Usage{QuotaBytes: limits.quota, MaxDocumentBytes: limits.maxDocument}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM _wce_documents").Scan(&usage.Documents); err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+	used, err := usedBytes(db)
+	if err != nil {
+		return nil, err
+	}
+	usage.UsedBytes = used
+	if limits.quota > 0 {
+		usage.AvailableBytes = max(limits.quota-used, 0)
+	}
+
+	return usage, nil
+}
+
+// storageLimits are a cenv's configured limits in bytes
+type storageLimits struct {
+	maxDocument int64
+	quota       int64 // 0 for no quota
+}
+
+func readLimits(db DB) storageLimits {
+	maxMB := config.GetInt(db, MaxDocumentSizeConfigKey, defaultMaxDocumentSizeMB)
+	if maxMB <= 0 {
+		maxMB = defaultMaxDocumentSizeMB
+	}
+	quotaMB := config.GetInt(db, StorageQuotaConfigKey, 0)
+	return storageLimits{maxDocument: maxMB << 20, quota: max(quotaMB, 0) << 20}
+}
+
+// checkSize refuses content larger than the document size limit. Binary
+// content is measured decoded, as it is when streamed.
+func (l storageLimits) checkSize(content string, isBinary bool) error {
+	size := int64(len(content))
+	if isBinary {
+		size = int64(len(content)/4*3 - (len(content) - len(strings.TrimRight(content, "="))))
+	}
+	if size > l.maxDocument {
+		return &LimitError{Limit: LimitDocumentSize, Size: size, Max: l.maxDocument}
+	}
+	return nil
+}
+
+// checkQuota refuses a write, made but not yet committed in tx, that leaves
+// document storage over the quota
+func (l storageLimits) checkQuota(tx queryer) error {
+	if l.quota == 0 {
+		return nil
+	}
+	used, err := usedBytes(tx)
+	if err != nil {
+		return err
+	}
+	if used > l.quota {
+		return &LimitError{Limit: LimitStorageQuota, Size: used, Max: l.quota}
+	}
+	return nil
+}
+
+// usedBytes sums the stored size of every document, streamed chunk and
+// saved version
+func usedBytes(db queryer) (int64, error) {
+	var used int64
+	err := db.QueryRow(`
+		SELECT (SELECT COALESCE(SUM(length(CAST(content AS BLOB))), 0) FROM _wce_documents)
+		     + (SELECT COALESCE(SUM(length(data)), 0) FROM _wce_document_blobs)
+		     + (SELECT COALESCE(SUM(length(CAST(content AS BLOB))), 0) FROM _wce_document_versions)
+	`).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure document storage: %w", err)
+	}
+	return used, nil
+}
@@ -0,0 +1,95 @@
+package document
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/config"
+)
+
+func TestDocumentSizeLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	config.Set(db, MaxDocumentSizeConfigKey, "1", "")
+
+	tooLarge := strings.Repeat("a", 1<<20+1)
+	var limitErr *LimitError
+
+	_, err := CreateDocument(db, "big.txt", tooLarge, "text/plain", "user-1", false, false)
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitDocumentSize || limitErr.Max != 1<<20 {
+		t.Fatalf("Expected a document size LimitError, got %v", err)
+	}
+
+	if _, err := CreateDocument(db, "doc.txt", "small", "text/plain", "user-1", false, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := UpdateDocument(db, "doc.txt", tooLarge, "user-1"); !errors.As(err, &limitErr) {
+		t.Errorf("Expected update to be refused, got %v", err)
+	}
+
+	// Binary content is measured decoded, so 1 MB of data fits
+	encoded := base64.StdEncoding.EncodeToString(make([]byte, 1<<20))
+	if _, err := CreateDocument(db, "data.bin", encoded, "application/octet-stream", "user-1", true, false); err != nil {
+		t.Errorf("Expected 1 MB of binary content to fit, got %v", err)
+	}
+
+	_, _, err = WriteBlob(db, "big.bin", "application/octet-stream", "user-1", bytes.NewReader(make([]byte, 1<<20+1)))
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitDocumentSize {
+		t.Errorf("Expected streamed content to be refused, got %v", err)
+	}
+	if _, err := GetDocument(db, "big.bin"); err == nil {
+		t.Error("Expected refused upload to leave no document")
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	config.Set(db, StorageQuotaConfigKey, "1", "")
+
+	half := strings.Repeat("a", 1<<19)
+	if _, err := CreateDocument(db, "one.txt", half, "text/plain", "user-1", false, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	// The replaced content is kept as a version, so this update fills the quota
+	if _, err := UpdateDocument(db, "one.txt", strings.Repeat("b", 1<<19), "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+
+	var limitErr *LimitError
+	_, err := CreateDocument(db, "two.txt", "x", "text/plain", "user-1", false, false)
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitStorageQuota || limitErr.Size != 1<<20+1 {
+		t.Fatalf("Expected a storage quota LimitError, got %v", err)
+	}
+	if _, err := GetDocument(db, "two.txt"); err == nil {
+		t.Error("Expected refused create to be rolled back")
+	}
+	if _, err := CopyDocument(db, "one.txt", "copy.txt", "user-1"); !errors.As(err, &limitErr) {
+		t.Errorf("Expected copy to be refused, got %v", err)
+	}
+	_, _, err = WriteBlob(db, "blob.bin", "application/octet-stream", "user-1", bytes.NewReader([]byte("x")))
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitStorageQuota {
+		t.Errorf("Expected upload to be refused, got %v", err)
+	}
+
+	usage, err := StorageUsage(db)
+	if err != nil {
+		t.Fatalf("StorageUsage failed: %v", err)
+	}
+	want := Usage{Documents: 1, UsedBytes: 1 << 20, QuotaBytes: 1 << 20, AvailableBytes: 0, MaxDocumentBytes: 10 << 20}
+	if *usage != want {
+		t.Errorf("Expected %+v, got %+v", want, *usage)
+	}
+
+	// Deleting frees space
+	if err := DeleteDocument(db, "one.txt", "user-1"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	if _, err := CreateDocument(db, "two.txt", "x", "text/plain", "user-1", false, false); err != nil {
+		t.Errorf("Expected create to fit after delete, got %v", err)
+	}
+}
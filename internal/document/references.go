@@ -0,0 +1,87 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// templateReference matches {% include "id" %} and {% extends "id" %} tags
+// naming a document literally
+var templateReference = regexp.MustCompile(`\{%-?\s*(include|extends)\s+(?:"([^"\n]+)"|'([^'\n]+)')`)
+
+// Reference records that a template document includes or extends another
+// document, so deleting the target would break it. Target may name a
+// document that does not exist.
+type Reference struct {
+	SourceID string `json:"source_id"`
+	TargetID string `json:"target_id"`
+	Kind     string `json:"kind"` // "include" or "extends"
+}
+
+// ExtractReferences returns the distinct documents content includes or
+// extends as a template, in order of first appearance
+func ExtractReferences(content string) []Reference {
+	var refs []Reference
+	seen := map[Reference]bool{}
+	for _, match := range templateReference.FindAllStringSubmatch(content, -1) {
+		ref := Reference{TargetID: strings.TrimSpace(match[2] + match[3]), Kind: match[1]}
+		if ref.TargetID != "" && !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// setReferences replaces a document's template references with those in
+// content. Binary documents have no references.
+func setReferences(db execer, sourceID, content string, isBinary bool) error {
+	if _, err := db.Exec(`DELETE FROM _wce_document_references WHERE source_id = ?`, sourceID); err != nil {
+		return fmt.Errorf("failed to clear document references: %w", err)
+	}
+	if isBinary {
+		return nil
+	}
+
+	for _, ref := range ExtractReferences(content) {
+		if _, err := db.Exec(`
+			INSERT OR IGNORE INTO _wce_document_references (source_id, target_id, kind) VALUES (?, ?, ?)
+		`, sourceID, ref.TargetID, ref.Kind); err != nil {
+			return fmt.Errorf("failed to store document reference: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// GetReferrers lists the template documents that include or extend id,
+// ordered by source
+func GetReferrers(db *sql.DB, id string) ([]Reference, error) {
+	rows, err := db.Query(`
+		SELECT source_id, target_id, kind
+		FROM _wce_document_references
+		WHERE target_id = ?
+		ORDER BY source_id, kind
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document references: %w", err)
+	}
+	defer rows.Close()
+
+	refs := []Reference{}
+	for rows.Next() {
+		var ref Reference
+		if err := rows.Scan(&ref.SourceID, &ref.TargetID, &ref.Kind); err != nil {
+			return nil, fmt.Errorf("failed to scan document reference: %w", err)
+		}
+		refs = append(refs, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating document references: %w", err)
+	}
+
+	return refs, nil
+}
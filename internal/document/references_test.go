@@ -0,0 +1,63 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractReferences(t *testing.T) {
+	content := `{% extends "layouts/base.html" %}
+{%- include 'partials/nav.html' %}
+{% include "partials/nav.html" %}
+{% include name %}
+{{ "{% include 'not/a/tag' %}" }}`
+
+	want := []Reference{
+		{TargetID: "layouts/base.html", Kind: "extends"},
+		{TargetID: "partials/nav.html", Kind: "include"},
+		{TargetID: "not/a/tag", Kind: "include"},
+	}
+	if got := ExtractReferences(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestDocumentReferences(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "layouts/base.html", "<main>{% block body %}{% endblock %}</main>", "text/html", "user-1", false, false)
+	CreateDocument(db, "pages/home.html", `{% extends "layouts/base.html" %}`, "text/html", "user-1", false, false)
+
+	refs, err := GetReferrers(db, "layouts/base.html")
+	if err != nil {
+		t.Fatalf("GetReferrers failed: %v", err)
+	}
+	want := []Reference{{SourceID: "pages/home.html", TargetID: "layouts/base.html", Kind: "extends"}}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("Expected %v, got %v", want, refs)
+	}
+
+	// Copies carry their references; moves keep them under the new id
+	if _, err := CopyDocument(db, "pages/home.html", "pages/copy.html", "user-1"); err != nil {
+		t.Fatalf("CopyDocument failed: %v", err)
+	}
+	if _, err := MoveDocument(db, "pages/home.html", "pages/index.html", "user-1"); err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+	refs, _ = GetReferrers(db, "layouts/base.html")
+	if len(refs) != 2 || refs[0].SourceID != "pages/copy.html" || refs[1].SourceID != "pages/index.html" {
+		t.Errorf("Unexpected referrers after copy and move: %v", refs)
+	}
+
+	// Updating replaces the references, and deleting drops them
+	if _, err := UpdateDocument(db, "pages/index.html", "<p>standalone</p>", "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if err := DeleteDocument(db, "pages/copy.html", "user-1"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	if refs, _ := GetReferrers(db, "layouts/base.html"); len(refs) != 0 {
+		t.Errorf("Expected no referrers, got %v", refs)
+	}
+}
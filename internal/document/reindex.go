@@ -0,0 +1,145 @@
+package document
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/thetanil/wce/internal/config"
+	wcedb "github.com/thetanil/wce/internal/db"
+)
+
+// DefaultReindexBatchSize is how many documents RebuildSearchIndex indexes
+// per transaction when no batch size is given
+const DefaultReindexBatchSize = 500
+
+// ReindexProgress reports how far a search index rebuild has got. Total is
+// the document count when the rebuild started.
+type ReindexProgress struct {
+	Indexed int `json:"indexed"`
+	Total   int `json:"total"`
+}
+
+// RebuildSearchIndex drops the search index and rebuilds it from
+// _wce_documents with the tokenizer set in search_tokenizer, repairing an
+// index or sync triggers that drifted after manual SQL or a failed
+// migration. Documents are indexed in batches of batchSize, each in its own
+// transaction, and progress is called after every batch. Searches run
+// during the rebuild miss documents not yet reindexed; documents written
+// meanwhile are indexed by the reinstalled triggers as usual.
+func RebuildSearchIndex(ctx context.Context, db *sql.DB, batchSize int, progress func(ReindexProgress)) (ReindexProgress, error) {
+	var p ReindexProgress
+	tokenizer, err := ParseTokenizer(config.GetString(db, SearchTokenizerConfigKey, ""))
+	if err != nil {
+		return p, fmt.Errorf("invalid %s: %w", SearchTokenizerConfigKey, err)
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultReindexBatchSize
+	}
+
+	columns := "document_id UNINDEXED, content"
+	if tokenizer != "" {
+		columns += `, tokenize = "` + tokenizer + `"`
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow("SELECT COUNT(*) FROM _wce_documents").Scan(&p.Total); err != nil {
+		return p, fmt.Errorf("failed to count documents: %w", err)
+	}
+	statements := []string{
+		"DROP TRIGGER IF EXISTS _wce_documents_ai",
+		"DROP TRIGGER IF EXISTS _wce_documents_ad",
+		"DROP TRIGGER IF EXISTS _wce_documents_au",
+		"DROP TABLE IF EXISTS _wce_document_search",
+		"CREATE VIRTUAL TABLE _wce_document_search USING fts5(" + columns + ")",
+		wcedb.SearchTriggers,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return p, fmt.Errorf("failed to recreate search index: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("failed to commit search index: %w", err)
+	}
+
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		n, last, err := reindexBatch(ctx, db, after, batchSize)
+		if err != nil {
+			return p, err
+		}
+		if n == 0 {
+			return p, nil
+		}
+		p.Indexed += n
+		after = last
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
+
+// reindexBatch indexes up to limit documents with ids after the given one,
+// returning how many it indexed and the last id. Rows the triggers added
+// for documents written since the rebuild began are replaced.
+func reindexBatch(ctx context.Context, db *sql.DB, after string, limit int) (int, string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, CASE WHEN searchable = 1 THEN content ELSE '' END, CASE WHEN searchable = 1 THEN COALESCE(compression, '') ELSE '' END
+		FROM _wce_documents WHERE id > ? ORDER BY id LIMIT ?
+	`, after, limit)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to list documents: %w", err)
+	}
+	var ids []interface{}
+	var contents []string
+	for rows.Next() {
+		var id, stored, compression string
+		if err := rows.Scan(&id, &stored, &compression); err != nil {
+			rows.Close()
+			return 0, "", fmt.Errorf("failed to scan document: %w", err)
+		}
+		content, err := DecodeContent(stored, compression)
+		if err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		ids = append(ids, id)
+		contents = append(contents, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", fmt.Errorf("error iterating documents: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, "", nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM _wce_document_search WHERE document_id IN ("+placeholders(len(ids))+")", ids...); err != nil {
+		return 0, "", fmt.Errorf("failed to index documents: %w", err)
+	}
+	for i, id := range ids {
+		if _, err := tx.Exec("INSERT INTO _wce_document_search(document_id, content) VALUES (?, ?)", id, contents[i]); err != nil {
+			return 0, "", fmt.Errorf("failed to index document: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("failed to commit search index: %w", err)
+	}
+	return len(ids), ids[len(ids)-1].(string), nil
+}
@@ -0,0 +1,146 @@
+package document
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/config"
+)
+
+func TestRebuildSearchIndex(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := CreateDocument(db, "a.md", "running the parse_config helper", "text/markdown", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := CreateDocument(db, "b.md", "nothing to see", "text/markdown", "user-1", false, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	config.Set(db, CompressionConfigKey, CompressionGzip, "")
+	config.Set(db, CompressionThresholdConfigKey, "0", "")
+	if _, err := CreateDocument(db, "c.md", strings.Repeat("runs config ", 100), "text/markdown", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	search := func(text string) []string {
+		t.Helper()
+		results, err := Query(db, QueryOptions{Text: text, Sort: SortID})
+		if err != nil {
+			t.Fatalf("Query(%q) failed: %v", text, err)
+		}
+		ids := []string{}
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	if got := search("run"); len(got) != 0 {
+		t.Errorf("Expected no stemming by default, got %v", got)
+	}
+
+	config.Set(db, SearchTokenizerConfigKey, "porter unicode61", "")
+	p, err := RebuildSearchIndex(context.Background(), db, 0, nil)
+	if err != nil {
+		t.Fatalf("RebuildSearchIndex failed: %v", err)
+	}
+	if p.Indexed != 3 || p.Total != 3 {
+		t.Errorf("Expected 3 of 3 documents indexed, got %+v", p)
+	}
+	if got := strings.Join(search("run"), ","); got != "a.md,c.md" {
+		t.Errorf("Expected stemmed matches including compressed content, got %s", got)
+	}
+
+	config.Set(db, SearchTokenizerConfigKey, "trigram", "")
+	if _, err := RebuildSearchIndex(context.Background(), db, 0, nil); err != nil {
+		t.Fatalf("RebuildSearchIndex failed: %v", err)
+	}
+	if got := strings.Join(search("e_con"), ","); got != "a.md" {
+		t.Errorf("Expected substring match with trigram, got %s", got)
+	}
+
+	// Documents written after a rebuild are indexed by the triggers
+	if _, err := CreateDocument(db, "d.md", "another parse_config", "text/markdown", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if got := strings.Join(search("se_co"), ","); got != "a.md,d.md" {
+		t.Errorf("Expected new documents indexed, got %s", got)
+	}
+
+	config.Set(db, SearchTokenizerConfigKey, "icu", "")
+	if _, err := RebuildSearchIndex(context.Background(), db, 0, nil); err == nil {
+		t.Error("Expected an invalid tokenizer to be rejected")
+	}
+	if got := strings.Join(search("se_co"), ","); got != "a.md,d.md" {
+		t.Errorf("Expected the index untouched after a failed rebuild, got %s", got)
+	}
+}
+
+func TestRebuildSearchIndex_Repair(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"a.md", "b.md", "c.md", "d.md", "e.md"} {
+		if _, err := CreateDocument(db, id, "drifting "+id, "text/markdown", "user-1", false, true); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+	}
+
+	// Manual SQL loses index rows and a sync trigger
+	if _, err := db.Exec("DELETE FROM _wce_document_search WHERE document_id IN ('b.md', 'c.md')"); err != nil {
+		t.Fatalf("Failed to drop index rows: %v", err)
+	}
+	if _, err := db.Exec("DROP TRIGGER _wce_documents_ai"); err != nil {
+		t.Fatalf("Failed to drop trigger: %v", err)
+	}
+	count := func() int {
+		t.Helper()
+		results, err := Query(db, QueryOptions{Text: "drifting"})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return len(results)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("Expected a drifted index with 3 documents, got %d", n)
+	}
+
+	var reports []ReindexProgress
+	p, err := RebuildSearchIndex(context.Background(), db, 2, func(p ReindexProgress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("RebuildSearchIndex failed: %v", err)
+	}
+	want := []ReindexProgress{{Indexed: 2, Total: 5}, {Indexed: 4, Total: 5}, {Indexed: 5, Total: 5}}
+	if len(reports) != len(want) {
+		t.Fatalf("Expected progress %v, got %v", want, reports)
+	}
+	for i := range want {
+		if reports[i] != want[i] {
+			t.Errorf("Expected progress %v, got %v", want, reports)
+		}
+	}
+	if p != want[len(want)-1] {
+		t.Errorf("Expected final progress %+v, got %+v", want[len(want)-1], p)
+	}
+	if n := count(); n != 5 {
+		t.Errorf("Expected every document indexed once, got %d", n)
+	}
+
+	// The dropped trigger is back
+	if _, err := CreateDocument(db, "f.md", "drifting f.md", "text/markdown", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if n := count(); n != 6 {
+		t.Errorf("Expected new documents indexed after repair, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := RebuildSearchIndex(ctx, db, 2, nil); err == nil {
+		t.Error("Expected a cancelled rebuild to fail")
+	}
+}
@@ -0,0 +1,199 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Scan status values for binary documents
+const (
+	ScanStatusPending     = "pending"
+	ScanStatusClean       = "clean"
+	ScanStatusQuarantined = "quarantined"
+	ScanStatusReleased    = "released"
+)
+
+// ScanRecord represents the malware scan state of a document
+type ScanRecord struct {
+	DocumentID string `json:"document_id"`
+	Version    int    `json:"version"`
+	Status     string `json:"status"`
+	Signature  string `json:"signature,omitempty"`
+	ScannedAt  int64  `json:"scanned_at,omitempty"`
+	ReviewedBy string `json:"reviewed_by,omitempty"`
+	ReviewedAt int64  `json:"reviewed_at,omitempty"`
+}
+
+// MarkScanPending records that a document version is awaiting a scan
+func MarkScanPending(db *sql.DB, documentID string, version int) error {
+	if documentID == "" {
+		return fmt.Errorf("document id cannot be empty")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_document_scans (document_id, version, status)
+		VALUES (?, ?, ?)
+		ON CONFLICT(document_id) DO UPDATE SET
+			version = excluded.version,
+			status = excluded.status,
+			signature = NULL,
+			scanned_at = NULL,
+			reviewed_by = NULL,
+			reviewed_at = NULL
+	`, documentID, version, ScanStatusPending)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark scan pending: %w", err)
+	}
+
+	return nil
+}
+
+// QuarantineUnscanned quarantines a document version that could not be
+// scanned, so it waits for an admin's review instead of being served
+func QuarantineUnscanned(db *sql.DB, documentID string, version int, reason string) error {
+	_, err := db.Exec(`
+		INSERT INTO _wce_document_scans (document_id, version, status, signature, scanned_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(document_id) DO UPDATE SET
+			version = excluded.version,
+			status = excluded.status,
+			signature = excluded.signature,
+			scanned_at = excluded.scanned_at,
+			reviewed_by = NULL,
+			reviewed_at = NULL
+	`, documentID, version, ScanStatusQuarantined, nullIfEmpty(reason), clock.Now().Unix())
+
+	if err != nil {
+		return fmt.Errorf("failed to quarantine document: %w", err)
+	}
+
+	return nil
+}
+
+// RecordScanResult stores the scan verdict for a document version.
+// Results for a superseded version are ignored.
+func RecordScanResult(db *sql.DB, documentID string, version int, infected bool, signature string) error {
+	status := ScanStatusClean
+	if infected {
+		status = ScanStatusQuarantined
+	}
+
+	_, err := db.Exec(`
+		UPDATE _wce_document_scans
+		SET status = ?, signature = ?, scanned_at = ?
+		WHERE document_id = ? AND version = ? AND status = ?
+	`, status, nullIfEmpty(signature), clock.Now().Unix(), documentID, version, ScanStatusPending)
+
+	if err != nil {
+		return fmt.Errorf("failed to record scan result: %w", err)
+	}
+
+	return nil
+}
+
+// GetScanRecord retrieves the scan state of a document, or nil if it was never scanned
+func GetScanRecord(db *sql.DB, documentID string) (*ScanRecord, error) {
+	var rec ScanRecord
+	var signature, reviewedBy sql.NullString
+	var scannedAt, reviewedAt sql.NullInt64
+
+	err := db.QueryRow(`
+		SELECT document_id, version, status, signature, scanned_at, reviewed_by, reviewed_at
+		FROM _wce_document_scans
+		WHERE document_id = ?
+	`, documentID).Scan(
+		&rec.DocumentID, &rec.Version, &rec.Status, &signature, &scannedAt, &reviewedBy, &reviewedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scan record: %w", err)
+	}
+
+	rec.Signature = signature.String
+	rec.ScannedAt = scannedAt.Int64
+	rec.ReviewedBy = reviewedBy.String
+	rec.ReviewedAt = reviewedAt.Int64
+
+	return &rec, nil
+}
+
+// ListQuarantined lists all documents currently held in quarantine
+func ListQuarantined(db *sql.DB) ([]ScanRecord, error) {
+	rows, err := db.Query(`
+		SELECT document_id, version, status, signature, scanned_at
+		FROM _wce_document_scans
+		WHERE status = ?
+		ORDER BY scanned_at DESC
+	`, ScanStatusQuarantined)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quarantined documents: %w", err)
+	}
+	defer rows.Close()
+
+	records := []ScanRecord{}
+	for rows.Next() {
+		var rec ScanRecord
+		var signature sql.NullString
+		var scannedAt sql.NullInt64
+
+		if err := rows.Scan(&rec.DocumentID, &rec.Version, &rec.Status, &signature, &scannedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan quarantine record: %w", err)
+		}
+
+		rec.Signature = signature.String
+		rec.ScannedAt = scannedAt.Int64
+		records = append(records, rec)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating quarantine records: %w", err)
+	}
+
+	return records, nil
+}
+
+// ReleaseQuarantined marks a quarantined document as reviewed and safe to serve
+func ReleaseQuarantined(db *sql.DB, documentID, userID string) error {
+	result, err := db.Exec(`
+		UPDATE _wce_document_scans
+		SET status = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE document_id = ? AND status = ?
+	`, ScanStatusReleased, userID, clock.Now().Unix(), documentID, ScanStatusQuarantined)
+
+	if err != nil {
+		return fmt.Errorf("failed to release document: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("quarantined document not found: %s", documentID)
+	}
+
+	return nil
+}
+
+// IsServable reports whether a scan record allows the document to be served
+func (r *ScanRecord) IsServable() bool {
+	if r == nil {
+		return true
+	}
+	return r.Status == ScanStatusClean || r.Status == ScanStatusReleased
+}
+
+// nullIfEmpty converts an empty string to NULL for SQLite
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
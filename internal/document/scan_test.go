@@ -0,0 +1,112 @@
+package document
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestScanLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	content := base64.StdEncoding.EncodeToString([]byte("binary"))
+	_, err := CreateDocument(db, "files/a.bin", content, "application/octet-stream", "user-1", true, false)
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	// Unscanned documents are servable
+	rec, err := GetScanRecord(db, "files/a.bin")
+	if err != nil {
+		t.Fatalf("Failed to get scan record: %v", err)
+	}
+	if !rec.IsServable() {
+		t.Error("Expected unscanned document to be servable")
+	}
+
+	if err := MarkScanPending(db, "files/a.bin", 1); err != nil {
+		t.Fatalf("Failed to mark pending: %v", err)
+	}
+
+	rec, _ = GetScanRecord(db, "files/a.bin")
+	if rec.Status != ScanStatusPending || rec.IsServable() {
+		t.Errorf("Expected pending, non-servable record, got %s", rec.Status)
+	}
+
+	if err := RecordScanResult(db, "files/a.bin", 1, true, "Test-Signature"); err != nil {
+		t.Fatalf("Failed to record result: %v", err)
+	}
+
+	rec, _ = GetScanRecord(db, "files/a.bin")
+	if rec.Status != ScanStatusQuarantined {
+		t.Errorf("Expected quarantined, got %s", rec.Status)
+	}
+	if rec.Signature != "Test-Signature" {
+		t.Errorf("Expected signature 'Test-Signature', got '%s'", rec.Signature)
+	}
+
+	quarantined, err := ListQuarantined(db)
+	if err != nil {
+		t.Fatalf("Failed to list quarantined: %v", err)
+	}
+	if len(quarantined) != 1 {
+		t.Fatalf("Expected 1 quarantined document, got %d", len(quarantined))
+	}
+
+	if err := ReleaseQuarantined(db, "files/a.bin", "user-1"); err != nil {
+		t.Fatalf("Failed to release: %v", err)
+	}
+
+	rec, _ = GetScanRecord(db, "files/a.bin")
+	if rec.Status != ScanStatusReleased || !rec.IsServable() {
+		t.Errorf("Expected released, servable record, got %s", rec.Status)
+	}
+
+	// Releasing again fails since the document is no longer quarantined
+	if err := ReleaseQuarantined(db, "files/a.bin", "user-1"); err == nil {
+		t.Error("Expected error releasing non-quarantined document")
+	}
+}
+
+func TestRecordScanResult_StaleVersion(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	content := base64.StdEncoding.EncodeToString([]byte("binary"))
+	CreateDocument(db, "files/b.bin", content, "application/octet-stream", "user-1", true, false)
+
+	MarkScanPending(db, "files/b.bin", 1)
+	MarkScanPending(db, "files/b.bin", 2)
+
+	// Verdict for the superseded version must not clear the new one
+	if err := RecordScanResult(db, "files/b.bin", 1, false, ""); err != nil {
+		t.Fatalf("Failed to record result: %v", err)
+	}
+
+	rec, _ := GetScanRecord(db, "files/b.bin")
+	if rec.Status != ScanStatusPending {
+		t.Errorf("Expected status to remain pending, got %s", rec.Status)
+	}
+}
+
+func TestQuarantineUnscanned(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	content := base64.StdEncoding.EncodeToString([]byte("binary"))
+	CreateDocument(db, "files/c.bin", content, "application/octet-stream", "user-1", true, false)
+
+	// Both with and without a pending record, the document ends up held
+	for _, pending := range []bool{false, true} {
+		if pending {
+			MarkScanPending(db, "files/c.bin", 2)
+		}
+		if err := QuarantineUnscanned(db, "files/c.bin", 2, "scan error: unreachable"); err != nil {
+			t.Fatalf("Failed to quarantine: %v", err)
+		}
+		rec, _ := GetScanRecord(db, "files/c.bin")
+		if rec.Status != ScanStatusQuarantined || rec.Signature != "scan error: unreachable" || rec.IsServable() {
+			t.Errorf("Expected an unservable quarantine record, got %+v", rec)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// searchFields are the FTS columns a term can be scoped to with "field:term".
+// Other "word:word" terms are searched as plain text.
+var searchFields = map[string]bool{
+	"content": true,
+}
+
+// BuildSearchQuery turns user search input into an FTS5 MATCH expression.
+// It supports:
+//   - terms, matched as words and ANDed together: golang language
+//   - quoted phrases: "programming language"
+//   - prefix matching: prog* or "programming lang"*
+//   - AND, OR and NOT (uppercase) between terms: go OR rust NOT python
+//   - field scoping: content:golang
+//
+// Every term is quoted in the output, so quotes, colons and other FTS5
+// syntax in the input are matched literally instead of causing errors.
+func BuildSearchQuery(input string) (string, error) {
+	var parts []string
+	var pendingOp string
+
+	rest := input
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+
+		var term string
+		term, rest = nextSearchTerm(rest)
+
+		switch term {
+		case "AND", "OR", "NOT":
+			if len(parts) == 0 {
+				if term == "NOT" {
+					return "", fmt.Errorf("NOT must follow a search term")
+				}
+				continue // Leading AND/OR is ignored
+			}
+			if pendingOp == "OR" && term == "NOT" {
+				return "", fmt.Errorf("OR NOT is not supported")
+			}
+			pendingOp = term
+			continue
+		}
+
+		expr := searchTermExpr(term)
+		if expr == "" {
+			continue
+		}
+		if len(parts) > 0 && pendingOp != "" {
+			parts = append(parts, pendingOp)
+		}
+		parts = append(parts, expr)
+		pendingOp = ""
+	}
+
+	if len(parts) == 0 {
+		return "", fmt.Errorf("search query cannot be empty")
+	}
+
+	// A trailing operator has nothing to apply to and is dropped
+	return strings.Join(parts, " "), nil
+}
+
+// nextSearchTerm splits the next whitespace-delimited term off s. Quoted
+// sections may contain whitespace; an unterminated quote runs to the end.
+func nextSearchTerm(s string) (term, rest string) {
+	inQuote := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+// searchTextReplacer blanks out characters that cannot appear in an FTS5 string
+var searchTextReplacer = strings.NewReplacer(`"`, " ", "\x00", " ")
+
+// searchTermExpr converts one user term into a quoted FTS5 phrase, with a
+// column filter and prefix marker where requested. It returns "" for terms
+// with nothing to search for.
+func searchTermExpr(term string) string {
+	var field string
+	if name, value, ok := strings.Cut(term, ":"); ok && searchFields[name] {
+		field, term = name, value
+	}
+
+	prefix := strings.HasSuffix(term, "*")
+	term = strings.TrimRight(term, "*")
+
+	// Quotes only group words; they are not part of the text. SQLite ends
+	// FTS5 strings at a NUL byte, so it separates words too.
+	text := strings.TrimSpace(searchTextReplacer.Replace(term))
+	if !strings.ContainsFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) {
+		return ""
+	}
+
+	expr := `"` + text + `"`
+	if prefix {
+		expr += "*"
+	}
+	if field != "" {
+		expr = field + " : " + expr
+	}
+	return expr
+}
@@ -0,0 +1,119 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestBuildSearchQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"golang", `"golang"`},
+		{"golang language", `"golang" "language"`},
+		{`"programming language"`, `"programming language"`},
+		{"prog*", `"prog"*`},
+		{`"systems prog"*`, `"systems prog"*`},
+		{"go OR rust", `"go" OR "rust"`},
+		{"language NOT python", `"language" NOT "python"`},
+		{"language AND NOT python", `"language" NOT "python"`},
+		{"content:golang", `content : "golang"`},
+		{`content:"a language"`, `content : "a language"`},
+		{"key:value", `"key:value"`},
+		{`say "hi`, `"say" "hi"`},
+		{`a"b`, `"a b"`},
+		{"OR golang AND", `"golang"`},
+		{"and or not", `"and" "or" "not"`},
+		{"golang ( * -", `"golang"`},
+		{"go\x00lang", `"go lang"`},
+	}
+
+	for _, tt := range tests {
+		got, err := BuildSearchQuery(tt.input)
+		if err != nil {
+			t.Errorf("BuildSearchQuery(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BuildSearchQuery(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "   ", `""`, "*", "NOT golang", "go OR NOT rust"} {
+		if _, err := BuildSearchQuery(input); err == nil {
+			t.Errorf("Expected BuildSearchQuery(%q) to fail", input)
+		}
+	}
+}
+
+func TestSearchDocuments_QuerySyntax(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "docs/golang", "Golang is a programming language", "text/plain", "user-1", false, true)
+	CreateDocument(db, "docs/python", "Python is also a programming language", "text/plain", "user-1", false, true)
+	CreateDocument(db, "docs/rust", "Rust is a systems programming language: fast", "text/plain", "user-1", false, true)
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		{`"systems programming"`, 1},
+		{`"programming systems"`, 0},
+		{"prog*", 3},
+		{"golang OR rust", 2},
+		{"language NOT python", 2},
+		{"content:golang", 1},
+		{`language: "fast`, 1},
+		{`"unbalanced`, 0},
+		{"a:b:c OR golang", 1},
+		{"NEAR(golang rust)", 0},
+	}
+
+	for _, tt := range tests {
+		results, err := SearchDocuments(db, tt.query, 10)
+		if err != nil {
+			t.Errorf("SearchDocuments(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if len(results) != tt.count {
+			t.Errorf("SearchDocuments(%q) returned %d results, want %d", tt.query, len(results), tt.count)
+		}
+	}
+}
+
+// FuzzBuildSearchQuery checks that any user input either is rejected or
+// becomes an expression FTS5 accepts
+func FuzzBuildSearchQuery(f *testing.F) {
+	for _, seed := range []string{
+		"golang", `"programming language"`, "prog*", `"systems prog"*`, "go OR rust",
+		"language NOT python", "content:golang", `content:"a language"`, "a:b:c OR golang",
+		`say "hi`, `a"b`, "NEAR(golang rust)", "golang ( * -", "^start", "col : {a b}", "x + y",
+	} {
+		f.Add(seed)
+	}
+
+	db := setupTestDB(f)
+	defer db.Close()
+	if _, err := CreateDocument(db, "docs/golang", "Golang is a programming language", "text/plain", "user-1", false, true); err != nil {
+		f.Fatalf("CreateDocument failed: %v", err)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		query, err := BuildSearchQuery(input)
+		if err != nil {
+			return
+		}
+
+		rows, err := db.Query("SELECT document_id FROM _wce_document_search WHERE _wce_document_search MATCH ?", query)
+		if err != nil {
+			t.Fatalf("BuildSearchQuery(%q) = %s, which FTS5 rejects: %v", input, query, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("BuildSearchQuery(%q) = %s, which FTS5 rejects: %v", input, query, err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DocumentSize is the space a document takes: its inline content, its
+// streamed chunks and the content of its saved versions
+type DocumentSize struct {
+	ID           string `json:"id"`
+	ContentType  string `json:"content_type"`
+	ContentBytes int64  `json:"content_bytes"`
+	BlobBytes    int64  `json:"blob_bytes"`
+	VersionBytes int64  `json:"version_bytes"`
+	Versions     int    `json:"versions"`
+	TotalBytes   int64  `json:"total_bytes"`
+}
+
+// LargestDocuments returns the documents taking the most space, largest
+// first. limit defaults to 20 and is capped at 100.
+func LargestDocuments(db *sql.DB, limit int) ([]DocumentSize, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	rows, err := db.Query(`
+		SELECT id, content_type, content_bytes, blob_bytes, version_bytes, versions,
+		       content_bytes + blob_bytes + version_bytes AS total_bytes
+		FROM (
+			SELECT d.id, d.content_type,
+			       length(CAST(d.content AS BLOB)) AS content_bytes,
+			       (SELECT COALESCE(SUM(length(b.data)), 0) FROM _wce_document_blobs b
+			        WHERE b.document_id = d.id) AS blob_bytes,
+			       (SELECT COALESCE(SUM(length(CAST(v.content AS BLOB))), 0) FROM _wce_document_versions v
+			        WHERE v.document_id = d.id) AS version_bytes,
+			       (SELECT COUNT(*) FROM _wce_document_versions v
+			        WHERE v.document_id = d.id) AS versions
+			FROM _wce_documents d
+		)
+		ORDER BY total_bytes DESC, id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure documents: %w", err)
+	}
+	defer rows.Close()
+
+	sizes := []DocumentSize{}
+	for rows.Next() {
+		var size DocumentSize
+		err := rows.Scan(&size.ID, &size.ContentType, &size.ContentBytes, &size.BlobBytes,
+			&size.VersionBytes, &size.Versions, &size.TotalBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan document size: %w", err)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, rows.Err()
+}
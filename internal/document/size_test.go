@@ -0,0 +1,48 @@
+package document
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLargestDocuments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := CreateDocument(db, "small.txt", "hi", "text/plain", "user-1", false, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := CreateDocument(db, "notes.md", strings.Repeat("a", 100), "text/markdown", "user-1", false, false); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := UpdateDocument(db, "notes.md", strings.Repeat("b", 50), "user-1"); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if _, _, err := WriteBlob(db, "big.bin", "application/octet-stream", "user-1", bytes.NewReader(make([]byte, 1000))); err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+
+	sizes, err := LargestDocuments(db, 0)
+	if err != nil {
+		t.Fatalf("LargestDocuments failed: %v", err)
+	}
+	if len(sizes) != 3 {
+		t.Fatalf("Expected 3 documents, got %d", len(sizes))
+	}
+	if sizes[0].ID != "big.bin" || sizes[0].BlobBytes != 1000 || sizes[0].TotalBytes != 1000 {
+		t.Errorf("Expected blob largest with 1000 bytes, got %+v", sizes[0])
+	}
+	notes := sizes[1]
+	if notes.ID != "notes.md" || notes.ContentBytes != 50 || notes.Versions != 1 || notes.VersionBytes != 100 || notes.TotalBytes != 150 {
+		t.Errorf("Expected notes.md with 50 content and 100 version bytes, got %+v", notes)
+	}
+	if sizes[2].ID != "small.txt" || sizes[2].TotalBytes != 2 {
+		t.Errorf("Expected small.txt last, got %+v", sizes[2])
+	}
+
+	sizes, err = LargestDocuments(db, 1)
+	if err != nil || len(sizes) != 1 {
+		t.Errorf("Expected limit of 1 document, got %d (%v)", len(sizes), err)
+	}
+}
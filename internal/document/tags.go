@@ -0,0 +1,147 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TagCount is a tag and the number of documents carrying it
+type TagCount struct {
+	Tag       string `json:"tag"`
+	Documents int    `json:"documents"`
+}
+
+// SetDocumentTags replaces the tags of a document with tags in one
+// transaction and returns the normalized list
+func SetDocumentTags(db DB, documentID string, tags []string) ([]string, error) {
+	if documentID == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+	normalized := normalizeTags(tags)
+	sort.Strings(normalized)
+
+	tx, err := begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM _wce_documents WHERE id = ?", documentID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("document not found: %s", documentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check document existence: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM _wce_document_tags WHERE document_id = ?", documentID); err != nil {
+		return nil, fmt.Errorf("failed to clear tags: %w", err)
+	}
+	for _, tag := range normalized {
+		if _, err := tx.Exec("INSERT INTO _wce_document_tags (document_id, tag) VALUES (?, ?)", documentID, tag); err != nil {
+			return nil, fmt.Errorf("failed to add tag: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tags: %w", err)
+	}
+	if normalized == nil {
+		normalized = []string{}
+	}
+	return normalized, nil
+}
+
+// ListTags lists every tag in use with the number of documents carrying it
+func ListTags(db *sql.DB) ([]TagCount, error) {
+	rows, err := db.Query(`
+		SELECT tag, COUNT(*) FROM _wce_document_tags
+		GROUP BY tag
+		ORDER BY tag
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Documents); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tags: %w", err)
+	}
+	return tags, nil
+}
+
+// RenameTag renames a tag on every document carrying it, merging it into
+// the new tag where a document already has both. Returns the number of
+// documents that carried the old tag.
+func RenameTag(db *sql.DB, from, to string) (int, error) {
+	from = strings.ToLower(strings.TrimSpace(from))
+	to = strings.ToLower(strings.TrimSpace(to))
+	if from == "" || to == "" {
+		return 0, fmt.Errorf("tag cannot be empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM _wce_document_tags WHERE tag = ?", from).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count tag: %w", err)
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("tag not found: %s", from)
+	}
+	if from == to {
+		return count, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO _wce_document_tags (document_id, tag)
+		SELECT document_id, ? FROM _wce_document_tags WHERE tag = ?
+	`, to, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rename tag: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM _wce_document_tags WHERE tag = ?", from); err != nil {
+		return 0, fmt.Errorf("failed to rename tag: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit tag rename: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteTag removes a tag from every document and returns how many carried it
+func DeleteTag(db *sql.DB, tag string) (int, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return 0, fmt.Errorf("tag cannot be empty")
+	}
+
+	result, err := db.Exec("DELETE FROM _wce_document_tags WHERE tag = ?", tag)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete tag: %w", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("tag not found: %s", tag)
+	}
+	return int(count), nil
+}
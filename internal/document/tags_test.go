@@ -0,0 +1,69 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetDocumentTags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "posts/a", "content", "text/plain", "user-1", false, true)
+	AddDocumentTag(db, "posts/a", "old")
+
+	tags, err := SetDocumentTags(db, "posts/a", []string{"Go", " sqlite ", "go", ""})
+	if err != nil {
+		t.Fatalf("SetDocumentTags failed: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"go", "sqlite"}) {
+		t.Errorf("Expected normalized tags [go sqlite], got %v", tags)
+	}
+	if stored, _ := GetDocumentTags(db, "posts/a"); !reflect.DeepEqual(stored, tags) {
+		t.Errorf("Expected stored tags %v, got %v", tags, stored)
+	}
+
+	if tags, err := SetDocumentTags(db, "posts/a", []string{}); err != nil || len(tags) != 0 {
+		t.Errorf("Expected tags cleared, got %v: %v", tags, err)
+	}
+	if _, err := SetDocumentTags(db, "posts/missing", []string{"go"}); err == nil {
+		t.Error("Expected error tagging a missing document")
+	}
+}
+
+func TestRenameAndDeleteTag(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		CreateDocument(db, id, "content", "text/plain", "user-1", false, true)
+	}
+	SetDocumentTags(db, "a", []string{"golang"})
+	SetDocumentTags(db, "b", []string{"golang", "go"})
+	SetDocumentTags(db, "c", []string{"rust"})
+
+	tags, err := ListTags(db)
+	want := []TagCount{{"go", 1}, {"golang", 2}, {"rust", 1}}
+	if err != nil || !reflect.DeepEqual(tags, want) {
+		t.Fatalf("Expected %v, got %v: %v", want, tags, err)
+	}
+
+	// Renaming merges into a tag the document already has
+	if n, err := RenameTag(db, "golang", "Go"); err != nil || n != 2 {
+		t.Fatalf("Expected 2 documents renamed, got %d: %v", n, err)
+	}
+	tags, _ = ListTags(db)
+	if want := []TagCount{{"go", 2}, {"rust", 1}}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Expected %v after rename, got %v", want, tags)
+	}
+
+	if n, err := DeleteTag(db, "rust"); err != nil || n != 1 {
+		t.Errorf("Expected 1 document untagged, got %d: %v", n, err)
+	}
+	if _, err := DeleteTag(db, "rust"); err == nil {
+		t.Error("Expected error deleting an unused tag")
+	}
+	if _, err := RenameTag(db, "missing", "other"); err == nil {
+		t.Error("Expected error renaming an unused tag")
+	}
+}
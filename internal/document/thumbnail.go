@@ -0,0 +1,231 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Bounds on generated thumbnails. Each document keeps at most
+// maxThumbnailsPerDocument widths, dropping the oldest, so arbitrary widths
+// cannot grow the cache without limit.
+const (
+	MinThumbnailWidth        = 16
+	MaxThumbnailWidth        = 2048
+	maxThumbnailsPerDocument = 8
+	maxThumbnailSourcePixels = 50 << 20
+	thumbnailJPEGQuality     = 85
+)
+
+// Thumbnail is a scaled-down copy of an image document. JPEG images keep
+// their format; PNG and GIF images become PNG.
+type Thumbnail struct {
+	DocumentID  string
+	Width       int
+	SourceHash  string
+	ContentType string
+	Data        []byte
+	CreatedAt   int64
+}
+
+// thumbnailFormats maps the image types thumbnails are made of to the type
+// of the thumbnail
+var thumbnailFormats = map[string]string{
+	"image/jpeg": "image/jpeg",
+	"image/png":  "image/png",
+	"image/gif":  "image/png",
+}
+
+// CanThumbnail reports whether thumbnails can be made of a document
+func CanThumbnail(doc *Document) bool {
+	mediaType, _, err := mime.ParseMediaType(doc.ContentType)
+	return err == nil && doc.IsBinary && thumbnailFormats[mediaType] != ""
+}
+
+// GetThumbnail returns the thumbnail of an image document at width, making
+// and caching it when there is none for the document's current content.
+// sourceHash is the document's ContentHash. Images narrower than width are
+// re-encoded at their own size rather than scaled up.
+func GetThumbnail(ctx context.Context, db *sql.DB, doc *Document, sourceHash string, width int) (*Thumbnail, error) {
+	if !CanThumbnail(doc) {
+		return nil, fmt.Errorf("cannot make thumbnails of %s", doc.ContentType)
+	}
+	if width < MinThumbnailWidth || width > MaxThumbnailWidth {
+		return nil, fmt.Errorf("thumbnail width must be between %d and %d", MinThumbnailWidth, MaxThumbnailWidth)
+	}
+
+	thumb := &Thumbnail{DocumentID: doc.ID, Width: width}
+	err := db.QueryRowContext(ctx, `
+		SELECT source_hash, content_type, data, created_at
+		FROM _wce_document_thumbnails
+		WHERE document_id = ? AND width = ?
+	`, doc.ID, width).Scan(&thumb.SourceHash, &thumb.ContentType, &thumb.Data, &thumb.CreatedAt)
+	if err == nil && thumb.SourceHash == sourceHash {
+		return thumb, nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+
+	thumb, err = makeThumbnail(ctx, db, doc, width)
+	if err != nil {
+		return nil, err
+	}
+	thumb.SourceHash = sourceHash
+	if err := saveThumbnail(db, thumb); err != nil {
+		return nil, err
+	}
+	return thumb, nil
+}
+
+// makeThumbnail decodes an image document and scales it to width
+func makeThumbnail(ctx context.Context, db *sql.DB, doc *Document, width int) (*Thumbnail, error) {
+	var content io.Reader
+	if doc.IsBlob() {
+		reader, err := OpenBlob(ctx, db, doc.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		content = reader
+	} else {
+		data, err := base64.StdEncoding.DecodeString(doc.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode document content: %w", err)
+		}
+		content = bytes.NewReader(data)
+	}
+
+	// The header is checked before decoding so a small file cannot claim a
+	// huge canvas
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document content: %w", err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(doc.ContentType)
+	decodeConfig, decode := imageCodec(mediaType)
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image: %v", mediaType, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large for thumbnails", config.Width, config.Height)
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image: %v", mediaType, err)
+	}
+
+	bounds := img.Bounds()
+	targetWidth := min(width, bounds.Dx())
+	targetHeight := max(1, bounds.Dy()*targetWidth/bounds.Dx())
+	scaled := scaleImage(img, targetWidth, targetHeight)
+
+	thumb := &Thumbnail{DocumentID: doc.ID, Width: width, ContentType: thumbnailFormats[mediaType]}
+	var buf bytes.Buffer
+	if thumb.ContentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	thumb.Data = buf.Bytes()
+	return thumb, nil
+}
+
+// imageCodec returns the decoder functions of an image type
+func imageCodec(mediaType string) (func(io.Reader) (image.Config, error), func(io.Reader) (image.Image, error)) {
+	switch mediaType {
+	case "image/jpeg":
+		return jpeg.DecodeConfig, jpeg.Decode
+	case "image/gif":
+		return gif.DecodeConfig, gif.Decode
+	default:
+		return png.DecodeConfig, png.Decode
+	}
+}
+
+// scaleImage scales src to width x height, averaging the source pixels each
+// destination pixel covers. It only shrinks well; thumbnails never grow.
+func scaleImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := max(bounds.Min.Y+(y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := max(bounds.Min.X+(x+1)*srcWidth/width, x0+1)
+
+			// RGBA returns alpha-premultiplied 16-bit channels, which
+			// average correctly and fit image.RGBA once shifted
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// saveThumbnail caches a thumbnail, dropping the document's oldest
+// thumbnails past maxThumbnailsPerDocument
+func saveThumbnail(db *sql.DB, thumb *Thumbnail) error {
+	thumb.CreatedAt = clock.Now().Unix()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO _wce_document_thumbnails (document_id, width, source_hash, content_type, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, thumb.DocumentID, thumb.Width, thumb.SourceHash, thumb.ContentType, thumb.Data, thumb.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM _wce_document_thumbnails
+		WHERE document_id = ? AND width NOT IN (
+			SELECT width FROM _wce_document_thumbnails
+			WHERE document_id = ?
+			ORDER BY created_at DESC, width = ? DESC
+			LIMIT ?
+		)
+	`, thumb.DocumentID, thumb.DocumentID, thumb.Width, maxThumbnailsPerDocument)
+	if err != nil {
+		return fmt.Errorf("failed to prune thumbnails: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit thumbnail: %w", err)
+	}
+	return nil
+}
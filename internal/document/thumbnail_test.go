@@ -0,0 +1,158 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testPNG encodes a width x height image, red on the left half and blue on
+// the right
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetThumbnail(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	content := base64.StdEncoding.EncodeToString(testPNG(t, 400, 200))
+	doc, err := CreateDocument(db, "assets/photo.png", content, "image/png", "user-1", true, false)
+	if err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	hash, err := ContentHash(ctx, db, doc)
+	if err != nil {
+		t.Fatalf("ContentHash failed: %v", err)
+	}
+
+	thumb, err := GetThumbnail(ctx, db, doc, hash, 100)
+	if err != nil {
+		t.Fatalf("GetThumbnail failed: %v", err)
+	}
+	if thumb.ContentType != "image/png" {
+		t.Errorf("Expected image/png, got %s", thumb.ContentType)
+	}
+	img, err := png.Decode(bytes.NewReader(thumb.Data))
+	if err != nil {
+		t.Fatalf("Thumbnail is not a PNG: %v", err)
+	}
+	if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 50 {
+		t.Errorf("Expected 100x50, got %v", img.Bounds())
+	}
+	if r, _, b, _ := img.At(10, 25).RGBA(); r>>8 != 255 || b != 0 {
+		t.Errorf("Expected red on the left, got %v", img.At(10, 25))
+	}
+	if r, _, b, _ := img.At(90, 25).RGBA(); r != 0 || b>>8 != 255 {
+		t.Errorf("Expected blue on the right, got %v", img.At(90, 25))
+	}
+
+	// The second request is served from the cache
+	cached, err := GetThumbnail(ctx, db, doc, hash, 100)
+	if err != nil {
+		t.Fatalf("GetThumbnail failed: %v", err)
+	}
+	if cached.CreatedAt != thumb.CreatedAt || !bytes.Equal(cached.Data, thumb.Data) {
+		t.Error("Expected the cached thumbnail")
+	}
+
+	// Images are not scaled up
+	large, err := GetThumbnail(ctx, db, doc, hash, 1000)
+	if err != nil {
+		t.Fatalf("GetThumbnail failed: %v", err)
+	}
+	if config, err := png.DecodeConfig(bytes.NewReader(large.Data)); err != nil || config.Width != 400 {
+		t.Errorf("Expected the source width of 400, got %v (%v)", config.Width, err)
+	}
+
+	// New content replaces stale thumbnails
+	content = base64.StdEncoding.EncodeToString(testPNG(t, 200, 200))
+	doc, err = UpdateDocument(db, doc.ID, content, "user-1")
+	if err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	newHash, _ := ContentHash(ctx, db, doc)
+	thumb, err = GetThumbnail(ctx, db, doc, newHash, 100)
+	if err != nil {
+		t.Fatalf("GetThumbnail failed: %v", err)
+	}
+	if thumb.SourceHash != newHash {
+		t.Error("Expected a thumbnail of the new content")
+	}
+	if config, _ := png.DecodeConfig(bytes.NewReader(thumb.Data)); config.Height != 100 {
+		t.Errorf("Expected height 100, got %d", config.Height)
+	}
+
+	for _, width := range []int{0, MinThumbnailWidth - 1, MaxThumbnailWidth + 1} {
+		if _, err := GetThumbnail(ctx, db, doc, newHash, width); err == nil {
+			t.Errorf("Expected width %d to be rejected", width)
+		}
+	}
+
+	text, _ := CreateDocument(db, "assets/site.css", "body {}", "text/css", "user-1", false, false)
+	if _, err := GetThumbnail(ctx, db, text, "", 100); err == nil {
+		t.Error("Expected thumbnails of text documents to be rejected")
+	}
+
+	// Deleting the document drops its thumbnails
+	if err := DeleteDocument(db, doc.ID, "user-1"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM _wce_document_thumbnails").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no thumbnails after delete, got %d", count)
+	}
+}
+
+func TestThumbnailCacheLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil)
+	doc, _, err := WriteBlob(db, "assets/photo.jpg", "image/jpeg", "user-1", &buf)
+	if err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+	hash, _ := ContentHash(ctx, db, doc)
+
+	for width := MinThumbnailWidth; width < MinThumbnailWidth+maxThumbnailsPerDocument+3; width++ {
+		thumb, err := GetThumbnail(ctx, db, doc, hash, width)
+		if err != nil {
+			t.Fatalf("GetThumbnail(%d) failed: %v", width, err)
+		}
+		if thumb.ContentType != "image/jpeg" {
+			t.Errorf("Expected image/jpeg, got %s", thumb.ContentType)
+		}
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM _wce_document_thumbnails WHERE document_id = ?", doc.ID).Scan(&count)
+	if count != maxThumbnailsPerDocument {
+		t.Errorf("Expected %d cached thumbnails, got %d", maxThumbnailsPerDocument, count)
+	}
+}
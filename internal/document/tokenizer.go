@@ -0,0 +1,124 @@
+package document
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SearchTokenizerConfigKey selects the FTS5 tokenizer of the search index,
+// in FTS5 syntax such as "porter unicode61 remove_diacritics 2" or
+// "trigram". Unset or empty means FTS5's default, unicode61. A change takes
+// effect when RebuildSearchIndex runs.
+const SearchTokenizerConfigKey = "search_tokenizer"
+
+// tokenizerOptions lists the options each base tokenizer accepts and
+// whether each takes a quoted string argument rather than a small integer
+var tokenizerOptions = map[string]map[string]bool{
+	"unicode61": {"remove_diacritics": false, "categories": true, "tokenchars": true, "separators": true},
+	"ascii":     {"tokenchars": true, "separators": true},
+	"trigram":   {"case_sensitive": false, "remove_diacritics": false},
+}
+
+// tokenizerFlagValues bounds the integer options
+var tokenizerFlagValues = map[string][]string{
+	"remove_diacritics": {"0", "1", "2"},
+	"case_sensitive":    {"0", "1"},
+}
+
+// unicodeCategory matches one entry of the unicode61 categories option
+var unicodeCategory = regexp.MustCompile(`^[A-Z][a-z*]$`)
+
+// ParseTokenizer validates a search_tokenizer value and returns the FTS5
+// tokenize argument it stands for, or "" for the default tokenizer. Option
+// strings are given in single quotes and may not contain quotes, so the
+// result can be embedded in the index's DDL.
+func ParseTokenizer(value string) (string, error) {
+	words, err := splitTokenizerWords(value)
+	if err != nil {
+		return "", err
+	}
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	spec := words
+	if spec[0] == "porter" {
+		spec = spec[1:]
+		if len(spec) == 0 {
+			spec = []string{"unicode61"}
+			words = append(words, "unicode61")
+		}
+	}
+
+	options, ok := tokenizerOptions[spec[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown tokenizer %q: must be unicode61, ascii or trigram, optionally after porter", spec[0])
+	}
+	if spec[0] == "trigram" && len(spec) != len(words) {
+		return "", fmt.Errorf("porter cannot wrap the trigram tokenizer")
+	}
+
+	args := spec[1:]
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("tokenizer option %q requires a value", args[len(args)-1])
+	}
+	for i := 0; i < len(args); i += 2 {
+		name, arg := args[i], args[i+1]
+		quoted, ok := options[name]
+		if !ok {
+			return "", fmt.Errorf("unknown %s option %q", spec[0], name)
+		}
+		if !quoted {
+			if !slices.Contains(tokenizerFlagValues[name], arg) {
+				return "", fmt.Errorf("%s must be one of %s", name, strings.Join(tokenizerFlagValues[name], ", "))
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "'") {
+			return "", fmt.Errorf("%s requires a quoted string", name)
+		}
+		if name == "categories" {
+			for _, category := range strings.Fields(strings.Trim(arg, "'")) {
+				if !unicodeCategory.MatchString(category) {
+					return "", fmt.Errorf("invalid unicode category %q", category)
+				}
+			}
+		}
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// splitTokenizerWords splits a tokenizer value on spaces, keeping single
+// quoted strings (quotes included) as one word
+func splitTokenizerWords(value string) ([]string, error) {
+	var words []string
+	for rest := strings.TrimSpace(value); rest != ""; rest = strings.TrimSpace(rest) {
+		if rest[0] == '\'' {
+			end := strings.IndexByte(rest[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted string in tokenizer")
+			}
+			word := rest[:end+2]
+			if strings.ContainsAny(word[1:len(word)-1], "\"\x00") || len(word) == 2 {
+				return nil, fmt.Errorf("quoted tokenizer strings must be non-empty and free of quotes")
+			}
+			words = append(words, word)
+			rest = rest[end+2:]
+			continue
+		}
+		end := strings.IndexAny(rest, " \t\n'")
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		if strings.ContainsRune(word, '"') {
+			return nil, fmt.Errorf("invalid tokenizer word %q", word)
+		}
+		words = append(words, strings.ToLower(word))
+		rest = rest[end:]
+	}
+	return words, nil
+}
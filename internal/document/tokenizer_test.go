@@ -0,0 +1,44 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestParseTokenizer(t *testing.T) {
+	valid := map[string]string{
+		"":                                     "",
+		"  ":                                   "",
+		"trigram":                              "trigram",
+		"Porter":                               "porter unicode61",
+		"porter unicode61 remove_diacritics 2": "porter unicode61 remove_diacritics 2",
+		"unicode61 tokenchars '_-'":            "unicode61 tokenchars '_-'",
+		"unicode61 categories 'L* N* Co'":      "unicode61 categories 'L* N* Co'",
+		"trigram case_sensitive 1":             "trigram case_sensitive 1",
+		"ascii separators '.'":                 "ascii separators '.'",
+		"porter ascii":                         "porter ascii",
+	}
+	for value, want := range valid {
+		got, err := ParseTokenizer(value)
+		if err != nil || got != want {
+			t.Errorf("ParseTokenizer(%q) = %q, %v; want %q", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{
+		"icu",
+		"porter trigram",
+		"unicode61 remove_diacritics",
+		"unicode61 remove_diacritics 3",
+		"trigram tokenchars '_'",
+		"unicode61 tokenchars _",
+		"unicode61 tokenchars '",
+		"unicode61 tokenchars ''",
+		`unicode61 tokenchars '"'`,
+		`unicode61") ; DROP TABLE x; --`,
+		"unicode61 categories 'Letters'",
+	} {
+		if _, err := ParseTokenizer(value); err == nil {
+			t.Errorf("Expected ParseTokenizer(%q) to fail", value)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tree entry types
+const (
+	EntryDocument = "document"
+	EntryFolder   = "folder"
+)
+
+// TreeEntry is an immediate child of a folder in the slash-delimited id space.
+// Folders are derived from ids and exist only while they contain documents.
+type TreeEntry struct {
+	Name        string `json:"name"` // Last path segment
+	Path        string `json:"path"` // Document id, or folder prefix ending in '/'
+	Type        string `json:"type"` // "document" or "folder"
+	ContentType string `json:"content_type,omitempty"`
+	IsBinary    bool   `json:"is_binary,omitempty"`
+	Size        int64  `json:"size,omitempty"`    // Stored content length, inline or streamed
+	Version     int    `json:"version,omitempty"` // Documents only
+	ModifiedAt  int64  `json:"modified_at"`       // Latest modification within a folder
+	Documents   int    `json:"documents,omitempty"`
+}
+
+// NormalizeFolder turns a folder path into the id prefix it covers:
+// "" is the root and "pages" becomes "pages/"
+func NormalizeFolder(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// ListChildren lists the documents and folder stubs directly under a folder.
+// Folders come first, then documents, each ordered by name. Folder entries
+// count every document beneath them.
+func ListChildren(db *sql.DB, folder string) ([]TreeEntry, error) {
+	folder = NormalizeFolder(folder)
+
+	// substr rather than LIKE so '%' and '_' in ids match literally
+	rows, err := db.Query(`
+		SELECT id, content_type, is_binary,
+		       length(content) + COALESCE((
+		           SELECT SUM(length(data)) FROM _wce_document_blobs
+		           WHERE document_id = _wce_documents.id
+		       ), 0),
+		       version, modified_at
+		FROM _wce_documents
+		WHERE substr(id, 1, ?) = ?
+		ORDER BY id
+	`, len(folder), folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	folders := map[string]*TreeEntry{}
+	entries := []TreeEntry{}
+
+	for rows.Next() {
+		var id, contentType string
+		var isBinaryInt int
+		var size, modifiedAt int64
+		var version int
+		if err := rows.Scan(&id, &contentType, &isBinaryInt, &size, &version, &modifiedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+
+		rest := id[len(folder):]
+		if name, _, nested := strings.Cut(rest, "/"); nested {
+			entry, ok := folders[name]
+			if !ok {
+				entry = &TreeEntry{Name: name, Path: folder + name + "/", Type: EntryFolder}
+				folders[name] = entry
+			}
+			entry.Documents++
+			if modifiedAt > entry.ModifiedAt {
+				entry.ModifiedAt = modifiedAt
+			}
+			continue
+		}
+
+		entries = append(entries, TreeEntry{
+			Name:        rest,
+			Path:        id,
+			Type:        EntryDocument,
+			ContentType: contentType,
+			IsBinary:    isBinaryInt == 1,
+			Size:        size,
+			Version:     version,
+			ModifiedAt:  modifiedAt,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating documents: %w", err)
+	}
+
+	stubs := make([]TreeEntry, 0, len(folders)+len(entries))
+	for _, entry := range folders {
+		stubs = append(stubs, *entry)
+	}
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].Name < stubs[j].Name })
+
+	return append(stubs, entries...), nil
+}
@@ -0,0 +1,53 @@
+package document
+
+import "testing"
+
+func TestListChildren(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"index", "pages", "pages/home", "pages/docs/intro", "pages/docs/a/deep", "pages_old/x"} {
+		if _, err := CreateDocument(db, id, "content", "text/plain", "user-1", false, false); err != nil {
+			t.Fatalf("CreateDocument %s failed: %v", id, err)
+		}
+	}
+
+	root, err := ListChildren(db, "")
+	if err != nil {
+		t.Fatalf("ListChildren failed: %v", err)
+	}
+	names := []string{}
+	for _, e := range root {
+		names = append(names, e.Type+":"+e.Name)
+	}
+	want := []string{"folder:pages", "folder:pages_old", "document:index", "document:pages"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, names)
+			break
+		}
+	}
+
+	// The '_' in pages_old must not match as a LIKE wildcard
+	pages, err := ListChildren(db, "/pages")
+	if err != nil {
+		t.Fatalf("ListChildren failed: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("Expected docs folder and home, got %+v", pages)
+	}
+	if pages[0].Path != "pages/docs/" || pages[0].Documents != 2 {
+		t.Errorf("Unexpected folder stub: %+v", pages[0])
+	}
+	if pages[1].Path != "pages/home" || pages[1].Size != int64(len("content")) {
+		t.Errorf("Unexpected document entry: %+v", pages[1])
+	}
+
+	empty, err := ListChildren(db, "missing/")
+	if err != nil || len(empty) != 0 {
+		t.Errorf("Expected no children, got %v (%v)", empty, err)
+	}
+}
@@ -0,0 +1,74 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+)
+
+// DB is satisfied by *sql.DB and by a *sql.Tx the caller began. Writes given
+// a transaction run inside it under a savepoint, so a caller combining
+// several writes commits or rolls them back together.
+type DB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// savepoints numbers the savepoints of writes joining a caller's transaction
+var savepoints atomic.Uint64
+
+// txn is the transaction of one document write: its own transaction when
+// given a *sql.DB, or a savepoint within the caller's transaction
+type txn struct {
+	DB
+	tx        *sql.Tx
+	savepoint string
+	done      bool
+}
+
+// begin starts the transaction of a document write on db
+func begin(db DB) (*txn, error) {
+	if pool, ok := db.(*sql.DB); ok {
+		tx, err := pool.Begin()
+		if err != nil {
+			return nil, err
+		}
+		return &txn{DB: tx, tx: tx}, nil
+	}
+
+	name := fmt.Sprintf("wce_write_%d", savepoints.Add(1))
+	if _, err := db.Exec("SAVEPOINT " + name); err != nil {
+		return nil, err
+	}
+	return &txn{DB: db, savepoint: name}, nil
+}
+
+// Commit commits the write, which within a caller's transaction takes
+// effect when the caller commits
+func (t *txn) Commit() error {
+	if t.tx != nil {
+		return t.tx.Commit()
+	}
+	t.done = true
+	_, err := t.Exec("RELEASE " + t.savepoint)
+	return err
+}
+
+// Rollback undoes the write unless it was committed, leaving a caller's
+// transaction as it was before the write. Like sql.Tx.Rollback it can be
+// deferred.
+func (t *txn) Rollback() error {
+	if t.tx != nil {
+		return t.tx.Rollback()
+	}
+	if t.done {
+		return nil
+	}
+	t.done = true
+	if _, err := t.Exec("ROLLBACK TO " + t.savepoint); err != nil {
+		return err
+	}
+	_, err := t.Exec("RELEASE " + t.savepoint)
+	return err
+}
@@ -0,0 +1,59 @@
+package document
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWritesJoinTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// A rolled back transaction leaves none of its writes
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := CreateDocument(tx, "notes/a", "first", "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	if _, err := SetDocumentMetadata(tx, "notes/a", json.RawMessage(`{"draft": true}`), "user-1"); err != nil {
+		t.Fatalf("SetDocumentMetadata failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if _, err := GetDocument(db, "notes/a"); err == nil {
+		t.Error("Expected the rolled back document to be gone")
+	}
+
+	// A failing write undoes only itself, and the rest commit together
+	if _, err := db.Exec("INSERT INTO _wce_config (key, value, updated_at) VALUES (?, '1', 0)", StorageQuotaConfigKey); err != nil {
+		t.Fatalf("Failed to set quota: %v", err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := CreateDocument(tx, "notes/b", "second", "text/plain", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	huge := make([]byte, 2<<20)
+	for i := range huge {
+		huge[i] = 'x'
+	}
+	if _, err := UpdateDocument(tx, "notes/b", string(huge), "user-1"); err == nil {
+		t.Fatal("Expected the update over the quota to fail")
+	}
+	if _, err := SetDocumentTags(tx, "notes/b", []string{"kept"}); err != nil {
+		t.Fatalf("SetDocumentTags failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	doc, err := GetDocument(db, "notes/b")
+	if err != nil || doc.Content != "second" || doc.Version != 1 || len(doc.Tags) != 1 {
+		t.Errorf("Expected the document committed without the failed update, got %+v (%v)", doc, err)
+	}
+}
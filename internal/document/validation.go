@@ -0,0 +1,138 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// isJSONContentType reports whether contentType is application/json,
+// ignoring parameters such as charset
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// loadSchema reads and compiles the JSON Schema stored as document schemaID
+func loadSchema(db queryer, schemaID string) (*jsonSchema, error) {
+	var content, compression string
+	var isBinary int
+	err := db.QueryRow(
+		`SELECT content, is_binary, COALESCE(compression, '') FROM _wce_documents WHERE id = ?`, schemaID,
+	).Scan(&content, &isBinary, &compression)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("schema document %s does not exist", schemaID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema document: %w", err)
+	}
+	if isBinary == 1 {
+		return nil, fmt.Errorf("schema document %s is binary", schemaID)
+	}
+	if content, err = DecodeContent(content, compression); err != nil {
+		return nil, err
+	}
+
+	schema, err := compileSchema([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("schema document %s: %w", schemaID, err)
+	}
+	return schema, nil
+}
+
+// validateAgainstSchema checks content against the schema stored as document
+// schemaID, returning a *SchemaError listing every failure
+func validateAgainstSchema(db queryer, schemaID, content string) error {
+	schema, err := loadSchema(db, schemaID)
+	if err != nil {
+		return err
+	}
+	if errs := schema.validateContent([]byte(content)); len(errs) > 0 {
+		return &SchemaError{SchemaID: schemaID, Errors: errs}
+	}
+	return nil
+}
+
+// checkSchemaAttachment verifies that a document with contentType can be
+// validated against schemaID
+func checkSchemaAttachment(id, contentType, schemaID string, isBinary bool) error {
+	if isBinary || !isJSONContentType(contentType) {
+		return fmt.Errorf("only application/json documents can have a schema")
+	}
+	if schemaID == id {
+		return fmt.Errorf("a document cannot be its own schema")
+	}
+	return nil
+}
+
+// schemaUsers counts the documents and typed collections validated against
+// document id
+func schemaUsers(db queryer, id string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM _wce_documents WHERE schema_id = ?)
+		     + (SELECT COUNT(*) FROM _wce_collections WHERE schema_id = ?)
+	`, id, id).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check schema usage: %w", err)
+	}
+	return count, nil
+}
+
+// SetDocumentSchema attaches the JSON Schema stored as document schemaID to
+// document id, or detaches it when schemaID is empty. The document's current
+// content must already match. The content version is unchanged; the
+// modification time and author are updated.
+func SetDocumentSchema(db DB, id, schemaID, userID string) (*Document, error) {
+	if id == "" {
+		return nil, fmt.Errorf("document id cannot be empty")
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+
+	doc, err := GetDocument(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if schemaID != "" {
+		if err := checkSchemaAttachment(id, doc.ContentType, schemaID, doc.IsBinary); err != nil {
+			return nil, err
+		}
+		if err := validateAgainstSchema(db, schemaID, doc.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	var schemaParam interface{}
+	if schemaID != "" {
+		schemaParam = schemaID
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_documents
+		SET schema_id = ?, modified_at = ?, modified_by = ?
+		WHERE id = ? AND version = ?
+	`, schemaParam, clock.Now().Unix(), userID, id, doc.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update schema: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("document %s was modified concurrently", id)
+	}
+
+	return GetDocument(db, id)
+}
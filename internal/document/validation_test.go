@@ -0,0 +1,147 @@
+package document
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const productSchema = `{
+	"type": "object",
+	"required": ["sku", "price"],
+	"properties": {
+		"sku": {"type": "string"},
+		"price": {"type": "number", "minimum": 0}
+	}
+}`
+
+func TestCreateDocumentWithSchema(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := CreateDocument(db, "schemas/product", productSchema, "application/schema+json", "user-1", false, false); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	doc, err := CreateDocumentWithSchema(db, "products/a", `{"sku": "A1", "price": 9.5}`, "application/json", "user-1", "schemas/product", false, true)
+	if err != nil {
+		t.Fatalf("CreateDocumentWithSchema failed: %v", err)
+	}
+	if doc.SchemaID != "schemas/product" {
+		t.Errorf("Expected schema_id on created document, got %q", doc.SchemaID)
+	}
+	if got, _ := GetDocument(db, "products/a"); got.SchemaID != "schemas/product" {
+		t.Errorf("Expected schema_id to be stored, got %q", got.SchemaID)
+	}
+
+	_, err = CreateDocumentWithSchema(db, "products/b", `{"sku": 2, "price": -1}`, "application/json; charset=utf-8", "user-1", "schemas/product", false, true)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected a SchemaError, got %v", err)
+	}
+	if schemaErr.SchemaID != "schemas/product" || len(schemaErr.Errors) != 2 {
+		t.Errorf("Unexpected validation errors: %+v", schemaErr.Errors)
+	}
+	if _, err := GetDocument(db, "products/b"); err == nil {
+		t.Error("Expected invalid document not to be created")
+	}
+
+	rejected := []struct {
+		name, id, contentType, schemaID, want string
+	}{
+		{"NotJSON", "notes/a", "text/plain", "schemas/product", "only application/json"},
+		{"MissingSchema", "products/c", "application/json", "schemas/missing", "does not exist"},
+		{"OwnSchema", "products/d", "application/json", "products/d", "its own schema"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CreateDocumentWithSchema(db, tt.id, `{"sku": "x", "price": 1}`, tt.contentType, "user-1", tt.schemaID, false, true)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestUpdateDocument_Schema(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "schemas/product", productSchema, "application/json", "user-1", false, false)
+	CreateDocumentWithSchema(db, "products/a", `{"sku": "A1", "price": 1}`, "application/json", "user-1", "schemas/product", false, true)
+
+	if _, err := UpdateDocument(db, "products/a", `{"sku": "A1", "price": 2}`, "user-1"); err != nil {
+		t.Fatalf("Expected valid update to succeed: %v", err)
+	}
+
+	_, err := UpdateDocument(db, "products/a", `{"sku": "A1"}`, "user-1")
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || schemaErr.Errors[0].Message != `missing required property "price"` {
+		t.Fatalf("Expected missing property error, got %v", err)
+	}
+	if doc, _ := GetDocument(db, "products/a"); doc.Version != 2 {
+		t.Errorf("Expected rejected update to leave version 2, got %d", doc.Version)
+	}
+
+	// Restoring a revision is validated like any other update
+	if _, err := SetDocumentSchema(db, "products/a", "", "user-1"); err != nil {
+		t.Fatalf("Failed to detach schema: %v", err)
+	}
+	UpdateDocument(db, "products/a", `{"sku": "A1"}`, "user-1")
+	if _, err := SetDocumentSchema(db, "products/a", "schemas/product", "user-1"); !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected attaching to invalid content to fail, got %v", err)
+	}
+	UpdateDocument(db, "products/a", `{"sku": "A1", "price": 3}`, "user-1")
+	if _, err := SetDocumentSchema(db, "products/a", "schemas/product", "user-1"); err != nil {
+		t.Fatalf("Failed to attach schema: %v", err)
+	}
+	if _, err := RestoreDocumentVersion(db, "products/a", 3, "user-1"); !errors.As(err, &schemaErr) {
+		t.Errorf("Expected restoring an invalid revision to fail, got %v", err)
+	}
+
+	// A schema in use must stay a schema and cannot be deleted
+	if _, err := UpdateDocument(db, "schemas/product", `{"type": "nonsense"}`, "user-1"); err == nil {
+		t.Error("Expected invalid schema update to be rejected")
+	}
+	if _, err := UpdateDocument(db, "schemas/product", `{"type": "object"}`, "user-1"); err != nil {
+		t.Errorf("Expected valid schema update to succeed: %v", err)
+	}
+	if err := DeleteDocument(db, "schemas/product", "user-1"); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Expected schema in use not to be deleted, got %v", err)
+	}
+}
+
+func TestMoveAndCopyDocument_Schema(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	CreateDocument(db, "schemas/product", productSchema, "application/json", "user-1", false, false)
+	CreateDocumentWithSchema(db, "products/a", `{"sku": "A1", "price": 1}`, "application/json", "user-1", "schemas/product", false, true)
+
+	copied, err := CopyDocument(db, "products/a", "products/b", "user-1")
+	if err != nil {
+		t.Fatalf("CopyDocument failed: %v", err)
+	}
+	if copied.SchemaID != "schemas/product" {
+		t.Errorf("Expected copy to keep its schema, got %q", copied.SchemaID)
+	}
+
+	if _, err := MoveDocument(db, "schemas/product", "schemas/item", "user-1"); err != nil {
+		t.Fatalf("MoveDocument failed: %v", err)
+	}
+	for _, id := range []string{"products/a", "products/b"} {
+		if doc, _ := GetDocument(db, id); doc.SchemaID != "schemas/item" {
+			t.Errorf("Expected %s to follow its schema, got %q", id, doc.SchemaID)
+		}
+	}
+
+	docs, err := ListDocuments(db, "products/", 10, 0)
+	if err != nil {
+		t.Fatalf("ListDocuments failed: %v", err)
+	}
+	for _, doc := range docs {
+		if doc.SchemaID != "schemas/item" {
+			t.Errorf("Expected listed %s to carry its schema, got %q", doc.ID, doc.SchemaID)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DocumentVersion is a revision of a document. Content is omitted when listing.
+type DocumentVersion struct {
+	DocumentID  string `json:"document_id"`
+	Version     int    `json:"version"`
+	Content     string `json:"content,omitempty"`
+	ContentType string `json:"content_type"`
+	IsBinary    bool   `json:"is_binary"`
+	ModifiedAt  int64  `json:"modified_at"`
+	ModifiedBy  string `json:"modified_by"`
+	Current     bool   `json:"current"`
+}
+
+// ListDocumentVersions lists every revision of a document, newest first,
+// including the current one
+func ListDocumentVersions(db *sql.DB, id string) ([]DocumentVersion, error) {
+	current, err := GetDocument(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT document_id, version, content_type, is_binary, modified_at, modified_by
+		FROM _wce_document_versions
+		WHERE document_id = ?
+		ORDER BY version DESC
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list document versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []DocumentVersion{currentVersion(current)}
+	versions[0].Content = ""
+
+	for rows.Next() {
+		var v DocumentVersion
+		var isBinaryInt int
+		if err := rows.Scan(&v.DocumentID, &v.Version, &v.ContentType, &isBinaryInt, &v.ModifiedAt, &v.ModifiedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan document version: %w", err)
+		}
+		v.IsBinary = isBinaryInt == 1
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating document versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// GetDocumentVersion retrieves a single revision of a document with its content
+func GetDocumentVersion(db *sql.DB, id string, version int) (*DocumentVersion, error) {
+	current, err := GetDocument(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if version == current.Version {
+		v := currentVersion(current)
+		return &v, nil
+	}
+
+	var v DocumentVersion
+	var isBinaryInt int
+	var compression string
+	err = db.QueryRow(`
+		SELECT document_id, version, content, content_type, is_binary, modified_at, modified_by, COALESCE(compression, '')
+		FROM _wce_document_versions
+		WHERE document_id = ? AND version = ?
+	`, id, version).Scan(&v.DocumentID, &v.Version, &v.Content, &v.ContentType, &isBinaryInt, &v.ModifiedAt, &v.ModifiedBy, &compression)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("document version not found: %s@%d", id, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document version: %w", err)
+	}
+	if v.Content, err = DecodeContent(v.Content, compression); err != nil {
+		return nil, err
+	}
+	v.IsBinary = isBinaryInt == 1
+
+	return &v, nil
+}
+
+// RestoreDocumentVersion makes the content of a past revision current again.
+// The restore is a regular update, so it gets a new version number and the
+// replaced content is archived like any other.
+func RestoreDocumentVersion(db *sql.DB, id string, version int, userID string) (*Document, error) {
+	v, err := GetDocumentVersion(db, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if v.Current {
+		return nil, fmt.Errorf("version %d is already the current version", version)
+	}
+
+	return UpdateDocument(db, id, v.Content, userID)
+}
+
+// currentVersion describes a document's current content as a revision
+func currentVersion(doc *Document) DocumentVersion {
+	return DocumentVersion{
+		DocumentID:  doc.ID,
+		Version:     doc.Version,
+		Content:     doc.Content,
+		ContentType: doc.ContentType,
+		IsBinary:    doc.IsBinary,
+		ModifiedAt:  doc.ModifiedAt,
+		ModifiedBy:  doc.ModifiedBy,
+		Current:     true,
+	}
+}
@@ -0,0 +1,79 @@
+package document
+
+import "testing"
+
+func TestDocumentVersions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := CreateDocument(db, "pages/home", "v1", "text/html", "user-1", false, true); err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	for _, content := range []string{"v2", "v3"} {
+		if _, err := UpdateDocument(db, "pages/home", content, "user-1"); err != nil {
+			t.Fatalf("UpdateDocument failed: %v", err)
+		}
+	}
+
+	versions, err := ListDocumentVersions(db, "pages/home")
+	if err != nil {
+		t.Fatalf("ListDocumentVersions failed: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Expected 3 versions, got %d", len(versions))
+	}
+	if versions[0].Version != 3 || !versions[0].Current || versions[2].Version != 1 || versions[2].Current {
+		t.Errorf("Unexpected version order: %+v", versions)
+	}
+	if versions[0].Content != "" || versions[1].Content != "" {
+		t.Error("Listing should omit content")
+	}
+
+	v, err := GetDocumentVersion(db, "pages/home", 1)
+	if err != nil {
+		t.Fatalf("GetDocumentVersion failed: %v", err)
+	}
+	if v.Content != "v1" || v.Current {
+		t.Errorf("Unexpected version 1: %+v", v)
+	}
+
+	v, err = GetDocumentVersion(db, "pages/home", 3)
+	if err != nil || v.Content != "v3" || !v.Current {
+		t.Errorf("Expected current version 3, got %+v (%v)", v, err)
+	}
+
+	if _, err := GetDocumentVersion(db, "pages/home", 9); err == nil {
+		t.Error("Expected error for unknown version")
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		doc, err := RestoreDocumentVersion(db, "pages/home", 1, "user-1")
+		if err != nil {
+			t.Fatalf("RestoreDocumentVersion failed: %v", err)
+		}
+		if doc.Content != "v1" || doc.Version != 4 {
+			t.Errorf("Expected v1 as version 4, got %q version %d", doc.Content, doc.Version)
+		}
+
+		v, err := GetDocumentVersion(db, "pages/home", 3)
+		if err != nil || v.Content != "v3" {
+			t.Errorf("Expected replaced content archived, got %+v (%v)", v, err)
+		}
+
+		if _, err := RestoreDocumentVersion(db, "pages/home", 4, "user-1"); err == nil {
+			t.Error("Expected error restoring the current version")
+		}
+	})
+
+	t.Run("DeleteCascades", func(t *testing.T) {
+		if err := DeleteDocument(db, "pages/home", "user-1"); err != nil {
+			t.Fatalf("DeleteDocument failed: %v", err)
+		}
+
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM _wce_document_versions`).Scan(&count)
+		if count != 0 {
+			t.Errorf("Expected versions removed with document, %d remain", count)
+		}
+	})
+}
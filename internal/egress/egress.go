@@ -0,0 +1,123 @@
+// Package egress guards outbound connections to URLs that cenv admins
+// configure, such as proxy upstreams and alert webhooks. Loopback, private,
+// link-local and unspecified addresses are refused when the connection is
+// dialled, after DNS resolution, so a hostname cannot be pointed (or
+// rebound) at the server's own network or a cloud metadata service.
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned when dialling an address outbound requests may not
+// reach
+var ErrBlocked = errors.New("destination address is not allowed")
+
+// blockedPrefixes are internal ranges not covered by the netip.Addr checks
+// in Blocked
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT, used for some cloud metadata
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, which embeds IPv4 addresses
+}
+
+// Blocked reports whether addr is a loopback, private, link-local,
+// multicast or unspecified address
+func Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Guard refuses connections to blocked addresses, except those in the
+// prefixes it was created with, e.g. an internal API an operator trusts
+type Guard struct {
+	allowed []netip.Prefix
+}
+
+// NewGuard creates a guard that lets connections to allowed through
+func NewGuard(allowed ...netip.Prefix) *Guard {
+	return &Guard{allowed: allowed}
+}
+
+// Permits reports whether connections to addr are allowed
+func (g *Guard) Permits(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range g.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return !Blocked(addr)
+}
+
+// Control is a net.Dialer Control hook. It sees the resolved address each
+// connection is about to use, so it also covers redirects and every address
+// a hostname resolves to.
+func (g *Guard) Control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlocked, address)
+	}
+	if !g.Permits(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlocked, addrPort.Addr())
+	}
+	return nil
+}
+
+// Transport returns an HTTP transport that dials through the guard.
+// Environment proxies are not used, as the guard would only see the proxy's
+// address.
+func (g *Guard) Transport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   g.Control,
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// ParsePrefixes parses a comma-separated list of CIDR prefixes or single
+// addresses
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or prefix: %s", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,68 @@
+package egress
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestBlocked(t *testing.T) {
+	blocked := []string{
+		"127.0.0.1", "127.255.255.254", "::1", // Loopback
+		"10.0.0.1", "172.16.0.1", "172.31.255.255", "192.168.1.1", "fd00::1", // Private
+		"169.254.169.254", "fe80::1", // Link-local, cloud metadata
+		"0.0.0.0", "::", "0.1.2.3", // Unspecified and "this" network
+		"100.100.100.200",                     // Carrier-grade NAT
+		"::ffff:127.0.0.1", "::ffff:10.0.0.1", // IPv4-mapped
+		"64:ff9b::a9fe:a9fe",   // NAT64 of 169.254.169.254
+		"224.0.0.1", "ff02::1", // Multicast
+	}
+	for _, s := range blocked {
+		if !Blocked(netip.MustParseAddr(s)) {
+			t.Errorf("Expected %s blocked", s)
+		}
+	}
+
+	for _, s := range []string{"8.8.8.8", "93.184.216.34", "172.32.0.1", "2606:4700::1111"} {
+		if Blocked(netip.MustParseAddr(s)) {
+			t.Errorf("Expected %s allowed", s)
+		}
+	}
+}
+
+func TestGuardAllowlist(t *testing.T) {
+	guard := NewGuard(netip.MustParsePrefix("10.1.0.0/16"))
+	if !guard.Permits(netip.MustParseAddr("10.1.2.3")) || guard.Permits(netip.MustParseAddr("10.2.0.1")) {
+		t.Error("Expected only the allowed prefix let through")
+	}
+
+	prefixes, err := ParsePrefixes(" 10.1.0.0/16, 192.168.1.5 ,")
+	if err != nil || len(prefixes) != 2 || prefixes[1].String() != "192.168.1.5/32" {
+		t.Errorf("Unexpected prefixes: %v %v", prefixes, err)
+	}
+	if _, err := ParsePrefixes("internal"); err == nil {
+		t.Error("Expected an invalid prefix refused")
+	}
+}
+
+func TestTransportDialsThroughGuard(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// localhost resolves to loopback, so a hostname is no way around the guard
+	for _, url := range []string{server.URL, "http://localhost:" + server.URL[len("http://127.0.0.1:"):]} {
+		_, err := (&http.Client{Transport: NewGuard().Transport()}).Get(url)
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("Expected %s refused, got %v", url, err)
+		}
+	}
+
+	client := &http.Client{Transport: NewGuard(netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")).Transport()}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected an allowed address reachable: %v", err)
+	}
+	resp.Body.Close()
+}
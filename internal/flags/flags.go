@@ -0,0 +1,259 @@
+// Package flags stores a cenv's feature flags, which let cenv apps ship
+// gated features: on or off for everyone, rolled out to a percentage of
+// users, or overridden for single users. Scripts read them with
+// flags.enabled(name) and templates with {% if flags.name %}.
+package flags
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// validName matches flag names, which templates read as attributes
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Flag is a feature flag and its per-user overrides
+type Flag struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Enabled     bool            `json:"enabled"`
+	Rollout     int             `json:"rollout"`
+	Overrides   map[string]bool `json:"overrides"` // user_id -> enabled
+	ModifiedAt  int64           `json:"modified_at"`
+	ModifiedBy  string          `json:"modified_by,omitempty"`
+}
+
+// IsValidName checks if a flag name is allowed
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Validate checks a flag's name and rollout
+func (f *Flag) Validate() error {
+	if !IsValidName(f.Name) {
+		return fmt.Errorf("invalid flag name: must be lowercase letters, digits and '_', starting with a letter")
+	}
+	if f.Rollout < 0 || f.Rollout > 100 {
+		return fmt.Errorf("rollout must be between 0 and 100")
+	}
+	return nil
+}
+
+// Put creates or replaces a flag's state, description and rollout. Its
+// overrides are kept.
+func Put(db *sql.DB, flag *Flag, userID string) error {
+	if err := flag.Validate(); err != nil {
+		return err
+	}
+
+	var modifiedBy interface{}
+	if userID != "" {
+		modifiedBy = userID
+	}
+	flag.ModifiedAt = time.Now().Unix()
+	flag.ModifiedBy = userID
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_feature_flags (name, description, enabled, rollout, modified_at, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			description = excluded.description,
+			enabled = excluded.enabled,
+			rollout = excluded.rollout,
+			modified_at = excluded.modified_at,
+			modified_by = excluded.modified_by
+	`, flag.Name, flag.Description, flag.Enabled, flag.Rollout, flag.ModifiedAt, modifiedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save flag: %w", err)
+	}
+
+	overrides, err := loadOverrides(db, flag.Name)
+	if err != nil {
+		return err
+	}
+	flag.Overrides = overrides
+	return nil
+}
+
+// Get returns a flag by name
+func Get(db *sql.DB, name string) (*Flag, error) {
+	row := db.QueryRow(`
+		SELECT name, description, enabled, rollout, modified_at, modified_by
+		FROM _wce_feature_flags WHERE name = ?
+	`, name)
+
+	flag, err := scanFlag(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("flag not found: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if flag.Overrides, err = loadOverrides(db, name); err != nil {
+		return nil, err
+	}
+	return flag, nil
+}
+
+// List returns all flags ordered by name
+func List(db *sql.DB) ([]Flag, error) {
+	rows, err := db.Query(`
+		SELECT name, description, enabled, rollout, modified_at, modified_by
+		FROM _wce_feature_flags ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flags: %w", err)
+	}
+	defer rows.Close()
+
+	flags := []Flag{}
+	for rows.Next() {
+		flag, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, *flag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating flags: %w", err)
+	}
+	rows.Close()
+
+	for i := range flags {
+		if flags[i].Overrides, err = loadOverrides(db, flags[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return flags, nil
+}
+
+// Delete removes a flag and its overrides
+func Delete(db *sql.DB, name string) error {
+	result, err := db.Exec(`DELETE FROM _wce_feature_flags WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete flag: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("flag not found: %s", name)
+	}
+	return nil
+}
+
+// SetOverride turns a flag on or off for one user, whatever its state and
+// rollout
+func SetOverride(db *sql.DB, name, userID string, enabled bool) error {
+	if _, err := Get(db, name); err != nil {
+		return err
+	}
+	var exists int
+	if err := db.QueryRow(`SELECT 1 FROM _wce_users WHERE user_id = ?`, userID).Scan(&exists); err != nil {
+		return fmt.Errorf("user not found: %s", userID)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_feature_flag_overrides (flag_name, user_id, enabled)
+		VALUES (?, ?, ?)
+		ON CONFLICT(flag_name, user_id) DO UPDATE SET enabled = excluded.enabled
+	`, name, userID, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to save override: %w", err)
+	}
+	return nil
+}
+
+// DeleteOverride returns a user to the flag's state and rollout
+func DeleteOverride(db *sql.DB, name, userID string) error {
+	result, err := db.Exec(`DELETE FROM _wce_feature_flag_overrides WHERE flag_name = ? AND user_id = ?`, name, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete override: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("override not found: %s for %s", name, userID)
+	}
+	return nil
+}
+
+// Enabled reports whether a flag is on for a user: a user's override wins,
+// then a disabled flag is off, and an enabled one is on for its rollout
+// percentage of users. Anonymous callers (an empty userID) only see flags
+// rolled out to everyone. Unknown flags are off.
+func Enabled(db *sql.DB, name, userID string) (bool, error) {
+	var enabled bool
+	var rollout int
+	var override sql.NullBool
+	err := db.QueryRow(`
+		SELECT f.enabled, f.rollout, o.enabled
+		FROM _wce_feature_flags f
+		LEFT JOIN _wce_feature_flag_overrides o ON o.flag_name = f.name AND o.user_id = ?
+		WHERE f.name = ?
+	`, userID, name).Scan(&enabled, &rollout, &override)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read flag: %w", err)
+	}
+
+	switch {
+	case override.Valid:
+		return override.Bool, nil
+	case !enabled:
+		return false, nil
+	case rollout >= 100:
+		return true, nil
+	case userID == "":
+		return false, nil
+	}
+	return bucket(name, userID) < rollout, nil
+}
+
+// bucket places a user in one of 100 buckets for a flag. Hashing the flag
+// name in with the user spreads different flags' rollouts over different
+// users, and a user's bucket never changes, so raising a rollout only adds
+// users.
+func bucket(name, userID string) int {
+	sum := sha256.Sum256([]byte(name + "\x00" + userID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// loadOverrides returns the per-user overrides of a flag
+func loadOverrides(db *sql.DB, name string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT user_id, enabled FROM _wce_feature_flag_overrides WHERE flag_name = ?`, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list overrides: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := map[string]bool{}
+	for rows.Next() {
+		var userID string
+		var enabled bool
+		if err := rows.Scan(&userID, &enabled); err != nil {
+			return nil, fmt.Errorf("failed to scan override: %w", err)
+		}
+		overrides[userID] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+// scanFlag scans a flag row selected in the standard column order
+func scanFlag(scanner interface{ Scan(...interface{}) error }) (*Flag, error) {
+	var flag Flag
+	var modifiedBy sql.NullString
+	err := scanner.Scan(&flag.Name, &flag.Description, &flag.Enabled, &flag.Rollout, &flag.ModifiedAt, &modifiedBy)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan flag: %w", err)
+	}
+	flag.ModifiedBy = modifiedBy.String
+	return &flag, nil
+}
@@ -0,0 +1,146 @@
+package flags
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		_, err := conn.Exec(`INSERT INTO _wce_users (user_id, username, password_hash, created_at) VALUES (?, ?, 'x', 0)`,
+			fmt.Sprintf("user-%d", i), fmt.Sprintf("user%d", i))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	return conn
+}
+
+func enabled(t *testing.T, conn *sql.DB, name, userID string) bool {
+	t.Helper()
+	on, err := Enabled(conn, name, userID)
+	if err != nil {
+		t.Fatalf("Enabled failed: %v", err)
+	}
+	return on
+}
+
+func TestFlags(t *testing.T) {
+	conn := setupTestDB(t)
+
+	if enabled(t, conn, "new_nav", "user-1") {
+		t.Error("Expected unknown flags to be off")
+	}
+
+	flag := &Flag{Name: "new_nav", Description: "Redesigned navigation", Enabled: true, Rollout: 100}
+	if err := Put(conn, flag, "user-0"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !enabled(t, conn, "new_nav", "user-1") || !enabled(t, conn, "new_nav", "") {
+		t.Error("Expected a fully rolled out flag to be on for everyone")
+	}
+
+	// Overrides win over the flag's state
+	flag.Enabled = false
+	if err := Put(conn, flag, "user-0"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := SetOverride(conn, "new_nav", "user-2", true); err != nil {
+		t.Fatalf("SetOverride failed: %v", err)
+	}
+	if enabled(t, conn, "new_nav", "user-1") || !enabled(t, conn, "new_nav", "user-2") {
+		t.Error("Expected the flag off except for the overridden user")
+	}
+
+	got, err := Get(conn, "new_nav")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Description != "Redesigned navigation" || got.Enabled || !got.Overrides["user-2"] || got.ModifiedBy != "user-0" {
+		t.Errorf("Unexpected flag: %+v", got)
+	}
+
+	if err := DeleteOverride(conn, "new_nav", "user-2"); err != nil {
+		t.Fatalf("DeleteOverride failed: %v", err)
+	}
+	if enabled(t, conn, "new_nav", "user-2") {
+		t.Error("Expected the override to be gone")
+	}
+	if err := DeleteOverride(conn, "new_nav", "user-2"); err == nil {
+		t.Error("Expected deleting a missing override to fail")
+	}
+	if err := SetOverride(conn, "new_nav", "nobody", true); err == nil {
+		t.Error("Expected an override for an unknown user to fail")
+	}
+	if err := SetOverride(conn, "missing", "user-1", true); err == nil {
+		t.Error("Expected an override of an unknown flag to fail")
+	}
+
+	for _, bad := range []Flag{{Name: "New-Nav"}, {Name: "x", Rollout: 101}, {Name: "x", Rollout: -1}} {
+		if err := Put(conn, &bad, ""); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+
+	if err := Delete(conn, "new_nav"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if flags, _ := List(conn); len(flags) != 0 {
+		t.Errorf("Expected no flags, got %+v", flags)
+	}
+	if err := Delete(conn, "new_nav"); err == nil {
+		t.Error("Expected deleting a missing flag to fail")
+	}
+}
+
+func TestRollout(t *testing.T) {
+	conn := setupTestDB(t)
+
+	flag := &Flag{Name: "beta", Enabled: true, Rollout: 30}
+	if err := Put(conn, flag, ""); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	before := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		before[userID] = enabled(t, conn, "beta", userID)
+	}
+	count := 0
+	for _, on := range before {
+		if on {
+			count++
+		}
+	}
+	if count < 35 || count > 85 {
+		t.Errorf("Expected about 30%% of 200 users, got %d", count)
+	}
+	if enabled(t, conn, "beta", "") {
+		t.Error("Expected a partial rollout to be off for anonymous callers")
+	}
+
+	// Raising the rollout keeps everyone who had the flag
+	flag.Rollout = 60
+	if err := Put(conn, flag, ""); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for userID, on := range before {
+		if on && !enabled(t, conn, "beta", userID) {
+			t.Errorf("Expected %s to keep the flag", userID)
+		}
+	}
+}
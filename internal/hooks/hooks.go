@@ -0,0 +1,217 @@
+// Package hooks stores a cenv's hook scripts: Starlark scripts run after
+// document writes, for cache invalidation, denormalization and
+// notifications inside the cenv. A hook defines handle_event(event) and is
+// registered for one event; hooks of an event run in name order.
+package hooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Events hooks can be registered for
+const (
+	EventDocumentCreated = "on_document_created"
+	EventDocumentUpdated = "on_document_updated"
+	EventDocumentDeleted = "on_document_deleted"
+)
+
+// Events lists every event hooks can be registered for
+var Events = []string{EventDocumentCreated, EventDocumentUpdated, EventDocumentDeleted}
+
+// validName matches hook names
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Hook is a script run after every write of its event
+type Hook struct {
+	Name        string `json:"name"`
+	Event       string `json:"event"`
+	Script      string `json:"script,omitempty"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+
+	// Builtins the script may use (see starlark.AllCapabilities)
+	Capabilities []string `json:"capabilities"`
+
+	// Outcome of the latest run; LastError is empty when it succeeded
+	LastRunAt int64  `json:"last_run_at,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+
+	ModifiedAt int64  `json:"modified_at"`
+	ModifiedBy string `json:"modified_by,omitempty"`
+}
+
+// IsValidEvent checks if hooks can be registered for an event
+func IsValidEvent(event string) bool {
+	for _, known := range Events {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a hook's name, event and script
+func (h *Hook) Validate() error {
+	if !validName.MatchString(h.Name) {
+		return fmt.Errorf("invalid hook name: must be lowercase letters, digits and '_', starting with a letter")
+	}
+	if !IsValidEvent(h.Event) {
+		return fmt.Errorf("invalid event: must be one of %v", Events)
+	}
+	if h.Script == "" {
+		return fmt.Errorf("script is required")
+	}
+	return nil
+}
+
+// Put creates or replaces a hook. Its last run is kept while its script is
+// unchanged.
+func Put(db *sql.DB, hook *Hook, userID string) error {
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	if hook.Capabilities == nil {
+		hook.Capabilities = []string{}
+	}
+	capabilities, err := json.Marshal(hook.Capabilities)
+	if err != nil {
+		return fmt.Errorf("failed to encode capabilities: %w", err)
+	}
+
+	var modifiedBy interface{}
+	if userID != "" {
+		modifiedBy = userID
+	}
+	hook.ModifiedAt = time.Now().Unix()
+	hook.ModifiedBy = userID
+
+	_, err = db.Exec(`
+		INSERT INTO _wce_hooks (name, event, script, description, capabilities, enabled, modified_at, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			event = excluded.event,
+			last_run_at = CASE WHEN script = excluded.script THEN last_run_at END,
+			last_error = CASE WHEN script = excluded.script THEN last_error END,
+			script = excluded.script,
+			description = excluded.description,
+			capabilities = excluded.capabilities,
+			enabled = excluded.enabled,
+			modified_at = excluded.modified_at,
+			modified_by = excluded.modified_by
+	`, hook.Name, hook.Event, hook.Script, hook.Description, string(capabilities), hook.Enabled, hook.ModifiedAt, modifiedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save hook: %w", err)
+	}
+	return nil
+}
+
+// Get returns a hook by name, with its script
+func Get(db *sql.DB, name string) (*Hook, error) {
+	row := db.QueryRow(`
+		SELECT name, event, script, description, capabilities, enabled, last_run_at, last_error, modified_at, modified_by
+		FROM _wce_hooks WHERE name = ?
+	`, name)
+
+	hook, err := scanHook(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("hook not found: %s", name)
+	}
+	return hook, err
+}
+
+// List returns all hooks ordered by name, without their scripts
+func List(db *sql.DB) ([]Hook, error) {
+	hooks, err := query(db, `
+		SELECT name, event, '', description, capabilities, enabled, last_run_at, last_error, modified_at, modified_by
+		FROM _wce_hooks ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// ForEvent returns the enabled hooks of an event in the order they run,
+// with their scripts
+func ForEvent(db *sql.DB, event string) ([]Hook, error) {
+	hooks, err := query(db, `
+		SELECT name, event, script, description, capabilities, enabled, last_run_at, last_error, modified_at, modified_by
+		FROM _wce_hooks WHERE event = ? AND enabled = 1 ORDER BY name
+	`, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// Delete removes a hook
+func Delete(db *sql.DB, name string) error {
+	result, err := db.Exec(`DELETE FROM _wce_hooks WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete hook: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("hook not found: %s", name)
+	}
+	return nil
+}
+
+// RecordRun stores the outcome of a hook's run; runErr is nil on success
+func RecordRun(db *sql.DB, name string, runErr error) error {
+	var lastError interface{}
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	_, err := db.Exec(`UPDATE _wce_hooks SET last_run_at = ?, last_error = ? WHERE name = ?`,
+		time.Now().Unix(), lastError, name)
+	if err != nil {
+		return fmt.Errorf("failed to record hook run: %w", err)
+	}
+	return nil
+}
+
+// query runs a hook query selected in the standard column order
+func query(db *sql.DB, sqlStr string, args ...interface{}) ([]Hook, error) {
+	rows, err := db.Query(sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Hook{}
+	for rows.Next() {
+		hook, err := scanHook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+// scanHook scans a hook row selected in the standard column order
+func scanHook(scanner interface{ Scan(...interface{}) error }) (*Hook, error) {
+	var hook Hook
+	var capabilities string
+	var lastRunAt sql.NullInt64
+	var lastError, modifiedBy sql.NullString
+	err := scanner.Scan(&hook.Name, &hook.Event, &hook.Script, &hook.Description, &capabilities, &hook.Enabled,
+		&lastRunAt, &lastError, &hook.ModifiedAt, &modifiedBy)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan hook: %w", err)
+	}
+	if err := json.Unmarshal([]byte(capabilities), &hook.Capabilities); err != nil {
+		hook.Capabilities = []string{}
+	}
+	hook.LastRunAt = lastRunAt.Int64
+	hook.LastError = lastError.String
+	hook.ModifiedBy = modifiedBy.String
+	return &hook, nil
+}
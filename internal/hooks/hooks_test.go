@@ -0,0 +1,98 @@
+package hooks
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	return conn
+}
+
+const script = "def handle_event(event):\n    pass\n"
+
+func TestHooks(t *testing.T) {
+	conn := setupTestDB(t)
+
+	for _, hook := range []*Hook{
+		{Name: "Bad-Name", Event: EventDocumentCreated, Script: script},
+		{Name: "purge", Event: "on_document_read", Script: script},
+		{Name: "purge", Event: EventDocumentCreated},
+	} {
+		if err := Put(conn, hook, ""); err == nil {
+			t.Errorf("Expected %+v to be rejected", hook)
+		}
+	}
+
+	for _, hook := range []*Hook{
+		{Name: "purge_cache", Event: EventDocumentUpdated, Script: script, Enabled: true},
+		{Name: "count_docs", Event: EventDocumentUpdated, Script: script, Enabled: true},
+		{Name: "notify", Event: EventDocumentUpdated, Script: script},
+		{Name: "index", Event: EventDocumentCreated, Script: script, Enabled: true, Capabilities: []string{"db_write"}},
+	} {
+		if err := Put(conn, hook, ""); err != nil {
+			t.Fatalf("Put %s failed: %v", hook.Name, err)
+		}
+	}
+
+	// Only enabled hooks run, in name order
+	updated, err := ForEvent(conn, EventDocumentUpdated)
+	if err != nil {
+		t.Fatalf("ForEvent failed: %v", err)
+	}
+	if len(updated) != 2 || updated[0].Name != "count_docs" || updated[1].Name != "purge_cache" || updated[0].Script != script {
+		t.Errorf("Unexpected hooks for updates: %+v", updated)
+	}
+
+	if err := RecordRun(conn, "index", errors.New("boom")); err != nil {
+		t.Fatalf("RecordRun failed: %v", err)
+	}
+	hook, err := Get(conn, "index")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if hook.LastRunAt == 0 || hook.LastError != "boom" || len(hook.Capabilities) != 1 {
+		t.Errorf("Unexpected hook: %+v", hook)
+	}
+
+	// Saving the same script keeps the last run, a new script clears it
+	hook.Description = "Search index"
+	Put(conn, hook, "")
+	if hook, _ = Get(conn, "index"); hook.LastError != "boom" {
+		t.Errorf("Expected the last run to be kept, got %+v", hook)
+	}
+	hook.Script = script + "\n"
+	Put(conn, hook, "")
+	if hook, _ = Get(conn, "index"); hook.LastRunAt != 0 || hook.LastError != "" {
+		t.Errorf("Expected the last run to be cleared, got %+v", hook)
+	}
+
+	list, err := List(conn)
+	if err != nil || len(list) != 4 || list[0].Script != "" {
+		t.Errorf("Unexpected list: %+v (%v)", list, err)
+	}
+
+	if err := Delete(conn, "notify"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if err := Delete(conn, "notify"); err == nil {
+		t.Error("Expected deleting a missing hook to fail")
+	}
+	if _, err := Get(conn, "notify"); err == nil {
+		t.Error("Expected a deleted hook to be gone")
+	}
+}
@@ -0,0 +1,50 @@
+// Package keychain stores secrets, such as the token `wce login` receives,
+// in the operating system's credential store. It runs the store's own
+// command-line tool, security on macOS and secret-tool (libsecret) on
+// Linux, and passes secrets on standard input so they never appear in a
+// process listing.
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Service is the service name secrets are stored under
+const Service = "wce"
+
+// ErrUnsupported is returned on platforms without a supported credential
+// store
+var ErrUnsupported = errors.New("no supported keychain on this platform")
+
+// lookTool finds the store's command-line tool
+func lookTool(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s not found", ErrUnsupported, name)
+	}
+	return path, nil
+}
+
+// run runs a store command with stdin as its input, returning its error
+// output in the error when it fails
+func run(stdin string, name string, args ...string) error {
+	path, err := lookTool(name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", name, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
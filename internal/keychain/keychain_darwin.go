@@ -0,0 +1,26 @@
+package keychain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Available reports an error wrapping ErrUnsupported when the security tool
+// is missing
+func Available() error {
+	_, err := lookTool("security")
+	return err
+}
+
+// Store saves secret in the login keychain as a generic password for
+// account, replacing any secret already stored for it. The command goes to
+// security's interactive mode on stdin rather than its arguments.
+func Store(account, secret string) error {
+	for _, value := range []string{account, secret} {
+		if strings.ContainsAny(value, "'\n") {
+			return fmt.Errorf("keychain values cannot contain quotes or newlines")
+		}
+	}
+	command := fmt.Sprintf("add-generic-password -U -s '%s' -a '%s' -w '%s'\n", Service, account, secret)
+	return run(command, "security", "-i")
+}
@@ -0,0 +1,15 @@
+package keychain
+
+// Available reports an error wrapping ErrUnsupported when secret-tool is
+// not installed
+func Available() error {
+	_, err := lookTool("secret-tool")
+	return err
+}
+
+// Store saves secret in the Secret Service keyring (GNOME Keyring, KWallet)
+// for account, replacing any secret already stored for it
+func Store(account, secret string) error {
+	return run(secret, "secret-tool", "store", "--label", Service+" "+account,
+		"service", Service, "account", account)
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux
+
+package keychain
+
+// Available returns ErrUnsupported
+func Available() error {
+	return ErrUnsupported
+}
+
+// Store is unsupported here
+func Store(account, secret string) error {
+	return ErrUnsupported
+}
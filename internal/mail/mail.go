@@ -0,0 +1,182 @@
+// Package mail sends the server's emails, such as password reset and
+// address verification messages. A Mailer delivers each message;
+// SMTPMailer sends it through an SMTP relay.
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds an SMTP delivery when the context has no deadline
+const DefaultTimeout = 30 * time.Second
+
+// Message is a plain text email to one recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server. Connections are
+// upgraded with STARTTLS when the server offers it, or use TLS from the
+// start with ImplicitTLS (smtps://, usually port 465).
+type SMTPMailer struct {
+	Host        string
+	Port        int
+	Username    string // Optional; PLAIN authentication, only over TLS or to localhost
+	Password    string
+	From        string
+	ImplicitTLS bool
+}
+
+// ParseSMTPURL configures a mailer from a URL of the form
+// smtp://[user:password@]host[:port] or smtps://..., sending as from.
+// The port defaults to 587 for smtp and 465 for smtps.
+func ParseSMTPURL(raw, from string) (*SMTPMailer, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "smtp" && u.Scheme != "smtps") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SMTP URL: expected smtp://[user:password@]host[:port] or smtps://...")
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+
+	m := &SMTPMailer{
+		Host:        u.Hostname(),
+		Port:        587,
+		From:        from,
+		ImplicitTLS: u.Scheme == "smtps",
+	}
+	if m.ImplicitTLS {
+		m.Port = 465
+	}
+	if port := u.Port(); port != "" {
+		if m.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid SMTP port %q", port)
+		}
+	}
+	if u.User != nil {
+		m.Username = u.User.Username()
+		m.Password, _ = u.User.Password()
+	}
+	return m, nil
+}
+
+// Send delivers a message
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := m.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	address := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	if m.ImplicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !m.ImplicitTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(envelopeAddress(m.From)); err != nil {
+		return fmt.Errorf("sender refused: %w", err)
+	}
+	if err := client.Rcpt(envelopeAddress(msg.To)); err != nil {
+		return fmt.Errorf("recipient refused: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message refused: %w", err)
+	}
+	return client.Quit()
+}
+
+// compose renders a message with its headers, refusing header injection
+func (m *SMTPMailer) compose(msg *Message) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	for _, header := range []string{m.From, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("line breaks are not allowed in headers")
+		}
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), m.Host)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	b.WriteString(body)
+	if !strings.HasSuffix(body, "\r\n") {
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// envelopeAddress returns the bare address of "Name <addr>" for SMTP commands
+func envelopeAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return parsed.Address
+	}
+	return address
+}
@@ -0,0 +1,347 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// inline renders the inline content of a block: escapes, code spans,
+// emphasis, links, images, autolinks and line breaks. Everything else is
+// HTML-escaped text.
+func inline(s string) string {
+	var b strings.Builder
+	text := 0 // Start of the pending run of plain text
+	flush := func(i int) {
+		b.WriteString(html.EscapeString(s[text:i]))
+	}
+
+	for i := 0; i < len(s); {
+		out, end := inlineAt(s, i)
+		if end == i {
+			i++
+			continue
+		}
+		flush(i)
+		b.WriteString(out)
+		i, text = end, end
+	}
+	flush(len(s))
+	return b.String()
+}
+
+// inlineAt renders the construct starting at s[i], returning its HTML and
+// the index after it, or end == i when no construct starts there
+func inlineAt(s string, i int) (string, int) {
+	switch s[i] {
+	case '\\':
+		if i+1 < len(s) && s[i+1] == '\n' {
+			return "<br>\n", i + 2
+		}
+		if i+1 < len(s) && isPunct(s[i+1]) {
+			return html.EscapeString(s[i+1 : i+2]), i + 2
+		}
+	case ' ':
+		// Two or more spaces before a newline make a hard break
+		j := i
+		for j < len(s) && s[j] == ' ' {
+			j++
+		}
+		if j-i >= 2 && j < len(s) && s[j] == '\n' {
+			return "<br>\n", j + 1
+		}
+	case '`':
+		return codeSpan(s, i)
+	case '&':
+		if m := entityPattern.FindString(s[i:]); m != "" && html.UnescapeString(m) != m {
+			return m, i + len(m)
+		}
+	case '<':
+		return autolink(s, i)
+	case '!':
+		if i+1 < len(s) && s[i+1] == '[' {
+			if text, dest, title, end, ok := parseLink(s, i+1); ok {
+				if url, safe := safeURL(dest, false); safe {
+					out := `<img src="` + url + `" alt="` + html.EscapeString(plainText(text)) + `"`
+					if title != "" {
+						out += ` title="` + html.EscapeString(title) + `"`
+					}
+					return out + ">", end
+				}
+				return html.EscapeString(plainText(text)), end
+			}
+		}
+	case '[':
+		if text, dest, title, end, ok := parseLink(s, i); ok {
+			if url, safe := safeURL(dest, true); safe {
+				out := `<a href="` + url + `"`
+				if title != "" {
+					out += ` title="` + html.EscapeString(title) + `"`
+				}
+				return out + ">" + inline(text) + "</a>", end
+			}
+			return inline(text), end
+		}
+	case '*', '_', '~':
+		return emphasis(s, i)
+	}
+	return "", i
+}
+
+var entityPattern = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// unescapePunct removes backslashes escaping punctuation
+func unescapePunct(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// codeSpan renders a code span opened by the backtick run at s[i]
+func codeSpan(s string, i int) (string, int) {
+	n := runLength(s, i)
+	for j := i + n; j < len(s); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			break
+		}
+		j += k
+		m := runLength(s, j)
+		if m == n {
+			code := strings.ReplaceAll(s[i+n:j], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			return "<code>" + html.EscapeString(code) + "</code>", j + m
+		}
+		j += m
+	}
+	// An unmatched run is literal text
+	return html.EscapeString(s[i : i+n]), i + n
+}
+
+// runLength counts the repeats of s[i] starting at i
+func runLength(s string, i int) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+// emphasis renders strong, emphasised or struck-through text opened by the
+// delimiter run at s[i]
+func emphasis(s string, i int) (string, int) {
+	c := s[i]
+	n := runLength(s, i)
+	literal := html.EscapeString(s[i : i+n])
+
+	// Openers are followed by text; intraword underscores are literal
+	if i+n >= len(s) || isSpace(s[i+n]) || (c == '_' && i > 0 && isWordChar(s[i-1])) {
+		return literal, i + n
+	}
+
+	switch {
+	case c == '~':
+		if n == 2 {
+			if content, end, ok := findCloser(s, i+2, c, 2); ok {
+				return "<del>" + inline(content) + "</del>", end
+			}
+		}
+	case n >= 2:
+		if content, end, ok := findCloser(s, i+2, c, 2); ok {
+			return "<strong>" + inline(content) + "</strong>", end
+		}
+		fallthrough
+	default:
+		if content, end, ok := findCloser(s, i+1, c, 1); ok {
+			return "<em>" + inline(content) + "</em>", end
+		}
+	}
+	return literal, i + n
+}
+
+// findCloser finds the delimiter run closing an opener of width n whose
+// content starts at from. Strong closers take the last two characters of a
+// run of two or more, emphasis closers the last of a run other than two,
+// so "***a***" nests and "*a **b** c*" pairs as written.
+func findCloser(s string, from int, c byte, n int) (string, int, bool) {
+	for j := from; j < len(s); {
+		switch {
+		case s[j] == '\\':
+			j += 2
+			continue
+		case s[j] == '`':
+			_, j = codeSpan(s, j)
+			continue
+		case s[j] != c:
+			j++
+			continue
+		}
+
+		m := runLength(s, j)
+		closes := j > from && !isSpace(s[j-1])
+		if c == '_' && j+m < len(s) && isWordChar(s[j+m]) {
+			closes = false
+		}
+		switch {
+		case !closes:
+		case c == '~' && m == 2, c != '~' && n == 2 && m >= 2:
+			return s[from : j+m-n], j + m, true
+		case c != '~' && n == 1 && m != 2:
+			return s[from : j+m-1], j + m, true
+		}
+		j += m
+	}
+	return "", 0, false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n'
+}
+
+func isWordChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// parseLink parses "[text](destination "title")" starting at the bracket
+// at s[i]
+func parseLink(s string, i int) (text, dest, title string, end int, ok bool) {
+	depth := 0
+	closing := -1
+	for j := i; j < len(s) && closing < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			_, next := codeSpan(s, j)
+			j = next - 1
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closing = j
+			}
+		}
+	}
+	if closing < 0 || closing+1 >= len(s) || s[closing+1] != '(' {
+		return "", "", "", 0, false
+	}
+	text = s[i+1 : closing]
+
+	j := skipSpaces(s, closing+2)
+	if j < len(s) && s[j] == '<' {
+		k := strings.IndexAny(s[j:], ">\n")
+		if k < 0 || s[j+k] != '>' {
+			return "", "", "", 0, false
+		}
+		dest = s[j+1 : j+k]
+		j += k + 1
+	} else {
+		start, parens := j, 0
+		for ; j < len(s) && s[j] > ' '; j++ {
+			if s[j] == '\\' && j+1 < len(s) {
+				j++
+			} else if s[j] == '(' {
+				parens++
+			} else if s[j] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		dest = s[start:j]
+	}
+
+	j = skipSpaces(s, j)
+	if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+		quote := s[j]
+		k := strings.IndexByte(s[j+1:], quote)
+		if k < 0 {
+			return "", "", "", 0, false
+		}
+		title = unescapePunct(s[j+1 : j+1+k])
+		j = skipSpaces(s, j+k+2)
+	}
+	if j >= len(s) || s[j] != ')' {
+		return "", "", "", 0, false
+	}
+	return text, unescapePunct(dest), title, j + 1, true
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+var (
+	autolinkPattern = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*)>`)
+	emailPattern    = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*)>`)
+)
+
+// autolink renders "<https://...>" and "<user@example.com>"; anything else
+// starting with "<", raw HTML included, is left as escaped text
+func autolink(s string, i int) (string, int) {
+	if m := emailPattern.FindStringSubmatch(s[i:]); m != nil {
+		return `<a href="mailto:` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>", i + len(m[0])
+	}
+	if m := autolinkPattern.FindStringSubmatch(s[i:]); m != nil {
+		if url, safe := safeURL(m[1], true); safe {
+			return `<a href="` + url + `">` + html.EscapeString(m[1]) + "</a>", i + len(m[0])
+		}
+	}
+	return "", i
+}
+
+// safeURL checks a link or image destination and returns it escaped for an
+// attribute. Relative references are always allowed; absolute URLs must be
+// http or https, or mailto for links.
+func safeURL(dest string, link bool) (string, bool) {
+	dest = strings.TrimSpace(dest)
+	if colon := strings.IndexByte(dest, ':'); colon >= 0 && !strings.ContainsAny(dest[:colon], "/?#") {
+		switch strings.ToLower(dest[:colon]) {
+		case "http", "https":
+		case "mailto":
+			if !link {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+	}
+	return html.EscapeString(strings.ReplaceAll(dest, " ", "%20")), true
+}
+
+// plainText strips markup from inline source, for image alt text
+func plainText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '_', '~', '`', '[', ']':
+		case '\\':
+			if i+1 < len(s) && isPunct(s[i+1]) {
+				i++
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
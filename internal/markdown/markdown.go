@@ -0,0 +1,516 @@
+// Package markdown renders Markdown documents to HTML.
+//
+// It covers the commonly used part of CommonMark (headings, paragraphs,
+// emphasis, code, links, images, lists, block quotes and rules) plus
+// GitHub-style tables and strikethrough. The output is safe to serve as is:
+// raw HTML in the source is escaped rather than passed through, and link and
+// image URLs are limited to relative references and http, https and mailto.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ToHTML renders Markdown source to an HTML fragment
+func ToHTML(src string) string {
+	r := &renderer{ids: map[string]int{}}
+	r.blocks(splitLines(src), false)
+	return r.b.String()
+}
+
+// Title returns the plain text of the first level 1 heading, or "" if there
+// is none
+func Title(src string) string {
+	lines := splitLines(src)
+	for i := 0; i < len(lines); i++ {
+		if marker, _ := fenceOpen(lines[i]); marker != "" {
+			i = fenceEnd(lines, i, marker)
+			continue
+		}
+		if level, text := heading(lines[i]); level == 1 {
+			return plainText(text)
+		}
+	}
+	return ""
+}
+
+func splitLines(src string) []string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+	return strings.Split(src, "\n")
+}
+
+// renderer accumulates HTML and the heading ids handed out so far
+type renderer struct {
+	b   strings.Builder
+	ids map[string]int
+}
+
+// blocks renders a sequence of block-level lines. In a tight list item
+// paragraphs are written without <p> tags.
+func (r *renderer) blocks(lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case indent(line) >= 4:
+			i = r.indentedCode(lines, i)
+		default:
+			if marker, info := fenceOpen(line); marker != "" {
+				i = r.fencedCode(lines, i, marker, info)
+			} else if level, text := heading(line); level > 0 {
+				r.heading(level, text)
+				i++
+			} else if isRule(line) {
+				r.b.WriteString("<hr>\n")
+				i++
+			} else if isQuote(line) {
+				i = r.quote(lines, i)
+			} else if _, ok := listMarker(line); ok {
+				i = r.list(lines, i)
+			} else if isTableStart(lines, i) {
+				i = r.table(lines, i)
+			} else {
+				i = r.paragraph(lines, i, tight)
+			}
+		}
+	}
+}
+
+// indent counts the leading spaces of a line
+func indent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(line string) bool {
+	if indent(line) >= 4 {
+		return false
+	}
+	if marker, _ := fenceOpen(line); marker != "" {
+		return true
+	}
+	if level, _ := heading(line); level > 0 {
+		return true
+	}
+	if _, ok := listMarker(line); ok {
+		return true
+	}
+	return isRule(line) || isQuote(line)
+}
+
+func (r *renderer) paragraph(lines []string, i int, tight bool) int {
+	start := i
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (i == start || !startsBlock(lines[i])) {
+		i++
+	}
+	text := make([]string, 0, i-start)
+	for _, line := range lines[start:i] {
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+	content := inline(strings.TrimRight(strings.Join(text, "\n"), " "))
+	if tight {
+		r.b.WriteString(content + "\n")
+	} else {
+		r.b.WriteString("<p>" + content + "</p>\n")
+	}
+	return i
+}
+
+var headingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+
+// heading parses an ATX heading, returning level 0 when line is not one
+func heading(line string) (int, string) {
+	m := headingPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, ""
+	}
+	text := m[2]
+	if strings.Trim(text, "#") == "" {
+		text = ""
+	}
+	return len(m[1]), text
+}
+
+func (r *renderer) heading(level int, text string) {
+	tag := "h" + strconv.Itoa(level)
+	r.b.WriteString("<" + tag)
+	if id := r.headingID(text); id != "" {
+		r.b.WriteString(` id="` + id + `"`)
+	}
+	r.b.WriteString(">" + inline(text) + "</" + tag + ">\n")
+}
+
+// headingID returns a unique anchor for a heading, derived from its text
+func (r *renderer) headingID(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case c == ' ' || c == '-':
+			b.WriteByte('-')
+		case c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c > 127:
+			b.WriteRune(c)
+		}
+	}
+	id := strings.Trim(b.String(), "-")
+	if id == "" {
+		return ""
+	}
+	n := r.ids[id]
+	r.ids[id] = n + 1
+	if n > 0 {
+		id += "-" + strconv.Itoa(n)
+	}
+	return html.EscapeString(id)
+}
+
+func isRule(line string) bool {
+	if indent(line) >= 4 {
+		return false
+	}
+	compact := strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	if len(compact) < 3 {
+		return false
+	}
+	for _, c := range []string{"-", "*", "_"} {
+		if strings.Trim(compact, c) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fenceOpen returns the marker and info string of a code fence opening line
+func fenceOpen(line string) (string, string) {
+	if indent(line) >= 4 {
+		return "", ""
+	}
+	t := strings.TrimLeft(line, " ")
+	for _, c := range []byte{'`', '~'} {
+		n := 0
+		for n < len(t) && t[n] == c {
+			n++
+		}
+		if n >= 3 {
+			info := strings.TrimSpace(t[n:])
+			if c == '`' && strings.Contains(info, "`") {
+				return "", ""
+			}
+			return t[:n], info
+		}
+	}
+	return "", ""
+}
+
+// fenceEnd returns the index of the line closing the fence opened at i, or
+// len(lines) when the fence is never closed
+func fenceEnd(lines []string, i int, marker string) int {
+	for j := i + 1; j < len(lines); j++ {
+		t := strings.TrimSpace(lines[j])
+		if indent(lines[j]) < 4 && strings.HasPrefix(t, marker) && strings.Trim(t, marker[:1]) == "" {
+			return j
+		}
+	}
+	return len(lines)
+}
+
+func (r *renderer) fencedCode(lines []string, i int, marker, info string) int {
+	end := fenceEnd(lines, i, marker)
+	r.b.WriteString("<pre><code")
+	if lang, _, _ := strings.Cut(info, " "); lang != "" {
+		r.b.WriteString(` class="language-` + html.EscapeString(unescapePunct(lang)) + `"`)
+	}
+	r.b.WriteString(">")
+	for _, line := range lines[i+1 : end] {
+		r.b.WriteString(html.EscapeString(line) + "\n")
+	}
+	r.b.WriteString("</code></pre>\n")
+	return end + 1
+}
+
+func (r *renderer) indentedCode(lines []string, i int) int {
+	start := i
+	last := i
+	for i < len(lines) && (indent(lines[i]) >= 4 || strings.TrimSpace(lines[i]) == "") {
+		if strings.TrimSpace(lines[i]) != "" {
+			last = i
+		}
+		i++
+	}
+	r.b.WriteString("<pre><code>")
+	for _, line := range lines[start : last+1] {
+		if len(line) >= 4 {
+			line = line[4:]
+		} else {
+			line = ""
+		}
+		r.b.WriteString(html.EscapeString(line) + "\n")
+	}
+	r.b.WriteString("</code></pre>\n")
+	return last + 1
+}
+
+func isQuote(line string) bool {
+	return indent(line) < 4 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+func (r *renderer) quote(lines []string, i int) int {
+	var inner []string
+	for i < len(lines) && isQuote(lines[i]) {
+		t := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+		inner = append(inner, strings.TrimPrefix(t, " "))
+		i++
+	}
+	r.b.WriteString("<blockquote>\n")
+	r.blocks(inner, false)
+	r.b.WriteString("</blockquote>\n")
+	return i
+}
+
+// marker describes the marker of a list item
+type marker struct {
+	ordered bool
+	start   int
+	width   int // Columns before the item content
+}
+
+var orderedPattern = regexp.MustCompile(`^[0-9]{1,9}[.)]`)
+
+// listMarker parses the marker of a list item line
+func listMarker(line string) (marker, bool) {
+	n := indent(line)
+	if n >= 4 {
+		return marker{}, false
+	}
+	t := line[n:]
+	m := marker{}
+	switch {
+	case len(t) > 0 && (t[0] == '-' || t[0] == '*' || t[0] == '+'):
+		m.width = n + 1
+	case orderedPattern.MatchString(t):
+		digits := orderedPattern.FindString(t)
+		m.ordered = true
+		m.start, _ = strconv.Atoi(digits[:len(digits)-1])
+		m.width = n + len(digits)
+	default:
+		return marker{}, false
+	}
+
+	rest := line[m.width:]
+	if rest == "" {
+		return m, true
+	}
+	if rest[0] != ' ' {
+		return marker{}, false
+	}
+	spaces := indent(rest)
+	if spaces > 4 || spaces == len(rest) {
+		spaces = 1
+	}
+	m.width += spaces
+	return m, true
+}
+
+func (r *renderer) list(lines []string, i int) int {
+	first, _ := listMarker(lines[i])
+	var items [][]string
+	loose := false
+
+	for i < len(lines) {
+		m, ok := listMarker(lines[i])
+		if !ok || m.ordered != first.ordered || isRule(lines[i]) {
+			break
+		}
+		item := []string{contentAfter(lines[i], m.width)}
+		i++
+	itemLines:
+		for i < len(lines) {
+			line := lines[i]
+			switch {
+			case strings.TrimSpace(line) == "":
+				item = append(item, "")
+			case indent(line) >= m.width:
+				item = append(item, line[m.width:])
+			case item[len(item)-1] != "" && !startsBlock(line):
+				// Lazy continuation of the item's paragraph
+				item = append(item, strings.TrimLeft(line, " "))
+			default:
+				break itemLines
+			}
+			i++
+		}
+		trailing := 0
+		for len(item) > 1 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		for _, line := range item[1:] {
+			if line == "" {
+				loose = true
+			}
+		}
+		items = append(items, item)
+
+		// Blank lines either separate items of a loose list or end the list
+		if trailing > 0 {
+			if next, ok := listMarker(lineAt(lines, i)); !ok || next.ordered != first.ordered || isRule(lines[i]) {
+				break
+			}
+			loose = true
+		}
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	r.b.WriteString("<" + tag)
+	if first.ordered && first.start != 1 {
+		r.b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+	}
+	r.b.WriteString(">\n")
+	for _, item := range items {
+		child := &renderer{ids: r.ids}
+		child.blocks(item, !loose)
+		content := child.b.String()
+		switch {
+		case loose:
+			r.b.WriteString("<li>\n" + content + "</li>\n")
+		case isParagraphOnly(item):
+			r.b.WriteString("<li>" + strings.TrimSuffix(content, "\n") + "</li>\n")
+		default:
+			r.b.WriteString("<li>" + content + "</li>\n")
+		}
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// lineAt returns line i, or "" past the end
+func lineAt(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
+
+// contentAfter strips a list marker of the given width from a line
+func contentAfter(line string, width int) string {
+	if width >= len(line) {
+		return ""
+	}
+	return line[width:]
+}
+
+// isParagraphOnly reports whether item lines hold a single paragraph
+func isParagraphOnly(item []string) bool {
+	for i, line := range item {
+		if strings.TrimSpace(line) == "" || (i > 0 && startsBlock(line)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTableStart reports whether a table header and delimiter row start at i
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") {
+		return false
+	}
+	header := splitRow(lines[i])
+	aligns, ok := delimiterRow(lines[i+1])
+	return ok && len(aligns) == len(header)
+}
+
+var delimiterCell = regexp.MustCompile(`^:?-+:?$`)
+
+// delimiterRow parses a table delimiter row into column alignments
+func delimiterRow(line string) ([]string, bool) {
+	if !strings.Contains(line, "-") {
+		return nil, false
+	}
+	cells := splitRow(line)
+	aligns := make([]string, len(cells))
+	for i, cell := range cells {
+		if !delimiterCell.MatchString(cell) {
+			return nil, false
+		}
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns[i] = "center"
+		case left:
+			aligns[i] = "left"
+		case right:
+			aligns[i] = "right"
+		}
+	}
+	return aligns, true
+}
+
+// splitRow splits a table row on unescaped pipes
+func splitRow(line string) []string {
+	t := strings.TrimSpace(line)
+	t = strings.TrimPrefix(t, "|")
+	if strings.HasSuffix(t, "|") && !strings.HasSuffix(t, `\|`) {
+		t = t[:len(t)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(t); i++ {
+		if t[i] == '\\' && i+1 < len(t) && t[i+1] == '|' {
+			cell.WriteByte('|')
+			i++
+			continue
+		}
+		if t[i] == '|' {
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+			continue
+		}
+		cell.WriteByte(t[i])
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func (r *renderer) table(lines []string, i int) int {
+	header := splitRow(lines[i])
+	aligns, _ := delimiterRow(lines[i+1])
+	i += 2
+
+	r.b.WriteString("<table>\n<thead>\n")
+	r.row("th", header, aligns)
+	r.b.WriteString("</thead>\n")
+	if i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|") {
+		r.b.WriteString("<tbody>\n")
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|") {
+			r.row("td", splitRow(lines[i]), aligns)
+			i++
+		}
+		r.b.WriteString("</tbody>\n")
+	}
+	r.b.WriteString("</table>\n")
+	return i
+}
+
+// row writes a table row, padding or truncating it to the header's columns
+func (r *renderer) row(tag string, cells, aligns []string) {
+	r.b.WriteString("<tr>\n")
+	for i, align := range aligns {
+		r.b.WriteString("<" + tag)
+		if align != "" {
+			r.b.WriteString(` align="` + align + `"`)
+		}
+		r.b.WriteString(">")
+		if i < len(cells) {
+			r.b.WriteString(inline(cells[i]))
+		}
+		r.b.WriteString("</" + tag + ">\n")
+	}
+	r.b.WriteString("</tr>\n")
+}
@@ -0,0 +1,76 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"heading", "# Getting *started* #", "<h1 id=\"getting-started\">Getting <em>started</em></h1>\n"},
+		{"duplicate heading ids", "## Usage\n## Usage", "<h2 id=\"usage\">Usage</h2>\n<h2 id=\"usage-1\">Usage</h2>\n"},
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"hard break", "one  \ntwo\\\nthree", "<p>one<br>\ntwo<br>\nthree</p>\n"},
+		{"emphasis", "*a* **b** ***c*** _d_ ~~e~~ snake_case_name", "<p><em>a</em> <strong>b</strong> <strong><em>c</em></strong> <em>d</em> <del>e</del> snake_case_name</p>\n"},
+		{"nested emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"unmatched delimiters", "2 * 3 * 4 and **open", "<p>2 * 3 * 4 and **open</p>\n"},
+		{"code span", "use `a < b` or ``x ` y``", "<p>use <code>a &lt; b</code> or <code>x ` y</code></p>\n"},
+		{"escapes and entities", `\*not em\* &copy; &bogus; AT&T`, "<p>*not em* &copy; &amp;bogus; AT&amp;T</p>\n"},
+		{"link", `[the *docs*](/docs/intro.md "Intro")`, "<p><a href=\"/docs/intro.md\" title=\"Intro\">the <em>docs</em></a></p>\n"},
+		{"image", "![a *logo*](https://example.com/logo.png)", "<p><img src=\"https://example.com/logo.png\" alt=\"a logo\"></p>\n"},
+		{"autolinks", "<https://example.com/?a=1&b=2> <me@example.com>", "<p><a href=\"https://example.com/?a=1&amp;b=2\">https://example.com/?a=1&amp;b=2</a> <a href=\"mailto:me@example.com\">me@example.com</a></p>\n"},
+		{"fenced code", "```go\nif a < b {\n}\n```", "<pre><code class=\"language-go\">if a &lt; b {\n}\n</code></pre>\n"},
+		{"unclosed fence", "~~~\ncode", "<pre><code>code\n</code></pre>\n"},
+		{"indented code", "    x := 1\n\n    y := 2\n\ntext", "<pre><code>x := 1\n\ny := 2\n</code></pre>\n<p>text</p>\n"},
+		{"rule", "a\n\n---\n\n* * *", "<p>a</p>\n<hr>\n<hr>\n"},
+		{"blockquote", "> quoted\n> # title", "<blockquote>\n<p>quoted</p>\n<h1 id=\"title\">title</h1>\n</blockquote>\n"},
+		{"tight list", "- one\n- two\n  continued\n- three", "<ul>\n<li>one</li>\n<li>two\ncontinued</li>\n<li>three</li>\n</ul>\n"},
+		{"loose list", "1. one\n\n2. two", "<ol>\n<li>\n<p>one</p>\n</li>\n<li>\n<p>two</p>\n</li>\n</ol>\n"},
+		{"ordered start", "3) three\n4) four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n"},
+		{"nested list", "- a\n  - b\n  - c\n- d", "<ul>\n<li>a\n<ul>\n<li>b</li>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n"},
+		{"list ends at paragraph", "- a\n\nafter", "<ul>\n<li>a</li>\n</ul>\n<p>after</p>\n"},
+		{"table", "| Name | Qty |\n|:-----|----:|\n| a\\|b | 1 |\n| c |", "<table>\n<thead>\n<tr>\n<th align=\"left\">Name</th>\n<th align=\"right\">Qty</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td align=\"left\">a|b</td>\n<td align=\"right\">1</td>\n</tr>\n<tr>\n<td align=\"left\">c</td>\n<td align=\"right\"></td>\n</tr>\n</tbody>\n</table>\n"},
+	}
+
+	for _, tt := range tests {
+		if got := ToHTML(tt.src); got != tt.want {
+			t.Errorf("%s: ToHTML(%q)\n got %q\nwant %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestToHTML_Sanitizes(t *testing.T) {
+	src := strings.Join([]string{
+		`<script>alert(1)</script>`,
+		`<img src=x onerror="alert(1)">`,
+		`[click](javascript:alert(1)) [upper](JavaScript:alert(1)) [data](data:text/html;base64,PHNjcmlwdD4=)`,
+		`![pic](javascript:alert(1)) ![mail](mailto:a@example.com)`,
+		`<javascript:alert(1)>`,
+		`[quote](/x" onmouseover="alert(1))`,
+		"```\"><script>\n</script>\n```",
+	}, "\n\n")
+
+	out := ToHTML(src)
+	for _, bad := range []string{"<script", "<img src=x", `="javascript:`, `="JavaScript:`, `="data:`, `src="mailto:`, `" onmouseover`, "<a href"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("Output contains %q:\n%s", bad, out)
+		}
+	}
+	if !strings.Contains(out, "<p>click upper data</p>") {
+		t.Errorf("Expected unsafe links to keep their text:\n%s", out)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	src := "```\n# not a heading\n```\n## Sub\n# The *Real* Title\n# Second"
+	if got := Title(src); got != "The Real Title" {
+		t.Errorf("Title = %q, want %q", got, "The Real Title")
+	}
+	if got := Title("no heading"); got != "" {
+		t.Errorf("Title = %q, want empty", got)
+	}
+}
@@ -0,0 +1,423 @@
+// Package proxy forwards requests from a cenv to configured upstream
+// services, such as another WCE instance or a third-party API. Credentials
+// are injected server-side from the cenv's secrets so apps never hold them.
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/egress"
+	"github.com/thetanil/wce/internal/secrets"
+)
+
+// Route limits
+const (
+	DefaultTimeoutMS        = 10000
+	MaxTimeoutMS            = 60000
+	DefaultMaxResponseBytes = 5 << 20
+	MaxResponseBytesLimit   = 50 << 20
+	MaxCacheTTLSeconds      = 86400
+)
+
+// validName matches route names as they appear in the URL
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// validHeader matches header names credentials may be injected into
+var validHeader = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// forwardedRequestHeaders are copied from the client request to the upstream.
+// Client credentials (Authorization, Cookie) are never forwarded.
+var forwardedRequestHeaders = []string{"Accept", "Accept-Language", "Content-Type", "If-None-Match", "If-Modified-Since"}
+
+// forwardedResponseHeaders are copied from the upstream response to the client
+var forwardedResponseHeaders = []string{"Content-Type", "Content-Language", "Cache-Control", "ETag", "Last-Modified", "Location"}
+
+// ErrTimeout is returned when the upstream does not answer within the route timeout
+var ErrTimeout = errors.New("upstream timed out")
+
+// ErrBlockedUpstream is returned when the upstream resolves to an internal
+// address the server does not allow routes to reach
+var ErrBlockedUpstream = errors.New("upstream address is not allowed")
+
+// NewClient creates the client Forward sends upstream requests with. It
+// dials through guard, so routes cannot reach internal addresses, and does
+// not follow redirects: injected credentials must not be sent to hosts
+// other than the configured upstream.
+func NewClient(guard *egress.Guard) *http.Client {
+	return &http.Client{
+		Transport: guard.Transport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Route is an upstream proxy route
+type Route struct {
+	Name             string `json:"name"`
+	UpstreamURL      string `json:"upstream_url"`
+	AuthHeader       string `json:"auth_header,omitempty"`
+	AuthPrefix       string `json:"auth_prefix,omitempty"`
+	SecretName       string `json:"secret_name,omitempty"`
+	TimeoutMS        int64  `json:"timeout_ms"`
+	MaxResponseBytes int64  `json:"max_response_bytes"`
+	CacheTTLSeconds  int64  `json:"cache_ttl_seconds"`
+	Enabled          bool   `json:"enabled"`
+	ModifiedAt       int64  `json:"modified_at"`
+	ModifiedBy       string `json:"modified_by,omitempty"`
+}
+
+// Response is an upstream response, possibly served from cache
+type Response struct {
+	Status int
+	Header map[string]string
+	Body   []byte
+	Cached bool
+}
+
+// IsValidName checks if a route name is allowed
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Validate checks a route and fills in default limits
+func (r *Route) Validate() error {
+	if !IsValidName(r.Name) {
+		return fmt.Errorf("invalid route name: %s", r.Name)
+	}
+
+	u, err := url.Parse(r.UpstreamURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("upstream_url must be an absolute http or https URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("upstream_url cannot have a query or fragment")
+	}
+
+	if (r.AuthHeader == "") != (r.SecretName == "") {
+		return fmt.Errorf("auth_header and secret_name must be set together")
+	}
+	if r.AuthHeader != "" && (!validHeader.MatchString(r.AuthHeader) || strings.EqualFold(r.AuthHeader, "Host")) {
+		return fmt.Errorf("invalid auth_header: %s", r.AuthHeader)
+	}
+
+	if r.TimeoutMS == 0 {
+		r.TimeoutMS = DefaultTimeoutMS
+	}
+	if r.TimeoutMS < 0 || r.TimeoutMS > MaxTimeoutMS {
+		return fmt.Errorf("timeout_ms must be between 1 and %d", MaxTimeoutMS)
+	}
+	if r.MaxResponseBytes == 0 {
+		r.MaxResponseBytes = DefaultMaxResponseBytes
+	}
+	if r.MaxResponseBytes < 0 || r.MaxResponseBytes > MaxResponseBytesLimit {
+		return fmt.Errorf("max_response_bytes must be between 1 and %d", MaxResponseBytesLimit)
+	}
+	if r.CacheTTLSeconds < 0 || r.CacheTTLSeconds > MaxCacheTTLSeconds {
+		return fmt.Errorf("cache_ttl_seconds must be between 0 and %d", MaxCacheTTLSeconds)
+	}
+
+	return nil
+}
+
+// PutRoute creates or replaces a route. Cached responses for the route are dropped.
+func PutRoute(db *sql.DB, route *Route, userID string) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+	if route.SecretName != "" {
+		exists, err := secrets.Exists(db, route.SecretName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("secret not found: %s", route.SecretName)
+		}
+	}
+
+	var modifiedBy interface{}
+	if userID != "" {
+		modifiedBy = userID
+	}
+	route.ModifiedAt = time.Now().Unix()
+	route.ModifiedBy = userID
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO _wce_proxy_routes (name, upstream_url, auth_header, auth_prefix, secret_name,
+			timeout_ms, max_response_bytes, cache_ttl_seconds, enabled, modified_at, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			upstream_url = excluded.upstream_url,
+			auth_header = excluded.auth_header,
+			auth_prefix = excluded.auth_prefix,
+			secret_name = excluded.secret_name,
+			timeout_ms = excluded.timeout_ms,
+			max_response_bytes = excluded.max_response_bytes,
+			cache_ttl_seconds = excluded.cache_ttl_seconds,
+			enabled = excluded.enabled,
+			modified_at = excluded.modified_at,
+			modified_by = excluded.modified_by
+	`, route.Name, route.UpstreamURL, nullIfEmpty(route.AuthHeader), route.AuthPrefix, nullIfEmpty(route.SecretName),
+		route.TimeoutMS, route.MaxResponseBytes, route.CacheTTLSeconds, route.Enabled, route.ModifiedAt, modifiedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save proxy route: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM _wce_proxy_cache WHERE route_name = ?`, route.Name); err != nil {
+		return fmt.Errorf("failed to clear proxy cache: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetRoute returns a route by name
+func GetRoute(db *sql.DB, name string) (*Route, error) {
+	row := db.QueryRow(`
+		SELECT name, upstream_url, auth_header, auth_prefix, secret_name, timeout_ms,
+			max_response_bytes, cache_ttl_seconds, enabled, modified_at, modified_by
+		FROM _wce_proxy_routes WHERE name = ?
+	`, name)
+
+	route, err := scanRoute(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("proxy route not found: %s", name)
+	}
+	return route, err
+}
+
+// ListRoutes returns all routes ordered by name
+func ListRoutes(db *sql.DB) ([]Route, error) {
+	rows, err := db.Query(`
+		SELECT name, upstream_url, auth_header, auth_prefix, secret_name, timeout_ms,
+			max_response_bytes, cache_ttl_seconds, enabled, modified_at, modified_by
+		FROM _wce_proxy_routes ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list proxy routes: %w", err)
+	}
+	defer rows.Close()
+
+	routes := []Route{}
+	for rows.Next() {
+		route, err := scanRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, *route)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating proxy routes: %w", err)
+	}
+
+	return routes, nil
+}
+
+// DeleteRoute removes a route and its cached responses
+func DeleteRoute(db *sql.DB, name string) error {
+	result, err := db.Exec(`DELETE FROM _wce_proxy_routes WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete proxy route: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("proxy route not found: %s", name)
+	}
+
+	return nil
+}
+
+// scanRoute scans a route row selected in the standard column order
+func scanRoute(scanner interface{ Scan(...interface{}) error }) (*Route, error) {
+	var route Route
+	var authHeader, secretName, modifiedBy sql.NullString
+
+	err := scanner.Scan(&route.Name, &route.UpstreamURL, &authHeader, &route.AuthPrefix, &secretName,
+		&route.TimeoutMS, &route.MaxResponseBytes, &route.CacheTTLSeconds, &route.Enabled,
+		&route.ModifiedAt, &modifiedBy)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan proxy route: %w", err)
+	}
+
+	route.AuthHeader = authHeader.String
+	route.SecretName = secretName.String
+	route.ModifiedBy = modifiedBy.String
+
+	return &route, nil
+}
+
+// Forward sends a request to the route's upstream. path is appended to the
+// upstream URL and rawQuery is passed through. Successful GET responses are
+// cached when the route has a cache TTL. client should come from NewClient.
+func Forward(ctx context.Context, client *http.Client, db *sql.DB, vault *secrets.Manager, route *Route, method, path, rawQuery string, header http.Header, body io.Reader) (*Response, error) {
+	target, cacheKey, err := buildTarget(route.UpstreamURL, path, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheable := method == http.MethodGet && route.CacheTTLSeconds > 0
+	if cacheable {
+		if cached, err := cacheGet(db, route.Name, cacheKey); err == nil && cached != nil {
+			return cached, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(route.TimeoutMS)*time.Millisecond)
+	defer cancel()
+
+	// Request bodies are held to the same limit as responses
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = io.LimitReader(body, route.MaxResponseBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream request: %w", err)
+	}
+	for _, name := range forwardedRequestHeaders {
+		if value := header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	if route.SecretName != "" {
+		if vault == nil {
+			return nil, fmt.Errorf("secret storage is not configured")
+		}
+		secret, err := vault.Get(db, route.SecretName)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(route.AuthHeader, route.AuthPrefix+secret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		if errors.Is(err, egress.ErrBlocked) {
+			return nil, ErrBlockedUpstream
+		}
+		return nil, fmt.Errorf("upstream request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read one byte past the limit to detect oversized responses
+	data, err := io.ReadAll(io.LimitReader(resp.Body, route.MaxResponseBytes+1))
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, fmt.Errorf("failed to read upstream response: %w", err)
+	}
+	if int64(len(data)) > route.MaxResponseBytes {
+		return nil, fmt.Errorf("upstream response exceeds %d bytes", route.MaxResponseBytes)
+	}
+
+	result := &Response{Status: resp.StatusCode, Header: map[string]string{}, Body: data}
+	for _, name := range forwardedResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			result.Header[name] = value
+		}
+	}
+
+	if cacheable && resp.StatusCode == http.StatusOK {
+		cacheStore(db, route, cacheKey, result)
+	}
+
+	return result, nil
+}
+
+// buildTarget joins the upstream URL and a request path. The cleaned path
+// must stay under the upstream base path.
+func buildTarget(upstream, path, rawQuery string) (string, string, error) {
+	base, err := url.Parse(upstream)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid upstream url: %w", err)
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return "", "", fmt.Errorf("invalid proxy path: %s", path)
+		}
+	}
+
+	target := *base
+	target.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	target.RawQuery = rawQuery
+
+	cacheKey := target.Path
+	if rawQuery != "" {
+		cacheKey += "?" + rawQuery
+	}
+
+	return target.String(), cacheKey, nil
+}
+
+// cacheGet returns an unexpired cached response, or nil
+func cacheGet(db *sql.DB, routeName, key string) (*Response, error) {
+	var status int
+	var headers string
+	var body []byte
+	err := db.QueryRow(`
+		SELECT status, headers, body FROM _wce_proxy_cache
+		WHERE route_name = ? AND cache_key = ? AND expires_at > ?
+	`, routeName, key, time.Now().Unix()).Scan(&status, &headers, &body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{Status: status, Body: body, Cached: true}
+	if err := json.Unmarshal([]byte(headers), &resp.Header); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// cacheStore saves a response; failures only cost a cache miss
+func cacheStore(db *sql.DB, route *Route, key string, resp *Response) {
+	headers, err := json.Marshal(resp.Header)
+	if err != nil {
+		return
+	}
+	db.Exec(`
+		INSERT OR REPLACE INTO _wce_proxy_cache (route_name, cache_key, status, headers, body, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, route.Name, key, resp.Status, string(headers), resp.Body, time.Now().Unix()+route.CacheTTLSeconds)
+}
+
+// nullIfEmpty maps an empty string to NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/egress"
+	"github.com/thetanil/wce/internal/secrets"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	return conn
+}
+
+func setupTestVault(t *testing.T) *secrets.Manager {
+	keys, err := secrets.LoadOrCreateLocalKeys(filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatalf("Failed to create master key: %v", err)
+	}
+	return secrets.NewManager(keys)
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Route{
+		{Name: "Bad Name", UpstreamURL: "https://example.com"},
+		{Name: "api", UpstreamURL: "ftp://example.com"},
+		{Name: "api", UpstreamURL: "/relative"},
+		{Name: "api", UpstreamURL: "https://example.com?key=1"},
+		{Name: "api", UpstreamURL: "https://example.com", AuthHeader: "X-Key"},
+		{Name: "api", UpstreamURL: "https://example.com", AuthHeader: "Host", SecretName: "key"},
+		{Name: "api", UpstreamURL: "https://example.com", TimeoutMS: MaxTimeoutMS + 1},
+		{Name: "api", UpstreamURL: "https://example.com", CacheTTLSeconds: -1},
+	}
+	for _, route := range invalid {
+		if err := route.Validate(); err == nil {
+			t.Errorf("Expected route %+v to be invalid", route)
+		}
+	}
+
+	route := Route{Name: "api", UpstreamURL: "https://example.com/v1"}
+	if err := route.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if route.TimeoutMS != DefaultTimeoutMS || route.MaxResponseBytes != DefaultMaxResponseBytes {
+		t.Errorf("Expected default limits, got %+v", route)
+	}
+}
+
+func TestForward(t *testing.T) {
+	conn := setupTestDB(t)
+	vault := setupTestVault(t)
+	ctx := context.Background()
+
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/v1/items":
+			if r.Header.Get("X-Api-Key") != "Key s3cret" || r.Header.Get("Cookie") != "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "upstream=1")
+			w.Write([]byte(`{"q":"` + r.URL.Query().Get("q") + `"}`))
+		case "/v1/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/v1/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/v1/redirect":
+			http.Redirect(w, r, "https://elsewhere.example/", http.StatusFound)
+		}
+	}))
+	defer upstream.Close()
+
+	if err := vault.Set(conn, "api_key", "s3cret", ""); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	route := &Route{
+		Name:            "items",
+		UpstreamURL:     upstream.URL + "/v1",
+		AuthHeader:      "X-Api-Key",
+		AuthPrefix:      "Key ",
+		SecretName:      "api_key",
+		CacheTTLSeconds: 60,
+		Enabled:         true,
+	}
+	if err := PutRoute(conn, route, ""); err != nil {
+		t.Fatalf("PutRoute failed: %v", err)
+	}
+
+	header := http.Header{"Cookie": {"wce=session"}, "Authorization": {"Bearer user-token"}}
+
+	// The test upstream is on loopback, which routes may not reach by default
+	if _, err := Forward(ctx, NewClient(egress.NewGuard()), conn, vault, route, "GET", "items", "", header, nil); !errors.Is(err, ErrBlockedUpstream) {
+		t.Fatalf("Expected a loopback upstream refused, got %v", err)
+	}
+	client := NewClient(egress.NewGuard(netip.MustParsePrefix("127.0.0.0/8")))
+
+	resp, err := Forward(ctx, client, conn, vault, route, "GET", "items", "q=a", header, nil)
+	if err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if resp.Status != http.StatusOK || string(resp.Body) != `{"q":"a"}` || resp.Cached {
+		t.Errorf("Unexpected response: %d %s cached=%t", resp.Status, resp.Body, resp.Cached)
+	}
+	if resp.Header["Content-Type"] != "application/json" || resp.Header["Set-Cookie"] != "" {
+		t.Errorf("Unexpected headers: %v", resp.Header)
+	}
+
+	resp, err = Forward(ctx, client, conn, vault, route, "GET", "items", "q=a", header, nil)
+	if err != nil || !resp.Cached || hits.Load() != 1 {
+		t.Errorf("Expected cached response, got cached=%v hits=%d (%v)", resp != nil && resp.Cached, hits.Load(), err)
+	}
+
+	// Updating the route drops its cache
+	if err := PutRoute(conn, route, ""); err != nil {
+		t.Fatalf("PutRoute failed: %v", err)
+	}
+	if resp, _ := Forward(ctx, client, conn, vault, route, "GET", "items", "q=a", header, nil); resp == nil || resp.Cached {
+		t.Error("Expected cache to be cleared after update")
+	}
+
+	route.MaxResponseBytes = 10
+	if _, err := Forward(ctx, client, conn, vault, route, "GET", "big", "", header, nil); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Expected size limit error, got %v", err)
+	}
+
+	route.TimeoutMS = 50
+	if _, err := Forward(ctx, client, conn, vault, route, "GET", "slow", "", header, nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+
+	route.TimeoutMS = DefaultTimeoutMS
+	route.MaxResponseBytes = DefaultMaxResponseBytes
+	resp, err = Forward(ctx, client, conn, vault, route, "GET", "redirect", "", header, nil)
+	if err != nil || resp.Status != http.StatusFound || resp.Header["Location"] != "https://elsewhere.example/" {
+		t.Errorf("Expected redirect to be passed through, got %+v (%v)", resp, err)
+	}
+
+	if _, err := Forward(ctx, client, conn, vault, route, "GET", "../admin", "", header, nil); err == nil {
+		t.Error("Expected path traversal to be rejected")
+	}
+}
+
+func TestRouteSecretReference(t *testing.T) {
+	conn := setupTestDB(t)
+
+	route := &Route{Name: "api", UpstreamURL: "https://example.com", AuthHeader: "Authorization", SecretName: "missing"}
+	if err := PutRoute(conn, route, ""); err == nil {
+		t.Error("Expected error referencing a missing secret")
+	}
+
+	setupTestVault(t).Set(conn, "token", "abc", "")
+	route.SecretName = "token"
+	if err := PutRoute(conn, route, ""); err != nil {
+		t.Fatalf("PutRoute failed: %v", err)
+	}
+
+	if err := secrets.Delete(conn, "token"); err == nil || !strings.Contains(err.Error(), "proxy route") {
+		t.Errorf("Expected secret in use to be kept, got %v", err)
+	}
+
+	if err := DeleteRoute(conn, "api"); err != nil {
+		t.Fatalf("DeleteRoute failed: %v", err)
+	}
+	if err := secrets.Delete(conn, "token"); err != nil {
+		t.Errorf("Expected secret to be deleted, got %v", err)
+	}
+}
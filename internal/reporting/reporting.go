@@ -0,0 +1,132 @@
+// Package reporting forwards server errors, such as handler panics, to
+// external error trackers. A Sink receives each report; SentrySink posts it
+// to Sentry or any service speaking Sentry's store API, such as GlitchTip.
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Report describes a recovered panic and the request it happened in
+type Report struct {
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Route     string    `json:"route"` // Pattern of the route that panicked, "" if none matched
+	CenvID    string    `json:"cenv_id,omitempty"`
+	Message   string    `json:"message"` // The panic value
+	Stack     string    `json:"stack"`
+	Time      time.Time `json:"time"`
+}
+
+// Sink delivers reports to an error tracker
+type Sink interface {
+	Send(ctx context.Context, report *Report) error
+}
+
+// SentrySink sends reports to a Sentry-compatible store endpoint
+type SentrySink struct {
+	Client      *http.Client
+	Environment string // Optional, e.g. "production"
+
+	endpoint  string
+	publicKey string
+}
+
+// NewSentrySink creates a sink for a Sentry DSN of the form
+// https://<key>@<host>/<project-id>, sending with a 10 second timeout
+func NewSentrySink(dsn string) (*SentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: expected scheme://key@host/project")
+	}
+	path, project, ok := cutLast(strings.TrimSuffix(u.Path, "/"), "/")
+	if !ok || project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing project id")
+	}
+
+	return &SentrySink{
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		endpoint:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path, project),
+		publicKey: u.User.Username(),
+	}, nil
+}
+
+// sentryEvent is the subset of Sentry's event payload reports fill in
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags"`
+	Request     map[string]string `json:"request"`
+	Extra       map[string]string `json:"extra"`
+}
+
+// Send posts a report as an error event
+func (s *SentrySink) Send(ctx context.Context, report *Report) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	tags := map[string]string{"request_id": report.RequestID}
+	if report.CenvID != "" {
+		tags["cenv_id"] = report.CenvID
+	}
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   report.Time.UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "wce",
+		Environment: s.Environment,
+		Transaction: report.Route,
+		Message:     "panic: " + report.Message,
+		Tags:        tags,
+		Request:     map[string]string{"method": report.Method, "url": report.URL},
+		Extra:       map[string]string{"stack": report.Stack},
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf(
+		"Sentry sentry_version=7, sentry_client=wce/1.0, sentry_timestamp=%d, sentry_key=%s",
+		report.Time.Unix(), s.publicKey))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error tracker returned %s", resp.Status)
+	}
+	return nil
+}
+
+// cutLast splits s around the last sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
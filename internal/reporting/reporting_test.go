@@ -0,0 +1,75 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSentrySink(t *testing.T) {
+	for _, dsn := range []string{"", "not a url", "https://sentry.example.com/42", "https://key@sentry.example.com/"} {
+		if _, err := NewSentrySink(dsn); err == nil {
+			t.Errorf("Expected DSN %q to be rejected", dsn)
+		}
+	}
+
+	sink, err := NewSentrySink("https://abc123@sentry.example.com/prefix/42")
+	if err != nil {
+		t.Fatalf("NewSentrySink failed: %v", err)
+	}
+	if sink.endpoint != "https://sentry.example.com/prefix/api/42/store/" || sink.publicKey != "abc123" {
+		t.Errorf("Unexpected sink: %s %s", sink.endpoint, sink.publicKey)
+	}
+}
+
+func TestSentrySinkSend(t *testing.T) {
+	var path, auth string
+	var event map[string]interface{}
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("X-Sentry-Auth")
+		json.NewDecoder(r.Body).Decode(&event)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer tracker.Close()
+
+	sink, err := NewSentrySink(strings.Replace(tracker.URL, "http://", "http://key1@", 1) + "/7")
+	if err != nil {
+		t.Fatalf("NewSentrySink failed: %v", err)
+	}
+	err = sink.Send(context.Background(), &Report{
+		RequestID: "req-1",
+		Method:    "GET",
+		URL:       "/abc/documents/x",
+		Route:     "GET /{cenvID}/documents/{docID...}",
+		CenvID:    "abc",
+		Message:   "nil map",
+		Stack:     "goroutine 1 [running]:",
+		Time:      time.Unix(1700000000, 0),
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if path != "/api/7/store/" || !strings.Contains(auth, "sentry_key=key1") {
+		t.Errorf("Unexpected request: %s %s", path, auth)
+	}
+	tags, _ := event["tags"].(map[string]interface{})
+	if event["message"] != "panic: nil map" || event["transaction"] != "GET /{cenvID}/documents/{docID...}" ||
+		tags["request_id"] != "req-1" || tags["cenv_id"] != "abc" || len(event["event_id"].(string)) != 32 {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer failing.Close()
+	sink, _ = NewSentrySink(strings.Replace(failing.URL, "http://", "http://key1@", 1) + "/7")
+	if err := sink.Send(context.Background(), &Report{Time: time.Now()}); err == nil {
+		t.Error("Expected a rejected event to be an error")
+	}
+}
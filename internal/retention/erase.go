@@ -0,0 +1,201 @@
+package retention
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+)
+
+// ErrUserNotFound is returned when erasing an unknown user
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrEraseOwner is returned when erasing the cenv owner, who must hand over
+// ownership first
+var ErrEraseOwner = errors.New("the owner cannot be erased; transfer ownership first")
+
+// ErasureReport records what erasing a user did. The user's id remains as
+// a pseudonym, so records that must be kept still refer to one account
+// without saying who it was.
+type ErasureReport struct {
+	ID         int64            `json:"id"`
+	UserID     string           `json:"user_id"`
+	Pseudonym  string           `json:"pseudonym"` // The username the account now has
+	ErasedBy   string           `json:"erased_by"`
+	ErasedAt   int64            `json:"erased_at"`
+	Deleted    map[string]int64 `json:"deleted"`    // Rows deleted, per table
+	Anonymized map[string]int64 `json:"anonymized"` // Rows stripped of identifying fields, per table
+	Retained   map[string]int64 `json:"retained"`   // Rows that cannot be changed, per table
+
+	// Documents the user created or edited. Their content is not changed;
+	// review it for personal data and delete or edit what must go.
+	FlaggedDocuments []string `json:"flagged_documents"`
+}
+
+// erasedTables are the rows deleted with a user, by the column naming them
+var erasedTables = []struct{ table, column string }{
+	{"_wce_sessions", "user_id"},
+	{"_wce_device_codes", "approved_by"},
+	{"_wce_password_resets", "user_id"},
+	{"_wce_email_verifications", "user_id"},
+	{"_wce_request_nonces", "user_id"},
+	{"_wce_table_permissions", "user_id"},
+	{"_wce_row_policies", "user_id"},
+	{"_wce_feature_flag_overrides", "user_id"},
+	{"_wce_request_captures", "user_id"},
+}
+
+// EraseUser removes a user's personal data: their sessions, tokens,
+// grants, login history and captured requests are deleted; the account
+// is disabled and its username, email and password replaced; and audit
+// entries lose the network details they recorded. Documents the user wrote
+// are flagged in the report, not changed. The compliance log is
+// append-only, so its records are counted as retained. The report is
+// stored and returned.
+func EraseUser(db *sql.DB, userID, erasedBy string, now time.Time) (*ErasureReport, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var username, role string
+	err = tx.QueryRow(`SELECT username, role FROM _wce_users WHERE user_id = ?`, userID).Scan(&username, &role)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if role == auth.RoleOwner {
+		return nil, ErrEraseOwner
+	}
+
+	report := &ErasureReport{
+		UserID:           userID,
+		Pseudonym:        "erased-" + userID,
+		ErasedBy:         erasedBy,
+		ErasedAt:         now.Unix(),
+		Deleted:          map[string]int64{},
+		Anonymized:       map[string]int64{},
+		Retained:         map[string]int64{},
+		FlaggedDocuments: []string{},
+	}
+	count := func(counts map[string]int64, table, query string, args ...interface{}) error {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to erase from %s: %w", table, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			counts[table] = n
+		}
+		return nil
+	}
+
+	for _, t := range erasedTables {
+		if err := count(report.Deleted, t.table, "DELETE FROM "+t.table+" WHERE "+t.column+" = ?", userID); err != nil {
+			return nil, err
+		}
+	}
+	// Failed attempts are recorded by the username typed, without a user id
+	if err := count(report.Deleted, "_wce_login_attempts",
+		`DELETE FROM _wce_login_attempts WHERE user_id = ? OR username = ?`, userID, username); err != nil {
+		return nil, err
+	}
+
+	if err := count(report.Anonymized, "_wce_users", `
+		UPDATE _wce_users
+		SET username = ?, email = NULL, password_hash = '!', enabled = 0, last_login = NULL
+		WHERE user_id = ?
+	`, report.Pseudonym, userID); err != nil {
+		return nil, err
+	}
+	if err := count(report.Anonymized, "_wce_audit_log", `
+		UPDATE _wce_audit_log SET username = ?, ip_address = NULL, user_agent = NULL
+		WHERE user_id = ?
+	`, report.Pseudonym, userID); err != nil {
+		return nil, err
+	}
+
+	var retained int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM _wce_compliance_log WHERE user_id = ?`, userID).Scan(&retained); err != nil {
+		return nil, fmt.Errorf("failed to count compliance records: %w", err)
+	}
+	if retained > 0 {
+		report.Retained["_wce_compliance_log"] = retained
+	}
+
+	rows, err := tx.Query(`
+		SELECT id FROM _wce_documents WHERE created_by = ?1 OR modified_by = ?1
+		UNION
+		SELECT document_id FROM _wce_document_versions WHERE modified_by = ?1
+		ORDER BY 1
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find documents: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		report.FlaggedDocuments = append(report.FlaggedDocuments, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating documents: %w", err)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	result, err := tx.Exec(`
+		INSERT INTO _wce_erasures (user_id, erased_by, erased_at, report) VALUES (?, ?, ?, ?)
+	`, userID, erasedBy, report.ErasedAt, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record erasure: %w", err)
+	}
+	if report.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit erasure: %w", err)
+	}
+	return report, nil
+}
+
+// ListErasures returns the stored erasure reports, newest first
+func ListErasures(db *sql.DB) ([]ErasureReport, error) {
+	rows, err := db.Query(`SELECT id, report FROM _wce_erasures ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query erasures: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []ErasureReport{}
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan erasure: %w", err)
+		}
+		var report ErasureReport
+		if err := json.Unmarshal([]byte(data), &report); err != nil {
+			return nil, fmt.Errorf("failed to decode erasure %d: %w", id, err)
+		}
+		report.ID = id
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating erasures: %w", err)
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,245 @@
+package retention
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UserData is everything the system tables hold about a user, for a data
+// portability export. The content of documents they created is exported
+// alongside it.
+type UserData struct {
+	ExportedAt        int64             `json:"exported_at"`
+	Profile           UserProfile       `json:"profile"`
+	Sessions          []SessionRecord   `json:"sessions"`
+	Permissions       []PermissionGrant `json:"permissions"`
+	FeatureFlags      map[string]bool   `json:"feature_flags"` // Overrides set for the user
+	LoginAttempts     []LoginRecord     `json:"login_attempts"`
+	AuditLog          []AuditRecord     `json:"audit_log"`
+	EndpointChanges   []EndpointComment `json:"endpoint_changes"`
+	AuthoredDocuments []string          `json:"authored_documents"` // Created by the user; exported in full
+	EditedDocuments   []string          `json:"edited_documents"`   // Created by others, edited by the user
+}
+
+// UserProfile is the user's account
+type UserProfile struct {
+	UserID        string `json:"user_id"`
+	Username      string `json:"username"`
+	Role          string `json:"role"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	CreatedAt     int64  `json:"created_at"`
+	InvitedBy     string `json:"invited_by,omitempty"` // Username of the inviter
+	LastLogin     int64  `json:"last_login,omitempty"`
+	Enabled       bool   `json:"enabled"`
+}
+
+// SessionRecord is a session of the user, without its token hashes
+type SessionRecord struct {
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	LastUsed  int64  `json:"last_used,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// PermissionGrant is a table permission granted to the user
+type PermissionGrant struct {
+	Table     string `json:"table"`
+	CanRead   bool   `json:"can_read"`
+	CanWrite  bool   `json:"can_write"`
+	CanDelete bool   `json:"can_delete"`
+	CanGrant  bool   `json:"can_grant"`
+}
+
+// LoginRecord is a login attempt for the user's account
+type LoginRecord struct {
+	Timestamp int64  `json:"timestamp"`
+	Succeeded bool   `json:"succeeded"`
+	IPAddress string `json:"ip_address,omitempty"`
+	Country   string `json:"country,omitempty"`
+}
+
+// AuditRecord is an audit log entry of an action the user took. Request
+// bodies recorded with signed admin requests are left out: they describe
+// the resources changed, and can hold other users' credentials.
+type AuditRecord struct {
+	Timestamp    int64  `json:"timestamp"`
+	Action       string `json:"action"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+	IPAddress    string `json:"ip_address,omitempty"`
+	UserAgent    string `json:"user_agent,omitempty"`
+}
+
+// EndpointComment is an endpoint change the user submitted, with its
+// description, or reviewed, with their review comment
+type EndpointComment struct {
+	ID          int64  `json:"id"`
+	Role        string `json:"role"` // 'submitter' or 'reviewer'
+	Path        string `json:"path"`
+	Method      string `json:"method"`
+	Status      string `json:"status"`
+	Description string `json:"description,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	At          int64  `json:"at"` // When submitted or reviewed
+}
+
+// ExportUser collects a user's data from the system tables
+func ExportUser(db *sql.DB, userID string, now time.Time) (*UserData, error) {
+	data := &UserData{
+		ExportedAt:        now.Unix(),
+		Sessions:          []SessionRecord{},
+		Permissions:       []PermissionGrant{},
+		FeatureFlags:      map[string]bool{},
+		LoginAttempts:     []LoginRecord{},
+		AuditLog:          []AuditRecord{},
+		EndpointChanges:   []EndpointComment{},
+		AuthoredDocuments: []string{},
+		EditedDocuments:   []string{},
+	}
+
+	p := &data.Profile
+	var email, invitedBy sql.NullString
+	var lastLogin sql.NullInt64
+	err := db.QueryRow(`
+		SELECT u.user_id, u.username, u.role, u.email, u.created_at, i.username, u.last_login, u.enabled,
+		       EXISTS (SELECT 1 FROM _wce_email_verifications v
+		               WHERE v.user_id = u.user_id AND v.verified_at IS NOT NULL AND v.email = u.email COLLATE NOCASE)
+		FROM _wce_users u LEFT JOIN _wce_users i ON i.user_id = u.invited_by
+		WHERE u.user_id = ?
+	`, userID).Scan(&p.UserID, &p.Username, &p.Role, &email, &p.CreatedAt, &invitedBy, &lastLogin, &p.Enabled, &p.EmailVerified)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user: %w", err)
+	}
+	p.Email, p.InvitedBy, p.LastLogin = email.String, invitedBy.String, lastLogin.Int64
+
+	err = collect(db, `
+		SELECT created_at, MAX(expires_at, COALESCE(refresh_expires_at, 0)), COALESCE(last_used, 0),
+		       COALESCE(ip_address, ''), COALESCE(user_agent, '')
+		FROM _wce_sessions WHERE user_id = ? ORDER BY created_at
+	`, []interface{}{userID}, func(rows *sql.Rows) error {
+		var s SessionRecord
+		err := rows.Scan(&s.CreatedAt, &s.ExpiresAt, &s.LastUsed, &s.IPAddress, &s.UserAgent)
+		data.Sessions = append(data.Sessions, s)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	err = collect(db, `
+		SELECT table_name, can_read, can_write, can_delete, can_grant
+		FROM _wce_table_permissions WHERE user_id = ? ORDER BY table_name
+	`, []interface{}{userID}, func(rows *sql.Rows) error {
+		var g PermissionGrant
+		err := rows.Scan(&g.Table, &g.CanRead, &g.CanWrite, &g.CanDelete, &g.CanGrant)
+		data.Permissions = append(data.Permissions, g)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read permissions: %w", err)
+	}
+
+	err = collect(db, `
+		SELECT flag_name, enabled FROM _wce_feature_flag_overrides WHERE user_id = ?
+	`, []interface{}{userID}, func(rows *sql.Rows) error {
+		var name string
+		var enabled bool
+		err := rows.Scan(&name, &enabled)
+		data.FeatureFlags[name] = enabled
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature flags: %w", err)
+	}
+
+	err = collect(db, `
+		SELECT timestamp, succeeded, COALESCE(ip_address, ''), COALESCE(country, '')
+		FROM _wce_login_attempts WHERE user_id = ? OR username = ? ORDER BY id
+	`, []interface{}{userID, p.Username}, func(rows *sql.Rows) error {
+		var l LoginRecord
+		err := rows.Scan(&l.Timestamp, &l.Succeeded, &l.IPAddress, &l.Country)
+		data.LoginAttempts = append(data.LoginAttempts, l)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login attempts: %w", err)
+	}
+
+	err = collect(db, `
+		SELECT timestamp, action, COALESCE(resource_type, ''), COALESCE(resource_id, ''),
+		       COALESCE(ip_address, ''), COALESCE(user_agent, '')
+		FROM _wce_audit_log WHERE user_id = ? ORDER BY id
+	`, []interface{}{userID}, func(rows *sql.Rows) error {
+		var a AuditRecord
+		err := rows.Scan(&a.Timestamp, &a.Action, &a.ResourceType, &a.ResourceID, &a.IPAddress, &a.UserAgent)
+		data.AuditLog = append(data.AuditLog, a)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	err = collect(db, `
+		SELECT id, 'submitter', path, method, status, COALESCE(description, ''), '', submitted_at
+		FROM _wce_endpoint_changes WHERE submitted_by = ?1
+		UNION ALL
+		SELECT id, 'reviewer', path, method, status, '', COALESCE(review_comment, ''), reviewed_at
+		FROM _wce_endpoint_changes WHERE reviewed_by = ?1
+		ORDER BY 8, 1
+	`, []interface{}{userID}, func(rows *sql.Rows) error {
+		var c EndpointComment
+		err := rows.Scan(&c.ID, &c.Role, &c.Path, &c.Method, &c.Status, &c.Description, &c.Comment, &c.At)
+		data.EndpointChanges = append(data.EndpointChanges, c)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read endpoint changes: %w", err)
+	}
+
+	err = collect(db, `
+		SELECT id, created_by = ?1 FROM _wce_documents WHERE created_by = ?1 OR modified_by = ?1
+		UNION
+		SELECT v.document_id, 0 FROM _wce_document_versions v JOIN _wce_documents d ON d.id = v.document_id
+		WHERE v.modified_by = ?1 AND d.created_by != ?1
+		ORDER BY 1
+	`, []interface{}{userID}, func(rows *sql.Rows) error {
+		var id string
+		var authored bool
+		if err := rows.Scan(&id, &authored); err != nil {
+			return err
+		}
+		if authored {
+			data.AuthoredDocuments = append(data.AuthoredDocuments, id)
+		} else {
+			data.EditedDocuments = append(data.EditedDocuments, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read documents: %w", err)
+	}
+
+	return data, nil
+}
+
+// collect runs a query and calls scan for each row
+func collect(db *sql.DB, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
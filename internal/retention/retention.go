@@ -0,0 +1,99 @@
+// Package retention enforces a cenv's data retention: purging audit
+// entries, sessions and operational logs older than its retention settings,
+// and exporting or erasing a user's personal data on request.
+package retention
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thetanil/wce/internal/config"
+)
+
+// Config keys of the retention periods, in days; 0 keeps data forever
+const (
+	AuditDaysConfigKey     = "retention_audit_days"
+	SessionDaysConfigKey   = "retention_session_days"
+	AnalyticsDaysConfigKey = "retention_analytics_days"
+)
+
+// Policy is how many days a cenv keeps each kind of data; 0 keeps it forever
+type Policy struct {
+	AuditDays     int64 `json:"audit_days"`     // Audit log entries
+	SessionDays   int64 `json:"session_days"`   // Sessions ended or unused for this long
+	AnalyticsDays int64 `json:"analytics_days"` // Login attempts, alerts, endpoint logs, failed request captures and slow queries
+}
+
+// LoadPolicy reads a cenv's retention settings
+func LoadPolicy(db *sql.DB) Policy {
+	return Policy{
+		AuditDays:     config.GetInt(db, AuditDaysConfigKey, 0),
+		SessionDays:   config.GetInt(db, SessionDaysConfigKey, 0),
+		AnalyticsDays: config.GetInt(db, AnalyticsDaysConfigKey, 0),
+	}
+}
+
+// IsZero reports whether the policy keeps everything
+func (p Policy) IsZero() bool {
+	return p.AuditDays <= 0 && p.SessionDays <= 0 && p.AnalyticsDays <= 0
+}
+
+// analyticsTables are the operational logs purged by AnalyticsDays, with
+// the column holding each row's time
+var analyticsTables = []struct{ table, column string }{
+	{"_wce_login_attempts", "timestamp"},
+	{"_wce_alerts", "created_at"},
+	{"_wce_endpoint_logs", "created_at"},
+	{"_wce_request_captures", "created_at"},
+	{"_wce_slow_queries", "created_at"},
+}
+
+// Purge deletes the data the policy no longer keeps as of now and returns
+// the number of rows deleted per table. The compliance log is append-only
+// and never purged.
+func Purge(db *sql.DB, policy Policy, now time.Time) (map[string]int64, error) {
+	purged := make(map[string]int64)
+	cutoff := func(days int64) int64 {
+		return now.Add(-time.Duration(days) * 24 * time.Hour).Unix()
+	}
+	deleteRows := func(table, query string, args ...interface{}) error {
+		result, err := db.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %w", table, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			purged[table] += n
+		}
+		return nil
+	}
+
+	if policy.AuditDays > 0 {
+		if err := deleteRows("_wce_audit_log", `DELETE FROM _wce_audit_log WHERE timestamp < ?`, cutoff(policy.AuditDays)); err != nil {
+			return purged, err
+		}
+	}
+
+	if policy.SessionDays > 0 {
+		before := cutoff(policy.SessionDays)
+		err := deleteRows("_wce_sessions", `
+			DELETE FROM _wce_sessions
+			WHERE MAX(expires_at, COALESCE(refresh_expires_at, 0)) < ?
+			   OR COALESCE(last_used, created_at) < ?
+		`, before, before)
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	if policy.AnalyticsDays > 0 {
+		before := cutoff(policy.AnalyticsDays)
+		for _, t := range analyticsTables {
+			if err := deleteRows(t.table, "DELETE FROM "+t.table+" WHERE "+t.column+" < ?", before); err != nil {
+				return purged, err
+			}
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,218 @@
+package retention
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	if _, err := conn.Exec(`
+		INSERT INTO _wce_users (user_id, username, password_hash, role, email, created_at) VALUES
+			('owner-1', 'owner', 'x', 'owner', 'owner@example.com', 0),
+			('user-1', 'alice', 'x', 'editor', 'alice@example.com', 0),
+			('user-2', 'bob', 'x', 'editor', 'bob@example.com', 0)
+	`); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	return conn
+}
+
+// daysAgo returns the Unix time n days before now
+func daysAgo(n int) int64 {
+	return now.Add(-time.Duration(n) * 24 * time.Hour).Unix()
+}
+
+func count(t *testing.T, conn *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("Failed to count: %v", err)
+	}
+	return n
+}
+
+func TestPurge(t *testing.T) {
+	conn := setupTestDB(t)
+
+	for _, ts := range []int64{daysAgo(100), daysAgo(10)} {
+		conn.Exec(`INSERT INTO _wce_audit_log (timestamp, user_id, username, action) VALUES (?, 'user-1', 'alice', 'login')`, ts)
+		conn.Exec(`INSERT INTO _wce_login_attempts (username, succeeded, timestamp) VALUES ('alice', 1, ?)`, ts)
+		conn.Exec(`INSERT INTO _wce_alerts (kind, message, created_at) VALUES ('failed_logins', 'x', ?)`, ts)
+	}
+	// An idle session, one that ended long ago and one in use
+	conn.Exec(`INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at, last_used) VALUES
+		('idle', 'user-1', 'a', ?, ?, ?),
+		('ended', 'user-1', 'b', ?, ?, NULL),
+		('active', 'user-1', 'c', ?, ?, ?)`,
+		daysAgo(100), daysAgo(-1), daysAgo(60),
+		daysAgo(100), daysAgo(99),
+		daysAgo(100), daysAgo(-1), daysAgo(1))
+
+	purged, err := Purge(conn, Policy{}, now)
+	if err != nil || len(purged) != 0 {
+		t.Fatalf("Expected an empty policy to purge nothing, got %v %v", purged, err)
+	}
+
+	purged, err = Purge(conn, Policy{AuditDays: 30, SessionDays: 30, AnalyticsDays: 30}, now)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	want := map[string]int64{"_wce_audit_log": 1, "_wce_sessions": 2, "_wce_login_attempts": 1, "_wce_alerts": 1}
+	for table, n := range want {
+		if purged[table] != n {
+			t.Errorf("Expected %d rows purged from %s, got %d", n, table, purged[table])
+		}
+	}
+	if n := count(t, conn, `SELECT COUNT(*) FROM _wce_sessions WHERE session_id = 'active'`); n != 1 {
+		t.Error("Expected the session in use kept")
+	}
+	if n := count(t, conn, `SELECT COUNT(*) FROM _wce_audit_log`); n != 1 {
+		t.Errorf("Expected the recent audit entry kept, got %d entries", n)
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	conn := setupTestDB(t)
+
+	setup := []string{
+		`INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at) VALUES ('s1', 'user-1', 'a', 0, 9999999999)`,
+		`INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at) VALUES ('s2', 'user-2', 'b', 0, 9999999999)`,
+		`INSERT INTO _wce_table_permissions (table_name, user_id, can_read) VALUES ('notes', 'user-1', 1)`,
+		`INSERT INTO _wce_login_attempts (username, user_id, ip_address, succeeded, timestamp) VALUES ('alice', 'user-1', '10.0.0.1', 1, 0)`,
+		`INSERT INTO _wce_login_attempts (username, ip_address, succeeded, timestamp) VALUES ('alice', '10.0.0.1', 0, 0)`,
+		`INSERT INTO _wce_audit_log (timestamp, user_id, username, action, ip_address, user_agent) VALUES (0, 'user-1', 'alice', 'login', '10.0.0.1', 'curl')`,
+		`INSERT INTO _wce_documents (id, content, content_type, created_at, modified_at, created_by, modified_by) VALUES
+			('notes/alice', 'x', 'text/plain', 0, 0, 'user-1', 'user-1'),
+			('notes/shared', 'x', 'text/plain', 0, 0, 'user-2', 'user-2'),
+			('notes/bob', 'x', 'text/plain', 0, 0, 'user-2', 'user-2')`,
+		`INSERT INTO _wce_document_versions (document_id, version, content, content_type, modified_at, modified_by) VALUES ('notes/shared', 1, 'x', 'text/plain', 0, 'user-1')`,
+		`INSERT INTO _wce_compliance_log (timestamp, user_id, method, route, path, status, prev_hash, hash) VALUES (0, 'user-1', 'POST', 'r', '/p', 200, 'p', 'h')`,
+	}
+	for _, stmt := range setup {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Setup failed: %v\n%s", err, stmt)
+		}
+	}
+
+	if _, err := EraseUser(conn, "owner-1", "user-2", now); err != ErrEraseOwner {
+		t.Errorf("Expected the owner refused, got %v", err)
+	}
+	if _, err := EraseUser(conn, "nobody", "user-2", now); err != ErrUserNotFound {
+		t.Errorf("Expected an unknown user to be not found, got %v", err)
+	}
+
+	report, err := EraseUser(conn, "user-1", "owner-1", now)
+	if err != nil {
+		t.Fatalf("EraseUser failed: %v", err)
+	}
+	if report.Deleted["_wce_sessions"] != 1 || report.Deleted["_wce_table_permissions"] != 1 || report.Deleted["_wce_login_attempts"] != 2 {
+		t.Errorf("Unexpected deletions: %v", report.Deleted)
+	}
+	if report.Anonymized["_wce_users"] != 1 || report.Anonymized["_wce_audit_log"] != 1 {
+		t.Errorf("Unexpected anonymizations: %v", report.Anonymized)
+	}
+	if report.Retained["_wce_compliance_log"] != 1 {
+		t.Errorf("Expected the compliance record retained, got %v", report.Retained)
+	}
+	if len(report.FlaggedDocuments) != 2 || report.FlaggedDocuments[0] != "notes/alice" || report.FlaggedDocuments[1] != "notes/shared" {
+		t.Errorf("Unexpected flagged documents: %v", report.FlaggedDocuments)
+	}
+
+	var username, hash string
+	var email sql.NullString
+	var enabled bool
+	conn.QueryRow(`SELECT username, email, password_hash, enabled FROM _wce_users WHERE user_id = 'user-1'`).Scan(&username, &email, &hash, &enabled)
+	if username != report.Pseudonym || email.Valid || hash != "!" || enabled {
+		t.Errorf("Expected the account anonymized, got %s %v %s %v", username, email, hash, enabled)
+	}
+	if n := count(t, conn, `SELECT COUNT(*) FROM _wce_audit_log WHERE username = 'alice' OR ip_address IS NOT NULL`); n != 0 {
+		t.Error("Expected audit entries anonymized")
+	}
+	if n := count(t, conn, `SELECT COUNT(*) FROM _wce_sessions WHERE user_id = 'user-2'`); n != 1 {
+		t.Error("Expected other users' data kept")
+	}
+
+	reports, err := ListErasures(conn)
+	if err != nil || len(reports) != 1 || reports[0].ID != report.ID || len(reports[0].FlaggedDocuments) != 2 {
+		t.Fatalf("Expected the report stored, got %+v %v", reports, err)
+	}
+}
+
+func TestExportUser(t *testing.T) {
+	conn := setupTestDB(t)
+
+	setup := []string{
+		`UPDATE _wce_users SET invited_by = 'owner-1', last_login = 5 WHERE user_id = 'user-1'`,
+		`INSERT INTO _wce_email_verifications (user_id, email, created_at, expires_at, verified_at) VALUES ('user-1', 'Alice@example.com', 0, 0, 1)`,
+		`INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at, ip_address) VALUES ('s1', 'user-1', 'secret-hash', 1, 2, '10.0.0.1')`,
+		`INSERT INTO _wce_sessions (session_id, user_id, token_hash, created_at, expires_at) VALUES ('s2', 'user-2', 'b', 1, 2)`,
+		`INSERT INTO _wce_table_permissions (table_name, user_id, can_read) VALUES ('notes', 'user-1', 1)`,
+		`INSERT INTO _wce_login_attempts (username, succeeded, timestamp) VALUES ('alice', 0, 3)`,
+		`INSERT INTO _wce_audit_log (timestamp, user_id, username, action, details) VALUES (4, 'user-1', 'alice', 'create_user', '{"body":{"password":"bobpass123"}}')`,
+		`INSERT INTO _wce_endpoint_changes (path, method, script, description, submitted_by, submitted_at) VALUES ('/hello', 'GET', 'x', 'Say hello', 'user-1', 6)`,
+		`INSERT INTO _wce_endpoint_changes (path, method, script, status, submitted_by, submitted_at, reviewed_by, reviewed_at, review_comment) VALUES ('/bye', 'GET', 'x', 'rejected', 'user-2', 6, 'user-1', 7, 'Not yet')`,
+		`INSERT INTO _wce_documents (id, content, content_type, created_at, modified_at, created_by, modified_by) VALUES
+			('notes/alice', 'x', 'text/plain', 0, 0, 'user-1', 'user-2'),
+			('notes/shared', 'x', 'text/plain', 0, 0, 'user-2', 'user-1'),
+			('notes/bob', 'x', 'text/plain', 0, 0, 'user-2', 'user-2')`,
+		`INSERT INTO _wce_document_versions (document_id, version, content, content_type, modified_at, modified_by) VALUES ('notes/shared', 1, 'x', 'text/plain', 0, 'user-1')`,
+	}
+	for _, stmt := range setup {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Setup failed: %v\n%s", err, stmt)
+		}
+	}
+
+	if _, err := ExportUser(conn, "nobody", now); err != ErrUserNotFound {
+		t.Errorf("Expected an unknown user to be not found, got %v", err)
+	}
+
+	data, err := ExportUser(conn, "user-1", now)
+	if err != nil {
+		t.Fatalf("ExportUser failed: %v", err)
+	}
+	p := data.Profile
+	if p.Username != "alice" || p.Email != "alice@example.com" || !p.EmailVerified || p.InvitedBy != "owner" || p.LastLogin != 5 {
+		t.Errorf("Unexpected profile: %+v", p)
+	}
+	if len(data.Sessions) != 1 || data.Sessions[0].IPAddress != "10.0.0.1" {
+		t.Errorf("Expected only the user's session, got %+v", data.Sessions)
+	}
+	if len(data.Permissions) != 1 || len(data.LoginAttempts) != 1 || len(data.AuditLog) != 1 {
+		t.Errorf("Unexpected records: %+v", data)
+	}
+	if len(data.EndpointChanges) != 2 || data.EndpointChanges[1].Comment != "Not yet" || data.EndpointChanges[0].Description != "Say hello" {
+		t.Errorf("Unexpected endpoint changes: %+v", data.EndpointChanges)
+	}
+	if len(data.AuthoredDocuments) != 1 || data.AuthoredDocuments[0] != "notes/alice" ||
+		len(data.EditedDocuments) != 1 || data.EditedDocuments[0] != "notes/shared" {
+		t.Errorf("Unexpected documents: %v %v", data.AuthoredDocuments, data.EditedDocuments)
+	}
+
+	encoded, _ := json.Marshal(data)
+	for _, leak := range []string{"secret-hash", "bobpass123"} {
+		if strings.Contains(string(encoded), leak) {
+			t.Errorf("Expected %q left out of the export", leak)
+		}
+	}
+}
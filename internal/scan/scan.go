@@ -0,0 +1,160 @@
+// Package scan provides pluggable malware scanners for binary document uploads.
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Result holds the verdict returned by a scanner
+type Result struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Scanner inspects content read from r and reports whether it is malicious.
+// Content is streamed, so scanners never hold a whole document in memory.
+type Scanner interface {
+	Scan(ctx context.Context, name string, r io.Reader) (*Result, error)
+}
+
+// clamdChunkSize is the maximum chunk size sent per INSTREAM frame
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans content using a clamd daemon via the INSTREAM command
+type ClamdScanner struct {
+	Network string // "unix" or "tcp"
+	Address string // Socket path or host:port
+	Timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd daemon at the given address
+func NewClamdScanner(network, address string) *ClamdScanner {
+	return &ClamdScanner{
+		Network: network,
+		Address: address,
+		Timeout: 30 * time.Second,
+	}
+}
+
+// Scan streams content to clamd and parses the verdict
+func (c *ClamdScanner) Scan(ctx context.Context, name string, r io.Reader) (*Result, error) {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	// Send content as length-prefixed chunks, terminated by a zero-length chunk
+	chunk := make([]byte, clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return nil, fmt.Errorf("failed to send chunk: %w", err)
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return nil, fmt.Errorf("failed to send chunk: %w", err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content: %w", err)
+		}
+	}
+
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("failed to terminate stream: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read reply: %w", err)
+	}
+
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses replies such as "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return &Result{Infected: false}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Result{
+			Infected:  true,
+			Signature: strings.TrimSuffix(reply, " FOUND"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("clamd error: %s", reply)
+	}
+}
+
+// HTTPScanner scans content by posting it to an external HTTP scanning service.
+// The service must respond with a JSON body of the form
+// {"infected": bool, "signature": "..."}.
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPScanner creates a scanner that posts content to the given URL
+func NewHTTPScanner(url string) *HTTPScanner {
+	return &HTTPScanner{
+		URL:    url,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Scan streams content to the scanning service and decodes the verdict
+func (h *HTTPScanner) Scan(ctx context.Context, name string, r io.Reader) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scan request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", name)
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scan request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("scanner returned status %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode scan result: %w", err)
+	}
+
+	return &result, nil
+}
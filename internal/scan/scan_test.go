@@ -0,0 +1,148 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startFakeClamd starts a unix socket listener that speaks the INSTREAM protocol
+// and flags any content containing "EICAR"
+func startFakeClamd(t *testing.T) string {
+	socketPath := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				command, err := reader.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var content []byte
+				for {
+					var size [4]byte
+					if _, err := io.ReadFull(reader, size[:]); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size[:])
+					if n == 0 {
+						break
+					}
+					chunk := make([]byte, n)
+					if _, err := io.ReadFull(reader, chunk); err != nil {
+						return
+					}
+					content = append(content, chunk...)
+				}
+
+				if strings.Contains(string(content), "EICAR") {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+
+	return socketPath
+}
+
+func TestClamdScanner_Clean(t *testing.T) {
+	socketPath := startFakeClamd(t)
+	scanner := NewClamdScanner("unix", socketPath)
+
+	result, err := scanner.Scan(context.Background(), "image.png", strings.NewReader("harmless content"))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if result.Infected {
+		t.Error("Expected clean result")
+	}
+}
+
+func TestClamdScanner_Infected(t *testing.T) {
+	socketPath := startFakeClamd(t)
+	scanner := NewClamdScanner("unix", socketPath)
+
+	// Larger than one chunk to exercise chunked streaming
+	data := []byte(strings.Repeat("x", clamdChunkSize+10) + "EICAR")
+
+	result, err := scanner.Scan(context.Background(), "payload.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if !result.Infected {
+		t.Error("Expected infected result")
+	}
+	if result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Expected signature 'Eicar-Test-Signature', got '%s'", result.Signature)
+	}
+}
+
+func TestParseClamdReply_Error(t *testing.T) {
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Error("Expected error for clamd error reply")
+	}
+}
+
+func TestHTTPScanner(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Filename") != "doc.pdf" {
+			t.Errorf("Expected X-Filename header 'doc.pdf', got '%s'", r.Header.Get("X-Filename"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(Result{
+			Infected:  strings.Contains(string(body), "EICAR"),
+			Signature: "Test-Signature",
+		})
+	}))
+	defer ts.Close()
+
+	scanner := NewHTTPScanner(ts.URL)
+
+	result, err := scanner.Scan(context.Background(), "doc.pdf", strings.NewReader("EICAR"))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !result.Infected {
+		t.Error("Expected infected result")
+	}
+}
+
+func TestHTTPScanner_ErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	scanner := NewHTTPScanner(ts.URL)
+
+	if _, err := scanner.Scan(context.Background(), "doc.pdf", strings.NewReader("data")); err == nil {
+		t.Error("Expected error for non-2xx status")
+	}
+}
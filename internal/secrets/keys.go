@@ -0,0 +1,192 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// KeyWrapper protects per-cenv data keys with a master key. A KMS plug-in
+// implements it by calling the KMS encrypt and decrypt operations, with
+// KeyID naming the KMS key version. Implementations must be safe for
+// concurrent use.
+type KeyWrapper interface {
+	// KeyID identifies the master key Wrap currently uses
+	KeyID() string
+
+	Wrap(dataKey []byte) ([]byte, error)
+
+	// Unwrap recovers a data key wrapped by the master key keyID, which may
+	// be an earlier key than the current one
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeys is a KeyWrapper backed by a key file holding the current master
+// key and the ones it replaced. Keys are 256-bit and wrap with AES-GCM.
+type LocalKeys struct {
+	path string
+
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// localKeyFile is the on-disk format of LocalKeys
+type localKeyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // id -> hex key
+}
+
+// LoadOrCreateLocalKeys reads the key file at path, creating it with a new
+// master key if it does not exist
+func LoadOrCreateLocalKeys(path string) (*LocalKeys, error) {
+	k := &LocalKeys{path: path, keys: map[string][]byte{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := k.Rotate(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	var file localKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid master key file: %w", err)
+	}
+	for id, encoded := range file.Keys {
+		key, err := hex.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid master key %s", id)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[file.Current]; !ok {
+		return nil, fmt.Errorf("master key file has no current key")
+	}
+	k.current = file.Current
+
+	return k, nil
+}
+
+// Rotate makes a new master key current and saves the key file. Earlier keys
+// are kept so existing data keys can still be unwrapped, and are re-wrapped
+// with the new key the next time each cenv uses its secrets.
+func (k *LocalKeys) Rotate() error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate master key: %w", err)
+	}
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys["local-"+hex.EncodeToString(id)] = key
+	previous := k.current
+	k.current = "local-" + hex.EncodeToString(id)
+
+	if err := k.save(); err != nil {
+		delete(k.keys, k.current)
+		k.current = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the key file, readable by the owner only
+func (k *LocalKeys) save() error {
+	file := localKeyFile{Current: k.current, Keys: map[string]string{}}
+	for id, key := range k.keys {
+		file.Keys[id] = hex.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode master key file: %w", err)
+	}
+
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	return nil
+}
+
+// KeyID returns the current master key's id
+func (k *LocalKeys) KeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Wrap encrypts a data key with the current master key
+func (k *LocalKeys) Wrap(dataKey []byte) ([]byte, error) {
+	k.mu.RLock()
+	id, key := k.current, k.keys[k.current]
+	k.mu.RUnlock()
+
+	return seal(key, dataKey, []byte(id))
+}
+
+// Unwrap decrypts a data key wrapped by master key keyID
+func (k *LocalKeys) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	k.mu.RLock()
+	key, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown master key: %s", keyID)
+	}
+
+	return open(key, wrapped, []byte(keyID))
+}
+
+// seal encrypts plaintext with AES-256-GCM, prefixing the random nonce.
+// additionalData is authenticated but not stored.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,360 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// valuePrefix marks an encrypted value: "wce:v1:<data key id>:<base64>".
+// Values without it are plaintext written before encryption at rest and are
+// re-encrypted when next read.
+const valuePrefix = "wce:v1:"
+
+// keyTableSchema creates the data key table in cenvs created before it was
+// part of the schema
+const keyTableSchema = `
+CREATE TABLE IF NOT EXISTS _wce_secret_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    wrapped_key BLOB NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    created_by TEXT
+)`
+
+// Manager encrypts secret values with a per-cenv data key, which is stored
+// wrapped by the server's master key
+type Manager struct {
+	keys KeyWrapper
+
+	// ready records databases whose key table has been created
+	ready sync.Map
+}
+
+// KeyStatus describes a cenv's current data key
+type KeyStatus struct {
+	DataKeyID   int64  `json:"data_key_id"`
+	MasterKeyID string `json:"master_key_id"`
+	CreatedAt   int64  `json:"created_at"`
+	CreatedBy   string `json:"created_by,omitempty"`
+	Secrets     int    `json:"secrets"`
+}
+
+// NewManager creates a manager that wraps data keys with keys
+func NewManager(keys KeyWrapper) *Manager {
+	return &Manager{keys: keys}
+}
+
+// Set creates or replaces a secret, encrypting its value
+func (m *Manager) Set(db *sql.DB, name, value, userID string) error {
+	if !IsValidName(name) {
+		return fmt.Errorf("invalid secret name: %s", name)
+	}
+	if value == "" {
+		return fmt.Errorf("secret value cannot be empty")
+	}
+
+	keyID, key, err := m.dataKey(db, userID)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptValue(keyID, key, name, value)
+	if err != nil {
+		return err
+	}
+
+	var updatedBy interface{}
+	if userID != "" {
+		updatedBy = userID
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO _wce_secrets (name, value, updated_at, updated_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			value = excluded.value,
+			updated_at = excluded.updated_at,
+			updated_by = excluded.updated_by
+	`, name, encrypted, time.Now().Unix(), updatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to set secret %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Get returns a secret's decrypted value
+func (m *Manager) Get(db *sql.DB, name string) (string, error) {
+	if err := m.ensureKeyTable(db); err != nil {
+		return "", err
+	}
+
+	var stored string
+	err := db.QueryRow(`SELECT value FROM _wce_secrets WHERE name = ?`, name).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("secret not found: %s", name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	if !strings.HasPrefix(stored, valuePrefix) {
+		// Legacy plaintext: encrypt it in place, keeping updated_at/by
+		if keyID, key, err := m.dataKey(db, ""); err == nil {
+			if encrypted, err := encryptValue(keyID, key, name, stored); err == nil {
+				db.Exec(`UPDATE _wce_secrets SET value = ? WHERE name = ? AND value = ?`, encrypted, name, stored)
+			}
+		}
+		return stored, nil
+	}
+
+	return m.decryptValue(db, name, stored)
+}
+
+// Rotate replaces a cenv's data key: every secret, including any legacy
+// plaintext, is re-encrypted with a new key and the old keys are deleted
+func (m *Manager) Rotate(db *sql.DB, userID string) (*KeyStatus, error) {
+	if err := m.ensureKeyTable(db); err != nil {
+		return nil, err
+	}
+
+	// Decrypt everything before touching the keys so a failure leaves the
+	// cenv unchanged
+	rows, err := db.Query(`SELECT name, value FROM _wce_secrets`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+	values := map[string]string{}
+	for rows.Next() {
+		var name, stored string
+		if err := rows.Scan(&name, &stored); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		values[name] = stored
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating secrets: %w", err)
+	}
+	for name, stored := range values {
+		if !strings.HasPrefix(stored, valuePrefix) {
+			continue
+		}
+		plaintext, err := m.decryptValue(db, name, stored)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = plaintext
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := m.keys.Wrap(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	keyID, err := insertDataKey(tx, wrapped, m.keys.KeyID(), userID)
+	if err != nil {
+		return nil, err
+	}
+	for name, plaintext := range values {
+		encrypted, err := encryptValue(keyID, key, name, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`UPDATE _wce_secrets SET value = ? WHERE name = ?`, encrypted, name); err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt secret %s: %w", name, err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM _wce_secret_keys WHERE id != ?`, keyID); err != nil {
+		return nil, fmt.Errorf("failed to delete old data keys: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return m.Status(db)
+}
+
+// Status describes the cenv's current data key, creating one if needed
+func (m *Manager) Status(db *sql.DB) (*KeyStatus, error) {
+	keyID, _, err := m.dataKey(db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	status := &KeyStatus{DataKeyID: keyID}
+	var createdBy sql.NullString
+	err = db.QueryRow(`
+		SELECT master_key_id, created_at, created_by FROM _wce_secret_keys WHERE id = ?
+	`, keyID).Scan(&status.MasterKeyID, &status.CreatedAt, &createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data key: %w", err)
+	}
+	status.CreatedBy = createdBy.String
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM _wce_secrets`).Scan(&status.Secrets); err != nil {
+		return nil, fmt.Errorf("failed to count secrets: %w", err)
+	}
+
+	return status, nil
+}
+
+// dataKey returns the cenv's newest data key, creating it on first use and
+// re-wrapping it if the master key has been rotated since it was stored
+func (m *Manager) dataKey(db *sql.DB, userID string) (int64, []byte, error) {
+	if err := m.ensureKeyTable(db); err != nil {
+		return 0, nil, err
+	}
+
+	var id int64
+	err := db.QueryRow(`SELECT id FROM _wce_secret_keys ORDER BY id DESC LIMIT 1`).Scan(&id)
+	if err == sql.ErrNoRows {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return 0, nil, fmt.Errorf("failed to generate data key: %w", err)
+		}
+		wrapped, err := m.keys.Wrap(key)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+		id, err := insertDataKey(db, wrapped, m.keys.KeyID(), userID)
+		if err != nil {
+			return 0, nil, err
+		}
+		return id, key, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read data key: %w", err)
+	}
+
+	key, err := m.keyByID(db, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return id, key, nil
+}
+
+// keyByID unwraps data key id. Keys wrapped by an earlier master key are
+// re-wrapped with the current one so the old master key can be retired.
+func (m *Manager) keyByID(db *sql.DB, id int64) ([]byte, error) {
+	var wrapped []byte
+	var masterKeyID string
+	err := db.QueryRow(`
+		SELECT wrapped_key, master_key_id FROM _wce_secret_keys WHERE id = ?
+	`, id).Scan(&wrapped, &masterKeyID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("data key %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data key: %w", err)
+	}
+
+	key, err := m.keys.Unwrap(masterKeyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %d: %w", id, err)
+	}
+
+	if masterKeyID != m.keys.KeyID() {
+		if rewrapped, err := m.keys.Wrap(key); err == nil {
+			db.Exec(`UPDATE _wce_secret_keys SET wrapped_key = ?, master_key_id = ? WHERE id = ?`,
+				rewrapped, m.keys.KeyID(), id)
+		}
+	}
+
+	return key, nil
+}
+
+// decryptValue decrypts a stored "wce:v1:" value
+func (m *Manager) decryptValue(db *sql.DB, name, stored string) (string, error) {
+	idPart, encoded, ok := strings.Cut(strings.TrimPrefix(stored, valuePrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("secret %s is corrupt", name)
+	}
+	keyID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("secret %s is corrupt", name)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("secret %s is corrupt", name)
+	}
+
+	key, err := m.keyByID(db, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	// The name is authenticated so values cannot be swapped between secrets
+	plaintext, err := open(key, sealed, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// ensureKeyTable creates the data key table once per database
+func (m *Manager) ensureKeyTable(db *sql.DB) error {
+	if _, ok := m.ready.Load(db); ok {
+		return nil
+	}
+	if _, err := db.Exec(keyTableSchema); err != nil {
+		return fmt.Errorf("failed to create secret key table: %w", err)
+	}
+	m.ready.Store(db, true)
+	return nil
+}
+
+// encryptValue seals a value with data key keyID
+func encryptValue(keyID int64, key []byte, name, value string) (string, error) {
+	sealed, err := seal(key, []byte(value), []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt secret %s: %w", name, err)
+	}
+	return valuePrefix + strconv.FormatInt(keyID, 10) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertDataKey stores a wrapped data key and returns its id
+func insertDataKey(db execer, wrapped []byte, masterKeyID, userID string) (int64, error) {
+	var createdBy interface{}
+	if userID != "" {
+		createdBy = userID
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO _wce_secret_keys (wrapped_key, master_key_id, created_at, created_by)
+		VALUES (?, ?, ?, ?)
+	`, wrapped, masterKeyID, time.Now().Unix(), createdBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store data key: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get data key id: %w", err)
+	}
+	return id, nil
+}
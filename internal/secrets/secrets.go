@@ -0,0 +1,82 @@
+// Package secrets stores named credentials for a cenv. Values are write-only
+// through the API and are only read server-side, e.g. by proxy routes. They
+// are encrypted at rest with a per-cenv data key; see Manager.
+package secrets
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// validName matches secret names: letters, digits, '_', '-' and '.'
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]{0,63}$`)
+
+// Info describes a secret without its value
+type Info struct {
+	Name      string `json:"name"`
+	UpdatedAt int64  `json:"updated_at"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// IsValidName checks if a secret name is allowed
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Exists reports whether a secret is defined, without decrypting it
+func Exists(db *sql.DB, name string) (bool, error) {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM _wce_secrets WHERE name = ?`, name).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check secret: %w", err)
+	}
+	return count > 0, nil
+}
+
+// List returns all secrets ordered by name, without values
+func List(db *sql.DB) ([]Info, error) {
+	rows, err := db.Query(`SELECT name, updated_at, updated_by FROM _wce_secrets ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	defer rows.Close()
+
+	infos := []Info{}
+	for rows.Next() {
+		var info Info
+		var updatedBy sql.NullString
+		if err := rows.Scan(&info.Name, &info.UpdatedAt, &updatedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		info.UpdatedBy = updatedBy.String
+		infos = append(infos, info)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating secrets: %w", err)
+	}
+
+	return infos, nil
+}
+
+// Delete removes a secret. Secrets still referenced by a proxy route cannot be deleted.
+func Delete(db *sql.DB, name string) error {
+	result, err := db.Exec(`DELETE FROM _wce_secrets WHERE name = ?`, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+			return fmt.Errorf("secret %s is still used by a proxy route", name)
+		}
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("secret not found: %s", name)
+	}
+
+	return nil
+}
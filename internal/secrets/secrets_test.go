@@ -0,0 +1,188 @@
+package secrets
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	return conn
+}
+
+func setupTestKeys(t *testing.T) *LocalKeys {
+	keys, err := LoadOrCreateLocalKeys(filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatalf("Failed to create master key: %v", err)
+	}
+	return keys
+}
+
+func storedValue(t *testing.T, conn *sql.DB, name string) string {
+	var value string
+	if err := conn.QueryRow(`SELECT value FROM _wce_secrets WHERE name = ?`, name).Scan(&value); err != nil {
+		t.Fatalf("Failed to read stored value: %v", err)
+	}
+	return value
+}
+
+func TestSecrets(t *testing.T) {
+	conn := setupTestDB(t)
+	m := NewManager(setupTestKeys(t))
+
+	if err := m.Set(conn, "bad name", "x", ""); err == nil {
+		t.Error("Expected invalid name to be rejected")
+	}
+	if err := m.Set(conn, "api_key", "", ""); err == nil {
+		t.Error("Expected empty value to be rejected")
+	}
+
+	if err := m.Set(conn, "api_key", "one", ""); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := m.Set(conn, "api_key", "two", ""); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	value, err := m.Get(conn, "api_key")
+	if err != nil || value != "two" {
+		t.Errorf("Expected replaced value, got %q (%v)", value, err)
+	}
+	if exists, _ := Exists(conn, "api_key"); !exists {
+		t.Error("Expected secret to exist")
+	}
+
+	infos, err := List(conn)
+	if err != nil || len(infos) != 1 || infos[0].Name != "api_key" {
+		t.Errorf("Unexpected list: %v (%v)", infos, err)
+	}
+
+	if err := Delete(conn, "api_key"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := m.Get(conn, "api_key"); err == nil {
+		t.Error("Expected deleted secret to be gone")
+	}
+	if err := Delete(conn, "api_key"); err == nil {
+		t.Error("Expected error deleting a missing secret")
+	}
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	conn := setupTestDB(t)
+	m := NewManager(setupTestKeys(t))
+
+	m.Set(conn, "token", "s3cret-value", "")
+	m.Set(conn, "other", "other-value", "")
+
+	stored := storedValue(t, conn, "token")
+	if !strings.HasPrefix(stored, valuePrefix) || strings.Contains(stored, "s3cret-value") {
+		t.Errorf("Expected encrypted value, got %q", stored)
+	}
+
+	// Ciphertext is bound to the secret name
+	conn.Exec(`UPDATE _wce_secrets SET value = ? WHERE name = 'other'`, stored)
+	if _, err := m.Get(conn, "other"); err == nil {
+		t.Error("Expected value copied from another secret to fail")
+	}
+
+	// A different master key cannot unwrap the data key
+	if _, err := NewManager(setupTestKeys(t)).Get(conn, "token"); err == nil {
+		t.Error("Expected foreign master key to fail")
+	}
+
+	// Legacy plaintext is readable and re-encrypted in place
+	conn.Exec(`UPDATE _wce_secrets SET value = 'legacy' WHERE name = 'other'`)
+	if value, err := m.Get(conn, "other"); err != nil || value != "legacy" {
+		t.Errorf("Expected legacy value, got %q (%v)", value, err)
+	}
+	if stored := storedValue(t, conn, "other"); !strings.HasPrefix(stored, valuePrefix) {
+		t.Errorf("Expected legacy value to be re-encrypted, got %q", stored)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	conn := setupTestDB(t)
+	m := NewManager(setupTestKeys(t))
+
+	m.Set(conn, "token", "abc", "")
+	conn.Exec(`INSERT INTO _wce_secrets (name, value, updated_at) VALUES ('legacy', 'plain', 0)`)
+
+	before, err := m.Status(conn)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	after, err := m.Rotate(conn, "")
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if after.DataKeyID == before.DataKeyID || after.Secrets != 2 {
+		t.Errorf("Unexpected status after rotation: %+v", after)
+	}
+
+	var keys int
+	conn.QueryRow(`SELECT COUNT(*) FROM _wce_secret_keys`).Scan(&keys)
+	if keys != 1 {
+		t.Errorf("Expected old data keys to be deleted, got %d", keys)
+	}
+
+	for name, want := range map[string]string{"token": "abc", "legacy": "plain"} {
+		if !strings.HasPrefix(storedValue(t, conn, name), valuePrefix) {
+			t.Errorf("Expected %s to be encrypted after rotation", name)
+		}
+		if value, err := m.Get(conn, name); err != nil || value != want {
+			t.Errorf("Expected %s=%q, got %q (%v)", name, want, value, err)
+		}
+	}
+}
+
+func TestMasterKeyRotation(t *testing.T) {
+	conn := setupTestDB(t)
+	path := filepath.Join(t.TempDir(), "master.key")
+	keys, err := LoadOrCreateLocalKeys(path)
+	if err != nil {
+		t.Fatalf("Failed to create master key: %v", err)
+	}
+	m := NewManager(keys)
+
+	m.Set(conn, "token", "abc", "")
+	oldID := keys.KeyID()
+
+	if err := keys.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if keys.KeyID() == oldID {
+		t.Fatal("Expected a new current master key")
+	}
+
+	// The reloaded key file still unwraps the old data key, which is then
+	// re-wrapped with the new master key
+	reloaded, err := LoadOrCreateLocalKeys(path)
+	if err != nil || reloaded.KeyID() != keys.KeyID() {
+		t.Fatalf("Failed to reload key file: %v", err)
+	}
+	if value, err := NewManager(reloaded).Get(conn, "token"); err != nil || value != "abc" {
+		t.Fatalf("Expected value after master rotation, got %q (%v)", value, err)
+	}
+
+	status, err := m.Status(conn)
+	if err != nil || status.MasterKeyID != keys.KeyID() {
+		t.Errorf("Expected data key re-wrapped with %s, got %+v (%v)", keys.KeyID(), status, err)
+	}
+}
@@ -0,0 +1,410 @@
+// Package seed applies fixture data to user tables and documents.
+//
+// A fixture is a JSON document stored under the "seeds/" prefix:
+//
+//	{
+//	  "tables": [
+//	    {"table": "categories", "rows": [{"id": 1, "name": "Books"}]}
+//	  ],
+//	  "documents": [
+//	    {"id": "pages/home", "content": "<h1>Home</h1>", "content_type": "text/html"}
+//	  ]
+//	}
+//
+// Applying a fixture is idempotent: rows are matched on their primary key and
+// documents on their id, and existing ones are left alone unless overwrite is set.
+package seed
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// FixturePrefix is the document id prefix for seed fixtures
+const FixturePrefix = "seeds/"
+
+// Seed run statuses
+const (
+	StatusApplied = "applied"
+	StatusFailed  = "failed"
+)
+
+// maxFixtures bounds how many fixtures ApplyAll processes
+const maxFixtures = 1000
+
+// Fixture is the seed data carried by a fixture document.
+// Tables are applied in order, so referenced tables should come first.
+type Fixture struct {
+	Tables    []TableFixture    `json:"tables"`
+	Documents []DocumentFixture `json:"documents"`
+}
+
+// TableFixture holds rows for a user table. Every row must set the table's primary key.
+type TableFixture struct {
+	Table string                   `json:"table"`
+	Rows  []map[string]interface{} `json:"rows"`
+}
+
+// DocumentFixture describes a document to create
+type DocumentFixture struct {
+	ID          string `json:"id"`
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+	IsBinary    bool   `json:"is_binary"`
+	Searchable  bool   `json:"searchable"`
+}
+
+// Run records the outcome of applying a fixture
+type Run struct {
+	FixtureID        string `json:"fixture_id"`
+	Checksum         string `json:"checksum"`
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+	RowsInserted     int    `json:"rows_inserted"`
+	RowsUpdated      int    `json:"rows_updated"`
+	DocumentsCreated int    `json:"documents_created"`
+	DocumentsUpdated int    `json:"documents_updated"`
+	AppliedAt        int64  `json:"applied_at"`
+	AppliedBy        string `json:"applied_by,omitempty"`
+
+	// Documents created or updated by the run, for follow-up such as scanning
+	Documents []*document.Document `json:"-"`
+}
+
+// IsFixture checks if a document id names a seed fixture
+func IsFixture(id string) bool {
+	return strings.HasPrefix(id, FixturePrefix) && len(id) > len(FixturePrefix)
+}
+
+// Checksum returns the SHA-256 of fixture content
+func Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Parse decodes fixture content. Numbers are kept as json.Number so integer
+// values are stored as integers.
+func Parse(content string) (*Fixture, error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	var fixture Fixture
+	if err := decoder.Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture: %w", err)
+	}
+
+	for _, t := range fixture.Tables {
+		if !tables.IsValidIdentifier(t.Table) {
+			return nil, fmt.Errorf("invalid table name in fixture: %q", t.Table)
+		}
+		if tables.IsReservedName(t.Table) {
+			return nil, fmt.Errorf("fixtures cannot seed system table: %s", t.Table)
+		}
+	}
+	for _, d := range fixture.Documents {
+		if d.ID == "" || d.Content == "" || d.ContentType == "" {
+			return nil, fmt.Errorf("fixture documents need id, content and content_type")
+		}
+	}
+
+	return &fixture, nil
+}
+
+// Apply applies a stored fixture and records the run, whether it succeeds or fails
+func Apply(db *sql.DB, fixtureID, userID string, overwrite bool) (*Run, error) {
+	if !IsFixture(fixtureID) {
+		return nil, fmt.Errorf("fixture ids must start with %s", FixturePrefix)
+	}
+
+	doc, err := document.GetDocument(db, fixtureID)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &Run{
+		FixtureID: fixtureID,
+		Checksum:  Checksum(doc.Content),
+		Status:    StatusApplied,
+		AppliedAt: time.Now().Unix(),
+		AppliedBy: userID,
+	}
+
+	applyErr := applyFixture(db, doc.Content, userID, overwrite, run)
+	if applyErr != nil {
+		run.Status = StatusFailed
+		run.Error = applyErr.Error()
+	}
+
+	if err := recordRun(db, run); err != nil {
+		return nil, err
+	}
+
+	return run, applyErr
+}
+
+// ApplyAll applies every stored fixture in id order. A failing fixture does
+// not stop the others; its run carries the error.
+func ApplyAll(db *sql.DB, userID string, overwrite bool) ([]*Run, error) {
+	docs, err := document.ListDocuments(db, FixturePrefix, maxFixtures, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []*Run{}
+	for _, doc := range docs {
+		run, err := Apply(db, doc.ID, userID, overwrite)
+		if run == nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// NeedsApply reports whether a fixture's content differs from its last successful run
+func NeedsApply(db *sql.DB, fixtureID, content string) (bool, error) {
+	var checksum string
+	err := db.QueryRow(`
+		SELECT checksum FROM _wce_seed_runs WHERE fixture_id = ? AND status = ?
+	`, fixtureID, StatusApplied).Scan(&checksum)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query seed run: %w", err)
+	}
+	return checksum != Checksum(content), nil
+}
+
+// ListRuns lists the last run of each fixture
+func ListRuns(db *sql.DB) ([]Run, error) {
+	rows, err := db.Query(`
+		SELECT fixture_id, checksum, status, error, rows_inserted, rows_updated,
+		       documents_created, documents_updated, applied_at, applied_by
+		FROM _wce_seed_runs
+		ORDER BY fixture_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list seed runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		var errorText, appliedBy sql.NullString
+		err := rows.Scan(&run.FixtureID, &run.Checksum, &run.Status, &errorText,
+			&run.RowsInserted, &run.RowsUpdated, &run.DocumentsCreated, &run.DocumentsUpdated,
+			&run.AppliedAt, &appliedBy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan seed run: %w", err)
+		}
+		run.Error = errorText.String
+		run.AppliedBy = appliedBy.String
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating seed runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+// recordRun stores a run as the fixture's latest
+func recordRun(db *sql.DB, run *Run) error {
+	var appliedBy interface{}
+	if run.AppliedBy != "" {
+		appliedBy = run.AppliedBy
+	}
+
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO _wce_seed_runs (
+			fixture_id, checksum, status, error, rows_inserted, rows_updated,
+			documents_created, documents_updated, applied_at, applied_by
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.FixtureID, run.Checksum, run.Status, run.Error, run.RowsInserted, run.RowsUpdated,
+		run.DocumentsCreated, run.DocumentsUpdated, run.AppliedAt, appliedBy)
+	if err != nil {
+		return fmt.Errorf("failed to record seed run: %w", err)
+	}
+
+	return nil
+}
+
+// applyFixture applies table rows in one transaction, then documents.
+// Documents go through the document package, so a failure there leaves the
+// rows in place; re-applying the fixture completes it.
+func applyFixture(db *sql.DB, content, userID string, overwrite bool, run *Run) error {
+	fixture, err := Parse(content)
+	if err != nil {
+		return err
+	}
+
+	// Describe tables up front, before the transaction holds a connection
+	schemas := make([]*tables.TableSchema, len(fixture.Tables))
+	for i, t := range fixture.Tables {
+		schemas[i], err = tables.DescribeTable(db, t.Table)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, t := range fixture.Tables {
+		for n, row := range t.Rows {
+			inserted, updated, err := applyRow(tx, schemas[i], row, overwrite)
+			if err != nil {
+				return fmt.Errorf("table %s row %d: %w", t.Table, n+1, err)
+			}
+			if inserted {
+				run.RowsInserted++
+			}
+			if updated {
+				run.RowsUpdated++
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, d := range fixture.Documents {
+		existing, err := document.GetDocument(db, d.ID)
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+
+		if existing == nil {
+			doc, err := document.CreateDocument(db, d.ID, d.Content, d.ContentType, userID, d.IsBinary, d.Searchable)
+			if err != nil {
+				return fmt.Errorf("document %s: %w", d.ID, err)
+			}
+			run.DocumentsCreated++
+			run.Documents = append(run.Documents, doc)
+			continue
+		}
+
+		if overwrite && existing.Content != d.Content {
+			doc, err := document.UpdateDocument(db, d.ID, d.Content, userID)
+			if err != nil {
+				return fmt.Errorf("document %s: %w", d.ID, err)
+			}
+			run.DocumentsUpdated++
+			run.Documents = append(run.Documents, doc)
+		}
+	}
+
+	return nil
+}
+
+// applyRow inserts a row unless one with the same primary key exists, in
+// which case it is updated if overwrite is set
+func applyRow(tx *sql.Tx, schema *tables.TableSchema, row map[string]interface{}, overwrite bool) (inserted, updated bool, err error) {
+	declared := make(map[string]string, len(schema.Columns))
+	for _, column := range schema.Columns {
+		declared[strings.ToLower(column.Name)] = column.Name
+	}
+
+	values := make(map[string]interface{}, len(row))
+	for key, value := range row {
+		name, ok := declared[strings.ToLower(key)]
+		if !ok {
+			return false, false, fmt.Errorf("unknown column: %s", key)
+		}
+		values[name], err = columnValue(value)
+		if err != nil {
+			return false, false, err
+		}
+	}
+
+	var keyConditions, setClauses, columns []string
+	var keyArgs, setArgs, insertArgs []interface{}
+	for _, column := range schema.Columns {
+		value, ok := values[column.Name]
+		if column.PrimaryKey {
+			if !ok || value == nil {
+				return false, false, fmt.Errorf("missing primary key column: %s", column.Name)
+			}
+			keyConditions = append(keyConditions, fmt.Sprintf(`"%s" = ?`, column.Name))
+			keyArgs = append(keyArgs, value)
+		} else if ok {
+			setClauses = append(setClauses, fmt.Sprintf(`"%s" = ?`, column.Name))
+			setArgs = append(setArgs, value)
+		}
+		if ok {
+			columns = append(columns, fmt.Sprintf(`"%s"`, column.Name))
+			insertArgs = append(insertArgs, value)
+		}
+	}
+	if len(keyConditions) == 0 {
+		return false, false, fmt.Errorf("table %s has no primary key to match rows on", schema.Name)
+	}
+
+	where := strings.Join(keyConditions, " AND ")
+
+	var exists int
+	err = tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE %s`, schema.Name, where), keyArgs...).Scan(&exists)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to check row: %w", err)
+	}
+
+	if exists > 0 {
+		if !overwrite || len(setClauses) == 0 {
+			return false, false, nil
+		}
+		_, err = tx.Exec(fmt.Sprintf(`UPDATE "%s" SET %s WHERE %s`, schema.Name, strings.Join(setClauses, ", "), where),
+			append(setArgs, keyArgs...)...)
+		if err != nil {
+			return false, false, fmt.Errorf("failed to update row: %w", err)
+		}
+		return false, true, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`, schema.Name, strings.Join(columns, ", "), placeholders),
+		insertArgs...)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to insert row: %w", err)
+	}
+
+	return true, false, nil
+}
+
+// columnValue converts a decoded JSON value to a SQLite value.
+// Objects and arrays are stored as JSON text.
+func columnValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]interface{}, []interface{}:
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return nil, fmt.Errorf("failed to encode value: %w", err)
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,184 @@
+package seed
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	_, err = conn.Exec(`
+		INSERT INTO _wce_users (user_id, username, password_hash, role, created_at)
+		VALUES ('user-1', 'admin', 'x', 'owner', 0);
+		CREATE TABLE categories (id INTEGER PRIMARY KEY, name TEXT NOT NULL, meta TEXT);
+		CREATE TABLE products (
+			sku TEXT PRIMARY KEY,
+			category_id INTEGER REFERENCES categories(id),
+			price REAL
+		);
+		CREATE TABLE log_lines (line TEXT);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	return conn
+}
+
+func storeFixture(t *testing.T, conn *sql.DB, id, content string) {
+	t.Helper()
+	if _, err := document.CreateDocument(conn, id, content, "application/json", "user-1", false, false); err != nil {
+		t.Fatalf("Failed to store fixture: %v", err)
+	}
+}
+
+const demoFixture = `{
+	"tables": [
+		{"table": "categories", "rows": [
+			{"id": 1, "name": "Books", "meta": {"icon": "<b>"}},
+			{"id": 2, "name": "Music"}
+		]},
+		{"table": "products", "rows": [
+			{"sku": "B-1", "category_id": 1, "price": 9.5}
+		]}
+	],
+	"documents": [
+		{"id": "pages/home", "content": "<h1>Demo</h1>", "content_type": "text/html"}
+	]
+}`
+
+func TestApply(t *testing.T) {
+	conn := setupTestDB(t)
+	storeFixture(t, conn, "seeds/demo", demoFixture)
+
+	run, err := Apply(conn, "seeds/demo", "user-1", false)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if run.RowsInserted != 3 || run.DocumentsCreated != 1 || run.Status != StatusApplied {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+
+	var meta string
+	var price float64
+	conn.QueryRow(`SELECT meta FROM categories WHERE id = 1`).Scan(&meta)
+	conn.QueryRow(`SELECT price FROM products WHERE sku = 'B-1'`).Scan(&price)
+	if meta != `{"icon":"<b>"}` || price != 9.5 {
+		t.Errorf("Unexpected values: meta=%s price=%v", meta, price)
+	}
+
+	if pending, _ := NeedsApply(conn, "seeds/demo", demoFixture); pending {
+		t.Error("Expected applied fixture to be up to date")
+	}
+
+	t.Run("Idempotent", func(t *testing.T) {
+		conn.Exec(`UPDATE categories SET name = 'Edited' WHERE id = 1`)
+
+		run, err := Apply(conn, "seeds/demo", "user-1", false)
+		if err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		if run.RowsInserted != 0 || run.RowsUpdated != 0 || run.DocumentsCreated != 0 {
+			t.Errorf("Expected nothing applied twice, got %+v", run)
+		}
+
+		var name string
+		conn.QueryRow(`SELECT name FROM categories WHERE id = 1`).Scan(&name)
+		if name != "Edited" {
+			t.Errorf("Existing rows should be kept, got %q", name)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		document.UpdateDocument(conn, "pages/home", "<h1>Changed</h1>", "user-1")
+
+		run, err := Apply(conn, "seeds/demo", "user-1", true)
+		if err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		if run.RowsUpdated != 3 || run.DocumentsUpdated != 1 {
+			t.Errorf("Expected rows and document reset, got %+v", run)
+		}
+
+		doc, _ := document.GetDocument(conn, "pages/home")
+		if doc.Content != "<h1>Demo</h1>" {
+			t.Errorf("Expected seeded content, got %q", doc.Content)
+		}
+	})
+}
+
+func TestApply_Failures(t *testing.T) {
+	conn := setupTestDB(t)
+
+	tests := []struct {
+		name    string
+		fixture string
+	}{
+		{"system table", `{"tables": [{"table": "_wce_users", "rows": [{"user_id": "x"}]}]}`},
+		{"unknown column", `{"tables": [{"table": "categories", "rows": [{"id": 3, "nope": 1}]}]}`},
+		{"missing key", `{"tables": [{"table": "categories", "rows": [{"name": "x"}]}]}`},
+		{"no primary key", `{"tables": [{"table": "log_lines", "rows": [{"line": "x"}]}]}`},
+		{"foreign key", `{"tables": [{"table": "products", "rows": [{"sku": "X", "category_id": 99}]}]}`},
+		{"unknown field", `{"rows": []}`},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := "seeds/bad" + string(rune('a'+i))
+			storeFixture(t, conn, id, tt.fixture)
+
+			run, err := Apply(conn, id, "user-1", false)
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if run == nil || run.Status != StatusFailed {
+				t.Errorf("Expected failed run recorded, got %+v", run)
+			}
+		})
+	}
+
+	// Failed fixtures roll back their rows
+	var count int
+	conn.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no rows from failed fixtures, got %d", count)
+	}
+
+	runs, err := ListRuns(conn)
+	if err != nil || len(runs) != len(tests) {
+		t.Errorf("Expected %d runs, got %d (%v)", len(tests), len(runs), err)
+	}
+
+	if _, err := Apply(conn, "pages/home", "user-1", false); err == nil {
+		t.Error("Expected error for non-fixture document")
+	}
+}
+
+func TestApplyAll(t *testing.T) {
+	conn := setupTestDB(t)
+	storeFixture(t, conn, "seeds/1-categories", `{"tables": [{"table": "categories", "rows": [{"id": 1, "name": "A"}]}]}`)
+	storeFixture(t, conn, "seeds/2-products", `{"tables": [{"table": "products", "rows": [{"sku": "P", "category_id": 1}]}]}`)
+	storeFixture(t, conn, "seeds/3-broken", `not json`)
+
+	runs, err := ApplyAll(conn, "user-1", false)
+	if err != nil {
+		t.Fatalf("ApplyAll failed: %v", err)
+	}
+	if len(runs) != 3 || runs[1].Status != StatusApplied || runs[2].Status != StatusFailed {
+		t.Errorf("Unexpected runs: %+v", runs)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thetanil/wce/internal/alerts"
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// SetGeoIP configures the GeoIP plug-in used to alert on logins from new
+// countries. A nil GeoIP disables those alerts.
+func (s *Server) SetGeoIP(geo alerts.GeoIP) {
+	s.monitor.GeoIP = geo
+}
+
+// recordLogin passes a login attempt to the alert monitor. Monitoring
+// failures are logged and never fail the login.
+func (s *Server) recordLogin(r *http.Request, db *sql.DB, cenvID, username, userID string, succeeded bool) {
+	err := s.monitor.RecordLogin(db, cenvID, alerts.LoginAttempt{
+		Username:  username,
+		UserID:    userID,
+		IPAddress: r.RemoteAddr,
+		Succeeded: succeeded,
+	})
+	if err != nil {
+		log.Printf("Failed to record login attempt in cenv %s: %v", cenvID, err)
+	}
+}
+
+// recordRoleChange passes a user's role change to the alert monitor
+func (s *Server) recordRoleChange(db *sql.DB, cenvID, actorID, username, oldRole, newRole string) {
+	if err := s.monitor.RecordRoleChange(db, cenvID, actorID, username, oldRole, newRole); err != nil {
+		log.Printf("Failed to record role change in cenv %s: %v", cenvID, err)
+	}
+}
+
+// recordGrantOption alerts when a grant gives a user the grant option on a
+// table they did not have it on. prev is the permission before the grant.
+func (s *Server) recordGrantOption(db *sql.DB, cenvID, actorID, userID, table string, prev *authz.Permission) {
+	if prev != nil && prev.CanGrant {
+		return
+	}
+	if err := s.monitor.RecordGrantOption(db, cenvID, actorID, userID, table); err != nil {
+		log.Printf("Failed to record permission grant in cenv %s: %v", cenvID, err)
+	}
+}
+
+// handleListAlerts lists raised alerts, newest first (admin/owner only).
+// ?kind= filters by kind and ?limit= caps the result (default 100).
+func (s *Server) handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view alerts")
+	if err != nil {
+		return // Response already sent
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	list, err := alerts.List(db, r.URL.Query().Get("kind"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list alerts",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"alerts": list,
+		"count":  len(list),
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/alerts"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestAuthAlerts(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("PUT /{cenvID}/admin/users/{username}", srv.handlePutUser)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("GET /{cenvID}/admin/alerts", srv.handleListAlerts)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	list := func(kind string) []alerts.Alert {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/alerts?kind="+kind, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to list alerts: %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Alerts []alerts.Alert `json:"alerts"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Alerts
+	}
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/alert_webhook_url", token,
+		map[string]string{"value": "ftp://example.com/hook"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid webhook URL to be rejected, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/alert_failed_logins", token,
+		map[string]string{"value": "3"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set threshold: %d %s", w.Code, w.Body.String())
+	}
+
+	// Failed logins for a username that does not exist still count
+	for i := 0; i < 3; i++ {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{
+			"username": "mallory", "password": "guess",
+		})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, got %d", w.Code)
+		}
+	}
+	if got := list(alerts.KindFailedLogins); len(got) != 2 {
+		// One for the username and one for the client address
+		t.Errorf("Expected 2 failed login alerts, got %+v", got)
+	}
+
+	// Promoting a user is an escalation
+	for _, role := range []string{"viewer", "admin"} {
+		w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/users/bob", token, map[string]interface{}{
+			"role": role, "password": "bobpass123",
+		})
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("Failed to put user: %d %s", w.Code, w.Body.String())
+		}
+	}
+	escalations := list(alerts.KindPermissionEscalation)
+	if len(escalations) != 1 || escalations[0].Message != `user "bob" was promoted from viewer to admin` {
+		t.Errorf("Unexpected escalation alerts: %+v", escalations)
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/alerts", "", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthenticated listing to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// assetPrefix is the document id prefix served as static assets, so
+// GET /{cenvID}/assets/css/site.css serves document assets/css/site.css
+const assetPrefix = "assets/"
+
+// assetVersionLength is the length of the content hash prefix in the
+// versioned URL of an asset; any prefix this long or longer is accepted
+const assetVersionLength = 16
+
+// immutableCacheControl lets browsers and CDNs keep a versioned asset for a
+// year without revalidating: new content gets a new URL
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// handleServeAsset serves a document under assets/ raw, without
+// authentication, for the CSS, JS and images of sites built in a cenv. A
+// ?v= query parameter matching the start of the content hash marks the URL
+// as versioned and makes the response cacheable forever; without it, or
+// when it names old content, clients revalidate with the ETag each time.
+// Range requests are supported, including for streamed documents. A ?w=
+// query parameter on a JPEG, PNG or GIF image serves a thumbnail that wide
+// instead, generated on first request and cached until the image changes.
+// Route: GET /{cenvID}/assets/{path...}
+func (s *Server) handleServeAsset(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	doc, err := document.GetDocument(db, assetPrefix+r.PathValue("path"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "Asset not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read asset", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The same checks as raw document reads: scanned binaries only, and the
+	// cenv's serving policy for the content type
+	if doc.IsBinary {
+		scanRecord, err := document.GetScanRecord(db, doc.ID)
+		if err != nil {
+			http.Error(w, "Failed to check scan status", http.StatusInternalServerError)
+			return
+		}
+		if !scanRecord.IsServable() {
+			if scanRecord.Status == document.ScanStatusPending {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Asset is "+scanRecord.Status, http.StatusServiceUnavailable)
+			} else {
+				http.Error(w, "Asset is "+scanRecord.Status, http.StatusForbidden)
+			}
+			return
+		}
+	}
+	disposition, err := document.ResolveDisposition(db, doc.ContentType)
+	if err != nil {
+		http.Error(w, "Failed to check mime policy", http.StatusInternalServerError)
+		return
+	}
+	if disposition == document.DispositionReject {
+		http.Error(w, "Content type not allowed: "+doc.ContentType, http.StatusForbidden)
+		return
+	}
+
+	hash, err := document.ContentHash(r.Context(), db, doc)
+	if err != nil {
+		http.Error(w, "Failed to read asset", http.StatusInternalServerError)
+		return
+	}
+
+	var thumb *document.Thumbnail
+	if width := r.URL.Query().Get("w"); width != "" {
+		n, err := strconv.Atoi(width)
+		if err != nil || n < document.MinThumbnailWidth || n > document.MaxThumbnailWidth {
+			http.Error(w, fmt.Sprintf("w must be between %d and %d", document.MinThumbnailWidth, document.MaxThumbnailWidth), http.StatusBadRequest)
+			return
+		}
+		if !document.CanThumbnail(doc) {
+			http.Error(w, "Thumbnails are not available for "+doc.ContentType, http.StatusBadRequest)
+			return
+		}
+		thumb, err = document.GetThumbnail(r.Context(), db, doc, hash, n)
+		if err != nil {
+			log.Printf("Failed to make thumbnail of %s: %v", doc.ID, err)
+			http.Error(w, "Failed to make thumbnail", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if version := r.URL.Query().Get("v"); len(version) >= assetVersionLength && strings.HasPrefix(hash, version) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		location := r.URL.Path + "?v=" + hash[:assetVersionLength]
+		if thumb != nil {
+			location += "&w=" + strconv.Itoa(thumb.Width)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Location", location)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if thumb != nil {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-w%d"`, hash, thumb.Width))
+		w.Header().Set("Content-Type", thumb.ContentType)
+		http.ServeContent(w, r, "", time.Unix(thumb.CreatedAt, 0), bytes.NewReader(thumb.Data))
+		return
+	}
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Content-Type", doc.ContentType)
+	if disposition == document.DispositionAttachment {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": path.Base(doc.ID),
+		}))
+	}
+
+	var content io.ReadSeeker
+	switch {
+	case doc.IsBlob():
+		reader, err := document.OpenBlob(r.Context(), db, doc.ID)
+		if err != nil {
+			http.Error(w, "Failed to read asset", http.StatusInternalServerError)
+			return
+		}
+		defer reader.Close()
+		content = reader
+	case doc.IsBinary:
+		data, err := base64.StdEncoding.DecodeString(doc.Content)
+		if err != nil {
+			log.Printf("Asset %s has invalid base64 content: %v", doc.ID, err)
+			http.Error(w, "Failed to read asset", http.StatusInternalServerError)
+			return
+		}
+		content = strings.NewReader(string(data))
+	default:
+		content = strings.NewReader(doc.Content)
+	}
+
+	// ServeContent answers conditional and range requests from the ETag
+	// and modification time
+	http.ServeContent(w, r, "", time.Unix(doc.ModifiedAt, 0), content)
+}
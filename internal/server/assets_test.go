@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestServeAsset(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("GET /{cenvID}/assets/{path...}", srv.handleServeAsset)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	const css = "body { color: #333; }\n"
+	pixel := []byte{0x89, 'P', 'N', 'G', 0, 1, 2, 3}
+	docs := []map[string]interface{}{
+		{"id": "assets/css/site.css", "content": css, "content_type": "text/css"},
+		{"id": "assets/img/pixel.png", "content": base64.StdEncoding.EncodeToString(pixel), "content_type": "image/png", "is_binary": true},
+		{"id": "private/notes.txt", "content": "secret", "content_type": "text/plain"},
+	}
+	for _, doc := range docs {
+		if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, doc); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	get := func(path string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+cenvID+"/assets/"+path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// Served raw without authentication, revalidated unless versioned
+	w := get("css/site.css")
+	if w.Code != http.StatusOK || w.Body.String() != css || w.Header().Get("Content-Type") != "text/css" {
+		t.Fatalf("Unexpected response %d %q: %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != "no-cache" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Unexpected headers: %v", w.Header())
+	}
+	versioned := w.Header().Get("Content-Location")
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(versioned, "/"+cenvID+"/assets/css/site.css?v=") {
+		t.Fatalf("Unexpected Content-Location %q", versioned)
+	}
+	version := versioned[strings.Index(versioned, "=")+1:]
+	if !strings.HasPrefix(etag, `"`+version) {
+		t.Errorf("Expected the version to prefix the ETag %s, got %s", etag, version)
+	}
+
+	if w := get("css/site.css?v=" + version); w.Header().Get("Cache-Control") != immutableCacheControl {
+		t.Errorf("Expected a versioned URL to be immutable, got %q", w.Header().Get("Cache-Control"))
+	}
+	if w := get("css/site.css?v=0000000000000000"); w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Expected a stale version to revalidate, got %q", w.Header().Get("Cache-Control"))
+	}
+	if w := get("css/site.css", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", w.Code)
+	}
+
+	// Ranges
+	w = get("css/site.css", "Range", "bytes=5-9")
+	if w.Code != http.StatusPartialContent || w.Body.String() != css[5:10] || w.Header().Get("Content-Range") != "bytes 5-9/22" {
+		t.Errorf("Unexpected range response %d %q: %q", w.Code, w.Header().Get("Content-Range"), w.Body.String())
+	}
+	if w := get("css/site.css", "Range", "bytes=100-"); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected 416, got %d", w.Code)
+	}
+
+	// Binary documents are decoded
+	if w := get("img/pixel.png"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), pixel) {
+		t.Errorf("Unexpected binary asset %d: %v", w.Code, w.Body.Bytes())
+	}
+
+	// Streamed documents are ranged across chunks
+	data := bytes.Repeat([]byte("0123456789"), document.BlobChunkSize/5)
+	req := httptest.NewRequest("PUT", "/"+cenvID+"/documents/assets/js/app.js", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "text/javascript")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	start := document.BlobChunkSize - 5
+	w = get("js/app.js", "Range", "bytes="+strconv.Itoa(start)+"-"+strconv.Itoa(start+9))
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(data[start:start+10]) {
+		t.Errorf("Unexpected blob range %d: %q", w.Code, w.Body.String())
+	}
+
+	// Only documents under assets/ are served
+	if w := get("../private/notes.txt"); w.Code == http.StatusOK {
+		t.Errorf("Expected documents outside assets/ not to be served, got %q", w.Body.String())
+	}
+	if w := get("css/missing.css"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
+
+func TestServeAssetThumbnail(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/assets/{path...}", srv.handleServeAsset)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	var photo bytes.Buffer
+	png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 300, 150)))
+	docs := []map[string]interface{}{
+		{"id": "assets/img/photo.png", "content": base64.StdEncoding.EncodeToString(photo.Bytes()), "content_type": "image/png", "is_binary": true},
+		{"id": "assets/img/broken.png", "content": base64.StdEncoding.EncodeToString([]byte("not a png")), "content_type": "image/png", "is_binary": true},
+		{"id": "assets/css/site.css", "content": "body {}", "content_type": "text/css"},
+	}
+	for _, doc := range docs {
+		if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, doc); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	get := func(path string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+cenvID+"/assets/"+path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("img/photo.png?w=100")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Unexpected response %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	config, err := png.DecodeConfig(w.Body)
+	if err != nil || config.Width != 100 || config.Height != 50 {
+		t.Errorf("Expected a 100x50 PNG, got %dx%d (%v)", config.Width, config.Height, err)
+	}
+	etag := w.Header().Get("ETag")
+	if !strings.HasSuffix(etag, `-w100"`) {
+		t.Errorf("Expected a per-width ETag, got %s", etag)
+	}
+	if location := w.Header().Get("Content-Location"); !strings.HasSuffix(location, "&w=100") {
+		t.Errorf("Expected a versioned thumbnail URL, got %q", location)
+	}
+	if w := get("img/photo.png?w=100", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", w.Code)
+	}
+	if w := get("img/photo.png"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), photo.Bytes()) {
+		t.Errorf("Expected the original without w, got %d", w.Code)
+	}
+
+	for path, code := range map[string]int{
+		"img/photo.png?w=abc":   http.StatusBadRequest,
+		"img/photo.png?w=5000":  http.StatusBadRequest,
+		"css/site.css?w=100":    http.StatusBadRequest,
+		"img/broken.png?w=100":  http.StatusUnprocessableEntity,
+		"img/missing.png?w=100": http.StatusNotFound,
+	} {
+		if w := get(path); w.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+}
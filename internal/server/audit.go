@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// handleListAudit lists audit log entries, newest first (admin/owner only).
+// ?action= filters by action and ?limit= caps the result (default 100).
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view the audit log")
+	if err != nil {
+		return // Response already sent
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := audit.List(db, r.URL.Query().Get("action"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list audit log",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
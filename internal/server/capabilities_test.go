@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+func TestEndpointCapabilities(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/admin/config", srv.handleListConfig)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints", srv.handleListEndpoints)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	db.Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)`)
+
+	writeScript := "def handle_request(req):\n" +
+		"    db.execute(\"INSERT INTO notes (body) VALUES ('x')\")\n" +
+		"    return response(\"ok\")"
+
+	t.Run("AdminDefaultsToAllCapabilities", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path": "/write", "method": "POST", "script": writeScript,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/star/write", token, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected write to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ReadOnlyEndpoint", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path": "/write-ro", "method": "POST", "script": writeScript, "capabilities": []string{},
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/star/write-ro", token, nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected write to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnknownCapability", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path": "/bad", "method": "GET", "script": writeScript, "capabilities": []string{"shell"},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("EditorNotAllowedByDefault", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", editorToken, map[string]interface{}{
+			"path": "/editor", "method": "POST", "script": writeScript,
+		})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("EditorCappedToGrant", func(t *testing.T) {
+		if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/editor_endpoint_capabilities", token,
+			map[string]string{"value": `["shell"]`}); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected invalid grant to be rejected, got %d", w.Code)
+		}
+
+		if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/allow_editor_endpoints", token,
+			map[string]string{"value": "true"}); w.Code != http.StatusOK {
+			t.Fatalf("Failed to set config: %d %s", w.Code, w.Body.String())
+		}
+
+		// Editor asks for db_write but the grant is read-only
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", editorToken, map[string]interface{}{
+			"path": "/editor", "method": "POST", "script": writeScript, "capabilities": []string{"db_write"},
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected editor to create endpoint, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if caps, _ := resp["capabilities"].([]interface{}); len(caps) != 0 {
+			t.Errorf("Expected no capabilities for editor endpoint, got %v", caps)
+		}
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/star/editor", token, nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected write to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("EditorGrantedKV", func(t *testing.T) {
+		if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/editor_endpoint_capabilities", token,
+			map[string]string{"value": `["kv"]`}); w.Code != http.StatusOK {
+			t.Fatalf("Failed to set grant: %d %s", w.Code, w.Body.String())
+		}
+
+		kvScript := "def handle_request(req):\n" +
+			"    kv.set(\"hits\", kv.get(\"hits\", 0) + 1)\n" +
+			"    return response(kv.get(\"hits\"))"
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", editorToken, map[string]interface{}{
+			"path": "/editor-kv", "method": "POST", "script": kvScript, "capabilities": []string{"db_write", "kv"},
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected editor to create endpoint, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if caps, _ := resp["capabilities"].([]interface{}); len(caps) != 1 || caps[0] != "kv" {
+			t.Errorf("Expected [kv] for editor endpoint, got %v", caps)
+		}
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/star/editor-kv", token, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected kv to work, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ListShowsCapabilities", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/endpoints", token, nil)
+		var endpoints []Endpoint
+		json.NewDecoder(w.Body).Decode(&endpoints)
+
+		for _, ep := range endpoints {
+			if ep.Path == "/write" && len(ep.Capabilities) != len(starlark_pkg.AllCapabilities) {
+				t.Errorf("Expected all capabilities on /write, got %v", ep.Capabilities)
+			}
+			if ep.Path == "/write-ro" && len(ep.Capabilities) != 0 {
+				t.Errorf("Expected no capabilities on /write-ro, got %v", ep.Capabilities)
+			}
+		}
+	})
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected exactly 1 note written, got %d", count)
+	}
+}
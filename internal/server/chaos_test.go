@@ -0,0 +1,442 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// faultDriverName is the driver chaos tests open cenv databases with: SQLite
+// with the faults of the faultInjector registered for the database's
+// storage directory
+const faultDriverName = "sqlite3-faults"
+
+func init() {
+	sql.Register(faultDriverName, &faultDriver{})
+}
+
+// errInjected is the database error faults return by default
+var errInjected = errors.New("injected fault: disk I/O error")
+
+// fault fails or delays the statements containing match. A delayed
+// statement gives up early with the context's error when its context ends.
+type fault struct {
+	match string
+	err   error
+	delay time.Duration
+}
+
+// faultInjector holds the faults active for one test's cenv databases
+type faultInjector struct {
+	mu     sync.Mutex
+	faults []fault
+	hits   int
+}
+
+// injectors maps storage directories to the injector for their databases
+var injectors sync.Map
+
+// fail makes statements containing match fail with err
+func (f *faultInjector) fail(match string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, fault{match: match, err: err})
+}
+
+// slow delays statements containing match by delay
+func (f *faultInjector) slow(match string, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, fault{match: match, delay: delay})
+}
+
+// reset removes every fault
+func (f *faultInjector) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+	f.hits = 0
+}
+
+// triggered returns how many statements hit a fault since the last reset
+func (f *faultInjector) triggered() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits
+}
+
+// check applies the first fault matching query
+func (f *faultInjector) check(ctx context.Context, query string) error {
+	f.mu.Lock()
+	var matched *fault
+	for i := range f.faults {
+		if strings.Contains(query, f.faults[i].match) {
+			matched = &f.faults[i]
+			f.hits++
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if matched == nil {
+		return nil
+	}
+	if matched.delay > 0 {
+		select {
+		case <-time.After(matched.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return matched.err
+}
+
+// faultDriver opens SQLite connections, with whichever driver the build
+// selects, that consult the injector of the storage directory they are in
+type faultDriver struct {
+	once sync.Once
+	base driver.Driver
+}
+
+// sqliteConn is the part of a SQLite connection faultConn intercepts. Both
+// drivers' connections implement it.
+type sqliteConn interface {
+	driver.Conn
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.ConnPrepareContext
+	driver.ConnBeginTx
+}
+
+func (d *faultDriver) Open(dsn string) (driver.Conn, error) {
+	d.once.Do(func() {
+		// Opening a handle connects to nothing; it only resolves the driver
+		db, _ := sql.Open(sqlite.DriverName, "")
+		d.base = db.Driver()
+		db.Close()
+	})
+	conn, err := d.base.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	base, ok := conn.(sqliteConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected driver connection: %T", conn)
+	}
+
+	injector := &faultInjector{}
+	injectors.Range(func(dir, value interface{}) bool {
+		if strings.HasPrefix(dsn, dir.(string)) {
+			injector = value.(*faultInjector)
+			return false
+		}
+		return true
+	})
+	return &faultConn{sqliteConn: base, faults: injector}, nil
+}
+
+// faultConn is a SQLite connection whose statements and transactions go
+// through its injector first. Transactions match the fault "BEGIN".
+type faultConn struct {
+	sqliteConn
+	faults *faultInjector
+}
+
+func (c *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.faults.check(ctx, query); err != nil {
+		return nil, err
+	}
+	return c.sqliteConn.ExecContext(ctx, query, args)
+}
+
+func (c *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.faults.check(ctx, query); err != nil {
+		return nil, err
+	}
+	return c.sqliteConn.QueryContext(ctx, query, args)
+}
+
+func (c *faultConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.faults.check(ctx, query); err != nil {
+		return nil, err
+	}
+	return c.sqliteConn.PrepareContext(ctx, query)
+}
+
+func (c *faultConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.faults.check(ctx, "BEGIN"); err != nil {
+		return nil, err
+	}
+	return c.sqliteConn.BeginTx(ctx, opts)
+}
+
+// chaosEnv is a server whose cenv databases inject faults
+type chaosEnv struct {
+	manager *cenv.Manager
+	faults  *faultInjector
+	mux     *http.ServeMux
+	cenvID  string
+	token   string
+}
+
+// newChaosEnv creates a server with one cenv, routes its document and auth
+// handlers, and returns it with no faults active
+func newChaosEnv(t *testing.T) *chaosEnv {
+	t.Helper()
+
+	dir := t.TempDir()
+	faults := &faultInjector{}
+	injectors.Store(dir, faults)
+	t.Cleanup(func() { injectors.Delete(dir) })
+
+	manager := cenv.NewManager(dir)
+	manager.SetDriver(faultDriverName)
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/documents/search", srv.handleSearchDocuments)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/export", srv.handleExportDocuments)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", srv.handleDeleteDocument)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+	mux.HandleFunc("GET /{cenvID}/assets/{path...}", srv.handleServeAsset)
+
+	env := &chaosEnv{manager: manager, faults: faults, mux: mux}
+	env.cenvID, env.token = setupTestCenv(t, mux)
+	return env
+}
+
+// path returns a path inside the cenv
+func (env *chaosEnv) path(rest string) string {
+	return "/" + env.cenvID + rest
+}
+
+// assertNoLeaks fails the test when a request left a connection checked out
+// of the cenv's pool, which happens when rows or transactions are not closed.
+// Transactions of cancelled contexts are rolled back in the background, so
+// connections get a moment to come back.
+func (env *chaosEnv) assertNoLeaks(t *testing.T) {
+	t.Helper()
+
+	db, err := env.manager.GetConnection(env.cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for db.Stats().InUse != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Errorf("Expected no connections in use, got %d", inUse)
+	}
+}
+
+func TestChaosDatabaseErrors(t *testing.T) {
+	env := newChaosEnv(t)
+
+	w := doJSON(t, env.mux, "POST", env.path("/documents"), env.token, map[string]interface{}{
+		"id": "pages/home", "content": "hello", "content_type": "text/plain",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		fault  string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"ReadDocument", "FROM _wce_documents", "GET", "/documents/pages/home", nil, http.StatusInternalServerError},
+		{"ListDocuments", "FROM _wce_documents", "GET", "/documents", nil, http.StatusInternalServerError},
+		{"SearchDocuments", "_wce_document_search", "GET", "/documents/search?q=hello", nil, http.StatusInternalServerError},
+		{"CreateDocument", "INSERT INTO _wce_documents", "POST", "/documents", map[string]interface{}{
+			"id": "pages/new", "content": "x", "content_type": "text/plain",
+		}, http.StatusInternalServerError},
+		{"CreateDocumentCommit", "BEGIN", "POST", "/documents", map[string]interface{}{
+			"id": "pages/new", "content": "x", "content_type": "text/plain",
+		}, http.StatusInternalServerError},
+		{"UpdateDocument", "UPDATE _wce_documents", "PUT", "/documents/pages/home", map[string]string{"content": "changed"}, http.StatusInternalServerError},
+		{"DeleteDocument", "DELETE FROM _wce_documents", "DELETE", "/documents/pages/home", nil, http.StatusInternalServerError},
+		{"ChangeLog", "INSERT INTO _wce_audit_log", "PUT", "/documents/pages/home", map[string]string{"content": "changed"}, http.StatusInternalServerError},
+		{"SessionCheck", "_wce_sessions", "GET", "/documents/pages/home", nil, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env.faults.reset()
+			env.faults.fail(tt.fault, errInjected)
+			defer env.faults.reset()
+
+			w := doJSON(t, env.mux, tt.method, env.path(tt.path), env.token, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if env.faults.triggered() == 0 {
+				t.Errorf("Fault %q was never hit", tt.fault)
+			}
+			if strings.Contains(w.Body.String(), errInjected.Error()) && w.Code != http.StatusInternalServerError {
+				t.Errorf("Database error leaked into a %d response: %s", w.Code, w.Body.String())
+			}
+			env.assertNoLeaks(t)
+		})
+	}
+
+	// Failed writes leave the document as it was
+	w = doJSON(t, env.mux, "GET", env.path("/documents/pages/home"), env.token, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"content":"hello"`) || !strings.Contains(w.Body.String(), `"version":1`) {
+		t.Errorf("Expected the document to be unchanged, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, env.mux, "GET", env.path("/documents/pages/new"), env.token, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the failed create to leave nothing behind, got %d", w.Code)
+	}
+}
+
+func TestChaosLogin(t *testing.T) {
+	env := newChaosEnv(t)
+
+	creds := map[string]string{"username": "admin", "password": "adminpass123"}
+
+	// A database failure is not a wrong password
+	env.faults.fail("FROM _wce_users", errInjected)
+	w := doJSON(t, env.mux, "POST", env.path("/login"), "", creds)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when users cannot be read, got %d: %s", w.Code, w.Body.String())
+	}
+	env.assertNoLeaks(t)
+
+	env.faults.reset()
+	env.faults.fail("INSERT INTO _wce_sessions", errInjected)
+	w = doJSON(t, env.mux, "POST", env.path("/login"), "", creds)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "token") {
+		t.Errorf("Expected 500 without a token when the session cannot be stored, got %d: %s", w.Code, w.Body.String())
+	}
+	env.assertNoLeaks(t)
+
+	env.faults.reset()
+	if w := doJSON(t, env.mux, "POST", env.path("/login"), "", creds); w.Code != http.StatusOK {
+		t.Errorf("Expected login to recover, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChaosSlowQueries(t *testing.T) {
+	env := newChaosEnv(t)
+
+	doJSON(t, env.mux, "POST", env.path("/documents"), env.token, map[string]interface{}{
+		"id": "pages/home", "content": "hello", "content_type": "text/plain",
+	})
+
+	// Slow statements delay the response but do not change it
+	env.faults.slow("FROM _wce_documents", 50*time.Millisecond)
+	start := time.Now()
+	w := doJSON(t, env.mux, "GET", env.path("/documents/pages/home"), env.token, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 from a slow database, got %d: %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the fault to delay the request, took %v", elapsed)
+	}
+	env.assertNoLeaks(t)
+
+	// Concurrent slow writes all land, each exactly once
+	env.faults.reset()
+	env.faults.slow("UPDATE _wce_documents", 10*time.Millisecond)
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doJSON(t, env.mux, "PUT", env.path("/documents/pages/home"), env.token, map[string]string{"content": "again"})
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	updated := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			updated++
+		case http.StatusConflict, http.StatusInternalServerError:
+			// Lost a race for the same version or the write lock
+		default:
+			t.Errorf("Unexpected status for a concurrent update: %d", code)
+		}
+	}
+	env.faults.reset()
+	w = doJSON(t, env.mux, "GET", env.path("/documents/pages/home"), env.token, nil)
+	if !strings.Contains(w.Body.String(), fmt.Sprintf(`"version":%d`, 1+updated)) {
+		t.Errorf("Expected version %d after %d updates, got %s", 1+updated, updated, w.Body.String())
+	}
+	env.assertNoLeaks(t)
+}
+
+func TestChaosCancelledRequests(t *testing.T) {
+	env := newChaosEnv(t)
+
+	req := httptest.NewRequest("PUT", env.path("/documents/assets/logo.bin"), bytes.NewReader(bytes.Repeat([]byte{1, 2, 3}, 1000)))
+	req.Header.Set("Authorization", "Bearer "+env.token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	w := httptest.NewRecorder()
+	env.mux.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Requests whose client goes away while streamed content is stuck stop
+	// waiting for it and release their connection
+	env.faults.slow("SELECT data FROM _wce_document_blobs", time.Minute)
+	for _, path := range []string{"/assets/logo.bin", "/documents/assets/logo.bin", "/documents/export?prefix=assets/"} {
+		t.Run(path, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			req := httptest.NewRequest("GET", env.path(path), nil).WithContext(ctx)
+			req.Header.Set("Authorization", "Bearer "+env.token)
+			req.Header.Set("Accept", "application/octet-stream")
+			w := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				env.mux.ServeHTTP(w, req)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Handler kept waiting after the request was cancelled")
+			}
+
+			// Content hashes are stored, so every response is sent before
+			// the content is read and is cut short
+			if w.Body.Len() >= 3000 {
+				t.Errorf("Expected the content to be cut short, got %d bytes", w.Body.Len())
+			}
+			if env.faults.triggered() == 0 {
+				t.Error("Expected the request to reach the stuck query")
+			}
+			env.assertNoLeaks(t)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/cluster"
+)
+
+// SetCoordinator enables multi-instance operation. Requests that may write to
+// a cenv are only served while this instance holds the cenv's write lease, and
+// completed writes are published on the coordinator's bus. Without a
+// coordinator the server assumes it is the only process using the storage directory.
+func (s *Server) SetCoordinator(coordinator *cluster.Coordinator) {
+	s.coordinator = coordinator
+}
+
+// mayWrite reports whether a request can modify its cenv. Starlark endpoints
+// can write on any method, so they always count as writes.
+func mayWrite(r *http.Request, path string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return strings.HasPrefix(path, "/star/")
+	}
+	return true
+}
+
+// coordinationMiddleware gates writes on the cenv's write lease. Every response
+// names the serving instance in X-WCE-Instance; when another instance holds the
+// lease the request is refused with 503 and X-WCE-Lease-Holder (plus
+// X-WCE-Lease-Address when advertised) so a load balancer can route the cenv there.
+func (s *Server) coordinationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.coordinator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-WCE-Instance", s.coordinator.Instance.ID)
+
+		cenvID, path, ok := cenv.ParsePath(r.URL.Path)
+		if !ok || !mayWrite(r, path) || !s.cenvManager.Exists(cenvID) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		lease, err := s.coordinator.Claim(cenvID)
+		if errors.Is(err, cluster.ErrLeaseHeld) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-WCE-Lease-Holder", lease.Holder.ID)
+			if lease.Holder.Address != "" {
+				w.Header().Set("X-WCE-Lease-Address", lease.Holder.Address)
+			}
+			retry := int(time.Until(lease.ExpiresAt).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "cenv is being written by another instance",
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to claim write lease for cenv %s: %v", cenvID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "failed to claim write lease",
+			})
+			return
+		}
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		if wrapped.statusCode < 400 {
+			event := cluster.Event{
+				CenvID: cenvID,
+				Method: r.Method,
+				Path:   path,
+				Time:   time.Now().Unix(),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.coordinator.Publish(ctx, event); err != nil {
+				log.Printf("Failed to publish write event for cenv %s: %v", cenvID, err)
+			}
+		}
+	})
+}
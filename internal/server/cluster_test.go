@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/cluster"
+)
+
+func TestCoordinationMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	manager := cenv.NewManager(dir)
+	bus := cluster.NewLocalBus()
+
+	// Two instances sharing one storage directory and signing key
+	newInstance := func(id string, srv *Server) *http.ServeMux {
+		srv.SetCoordinator(cluster.NewCoordinator(
+			cluster.Instance{ID: id, Address: "http://" + id + ":5309"},
+			cluster.NewFileLeases(dir), bus,
+		))
+
+		inner := http.NewServeMux()
+		inner.HandleFunc("POST /new", srv.handleNewCenv)
+		inner.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+		inner.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+		inner.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+
+		outer := http.NewServeMux()
+		outer.Handle("/", srv.coordinationMiddleware(inner))
+		return outer
+	}
+	srvA := New(0, manager)
+	srvB := New(0, manager)
+	srvB.jwtManager = srvA.jwtManager
+	muxA := newInstance("a", srvA)
+	muxB := newInstance("b", srvB)
+
+	var events []cluster.Event
+	cancel, _ := srvB.coordinator.Subscribe(func(e cluster.Event) { events = append(events, e) })
+	defer cancel()
+
+	// Login is a write, so instance a takes the lease
+	cenvID, token := setupTestCenv(t, muxA)
+
+	doc := map[string]interface{}{"id": "pages/home", "content": "hi", "content_type": "text/plain"}
+	if w := doJSON(t, muxA, "POST", "/"+cenvID+"/documents", token, doc); w.Code != http.StatusCreated {
+		t.Fatalf("Expected holder write to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(events) == 0 || events[len(events)-1].Path != "/documents" {
+		t.Errorf("Expected write event on the bus, got %+v", events)
+	}
+
+	t.Run("OtherInstanceRefusesWrites", func(t *testing.T) {
+		w := doJSON(t, muxB, "POST", "/"+cenvID+"/documents", token, doc)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected 503, got %d", w.Code)
+		}
+		if w.Header().Get("X-WCE-Lease-Holder") != "a" || w.Header().Get("X-WCE-Lease-Address") != "http://a:5309" {
+			t.Errorf("Expected routing hints, got %v", w.Header())
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After")
+		}
+	})
+
+	t.Run("OtherInstanceServesReads", func(t *testing.T) {
+		w := doJSON(t, muxB, "GET", "/"+cenvID+"/documents", token, nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-WCE-Instance") != "b" {
+			t.Errorf("Expected read from b, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("Handoff", func(t *testing.T) {
+		srvA.coordinator.Close()
+		if w := doJSON(t, muxB, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": "pages/about", "content": "hi", "content_type": "text/plain",
+		}); w.Code != http.StatusCreated {
+			t.Errorf("Expected b to write after a released, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// handleListCollections lists the saved search definitions
+func (s *Server) handleListCollections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	collections, err := document.ListCollections(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"collections": collections,
+		"count":       len(collections),
+	})
+}
+
+// handleRunCollection runs a saved search and returns a page of results,
+// ?limit= overriding its page size and ?cursor= continuing from the
+// next_cursor of the previous page
+// Route: GET /{cenvID}/collections/{name}
+func (s *Server) handleRunCollection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	collection, results, nextCursor, err := document.RunCollection(db, r.PathValue("name"), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case strings.HasPrefix(err.Error(), "collection not found"):
+			status = http.StatusNotFound
+		case err.Error() == "invalid cursor":
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	response := map[string]interface{}{
+		"collection": collection,
+		"results":    results,
+		"count":      len(results),
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handlePutCollection creates or replaces a saved search from
+// {"query", "tags", "prefix", "sort", "page_size", "schema_id"}, a schema
+// making it a typed collection with CRUD endpoints under /items
+func (s *Server) handlePutCollection(w http.ResponseWriter, r *http.Request) {
+	userID, db, ok := s.authorizeCollectionWrite(w, r)
+	if !ok {
+		return
+	}
+
+	var collection document.Collection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+	collection.Name = r.PathValue("name")
+
+	saved, err := document.SaveCollection(db, collection, userID)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(saved)
+}
+
+// handleDeleteCollection removes a saved search
+func (s *Server) handleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	_, db, ok := s.authorizeCollectionWrite(w, r)
+	if !ok {
+		return
+	}
+
+	if err := document.DeleteCollection(db, r.PathValue("name")); err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": true,
+	})
+}
+
+// authorizeCollectionWrite authenticates a request that changes saved
+// searches, which needs write access to documents, sending the error
+// response when it is not allowed
+func (s *Server) authorizeCollectionWrite(w http.ResponseWriter, r *http.Request) (string, *sql.DB, bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return "", nil, false
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return "", nil, false // Response already sent
+	}
+
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return "", nil, false
+	}
+	return userID, db, true
+}
+
+// handleListCollectionItems lists the items of a typed collection by id,
+// ?limit= overriding its page size and ?cursor= continuing from the
+// next_cursor of the previous page
+// Route: GET /{cenvID}/collections/{name}/items
+func (s *Server) handleListCollectionItems(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	items, nextCursor, err := document.ListCollectionItems(db, r.PathValue("name"), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	response := map[string]interface{}{
+		"items": items,
+		"count": len(items),
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleGetCollectionItem returns an item of a typed collection
+// Route: GET /{cenvID}/collections/{name}/items/{itemID}
+func (s *Server) handleGetCollectionItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	item, err := document.GetCollectionItem(db, r.PathValue("name"), r.PathValue("itemID"))
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleCreateCollectionItem stores the JSON request body as a new item of a
+// typed collection, under a generated id or the one given by ?id=
+// Route: POST /{cenvID}/collections/{name}/items
+func (s *Server) handleCreateCollectionItem(w http.ResponseWriter, r *http.Request) {
+	userID, db, ok := s.authorizeCollectionWrite(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := readItemData(w, r, db)
+	if !ok {
+		return
+	}
+
+	item, err := document.CreateCollectionItem(db, r.PathValue("name"), r.URL.Query().Get("id"), data, userID)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// handlePutCollectionItem replaces an item of a typed collection with the
+// JSON request body
+// Route: PUT /{cenvID}/collections/{name}/items/{itemID}
+func (s *Server) handlePutCollectionItem(w http.ResponseWriter, r *http.Request) {
+	userID, db, ok := s.authorizeCollectionWrite(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := readItemData(w, r, db)
+	if !ok {
+		return
+	}
+
+	item, err := document.UpdateCollectionItem(db, r.PathValue("name"), r.PathValue("itemID"), data, userID)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleDeleteCollectionItem deletes an item of a typed collection
+// Route: DELETE /{cenvID}/collections/{name}/items/{itemID}
+func (s *Server) handleDeleteCollectionItem(w http.ResponseWriter, r *http.Request) {
+	userID, db, ok := s.authorizeCollectionWrite(w, r)
+	if !ok {
+		return
+	}
+
+	if err := document.DeleteCollectionItem(db, r.PathValue("name"), r.PathValue("itemID"), userID); err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": true,
+	})
+}
+
+// readItemData reads a collection item from the request body, limited by
+// 'max_document_size_mb'
+func readItemData(w http.ResponseWriter, r *http.Request, db *sql.DB) ([]byte, bool) {
+	maxBytes := int64(config.GetInt(db, document.MaxDocumentSizeConfigKey, 10)) << 20
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "item exceeds the document size limit",
+		})
+		return nil, false
+	}
+	if !json.Valid(data) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body: item must be JSON",
+		})
+		return nil, false
+	}
+	return data, true
+}
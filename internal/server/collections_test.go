@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestCollectionAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("GET /{cenvID}/collections", srv.handleListCollections)
+	mux.HandleFunc("GET /{cenvID}/collections/{name}", srv.handleRunCollection)
+	mux.HandleFunc("PUT /{cenvID}/collections/{name}", srv.handlePutCollection)
+	mux.HandleFunc("DELETE /{cenvID}/collections/{name}", srv.handleDeleteCollection)
+	mux.HandleFunc("GET /{cenvID}/pages/{path...}", srv.handleRenderPage)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for _, id := range []string{"posts/a", "posts/b", "posts/c"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "content of " + id, "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/b/tags", token, map[string]interface{}{"tags": []string{"featured"}})
+	doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/c/tags", token, map[string]interface{}{"tags": []string{"featured"}})
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/collections/featured", token, map[string]interface{}{"prefix": "posts/", "sort": "sideways"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid sort, got %d", w.Code)
+	}
+	w := doJSON(t, mux, "PUT", "/"+cenvID+"/collections/featured", token, map[string]interface{}{
+		"prefix": "posts/", "tags": []string{"featured"}, "sort": "-id", "page_size": 1,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected collection to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+
+	run := func(query string) (ids []string, next string) {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/collections/featured"+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected collection to run, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Results    []document.SearchResult `json:"results"`
+			NextCursor string                  `json:"next_cursor"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		for _, r := range resp.Results {
+			ids = append(ids, r.ID)
+		}
+		return ids, resp.NextCursor
+	}
+	ids, next := run("")
+	if len(ids) != 1 || ids[0] != "posts/c" || next == "" {
+		t.Fatalf("Unexpected first page: %v %q", ids, next)
+	}
+	if ids, _ := run("?cursor=" + next); len(ids) != 1 || ids[0] != "posts/b" {
+		t.Errorf("Unexpected second page: %v", ids)
+	}
+	if ids, _ := run("?limit=10"); len(ids) != 2 {
+		t.Errorf("Expected limit to override the page size, got %v", ids)
+	}
+
+	// Template documents read collections by name
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id":           "templates/pages/featured.html",
+		"content":      "{% for post in collections.featured %}{{ post.id }};{% endfor %}{{ collections.missing|length }}",
+		"content_type": "text/html+jinja",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	page := httptest.NewRecorder()
+	mux.ServeHTTP(page, httptest.NewRequest("GET", "/"+cenvID+"/pages/featured", nil))
+	if page.Code != http.StatusOK || page.Body.String() != "posts/c;0" {
+		t.Errorf("Unexpected page: %d %q", page.Code, page.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/collections", token, nil)
+	var list struct {
+		Collections []document.Collection `json:"collections"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Collections) != 1 || list.Collections[0].Sort != "-id" {
+		t.Errorf("Unexpected collections: %+v", list.Collections)
+	}
+
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/collections/featured", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected delete to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/collections/featured", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted collection, got %d", w.Code)
+	}
+}
+
+func TestTypedCollectionAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/collections/{name}", srv.handlePutCollection)
+	mux.HandleFunc("GET /{cenvID}/collections/{name}/items", srv.handleListCollectionItems)
+	mux.HandleFunc("POST /{cenvID}/collections/{name}/items", srv.handleCreateCollectionItem)
+	mux.HandleFunc("GET /{cenvID}/collections/{name}/items/{itemID}", srv.handleGetCollectionItem)
+	mux.HandleFunc("PUT /{cenvID}/collections/{name}/items/{itemID}", srv.handlePutCollectionItem)
+	mux.HandleFunc("DELETE /{cenvID}/collections/{name}/items/{itemID}", srv.handleDeleteCollectionItem)
+
+	cenvID, token := setupTestCenv(t, mux)
+	base := "/" + cenvID + "/collections/books"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id":           "schemas/book",
+		"content":      `{"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}}}`,
+		"content_type": "application/json",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "POST", base+"/items", token, map[string]string{"title": "x"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before the collection exists, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", base, token, map[string]string{"schema_id": "schemas/book"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected typed collection to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "POST", base+"/items", token, map[string]int{"pages": 100})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an item not matching the schema, got %d", w.Code)
+	}
+	var invalid struct {
+		Details []document.ValidationError `json:"details"`
+	}
+	json.NewDecoder(w.Body).Decode(&invalid)
+	if len(invalid.Details) == 0 {
+		t.Error("Expected schema validation details")
+	}
+
+	w = doJSON(t, mux, "POST", base+"/items?id=dune", token, map[string]string{"title": "Dune"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "POST", base+"/items?id=dune", token, map[string]string{"title": "Dune"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing item, got %d", w.Code)
+	}
+
+	w = doJSON(t, mux, "PUT", base+"/items/dune", token, map[string]string{"title": "Dune Messiah"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "GET", base+"/items/dune", token, nil)
+	var item document.CollectionItem
+	json.NewDecoder(w.Body).Decode(&item)
+	var data map[string]string
+	json.Unmarshal(item.Data, &data)
+	if w.Code != http.StatusOK || data["title"] != "Dune Messiah" || item.Version != 2 {
+		t.Errorf("Unexpected item: %d %+v", w.Code, item)
+	}
+
+	w = doJSON(t, mux, "GET", base+"/items", token, nil)
+	var list struct {
+		Items []document.CollectionItem `json:"items"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Items) != 1 || list.Items[0].ID != "dune" {
+		t.Errorf("Unexpected items: %+v", list.Items)
+	}
+
+	if w := doJSON(t, mux, "DELETE", base+"/items/dune", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected delete to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "GET", base+"/items/dune", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted item, got %d", w.Code)
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+)
+
+// complianceCaptureConfigKey turns on the compliance log for a cenv
+const complianceCaptureConfigKey = "compliance_capture"
+
+// redacted replaces credentials in compliance records
+const redacted = "[REDACTED]"
+
+// complianceRoutes are the routes outside /admin/ that change who can do
+// what: issuing scoped tokens, approving devices, revoking sessions and
+// changing passwords
+var complianceRoutes = map[string]bool{
+	"POST /{cenvID}/token":              true,
+	"POST /{cenvID}/device":             true,
+	"DELETE /{cenvID}/me/sessions/{id}": true,
+	"POST /{cenvID}/me/password":        true,
+	"POST /{cenvID}/password/reset":     true,
+}
+
+// credentialHeaders are recorded as present but never with their value
+var credentialHeaders = []string{"Authorization", "Cookie", "X-WCE-Signature"}
+
+// sensitiveFields are substrings of JSON field names whose values are
+// redacted in compliance records
+var sensitiveFields = []string{"password", "passwd", "token", "secret", "credential", "signature", "api_key", "apikey", "private_key"}
+
+// complianceCapture buffers the start of a response and its status for the
+// compliance log while passing it through
+type complianceCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	size   int
+}
+
+func (c *complianceCapture) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *complianceCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if room := maxCaptureBodySize - c.body.Len(); room > 0 {
+		c.body.Write(b[:min(room, len(b))])
+	}
+	c.size += len(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *complianceCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// isComplianceRoute reports whether requests matching a route pattern are
+// recorded in the compliance log: changes under /admin/ and complianceRoutes
+func isComplianceRoute(method, route string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return strings.HasPrefix(route, method+" /{cenvID}/admin/") || complianceRoutes[route]
+}
+
+// complianceMiddleware records a redacted summary of each admin or
+// permission-changing request, and its response, in the hash-chained
+// compliance log of cenvs with compliance_capture on. It is checked before
+// and after the request, so turning capture on or off is itself recorded.
+// routes resolves the route pattern a request matched.
+func (s *Server) complianceMiddleware(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cenvID, _, ok := cenv.ParsePath(r.URL.Path)
+		if !ok || !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, route := routes.Handler(r)
+		if !isComplianceRoute(r.Method, route) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		db, err := s.cenvManager.GetConnection(cenvID)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		enabled := config.GetBool(db, complianceCaptureConfigKey, false)
+		body := bufferRequestBody(r)
+		capture := &complianceCapture{ResponseWriter: w}
+		next.ServeHTTP(capture, r)
+
+		if !enabled && !config.GetBool(db, complianceCaptureConfigKey, false) {
+			return
+		}
+		s.recordCompliance(db, r, cenvID, route, body, capture)
+	})
+}
+
+// recordCompliance appends the record of a finished request; a failure is
+// logged, as the response has already been sent
+func (s *Server) recordCompliance(db *sql.DB, r *http.Request, cenvID, route string, body []byte, capture *complianceCapture) {
+	record := &audit.Capture{
+		RequestID: requestID(r),
+		Method:    r.Method,
+		Route:     route,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Status:    capture.status,
+		IPAddress: r.RemoteAddr,
+	}
+	if record.Status == 0 {
+		record.Status = http.StatusOK
+	}
+
+	// The user the token names; the status shows whether it was accepted
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := s.jwtManager.ValidateToken(token); err == nil && claims.CenvID == cenvID {
+			record.UserID, record.Username = claims.UserID, claims.Username
+		}
+	}
+
+	// Secret values are never recorded, not even redacted JSON around them
+	hideBody := strings.Contains(route, "/admin/secrets/")
+
+	headers := make(map[string]string)
+	for _, name := range capturedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	for _, name := range credentialHeaders {
+		if r.Header.Get(name) != "" {
+			headers[name] = redacted
+		}
+	}
+	size := len(body)
+	if r.ContentLength > int64(size) {
+		size = int(r.ContentLength)
+	}
+	record.Request, _ = json.Marshal(map[string]interface{}{
+		"headers": headers,
+		"body":    summarizeBody(body, r.Header.Get("Content-Type"), size, hideBody),
+	})
+	record.Response, _ = json.Marshal(summarizeBody(capture.body.Bytes(), capture.Header().Get("Content-Type"), capture.size, false))
+
+	if err := audit.AppendCapture(db, record); err != nil {
+		log.Printf("Failed to record %s %s in the compliance log of cenv %s: %v", r.Method, r.URL.Path, cenvID, err)
+	}
+}
+
+// summarizeBody describes a request or response body for the compliance
+// log: its type and size, with JSON content included after redaction.
+// Other content, and JSON over the capture limit, is identified by hash.
+func summarizeBody(body []byte, contentType string, size int, hide bool) map[string]interface{} {
+	summary := map[string]interface{}{"size": size}
+	if contentType != "" {
+		summary["content_type"] = contentType
+	}
+	if size == 0 || hide {
+		return summary
+	}
+
+	var content interface{}
+	if size == len(body) && json.Unmarshal(body, &content) == nil {
+		summary["json"] = redactJSON(content)
+		return summary
+	}
+	sum := sha256.Sum256(body)
+	summary["sha256"] = hex.EncodeToString(sum[:])
+	if size > len(body) {
+		summary["truncated"] = true // The hash covers the captured start only
+	}
+	return summary
+}
+
+// redactJSON replaces the values of sensitive fields at any depth
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSensitiveField(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return value
+}
+
+func isSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range sensitiveFields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleExportCompliance exports the compliance log oldest first, with the
+// result of verifying its hash chain (admin/owner only). Pass the response's
+// next as after to page through it. Each export is recorded in the audit log.
+// Route: GET /{cenvID}/admin/compliance/export?after=&limit=
+func (s *Server) handleExportCompliance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "export the compliance log")
+	if err != nil {
+		return // Response already sent
+	}
+
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	records, err := audit.ListCaptures(db, after, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to export compliance log"})
+		return
+	}
+	chain, err := audit.VerifyChain(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to verify compliance log"})
+		return
+	}
+
+	next := after
+	if len(records) > 0 {
+		next = records[len(records)-1].ID
+	}
+	details, _ := json.Marshal(map[string]interface{}{"after": after, "next": next, "count": len(records)})
+	if err := audit.Record(db, audit.Entry{
+		UserID:       userID,
+		Action:       "export_compliance_log",
+		ResourceType: "compliance_log",
+		Details:      details,
+		IPAddress:    r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to audit compliance export in cenv %s: %v", cenvID, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": config.GetBool(db, complianceCaptureConfigKey, false),
+		"records": records,
+		"count":   len(records),
+		"next":    next,
+		"chain":   chain,
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestComplianceCapture(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/admin/users", srv.handleListUsers)
+	mux.HandleFunc("PUT /{cenvID}/admin/users/{username}", srv.handlePutUser)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("PUT /{cenvID}/admin/secrets/{name}", srv.handleSetSecret)
+	mux.HandleFunc("GET /{cenvID}/admin/compliance/export", srv.handleExportCompliance)
+	mux.HandleFunc("GET /{cenvID}/admin/audit", srv.handleListAudit)
+	handler := srv.complianceMiddleware(mux, mux)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	// send makes a request through the middleware
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		routed := http.NewServeMux()
+		routed.Handle("/", handler)
+		return doJSON(t, routed, method, "/"+cenvID+path, token, body)
+	}
+
+	export := func() (records []audit.Capture, chain audit.ChainStatus) {
+		t.Helper()
+		w := send("GET", "/admin/compliance/export", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to export: %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Records []audit.Capture   `json:"records"`
+			Chain   audit.ChainStatus `json:"chain"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Records, resp.Chain
+	}
+
+	// Off by default
+	if w := send("PUT", "/admin/users/bob", map[string]string{"role": "editor", "password": "bobpass123"}); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create user: %d %s", w.Code, w.Body.String())
+	}
+	if records, _ := export(); len(records) != 0 {
+		t.Fatalf("Expected nothing recorded while off, got %d records", len(records))
+	}
+
+	// Turning capture on is the first record
+	if w := send("PUT", "/admin/config/compliance_capture", map[string]string{"value": "true"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to enable capture: %d %s", w.Code, w.Body.String())
+	}
+	send("PUT", "/admin/users/bob", map[string]string{"role": "admin", "password": "newpass456"})
+	send("PUT", "/admin/secrets/api", map[string]string{"value": "sk-live-123"})
+	send("GET", "/admin/users", nil) // Reads are not recorded
+
+	records, chain := export()
+	if len(records) != 3 || !chain.Valid || chain.Records != 3 || chain.HeadHash != records[2].Hash {
+		t.Fatalf("Expected 3 records in a valid chain, got %d, %+v", len(records), chain)
+	}
+
+	user := records[1]
+	if user.Route != "PUT /{cenvID}/admin/users/{username}" || user.Status != http.StatusOK ||
+		user.Username != "admin" || user.RequestID == "" {
+		t.Errorf("Unexpected record: %+v", user)
+	}
+	var request struct {
+		Headers map[string]string `json:"headers"`
+		Body    struct {
+			JSON map[string]string `json:"json"`
+		} `json:"body"`
+	}
+	json.Unmarshal(user.Request, &request)
+	if request.Headers["Authorization"] != "[REDACTED]" || request.Headers["X-WCE-Signature"] != "[REDACTED]" ||
+		request.Body.JSON["role"] != "admin" || request.Body.JSON["password"] != "[REDACTED]" {
+		t.Errorf("Expected a redacted request, got %s", user.Request)
+	}
+	if !strings.Contains(string(user.Response), `"role":"admin"`) {
+		t.Errorf("Expected the response summarized, got %s", user.Response)
+	}
+
+	for _, record := range records {
+		for _, raw := range []json.RawMessage{record.Request, record.Response} {
+			if strings.Contains(string(raw), "newpass456") || strings.Contains(string(raw), "sk-live-123") ||
+				strings.Contains(string(raw), token) {
+				t.Errorf("Credential leaked into record %d: %s", record.ID, raw)
+			}
+		}
+	}
+
+	// Exports are audited, and only admins may export
+	w := send("GET", "/admin/audit?action=export_compliance_log", nil)
+	var audited struct {
+		Count int `json:"count"`
+	}
+	json.NewDecoder(w.Body).Decode(&audited)
+	if audited.Count != 2 {
+		t.Errorf("Expected 2 exports in the audit log, got %d", audited.Count)
+	}
+
+	send("PUT", "/admin/users/carol", map[string]string{"role": "viewer", "password": "carolpass123"})
+	carol := loginAs(t, mux, cenvID, "carol", "carolpass123")
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/compliance/export", carol, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a viewer to be refused, got %d", w.Code)
+	}
+}
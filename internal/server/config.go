@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/retention"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// configValidators check values for config keys with a structured format
+var configValidators = map[string]func(string) error{
+	"editor_endpoint_capabilities": func(value string) error {
+		_, err := starlark_pkg.DecodeCapabilities(value)
+		return err
+	},
+	"endpoint_log_level": func(value string) error {
+		if !starlark_pkg.IsValidLogLevel(value) {
+			return fmt.Errorf("must be 'debug', 'info', 'warn', or 'error'")
+		}
+		return nil
+	},
+	"require_signed_admin_requests":  validateBool,
+	"bind_sessions_to_client":        validateBool,
+	complianceCaptureConfigKey:       validateBool,
+	"alert_on_new_country":           validateBool,
+	"alert_on_permission_escalation": validateBool,
+	"alert_failed_logins": func(value string) error {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("must be a non-negative integer (0 disables failed login alerts)")
+		}
+		return nil
+	},
+	"alert_failed_login_window_minutes": func(value string) error {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			return fmt.Errorf("must be a positive integer")
+		}
+		return nil
+	},
+	"alert_webhook_url": func(value string) error {
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("must be an absolute http or https URL, or empty to disable")
+		}
+		return nil
+	},
+	retention.AuditDaysConfigKey:     validateRetentionDays,
+	retention.SessionDaysConfigKey:   validateRetentionDays,
+	retention.AnalyticsDaysConfigKey: validateRetentionDays,
+	"expired_documents": func(value string) error {
+		if value != "delete" && value != "archive" {
+			return fmt.Errorf("must be 'delete' or 'archive'")
+		}
+		return nil
+	},
+	document.CompressionConfigKey: func(value string) error {
+		if !document.IsValidCompression(value) {
+			return fmt.Errorf("must be 'off' or 'gzip'")
+		}
+		return nil
+	},
+	document.CompressionThresholdConfigKey: func(value string) error {
+		if kb, err := strconv.Atoi(value); err != nil || kb < 0 {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		return nil
+	},
+	document.MaxDocumentSizeConfigKey: func(value string) error {
+		if mb, err := strconv.Atoi(value); err != nil || mb < 1 {
+			return fmt.Errorf("must be a positive integer")
+		}
+		return nil
+	},
+	document.StorageQuotaConfigKey: func(value string) error {
+		if mb, err := strconv.Atoi(value); err != nil || mb < 0 {
+			return fmt.Errorf("must be a non-negative integer (0 disables the quota)")
+		}
+		return nil
+	},
+	document.SearchTokenizerConfigKey: func(value string) error {
+		_, err := document.ParseTokenizer(value)
+		return err
+	},
+	"slow_query_ms": func(value string) error {
+		if ms, err := strconv.Atoi(value); err != nil || ms < 0 {
+			return fmt.Errorf("must be a non-negative integer (0 disables the slow query log)")
+		}
+		return nil
+	},
+	siteURLConfigKey: func(value string) error {
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("must be an absolute http or https URL without query, or empty for the cenv's pages")
+		}
+		return nil
+	},
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("must be 'true' or 'false'")
+	}
+	return nil
+}
+
+func validateRetentionDays(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 0 {
+		return fmt.Errorf("must be a non-negative number of days (0 keeps data forever)")
+	}
+	return nil
+}
+
+// handleListConfig lists cenv configuration values (admin/owner only)
+func (s *Server) handleListConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view configuration")
+	if err != nil {
+		return // Response already sent
+	}
+
+	entries, err := config.List(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list configuration",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"config": entries,
+	})
+}
+
+// handleSetConfig sets a cenv configuration value (admin/owner only)
+func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	key := r.PathValue("key")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "change configuration")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "set_config", "config", key); err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Value string `json:"value"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if validate, ok := configValidators[key]; ok {
+		if err := validate(req.Value); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": key + ": " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := config.Set(db, key, req.Value, userID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to set configuration",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"key":   key,
+		"value": req.Value,
+	})
+}
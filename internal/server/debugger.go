@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+	"github.com/thetanil/wce/internal/websocket"
+)
+
+// debugSessionTimeout bounds how long a paused debug session may hold resources
+const debugSessionTimeout = 10 * time.Minute
+
+// debugCommand is a message sent by the debugger client.
+//
+// The first message must be "start"; afterwards the client sends "step",
+// "continue" or "stop" while execution is paused, and "breakpoints" at any time.
+type debugCommand struct {
+	Command     string            `json:"command"`
+	Script      string            `json:"script,omitempty"` // Optional draft script
+	Breakpoints []int32           `json:"breakpoints,omitempty"`
+	Step        bool              `json:"step,omitempty"` // Pause on the first line
+	Request     *simulatedRequest `json:"request,omitempty"`
+}
+
+// simulatedRequest describes a request passed to handle_request outside of
+// normal routing, as used by the debugger and admin test runs
+type simulatedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// debugEvent is a message sent to the debugger client
+type debugEvent struct {
+	Event string `json:"event"` // "paused", "log", "breakpoints", "result", "error"
+	*starlark_pkg.DebugEvent
+	Log         *starlark_pkg.LogEntry `json:"log,omitempty"`
+	Breakpoints []int32                `json:"breakpoints,omitempty"`
+	Status      int                    `json:"status,omitempty"`
+	Headers     map[string]string      `json:"headers,omitempty"`
+	Body        interface{}            `json:"body,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// debugCapabilities are the capabilities a debug run may keep. The debugger is
+// a GET that browsers cannot sign, so runs are read-only; the signed
+// POST .../test route runs scripts with their writes.
+var debugCapabilities = starlark_pkg.Capabilities{starlark_pkg.CapabilityRemote: true}
+
+// handleDebugEndpoint runs an endpoint script under the debugger over a WebSocket (admin/owner only).
+// The script runs against the live cenv database without the capabilities that write to it.
+// Browsers cannot set headers on WebSocket requests, so the token may be passed as ?token=.
+// Route: GET /{cenvID}/admin/endpoints/{endpointID}/debug
+func (s *Server) handleDebugEndpoint(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	endpointID := r.PathValue("endpointID")
+
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Header.Get("Authorization") == "" && r.URL.Query().Get("token") != "" {
+		r.Header.Set("Authorization", "Bearer "+r.URL.Query().Get("token"))
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "debug endpoints")
+	if err != nil {
+		return // Response already sent
+	}
+
+	var endpoint Endpoint
+	var capabilities string
+	err = db.QueryRow(`
+		SELECT id, path, method, script, capabilities
+		FROM _wce_endpoints
+		WHERE id = ?
+	`, endpointID).Scan(&endpoint.ID, &endpoint.Path, &endpoint.Method, &endpoint.Script, &capabilities)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	endpoint.Capabilities = decodeCapabilities(capabilities)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return // Response already sent
+	}
+	defer conn.Close()
+
+	var start debugCommand
+	if err := conn.ReadJSON(&start); err != nil || start.Command != "start" {
+		conn.WriteJSON(debugEvent{Event: "error", Error: "first message must be a start command"})
+		return
+	}
+
+	script := endpoint.Script
+	if start.Script != "" {
+		script = start.Script
+	}
+
+	req, err := buildSimulatedRequest(cenvID, &endpoint, start.Request)
+	if err != nil {
+		conn.WriteJSON(debugEvent{Event: "error", Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	actions := make(chan starlark_pkg.DebugAction)
+	finished := make(chan struct{})
+	disconnected := make(chan struct{})
+	var paused atomic.Bool
+
+	debugger := starlark_pkg.NewDebugger(start.Breakpoints, start.Step, func(event *starlark_pkg.DebugEvent) starlark_pkg.DebugAction {
+		paused.Store(true)
+		defer paused.Store(false)
+
+		if err := conn.WriteJSON(debugEvent{Event: "paused", DebugEvent: event}); err != nil {
+			return starlark_pkg.DebugStop
+		}
+
+		select {
+		case action := <-actions:
+			return action
+		case <-disconnected:
+			return starlark_pkg.DebugStop
+		case <-time.After(debugSessionTimeout):
+			return starlark_pkg.DebugStop
+		}
+	})
+
+	// Read client commands while the script runs
+	go func() {
+		defer close(disconnected)
+		for {
+			var cmd debugCommand
+			if err := conn.ReadJSON(&cmd); err != nil {
+				return
+			}
+
+			var action starlark_pkg.DebugAction
+			switch cmd.Command {
+			case "step":
+				action = starlark_pkg.DebugStep
+			case "continue":
+				action = starlark_pkg.DebugContinue
+			case "stop":
+				action = starlark_pkg.DebugStop
+			case "breakpoints":
+				debugger.SetBreakpoints(cmd.Breakpoints)
+				conn.WriteJSON(debugEvent{Event: "breakpoints", Breakpoints: debugger.Breakpoints()})
+				continue
+			default:
+				conn.WriteJSON(debugEvent{Event: "error", Error: "unknown command: " + cmd.Command})
+				continue
+			}
+
+			if !paused.Load() {
+				conn.WriteJSON(debugEvent{Event: "error", Error: "script is not paused"})
+				continue
+			}
+
+			select {
+			case actions <- action:
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	execCtx := &starlark_pkg.ExecutionContext{
+		DB:           db,
+		UserID:       userID,
+		Request:      req,
+		Timeout:      debugSessionTimeout,
+		Debugger:     debugger,
+		Capabilities: endpoint.capabilitySet().Intersect(debugCapabilities),
+		Remote:       s.newRemoteAccess(cenvID, db),
+		Log: func(entry starlark_pkg.LogEntry) error {
+			return conn.WriteJSON(debugEvent{Event: "log", Log: &entry})
+		},
+	}
+
+	log.Printf("Debug session started for endpoint %d by %s", endpoint.ID, userID)
+
+	result, err := starlark_pkg.Execute(context.Background(), script, execCtx)
+	close(finished)
+
+	if err != nil {
+		conn.WriteJSON(debugEvent{Event: "error", Error: err.Error()})
+		return
+	}
+
+	conn.WriteJSON(debugEvent{
+		Event:   "result",
+		Status:  result.StatusCode,
+		Headers: result.Headers,
+		Body:    result.Body,
+	})
+}
+
+// buildSimulatedRequest constructs the request passed to a script being debugged
+// or test-run, defaulting to the endpoint's own method and path
+func buildSimulatedRequest(cenvID string, endpoint *Endpoint, spec *simulatedRequest) (*http.Request, error) {
+	if spec == nil {
+		spec = &simulatedRequest{}
+	}
+
+	method := spec.Method
+	if method == "" {
+		method = endpoint.Method
+	}
+	if method == "*" {
+		method = http.MethodGet
+	}
+
+	path := spec.Path
+	if path == "" {
+		path = endpoint.Path
+	}
+
+	target := "/" + cenvID + "/star" + path
+	if spec.Query != "" {
+		target += "?" + spec.Query
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(spec.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range spec.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return req, nil
+}
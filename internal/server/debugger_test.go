@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/websocket"
+)
+
+func TestDebugEndpoint(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints", srv.handleListEndpoints)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}/debug", srv.handleDebugEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	script := "def handle_request(req):\n" +
+		"    total = 0\n" +
+		"    for i in range(3):\n" +
+		"        total = total + i\n" +
+		"    return {\"status\": 200, \"body\": str(total)}"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path":         "/sum",
+		"method":       "GET",
+		"script":       script,
+		"capabilities": []string{"db_write"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/endpoints", token, nil)
+	var endpoints []Endpoint
+	json.NewDecoder(w.Body).Decode(&endpoints)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	debugURL := fmt.Sprintf("ws%s/%s/admin/endpoints/%d/debug?token=%s",
+		strings.TrimPrefix(ts.URL, "http"), cenvID, endpoints[0].ID, token)
+
+	t.Run("RequiresAuth", func(t *testing.T) {
+		url := fmt.Sprintf("ws%s/%s/admin/endpoints/%d/debug", strings.TrimPrefix(ts.URL, "http"), cenvID, endpoints[0].ID)
+		if _, err := websocket.Dial(url, nil); err == nil {
+			t.Error("Expected handshake to fail without a token")
+		}
+	})
+
+	t.Run("BreakpointAndStop", func(t *testing.T) {
+		conn, err := websocket.Dial(debugURL, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(map[string]interface{}{"command": "start", "breakpoints": []int{4}})
+
+		var event map[string]interface{}
+		for _, want := range []string{"0", "1"} {
+			if err := conn.ReadJSON(&event); err != nil {
+				t.Fatalf("ReadJSON failed: %v", err)
+			}
+			if event["event"] != "paused" || event["line"] != float64(4) {
+				t.Fatalf("Expected pause at line 4, got %v", event)
+			}
+			locals := event["locals"].(map[string]interface{})
+			if locals["i"] != want {
+				t.Errorf("Expected i=%s, got locals %v", want, locals)
+			}
+			conn.WriteJSON(map[string]string{"command": "continue"})
+		}
+
+		conn.ReadJSON(&event) // Third iteration
+		conn.WriteJSON(map[string]string{"command": "stop"})
+
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		if event["event"] != "error" || !strings.Contains(event["error"].(string), "stopped by debugger") {
+			t.Errorf("Expected stopped error, got %v", event)
+		}
+	})
+
+	t.Run("NoWrites", func(t *testing.T) {
+		conn, err := websocket.Dial(debugURL, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(map[string]interface{}{
+			"command": "start",
+			"script": "def handle_request(req):\n" +
+				"    db.execute(\"CREATE TABLE debug_probe (x)\")\n" +
+				"    return {\"status\": 200}",
+		})
+
+		var event map[string]interface{}
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		if event["event"] != "error" || !strings.Contains(event["error"].(string), "db_write") {
+			t.Errorf("Expected the write to be refused, got %v", event)
+		}
+	})
+
+	t.Run("RunToCompletion", func(t *testing.T) {
+		conn, err := websocket.Dial(debugURL, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(map[string]interface{}{"command": "start"})
+
+		var event map[string]interface{}
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		if event["event"] != "result" || event["status"] != float64(200) || event["body"] != "3" {
+			t.Errorf("Unexpected result: %v", event)
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// DeviceCodeRequest starts a device authorization. Scope optionally limits
+// the token the device receives.
+type DeviceCodeRequest struct {
+	Scope string `json:"scope"`
+}
+
+// DeviceCodeResponse follows RFC 8628 section 3.2
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceTokenRequest polls for the token of a device authorization
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// oauthError writes an OAuth error response
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	w.WriteHeader(status)
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleDeviceCode starts the device flow for a CLI or other input-limited
+// client. No authentication is needed; the user approves in a browser.
+// Route: POST /{cenvID}/device/code
+func (s *Server) handleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+
+	var req DeviceCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return
+	}
+	if req.Scope != "" {
+		if _, err := auth.ParseScopes(req.Scope); err != nil {
+			oauthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+			return
+		}
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to connect to database",
+		})
+		return
+	}
+
+	code, err := auth.CreateDeviceCode(db, req.Scope)
+	if err != nil {
+		log.Printf("Failed to create device code in cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to create device code",
+		})
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	verificationURI := scheme + "://" + r.Host + "/" + cenvID + "/device"
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DeviceCodeResponse{
+		DeviceCode:              code.DeviceCode,
+		UserCode:                code.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + code.UserCode,
+		ExpiresIn:               int64(auth.DeviceCodeLifetime / time.Second),
+		Interval:                int64(auth.DevicePollInterval / time.Second),
+	})
+}
+
+// handleDeviceToken is polled by the device until the user approves or
+// denies it. Pending states are reported with the RFC 8628 error codes.
+// Route: POST /{cenvID}/device/token
+func (s *Server) handleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+
+	var req DeviceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeviceCode == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "device_code is required")
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to connect to database",
+		})
+		return
+	}
+
+	userID, scope, err := auth.PollDeviceCode(db, req.DeviceCode)
+	switch {
+	case errors.Is(err, auth.ErrAuthorizationPending), errors.Is(err, auth.ErrSlowDown),
+		errors.Is(err, auth.ErrAccessDenied), errors.Is(err, auth.ErrExpiredToken):
+		oauthError(w, http.StatusBadRequest, err.Error(), "")
+		return
+	case err != nil:
+		log.Printf("Failed to poll device code in cenv %s: %v", cenvID, err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	user, err := auth.GetUserByID(db, userID)
+	if err != nil || !user.Enabled {
+		oauthError(w, http.StatusBadRequest, "access_denied", "account disabled")
+		return
+	}
+
+	var scopes []string
+	if scope != "" {
+		scopes = strings.Fields(scope)
+	}
+	expiresIn := auth.DefaultSessionTimeout
+	token, err := s.issueToken(r, db, user.UserID, user.Username, cenvID, user.Role, scopes, expiresIn)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	log.Printf("Device authorized for user %s in cenv %s", user.Username, cenvID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expiresIn / time.Second),
+		Scope:       scope,
+	})
+}
+
+// devicePage is the browser page where a user confirms a device code
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize device</title></head>
+<body>
+<h1>Authorize device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if not .Done}}
+<form method="post">
+<p><label>Code <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+{{if .Scope}}<p>The device asks for: {{.Scope}}</p>{{end}}
+<p><label>Username <input name="username" autocomplete="username"></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password"></label></p>
+<p><button name="action" value="approve">Approve</button> <button name="action" value="deny">Deny</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// devicePageData fills devicePage
+type devicePageData struct {
+	UserCode string
+	Scope    string
+	Message  string
+	Done     bool
+}
+
+// renderDevicePage writes the confirmation page. It may not be framed, so
+// another site cannot trick a user into approving a device.
+func renderDevicePage(w http.ResponseWriter, status int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	devicePage.Execute(w, data)
+}
+
+// handleDevicePage shows the device confirmation form
+// Route: GET /{cenvID}/device
+func (s *Server) handleDevicePage(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := devicePageData{UserCode: auth.NormalizeUserCode(r.URL.Query().Get("user_code"))}
+	if data.UserCode != "" {
+		if db, err := s.cenvManager.GetConnection(cenvID); err == nil {
+			if device, err := auth.GetPendingDevice(db, data.UserCode); err == nil {
+				data.Scope = device.Scope
+			}
+		}
+	}
+
+	renderDevicePage(w, http.StatusOK, data)
+}
+
+// handleDeviceConfirm approves a device code with the user's credentials, or
+// denies it. Denying needs only the code.
+// Route: POST /{cenvID}/device
+func (s *Server) handleDeviceConfirm(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		renderDevicePage(w, http.StatusInternalServerError, devicePageData{Message: "Failed to connect to database."})
+		return
+	}
+
+	userCode := auth.NormalizeUserCode(r.PostFormValue("user_code"))
+	data := devicePageData{UserCode: userCode}
+
+	if r.PostFormValue("action") == "deny" {
+		if err := auth.DenyDeviceCode(db, userCode); err != nil {
+			data.Message = "Unknown or expired code."
+			renderDevicePage(w, http.StatusBadRequest, data)
+			return
+		}
+		renderDevicePage(w, http.StatusOK, devicePageData{Message: "The device was denied access.", Done: true})
+		return
+	}
+
+	device, err := auth.GetPendingDevice(db, userCode)
+	if err != nil {
+		data.Message = "Unknown or expired code."
+		renderDevicePage(w, http.StatusBadRequest, data)
+		return
+	}
+	data.Scope = device.Scope
+
+	// Same checks as login, without revealing whether the user exists
+	user, err := auth.GetUserByUsername(db, r.PostFormValue("username"))
+	if err != nil || !user.Enabled || auth.VerifyPassword(r.PostFormValue("password"), user.PasswordHash) != nil {
+		data.Message = "Invalid credentials."
+		renderDevicePage(w, http.StatusUnauthorized, data)
+		return
+	}
+	if s.awaitingEmailVerification(db, cenvID, user) {
+		data.Message = "Verify your email address first, using the link sent to it."
+		renderDevicePage(w, http.StatusForbidden, data)
+		return
+	}
+
+	if err := auth.ApproveDeviceCode(db, userCode, user.UserID); err != nil {
+		data.Message = "Unknown or expired code."
+		renderDevicePage(w, http.StatusBadRequest, data)
+		return
+	}
+
+	log.Printf("User %s approved device code %s in cenv %s", user.Username, userCode, cenvID)
+	renderDevicePage(w, http.StatusOK, devicePageData{Message: "Device approved. You can return to your terminal.", Done: true})
+}
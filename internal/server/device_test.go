@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestDeviceFlowAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/device/code", srv.handleDeviceCode)
+	mux.HandleFunc("POST /{cenvID}/device/token", srv.handleDeviceToken)
+	mux.HandleFunc("GET /{cenvID}/device", srv.handleDevicePage)
+	mux.HandleFunc("POST /{cenvID}/device", srv.handleDeviceConfirm)
+	mux.HandleFunc("GET /{cenvID}/admin/config", srv.handleListConfig)
+
+	cenvID, _ := setupTestCenv(t, mux)
+
+	confirm := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/"+cenvID+"/device", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	poll := func(deviceCode string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/device/token", "", map[string]string{"device_code": deviceCode})
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/device/code", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to start device flow: %d %s", w.Code, w.Body.String())
+	}
+	var code DeviceCodeResponse
+	json.Unmarshal(w.Body.Bytes(), &code)
+	if code.DeviceCode == "" || code.Interval != 5 || !strings.HasSuffix(code.VerificationURI, "/"+cenvID+"/device") ||
+		code.VerificationURIComplete != code.VerificationURI+"?user_code="+code.UserCode {
+		t.Errorf("Unexpected device code response: %s", w.Body.String())
+	}
+
+	if w, body := poll(code.DeviceCode); w.Code != http.StatusBadRequest || body["error"] != "authorization_pending" {
+		t.Errorf("Expected authorization_pending, got %d %v", w.Code, body)
+	}
+
+	page := httptest.NewRecorder()
+	mux.ServeHTTP(page, httptest.NewRequest("GET", "/"+cenvID+"/device?user_code="+code.UserCode, nil))
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), code.UserCode) || page.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("Unexpected confirmation page: %d %v", page.Code, page.Header())
+	}
+
+	if w := confirm(url.Values{"user_code": {code.UserCode}, "username": {"admin"}, "password": {"wrong"}, "action": {"approve"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected wrong password to be refused, got %d", w.Code)
+	}
+	if w := confirm(url.Values{"user_code": {code.UserCode}, "username": {"admin"}, "password": {"adminpass123"}, "action": {"approve"}}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to approve device: %d %s", w.Code, w.Body.String())
+	}
+
+	w, body := poll(code.DeviceCode)
+	if w.Code != http.StatusOK || body["token_type"] != "Bearer" {
+		t.Fatalf("Expected token, got %d %v", w.Code, body)
+	}
+	token, _ := body["access_token"].(string)
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/config", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected device token to authenticate, got %d", w.Code)
+	}
+	if w, body := poll(code.DeviceCode); w.Code != http.StatusBadRequest || body["error"] != "expired_token" {
+		t.Errorf("Expected used code to be refused, got %d %v", w.Code, body)
+	}
+
+	t.Run("Denied", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/device/code", "", map[string]string{"scope": "documents:read"})
+		var code DeviceCodeResponse
+		json.Unmarshal(w.Body.Bytes(), &code)
+
+		if w := confirm(url.Values{"user_code": {code.UserCode}, "action": {"deny"}}); w.Code != http.StatusOK {
+			t.Fatalf("Failed to deny device: %d", w.Code)
+		}
+		if w, body := poll(code.DeviceCode); w.Code != http.StatusBadRequest || body["error"] != "access_denied" {
+			t.Errorf("Expected access_denied, got %d %v", w.Code, body)
+		}
+	})
+
+	t.Run("InvalidScope", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/device/code", "", map[string]string{"scope": "everything"})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_scope") {
+			t.Errorf("Expected invalid_scope, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -1,14 +1,26 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/thetanil/wce/internal/authz"
 	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/diff"
 	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/hooks"
 )
 
 // handleCreateDocument creates a new document
@@ -39,11 +51,14 @@ func (s *Server) handleCreateDocument(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request
 	var req struct {
-		ID          string `json:"id"`
-		Content     string `json:"content"`
-		ContentType string `json:"content_type"`
-		IsBinary    bool   `json:"is_binary"`
-		Searchable  bool   `json:"searchable"`
+		ID          string          `json:"id"`
+		Content     string          `json:"content"`
+		ContentType string          `json:"content_type"`
+		IsBinary    bool            `json:"is_binary"`
+		Searchable  bool            `json:"searchable"`
+		Metadata    json.RawMessage `json:"metadata,omitempty"`
+		SchemaID    string          `json:"schema_id,omitempty"`
+		ExpiresAt   int64           `json:"expires_at,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -54,21 +69,54 @@ func (s *Server) handleCreateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create document
-	doc, err := document.CreateDocument(db, req.ID, req.Content, req.ContentType, userID, req.IsBinary, req.Searchable)
+	// Validate metadata up front so a bad object doesn't leave a half-created document
+	metadata, err := document.NormalizeMetadata(req.Metadata)
 	if err != nil {
-		// Check if error is due to duplicate
-		if strings.Contains(err.Error(), "already exists") {
-			w.WriteHeader(http.StatusConflict)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-		}
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	if req.ExpiresAt < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "expires_at must be a Unix timestamp, or 0 for no expiry",
+		})
+		return
+	}
+
+	// Create the document with its metadata and expiry in one transaction,
+	// so a failure part way leaves no document behind
+	var doc *document.Document
+	err = withTransaction(r, db, func(tx *sql.Tx) error {
+		var err error
+		if doc, err = document.CreateDocumentWithSchema(tx, req.ID, req.Content, req.ContentType, userID, req.SchemaID, req.IsBinary, req.Searchable); err != nil {
+			return err
+		}
+		if string(metadata) != "{}" {
+			if doc, err = document.SetDocumentMetadata(tx, doc.ID, metadata, userID); err != nil {
+				return err
+			}
+		}
+		if req.ExpiresAt != 0 {
+			if doc, err = document.SetDocumentExpiry(tx, doc.ID, req.ExpiresAt, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	// Binary uploads are held until the malware scanner clears them
+	s.queueDocumentScan(db, doc)
+	s.installSeedFixture(db, doc, userID, role)
+	s.runDocumentHooks(db, cenvID, hooks.EventDocumentCreated, doc.ID, userID)
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(doc)
 }
@@ -101,6 +149,28 @@ func (s *Server) handleGetDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Revision history is addressed as {docID}/versions[/{n}]
+	if id, version, ok := parseVersionPath(docID); ok {
+		s.writeDocumentVersions(w, db, id, version)
+		return
+	}
+
+	// Differences between revisions are addressed as {docID}/diff?from=&to=
+	if id, found := strings.CutSuffix(docID, "/diff"); found && id != "" {
+		s.writeDocumentDiff(w, r, db, id)
+		return
+	}
+
+	// The link graph is addressed as {docID}/links and {docID}/backlinks
+	if id, found := strings.CutSuffix(docID, "/links"); found && id != "" {
+		s.writeDocumentLinks(w, db, id, false)
+		return
+	}
+	if id, found := strings.CutSuffix(docID, "/backlinks"); found && id != "" {
+		s.writeDocumentLinks(w, db, id, true)
+		return
+	}
+
 	// Get document
 	doc, err := document.GetDocument(db, docID)
 	if err != nil {
@@ -115,21 +185,122 @@ func (s *Server) handleGetDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse to serve binary documents that are unscanned or quarantined
+	if doc.IsBinary {
+		scanRecord, err := document.GetScanRecord(db, docID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "failed to check scan status",
+			})
+			return
+		}
+		if !scanRecord.IsServable() {
+			if scanRecord.Status == document.ScanStatusPending {
+				w.Header().Set("Retry-After", "5")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				w.WriteHeader(http.StatusForbidden)
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "document is " + scanRecord.Status,
+			})
+			return
+		}
+	}
+
+	// Markdown is rendered to HTML for clients asking for HTML
+	if isMarkdown(doc) && acceptsHTML(r.Header.Get("Accept")) {
+		s.writeMarkdownHTML(w, r, db, doc)
+		return
+	}
+
 	// Check if content type should be returned as raw
 	acceptHeader := r.Header.Get("Accept")
 	if acceptHeader == doc.ContentType || acceptHeader == "*/*" {
+		// Apply the cenv's serving policy for this content type
+		disposition, err := document.ResolveDisposition(db, doc.ContentType)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "failed to check mime policy",
+			})
+			return
+		}
+
+		if disposition == document.DispositionReject {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "content type not allowed: " + doc.ContentType,
+			})
+			return
+		}
+
+		hash, err := document.ContentHash(r.Context(), db, doc)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "failed to read document",
+			})
+			return
+		}
+
+		// Validators let browsers and CDNs revalidate instead of refetching
+		etag := `"` + hash + `"`
+		modifiedAt := time.Unix(doc.ModifiedAt, 0)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modifiedAt.UTC().Format(http.TimeFormat))
+		w.Header().Set("Vary", "Accept")
+		if notModified(r, etag, modifiedAt) {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		// Return raw content with proper content type
 		w.Header().Set("Content-Type", doc.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if disposition == document.DispositionAttachment {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+				"filename": path.Base(doc.ID),
+			}))
+		}
+		if doc.IsBlob() {
+			s.streamBlob(w, r, db, doc)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(doc.Content))
 		return
 	}
 
 	// Return as JSON
+	w.Header().Set("Vary", "Accept")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(doc)
 }
 
+// notModified evaluates If-None-Match, or If-Modified-Since when no entity
+// tag was sent, against the current validators of a document
+func notModified(r *http.Request, etag string, modifiedAt time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		return err == nil && !modifiedAt.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
 // handleUpdateDocument updates an existing document
 func (s *Server) handleUpdateDocument(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -158,9 +329,27 @@ func (s *Server) handleUpdateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse request
+	// The full tag list is replaced as {docID}/tags
+	if id, found := strings.CutSuffix(docID, "/tags"); found && id != "" {
+		s.setDocumentTags(w, r, db, id)
+		return
+	}
+
+	// Any body other than JSON is the raw content of a binary document
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "" && mediaType != "application/json" {
+		s.uploadBlob(w, r, db, cenvID, docID, mediaType, userID)
+		return
+	}
+
+	// Parse request; content, metadata, schema and expiry may each be
+	// replaced. New content is checked against the current schema, and a new
+	// schema against the resulting content; "schema_id": "" detaches the
+	// schema and "expires_at": 0 clears the expiry.
 	var req struct {
-		Content string `json:"content"`
+		Content   *string         `json:"content"`
+		Metadata  json.RawMessage `json:"metadata"`
+		SchemaID  *string         `json:"schema_id"`
+		ExpiresAt *int64          `json:"expires_at"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -171,20 +360,54 @@ func (s *Server) handleUpdateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update document
-	doc, err := document.UpdateDocument(db, docID, req.Content, userID)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-		}
+	if req.Content == nil && req.Metadata == nil && req.SchemaID == nil && req.ExpiresAt == nil {
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": err.Error(),
+			"error": "content, metadata, schema_id or expires_at is required",
 		})
 		return
 	}
 
+	if req.Metadata != nil {
+		if _, err := document.NormalizeMetadata(req.Metadata); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	// Update document; the changes are applied together or not at all
+	var doc *document.Document
+	err = withTransaction(r, db, func(tx *sql.Tx) error {
+		var err error
+		if req.Content != nil {
+			doc, err = document.UpdateDocument(tx, docID, *req.Content, userID)
+		}
+		if err == nil && req.Metadata != nil {
+			doc, err = document.SetDocumentMetadata(tx, docID, req.Metadata, userID)
+		}
+		if err == nil && req.SchemaID != nil {
+			doc, err = document.SetDocumentSchema(tx, docID, *req.SchemaID, userID)
+		}
+		if err == nil && req.ExpiresAt != nil {
+			doc, err = document.SetDocumentExpiry(tx, docID, *req.ExpiresAt, userID)
+		}
+		return err
+	})
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	// Only new content needs scanning or fixture installation
+	if req.Content != nil {
+		s.queueDocumentScan(db, doc)
+		s.installSeedFixture(db, doc, userID, role)
+	}
+	s.runDocumentHooks(db, cenvID, hooks.EventDocumentUpdated, doc.ID, userID)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(doc)
 }
@@ -217,11 +440,32 @@ func (s *Server) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse to break the templates and endpoints that use the document
+	// unless the caller insists
+	if r.URL.Query().Get("force") != "true" {
+		referrers, err := documentReferrers(db, docID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if len(referrers) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":     fmt.Sprintf("document %s is referenced by %d templates or endpoints; delete with ?force=true to break them", docID, len(referrers)),
+				"referrers": referrers,
+			})
+			return
+		}
+	}
+
 	// Delete document
-	err = document.DeleteDocument(db, docID)
+	err = document.DeleteDocument(db, docID, userID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			w.WriteHeader(http.StatusNotFound)
+		} else if strings.Contains(err.Error(), "in use") {
+			w.WriteHeader(http.StatusConflict)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -231,6 +475,8 @@ func (s *Server) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.runDocumentHooks(db, cenvID, hooks.EventDocumentDeleted, docID, userID)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "document deleted successfully",
@@ -264,6 +510,35 @@ func (s *Server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Tree mode returns the immediate children of a folder for file browsers
+	switch r.URL.Query().Get("mode") {
+	case "", "flat":
+	case "tree":
+		folder := document.NormalizeFolder(r.URL.Query().Get("path"))
+		entries, err := document.ListChildren(db, folder)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":    folder,
+			"entries": entries,
+			"count":   len(entries),
+		})
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "mode must be 'flat' or 'tree'",
+		})
+		return
+	}
+
 	// Parse query parameters
 	prefix := r.URL.Query().Get("prefix")
 	limitStr := r.URL.Query().Get("limit")
@@ -283,9 +558,57 @@ func (s *Server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// List documents
-	docs, err := document.ListDocuments(db, prefix, limit, offset)
+	// include_content=false lists metadata only, without reading content
+	includeContent := true
+	if v := r.URL.Query().Get("include_content"); v != "" {
+		includeContent, err = strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "include_content must be true or false",
+			})
+			return
+		}
+	}
+
+	// sort=position lists pinned documents first, then in the order set by
+	// POST /documents/reorder; it pages by cursor only
+	sortOrder := r.URL.Query().Get("sort")
+	if sortOrder != "" && sortOrder != document.SortID && sortOrder != document.SortPosition {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "sort must be 'id' or 'position'",
+		})
+		return
+	}
+	if sortOrder == document.SortPosition && offset > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "sort=position pages by cursor, not offset",
+		})
+		return
+	}
+
+	// List documents; without an offset, pages are fetched by ?cursor= and
+	// the response carries the next_cursor to continue from
+	var docs []document.Document
+	var nextCursor string
+	switch {
+	case offset > 0:
+		docs, err = document.ListDocumentsFiltered(db, prefix, metadataFilters(r.URL.Query()), includeContent, limit, offset)
+	case sortOrder == document.SortPosition:
+		docs, nextCursor, err = document.ListDocumentsByPosition(db, prefix, metadataFilters(r.URL.Query()), includeContent, r.URL.Query().Get("cursor"), limit)
+	default:
+		docs, nextCursor, err = document.ListDocumentsAfter(db, prefix, metadataFilters(r.URL.Query()), includeContent, r.URL.Query().Get("cursor"), limit)
+	}
 	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid metadata key") || err.Error() == "invalid cursor" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": err.Error(),
@@ -294,10 +617,14 @@ func (s *Server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"documents": docs,
 		"count":     len(docs),
-	})
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 // handleSearchDocuments searches documents using FTS5
@@ -327,38 +654,749 @@ func (s *Server) handleSearchDocuments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get search query
-	query := r.URL.Query().Get("q")
-	if query == "" {
+	// Full-text, tag, content type, prefix and metadata criteria combine in
+	// one query; at least one is required
+	params := r.URL.Query()
+	opts := document.QueryOptions{
+		Text:         params.Get("q"),
+		Tags:         params["tag"],
+		ContentTypes: params["content_type"],
+		Prefix:       params.Get("prefix"),
+		Metadata:     metadataFilters(params),
+		Sort:         params.Get("sort"),
+		Limit:        20,
+	}
+	if opts.Text == "" && len(opts.Tags) == 0 && len(opts.ContentTypes) == 0 && opts.Prefix == "" && len(opts.Metadata) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "query parameter 'q' is required",
+			"error": "query parameter 'q' or a tag, content_type, prefix or metadata filter is required",
 		})
 		return
 	}
-
-	// Parse limit
-	limitStr := r.URL.Query().Get("limit")
-	limit := 20
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil {
-			limit = l
+	if opts.Text != "" {
+		if _, err := document.BuildSearchQuery(opts.Text); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "invalid search query: " + err.Error(),
+			})
+			return
 		}
 	}
+	if err := document.ValidateSort(opts.Sort, opts.Text != ""); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Parse pagination
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 {
+		opts.Limit = min(l, 100)
+	}
+	if o, err := strconv.Atoi(params.Get("offset")); err == nil {
+		opts.Offset = o
+	}
+	opts.Cursor = params.Get("cursor")
 
 	// Search documents
-	results, err := document.SearchDocuments(db, query, limit)
+	results, nextCursor, err := document.QueryPage(db, opts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid metadata key") || err.Error() == "invalid cursor" {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": err.Error(),
 		})
 		return
 	}
+	if results == nil {
+		results = []document.SearchResult{}
+	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"results": results,
 		"count":   len(results),
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleReindexSearch drops and rebuilds the search index from the documents,
+// with the tokenizer set in the search_tokenizer config (admin/owner only).
+// It repairs an index that drifted and applies a changed tokenizer. The
+// response is newline-delimited JSON: a {"indexed", "total"} line after each
+// batch (?batch_size=, default 500) and a final line with "done" or "error".
+func (s *Server) handleReindexSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "rebuild the search index")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "reindex_search", "search", ""); err != nil {
+		return // Response already sent
+	}
+
+	batchSize := document.DefaultReindexBatchSize
+	if raw := r.URL.Query().Get("batch_size"); raw != "" {
+		batchSize, err = strconv.Atoi(raw)
+		if err != nil || batchSize < 1 || batchSize > 10000 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "batch_size must be between 1 and 10000",
+			})
+			return
+		}
+	}
+
+	tokenizer := config.GetString(db, document.SearchTokenizerConfigKey, "")
+	if _, err := document.ParseTokenizer(tokenizer); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid " + document.SearchTokenizerConfigKey + ": " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher := http.NewResponseController(w)
+	final, err := document.RebuildSearchIndex(r.Context(), db, batchSize, func(p document.ReindexProgress) {
+		enc.Encode(p)
+		flusher.Flush()
+	})
+	if err != nil {
+		enc.Encode(map[string]interface{}{
+			"indexed": final.Indexed,
+			"total":   final.Total,
+			"error":   "failed to rebuild search index: " + err.Error(),
+		})
+		return
+	}
+	enc.Encode(map[string]interface{}{
+		"indexed":   final.Indexed,
+		"total":     final.Total,
+		"tokenizer": tokenizer,
+		"done":      true,
+	})
+}
+
+// handleDocumentChanges returns the document change feed: creates, updates
+// and deletes in the order they happened, after the ?since= sequence number
+// (0 for the whole history). The response's next is the since value for the
+// following request, so clients sync incrementally by polling with it.
+// Route: GET /{cenvID}/documents/changes?since=&limit=
+func (s *Server) handleDocumentChanges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	// Check read permission
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	var since int64
+	if param := r.URL.Query().Get("since"); param != "" {
+		since, err = strconv.ParseInt(param, 10, 64)
+		if err != nil || since < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "since must be a change sequence number",
+			})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	changes, err := document.ListChanges(db, since, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list document changes",
+		})
+		return
+	}
+
+	next := since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+		"count":   len(changes),
+		"next":    next,
 	})
 }
+
+// metadataFilters reads metadata.<key>=<value> query parameters, all of which
+// must match
+func metadataFilters(params url.Values) []document.MetadataFilter {
+	var filters []document.MetadataFilter
+	for param, values := range params {
+		if key, ok := strings.CutPrefix(param, "metadata."); ok {
+			for _, value := range values {
+				filters = append(filters, document.MetadataFilter{Key: key, Value: value})
+			}
+		}
+	}
+	return filters
+}
+
+// parseVersionPath splits a document path of the form "{docID}/versions" or
+// "{docID}/versions/{n}". The version is empty when listing.
+func parseVersionPath(docPath string) (docID, version string, ok bool) {
+	if id, found := strings.CutSuffix(docPath, "/versions"); found && id != "" {
+		return id, "", true
+	}
+
+	i := strings.LastIndex(docPath, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	if id, found := strings.CutSuffix(docPath[:i], "/versions"); found && id != "" {
+		return id, docPath[i+1:], true
+	}
+
+	return "", "", false
+}
+
+// writeDocumentLinks responds with the documents docID links to, or with its
+// backlinks. Backlinks are listed even if docID does not exist yet, so a
+// wiki can show what refers to a missing page.
+func (s *Server) writeDocumentLinks(w http.ResponseWriter, db *sql.DB, docID string, backlinks bool) {
+	var links []document.Link
+	var err error
+	if backlinks {
+		links, err = document.GetBacklinks(db, docID)
+	} else {
+		if _, err := document.GetDocument(db, docID); err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		links, err = document.GetOutboundLinks(db, docID)
+	}
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"document_id": docID,
+		"links":       links,
+		"count":       len(links),
+	})
+}
+
+// writeDocumentVersions responds with a document's revision list, or a single
+// revision when a version number is given
+func (s *Server) writeDocumentVersions(w http.ResponseWriter, db *sql.DB, docID, version string) {
+	if version == "" {
+		versions, err := document.ListDocumentVersions(db, docID)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"versions": versions,
+			"count":    len(versions),
+		})
+		return
+	}
+
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid version number",
+		})
+		return
+	}
+
+	v, err := document.GetDocumentVersion(db, docID, n)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	// Only the current revision of a binary document has a scan verdict,
+	// so past revisions must be restored (and rescanned) to be viewed
+	if v.IsBinary {
+		servable := false
+		if v.Current {
+			scanRecord, err := document.GetScanRecord(db, docID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "failed to check scan status",
+				})
+				return
+			}
+			servable = scanRecord.IsServable()
+		}
+		if !servable {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "binary document revision has not been cleared by the scanner",
+			})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeDocumentDiff responds with the difference between two revisions of a
+// document: a structural diff when both are JSON (or ?format=json), a unified
+// diff otherwise (or ?format=unified)
+func (s *Server) writeDocumentDiff(w http.ResponseWriter, r *http.Request, db *sql.DB, docID string) {
+	var versions [2]*document.DocumentVersion
+	for i, param := range []string{"from", "to"} {
+		n, err := strconv.Atoi(r.URL.Query().Get(param))
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": param + " must be a version number",
+			})
+			return
+		}
+
+		versions[i], err = document.GetDocumentVersion(db, docID, n)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		if versions[i].IsBinary {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "binary document revisions cannot be diffed",
+			})
+			return
+		}
+	}
+	from, to := versions[0], versions[1]
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "unified" && format != "json" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "format must be unified or json",
+		})
+		return
+	}
+
+	isJSON := func(v *document.DocumentVersion) bool {
+		mediaType, _, _ := mime.ParseMediaType(v.ContentType)
+		return mediaType == "application/json"
+	}
+	if format == "json" || (format == "" && isJSON(from) && isJSON(to)) {
+		changes, err := diff.JSON(from.Content, to.Content)
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"document_id": docID,
+				"from":        from.Version,
+				"to":          to.Version,
+				"changes":     changes,
+				"count":       len(changes),
+			})
+			return
+		}
+		if format == "json" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		// JSON revisions that do not parse are diffed as text
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(diff.Unified(
+		fmt.Sprintf("%s@%d", docID, from.Version), fmt.Sprintf("%s@%d", docID, to.Version),
+		from.Content, to.Content, 3,
+	)))
+}
+
+// RelocateDocumentRequest names the destination of a document move or copy
+type RelocateDocumentRequest struct {
+	To string `json:"to"`
+}
+
+// ReorderDocumentsRequest lists the documents under a prefix in the order
+// they should be listed with sort=position
+type ReorderDocumentsRequest struct {
+	Prefix string   `json:"prefix"`
+	IDs    []string `json:"ids"`
+}
+
+// handleDocumentAction dispatches POST actions addressed under a document path:
+// {docID}/versions/{n}/restore, {docID}/move, {docID}/copy, {docID}/pin and
+// {docID}/unpin
+func (s *Server) handleDocumentAction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	docPath := r.PathValue("docID")
+	if docID, found := strings.CutSuffix(docPath, "/move"); found && docID != "" {
+		s.relocateDocument(w, r, cenvID, docID, true)
+		return
+	}
+	if docID, found := strings.CutSuffix(docPath, "/copy"); found && docID != "" {
+		s.relocateDocument(w, r, cenvID, docID, false)
+		return
+	}
+	if docID, found := strings.CutSuffix(docPath, "/pin"); found && docID != "" {
+		s.pinDocument(w, r, cenvID, docID, true)
+		return
+	}
+	if docID, found := strings.CutSuffix(docPath, "/unpin"); found && docID != "" {
+		s.pinDocument(w, r, cenvID, docID, false)
+		return
+	}
+	s.restoreDocumentVersion(w, r, cenvID, docPath)
+}
+
+// restoreDocumentVersion makes a past revision the current content of a
+// document. The path must have the form {docID}/versions/{n}/restore.
+func (s *Server) restoreDocumentVersion(w http.ResponseWriter, r *http.Request, cenvID, docPath string) {
+	docPath, found := strings.CutSuffix(docPath, "/restore")
+	docID, version, ok := parseVersionPath(docPath)
+	if !found || !ok || version == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	// Check write permission
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return
+	}
+
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid version number",
+		})
+		return
+	}
+
+	doc, err := document.RestoreDocumentVersion(db, docID, n, userID)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	s.queueDocumentScan(db, doc)
+	s.installSeedFixture(db, doc, userID, role)
+	s.runDocumentHooks(db, cenvID, hooks.EventDocumentUpdated, doc.ID, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// relocateDocument moves or copies a document to the id given in the request
+// body. Moving removes the source, so it also needs delete permission.
+func (s *Server) relocateDocument(w http.ResponseWriter, r *http.Request, cenvID, docID string, move bool) {
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return
+	}
+	if move {
+		canDelete, err := authz.CanDelete(db, userID, role, "_wce_documents")
+		if err != nil || !canDelete {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "permission denied: cannot delete documents",
+			})
+			return
+		}
+	}
+
+	var req RelocateDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "request body must name a destination in 'to'",
+		})
+		return
+	}
+
+	var doc *document.Document
+	if move {
+		doc, err = document.MoveDocument(db, docID, req.To, userID)
+	} else {
+		doc, err = document.CopyDocument(db, docID, req.To, userID)
+	}
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	s.installSeedFixture(db, doc, userID, role)
+	if move {
+		s.runDocumentHooks(db, cenvID, hooks.EventDocumentDeleted, docID, userID)
+	}
+	s.runDocumentHooks(db, cenvID, hooks.EventDocumentCreated, doc.ID, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// pinDocument pins or unpins a document for position order
+func (s *Server) pinDocument(w http.ResponseWriter, r *http.Request, cenvID, docID string, pinned bool) {
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return
+	}
+
+	doc, err := document.SetDocumentPinned(db, docID, pinned)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// handleReorderDocuments sets the order of the documents under a prefix
+// from {"prefix", "ids"}, for listings with sort=position
+// Route: POST /{cenvID}/documents/reorder
+func (s *Server) handleReorderDocuments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return
+	}
+
+	var req ReorderDocumentsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if err := document.ReorderDocuments(db, req.Prefix, req.IDs); err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"prefix":    req.Prefix,
+		"reordered": len(req.IDs),
+	})
+}
+
+// writeSchemaError responds with the validation errors when err reports
+// content that does not match its JSON Schema
+func writeSchemaError(w http.ResponseWriter, err error) bool {
+	var schemaErr *document.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     "content does not match schema " + schemaErr.SchemaID,
+		"schema_id": schemaErr.SchemaID,
+		"details":   schemaErr.Errors,
+	})
+	return true
+}
+
+// writeLimitError responds with 413 Request Entity Too Large when err
+// reports a document over the size limit, or 507 Insufficient Storage when
+// it reports a write over the storage quota
+func writeLimitError(w http.ResponseWriter, err error) bool {
+	var limitErr *document.LimitError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+
+	if limitErr.Limit == document.LimitStorageQuota {
+		w.WriteHeader(http.StatusInsufficientStorage)
+	} else {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     limitErr.Error(),
+		"limit":     limitErr.Limit,
+		"max_bytes": limitErr.Max,
+	})
+	return true
+}
+
+// writeDocumentError maps document errors to HTTP status codes
+func writeDocumentError(w http.ResponseWriter, err error) {
+	if writeSchemaError(w, err) || writeLimitError(w, err) {
+		return
+	}
+
+	switch {
+	case strings.Contains(err.Error(), "not found"):
+		w.WriteHeader(http.StatusNotFound)
+	case strings.Contains(err.Error(), "already exists"), strings.Contains(err.Error(), "modified concurrently"):
+		w.WriteHeader(http.StatusConflict)
+	case strings.HasPrefix(err.Error(), "failed"), strings.HasPrefix(err.Error(), "error"):
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// uploadBlob streams a raw request body into a binary document, creating it
+// when it does not exist. The body is limited by 'max_document_size_mb'.
+func (s *Server) uploadBlob(w http.ResponseWriter, r *http.Request, db *sql.DB, cenvID, docID, contentType, userID string) {
+	maxBytes := int64(config.GetInt(db, document.MaxDocumentSizeConfigKey, 10)) << 20
+	body := http.MaxBytesReader(w, r.Body, maxBytes)
+
+	doc, created, err := document.WriteBlob(db, docID, contentType, userID, body)
+	if err != nil {
+		if writeLimitError(w, err) {
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else if strings.Contains(err.Error(), "failed") {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	s.queueDocumentScan(db, doc)
+
+	if created {
+		s.runDocumentHooks(db, cenvID, hooks.EventDocumentCreated, doc.ID, userID)
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		s.runDocumentHooks(db, cenvID, hooks.EventDocumentUpdated, doc.ID, userID)
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(doc)
+}
+
+// streamBlob copies a streamed document's content to the response without
+// buffering it. Headers other than Content-Length must already be set.
+func (s *Server) streamBlob(w http.ResponseWriter, r *http.Request, db *sql.DB, doc *document.Document) {
+	reader, err := document.OpenBlob(r.Context(), db, doc.ID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to read document",
+		})
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Length", strconv.FormatInt(doc.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Failed to stream document %s: %v", doc.ID, err)
+	}
+}
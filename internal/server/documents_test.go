@@ -0,0 +1,1158 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestDocumentVersionAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("POST /{cenvID}/documents/{docID...}", srv.handleDocumentAction)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "viewer", "viewerpass123", "viewer", "", ""); err != nil {
+		t.Fatalf("Failed to create viewer: %v", err)
+	}
+	viewerToken := loginAs(t, mux, cenvID, "viewer", "viewerpass123")
+
+	docPath := "/" + cenvID + "/documents/pages/home"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "pages/home", "content": "first", "content_type": "text/plain",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "PUT", docPath, token, map[string]string{"content": "second"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("List", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", docPath+"/versions", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Versions []document.DocumentVersion `json:"versions"`
+			Count    int                        `json:"count"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Count != 2 || resp.Versions[0].Version != 2 || !resp.Versions[0].Current {
+			t.Errorf("Unexpected versions: %+v", resp)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", docPath+"/versions/1", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var v document.DocumentVersion
+		json.NewDecoder(w.Body).Decode(&v)
+		if v.Content != "first" || v.Version != 1 {
+			t.Errorf("Unexpected version: %+v", v)
+		}
+
+		w = doJSON(t, mux, "GET", docPath+"/versions/7", token, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for unknown version, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "GET", docPath+"/versions/latest", token, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for invalid version, got %d", w.Code)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", docPath+"/versions/1/restore", viewerToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for viewer, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "POST", docPath+"/versions/1/restore", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var doc document.Document
+		json.NewDecoder(w.Body).Decode(&doc)
+		if doc.Content != "first" || doc.Version != 3 {
+			t.Errorf("Expected first as version 3, got %q version %d", doc.Content, doc.Version)
+		}
+
+		w = doJSON(t, mux, "POST", docPath+"/versions/1", token, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 without restore suffix, got %d", w.Code)
+		}
+	})
+}
+
+func TestDocumentDiffAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	create := func(id, content, contentType string, updates ...string) {
+		t.Helper()
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": content, "content_type": contentType,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		for _, update := range updates {
+			w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/"+id, token, map[string]string{"content": update})
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+		}
+	}
+	create("notes/a", "one\ntwo\nthree\n", "text/plain", "one\n2\nthree\n")
+	create("data/a", `{"title": "a", "count": 1}`, "application/json", `{"title": "b", "count": 1}`)
+
+	t.Run("Unified", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/notes/a/diff?from=1&to=2", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("Expected text/plain, got %s", ct)
+		}
+		want := "--- notes/a@1\n+++ notes/a@2\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+		if w.Body.String() != want {
+			t.Errorf("Unexpected diff:\n%s", w.Body.String())
+		}
+	})
+
+	t.Run("Structural", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/data/a/diff?from=1&to=2", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			From    int `json:"from"`
+			To      int `json:"to"`
+			Changes []struct {
+				Op   string      `json:"op"`
+				Path string      `json:"path"`
+				From interface{} `json:"from"`
+				To   interface{} `json:"to"`
+			} `json:"changes"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.From != 1 || resp.To != 2 || len(resp.Changes) != 1 {
+			t.Fatalf("Unexpected diff: %+v", resp)
+		}
+		if c := resp.Changes[0]; c.Op != "replace" || c.Path != "/title" || c.From != "a" || c.To != "b" {
+			t.Errorf("Unexpected change: %+v", c)
+		}
+
+		// The text diff is still available for JSON documents
+		w = doJSON(t, mux, "GET", "/"+cenvID+"/documents/data/a/diff?from=2&to=1&format=unified", token, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `+{"title": "a", "count": 1}`) {
+			t.Errorf("Expected a unified diff, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cases := []struct {
+			query string
+			code  int
+		}{
+			{"from=1", http.StatusBadRequest},
+			{"from=x&to=2", http.StatusBadRequest},
+			{"from=1&to=9", http.StatusNotFound},
+			{"from=1&to=2&format=html", http.StatusBadRequest},
+			{"from=1&to=2&format=json", http.StatusBadRequest},
+		}
+		for _, tc := range cases {
+			w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/notes/a/diff?"+tc.query, token, nil)
+			if w.Code != tc.code {
+				t.Errorf("%s: expected %d, got %d: %s", tc.query, tc.code, w.Code, w.Body.String())
+			}
+		}
+
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/missing/diff?from=1&to=2", token, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a missing document, got %d", w.Code)
+		}
+	})
+}
+
+func TestDocumentMoveCopyAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("POST /{cenvID}/documents/{docID...}", srv.handleDocumentAction)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "viewer", "viewerpass123", "viewer", "", ""); err != nil {
+		t.Fatalf("Failed to create viewer: %v", err)
+	}
+	viewerToken := loginAs(t, mux, cenvID, "viewer", "viewerpass123")
+
+	base := "/" + cenvID + "/documents/"
+	for _, id := range []string{"pages/home", "pages/about"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "content of " + id, "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	t.Run("Move", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", base+"pages/home/move", token, map[string]string{"to": "pages/index"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var doc document.Document
+		json.NewDecoder(w.Body).Decode(&doc)
+		if doc.ID != "pages/index" || doc.Content != "content of pages/home" {
+			t.Errorf("Unexpected moved document: %+v", doc)
+		}
+
+		if w := doJSON(t, mux, "GET", base+"pages/home", token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected old id to 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", base+"pages/about/copy", token, map[string]string{"to": "pages/about-v2"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := doJSON(t, mux, "GET", base+"pages/about", token, nil); w.Code != http.StatusOK {
+			t.Errorf("Expected source to remain, got %d", w.Code)
+		}
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", base+"pages/about/move", token, map[string]string{"to": "pages/index"})
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("MissingDestination", func(t *testing.T) {
+		if w := doJSON(t, mux, "POST", base+"pages/about/copy", token, map[string]string{}); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("ReservedID", func(t *testing.T) {
+		// GET {docID}/links etc. address sub-resources, so no document may
+		// end in those segments
+		for _, suffix := range []string{"versions", "diff", "links", "backlinks"} {
+			w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+				"id": "notes/" + suffix, "content": "x", "content_type": "text/plain",
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for notes/%s, got %d: %s", suffix, w.Code, w.Body.String())
+			}
+		}
+
+		w := doJSON(t, mux, "POST", base+"pages/about/copy", token, map[string]string{"to": "pages/about/links"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ViewerDenied", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", base+"pages/about/move", viewerToken, map[string]string{"to": "pages/x"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+}
+
+func TestDocumentTreeAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for _, id := range []string{"index", "pages/home", "pages/docs/intro", "pages/docs/setup"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "content of " + id, "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?mode=tree&path=pages", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Path    string               `json:"path"`
+		Entries []document.TreeEntry `json:"entries"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Path != "pages/" || len(resp.Entries) != 2 {
+		t.Fatalf("Unexpected tree: %+v", resp)
+	}
+	if resp.Entries[0].Type != document.EntryFolder || resp.Entries[0].Path != "pages/docs/" || resp.Entries[0].Documents != 2 {
+		t.Errorf("Expected docs folder first, got %+v", resp.Entries[0])
+	}
+	if resp.Entries[1].Type != document.EntryDocument || resp.Entries[1].Path != "pages/home" {
+		t.Errorf("Expected home document, got %+v", resp.Entries[1])
+	}
+
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?mode=bogus", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown mode, got %d", w.Code)
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/documents?prefix=pages/&include_content=false", token, nil)
+	var list struct {
+		Documents []document.Document `json:"documents"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list.Documents) != 3 || list.Documents[0].Content != "" {
+		t.Errorf("Expected metadata-only listing, got %d %+v", w.Code, list.Documents)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?include_content=maybe", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid include_content, got %d", w.Code)
+	}
+}
+
+func TestDocumentStreamingAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+	docPath := "/" + cenvID + "/documents/files/data.bin"
+
+	put := func(body []byte, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", docPath, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	data := bytes.Repeat([]byte{0, 1, 2, 3, 255}, document.BlobChunkSize/2)
+
+	w := put(data, "application/octet-stream")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var doc document.Document
+	json.NewDecoder(w.Body).Decode(&doc)
+	if !doc.IsBinary || doc.Size != int64(len(data)) {
+		t.Errorf("Unexpected document: %+v", doc)
+	}
+
+	if w := put([]byte("replaced"), "application/octet-stream"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 on replace, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", docPath, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/octet-stream")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "replaced" || w.Header().Get("Content-Length") != "8" {
+		t.Errorf("Unexpected raw body %q (length %s)", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := db.Exec("UPDATE _wce_config SET value = '1' WHERE key = 'max_document_size_mb'"); err != nil {
+		t.Fatalf("Failed to set size limit: %v", err)
+	}
+	if w := put(make([]byte, 2<<20), "application/octet-stream"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized upload, got %d", w.Code)
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "notes.txt", "content": "text", "content_type": "text/plain",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	docPath = "/" + cenvID + "/documents/notes.txt"
+	if w := put([]byte("raw"), "text/plain"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 streaming into a text document, got %d", w.Code)
+	}
+}
+
+func TestDocumentConditionalGet(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+	docPath := "/" + cenvID + "/documents/assets/site.css"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "assets/site.css", "content": "body {}", "content_type": "text/css",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", docPath, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "text/css")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w = get(nil)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("Expected 200 with validators, got %d (ETag %q, Last-Modified %q)", w.Code, etag, lastModified)
+	}
+
+	if w := get(map[string]string{"If-None-Match": `"other", ` + etag}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 for matching ETag, got %d", w.Code)
+	}
+	if w := get(map[string]string{"If-None-Match": `"other"`}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for stale ETag, got %d", w.Code)
+	}
+	if w := get(map[string]string{"If-Modified-Since": lastModified}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for If-Modified-Since, got %d", w.Code)
+	}
+	// If-None-Match takes precedence over If-Modified-Since
+	if w := get(map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 when ETag mismatches, got %d", w.Code)
+	}
+
+	w = doJSON(t, mux, "PUT", docPath, token, map[string]string{"content": "body { margin: 0 }"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = get(map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("Expected new content and ETag after update, got %d (%s)", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestDocumentMetadataAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "posts/a", "content": "a", "content_type": "text/plain",
+		"metadata": map[string]interface{}{"author": "alice", "featured": true},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "posts/b", "content": "b", "content_type": "text/plain",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "posts/c", "content": "c", "content_type": "text/plain", "metadata": []int{1},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for non-object metadata, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/posts/c", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected rejected create to leave no document, got %d", w.Code)
+	}
+
+	// Metadata alone can be replaced without a new content version
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/b", token, map[string]interface{}{
+		"metadata": map[string]string{"author": "bob"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var doc document.Document
+	json.NewDecoder(w.Body).Decode(&doc)
+	if string(doc.Metadata) != `{"author":"bob"}` || doc.Version != 1 || doc.Content != "b" {
+		t.Errorf("Unexpected document after metadata update: %+v", doc)
+	}
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/b", token, map[string]interface{}{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty update, got %d", w.Code)
+	}
+
+	list := func(query string) []string {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?"+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Documents []document.Document `json:"documents"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		ids := []string{}
+		for _, doc := range resp.Documents {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	if ids := list("metadata.author=alice"); len(ids) != 1 || ids[0] != "posts/a" {
+		t.Errorf("Expected posts/a for author=alice, got %v", ids)
+	}
+	if ids := list("metadata.featured=true&prefix=posts/"); len(ids) != 1 || ids[0] != "posts/a" {
+		t.Errorf("Expected posts/a for featured=true, got %v", ids)
+	}
+	if ids := list("metadata.author=carol"); len(ids) != 0 {
+		t.Errorf("Expected no matches for author=carol, got %v", ids)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?metadata.a%22b=x", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid metadata key, got %d", w.Code)
+	}
+}
+
+func TestDocumentSearchFilters(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/search", srv.handleSearchDocuments)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for id, contentType := range map[string]string{
+		"guides/go":   "text/markdown",
+		"guides/rust": "text/plain",
+		"notes/go":    "text/markdown",
+	} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "a guide to " + id, "content_type": contentType, "searchable": true,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	document.AddDocumentTag(db, "guides/go", "published")
+	document.AddDocumentTag(db, "notes/go", "published")
+
+	search := func(query string) []string {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/search?"+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Search %q failed: %d %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Results []document.SearchResult `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var ids []string
+		for _, r := range resp.Results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	if got := search("q=guide&tag=published&content_type=text/markdown&prefix=guides/"); len(got) != 1 || got[0] != "guides/go" {
+		t.Errorf("Expected [guides/go], got %v", got)
+	}
+	if got := search("tag=published&limit=1&offset=1"); len(got) != 1 || got[0] != "notes/go" {
+		t.Errorf("Expected second page [notes/go], got %v", got)
+	}
+	if got := search("content_type=text/plain"); len(got) != 1 || got[0] != "guides/rust" {
+		t.Errorf("Expected [guides/rust], got %v", got)
+	}
+
+	// Cursor pagination hands back next_cursor until the last page
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/search?tag=published&limit=1", token, nil)
+	var page struct {
+		Results    []document.SearchResult `json:"results"`
+		NextCursor string                  `json:"next_cursor"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Results) != 1 || page.Results[0].ID != "guides/go" || page.NextCursor == "" {
+		t.Fatalf("Unexpected first page: %s", w.Body.String())
+	}
+	if got := search("tag=published&limit=1&cursor=" + page.NextCursor); len(got) != 1 || got[0] != "notes/go" {
+		t.Errorf("Expected cursor page [notes/go], got %v", got)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/search?tag=published&cursor=bogus", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid cursor, got %d", w.Code)
+	}
+
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/search", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without criteria, got %d", w.Code)
+	}
+}
+
+func TestSearchTokenizerAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/search", srv.handleSearchDocuments)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("POST /{cenvID}/admin/search/reindex", srv.handleReindexSearch)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "notes/straße", "content": "Die Straße ist lang", "content_type": "text/plain", "searchable": true,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	count := func(query string) int {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/search?q="+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Search %q failed: %d %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Results []document.SearchResult `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return len(resp.Results)
+	}
+
+	if n := count("traß"); n != 0 {
+		t.Errorf("Expected no substring match before reindexing, got %d", n)
+	}
+
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/search_tokenizer", token, map[string]string{"value": "porter trigram"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid tokenizer, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/search_tokenizer", token, map[string]string{"value": "trigram"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/admin/search/reindex", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(lines[len(lines)-1]), &resp)
+	if resp["tokenizer"] != "trigram" || resp["indexed"] != float64(1) || resp["done"] != true {
+		t.Errorf("Unexpected reindex response: %s", w.Body.String())
+	}
+	if n := count("traß"); n != 1 {
+		t.Errorf("Expected a substring match after reindexing with trigram, got %d", n)
+	}
+
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/search/reindex", editorToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for editor, got %d", w.Code)
+	}
+}
+
+func TestSearchReindexAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("POST /{cenvID}/admin/search/reindex", srv.handleReindexSearch)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for _, id := range []string{"a", "b", "c"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": "notes/" + id, "content": "indexed " + id, "content_type": "text/plain", "searchable": true,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := db.Exec("DELETE FROM _wce_document_search"); err != nil {
+		t.Fatalf("Failed to empty the index: %v", err)
+	}
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/search/reindex?batch_size=2", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON, got %s", ct)
+	}
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid progress line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	if len(lines) != 3 || lines[0]["indexed"] != float64(2) || lines[1]["indexed"] != float64(3) || lines[2]["done"] != true || lines[2]["total"] != float64(3) {
+		t.Errorf("Expected two batches and a summary, got %s", w.Body.String())
+	}
+	var indexed int
+	db.QueryRow("SELECT COUNT(*) FROM _wce_document_search WHERE _wce_document_search MATCH 'indexed'").Scan(&indexed)
+	if indexed != 3 {
+		t.Errorf("Expected 3 documents back in the index, got %d", indexed)
+	}
+
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/search/reindex?batch_size=0", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid batch size, got %d", w.Code)
+	}
+}
+
+func TestDocumentLinksAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", srv.handleDeleteDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for id, content := range map[string]string{
+		"wiki/home":  "See [[wiki/guide]] and [[wiki/todo|the todo list]]",
+		"wiki/guide": "Back to [[wiki/home#top]]",
+	} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": content, "content_type": "text/markdown",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	links := func(path string, wantCode int) []document.Link {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/"+path, token, nil)
+		if w.Code != wantCode {
+			t.Fatalf("GET %s: expected %d, got %d: %s", path, wantCode, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Links []document.Link `json:"links"`
+			Count int             `json:"count"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Links) != resp.Count {
+			t.Errorf("GET %s: count %d does not match %d links", path, resp.Count, len(resp.Links))
+		}
+		return resp.Links
+	}
+
+	outbound := links("wiki/home/links", http.StatusOK)
+	if len(outbound) != 2 || outbound[0].TargetID != "wiki/guide" || !outbound[0].TargetExists ||
+		outbound[1].TargetID != "wiki/todo" || outbound[1].TargetExists {
+		t.Errorf("Unexpected outbound links: %+v", outbound)
+	}
+
+	// Backlinks to a page that does not exist yet
+	backlinks := links("wiki/todo/backlinks", http.StatusOK)
+	if len(backlinks) != 1 || backlinks[0].SourceID != "wiki/home" {
+		t.Errorf("Unexpected backlinks: %+v", backlinks)
+	}
+
+	links("wiki/todo/links", http.StatusNotFound)
+
+	// Deleting a document drops its outbound links
+	w := doJSON(t, mux, "DELETE", "/"+cenvID+"/documents/wiki/home", token, nil)
+	if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
+		t.Fatalf("Delete failed: %d %s", w.Code, w.Body.String())
+	}
+	if backlinks := links("wiki/guide/backlinks", http.StatusOK); len(backlinks) != 0 {
+		t.Errorf("Expected no backlinks after delete, got %+v", backlinks)
+	}
+}
+
+func TestDeleteReferencedDocument(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", srv.handleDeleteDocument)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("DELETE /{cenvID}/admin/endpoints/{endpointID}", srv.handleDeleteEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for id, content := range map[string]string{
+		"templates/base.html":   "<main>{% block body %}{% endblock %}</main>",
+		"templates/footer.html": "<footer></footer>",
+		"templates/page.html":   `{% extends "templates/base.html" %}{% block body %}{% include 'templates/footer.html' %}{% endblock %}`,
+	} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": content, "content_type": "text/html",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path": "/api/footer", "method": "GET",
+		"script": "def handle_request(req):\n    return template.render(\"templates/footer.html\")\n",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var endpoint struct {
+		ID int64 `json:"id"`
+	}
+	json.NewDecoder(w.Body).Decode(&endpoint)
+
+	deleteDoc := func(path string, wantCode int) []referrer {
+		t.Helper()
+		w := doJSON(t, mux, "DELETE", "/"+cenvID+"/documents/"+path, token, nil)
+		if w.Code != wantCode {
+			t.Fatalf("DELETE %s: expected %d, got %d: %s", path, wantCode, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Referrers []referrer `json:"referrers"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Referrers
+	}
+
+	referrers := deleteDoc("templates/footer.html", http.StatusConflict)
+	want := []referrer{
+		{Type: "document", Kind: "include", DocumentID: "templates/page.html"},
+		{Type: "endpoint", Kind: "render", EndpointID: endpoint.ID, Method: "GET", Path: "/api/footer"},
+	}
+	if !reflect.DeepEqual(referrers, want) {
+		t.Errorf("Expected referrers %+v, got %+v", want, referrers)
+	}
+
+	// Dropping the include and the endpoint leaves the footer unreferenced
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/templates/page.html", token, map[string]interface{}{
+		"content": `{% extends "templates/base.html" %}`,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Update failed: %d %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "DELETE", fmt.Sprintf("/%s/admin/endpoints/%d", cenvID, endpoint.ID), token, nil)
+	if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
+		t.Fatalf("Endpoint delete failed: %d %s", w.Code, w.Body.String())
+	}
+	deleteDoc("templates/footer.html", http.StatusOK)
+
+	// Forcing deletes a document that is still referenced
+	deleteDoc("templates/base.html", http.StatusConflict)
+	deleteDoc("templates/base.html?force=true", http.StatusOK)
+}
+
+func TestDocumentSchemaAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", srv.handleDeleteDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "schemas/post", "content_type": "application/json",
+		"content": `{"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}}}`,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "posts/a", "content_type": "application/json", "schema_id": "schemas/post",
+		"content": `{"title": 42}`,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		SchemaID string                     `json:"schema_id"`
+		Details  []document.ValidationError `json:"details"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.SchemaID != "schemas/post" || len(resp.Details) != 1 || resp.Details[0].Path != "/title" {
+		t.Errorf("Unexpected validation response: %+v", resp)
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "posts/a", "content_type": "application/json", "schema_id": "schemas/post",
+		"content": `{"title": "Hello"}`,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/a", token, map[string]interface{}{
+		"content": `{}`,
+	})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `missing required property`) {
+		t.Errorf("Expected invalid update to be rejected with details, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "DELETE", "/"+cenvID+"/documents/schemas/post", token, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected deleting a schema in use to conflict, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Detaching the schema allows any JSON again
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/a", token, map[string]interface{}{
+		"schema_id": "",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected schema to be detached, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/a", token, map[string]interface{}{
+		"content": `{}`,
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected unvalidated update to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDocumentChangesAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/changes", srv.handleDocumentChanges)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", srv.handleDeleteDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+	changesPath := "/" + cenvID + "/documents/changes"
+
+	doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "notes/a", "content": "one", "content_type": "text/plain",
+	})
+	doJSON(t, mux, "PUT", "/"+cenvID+"/documents/notes/a", token, map[string]string{"content": "two"})
+	doJSON(t, mux, "DELETE", "/"+cenvID+"/documents/notes/a", token, nil)
+
+	type feed struct {
+		Changes []document.Change `json:"changes"`
+		Count   int               `json:"count"`
+		Next    int64             `json:"next"`
+	}
+
+	w := doJSON(t, mux, "GET", changesPath+"?since=0&limit=2", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page feed
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Count != 2 || page.Changes[0].Op != "create" || page.Changes[1].Op != "update" || page.Changes[1].Version != 2 {
+		t.Fatalf("Unexpected first page: %+v", page)
+	}
+	if page.Changes[0].Actor == "" || page.Next != page.Changes[1].Seq {
+		t.Errorf("Unexpected actor or next: %+v", page)
+	}
+
+	w = doJSON(t, mux, "GET", changesPath+"?since="+strconv.FormatInt(page.Next, 10), token, nil)
+	var rest feed
+	json.NewDecoder(w.Body).Decode(&rest)
+	if rest.Count != 1 || rest.Changes[0].Op != "delete" || rest.Changes[0].ID != "notes/a" {
+		t.Fatalf("Unexpected second page: %+v", rest)
+	}
+
+	// Polling at the end returns nothing and keeps the cursor
+	w = doJSON(t, mux, "GET", changesPath+"?since="+strconv.FormatInt(rest.Next, 10), token, nil)
+	var empty feed
+	json.NewDecoder(w.Body).Decode(&empty)
+	if empty.Count != 0 || empty.Next != rest.Next || empty.Changes == nil {
+		t.Errorf("Unexpected empty page: %+v", empty)
+	}
+
+	if w := doJSON(t, mux, "GET", changesPath+"?since=yesterday", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad since, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", changesPath, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+}
+
+func TestDocumentOrderAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("POST /{cenvID}/documents/reorder", srv.handleReorderDocuments)
+	mux.HandleFunc("POST /{cenvID}/documents/{docID...}", srv.handleDocumentAction)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for _, id := range []string{"menu/a", "menu/b", "menu/c"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "item " + id, "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	list := func(query string) []string {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?prefix=menu/"+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Documents []document.Document `json:"documents"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var ids []string
+		for _, doc := range resp.Documents {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents/reorder", token, map[string]interface{}{
+		"prefix": "menu/", "ids": []string{"menu/c", "other/x"},
+	}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an id outside the prefix, got %d", w.Code)
+	}
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents/reorder", token, map[string]interface{}{
+		"prefix": "menu/", "ids": []string{"menu/c", "menu/a"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected reorder to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents/menu/b/pin", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected pin to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if ids := list("&sort=position"); strings.Join(ids, ",") != "menu/b,menu/c,menu/a" {
+		t.Errorf("Unexpected position order: %v", ids)
+	}
+	if ids := list(""); strings.Join(ids, ",") != "menu/a,menu/b,menu/c" {
+		t.Errorf("Expected id order by default, got %v", ids)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?sort=size", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown sort, got %d", w.Code)
+	}
+
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents/menu/b/unpin", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected unpin to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if ids := list("&sort=position"); strings.Join(ids, ",") != "menu/c,menu/a,menu/b" {
+		t.Errorf("Unexpected order after unpinning: %v", ids)
+	}
+}
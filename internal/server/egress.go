@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/netip"
+
+	"github.com/thetanil/wce/internal/alerts"
+	"github.com/thetanil/wce/internal/egress"
+	"github.com/thetanil/wce/internal/proxy"
+)
+
+// SetEgressAllowlist lets proxy routes, alert webhooks and the Starlark http
+// module reach the given internal addresses, e.g. a partner API on the
+// private network. Other loopback, private, link-local and unspecified
+// addresses stay blocked.
+func (s *Server) SetEgressAllowlist(prefixes ...netip.Prefix) {
+	guard := egress.NewGuard(prefixes...)
+	s.proxyClient = proxy.NewClient(guard)
+	s.monitor.Client = alerts.NewWebhookClient(guard)
+}
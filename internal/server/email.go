@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/mail"
+)
+
+// mailTimeout bounds the delivery of one email
+const mailTimeout = time.Minute
+
+// resetMailInterval is the least time between emailed resets for a user, so
+// the forgot password form cannot be used to flood an inbox
+const resetMailInterval = time.Minute
+
+// SetMailer sets the mailer used for password reset and email verification
+// messages and the Starlark mail module, such as a mail.SMTPMailer. Without
+// one, none of them is available.
+func (s *Server) SetMailer(mailer mail.Mailer) {
+	s.mailer = mailer
+}
+
+// SetPublicURL sets the base URL of the server, e.g. https://wce.example.com,
+// that links in emails point to. Without it, emails carry paths only: the
+// request's Host header is never trusted for links that carry tokens.
+func (s *Server) SetPublicURL(publicURL string) {
+	s.publicURL = strings.TrimSuffix(publicURL, "/")
+}
+
+// SetEmailVerification makes new cenvs require an owner email, and refuses
+// the owner's logins until the address is verified. It needs a mailer.
+func (s *Server) SetEmailVerification(required bool) {
+	s.requireEmailVerification = required
+}
+
+// ForgotPasswordRequest names the account to send a reset email for
+type ForgotPasswordRequest struct {
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// cenvLink returns the URL of a path in a cenv for use in emails
+func (s *Server) cenvLink(cenvID, path string) string {
+	return s.publicURL + "/" + cenvID + path
+}
+
+// sendMail delivers a message in the background, so responses neither wait
+// for the mail server nor reveal by their timing whether one was sent
+func (s *Server) sendMail(msg *mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %q: %v", msg.Subject, err)
+		}
+	}()
+}
+
+// sendEmailVerification issues a verification token for a user's address
+// and mails them the link that uses it
+func (s *Server) sendEmailVerification(db *sql.DB, cenvID string, user *auth.User, required bool) error {
+	token, err := auth.CreateEmailVerification(db, user.UserID, user.Email, required, auth.DefaultVerificationTimeout)
+	if err != nil {
+		return err
+	}
+	s.sendMail(&mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(`Hello %s,
+
+Open this link to verify your email address for cenv %s:
+
+%s
+
+The link expires in %d hours. If you did not expect this email, you can ignore it.
+`, user.Username, cenvID, s.cenvLink(cenvID, "/email/verify?token="+url.QueryEscape(token)),
+			int(auth.DefaultVerificationTimeout/time.Hour)),
+	})
+	return nil
+}
+
+// awaitingEmailVerification reports whether a user must verify their email
+// address before logging in. An expired verification link is replaced by a
+// new one, so the attempt to log in is how a user asks for another.
+func (s *Server) awaitingEmailVerification(db *sql.DB, cenvID string, user *auth.User) bool {
+	pending, expired, err := auth.PendingEmailVerification(db, user.UserID)
+	if err != nil {
+		log.Printf("Failed to check email verification of user %s: %v", user.UserID, err)
+		return true
+	}
+	if pending && expired && s.mailer != nil && user.Email != "" {
+		if err := s.sendEmailVerification(db, cenvID, user, true); err != nil {
+			log.Printf("Failed to resend email verification to user %s: %v", user.UserID, err)
+		}
+	}
+	return pending
+}
+
+// sendPasswordReset issues a reset token for a user with a verified address
+// and mails it to them, unless one was mailed within resetMailInterval
+func (s *Server) sendPasswordReset(db *sql.DB, cenvID string, user *auth.User) error {
+	verified, err := auth.IsEmailVerified(db, user)
+	if err != nil || !verified {
+		return err
+	}
+	last, err := auth.LastPasswordReset(db, user.UserID)
+	if err != nil {
+		return err
+	}
+	if clock.Now().Unix()-last < int64(resetMailInterval/time.Second) {
+		return nil
+	}
+
+	token, _, err := auth.CreatePasswordReset(db, user.UserID, user.UserID, auth.DefaultResetTimeout)
+	if err != nil {
+		return err
+	}
+	s.sendMail(&mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(`Hello %s,
+
+A password reset was requested for your account in cenv %s.
+Your reset code is:
+
+%s
+
+Send it with your new password to POST %s as
+{"token": "<code>", "new_password": "<new password>"}.
+The code works once and expires in %d minutes. Resetting signs you out
+everywhere. If you did not ask for this, ignore this email and your
+password stays the same.
+`, user.Username, cenvID, token, s.cenvLink(cenvID, "/password/reset"),
+			int(auth.DefaultResetTimeout/time.Minute)),
+	})
+	return nil
+}
+
+// handleForgotPassword emails a password reset code to the account named by
+// username or email, when its address is verified. The response is the same
+// whether or not an email was sent, so it does not reveal which accounts exist.
+// Route: POST /{cenvID}/password/forgot
+func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+	if s.mailer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "email is not configured on this server; ask an admin to reset your password",
+		})
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Username == "") == (req.Email == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "one of username or email is required",
+		})
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		log.Printf("Failed to connect to cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to connect to database",
+		})
+		return
+	}
+
+	var users []auth.User
+	if req.Username != "" {
+		if user, err := auth.GetUserByUsername(db, req.Username); err == nil && user.Enabled {
+			users = append(users, *user)
+		}
+	} else if users, err = auth.FindUsersByEmail(db, req.Email); err != nil {
+		log.Printf("Failed to look up users by email in cenv %s: %v", cenvID, err)
+	}
+	for i := range users {
+		if err := s.sendPasswordReset(db, cenvID, &users[i]); err != nil {
+			log.Printf("Failed to send password reset to user %s in cenv %s: %v", users[i].UserID, cenvID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "if the account exists and has a verified email address, a reset code was sent to it",
+	})
+}
+
+// handleRequestEmailVerification mails the caller a link verifying their
+// email address, which emailed password resets need
+// Route: POST /{cenvID}/me/email/verify
+func (s *Server) handleRequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+	if s.mailer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "email is not configured on this server"})
+		return
+	}
+
+	user, err := auth.GetUserByID(db, userID)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	if user.Email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "your account has no email address"})
+		return
+	}
+	if verified, err := auth.IsEmailVerified(db, user); err == nil && verified {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "email address already verified"})
+		return
+	}
+
+	if err := s.sendEmailVerification(db, cenvID, user, false); err != nil {
+		log.Printf("Failed to send email verification to user %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to send verification email"})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "verification link sent to " + user.Email,
+	})
+}
+
+// handleVerifyEmail verifies an email address with the token from a
+// verification link
+// Route: GET /{cenvID}/email/verify?token=
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "token is required"})
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		log.Printf("Failed to connect to cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to connect to database",
+		})
+		return
+	}
+
+	user, err := auth.VerifyEmail(db, token)
+	if err == auth.ErrInvalidVerificationToken {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to verify email in cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to verify email"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "email address verified",
+		"username": user.Username,
+		"email":    user.Email,
+	})
+}
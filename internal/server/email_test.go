@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/mail"
+)
+
+// mailerFunc is a Mailer calling a function, for tests
+type mailerFunc func(ctx context.Context, msg *mail.Message) error
+
+func (f mailerFunc) Send(ctx context.Context, msg *mail.Message) error {
+	return f(ctx, msg)
+}
+
+// expectMail waits for the next message sent to the outbox
+func expectMail(t *testing.T, outbox chan *mail.Message) *mail.Message {
+	t.Helper()
+	select {
+	case msg := <-outbox:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an email to be sent")
+		return nil
+	}
+}
+
+// expectNoMail checks that nothing is sent to the outbox for a short while
+func expectNoMail(t *testing.T, outbox chan *mail.Message) {
+	t.Helper()
+	select {
+	case msg := <-outbox:
+		t.Errorf("Expected no email, got %q to %s", msg.Subject, msg.To)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEmailVerificationAndForgotPassword(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/password/forgot", srv.handleForgotPassword)
+	mux.HandleFunc("POST /{cenvID}/password/reset", srv.handleResetPassword)
+	mux.HandleFunc("POST /{cenvID}/me/email/verify", srv.handleRequestEmailVerification)
+	mux.HandleFunc("GET /{cenvID}/email/verify", srv.handleVerifyEmail)
+
+	// Without a mailer there is nothing to send resets with
+	cenvID, _ := setupTestCenv(t, mux)
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/password/forgot", "", map[string]string{"username": "admin"}); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected forgot password unavailable without a mailer, got %d", w.Code)
+	}
+
+	outbox := make(chan *mail.Message, 10)
+	srv.SetMailer(mailerFunc(func(ctx context.Context, msg *mail.Message) error {
+		outbox <- msg
+		return nil
+	}))
+	srv.SetPublicURL("https://wce.example.com/")
+	srv.SetEmailVerification(true)
+
+	// New cenvs need an owner email, verified before the owner logs in
+	creds := map[string]string{"username": "owner", "password": "ownerpass123"}
+	if w := doJSON(t, mux, "POST", "/new", "", creds); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a cenv without an owner email refused, got %d", w.Code)
+	}
+	creds["email"] = "owner@example.com"
+	w := doJSON(t, mux, "POST", "/new", "", creds)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create cenv: %d %s", w.Code, w.Body.String())
+	}
+	var created NewCenvResponse
+	json.NewDecoder(w.Body).Decode(&created)
+	if !created.VerifyEmail {
+		t.Error("Expected the response to ask for email verification")
+	}
+	cenvID = created.CenvID
+
+	msg := expectMail(t, outbox)
+	link := regexp.MustCompile(`https://wce\.example\.com/\S+`).FindString(msg.Body)
+	if msg.To != "owner@example.com" || !strings.HasPrefix(link, "https://wce.example.com/"+cenvID+"/email/verify?token=") {
+		t.Fatalf("Unexpected verification email to %s: %s", msg.To, msg.Body)
+	}
+	login := map[string]string{"username": "owner", "password": "ownerpass123"}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", login); w.Code != http.StatusForbidden {
+		t.Errorf("Expected login refused before verification, got %d", w.Code)
+	}
+
+	verify, _ := url.Parse(link)
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/email/verify?token=wrong", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown token refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", verify.RequestURI(), "", nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to verify email: %d %s", w.Code, w.Body.String())
+	}
+	ownerToken := loginAs(t, mux, cenvID, "owner", "ownerpass123")
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/me/email/verify", ownerToken, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected a verified address reported, got %d", w.Code)
+	}
+
+	// Resets go only to verified addresses, with the same response either way
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := auth.CreateUser(db, "bob", "bobpass123", "editor", "bob@example.com", ""); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	for _, body := range []map[string]string{{"username": "bob"}, {"username": "nobody"}, {"email": "nobody@example.com"}} {
+		if w := doJSON(t, mux, "POST", "/"+cenvID+"/password/forgot", "", body); w.Code != http.StatusAccepted {
+			t.Errorf("Expected %v accepted, got %d", body, w.Code)
+		}
+	}
+	expectNoMail(t, outbox)
+
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/password/forgot", "", map[string]string{"email": "OWNER@example.com"}); w.Code != http.StatusAccepted {
+		t.Fatalf("Failed to request a reset: %d %s", w.Code, w.Body.String())
+	}
+	msg = expectMail(t, outbox)
+	code := regexp.MustCompile(`(?m)^([0-9a-f]{64})$`).FindString(msg.Body)
+	if msg.To != "owner@example.com" || code == "" {
+		t.Fatalf("Unexpected reset email to %s: %s", msg.To, msg.Body)
+	}
+
+	// Another request straight away sends nothing
+	doJSON(t, mux, "POST", "/"+cenvID+"/password/forgot", "", map[string]string{"username": "owner"})
+	expectNoMail(t, outbox)
+
+	body := map[string]string{"token": code, "new_password": "ownernewpass1"}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/password/reset", "", body); w.Code != http.StatusOK {
+		t.Fatalf("Failed to reset password: %d %s", w.Code, w.Body.String())
+	}
+	loginAs(t, mux, cenvID, "owner", "ownernewpass1")
+}
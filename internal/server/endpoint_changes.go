@@ -0,0 +1,497 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/diff"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// Endpoint change review states
+const (
+	ChangeStatusPending  = "pending"
+	ChangeStatusApproved = "approved"
+	ChangeStatusRejected = "rejected"
+)
+
+// EndpointChange is a proposed endpoint create or update awaiting review
+type EndpointChange struct {
+	ID             int64           `json:"id"`
+	Path           string          `json:"path"`
+	Method         string          `json:"method"`
+	Script         string          `json:"script,omitempty"`
+	Description    string          `json:"description,omitempty"`
+	RequestSchema  json.RawMessage `json:"request_schema,omitempty"`
+	ResponseSchema json.RawMessage `json:"response_schema,omitempty"`
+	Capabilities   []string        `json:"capabilities"` // nil = keep current grant
+	BaseScript     *string         `json:"-"`
+	Status         string          `json:"status"`
+	SubmittedBy    string          `json:"submitted_by"`
+	SubmittedAt    int64           `json:"submitted_at"`
+	ReviewedBy     string          `json:"reviewed_by,omitempty"`
+	ReviewedAt     int64           `json:"reviewed_at,omitempty"`
+	ReviewComment  string          `json:"review_comment,omitempty"`
+}
+
+// canReviewEndpoints reports whether a role may approve endpoint changes
+func canReviewEndpoints(role string) bool {
+	return role == authz.RoleOwner || role == authz.RoleAdmin
+}
+
+// getEndpointChange loads a proposed change by ID
+func getEndpointChange(db *sql.DB, changeID string) (*EndpointChange, error) {
+	var c EndpointChange
+	var description, requestSchema, responseSchema, capabilities, baseScript sql.NullString
+	var reviewedBy, reviewComment sql.NullString
+	var reviewedAt sql.NullInt64
+
+	err := db.QueryRow(`
+		SELECT id, path, method, script, description, request_schema, response_schema, capabilities,
+		       base_script, status, submitted_by, submitted_at, reviewed_by, reviewed_at, review_comment
+		FROM _wce_endpoint_changes
+		WHERE id = ?
+	`, changeID).Scan(
+		&c.ID, &c.Path, &c.Method, &c.Script, &description, &requestSchema, &responseSchema, &capabilities,
+		&baseScript, &c.Status, &c.SubmittedBy, &c.SubmittedAt, &reviewedBy, &reviewedAt, &reviewComment,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Description = description.String
+	if requestSchema.Valid {
+		c.RequestSchema = json.RawMessage(requestSchema.String)
+	}
+	if responseSchema.Valid {
+		c.ResponseSchema = json.RawMessage(responseSchema.String)
+	}
+	if capabilities.Valid {
+		c.Capabilities = decodeCapabilities(capabilities.String)
+	}
+	if baseScript.Valid {
+		c.BaseScript = &baseScript.String
+	}
+	c.ReviewedBy = reviewedBy.String
+	c.ReviewedAt = reviewedAt.Int64
+	c.ReviewComment = reviewComment.String
+
+	return &c, nil
+}
+
+// liveEndpointScript returns the current script for a path and method, or nil if none exists
+func liveEndpointScript(db *sql.DB, path, method string) (*string, error) {
+	var script string
+	err := db.QueryRow(`SELECT script FROM _wce_endpoints WHERE path = ? AND method = ?`, path, method).Scan(&script)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
+
+// handleSubmitEndpointChange proposes an endpoint create or update for review (editor and above)
+// Route: POST /{cenvID}/admin/endpoint-changes
+func (s *Server) handleSubmitEndpointChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	if role == authz.RoleViewer {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "viewers cannot propose endpoint changes",
+		})
+		return
+	}
+
+	var req struct {
+		Path           string          `json:"path"`
+		Method         string          `json:"method"`
+		Script         string          `json:"script"`
+		Description    string          `json:"description"`
+		RequestSchema  json.RawMessage `json:"request_schema"`
+		ResponseSchema json.RawMessage `json:"response_schema"`
+		Capabilities   *[]string       `json:"capabilities"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if req.Path == "" || req.Method == "" || req.Script == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "path, method, and script are required",
+		})
+		return
+	}
+
+	lint := starlark_pkg.Lint(req.Script)
+	if !lint.OK() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "script failed static checks",
+			"errors":   lint.Errors,
+			"warnings": lint.Warnings,
+		})
+		return
+	}
+
+	requestSchema, err := schemaParam(req.RequestSchema)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "request_schema: " + err.Error()})
+		return
+	}
+	responseSchema, err := schemaParam(req.ResponseSchema)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "response_schema: " + err.Error()})
+		return
+	}
+
+	var capabilities interface{}
+	if req.Capabilities != nil {
+		caps, err := starlark_pkg.ParseCapabilities(*req.Capabilities)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		capabilities = caps.Encode()
+	}
+
+	baseScript, err := liveEndpointScript(db, req.Path, req.Method)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO _wce_endpoint_changes (path, method, script, description, request_schema, response_schema,
+			capabilities, base_script, status, submitted_by, submitted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.Path, req.Method, req.Script, req.Description, requestSchema, responseSchema,
+		capabilities, baseScript, ChangeStatusPending, userID, time.Now().Unix())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to submit change"})
+		return
+	}
+
+	changeID, _ := result.LastInsertId()
+
+	resp := map[string]interface{}{
+		"id":     changeID,
+		"status": ChangeStatusPending,
+	}
+	if len(lint.Warnings) > 0 {
+		resp["warnings"] = lint.Warnings
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleListEndpointChanges lists proposed changes; editors only see their own
+// Route: GET /{cenvID}/admin/endpoint-changes?status=
+func (s *Server) handleListEndpointChanges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	query := `
+		SELECT id, path, method, status, submitted_by, submitted_at, reviewed_by, reviewed_at
+		FROM _wce_endpoint_changes
+		WHERE 1 = 1
+	`
+	args := []interface{}{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	if !canReviewEndpoints(role) {
+		query += " AND submitted_by = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY id DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to list changes"})
+		return
+	}
+	defer rows.Close()
+
+	changes := []EndpointChange{}
+	for rows.Next() {
+		var c EndpointChange
+		var reviewedBy sql.NullString
+		var reviewedAt sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Path, &c.Method, &c.Status, &c.SubmittedBy, &c.SubmittedAt, &reviewedBy, &reviewedAt); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "failed to list changes"})
+			return
+		}
+		c.ReviewedBy = reviewedBy.String
+		c.ReviewedAt = reviewedAt.Int64
+		changes = append(changes, c)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+		"count":   len(changes),
+	})
+}
+
+// handleGetEndpointChange returns a proposed change with a diff against the live script
+// Route: GET /{cenvID}/admin/endpoint-changes/{changeID}
+func (s *Server) handleGetEndpointChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	change, err := getEndpointChange(db, r.PathValue("changeID"))
+	if err == sql.ErrNoRows || (err == nil && !canReviewEndpoints(role) && change.SubmittedBy != userID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "change not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+
+	live, err := liveEndpointScript(db, change.Path, change.Method)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+
+	liveScript := ""
+	if live != nil {
+		liveScript = *live
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"change": change,
+		"diff":   diff.Unified("live", "proposed", liveScript, change.Script, 3),
+		"stale":  change.Status == ChangeStatusPending && isStaleChange(change, live),
+	})
+}
+
+// isStaleChange reports whether the live endpoint changed since the proposal was submitted
+func isStaleChange(change *EndpointChange, live *string) bool {
+	if change.BaseScript == nil || live == nil {
+		return (change.BaseScript == nil) != (live == nil)
+	}
+	return *change.BaseScript != *live
+}
+
+// handleReviewEndpointChange approves or rejects a proposed change (admin/owner only).
+// Approving a change whose endpoint was modified after submission requires "force".
+// Route: POST /{cenvID}/admin/endpoint-changes/{changeID}
+func (s *Server) handleReviewEndpointChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "review endpoint changes")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "review_endpoint_change", "endpoint_change", r.PathValue("changeID")); err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Action  string `json:"action"` // "approve" or "reject"
+		Comment string `json:"comment"`
+		Force   bool   `json:"force"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if req.Action != "approve" && req.Action != "reject" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "action must be 'approve' or 'reject'",
+		})
+		return
+	}
+
+	change, err := getEndpointChange(db, r.PathValue("changeID"))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "change not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+
+	if change.Status != ChangeStatusPending {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "change already " + change.Status,
+		})
+		return
+	}
+
+	if req.Action == "approve" && !req.Force {
+		live, err := liveEndpointScript(db, change.Path, change.Method)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+			return
+		}
+		if isStaleChange(change, live) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "endpoint was modified after this change was submitted; review the diff and approve with force",
+			})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	status := ChangeStatusRejected
+	if req.Action == "approve" {
+		status = ChangeStatusApproved
+
+		if err := applyEndpointChange(tx, change); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "failed to apply change"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE _wce_endpoint_changes
+		SET status = ?, reviewed_by = ?, reviewed_at = ?, review_comment = ?
+		WHERE id = ? AND status = ?
+	`, status, userID, time.Now().Unix(), nullString(req.Comment), change.ID, ChangeStatusPending)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+		return
+	}
+
+	log.Printf("Endpoint change %d (%s %s) %s by %s", change.ID, change.Method, change.Path, status, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     change.ID,
+		"status": status,
+	})
+}
+
+// applyEndpointChange writes an approved change to the live endpoint.
+// The submitter is recorded as the author; an existing endpoint keeps its enabled state.
+func applyEndpointChange(tx *sql.Tx, change *EndpointChange) error {
+	enabled := true
+	err := tx.QueryRow(`SELECT enabled FROM _wce_endpoints WHERE path = ? AND method = ?`,
+		change.Path, change.Method).Scan(&enabled)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	ew := &endpointWrite{
+		Path:             change.Path,
+		Method:           change.Method,
+		Script:           change.Script,
+		Description:      change.Description,
+		KeepCapabilities: change.Capabilities == nil,
+		Enabled:          enabled,
+		UserID:           change.SubmittedBy,
+	}
+	if change.RequestSchema != nil {
+		ew.RequestSchema = string(change.RequestSchema)
+	}
+	if change.ResponseSchema != nil {
+		ew.ResponseSchema = string(change.ResponseSchema)
+	}
+	if change.Capabilities != nil {
+		ew.Capabilities, err = starlark_pkg.ParseCapabilities(change.Capabilities)
+		if err != nil {
+			return err
+		}
+	}
+
+	return saveEndpoint(tx, ew)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestEndpointChangeReview(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoint-changes", srv.handleListEndpointChanges)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoint-changes", srv.handleSubmitEndpointChange)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoint-changes/{changeID}", srv.handleGetEndpointChange)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoint-changes/{changeID}", srv.handleReviewEndpointChange)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path":   "/hello",
+		"method": "GET",
+		"script": "def handle_request(req):\n    return response(\"v1\")",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	submit := func(script string) int64 {
+		t.Helper()
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoint-changes", editorToken, map[string]interface{}{
+			"path":   "/hello",
+			"method": "GET",
+			"script": script,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to submit change: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return int64(resp["id"].(float64))
+	}
+
+	review := func(changeID int64, body map[string]interface{}) *http.Response {
+		t.Helper()
+		w := doJSON(t, mux, "POST", fmt.Sprintf("/%s/admin/endpoint-changes/%d", cenvID, changeID), token, body)
+		return w.Result()
+	}
+
+	callHello := func() string {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/star/hello", token, nil)
+		return strings.TrimSpace(w.Body.String())
+	}
+
+	var changeID int64
+
+	t.Run("SubmitDoesNotGoLive", func(t *testing.T) {
+		changeID = submit("def handle_request(req):\n    return response(\"v2\")")
+
+		if got := callHello(); !strings.Contains(got, "v1") {
+			t.Errorf("Expected live endpoint unchanged, got %s", got)
+		}
+	})
+
+	t.Run("EditorCannotApprove", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", fmt.Sprintf("/%s/admin/endpoint-changes/%d", cenvID, changeID), editorToken,
+			map[string]string{"action": "approve"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("DiffView", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", fmt.Sprintf("/%s/admin/endpoint-changes/%d", cenvID, changeID), token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		d, _ := resp["diff"].(string)
+		if !strings.Contains(d, `-    return response("v1")`) || !strings.Contains(d, `+    return response("v2")`) {
+			t.Errorf("Unexpected diff:\n%s", d)
+		}
+		if resp["stale"] != false {
+			t.Errorf("Expected change not to be stale")
+		}
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		if resp := review(changeID, map[string]interface{}{"action": "approve"}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+
+		if got := callHello(); !strings.Contains(got, "v2") {
+			t.Errorf("Expected approved script to be live, got %s", got)
+		}
+
+		if resp := review(changeID, map[string]interface{}{"action": "approve"}); resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected 409 re-reviewing, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		rejected := submit("def handle_request(req):\n    return response(\"bad\")")
+
+		if resp := review(rejected, map[string]interface{}{"action": "reject", "comment": "no"}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		if got := callHello(); !strings.Contains(got, "v2") {
+			t.Errorf("Expected rejected script not to go live, got %s", got)
+		}
+	})
+
+	t.Run("StaleRequiresForce", func(t *testing.T) {
+		stale := submit("def handle_request(req):\n    return response(\"v3\")")
+
+		// Another change lands first
+		other := submit("def handle_request(req):\n    return response(\"v4\")")
+		review(other, map[string]interface{}{"action": "approve"})
+
+		if resp := review(stale, map[string]interface{}{"action": "approve"}); resp.StatusCode != http.StatusConflict {
+			t.Fatalf("Expected 409 for stale change, got %d", resp.StatusCode)
+		}
+		if resp := review(stale, map[string]interface{}{"action": "approve", "force": true}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected forced approval, got %d", resp.StatusCode)
+		}
+		if got := callHello(); !strings.Contains(got, "v3") {
+			t.Errorf("Expected forced script to be live, got %s", got)
+		}
+	})
+
+	t.Run("EditorListsOwnChanges", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/endpoint-changes?status=pending", editorToken, nil)
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["count"] != float64(0) {
+			t.Errorf("Expected no pending changes, got %v", resp["count"])
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/config"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// maxLogEntriesPerRequest caps how many entries a single execution may store
+const maxLogEntriesPerRequest = 100
+
+// validRequestID matches client-supplied request IDs that are safe to echo and store
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// EndpointLogEntry is a stored log entry emitted by an endpoint script
+type EndpointLogEntry struct {
+	ID         int64                  `json:"id"`
+	EndpointID int64                  `json:"endpoint_id"`
+	RequestID  string                 `json:"request_id"`
+	Level      string                 `json:"level"`
+	Message    string                 `json:"message"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+	CreatedAt  int64                  `json:"created_at"`
+}
+
+// requestID returns the id recoveryMiddleware gave the request, else the
+// client's X-Request-Id if well-formed, otherwise a new random ID
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	if id := r.Header.Get("X-Request-Id"); validRequestID.MatchString(id) {
+		return id
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newEndpointLogger returns a log sink that stores entries for one execution.
+// Entries below the cenv's endpoint_log_level are dropped, as is anything past
+// maxLogEntriesPerRequest.
+func newEndpointLogger(db *sql.DB, endpointID int64, reqID string) func(starlark_pkg.LogEntry) error {
+	minLevel := config.GetString(db, "endpoint_log_level", starlark_pkg.LogLevelInfo)
+	count := 0
+
+	return func(entry starlark_pkg.LogEntry) error {
+		if !starlark_pkg.LogLevelEnabled(minLevel, entry.Level) {
+			return nil
+		}
+
+		count++
+		if count > maxLogEntriesPerRequest {
+			return nil
+		}
+		if count == maxLogEntriesPerRequest {
+			entry = starlark_pkg.LogEntry{
+				Level:   starlark_pkg.LogLevelWarn,
+				Message: "log entry limit reached; further entries dropped",
+			}
+		}
+
+		var fields interface{}
+		if len(entry.Fields) > 0 {
+			data, err := json.Marshal(entry.Fields)
+			if err != nil {
+				return err
+			}
+			fields = string(data)
+		}
+
+		_, err := db.Exec(`
+			INSERT INTO _wce_endpoint_logs (endpoint_id, request_id, level, message, fields, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, endpointID, reqID, entry.Level, entry.Message, fields, time.Now().Unix())
+		if err != nil {
+			log.Printf("Failed to store log entry for endpoint %d: %v", endpointID, err)
+		}
+
+		return nil
+	}
+}
+
+// handleListEndpointLogs lists log entries for an endpoint (admin/owner only)
+// Route: GET /{cenvID}/admin/endpoints/{endpointID}/logs?request_id=&level=&limit=
+func (s *Server) handleListEndpointLogs(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	endpointID := r.PathValue("endpointID")
+
+	if !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, role, err := s.authenticateAndAuthorize(r, cenvID, []string{"admin", "owner"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if role == "" {
+		http.Error(w, "Only admin or owner can view endpoint logs", http.StatusForbidden)
+		return
+	}
+
+	query := `
+		SELECT id, endpoint_id, request_id, level, message, fields, created_at
+		FROM _wce_endpoint_logs
+		WHERE endpoint_id = ?
+	`
+	args := []interface{}{endpointID}
+
+	if reqID := r.URL.Query().Get("request_id"); reqID != "" {
+		query += " AND request_id = ?"
+		args = append(args, reqID)
+	}
+
+	if level := r.URL.Query().Get("level"); level != "" {
+		if !starlark_pkg.IsValidLogLevel(level) {
+			http.Error(w, "level must be 'debug', 'info', 'warn', or 'error'", http.StatusBadRequest)
+			return
+		}
+		levels := []interface{}{}
+		for _, l := range []string{starlark_pkg.LogLevelDebug, starlark_pkg.LogLevelInfo, starlark_pkg.LogLevelWarn, starlark_pkg.LogLevelError} {
+			if starlark_pkg.LogLevelEnabled(level, l) {
+				levels = append(levels, l)
+			}
+		}
+		query += " AND level IN (?" + strings.Repeat(", ?", len(levels)-1) + ")"
+		args = append(args, levels...)
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []EndpointLogEntry{}
+	for rows.Next() {
+		var e EndpointLogEntry
+		var fields sql.NullString
+		if err := rows.Scan(&e.ID, &e.EndpointID, &e.RequestID, &e.Level, &e.Message, &fields, &e.CreatedAt); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if fields.Valid {
+			json.Unmarshal([]byte(fields.String), &e.Fields)
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+)
+
+func TestEndpointLogs(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints", srv.handleListEndpoints)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}/logs", srv.handleListEndpointLogs)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	script := "def handle_request(req):\n" +
+		"    log.debug(\"hidden by default\")\n" +
+		"    log.info(\"looking up\", id=req.query.get(\"id\"))\n" +
+		"    if req.query.get(\"fail\"):\n" +
+		"        fail(\"lookup failed\")\n" +
+		"    return response(\"ok\")"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path": "/lookup", "method": "GET", "script": script,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/endpoints", token, nil)
+	var endpoints []Endpoint
+	json.NewDecoder(w.Body).Decode(&endpoints)
+	logsPath := fmt.Sprintf("/%s/admin/endpoints/%d/logs", cenvID, endpoints[0].ID)
+
+	listLogs := func(query string) []EndpointLogEntry {
+		t.Helper()
+		w := doJSON(t, mux, "GET", logsPath+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to list logs: %d %s", w.Code, w.Body.String())
+		}
+		var entries []EndpointLogEntry
+		json.NewDecoder(w.Body).Decode(&entries)
+		return entries
+	}
+
+	t.Run("CorrelatedWithRequestID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/"+cenvID+"/star/lookup?id=42", nil)
+		req.Header.Set("X-Request-Id", "req-abc")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Header().Get("X-Request-Id") != "req-abc" {
+			t.Errorf("Expected request ID echoed, got %q", w.Header().Get("X-Request-Id"))
+		}
+
+		entries := listLogs("?request_id=req-abc")
+		if len(entries) != 1 {
+			t.Fatalf("Expected 1 entry (debug filtered), got %d", len(entries))
+		}
+		if entries[0].Message != "looking up" || entries[0].Fields["id"] != "42" {
+			t.Errorf("Unexpected entry: %+v", entries[0])
+		}
+	})
+
+	t.Run("GeneratedRequestID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/"+cenvID+"/star/lookup", nil)
+		req.Header.Set("X-Request-Id", "bad id with spaces")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		id := w.Header().Get("X-Request-Id")
+		if id == "" || id == "bad id with spaces" {
+			t.Errorf("Expected generated request ID, got %q", id)
+		}
+	})
+
+	t.Run("ScriptErrorsAreLogged", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/star/lookup?fail=1", "", nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", w.Code)
+		}
+
+		entries := listLogs("?level=error&request_id=" + w.Header().Get("X-Request-Id"))
+		if len(entries) != 1 {
+			t.Fatalf("Expected 1 error entry, got %d", len(entries))
+		}
+	})
+
+	t.Run("DebugLevel", func(t *testing.T) {
+		db, _ := manager.GetConnection(cenvID)
+		config.Set(db, "endpoint_log_level", "debug", "")
+
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/star/lookup", "", nil)
+		entries := listLogs("?request_id=" + w.Header().Get("X-Request-Id"))
+		if len(entries) != 2 {
+			t.Errorf("Expected debug entry to be stored, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("InvalidLevel", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", logsPath+"?level=verbose", token, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+}
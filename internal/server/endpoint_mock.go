@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// handleSetEndpointMock enables, updates or disables an endpoint's mock response
+// Route: PUT /{cenvID}/admin/endpoints/{endpointID}/mock
+func (s *Server) handleSetEndpointMock(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	endpointID := r.PathValue("endpointID")
+
+	// Validate cenv exists
+	if !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	// Authenticate and authorize (admin/owner only)
+	db, role, err := s.authenticateAndAuthorize(r, cenvID, []string{"admin", "owner"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if role == "" {
+		http.Error(w, "Only admin or owner can configure endpoint mocks", http.StatusForbidden)
+		return
+	}
+
+	identity, _ := s.identify(r, cenvID)
+	if err := s.verifyAdminRequest(w, r, db, identity.UserID, "set_endpoint_mock", "endpoint", endpointID); err != nil {
+		return // Response already sent
+	}
+
+	var req EndpointMock
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status == 0 {
+		req.Status = http.StatusOK
+	}
+	if req.Status < 100 || req.Status > 599 {
+		http.Error(w, "status must be a valid HTTP status code", http.StatusBadRequest)
+		return
+	}
+
+	var mockBody interface{}
+	if len(req.Body) > 0 {
+		mockBody = string(req.Body)
+	}
+
+	var contentType interface{}
+	if req.ContentType != "" {
+		contentType = req.ContentType
+	}
+
+	result, err := db.Exec(`
+		UPDATE _wce_endpoints
+		SET mock_enabled = ?, mock_status = ?, mock_body = ?, mock_content_type = ?, modified_at = ?
+		WHERE id = ?
+	`, req.Enabled, req.Status, mockBody, contentType, time.Now().Unix(), endpointID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+
+	message := "Endpoint mock disabled"
+	if req.Enabled {
+		message = "Endpoint mock enabled"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+	})
+}
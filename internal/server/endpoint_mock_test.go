@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestEndpointMock(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints", srv.handleListEndpoints)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}", srv.handleGetEndpoint)
+	mux.HandleFunc("PUT /{cenvID}/admin/endpoints/{endpointID}/mock", srv.handleSetEndpointMock)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	// Script that is not finished yet
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path":   "/orders",
+		"method": "GET",
+		"script": "def handle_request(req):\n    fail(\"not implemented\")",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/endpoints", token, nil)
+	var endpoints []Endpoint
+	json.NewDecoder(w.Body).Decode(&endpoints)
+	if len(endpoints) != 1 {
+		t.Fatalf("Expected 1 endpoint, got %d", len(endpoints))
+	}
+	mockPath := fmt.Sprintf("/%s/admin/endpoints/%d/mock", cenvID, endpoints[0].ID)
+
+	t.Run("ScriptRunsWithoutMock", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/star/orders", token, nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected 500 from unfinished script, got %d", w.Code)
+		}
+	})
+
+	t.Run("EnableMock", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", mockPath, token, map[string]interface{}{
+			"enabled": true,
+			"status":  202,
+			"body":    map[string]interface{}{"orders": []int{1, 2}},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "GET", "/"+cenvID+"/star/orders", token, nil)
+		if w.Code != 202 {
+			t.Fatalf("Expected mock status 202, got %d: %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get("X-WCE-Mock") != "true" {
+			t.Error("Expected X-WCE-Mock header")
+		}
+
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if orders, ok := resp["orders"].([]interface{}); !ok || len(orders) != 2 {
+			t.Errorf("Expected mock body, got %v", resp)
+		}
+	})
+
+	t.Run("GetEndpointShowsMock", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", fmt.Sprintf("/%s/admin/endpoints/%d", cenvID, endpoints[0].ID), token, nil)
+		var ep Endpoint
+		json.NewDecoder(w.Body).Decode(&ep)
+		if ep.Mock == nil || !ep.Mock.Enabled || ep.Mock.Status != 202 {
+			t.Errorf("Expected enabled mock in endpoint details, got %+v", ep.Mock)
+		}
+	})
+
+	t.Run("DisableMock", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", mockPath, token, map[string]interface{}{"enabled": false})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "GET", "/"+cenvID+"/star/orders", token, nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected script to run again, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnknownEndpoint", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/endpoints/9999/mock", token, map[string]interface{}{"enabled": true})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// handleTestEndpoint runs an endpoint script against a simulated request and
+// returns the response, logs and, when requested, an execution profile.
+// Profiling is only available through this admin test run, never on live traffic.
+// Route: POST /{cenvID}/admin/endpoints/{endpointID}/test
+func (s *Server) handleTestEndpoint(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+	endpointID := r.PathValue("endpointID")
+
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "test endpoints")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "test_endpoint", "endpoint", r.PathValue("endpointID")); err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Script  string            `json:"script"` // Optional draft script
+		Request *simulatedRequest `json:"request"`
+		Profile bool              `json:"profile"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var endpoint Endpoint
+	var capabilities string
+	err = db.QueryRow(`
+		SELECT id, path, method, script, capabilities
+		FROM _wce_endpoints
+		WHERE id = ?
+	`, endpointID).Scan(&endpoint.ID, &endpoint.Path, &endpoint.Method, &endpoint.Script, &capabilities)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	endpoint.Capabilities = decodeCapabilities(capabilities)
+
+	script := endpoint.Script
+	if req.Script != "" {
+		script = req.Script
+	}
+
+	testReq, err := buildSimulatedRequest(cenvID, &endpoint, req.Request)
+	if err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	execCtx := &starlark_pkg.ExecutionContext{
+		DB:           db,
+		UserID:       userID,
+		Request:      testReq,
+		Timeout:      5 * time.Second,
+		Capabilities: endpoint.capabilitySet(),
+		HTTPClient:   s.proxyClient,
+		Mailer:       s.mailer,
+	}
+
+	logs := []starlark_pkg.LogEntry{}
+	execCtx.Log = func(entry starlark_pkg.LogEntry) error {
+		logs = append(logs, entry)
+		return nil
+	}
+
+	var profiler *starlark_pkg.Profiler
+	if req.Profile {
+		profiler = starlark_pkg.NewProfiler()
+		execCtx.Profiler = profiler
+	}
+
+	resp := map[string]interface{}{
+		"endpoint_id": endpoint.ID,
+		"draft":       req.Script != "",
+	}
+
+	result, err := starlark_pkg.Execute(context.Background(), script, execCtx)
+	resp["logs"] = logs
+	if profiler != nil {
+		resp["profile"] = profiler.Report()
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	} else {
+		resp["status"] = result.StatusCode
+		resp["headers"] = result.Headers
+		resp["body"] = result.Body
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
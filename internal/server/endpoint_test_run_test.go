@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+func TestTestEndpointProfile(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints", srv.handleListEndpoints)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints/{endpointID}/test", srv.handleTestEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	script := "def count():\n" +
+		"    return db.query(\"SELECT COUNT(*) AS n FROM _wce_users\")[0][\"n\"]\n" +
+		"\n" +
+		"def handle_request(req):\n" +
+		"    log.info(\"testing\", who=req.query.get(\"who\"))\n" +
+		"    total = 0\n" +
+		"    for i in range(3):\n" +
+		"        total += count()\n" +
+		"    return response(str(total))"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path": "/count", "method": "GET", "script": script,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/endpoints", token, nil)
+	var endpoints []Endpoint
+	json.NewDecoder(w.Body).Decode(&endpoints)
+	testPath := fmt.Sprintf("/%s/admin/endpoints/%d/test", cenvID, endpoints[0].ID)
+
+	t.Run("WithProfile", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", testPath, token, map[string]interface{}{
+			"request": map[string]interface{}{"query": "who=admin"},
+			"profile": true,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Test run failed: %d %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Status  int                     `json:"status"`
+			Body    string                  `json:"body"`
+			Logs    []starlark_pkg.LogEntry `json:"logs"`
+			Profile *starlark_pkg.Profile   `json:"profile"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+
+		if resp.Status != 200 || resp.Body != "3" {
+			t.Errorf("Unexpected response: %d %q", resp.Status, resp.Body)
+		}
+		if len(resp.Logs) != 1 || resp.Logs[0].Fields["who"] != "admin" {
+			t.Errorf("Unexpected logs: %+v", resp.Logs)
+		}
+		if resp.Profile == nil {
+			t.Fatal("Expected profile in response")
+		}
+
+		var countCalls int
+		for _, fn := range resp.Profile.Functions {
+			if fn.Name == "count" {
+				countCalls = fn.Calls
+			}
+		}
+		if countCalls != 3 {
+			t.Errorf("Expected 3 calls to count, got %d", countCalls)
+		}
+		if len(resp.Profile.Queries) != 1 || resp.Profile.Queries[0].Calls != 3 {
+			t.Errorf("Unexpected query profile: %+v", resp.Profile.Queries)
+		}
+	})
+
+	t.Run("WithoutProfile", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", testPath, token, map[string]interface{}{})
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if _, ok := resp["profile"]; ok {
+			t.Error("Profile should only be returned when requested")
+		}
+	})
+
+	t.Run("EditorForbidden", func(t *testing.T) {
+		db, err := manager.GetConnection(cenvID)
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+			t.Fatalf("Failed to create editor: %v", err)
+		}
+		editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+		w := doJSON(t, mux, "POST", testPath, editorToken, map[string]interface{}{"profile": true})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+	})
+}
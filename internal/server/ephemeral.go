@@ -0,0 +1,58 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Anonymous clients may create this many ephemeral cenvs per window from one
+// network prefix, as each holds a database in memory until it expires
+const (
+	ephemeralPerClient    = 5
+	ephemeralClientWindow = time.Hour
+)
+
+// clientLimiter counts requests per client in fixed windows
+type clientLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*clientWindow
+}
+
+type clientWindow struct {
+	start time.Time
+	count int
+}
+
+func newClientLimiter(limit int, window time.Duration) *clientLimiter {
+	return &clientLimiter{limit: limit, window: window, clients: map[string]*clientWindow{}}
+}
+
+// Allow counts a request from client and reports whether it is within the
+// limit. Clients whose window has passed are forgotten.
+func (l *clientLimiter) Allow(client string) bool {
+	now := clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, w := range l.clients {
+		if now.Sub(w.start) >= l.window {
+			delete(l.clients, key)
+		}
+	}
+
+	w := l.clients[client]
+	if w == nil {
+		w = &clientWindow{start: now}
+		l.clients[client] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestEphemeralCenvAPI(t *testing.T) {
+	storageDir := t.TempDir()
+	manager := cenv.NewManager(storageDir)
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+
+	w := doJSON(t, mux, "POST", "/new?ephemeral=true&ttl=10m", "", map[string]string{
+		"username": "demo", "password": "demopass123",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp NewCenvResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Ephemeral || resp.ExpiresAt <= time.Now().Unix() || resp.ExpiresAt > time.Now().Add(10*time.Minute).Unix() {
+		t.Errorf("Expected an ephemeral cenv expiring within 10m, got %+v", resp)
+	}
+
+	token := loginAs(t, mux, resp.CenvID, "demo", "demopass123")
+	w = doJSON(t, mux, "POST", "/"+resp.CenvID+"/documents", token, map[string]interface{}{
+		"id": "hello.txt", "content": "hi", "content_type": "text/plain",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := manager.DatabaseSize(resp.CenvID); err == nil {
+		t.Error("Expected no database file for an ephemeral cenv")
+	}
+
+	srv.cenvManager.ExpireEphemeral(time.Now().Add(10 * time.Minute))
+	w = doJSON(t, mux, "POST", "/"+resp.CenvID+"/login", "", map[string]string{"username": "demo", "password": "demopass123"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after expiry, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Each client network may only create a few
+	create := func(remoteAddr string) int {
+		req := httptest.NewRequest("POST", "/new?ephemeral=true", strings.NewReader(`{"username":"demo","password":"demopass123"}`))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 1; i < ephemeralPerClient; i++ {
+		if code := create("192.0.2.7:1234"); code != http.StatusCreated {
+			t.Fatalf("Expected 201 for ephemeral cenv %d, got %d", i+1, code)
+		}
+	}
+	if code := create("192.0.2.8:4321"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 from the same /24 past the limit, got %d", code)
+	}
+	if code := create("198.51.100.1:1234"); code != http.StatusCreated {
+		t.Errorf("Expected another network to be unaffected, got %d", code)
+	}
+
+	for _, ttl := range []string{"forever", "48h", "-1m"} {
+		w := doJSON(t, mux, "POST", "/new?ephemeral=true&ttl="+ttl, "", map[string]string{
+			"username": "demo", "password": "demopass123",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for ttl %q, got %d", ttl, w.Code)
+		}
+	}
+
+	// Without the flag a cenv is created on disk and has no expiry
+	w = doJSON(t, mux, "POST", "/new", "", map[string]string{"username": "owner", "password": "ownerpass123"})
+	resp = NewCenvResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Ephemeral || resp.ExpiresAt != 0 {
+		t.Errorf("Expected a persistent cenv, got %+v", resp)
+	}
+	if _, err := manager.DatabaseSize(resp.CenvID); err != nil {
+		t.Errorf("Expected a database file, got %v", err)
+	}
+}
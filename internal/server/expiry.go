@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/cluster"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// sweepExpiredDocuments deletes or archives, per the cenv's
+// expired_documents setting, the expired documents of every cenv. With a
+// coordinator, cenvs whose write lease another instance holds are left to it.
+// Returns the failures, after sweeping every cenv it can.
+func (s *Server) sweepExpiredDocuments() error {
+	cenvIDs, err := s.cenvManager.List()
+	if err != nil {
+		log.Printf("Failed to list cenvs for expiry sweep: %v", err)
+		return fmt.Errorf("failed to list cenvs: %w", err)
+	}
+
+	var failures []error
+
+	for _, cenvID := range cenvIDs {
+		if s.coordinator != nil {
+			if _, err := s.coordinator.Claim(cenvID); err != nil {
+				if !errors.Is(err, cluster.ErrLeaseHeld) {
+					log.Printf("Failed to claim write lease for cenv %s: %v", cenvID, err)
+					failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+				}
+				continue
+			}
+		}
+
+		db, err := s.cenvManager.GetConnection(cenvID)
+		if err != nil {
+			log.Printf("Failed to open cenv %s for expiry sweep: %v", cenvID, err)
+			failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+			continue
+		}
+
+		archive := config.GetString(db, "expired_documents", "delete") == "archive"
+		removed, err := document.SweepExpiredDocuments(db, clock.Now().Unix(), archive)
+		if err != nil {
+			log.Printf("Expiry sweep of cenv %s: %v", cenvID, err)
+			failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+		}
+		if removed == 0 {
+			continue
+		}
+		log.Printf("Removed %d expired documents from cenv %s", removed, cenvID)
+
+		if s.coordinator != nil {
+			event := cluster.Event{
+				CenvID: cenvID,
+				Method: "DELETE",
+				Path:   "/documents",
+				Time:   time.Now().Unix(),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := s.coordinator.Publish(ctx, event); err != nil {
+				log.Printf("Failed to publish write event for cenv %s: %v", cenvID, err)
+			}
+			cancel()
+		}
+	}
+
+	return errors.Join(failures...)
+}
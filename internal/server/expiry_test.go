@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestDocumentExpiry(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+	past := time.Now().Add(-time.Minute).Unix()
+	future := time.Now().Add(time.Hour).Unix()
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "previews/a", "content": "preview", "content_type": "text/plain", "expires_at": past,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var doc document.Document
+	json.NewDecoder(w.Body).Decode(&doc)
+	if doc.ExpiresAt != past {
+		t.Errorf("Expected expires_at %d, got %d", past, doc.ExpiresAt)
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "previews/bad", "content": "preview", "content_type": "text/plain", "expires_at": -5,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for negative expiry, got %d", w.Code)
+	}
+
+	for _, id := range []string{"exports/b", "exports/c"} {
+		doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "export", "content_type": "text/plain",
+		})
+	}
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/exports/b", token, map[string]interface{}{"expires_at": future})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	db, _ := manager.GetConnection(cenvID)
+	srv.sweepExpiredDocuments()
+	if _, err := document.GetDocument(db, "previews/a"); err == nil {
+		t.Error("Expected expired document to be deleted")
+	}
+	if _, err := document.GetDocument(db, "exports/b"); err != nil {
+		t.Errorf("Expected unexpired document to be kept: %v", err)
+	}
+
+	// Archiving is configured per cenv
+	config.Set(db, "expired_documents", "archive", "")
+	doJSON(t, mux, "PUT", "/"+cenvID+"/documents/exports/c", token, map[string]interface{}{"expires_at": past})
+	srv.sweepExpiredDocuments()
+	if _, err := document.GetDocument(db, "archive/exports/c"); err != nil {
+		t.Errorf("Expected expired document to be archived: %v", err)
+	}
+
+	// Clearing the expiry keeps the document
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/exports/b", token, map[string]interface{}{"expires_at": 0})
+	var cleared document.Document
+	json.NewDecoder(w.Body).Decode(&cleared)
+	if w.Code != http.StatusOK || cleared.ExpiresAt != 0 {
+		t.Errorf("Expected expiry to be cleared, got %d: %+v", w.Code, cleared)
+	}
+}
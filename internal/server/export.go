@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// handleExportDocuments streams the documents under ?prefix= as an archive
+// with a metadata manifest, for backups and editing files locally.
+// ?format=tar returns a gzip-compressed tarball instead of a ZIP.
+func (s *Server) handleExportDocuments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = document.ExportFormatZip
+	}
+	if !document.IsValidExportFormat(format) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "format must be 'zip' or 'tar'",
+		})
+		return
+	}
+
+	if format == document.ExportFormatTar {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="documents.tar.gz"`)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="documents.zip"`)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// The archive is streamed, so a failure part way can only cut it short
+	if _, err := document.ExportDocuments(r.Context(), db, w, r.URL.Query().Get("prefix"), format); err != nil {
+		log.Printf("Document export from cenv %s failed: %v", cenvID, err)
+	}
+}
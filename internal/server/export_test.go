@@ -0,0 +1,51 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestDocumentExportAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/export", srv.handleExportDocuments)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for _, id := range []string{"site/index.html", "drafts/todo.txt"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id":           id,
+			"content":      "content of " + id,
+			"content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create %s: %d %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/export?prefix=site/", token, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected a ZIP, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Export is not a valid ZIP: %v", err)
+	}
+	if len(archive.File) != 2 || archive.File[0].Name != "site/index.html" || archive.File[1].Name != document.ExportManifestName {
+		t.Errorf("Unexpected archive entries: %d", len(archive.File))
+	}
+
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/export?format=rar", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", w.Code)
+	}
+}
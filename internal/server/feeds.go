@@ -0,0 +1,326 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/markdown"
+)
+
+// Config keys of the sitemap and feeds. feed_prefix selects the published
+// documents and turns them on; site_url is where those documents are served,
+// the cenv's pages by default.
+const (
+	feedPrefixConfigKey = "feed_prefix"
+	feedTitleConfigKey  = "feed_title"
+	siteURLConfigKey    = "site_url"
+)
+
+// Bounds on generated documents: feeds carry the latest entries, and a
+// sitemap may list at most 50,000 URLs
+const (
+	maxFeedEntries = 50
+	maxSitemapURLs = 50000
+)
+
+// feedCacheControl lets crawlers and feed readers reuse a response briefly
+const feedCacheControl = "public, max-age=300"
+
+// feedSite describes the published site a cenv's feeds point into
+type feedSite struct {
+	Title   string
+	URL     string // Base URL of the published documents, without trailing slash
+	SelfURL string // URL of the feed being served
+}
+
+// feedEntry is a published document as listed in a sitemap or feed
+type feedEntry struct {
+	Title     string
+	Summary   string
+	Link      string
+	Published time.Time
+	Updated   time.Time
+}
+
+// handleSitemap serves a sitemap.xml of the documents under the cenv's
+// feed_prefix, without authentication
+// Route: GET /{cenvID}/sitemap.xml
+func (s *Server) handleSitemap(w http.ResponseWriter, r *http.Request) {
+	_, entries, ok := s.loadFeed(w, r, false, maxSitemapURLs)
+	if !ok {
+		return
+	}
+
+	urlset := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, entry := range entries {
+		urlset.URLs = append(urlset.URLs, sitemapURL{Loc: entry.Link, LastMod: entry.Updated.Format(time.RFC3339)})
+	}
+	writeFeedXML(w, "application/xml", urlset)
+}
+
+// handleRSSFeed serves an RSS 2.0 feed of the latest documents under the
+// cenv's feed_prefix, without authentication
+// Route: GET /{cenvID}/feed.xml
+func (s *Server) handleRSSFeed(w http.ResponseWriter, r *http.Request) {
+	site, entries, ok := s.loadFeed(w, r, true, maxFeedEntries)
+	if !ok {
+		return
+	}
+
+	channel := rssChannel{
+		Title:       site.Title,
+		Link:        site.URL,
+		Description: site.Title,
+		AtomLink:    atomLink{Href: site.SelfURL, Rel: "self", Type: "application/rss+xml"},
+	}
+	for _, entry := range entries {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			GUID:        rssGUID{Value: entry.Link, IsPermaLink: true},
+			PubDate:     entry.Published.Format(time.RFC1123Z),
+			Description: entry.Summary,
+		})
+	}
+	if len(entries) > 0 {
+		channel.LastBuildDate = latestUpdate(entries).Format(time.RFC1123Z)
+	}
+	writeFeedXML(w, "application/rss+xml", rssFeed{Version: "2.0", XmlnsAtom: "http://www.w3.org/2005/Atom", Channel: channel})
+}
+
+// handleAtomFeed serves an Atom feed of the latest documents under the
+// cenv's feed_prefix, without authentication
+// Route: GET /{cenvID}/atom.xml
+func (s *Server) handleAtomFeed(w http.ResponseWriter, r *http.Request) {
+	site, entries, ok := s.loadFeed(w, r, true, maxFeedEntries)
+	if !ok {
+		return
+	}
+
+	feed := atomFeed{
+		ID:      site.SelfURL,
+		Title:   site.Title,
+		Updated: latestUpdate(entries).Format(time.RFC3339),
+		Author:  atomAuthor{Name: site.Title},
+		Links: []atomLink{
+			{Href: site.SelfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: site.URL, Rel: "alternate"},
+		},
+	}
+	for _, entry := range entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        entry.Link,
+			Title:     entry.Title,
+			Link:      atomLink{Href: entry.Link, Rel: "alternate"},
+			Published: entry.Published.Format(time.RFC3339),
+			Updated:   entry.Updated.Format(time.RFC3339),
+			Summary:   entry.Summary,
+		})
+	}
+	writeFeedXML(w, "application/atom+xml", feed)
+}
+
+// loadFeed reads the cenv's feed settings and its published documents, most
+// recently modified first, writing an error response and returning false
+// when the cenv does not exist or has no feed_prefix. Titles need content,
+// which sitemaps do without.
+func (s *Server) loadFeed(w http.ResponseWriter, r *http.Request, withTitles bool, limit int) (*feedSite, []feedEntry, bool) {
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	prefix := config.GetString(db, feedPrefixConfigKey, "")
+	if prefix == "" {
+		http.Error(w, "Feeds are not enabled", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	site := &feedSite{
+		Title:   config.GetString(db, feedTitleConfigKey, strings.TrimSuffix(prefix, "/")),
+		URL:     strings.TrimSuffix(config.GetString(db, siteURLConfigKey, ""), "/"),
+		SelfURL: scheme + "://" + r.Host + r.URL.Path,
+	}
+	if site.URL == "" {
+		site.URL = scheme + "://" + r.Host + "/" + cenvID + "/pages"
+	}
+
+	docs, err := document.ListRecentDocuments(db, prefix, withTitles, limit)
+	if err != nil {
+		log.Printf("Failed to list feed documents of cenv %s: %v", cenvID, err)
+		http.Error(w, "Failed to list documents", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	entries := []feedEntry{}
+	for i := range docs {
+		if entry, ok := newFeedEntry(site, &docs[i]); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return site, entries, true
+}
+
+// newFeedEntry describes a document for feeds from its front matter: title
+// (else the first markdown heading, else the id), description or summary,
+// and date as the publication time (else the creation time). Documents
+// marked draft are left out. A document's link is its id without extension
+// under the site URL, so blog/hello.md is {site_url}/blog/hello.
+func newFeedEntry(site *feedSite, doc *document.Document) (feedEntry, bool) {
+	frontMatter := map[string]interface{}{}
+	json.Unmarshal(doc.FrontMatter, &frontMatter)
+	if draft, _ := frontMatter["draft"].(bool); draft {
+		return feedEntry{}, false
+	}
+
+	entry := feedEntry{
+		Link:      site.URL + (&url.URL{Path: "/" + strings.TrimSuffix(doc.ID, path.Ext(doc.ID))}).EscapedPath(),
+		Published: time.Unix(doc.CreatedAt, 0).UTC(),
+		Updated:   time.Unix(doc.ModifiedAt, 0).UTC(),
+	}
+
+	entry.Title, _ = frontMatter["title"].(string)
+	if entry.Title == "" && doc.Content != "" {
+		if mediaType, _, _ := mime.ParseMediaType(doc.ContentType); mediaType == "text/markdown" || mediaType == "text/x-markdown" {
+			_, body, _ := document.SplitFrontMatter(doc.Content)
+			entry.Title = markdown.Title(body)
+		}
+	}
+	if entry.Title == "" {
+		entry.Title = doc.ID
+	}
+
+	for _, key := range []string{"description", "summary"} {
+		if summary, ok := frontMatter[key].(string); ok && summary != "" {
+			entry.Summary = summary
+			break
+		}
+	}
+
+	if date, ok := frontMatter["date"].(string); ok {
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if published, err := time.Parse(layout, date); err == nil {
+				entry.Published = published.UTC()
+				break
+			}
+		}
+	}
+
+	return entry, true
+}
+
+// latestUpdate returns the latest modification of the entries, or the Unix
+// epoch when there are none
+func latestUpdate(entries []feedEntry) time.Time {
+	latest := time.Unix(0, 0).UTC()
+	for _, entry := range entries {
+		if entry.Updated.After(latest) {
+			latest = entry.Updated
+		}
+	}
+	return latest
+}
+
+// writeFeedXML writes an XML document with a short public cache lifetime
+func writeFeedXML(w http.ResponseWriter, contentType string, v interface{}) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to encode feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", feedCacheControl)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	XmlnsAtom string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Summary   string   `xml:"summary,omitempty"`
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestFeeds(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("GET /{cenvID}/sitemap.xml", srv.handleSitemap)
+	mux.HandleFunc("GET /{cenvID}/feed.xml", srv.handleRSSFeed)
+	mux.HandleFunc("GET /{cenvID}/atom.xml", srv.handleAtomFeed)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+cenvID+path, nil))
+		return w
+	}
+
+	// Feeds are off until a prefix is configured
+	if w := get("/feed.xml"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without feed_prefix, got %d", w.Code)
+	}
+
+	docs := []map[string]interface{}{
+		{"id": "blog/hello.md", "content_type": "text/markdown",
+			"content": "---\ntitle: Hello & welcome\ndate: 2025-01-02\ndescription: The first post\n---\n# Ignored heading\n"},
+		{"id": "blog/second post.md", "content_type": "text/markdown", "content": "# Second post\n\nBody"},
+		{"id": "blog/draft.md", "content_type": "text/markdown", "content": "---\ndraft: true\n---\nNot yet"},
+		{"id": "notes/private.md", "content_type": "text/markdown", "content": "# Private"},
+	}
+	for _, doc := range docs {
+		if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, doc); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	for key, value := range map[string]string{"feed_prefix": "blog/", "feed_title": "My Blog", "site_url": "https://example.com/"} {
+		if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/"+key, token, map[string]string{"value": value}); w.Code != http.StatusOK {
+			t.Fatalf("Failed to set %s: %d %s", key, w.Code, w.Body.String())
+		}
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/site_url", token, map[string]string{"value": "example.com"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a relative site_url to be rejected, got %d", w.Code)
+	}
+
+	w := get("/sitemap.xml")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml") {
+		t.Fatalf("Unexpected sitemap response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var sitemap sitemapURLSet
+	if err := xml.Unmarshal(w.Body.Bytes(), &sitemap); err != nil {
+		t.Fatalf("Invalid sitemap: %v", err)
+	}
+	locs := map[string]bool{}
+	for _, u := range sitemap.URLs {
+		locs[u.Loc] = true
+	}
+	if len(locs) != 2 || !locs["https://example.com/blog/hello"] || !locs["https://example.com/blog/second%20post"] {
+		t.Errorf("Unexpected sitemap URLs: %+v", sitemap.URLs)
+	}
+
+	w = get("/feed.xml")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/rss+xml") {
+		t.Fatalf("Unexpected feed response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("Invalid RSS: %v", err)
+	}
+	if rss.Channel.Title != "My Blog" || len(rss.Channel.Items) != 2 {
+		t.Fatalf("Unexpected channel: %+v", rss.Channel)
+	}
+	items := map[string]rssItem{}
+	for _, item := range rss.Channel.Items {
+		items[item.Link] = item
+	}
+	hello := items["https://example.com/blog/hello"]
+	if hello.Title != "Hello & welcome" || hello.Description != "The first post" || !strings.HasPrefix(hello.PubDate, "Thu, 02 Jan 2025") {
+		t.Errorf("Unexpected item: %+v", hello)
+	}
+	if items["https://example.com/blog/second%20post"].Title != "Second post" {
+		t.Errorf("Expected the heading as title, got %+v", rss.Channel.Items)
+	}
+
+	w = get("/atom.xml")
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("Invalid Atom: %v", err)
+	}
+	if w.Code != http.StatusOK || atom.Title != "My Blog" || len(atom.Entries) != 2 || atom.Links[0].Href != "http://example.com/"+cenvID+"/atom.xml" {
+		t.Errorf("Unexpected Atom feed: %d %+v", w.Code, atom)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/flags"
+)
+
+// FlagOverrideRequest turns a feature flag on or off for one user
+type FlagOverrideRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// handleListFlags lists feature flags with their overrides (admin/owner only)
+// Route: GET /{cenvID}/admin/flags
+func (s *Server) handleListFlags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view feature flags")
+	if err != nil {
+		return // Response already sent
+	}
+
+	list, err := flags.List(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"flags": list,
+		"count": len(list),
+	})
+}
+
+// handlePutFlag creates or replaces a feature flag, keeping its overrides
+// (admin/owner only). Rollout defaults to 100 percent.
+// Route: PUT /{cenvID}/admin/flags/{name}
+func (s *Server) handlePutFlag(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage feature flags")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "put_flag", "flag", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	flag := flags.Flag{Rollout: 100}
+	if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+	flag.Name = r.PathValue("name")
+
+	if err := flags.Put(db, &flag, userID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Feature flag %s set to enabled=%t rollout=%d by %s", flag.Name, flag.Enabled, flag.Rollout, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(flag)
+}
+
+// handleDeleteFlag removes a feature flag and its overrides (admin/owner only)
+// Route: DELETE /{cenvID}/admin/flags/{name}
+func (s *Server) handleDeleteFlag(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage feature flags")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "delete_flag", "flag", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	name := r.PathValue("name")
+	if err := flags.Delete(db, name); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Feature flag %s deleted by %s", name, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "flag deleted successfully",
+	})
+}
+
+// handleSetFlagOverride turns a feature flag on or off for one user,
+// whatever its state and rollout (admin/owner only)
+// Route: PUT /{cenvID}/admin/flags/{name}/overrides/{userID}
+func (s *Server) handleSetFlagOverride(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	adminID, db, err := s.requireAdmin(w, r, cenvID, "manage feature flags")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, adminID, "set_flag_override", "flag", r.PathValue("name")+"/"+r.PathValue("userID")); err != nil {
+		return // Response already sent
+	}
+
+	var req FlagOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	name, userID := r.PathValue("name"), r.PathValue("userID")
+	if err := flags.SetOverride(db, name, userID, req.Enabled); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Feature flag %s overridden to enabled=%t for %s by %s", name, req.Enabled, userID, adminID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"flag":    name,
+		"user_id": userID,
+		"enabled": req.Enabled,
+	})
+}
+
+// handleDeleteFlagOverride returns a user to a feature flag's state and
+// rollout (admin/owner only)
+// Route: DELETE /{cenvID}/admin/flags/{name}/overrides/{userID}
+func (s *Server) handleDeleteFlagOverride(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	adminID, db, err := s.requireAdmin(w, r, cenvID, "manage feature flags")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, adminID, "delete_flag_override", "flag", r.PathValue("name")+"/"+r.PathValue("userID")); err != nil {
+		return // Response already sent
+	}
+
+	name, userID := r.PathValue("name"), r.PathValue("userID")
+	if err := flags.DeleteOverride(db, name, userID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Feature flag %s override for %s removed by %s", name, userID, adminID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "override deleted successfully",
+	})
+}
+
+// handleMyFlags returns which feature flags are on for the caller, for
+// client-side apps
+// Route: GET /{cenvID}/flags
+func (s *Server) handleMyFlags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	list, err := flags.List(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	enabled := make(map[string]bool, len(list))
+	for _, flag := range list {
+		if enabled[flag.Name], err = flags.Enabled(db, flag.Name, userID); err != nil {
+			writeTableError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"flags": enabled,
+	})
+}
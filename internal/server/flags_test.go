@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestFeatureFlagsAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/pages/{path...}", srv.handleRenderPage)
+	mux.HandleFunc("GET /{cenvID}/admin/flags", srv.handleListFlags)
+	mux.HandleFunc("PUT /{cenvID}/admin/flags/{name}", srv.handlePutFlag)
+	mux.HandleFunc("DELETE /{cenvID}/admin/flags/{name}", srv.handleDeleteFlag)
+	mux.HandleFunc("PUT /{cenvID}/admin/flags/{name}/overrides/{userID}", srv.handleSetFlagOverride)
+	mux.HandleFunc("DELETE /{cenvID}/admin/flags/{name}/overrides/{userID}", srv.handleDeleteFlagOverride)
+	mux.HandleFunc("GET /{cenvID}/flags", srv.handleMyFlags)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	viewer, err := auth.CreateUser(db, "viewer", "viewerpass123", "viewer", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create viewer: %v", err)
+	}
+	viewerToken := loginAs(t, mux, cenvID, "viewer", "viewerpass123")
+
+	myFlags := func(token string) map[string]bool {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/flags", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Flags map[string]bool `json:"flags"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Flags
+	}
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/flags/new_nav", viewerToken, map[string]interface{}{"enabled": true}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected viewers to be refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/flags/new_nav", token, map[string]interface{}{"enabled": false}); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/flags/Bad-Name", token, map[string]interface{}{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid name, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/flags/new_nav", token, map[string]interface{}{"rollout": 150}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid rollout, got %d", w.Code)
+	}
+
+	page := map[string]interface{}{
+		"id":           "templates/pages/index.html",
+		"content":      "{% if flags.new_nav %}new{% else %}old{% endif %}",
+		"content_type": "text/html",
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, page); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	render := func(token string) string {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/pages/", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	// A disabled flag is on only for the overridden user
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/flags/new_nav/overrides/"+viewer.UserID, token, map[string]bool{"enabled": true}); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !myFlags(viewerToken)["new_nav"] || myFlags(token)["new_nav"] {
+		t.Error("Expected new_nav on for the viewer only")
+	}
+	if render(viewerToken) != "new" || render(token) != "old" || render("") != "old" {
+		t.Error("Expected the page to follow the flag for each user")
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/flags", token, nil)
+	var listed struct {
+		Flags []struct {
+			Name      string          `json:"name"`
+			Rollout   int             `json:"rollout"`
+			Overrides map[string]bool `json:"overrides"`
+		} `json:"flags"`
+	}
+	json.NewDecoder(w.Body).Decode(&listed)
+	if len(listed.Flags) != 1 || listed.Flags[0].Rollout != 100 || !listed.Flags[0].Overrides[viewer.UserID] {
+		t.Errorf("Unexpected flags: %+v", listed.Flags)
+	}
+
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/flags/new_nav/overrides/"+viewer.UserID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if myFlags(viewerToken)["new_nav"] {
+		t.Error("Expected new_nav off once the override is removed")
+	}
+
+	// Enabled for everyone, including anonymous page views
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/flags/new_nav", token, map[string]interface{}{"enabled": true}); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if render("") != "new" {
+		t.Error("Expected the page to show the enabled feature")
+	}
+
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/flags/new_nav", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/flags/new_nav", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+	if len(myFlags(viewerToken)) != 0 {
+		t.Error("Expected no flags after delete")
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// setupTestCenv creates a cenv through the given mux and logs in as its owner.
+// The mux must route POST /new and POST /{cenvID}/login.
+// Returns the cenv ID and a bearer token.
+func setupTestCenv(t *testing.T, mux *http.ServeMux) (string, string) {
+	t.Helper()
+
+	creds := map[string]string{
+		"username": "admin",
+		"password": "adminpass123",
+	}
+
+	w := doJSON(t, mux, "POST", "/new", "", creds)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create cenv: %d %s", w.Code, w.Body.String())
+	}
+
+	var created map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&created)
+	cenvID := created["cenv_id"].(string)
+
+	return cenvID, loginAs(t, mux, cenvID, creds["username"], creds["password"])
+}
+
+// loginAs logs in to a cenv and returns a bearer token
+func loginAs(t *testing.T, mux *http.ServeMux, cenvID, username, password string) string {
+	t.Helper()
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{
+		"username": username,
+		"password": password,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to login as %s: %d %s", username, w.Code, w.Body.String())
+	}
+
+	var login map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&login)
+
+	return login["token"].(string)
+}
+
+// doJSON sends a request with an optional JSON body and bearer token
+func doJSON(t *testing.T, mux *http.ServeMux, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to marshal body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(bodyBytes))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		signRequest(req, token, bodyBytes)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+// signRequest authenticates a request with token and signs it the way
+// clients sign admin mutations, with a fresh timestamp and nonce
+func signRequest(req *http.Request, token string, body []byte) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerNonce, hex.EncodeToString(nonce))
+	req.Header.Set(headerSignature, requestSignature(token, req.Method, req.URL.RequestURI(), timestamp, hex.EncodeToString(nonce), body))
+}
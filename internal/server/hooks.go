@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/hooks"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// hookTimeout bounds each hook run, as endpoint scripts are bounded
+const hookTimeout = 5 * time.Second
+
+// PutHookRequest creates or replaces a hook script
+type PutHookRequest struct {
+	Event       string `json:"event"`
+	Script      string `json:"script"`
+	Description string `json:"description"`
+	Enabled     *bool  `json:"enabled"` // Defaults to true
+
+	// Granted builtins; omitted grants all
+	Capabilities *[]string `json:"capabilities"`
+}
+
+// handleListHooks lists hook scripts without their source (admin/owner only)
+// Route: GET /{cenvID}/admin/hooks
+func (s *Server) handleListHooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view hooks")
+	if err != nil {
+		return // Response already sent
+	}
+
+	list, err := hooks.List(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hooks":  list,
+		"count":  len(list),
+		"events": hooks.Events,
+	})
+}
+
+// handleGetHook returns a hook script with its source and last run
+// (admin/owner only)
+// Route: GET /{cenvID}/admin/hooks/{name}
+func (s *Server) handleGetHook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view hooks")
+	if err != nil {
+		return // Response already sent
+	}
+
+	hook, err := hooks.Get(db, r.PathValue("name"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// handlePutHook creates or replaces a hook script (admin/owner only). The
+// script must define handle_event(event) and pass the static checks
+// endpoint scripts do.
+// Route: PUT /{cenvID}/admin/hooks/{name}
+func (s *Server) handlePutHook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage hooks")
+	if err != nil {
+		return // Response already sent
+	}
+
+	name := r.PathValue("name")
+	if err := s.verifyAdminRequest(w, r, db, userID, "deploy_hook", "hook", name); err != nil {
+		return // Response already sent
+	}
+
+	var req PutHookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	lint := starlark_pkg.LintHook(req.Script)
+	if !lint.OK() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "script failed static checks",
+			"errors":   lint.Errors,
+			"warnings": lint.Warnings,
+		})
+		return
+	}
+
+	var capabilities starlark_pkg.Capabilities // nil = all
+	if req.Capabilities != nil {
+		if capabilities, err = starlark_pkg.ParseCapabilities(*req.Capabilities); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	hook := &hooks.Hook{
+		Name:         name,
+		Event:        req.Event,
+		Script:       req.Script,
+		Description:  req.Description,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		Capabilities: capabilities.Names(),
+	}
+	if err := hooks.Put(db, hook, userID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Hook %s for %s saved by %s", hook.Name, hook.Event, userID)
+
+	resp := map[string]interface{}{"hook": hook}
+	if len(lint.Warnings) > 0 {
+		resp["warnings"] = lint.Warnings
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleDeleteHook removes a hook script (admin/owner only)
+// Route: DELETE /{cenvID}/admin/hooks/{name}
+func (s *Server) handleDeleteHook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage hooks")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "delete_hook", "hook", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	name := r.PathValue("name")
+	if err := hooks.Delete(db, name); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Hook %s deleted by %s", name, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "hook deleted successfully",
+	})
+}
+
+// runDocumentHooks runs the hooks of a document event after the write has
+// been committed, before the response is sent, so a client that sees the
+// response also sees the hooks' effects. A failing hook is logged and
+// recorded as its last error; it neither stops the other hooks nor fails
+// the write. Hooks run outside the request's context so a client that
+// disconnects does not cut them short.
+func (s *Server) runDocumentHooks(db *sql.DB, cenvID, event, docID, userID string) {
+	list, err := hooks.ForEvent(db, event)
+	if err != nil {
+		log.Printf("Failed to load %s hooks of cenv %s: %v", event, cenvID, err)
+		return
+	}
+
+	for i := range list {
+		hook := &list[i]
+		capabilities, err := starlark_pkg.ParseCapabilities(hook.Capabilities)
+		if err != nil {
+			capabilities = starlark_pkg.Capabilities{}
+		}
+		execCtx := &starlark_pkg.ExecutionContext{
+			DB:           db,
+			UserID:       userID,
+			Timeout:      hookTimeout,
+			Capabilities: capabilities,
+			Remote:       s.newRemoteAccess(cenvID, db),
+			HTTPClient:   s.proxyClient,
+			Mailer:       s.mailer,
+		}
+
+		runErr := starlark_pkg.ExecuteHook(context.Background(), hook.Script, execCtx, &starlark_pkg.Event{
+			Name:       event,
+			DocumentID: docID,
+			Actor:      userID,
+		})
+		if runErr != nil {
+			log.Printf("Hook %s of cenv %s failed on %s: %v", hook.Name, cenvID, docID, runErr)
+		}
+		if err := hooks.RecordRun(db, hook.Name, runErr); err != nil {
+			log.Printf("Failed to record run of hook %s: %v", hook.Name, err)
+		}
+	}
+}
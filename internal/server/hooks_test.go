@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+const recordEventHook = `
+def handle_event(event):
+    db.execute("INSERT INTO doc_events (event, document_id, actor) VALUES (?, ?, ?)",
+               [event.name, event.document_id, event.actor])
+`
+
+func TestDocumentHooks(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", srv.handleDeleteDocument)
+	mux.HandleFunc("POST /{cenvID}/documents/{docID...}", srv.handleDocumentAction)
+	mux.HandleFunc("GET /{cenvID}/admin/hooks", srv.handleListHooks)
+	mux.HandleFunc("GET /{cenvID}/admin/hooks/{name}", srv.handleGetHook)
+	mux.HandleFunc("PUT /{cenvID}/admin/hooks/{name}", srv.handlePutHook)
+	mux.HandleFunc("DELETE /{cenvID}/admin/hooks/{name}", srv.handleDeleteHook)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE doc_events (id INTEGER PRIMARY KEY, event TEXT, document_id TEXT, actor TEXT)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/hooks/record", editorToken, map[string]interface{}{
+		"event": "on_document_created", "script": recordEventHook,
+	}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected editors to be refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/hooks/record", token, map[string]interface{}{
+		"event": "on_document_created", "script": "def handle_request(req):\n    pass\n",
+	}); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "handle_event") {
+		t.Errorf("Expected a script without handle_event to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/hooks/record", token, map[string]interface{}{
+		"event": "on_document_read", "script": recordEventHook,
+	}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown event to be rejected, got %d", w.Code)
+	}
+
+	for _, event := range []string{"created", "updated", "deleted"} {
+		w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/hooks/record_"+event, token, map[string]interface{}{
+			"event": "on_document_" + event, "script": recordEventHook,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to save hook: %d %s", w.Code, w.Body.String())
+		}
+	}
+	// A failing hook neither fails the write nor stops the others
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/hooks/broken", token, map[string]interface{}{
+		"event": "on_document_created", "script": "def handle_event(event):\n    fail('boom')\n",
+	}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to save hook: %d %s", w.Code, w.Body.String())
+	}
+	// Disabled hooks do not run
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/hooks/off", token, map[string]interface{}{
+		"event": "on_document_created", "script": recordEventHook, "enabled": false,
+	}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to save hook: %d %s", w.Code, w.Body.String())
+	}
+
+	var ownerID string
+	db.QueryRow(`SELECT user_id FROM _wce_users WHERE username = 'admin'`).Scan(&ownerID)
+
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "notes/a.md", "content": "# A", "content_type": "text/markdown",
+	}); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create document: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/documents/notes/a.md", token, map[string]interface{}{"content": "# A2"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to update document: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents/notes/a.md/move", token, map[string]interface{}{"to": "notes/b.md"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to move document: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/documents/notes/b.md", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to delete document: %d %s", w.Code, w.Body.String())
+	}
+
+	rows, err := db.Query(`SELECT event, document_id, actor FROM doc_events ORDER BY id`)
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+	var events []string
+	for rows.Next() {
+		var event, docID, actor string
+		rows.Scan(&event, &docID, &actor)
+		if actor != ownerID {
+			t.Errorf("Expected actor %s, got %s", ownerID, actor)
+		}
+		events = append(events, event+" "+docID)
+	}
+	rows.Close()
+	want := []string{
+		"on_document_created notes/a.md",
+		"on_document_updated notes/a.md",
+		"on_document_deleted notes/a.md",
+		"on_document_created notes/b.md",
+		"on_document_deleted notes/b.md",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected events:\n%s", strings.Join(events, "\n"))
+	}
+
+	// The failure is recorded on the hook
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/hooks/broken", token, nil)
+	var broken struct {
+		Script    string `json:"script"`
+		LastRunAt int64  `json:"last_run_at"`
+		LastError string `json:"last_error"`
+	}
+	json.NewDecoder(w.Body).Decode(&broken)
+	if broken.Script == "" || broken.LastRunAt == 0 || !strings.Contains(broken.LastError, "boom") {
+		t.Errorf("Expected the failure to be recorded, got %+v", broken)
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/hooks", token, nil)
+	var list struct {
+		Count int `json:"count"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if list.Count != 5 {
+		t.Errorf("Expected 5 hooks, got %d", list.Count)
+	}
+
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/hooks/broken", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Failed to delete hook: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/hooks/broken", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted hook to be gone, got %d", w.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// Reasons a request carries no identity; the messages are the error
+// responses handlers send
+var (
+	errMissingAuthorization = errors.New("missing authorization header")
+	errInvalidAuthorization = errors.New("invalid authorization header format")
+	errInvalidToken         = errors.New("invalid token")
+	errWrongCenv            = errors.New("token not valid for this cenv")
+	errSessionInvalid       = errors.New("session expired or revoked")
+	errSessionCheck         = errors.New("failed to validate session")
+)
+
+// scopeError is returned for a scoped token that lacks the scope of the
+// route it is used on
+type scopeError struct {
+	scope string // Scope the route needs; "" when no scope allows it
+}
+
+func (e *scopeError) Error() string {
+	if e.scope == "" {
+		return "token scope does not allow this request"
+	}
+	return "token lacks scope " + e.scope
+}
+
+// identityKey is the context key for the outcome of authenticating a request
+type identityKey struct{}
+
+// identityResult is the identity a request was authenticated as for a
+// cenv, or why it was not
+type identityResult struct {
+	cenvID   string
+	identity *auth.Identity
+	err      error
+}
+
+// identityMiddleware authenticates the bearer token of each request to a
+// cenv once and stores the outcome in the request context, where identify,
+// auth.IdentityFromContext and everything downstream reads it.
+func (s *Server) identityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cenvID, _, ok := cenv.ParsePath(r.URL.Path)
+		if !ok || !cenv.IsValidUUID(cenvID) || r.Header.Get("Authorization") == "" || !s.cenvManager.Exists(cenvID) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, err := s.authenticate(r, cenvID)
+		ctx := context.WithValue(r.Context(), identityKey{}, &identityResult{cenvID: cenvID, identity: identity, err: err})
+		if identity != nil {
+			ctx = auth.WithIdentity(ctx, identity)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// identify returns who made a request to cenvID: the identity built by
+// identityMiddleware, or one authenticated now for requests that reached
+// the handler without it
+func (s *Server) identify(r *http.Request, cenvID string) (*auth.Identity, error) {
+	if result, ok := r.Context().Value(identityKey{}).(*identityResult); ok && result.cenvID == cenvID {
+		return result.identity, result.err
+	}
+	return s.authenticate(r, cenvID)
+}
+
+// authenticate validates the bearer token of a request to cenvID, the
+// session behind it and, for scoped tokens, the scope of the route
+func (s *Server) authenticate(r *http.Request, cenvID string) (*auth.Identity, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errMissingAuthorization
+	}
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok || strings.Contains(token, " ") {
+		return nil, errInvalidAuthorization
+	}
+
+	claims, err := s.jwtManager.ValidateToken(token)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	if claims.CenvID != cenvID {
+		return nil, errWrongCenv
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionCheck, err)
+	}
+	valid, err := s.sessionValid(r, db, claims, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionCheck, err)
+	}
+	if !valid {
+		return nil, errSessionInvalid
+	}
+	if claims.IsScoped() {
+		_, path, _ := cenv.ParsePath(r.URL.Path)
+		if scope := requiredScope(r.Method, path); scope == "" || !claims.HasScope(scope) {
+			return nil, &scopeError{scope: scope}
+		}
+	}
+
+	return auth.NewIdentity(claims), nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// maxImportSize caps the size of an uploaded import archive
+const maxImportSize = 256 << 20
+
+// errImportFailed rolls back an atomic import in which a file failed
+var errImportFailed = errors.New("import failed")
+
+// handleImportDocuments creates one document per file of a ZIP archive sent
+// as the 'archive' field of a multipart form. ?prefix= places the files
+// under a folder, ?overwrite=true replaces existing documents,
+// ?dry_run=true reports what would be imported without writing and
+// ?atomic=true writes nothing unless every file imports.
+func (s *Server) handleImportDocuments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "request must be a multipart form with a ZIP file in 'archive'",
+		})
+		return
+	}
+	defer file.Close()
+	defer r.MultipartForm.RemoveAll()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "archive is not a valid ZIP file",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	opts := document.ImportOptions{
+		Prefix:    query.Get("prefix"),
+		Overwrite: query.Get("overwrite") == "true",
+		DryRun:    query.Get("dry_run") == "true",
+		MaxSize:   config.GetInt(db, document.MaxDocumentSizeConfigKey, 10) << 20,
+	}
+	written := []*document.Document{}
+	opts.OnWrite = func(doc *document.Document) {
+		written = append(written, doc)
+	}
+
+	// ?atomic=true imports every file or none: the writes share the request's
+	// transaction, which is rolled back when any file fails
+	var report *document.ImportReport
+	if query.Get("atomic") == "true" && !opts.DryRun {
+		err = withTransaction(r, db, func(tx *sql.Tx) error {
+			var err error
+			if report, err = document.ImportZip(tx, archive, userID, opts); err != nil {
+				return err
+			}
+			if report.Failed > 0 {
+				return errImportFailed
+			}
+			return nil
+		})
+	} else {
+		report, err = document.ImportZip(db, archive, userID, opts)
+	}
+	if errors.Is(err, errImportFailed) {
+		report.RolledBack = true
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	for _, doc := range written {
+		s.queueDocumentScan(db, doc)
+		s.installSeedFixture(db, doc, userID, role)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
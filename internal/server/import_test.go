@@ -0,0 +1,118 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestDocumentImportAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents/import", srv.handleImportDocuments)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"index.html":  "<h1>Home</h1>",
+		"js/app.js":   "console.log('hi')",
+		"favicon.ico": "\x00\x00\x01\x00",
+	} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+
+	upload := func(query string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("archive", "site.zip")
+		part.Write(data)
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/"+cenvID+"/documents/import"+query, bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := upload("?prefix=site&dry_run=true", archive.Bytes())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report document.ImportReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if !report.DryRun || report.Created != 3 {
+		t.Fatalf("Unexpected dry run report: %+v", report)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/site/index.html", token, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected dry run to write nothing, got %d", w.Code)
+	}
+
+	w = upload("?prefix=site", archive.Bytes())
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || report.DryRun || report.Created != 3 {
+		t.Fatalf("Unexpected import: %d %+v", w.Code, report)
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/documents/site/favicon.ico", token, nil)
+	var doc document.Document
+	json.NewDecoder(w.Body).Decode(&doc)
+	if w.Code != http.StatusOK || !doc.IsBinary {
+		t.Errorf("Expected favicon imported as binary, got %d %+v", w.Code, doc)
+	}
+
+	// A second import skips what already exists
+	w = upload("?prefix=site", archive.Bytes())
+	json.NewDecoder(w.Body).Decode(&report)
+	if report.Skipped != 3 || report.Created != 0 {
+		t.Errorf("Expected existing documents to be skipped, got %+v", report)
+	}
+
+	if w := upload("", []byte("not a zip")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid archive, got %d", w.Code)
+	}
+
+	// An atomic import with a failing file writes none of the others
+	var partial bytes.Buffer
+	zw = zip.NewWriter(&partial)
+	for _, name := range []string{"notes/a.txt", "../escape.txt"} {
+		f, _ := zw.Create(name)
+		f.Write([]byte("content of " + name))
+	}
+	zw.Close()
+
+	w = upload("?atomic=true", partial.Bytes())
+	report = document.ImportReport{}
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusUnprocessableEntity || !report.RolledBack || report.Failed != 1 {
+		t.Fatalf("Unexpected atomic import: %d %+v", w.Code, report)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/notes/a.txt", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the rolled back import to write nothing, got %d", w.Code)
+	}
+
+	w = upload("", partial.Bytes())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a non-atomic import to succeed, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/notes/a.txt", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the non-atomic import to keep the good file, got %d", w.Code)
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+)
+
+// Background jobs reported by the operator status API and metrics
+const (
+	jobExpirySweep   = "expiry_sweep"
+	jobCenvSizes     = "cenv_sizes"
+	jobIdleArchive   = "idle_archive"
+	jobEphemeral     = "ephemeral_expiry"
+	jobRetention     = "retention_purge"
+	jobAlertDelivery = "alert_delivery"
+)
+
+// stalledAfterIntervals is how many intervals a scheduled job may go without
+// running before it is reported as stalled
+const stalledAfterIntervals = 3
+
+// JobStatus reports the health of a background job. Scheduled jobs have an
+// interval and are stalled when they stop running; event-driven jobs, such
+// as alert delivery, report their queue instead.
+type JobStatus struct {
+	Name           string `json:"name"`
+	Interval       int64  `json:"interval_seconds,omitempty"`
+	LastRunAt      int64  `json:"last_run_at,omitempty"`
+	LastSuccessAt  int64  `json:"last_success_at,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	LastDurationMs int64  `json:"last_duration_ms"`
+	Successes      int64  `json:"successes"`
+	Failures       int64  `json:"failures"`
+	QueueDepth     int64  `json:"queue_depth"`
+	Stalled        bool   `json:"stalled"`
+}
+
+// jobRegistry tracks the runs of the server's scheduled jobs
+type jobRegistry struct {
+	mu      sync.Mutex
+	started map[string]time.Time // When each job was scheduled
+	jobs    map[string]*JobStatus
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{started: map[string]time.Time{}, jobs: map[string]*JobStatus{}}
+}
+
+// schedule registers a job that should run every interval
+func (j *jobRegistry) schedule(name string, interval time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.started[name] = clock.Now()
+	j.jobs[name] = &JobStatus{Name: name, Interval: int64(interval / time.Second)}
+}
+
+// run runs a job and records its outcome
+func (j *jobRegistry) run(name string, job func() error) {
+	start := clock.Now()
+	err := job()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status, ok := j.jobs[name]
+	if !ok {
+		status = &JobStatus{Name: name}
+		j.started[name] = start
+		j.jobs[name] = status
+	}
+	status.LastRunAt = start.Unix()
+	status.LastDurationMs = clock.Now().Sub(start).Milliseconds()
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+	} else {
+		status.Successes++
+		status.LastSuccessAt = start.Unix()
+	}
+}
+
+// statuses returns the status of every scheduled job as of now
+func (j *jobRegistry) statuses(now time.Time) []JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(j.jobs))
+	for name, status := range j.jobs {
+		current := *status
+		if current.Interval > 0 {
+			last := j.started[name]
+			if current.LastRunAt > 0 {
+				last = time.Unix(current.LastRunAt, 0)
+			}
+			current.Stalled = now.Sub(last) > stalledAfterIntervals*time.Duration(current.Interval)*time.Second
+		}
+		statuses = append(statuses, current)
+	}
+	return statuses
+}
+
+// jobStatuses reports the scheduled jobs and alert delivery, sorted by name
+func (s *Server) jobStatuses() []JobStatus {
+	statuses := s.jobs.statuses(clock.Now())
+
+	deliveries := s.monitor.DeliveryStats()
+	statuses = append(statuses, JobStatus{
+		Name:       jobAlertDelivery,
+		LastRunAt:  deliveries.LastAttemptAt,
+		LastError:  deliveries.LastError,
+		Successes:  deliveries.Delivered,
+		Failures:   deliveries.Failed,
+		QueueDepth: deliveries.Pending,
+	})
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// handleOperatorJobs reports the health of every background job. healthy is
+// false while a scheduled job is stalled.
+// Route: GET /operator/jobs
+func (s *Server) handleOperatorJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireOperator(w, r) {
+		return // Response already sent
+	}
+
+	statuses := s.jobStatuses()
+	healthy := true
+	for _, status := range statuses {
+		if status.Stalled {
+			healthy = false
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs":    statuses,
+		"healthy": healthy,
+	})
+}
+
+// handleOperatorMetrics exposes the background job statuses and recovered
+// panics in the Prometheus text format
+// Route: GET /operator/metrics
+func (s *Server) handleOperatorMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireOperator(w, r) {
+		return // Response already sent
+	}
+
+	statuses := s.jobStatuses()
+	var out strings.Builder
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	gauge := func(name, help string, value func(JobStatus) int64) {
+		header(name, "gauge", help)
+		for _, status := range statuses {
+			fmt.Fprintf(&out, "%s{job=%q} %d\n", name, status.Name, value(status))
+		}
+	}
+
+	gauge("wce_job_last_run_timestamp_seconds", "Unix time the job last ran, 0 if it has not.",
+		func(status JobStatus) int64 { return status.LastRunAt })
+	gauge("wce_job_last_success_timestamp_seconds", "Unix time the job last succeeded, 0 if it has not.",
+		func(status JobStatus) int64 { return status.LastSuccessAt })
+	header("wce_job_runs_total", "counter", "Finished job runs by result.")
+	for _, status := range statuses {
+		fmt.Fprintf(&out, "wce_job_runs_total{job=%q,result=\"success\"} %d\n", status.Name, status.Successes)
+		fmt.Fprintf(&out, "wce_job_runs_total{job=%q,result=\"failure\"} %d\n", status.Name, status.Failures)
+	}
+	gauge("wce_job_queue_depth", "Work waiting for or in progress in the job.",
+		func(status JobStatus) int64 { return status.QueueDepth })
+	gauge("wce_job_stalled", "1 when a scheduled job has missed several runs.",
+		func(status JobStatus) int64 {
+			if status.Stalled {
+				return 1
+			}
+			return 0
+		})
+
+	counts, routes := s.panics.snapshot()
+	header("wce_http_panics_total", "counter", "Handler panics recovered, by route pattern.")
+	for _, route := range routes {
+		fmt.Fprintf(&out, "wce_http_panics_total{route=%q} %d\n", route, counts[route])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(out.String()))
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestJobRegistry(t *testing.T) {
+	jobs := newJobRegistry()
+	jobs.schedule(jobExpirySweep, time.Minute)
+
+	jobs.run(jobExpirySweep, func() error { return nil })
+	jobs.run(jobExpirySweep, func() error { return errors.New("cenv x: disk full") })
+
+	statuses := jobs.statuses(time.Now())
+	if len(statuses) != 1 {
+		t.Fatalf("Expected one job, got %+v", statuses)
+	}
+	status := statuses[0]
+	if status.Successes != 1 || status.Failures != 1 || status.LastError != "cenv x: disk full" || status.Interval != 60 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if status.LastRunAt == 0 || status.LastSuccessAt == 0 || status.Stalled {
+		t.Errorf("Expected a recent run, got %+v", status)
+	}
+
+	// A job that stops running is reported as stalled
+	if later := jobs.statuses(time.Now().Add(4 * time.Minute)); !later[0].Stalled {
+		t.Errorf("Expected the job to be stalled, got %+v", later[0])
+	}
+
+	// So is one that never ran at all
+	jobs.schedule(jobIdleArchive, time.Minute)
+	for _, status := range jobs.statuses(time.Now().Add(4 * time.Minute)) {
+		if !status.Stalled {
+			t.Errorf("Expected %s to be stalled", status.Name)
+		}
+	}
+}
+
+func TestOperatorJobsAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+	srv.SetOperatorToken("operator-secret")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /operator/jobs", srv.handleOperatorJobs)
+	mux.HandleFunc("GET /operator/metrics", srv.handleOperatorMetrics)
+
+	srv.jobs.schedule(jobExpirySweep, time.Minute)
+	srv.jobs.run(jobExpirySweep, srv.sweepExpiredDocuments)
+
+	if w := doJSON(t, mux, "GET", "/operator/jobs", "wrong", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", w.Code)
+	}
+
+	w := doJSON(t, mux, "GET", "/operator/jobs", "operator-secret", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Jobs    []JobStatus `json:"jobs"`
+		Healthy bool        `json:"healthy"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Healthy || len(resp.Jobs) != 2 {
+		t.Fatalf("Unexpected job status: %+v", resp)
+	}
+	if resp.Jobs[0].Name != jobAlertDelivery || resp.Jobs[1].Name != jobExpirySweep || resp.Jobs[1].Successes != 1 {
+		t.Errorf("Unexpected jobs: %+v", resp.Jobs)
+	}
+
+	w = doJSON(t, mux, "GET", "/operator/metrics", "operator-secret", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Expected text metrics, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE wce_job_runs_total counter",
+		`wce_job_runs_total{job="expiry_sweep",result="success"} 1`,
+		`wce_job_runs_total{job="expiry_sweep",result="failure"} 0`,
+		`wce_job_queue_depth{job="alert_delivery"} 0`,
+		`wce_job_stalled{job="expiry_sweep"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/auth"
+)
+
+// JWTKeyFile holds the keys that sign tokens, kept in the storage directory
+// unless secrets are configured with SetJWTSecrets
+const JWTKeyFile = "jwt.keys"
+
+// MinJWTSecretLength is the shortest secret SetJWTSecrets accepts
+const MinJWTSecretLength = 32
+
+// SetJWTSecrets signs tokens with configured secrets instead of the key file,
+// e.g. so instances that share no storage accept each other's tokens. The
+// first secret signs; the rest only verify, so a replaced secret can stay
+// listed until the tokens it signed expire. Rotation is then done by
+// changing the configuration.
+func (s *Server) SetJWTSecrets(secrets ...string) error {
+	if len(secrets) == 0 {
+		return fmt.Errorf("no JWT secret")
+	}
+	for _, secret := range secrets {
+		if len(secret) < MinJWTSecretLength {
+			return fmt.Errorf("JWT secrets must be at least %d characters", MinJWTSecretLength)
+		}
+	}
+	s.jwtManager = auth.NewJWTManagerWithKeys(auth.StaticJWTKeys(secrets...))
+	return nil
+}
+
+// handleOperatorJWTKeys lists the keys tokens are verified with, without
+// their secrets
+// Route: GET /operator/jwt/keys
+func (s *Server) handleOperatorJWTKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireOperator(w, r) {
+		return // Response already sent
+	}
+
+	keys := s.jwtManager.Keys()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys":      keys.List(),
+		"rotatable": keys.Rotatable(),
+	})
+}
+
+// handleRotateJWTKey makes a new key sign tokens. Tokens signed by earlier
+// keys stay valid until they expire; keys retired longer ago than the
+// longest token lifetime are dropped.
+// Route: POST /operator/jwt/keys/rotate
+func (s *Server) handleRotateJWTKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireOperator(w, r) {
+		return // Response already sent
+	}
+
+	keys := s.jwtManager.Keys()
+	id, err := keys.Rotate(MaxScopedTokenLifetime)
+	if errors.Is(err, auth.ErrStaticJWTKeys) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "JWT secrets are configured; rotate them in the configuration",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate JWT key: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to rotate JWT key",
+		})
+		return
+	}
+
+	log.Printf("JWT signing key rotated to %s by the operator", id)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"current": id,
+		"keys":    keys.List(),
+	})
+}
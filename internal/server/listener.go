@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DefaultDrainTimeout is how long a stopping server waits for in-flight
+// requests, including page renders and Starlark executions, to finish
+const DefaultDrainTimeout = 30 * time.Second
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// SetReusePort binds the listening socket with SO_REUSEPORT, so a newly
+// started process can listen on the same port while the old one drains.
+// It is ignored when systemd passes in the socket.
+func (s *Server) SetReusePort(reuse bool) {
+	s.reusePort = reuse
+}
+
+// SetDrainTimeout sets how long shutdown waits for in-flight requests
+func (s *Server) SetDrainTimeout(timeout time.Duration) {
+	s.drainTimeout = timeout
+}
+
+// listen returns the socket passed by systemd socket activation when there
+// is one, and otherwise binds the configured port
+func (s *Server) listen() (net.Listener, error) {
+	listener, err := activationListener()
+	if err != nil || listener != nil {
+		return listener, err
+	}
+
+	config := net.ListenConfig{}
+	if s.reusePort {
+		config.Control = func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			if err := conn.Control(func(fd uintptr) { sockErr = setReusePort(fd) }); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return config.Listen(context.Background(), "tcp", fmt.Sprintf(":%d", s.port))
+}
+
+// activationListener takes over the first socket passed with the
+// LISTEN_PID/LISTEN_FDS protocol, or returns nil when none was passed.
+// The variables are cleared so child processes do not inherit them.
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(listenFDsStart), "systemd-socket")
+	defer file.Close() // FileListener holds its own duplicate
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use activated socket: %w", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestReusePortListen(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+
+	first := New(0, manager)
+	first.SetReusePort(true)
+	l1, err := first.listen()
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l1.Close()
+	port := l1.Addr().(*net.TCPAddr).Port
+
+	plain := New(port, manager)
+	if l, err := plain.listen(); err == nil {
+		l.Close()
+		t.Error("Expected bind without SO_REUSEPORT to fail")
+	}
+
+	// A replacement process can take the port while the old one still listens
+	second := New(port, manager)
+	second.SetReusePort(true)
+	l2, err := second.listen()
+	if err != nil {
+		t.Fatalf("Expected second SO_REUSEPORT bind to succeed: %v", err)
+	}
+	l2.Close()
+}
+
+func TestActivationListenerIgnoresOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	listener, err := activationListener()
+	if listener != nil || err != nil {
+		t.Errorf("Expected no activated socket for another pid, got %v (%v)", listener, err)
+	}
+}
+
+func TestHealthDraining(t *testing.T) {
+	srv := New(0, cenv.NewManager(t.TempDir()))
+
+	w := httptest.NewRecorder()
+	srv.handleHealth(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+
+	srv.draining.Store(true)
+	w = httptest.NewRecorder()
+	srv.handleHealth(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %d", w.Code)
+	}
+}
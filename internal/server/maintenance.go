@@ -0,0 +1,48 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultSweepInterval is how often the server runs background maintenance:
+// removing expired documents, sampling cenv sizes, archiving idle cenvs,
+// dropping expired ephemeral cenvs and purging data past its retention
+const DefaultSweepInterval = time.Minute
+
+// SetSweepInterval sets how often background maintenance runs; 0 disables it
+func (s *Server) SetSweepInterval(interval time.Duration) {
+	s.sweepInterval = interval
+}
+
+// runMaintenance runs the background maintenance tasks every sweepInterval
+// until stop is closed, recording each run for the operator's job status
+func (s *Server) runMaintenance(stop <-chan struct{}) {
+	for _, job := range []string{jobExpirySweep, jobCenvSizes, jobIdleArchive, jobEphemeral, jobRetention} {
+		s.jobs.schedule(job, s.sweepInterval)
+	}
+
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.jobs.run(jobExpirySweep, s.sweepExpiredDocuments)
+			s.jobs.run(jobCenvSizes, s.recordCenvSizes)
+			s.jobs.run(jobIdleArchive, s.archiveIdleCenvs)
+			s.jobs.run(jobEphemeral, s.expireEphemeralCenvs)
+			s.jobs.run(jobRetention, s.purgeRetainedData)
+		}
+	}
+}
+
+// expireEphemeralCenvs drops the ephemeral cenvs whose TTL has passed
+func (s *Server) expireEphemeralCenvs() error {
+	for _, cenvID := range s.cenvManager.ExpireEphemeral(time.Now()) {
+		log.Printf("Expired ephemeral cenv %s", cenvID)
+	}
+	return nil
+}
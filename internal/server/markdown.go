@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/markdown"
+	"github.com/thetanil/wce/internal/template"
+)
+
+// markdownTemplateConfigKey names the template document that wraps markdown
+// rendered to HTML; empty serves a bare HTML page
+const markdownTemplateConfigKey = "markdown_template"
+
+// isMarkdown reports whether a document holds markdown text
+func isMarkdown(doc *document.Document) bool {
+	mediaType, _, err := mime.ParseMediaType(doc.ContentType)
+	return err == nil && !doc.IsBinary && (mediaType == "text/markdown" || mediaType == "text/x-markdown")
+}
+
+// acceptsHTML reports whether an Accept header lists text/html
+func acceptsHTML(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err == nil && mediaType == "text/html" && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMarkdownHTML serves a markdown document rendered to sanitized HTML,
+// wrapped in the cenv's markdown_template when one is configured. The
+// template gets the rendered body as content (output it with |safe), the
+// front matter title or else the first heading as title, and the document's
+// id, content_type, tags, metadata, front_matter and modified_at as
+// document. Front matter is not part of the rendered body.
+func (s *Server) writeMarkdownHTML(w http.ResponseWriter, r *http.Request, db *sql.DB, doc *document.Document) {
+	disposition, err := document.ResolveDisposition(db, doc.ContentType)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to check mime policy",
+		})
+		return
+	}
+	if disposition == document.DispositionReject {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "content type not allowed: " + doc.ContentType,
+		})
+		return
+	}
+
+	_, source, _ := document.SplitFrontMatter(doc.Content)
+	frontMatter := map[string]interface{}{}
+	json.Unmarshal(doc.FrontMatter, &frontMatter)
+
+	body := markdown.ToHTML(source)
+	title, _ := frontMatter["title"].(string)
+	if title == "" {
+		title = markdown.Title(source)
+	}
+	if title == "" {
+		title = doc.ID
+	}
+
+	page := "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + html.EscapeString(title) +
+		"</title>\n</head>\n<body>\n" + body + "</body>\n</html>\n"
+
+	if templateID := config.GetString(db, markdownTemplateConfigKey, ""); templateID != "" {
+		var metadata map[string]interface{}
+		json.Unmarshal(doc.Metadata, &metadata)
+		tags := make([]interface{}, len(doc.Tags))
+		for i, tag := range doc.Tags {
+			tags[i] = tag
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		rendered, err := template.RenderDocument(ctx, db, templateID, map[string]interface{}{
+			"content": body,
+			"title":   title,
+			"document": map[string]interface{}{
+				"id":           doc.ID,
+				"content_type": doc.ContentType,
+				"tags":         tags,
+				"metadata":     metadata,
+				"front_matter": frontMatter,
+				"modified_at":  doc.ModifiedAt,
+			},
+		})
+		if err != nil {
+			log.Printf("Markdown template %s failed for %s: %v", templateID, doc.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "failed to render markdown template: " + err.Error(),
+			})
+			return
+		}
+		page = rendered.Content
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(page))
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+)
+
+func TestMarkdownRenderedAsHTML(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	docs := []map[string]interface{}{
+		{"id": "docs/intro.md", "content": "# Intro & *Setup*\n\nRun `wce` <script>x</script>", "content_type": "text/markdown; charset=utf-8", "tags": []string{"guide"}},
+		{"id": "layouts/doc.html", "content": "<title>{{ title }}</title><main data-id=\"{{ document.id }}\">{{ content|safe }}</main>", "content_type": "text/html+jinja"},
+	}
+	for _, doc := range docs {
+		if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, doc); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+cenvID+"/documents/docs/intro.md", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	const body = "<h1 id=\"intro--setup\">Intro &amp; <em>Setup</em></h1>\n<p>Run <code>wce</code> &lt;script&gt;x&lt;/script&gt;</p>\n"
+
+	// Browsers get a standalone page
+	w := get("text/html,application/xhtml+xml,*/*;q=0.8")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("Expected 200 HTML, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "<title>Intro &amp; Setup</title>") || !strings.Contains(w.Body.String(), body) {
+		t.Errorf("Unexpected page: %s", w.Body.String())
+	}
+
+	// The source is still served as is
+	if w := get("text/markdown; charset=utf-8"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "# Intro") {
+		t.Errorf("Expected markdown source, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := get("text/html;q=0"); strings.Contains(w.Body.String(), "<h1") {
+		t.Errorf("Expected text/html;q=0 not to render: %s", w.Body.String())
+	}
+
+	// A configured template wraps the body
+	db, _ := manager.GetConnection(cenvID)
+	config.Set(db, markdownTemplateConfigKey, "layouts/doc.html", "")
+	w = get("text/html")
+	want := "<title>Intro &amp; Setup</title><main data-id=\"docs/intro.md\">" + body + "</main>"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("Expected templated page, got %d:\n%s\nwant:\n%s", w.Code, w.Body.String(), want)
+	}
+
+	config.Set(db, markdownTemplateConfigKey, "layouts/missing.html", "")
+	if w := get("text/html"); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for a missing template, got %d", w.Code)
+	}
+}
+
+func TestMarkdownFrontMatter(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	docs := []map[string]interface{}{
+		{"id": "posts/hello.md", "content": "---\ntitle: Hello\nauthor: alice\n---\n# Heading\n", "content_type": "text/markdown"},
+		{"id": "layouts/post.html", "content": "{{ title }} by {{ document.front_matter.author }}: {{ content|safe }}", "content_type": "text/html+jinja"},
+	}
+	for _, doc := range docs {
+		if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, doc); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+		"id": "posts/bad.md", "content": "---\ntitle: [\n---\n", "content_type": "text/markdown",
+	}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid front matter, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/documents?metadata.front_matter.author=alice", token, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"front_matter":{"author":"alice","title":"Hello"}`) {
+		t.Errorf("Expected listed front matter, got %d: %s", w.Code, w.Body.String())
+	}
+
+	db, _ := manager.GetConnection(cenvID)
+	config.Set(db, markdownTemplateConfigKey, "layouts/post.html", "")
+	req := httptest.NewRequest("GET", "/"+cenvID+"/documents/posts/hello.md", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	want := "Hello by alice: <h1 id=\"heading\">Heading</h1>\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("Expected %q, got %d: %q", want, w.Code, w.Body.String())
+	}
+}
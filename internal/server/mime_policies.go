@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// MIMEPolicyRequest represents request to set or delete a serving policy
+type MIMEPolicyRequest struct {
+	ContentType string `json:"content_type"` // e.g. "image/svg+xml", "text/*", "*/*"
+	Disposition string `json:"disposition"`  // "inline", "attachment", "reject"
+}
+
+// handleListMIMEPolicies lists content type serving policies (admin/owner only)
+func (s *Server) handleListMIMEPolicies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "list mime policies")
+	if err != nil {
+		return // Response already sent
+	}
+
+	policies, err := document.ListMIMEPolicies(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list mime policies",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policies": policies,
+	})
+}
+
+// handleSetMIMEPolicy creates or updates a content type serving policy (admin/owner only)
+func (s *Server) handleSetMIMEPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "set mime policies")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "set_mime_policy", "mime_policy", ""); err != nil {
+		return // Response already sent
+	}
+
+	var req MIMEPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if req.ContentType == "" || !document.IsValidDisposition(req.Disposition) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "content_type is required and disposition must be 'inline', 'attachment', or 'reject'",
+		})
+		return
+	}
+
+	if err := document.SetMIMEPolicy(db, req.ContentType, req.Disposition, userID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to set mime policy",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "mime policy set successfully",
+	})
+}
+
+// handleDeleteMIMEPolicy removes a content type serving policy (admin/owner only)
+func (s *Server) handleDeleteMIMEPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "delete mime policies")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "delete_mime_policy", "mime_policy", ""); err != nil {
+		return // Response already sent
+	}
+
+	var req MIMEPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ContentType == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "content_type is required",
+		})
+		return
+	}
+
+	if err := document.DeleteMIMEPolicy(db, req.ContentType); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "mime policy deleted successfully",
+	})
+}
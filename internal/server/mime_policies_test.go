@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestMIMEPolicyServing(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("GET /{cenvID}/admin/mime-policies", srv.handleListMIMEPolicies)
+	mux.HandleFunc("PUT /{cenvID}/admin/mime-policies", srv.handleSetMIMEPolicy)
+	mux.HandleFunc("DELETE /{cenvID}/admin/mime-policies", srv.handleDeleteMIMEPolicy)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for id, contentType := range map[string]string{
+		"assets/page.html": "text/html",
+		"assets/logo.svg":  "image/svg+xml",
+		"assets/notes.txt": "text/plain",
+	} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id":           id,
+			"content":      "<svg></svg>",
+			"content_type": contentType,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create %s: %d %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	setPolicy := func(contentType, disposition string) {
+		w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/mime-policies", token, map[string]string{
+			"content_type": contentType,
+			"disposition":  disposition,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to set policy: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	setPolicy("text/html", "attachment")
+	setPolicy("image/svg+xml", "reject")
+
+	getRaw := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+cenvID+"/documents/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "*/*")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Attachment", func(t *testing.T) {
+		w := getRaw("assets/page.html")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("Expected attachment disposition, got '%s'", w.Header().Get("Content-Disposition"))
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		w := getRaw("assets/logo.svg")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("InlineByDefault", func(t *testing.T) {
+		w := getRaw("assets/notes.txt")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		if w.Header().Get("Content-Disposition") != "" {
+			t.Errorf("Expected no disposition header, got '%s'", w.Header().Get("Content-Disposition"))
+		}
+	})
+
+	t.Run("InvalidDisposition", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/mime-policies", token, map[string]string{
+			"content_type": "text/plain",
+			"disposition":  "execute",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("DeletePolicy", func(t *testing.T) {
+		w := doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/mime-policies", token, map[string]string{
+			"content_type": "image/svg+xml",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := getRaw("assets/logo.svg"); w.Code != http.StatusOK {
+			t.Errorf("Expected 200 after deleting policy, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thetanil/wce/internal/cenv"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// schemaParam validates an optional JSON Schema and converts it to a nullable SQL value
+func schemaParam(raw json.RawMessage) (interface{}, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(trimmed, &schema); err != nil {
+		return nil, fmt.Errorf("must be a JSON object")
+	}
+
+	return string(trimmed), nil
+}
+
+// handleOpenAPI serves an OpenAPI 3 document describing the cenv's Starlark endpoints
+// Route: GET /{cenvID}/openapi.json
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	spec, err := buildOpenAPISpec(db, cenvID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
+
+// buildOpenAPISpec generates the OpenAPI document from enabled endpoints.
+// Summaries and descriptions come from the endpoint description or the
+// handle_request docstring; schemas come from the endpoint metadata.
+func buildOpenAPISpec(db *sql.DB, cenvID string) (map[string]interface{}, error) {
+	rows, err := db.Query(`
+		SELECT path, method, script, description, request_schema, response_schema
+		FROM _wce_endpoints
+		WHERE enabled = 1
+		ORDER BY path, method
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	paths := make(map[string]interface{})
+	for rows.Next() {
+		var path, method, script string
+		var description, requestSchema, responseSchema sql.NullString
+		if err := rows.Scan(&path, &method, &script, &description, &requestSchema, &responseSchema); err != nil {
+			return nil, fmt.Errorf("failed to scan endpoint: %w", err)
+		}
+
+		summary, details := starlark_pkg.SplitDocstring(starlark_pkg.HandlerDocstring(script))
+		if description.String != "" {
+			if summary == "" {
+				summary = description.String
+			} else if details == "" {
+				details = description.String
+			}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": operationID(method, path),
+			"responses": map[string]interface{}{
+				"200": openAPIResponse(responseSchema),
+			},
+		}
+		if summary != "" {
+			operation["summary"] = summary
+		}
+		if details != "" {
+			operation["description"] = details
+		}
+		if requestSchema.Valid {
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": json.RawMessage(requestSchema.String),
+					},
+				},
+			}
+		}
+
+		fullPath := "/" + cenvID + "/star" + path
+		item, ok := paths[fullPath].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[fullPath] = item
+		}
+
+		for _, m := range openAPIMethods(method) {
+			// A specific method takes precedence over a wildcard definition
+			if _, exists := item[m]; exists && method == "*" {
+				continue
+			}
+			item[m] = operation
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating endpoints: %w", err)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "WCE cenv " + cenvID,
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{},
+		},
+	}, nil
+}
+
+// openAPIResponse builds the default response object for an operation
+func openAPIResponse(schema sql.NullString) map[string]interface{} {
+	response := map[string]interface{}{
+		"description": "Successful response",
+	}
+	if schema.Valid {
+		response["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": json.RawMessage(schema.String),
+			},
+		}
+	}
+	return response
+}
+
+// openAPIMethods maps an endpoint method to OpenAPI operation keys
+func openAPIMethods(method string) []string {
+	if method == "*" {
+		return []string{"get", "post", "put", "delete"}
+	}
+	return []string{strings.ToLower(method)}
+}
+
+// operationID derives a stable operation identifier from method and path
+func operationID(method, path string) string {
+	if method == "*" {
+		method = "any"
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		b.WriteString("_" + part)
+	}
+	return b.String()
+}
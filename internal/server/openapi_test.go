@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestOpenAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/openapi.json", srv.handleOpenAPI)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path":   "/widgets",
+		"method": "POST",
+		"script": `def handle_request(req):
+    """Create a widget.
+
+    Stores the widget and returns it.
+    """
+    return response({}, status=201)`,
+		"request_schema":  map[string]interface{}{"type": "object", "required": []string{"name"}},
+		"response_schema": map[string]interface{}{"type": "object"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path":        "/ping",
+		"method":      "GET",
+		"script":      "def handle_request(req):\n    return response('pong')",
+		"description": "Health probe",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	t.Run("InvalidSchema", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path":           "/bad",
+			"method":         "GET",
+			"script":         "def handle_request(req):\n    return response('')",
+			"request_schema": "not an object",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/openapi.json", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var spec struct {
+		OpenAPI string                                       `json:"openapi"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatalf("Failed to decode spec: %v", err)
+	}
+
+	if spec.OpenAPI == "" {
+		t.Error("Expected openapi version")
+	}
+
+	create, ok := spec.Paths["/"+cenvID+"/star/widgets"]["post"]
+	if !ok {
+		t.Fatalf("Expected POST /widgets operation, got %v", spec.Paths)
+	}
+	if create["summary"] != "Create a widget." {
+		t.Errorf("Expected docstring summary, got %v", create["summary"])
+	}
+	if create["description"] != "Stores the widget and returns it." {
+		t.Errorf("Expected docstring description, got %v", create["description"])
+	}
+	if create["requestBody"] == nil {
+		t.Error("Expected requestBody from request_schema")
+	}
+
+	ping, ok := spec.Paths["/"+cenvID+"/star/ping"]["get"]
+	if !ok {
+		t.Fatal("Expected GET /ping operation")
+	}
+	if ping["summary"] != "Health probe" {
+		t.Errorf("Expected description as summary, got %v", ping["summary"])
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/alerts"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// SetOperatorToken enables the operator API under /operator/ for requests
+// bearing token. Without a token the operator API is disabled.
+func (s *Server) SetOperatorToken(token string) {
+	s.operatorToken = token
+}
+
+// requireOperator checks the operator bearer token, writing an error
+// response when it is missing or wrong
+func (s *Server) requireOperator(w http.ResponseWriter, r *http.Request) bool {
+	if s.operatorToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "operator API is disabled"})
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.operatorToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid operator token"})
+		return false
+	}
+	return true
+}
+
+// CenvUsage reports a cenv's storage to the operator
+type CenvUsage struct {
+	CenvID       string            `json:"cenv_id"`
+	Archived     bool              `json:"archived"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	SizeBytes    int64             `json:"size_bytes"`               // Current size, or last sampled when archived
+	GrowthPerDay *int64            `json:"growth_per_day,omitempty"` // Bytes, over the day before the last sample
+	Samples      []cenv.SizeSample `json:"samples,omitempty"`        // Only for a single cenv
+}
+
+// cenvUsage collects a cenv's size, growth and optionally its history
+func (s *Server) cenvUsage(cenvID string, withSamples bool) CenvUsage {
+	history := s.cenvManager.SizeHistory(cenvID)
+	usage := CenvUsage{
+		CenvID:     cenvID,
+		Archived:   s.cenvManager.IsArchived(cenvID),
+		Attributes: s.cenvManager.Attributes(cenvID),
+	}
+	if size, err := s.cenvManager.DatabaseSize(cenvID); err == nil {
+		usage.SizeBytes = size
+	} else if len(history) > 0 {
+		usage.SizeBytes = history[len(history)-1].Bytes
+	}
+	if growth, ok := cenv.GrowthPerDay(history, len(history)-1); ok {
+		usage.GrowthPerDay = &growth
+	}
+	if withSamples {
+		usage.Samples = history
+	}
+	return usage
+}
+
+// handleOperatorListCenvs lists every cenv with its size and growth
+// Route: GET /operator/cenvs
+func (s *Server) handleOperatorListCenvs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireOperator(w, r) {
+		return // Response already sent
+	}
+
+	cenvIDs, err := s.cenvManager.List()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to list cenvs"})
+		return
+	}
+	cenvIDs = append(cenvIDs, s.cenvManager.ArchivedCenvs()...)
+
+	usages := []CenvUsage{}
+	for _, cenvID := range cenvIDs {
+		usages = append(usages, s.cenvUsage(cenvID, false))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cenvs": usages,
+		"count": len(usages),
+	})
+}
+
+// handleOperatorGetCenv reports a cenv's size history
+// Route: GET /operator/cenvs/{cenvID}
+func (s *Server) handleOperatorGetCenv(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireOperator(w, r) {
+		return // Response already sent
+	}
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || (!s.cenvManager.Exists(cenvID) && !s.cenvManager.IsArchived(cenvID)) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.cenvUsage(cenvID, true))
+}
+
+// recordCenvSizes samples cenv sizes and alerts the operator about cenvs
+// whose latest sample crossed the size limit or the daily growth limit.
+// Each limit alerts once per crossing, not on every sample above it.
+func (s *Server) recordCenvSizes() error {
+	sampled, err := s.cenvManager.RecordSizes(time.Now())
+	if err != nil {
+		log.Printf("Failed to record cenv sizes: %v", err)
+		return fmt.Errorf("failed to record cenv sizes: %w", err)
+	}
+
+	policy := s.cenvManager.SizeAlerts()
+	if policy == nil {
+		return nil
+	}
+	channels := alerts.OperatorChannels{
+		WebhookURL: policy.WebhookURL,
+		SMTPAddr:   policy.SMTPAddr,
+		From:       policy.EmailFrom,
+		To:         policy.EmailTo,
+	}
+
+	for _, cenvID := range sampled {
+		history := s.cenvManager.SizeHistory(cenvID)
+		last := len(history) - 1
+		latest := history[last]
+
+		if policy.MaxBytes > 0 && latest.Bytes >= policy.MaxBytes && (last == 0 || history[last-1].Bytes < policy.MaxBytes) {
+			s.monitor.NotifyOperator(channels, cenvID, alerts.KindSizeThreshold,
+				fmt.Sprintf("cenv %s reached %d bytes (limit %d)", cenvID, latest.Bytes, policy.MaxBytes),
+				map[string]interface{}{"size_bytes": latest.Bytes, "max_bytes": policy.MaxBytes})
+		}
+
+		if policy.MaxGrowthPerDay > 0 {
+			growth, ok := cenv.GrowthPerDay(history, last)
+			previous, hadPrevious := cenv.GrowthPerDay(history, last-1)
+			if ok && growth > policy.MaxGrowthPerDay && (!hadPrevious || previous <= policy.MaxGrowthPerDay) {
+				s.monitor.NotifyOperator(channels, cenvID, alerts.KindSizeGrowth,
+					fmt.Sprintf("cenv %s grew %d bytes in a day (limit %d)", cenvID, growth, policy.MaxGrowthPerDay),
+					map[string]interface{}{"size_bytes": latest.Bytes, "growth_per_day": growth, "max_growth_per_day": policy.MaxGrowthPerDay})
+			}
+		}
+	}
+
+	return nil
+}
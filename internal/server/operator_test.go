@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestOperatorAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /operator/cenvs", srv.handleOperatorListCenvs)
+	mux.HandleFunc("GET /operator/cenvs/{cenvID}", srv.handleOperatorGetCenv)
+
+	cenvID, _ := setupTestCenv(t, mux)
+	srv.recordCenvSizes()
+
+	if w := doJSON(t, mux, "GET", "/operator/cenvs", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while the operator API is disabled, got %d", w.Code)
+	}
+
+	srv.SetOperatorToken("operator-secret")
+	if w := doJSON(t, mux, "GET", "/operator/cenvs", "wrong", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", w.Code)
+	}
+
+	w := doJSON(t, mux, "GET", "/operator/cenvs", "operator-secret", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list struct {
+		Cenvs []CenvUsage `json:"cenvs"`
+		Count int         `json:"count"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if list.Count != 1 || list.Cenvs[0].CenvID != cenvID || list.Cenvs[0].SizeBytes == 0 {
+		t.Errorf("Unexpected cenv list: %+v", list)
+	}
+
+	w = doJSON(t, mux, "GET", "/operator/cenvs/"+cenvID, "operator-secret", nil)
+	var usage CenvUsage
+	json.NewDecoder(w.Body).Decode(&usage)
+	if w.Code != http.StatusOK || len(usage.Samples) != 1 || usage.GrowthPerDay != nil {
+		t.Errorf("Expected one sample without growth, got %d: %+v", w.Code, usage)
+	}
+
+	if w := doJSON(t, mux, "GET", "/operator/cenvs/123e4567-e89b-12d3-a456-426614174000", "operator-secret", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown cenv, got %d", w.Code)
+	}
+}
+
+func TestSizeAlerts(t *testing.T) {
+	webhook := make(chan map[string]interface{}, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		webhook <- payload
+	}))
+	defer hook.Close()
+
+	storageDir := t.TempDir()
+	registry := `{"size_alerts": {"max_bytes": 1024, "webhook_url": "` + hook.URL + `",
+		"smtp_addr": "localhost:25", "email_from": "wce@example.com", "email_to": ["ops@example.com"]}}`
+	if err := os.WriteFile(filepath.Join(storageDir, cenv.RegistryFile), []byte(registry), 0600); err != nil {
+		t.Fatalf("Failed to write registry: %v", err)
+	}
+	manager := cenv.NewManager(storageDir)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+
+	srv := New(0, manager)
+	srv.SetEgressAllowlist(netip.MustParsePrefix("127.0.0.0/8")) // The test webhook is on loopback
+	mail := make(chan string, 4)
+	srv.monitor.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mail <- string(msg)
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	cenvID, _ := setupTestCenv(t, mux)
+
+	srv.recordCenvSizes()
+
+	select {
+	case payload := <-webhook:
+		alert := payload["alert"].(map[string]interface{})
+		if payload["cenv_id"] != cenvID || alert["kind"] != "size_threshold" {
+			t.Errorf("Unexpected webhook payload: %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a size alert webhook")
+	}
+	select {
+	case msg := <-mail:
+		if !strings.Contains(msg, "To: ops@example.com") || !strings.Contains(msg, cenvID) {
+			t.Errorf("Unexpected alert email: %s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a size alert email")
+	}
+
+	// No new sample within the interval, so no repeated alert
+	srv.recordCenvSizes()
+	select {
+	case payload := <-webhook:
+		t.Errorf("Expected no repeated alert, got %v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	storage := t.TempDir()
+	manager := cenv.NewManager(storage)
+	newMux := func(srv *Server) *http.ServeMux {
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /new", srv.handleNewCenv)
+		mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+		mux.HandleFunc("GET /{cenvID}/me/sessions", srv.handleListMySessions)
+		mux.HandleFunc("GET /operator/jwt/keys", srv.handleOperatorJWTKeys)
+		mux.HandleFunc("POST /operator/jwt/keys/rotate", srv.handleRotateJWTKey)
+		return mux
+	}
+
+	srv := New(0, manager)
+	srv.SetOperatorToken("operator-secret")
+	mux := newMux(srv)
+	cenvID, token := setupTestCenv(t, mux)
+
+	// Tokens outlive a restart
+	restarted := New(0, manager)
+	restarted.SetOperatorToken("operator-secret")
+	mux = newMux(restarted)
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected the token valid after a restart, got %d", w.Code)
+	}
+
+	if w := doJSON(t, mux, "POST", "/operator/jwt/keys/rotate", "wrong", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", w.Code)
+	}
+	w := doJSON(t, mux, "POST", "/operator/jwt/keys/rotate", "operator-secret", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to rotate: %d %s", w.Code, w.Body.String())
+	}
+	var rotated struct {
+		Current string            `json:"current"`
+		Keys    []auth.JWTKeyInfo `json:"keys"`
+	}
+	json.NewDecoder(w.Body).Decode(&rotated)
+	if len(rotated.Keys) != 2 || rotated.Keys[0].ID != rotated.Current || rotated.Keys[1].RetiredAt == 0 {
+		t.Errorf("Unexpected keys after rotation: %+v", rotated)
+	}
+
+	// Tokens of the retired key stay valid, and new ones use the new key
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the earlier token valid after rotation, got %d", w.Code)
+	}
+	newToken := loginAs(t, mux, cenvID, "admin", "adminpass123")
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", newToken, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the new token valid, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(storage, JWTKeyFile)); err != nil {
+		t.Errorf("Expected the keys in the storage directory: %v", err)
+	}
+
+	// Configured secrets are rotated in the configuration instead
+	if err := restarted.SetJWTSecrets("too-short"); err == nil {
+		t.Error("Expected a short secret refused")
+	}
+	restarted.SetJWTSecrets(strings.Repeat("s", MinJWTSecretLength))
+	if w := doJSON(t, mux, "POST", "/operator/jwt/keys/rotate", "operator-secret", nil); w.Code != http.StatusConflict {
+		t.Errorf("Expected configured secrets not rotatable, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", newToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected tokens of the replaced keys refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// ChangePasswordRequest is the body of a password change
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// PasswordResetResponse carries a one-time reset token to hand to the user
+type PasswordResetResponse struct {
+	Username   string `json:"username"`
+	ResetToken string `json:"reset_token"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// ResetPasswordRequest is the body of a password reset
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// writePasswordError sends the status for a password change or reset failure
+func writePasswordError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrWrongPassword):
+		w.WriteHeader(http.StatusForbidden)
+	case errors.Is(err, auth.ErrPasswordTooShort), errors.Is(err, auth.ErrPasswordUnchanged),
+		errors.Is(err, auth.ErrInvalidResetToken):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		log.Printf("Failed to set password: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		err = errors.New("failed to set password")
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// handleChangePassword changes the caller's password. The current password
+// is required, and every other session of the caller is revoked.
+// Route: POST /{cenvID}/me/password
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "current_password and new_password are required",
+		})
+		return
+	}
+
+	user, err := auth.GetUserByID(db, userID)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	if err := auth.ChangePassword(db, user, req.CurrentPassword, req.NewPassword, bearerTokenHash(r)); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	auditPassword(r, db, userID, "change_password")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "password changed; other sessions were signed out",
+	})
+}
+
+// handleCreatePasswordReset issues a one-time token that sets a user's
+// password, for an admin to hand over when the user is locked out or their
+// password is compromised (admin/owner only; the owner's by the owner only).
+// Issuing a new token replaces any unused one.
+// Route: POST /{cenvID}/admin/users/{username}/password-reset
+func (s *Server) handleCreatePasswordReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	callerID, callerRole, db, err := s.requireUserManager(w, r, "reset passwords")
+	if err != nil {
+		return // Response already sent
+	}
+
+	username := r.PathValue("username")
+	user, err := auth.GetUserByUsername(db, username)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	if user.Role == authz.RoleOwner && callerRole != authz.RoleOwner {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "only the owner can change the owner account",
+		})
+		return
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, callerID, "create_password_reset", "user", user.UserID); err != nil {
+		return // Response already sent
+	}
+
+	token, expiresAt, err := auth.CreatePasswordReset(db, user.UserID, callerID, auth.DefaultResetTimeout)
+	if err != nil {
+		log.Printf("Failed to create password reset for %s: %v", username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to create password reset",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PasswordResetResponse{
+		Username:   user.Username,
+		ResetToken: token,
+		ExpiresAt:  expiresAt,
+	})
+}
+
+// handleResetPassword sets a password with a reset token and signs the user
+// out everywhere. No login is needed: the token is the credential.
+// Route: POST /{cenvID}/password/reset
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.NewPassword == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "token and new_password are required",
+		})
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		log.Printf("Failed to connect to cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to connect to database",
+		})
+		return
+	}
+
+	user, err := auth.ResetPassword(db, req.Token, req.NewPassword)
+	if err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	auditPassword(r, db, user.UserID, "reset_password")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "password reset; log in with the new password",
+		"username": user.Username,
+	})
+}
+
+// auditPassword records a user's password change or reset in the audit log
+func auditPassword(r *http.Request, db *sql.DB, userID, action string) {
+	if err := audit.Record(db, audit.Entry{
+		UserID:       userID,
+		Action:       action,
+		ResourceType: "user",
+		ResourceID:   userID,
+		IPAddress:    r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to audit %s of user %s: %v", action, userID, err)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/me/sessions", srv.handleListMySessions)
+	mux.HandleFunc("POST /{cenvID}/me/password", srv.handleChangePassword)
+	mux.HandleFunc("POST /{cenvID}/password/reset", srv.handleResetPassword)
+	mux.HandleFunc("POST /{cenvID}/admin/users/{username}/password-reset", srv.handleCreatePasswordReset)
+
+	cenvID, adminToken := setupTestCenv(t, mux)
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := auth.CreateUser(db, "bob", "bobpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	laptop := loginAs(t, mux, cenvID, "bob", "bobpass123")
+	phone := loginAs(t, mux, cenvID, "bob", "bobpass123")
+
+	path := "/" + cenvID + "/me/password"
+	if w := doJSON(t, mux, "POST", path, laptop, map[string]string{"current_password": "guess1234", "new_password": "bobnewpass1"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a wrong current password refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", path, laptop, map[string]string{"current_password": "bobpass123", "new_password": "short"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a short password refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", path, laptop, map[string]string{"current_password": "bobpass123", "new_password": "bobnewpass1"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to change password: %d %s", w.Code, w.Body.String())
+	}
+
+	// The changing session stays, the others are signed out
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", laptop, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the current session kept, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", phone, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected other sessions revoked, got %d", w.Code)
+	}
+	loginAs(t, mux, cenvID, "bob", "bobnewpass1")
+
+	// Only admins issue reset tokens, and only the owner for the owner
+	resetPath := "/" + cenvID + "/admin/users/bob/password-reset"
+	if w := doJSON(t, mux, "POST", resetPath, laptop, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an editor refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/users/nobody/password-reset", adminToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown user to be 404, got %d", w.Code)
+	}
+	w := doJSON(t, mux, "POST", resetPath, adminToken, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create reset: %d %s", w.Code, w.Body.String())
+	}
+	var reset PasswordResetResponse
+	json.NewDecoder(w.Body).Decode(&reset)
+	if reset.Username != "bob" || reset.ResetToken == "" || reset.ExpiresAt == 0 {
+		t.Fatalf("Unexpected reset: %+v", reset)
+	}
+
+	body := map[string]string{"token": reset.ResetToken, "new_password": "bobresetpass1"}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/password/reset", "", body); w.Code != http.StatusOK {
+		t.Fatalf("Failed to reset password: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/password/reset", "", body); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a used token refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", laptop, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a reset to sign the user out everywhere, got %d", w.Code)
+	}
+	loginAs(t, mux, cenvID, "bob", "bobresetpass1")
+}
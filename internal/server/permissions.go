@@ -3,9 +3,9 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/thetanil/wce/internal/auth"
 	"github.com/thetanil/wce/internal/authz"
@@ -31,7 +31,7 @@ type RevokePermissionRequest struct {
 // CreatePolicyRequest represents request to create row policy
 type CreatePolicyRequest struct {
 	TableName    string `json:"table_name"`
-	UserID       string `json:"user_id"` // empty = applies to all
+	UserID       string `json:"user_id"`     // empty = applies to all
 	PolicyType   string `json:"policy_type"` // "read", "write", "delete"
 	SQLCondition string `json:"sql_condition"`
 }
@@ -58,58 +58,48 @@ func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request, cenvID stri
 		return "", "", nil, fmt.Errorf("database connection failed: %w", err)
 	}
 
-	// Extract token
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "missing authorization header",
-		})
-		return "", "", nil, fmt.Errorf("missing authorization")
+	identity, err := s.identify(r, cenvID)
+	var scopeErr *scopeError
+	if errors.As(err, &scopeErr) {
+		writeScopeError(w, scopeErr)
+		return "", "", nil, err
 	}
-
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		w.WriteHeader(http.StatusUnauthorized)
+	if err != nil {
+		// Session lookups fail on database errors, which are not the caller's
+		message := err.Error()
+		if errors.Is(err, errSessionCheck) {
+			message = errSessionCheck.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "invalid authorization header format",
+			"error": message,
 		})
-		return "", "", nil, fmt.Errorf("invalid authorization format")
+		return "", "", nil, err
 	}
 
-	token := parts[1]
+	return identity.UserID, identity.Role, db, nil
+}
 
-	// Validate token
-	claims, err := s.jwtManager.ValidateToken(token)
+// requireAdmin authenticates the request and requires the owner or admin role.
+// action completes the message "only owner or admin can ..." on rejection.
+// Returns (userID, db, error)
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request, cenvID, action string) (string, *sql.DB, error) {
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "invalid token",
-		})
-		return "", "", nil, fmt.Errorf("invalid token: %w", err)
-	}
-
-	// Verify token is for this cenv
-	if claims.CenvID != cenvID {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "token not valid for this cenv",
-		})
-		return "", "", nil, fmt.Errorf("token cenv mismatch")
+		return "", nil, err
 	}
 
-	// Check session validity
-	tokenHash := auth.GetTokenHash(token)
-	valid, err := auth.IsSessionValid(db, tokenHash)
-	if err != nil || !valid {
-		w.WriteHeader(http.StatusUnauthorized)
+	if role != authz.RoleOwner && role != authz.RoleAdmin {
+		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "session expired or revoked",
+			"error": "only owner or admin can " + action,
 		})
-		return "", "", nil, fmt.Errorf("invalid session")
+		return "", nil, fmt.Errorf("insufficient role: %s", role)
 	}
 
-	return claims.UserID, claims.Role, db, nil
+	return userID, db, nil
 }
 
 // handleListPermissions lists permissions (admin/owner only)
@@ -177,7 +167,7 @@ func (s *Server) handleGrantPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, role, db, err := s.requireAuth(w, r, cenvID)
+	adminID, role, db, err := s.requireAuth(w, r, cenvID)
 	if err != nil {
 		return // Response already sent
 	}
@@ -191,6 +181,10 @@ func (s *Server) handleGrantPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := s.verifyAdminRequest(w, r, db, adminID, "grant_permission", "permission", ""); err != nil {
+		return // Response already sent
+	}
+
 	// Parse request
 	var req GrantPermissionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -211,6 +205,7 @@ func (s *Server) handleGrantPermission(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Grant permission
+	prev, _ := authz.GetTablePermission(db, req.UserID, req.TableName)
 	err = authz.GrantPermission(db, req.UserID, req.TableName, req.CanRead, req.CanWrite, req.CanDelete, req.CanGrant)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -219,6 +214,9 @@ func (s *Server) handleGrantPermission(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if req.CanGrant {
+		s.recordGrantOption(db, cenvID, adminID, req.UserID, req.TableName, prev)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -237,7 +235,7 @@ func (s *Server) handleRevokePermission(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, role, db, err := s.requireAuth(w, r, cenvID)
+	adminID, role, db, err := s.requireAuth(w, r, cenvID)
 	if err != nil {
 		return // Response already sent
 	}
@@ -251,6 +249,10 @@ func (s *Server) handleRevokePermission(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := s.verifyAdminRequest(w, r, db, adminID, "revoke_permission", "permission", ""); err != nil {
+		return // Response already sent
+	}
+
 	// Parse request
 	var req RevokePermissionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -364,6 +366,10 @@ func (s *Server) handleCreatePolicy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := s.verifyAdminRequest(w, r, db, creatorID, "create_policy", "policy", ""); err != nil {
+		return // Response already sent
+	}
+
 	// Parse request
 	var req CreatePolicyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -408,3 +414,119 @@ func (s *Server) handleCreatePolicy(w http.ResponseWriter, r *http.Request) {
 		"message": "policy created successfully",
 	})
 }
+
+// permissionResource converts a stored permission to its read-back form
+func permissionResource(perm *authz.Permission) GrantPermissionRequest {
+	return GrantPermissionRequest{
+		UserID:    perm.UserID,
+		TableName: perm.TableName,
+		CanRead:   perm.CanRead,
+		CanWrite:  perm.CanWrite,
+		CanDelete: perm.CanDelete,
+		CanGrant:  perm.CanGrant,
+	}
+}
+
+// handleGetPermission returns one user's permission on one table (admin/owner only).
+// Responds 404 when no grant exists so declarative clients can detect removal.
+func (s *Server) handleGetPermission(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, _, db, err := s.requireUserManager(w, r, "view permissions")
+	if err != nil {
+		return // Response already sent
+	}
+
+	perm, err := authz.GetTablePermission(db, r.PathValue("userID"), r.PathValue("table"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	if perm == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission not found",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(permissionResource(perm))
+}
+
+// handlePutPermission sets one user's permission on one table to exactly the
+// flags in the body (admin/owner only). Omitted flags are revoked.
+func (s *Server) handlePutPermission(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID, _, db, err := s.requireUserManager(w, r, "grant permissions")
+	if err != nil {
+		return // Response already sent
+	}
+
+	userID := r.PathValue("userID")
+	tableName := r.PathValue("table")
+
+	if err := s.verifyAdminRequest(w, r, db, adminID, "grant_permission", "permission", userID+"/"+tableName); err != nil {
+		return // Response already sent
+	}
+
+	var req GrantPermissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if _, err := auth.GetUserByID(db, userID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	prev, _ := authz.GetTablePermission(db, userID, tableName)
+	if err := authz.GrantPermission(db, userID, tableName, req.CanRead, req.CanWrite, req.CanDelete, req.CanGrant); err != nil {
+		writeTableError(w, err)
+		return
+	}
+	if req.CanGrant {
+		s.recordGrantOption(db, r.PathValue("cenvID"), adminID, userID, tableName, prev)
+	}
+
+	perm, err := authz.GetTablePermission(db, userID, tableName)
+	if err != nil || perm == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to read back permission",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(permissionResource(perm))
+}
+
+// handleDeletePermission removes one user's permission on one table (admin/owner only)
+func (s *Server) handleDeletePermission(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID, _, db, err := s.requireUserManager(w, r, "revoke permissions")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, adminID, "revoke_permission", "permission", r.PathValue("userID")+"/"+r.PathValue("table")); err != nil {
+		return // Response already sent
+	}
+
+	if err := authz.RevokePermission(db, r.PathValue("userID"), r.PathValue("table")); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "permission revoked successfully",
+	})
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/config"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// Plan actions
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// PlanRequest is the desired state of a cenv's managed resources. A section
+// that is omitted is not managed and produces no changes; a section that is
+// present (even empty) is authoritative, so existing resources missing from
+// it are planned for deletion. Config keys are never deleted.
+type PlanRequest struct {
+	Config      map[string]string        `json:"config"`
+	Users       []PlanUser               `json:"users"`
+	Permissions []GrantPermissionRequest `json:"permissions"`
+	Endpoints   []PlanEndpoint           `json:"endpoints"`
+}
+
+// PlanUser is the desired state of a user. Passwords are write-only and
+// cannot be compared, so they are not part of the plan.
+type PlanUser struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Email    string `json:"email"`
+	Enabled  *bool  `json:"enabled"` // Defaults to true
+}
+
+// PlanEndpoint is the desired state of a Starlark endpoint, keyed by method and path
+type PlanEndpoint struct {
+	Path         string    `json:"path"`
+	Method       string    `json:"method"`
+	Script       string    `json:"script"`
+	Description  string    `json:"description"`
+	Enabled      *bool     `json:"enabled"`      // Defaults to true
+	Capabilities *[]string `json:"capabilities"` // Omitted is not compared
+}
+
+// PlanChange is a single difference between the desired and current state
+type PlanChange struct {
+	Resource string   `json:"resource"` // "config", "user", "permission" or "endpoint"
+	Key      string   `json:"key"`
+	Action   string   `json:"action"`
+	Fields   []string `json:"fields,omitempty"` // Changed fields for updates
+}
+
+// PlanSummary counts the changes in a plan
+type PlanSummary struct {
+	Create    int `json:"create"`
+	Update    int `json:"update"`
+	Delete    int `json:"delete"`
+	Unchanged int `json:"unchanged"`
+}
+
+// plan accumulates changes and the summary
+type plan struct {
+	Changes []PlanChange `json:"changes"`
+	Summary PlanSummary  `json:"summary"`
+}
+
+func (p *plan) add(resource, key, action string, fields ...string) {
+	switch action {
+	case PlanCreate:
+		p.Summary.Create++
+	case PlanUpdate:
+		if len(fields) == 0 {
+			p.Summary.Unchanged++
+			return
+		}
+		p.Summary.Update++
+	case PlanDelete:
+		p.Summary.Delete++
+	}
+	p.Changes = append(p.Changes, PlanChange{Resource: resource, Key: key, Action: action, Fields: fields})
+}
+
+// changed takes (name, current, desired) triples and returns the names whose
+// values differ, in the order given
+func changed(triples ...interface{}) []string {
+	var fields []string
+	for i := 0; i+2 < len(triples); i += 3 {
+		if triples[i+1] != triples[i+2] {
+			fields = append(fields, triples[i].(string))
+		}
+	}
+	return fields
+}
+
+// handleGetCenv returns the cenv's identity, owner and configuration (admin/owner only)
+func (s *Server) handleGetCenv(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, _, db, err := s.requireUserManager(w, r, "view the cenv")
+	if err != nil {
+		return // Response already sent
+	}
+
+	var owner auth.User
+	err = db.QueryRow(`
+		SELECT user_id, username, created_at FROM _wce_users WHERE role = ? ORDER BY created_at LIMIT 1
+	`, authz.RoleOwner).Scan(&owner.UserID, &owner.Username, &owner.CreatedAt)
+	if err != nil {
+		writeTableError(w, fmt.Errorf("failed to find owner: %w", err))
+		return
+	}
+
+	entries, err := config.List(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		values[entry.Key] = entry.Value
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cenv_id":    r.PathValue("cenvID"),
+		"owner":      owner.Username,
+		"owner_id":   owner.UserID,
+		"created_at": owner.CreatedAt,
+		"config":     values,
+	})
+}
+
+// handlePlan diffs a desired state against the cenv without changing anything
+// (admin/owner only). Applying the listed changes with the resource endpoints
+// brings the cenv to the desired state.
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, _, db, err := s.requireUserManager(w, r, "plan changes")
+	if err != nil {
+		return // Response already sent
+	}
+
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	result, err := buildPlan(db, &req)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// buildPlan computes the changes needed to reach the desired state
+func buildPlan(db *sql.DB, req *PlanRequest) (*plan, error) {
+	p := &plan{Changes: []PlanChange{}}
+
+	steps := []func(*sql.DB, *PlanRequest, *plan) error{planConfig, planUsers, planPermissions, planEndpoints}
+	for _, step := range steps {
+		if err := step(db, req, p); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func planConfig(db *sql.DB, req *PlanRequest, p *plan) error {
+	if req.Config == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(req.Config))
+	for key := range req.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := req.Config[key]
+		if validate, ok := configValidators[key]; ok {
+			if err := validate(value); err != nil {
+				return fmt.Errorf("config %s: %v", key, err)
+			}
+		}
+
+		current, found, err := config.Get(db, key)
+		if err != nil {
+			return err
+		}
+		if !found {
+			p.add("config", key, PlanCreate)
+			continue
+		}
+		p.add("config", key, PlanUpdate, changed("value", current, value)...)
+	}
+
+	return nil
+}
+
+func planUsers(db *sql.DB, req *PlanRequest, p *plan) error {
+	if req.Users == nil {
+		return nil
+	}
+
+	users, err := auth.ListUsers(db)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*auth.User, len(users))
+	for i := range users {
+		current[users[i].Username] = &users[i]
+	}
+
+	desired := make(map[string]bool, len(req.Users))
+	for _, u := range req.Users {
+		if !auth.IsValidRole(u.Role) {
+			return fmt.Errorf("user %s: invalid role %q", u.Username, u.Role)
+		}
+		if desired[u.Username] {
+			return fmt.Errorf("user %s is listed more than once", u.Username)
+		}
+		desired[u.Username] = true
+
+		enabled := u.Enabled == nil || *u.Enabled
+		existing, ok := current[u.Username]
+		if !ok {
+			if !isValidUsername(u.Username) {
+				return fmt.Errorf("user %s: invalid username", u.Username)
+			}
+			p.add("user", u.Username, PlanCreate)
+			continue
+		}
+		p.add("user", u.Username, PlanUpdate, changed(
+			"role", existing.Role, u.Role,
+			"email", existing.Email, u.Email,
+			"enabled", existing.Enabled, enabled,
+		)...)
+	}
+
+	// The owner is never planned for deletion
+	for _, user := range users {
+		if !desired[user.Username] && user.Role != authz.RoleOwner {
+			p.add("user", user.Username, PlanDelete)
+		}
+	}
+
+	return nil
+}
+
+func planPermissions(db *sql.DB, req *PlanRequest, p *plan) error {
+	if req.Permissions == nil {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT user_id, table_name, can_read, can_write, can_delete, can_grant
+		FROM _wce_table_permissions
+		ORDER BY user_id, table_name
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to list permissions: %w", err)
+	}
+	defer rows.Close()
+
+	current := map[string]GrantPermissionRequest{}
+	var order []string
+	for rows.Next() {
+		var perm GrantPermissionRequest
+		if err := rows.Scan(&perm.UserID, &perm.TableName, &perm.CanRead, &perm.CanWrite, &perm.CanDelete, &perm.CanGrant); err != nil {
+			return fmt.Errorf("failed to scan permission: %w", err)
+		}
+		key := perm.UserID + "/" + perm.TableName
+		current[key] = perm
+		order = append(order, key)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating permissions: %w", err)
+	}
+
+	desired := make(map[string]bool, len(req.Permissions))
+	for _, perm := range req.Permissions {
+		if perm.UserID == "" || perm.TableName == "" {
+			return fmt.Errorf("permissions require user_id and table_name")
+		}
+		key := perm.UserID + "/" + perm.TableName
+		if desired[key] {
+			return fmt.Errorf("permission %s is listed more than once", key)
+		}
+		desired[key] = true
+
+		existing, ok := current[key]
+		if !ok {
+			p.add("permission", key, PlanCreate)
+			continue
+		}
+		p.add("permission", key, PlanUpdate, changed(
+			"can_read", existing.CanRead, perm.CanRead,
+			"can_write", existing.CanWrite, perm.CanWrite,
+			"can_delete", existing.CanDelete, perm.CanDelete,
+			"can_grant", existing.CanGrant, perm.CanGrant,
+		)...)
+	}
+
+	for _, key := range order {
+		if !desired[key] {
+			p.add("permission", key, PlanDelete)
+		}
+	}
+
+	return nil
+}
+
+func planEndpoints(db *sql.DB, req *PlanRequest, p *plan) error {
+	if req.Endpoints == nil {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT path, method, script, description, enabled, capabilities
+		FROM _wce_endpoints
+		ORDER BY path, method
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to list endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	current := map[string]Endpoint{}
+	var order []string
+	for rows.Next() {
+		var ep Endpoint
+		var capabilities string
+		if err := rows.Scan(&ep.Path, &ep.Method, &ep.Script, &ep.Description, &ep.Enabled, &capabilities); err != nil {
+			return fmt.Errorf("failed to scan endpoint: %w", err)
+		}
+		ep.Capabilities = decodeCapabilities(capabilities)
+		key := ep.Method + " " + ep.Path
+		current[key] = ep
+		order = append(order, key)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating endpoints: %w", err)
+	}
+
+	desired := make(map[string]bool, len(req.Endpoints))
+	for _, ep := range req.Endpoints {
+		if ep.Path == "" || ep.Method == "" || ep.Script == "" {
+			return fmt.Errorf("endpoints require path, method, and script")
+		}
+		key := ep.Method + " " + ep.Path
+		if desired[key] {
+			return fmt.Errorf("endpoint %s is listed more than once", key)
+		}
+		desired[key] = true
+
+		enabled := ep.Enabled == nil || *ep.Enabled
+		existing, ok := current[key]
+		if !ok {
+			p.add("endpoint", key, PlanCreate)
+			continue
+		}
+
+		fields := changed(
+			"script", existing.Script, ep.Script,
+			"description", existing.Description, ep.Description,
+			"enabled", existing.Enabled, enabled,
+		)
+		if ep.Capabilities != nil {
+			caps, err := starlark_pkg.ParseCapabilities(*ep.Capabilities)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: %v", key, err)
+			}
+			if fmt.Sprint(caps.Names()) != fmt.Sprint(existing.Capabilities) {
+				fields = append(fields, "capabilities")
+			}
+		}
+		p.add("endpoint", key, PlanUpdate, fields...)
+	}
+
+	for _, key := range order {
+		if !desired[key] {
+			p.add("endpoint", key, PlanDelete)
+		}
+	}
+
+	return nil
+}
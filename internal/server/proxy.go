@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/proxy"
+	"github.com/thetanil/wce/internal/secrets"
+)
+
+// SecretRequest sets a secret's value
+type SecretRequest struct {
+	Value string `json:"value"`
+}
+
+// handleProxy forwards a request to a configured upstream route.
+// Any authenticated user of the cenv may use an enabled route.
+// Route: /{cenvID}/ext/{name}/{path...}
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	name := r.PathValue("name")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	route, err := proxy.GetRoute(db, name)
+	if err != nil || !route.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "proxy route not found: " + name,
+		})
+		return
+	}
+
+	resp, err := proxy.Forward(r.Context(), s.proxyClient, db, s.secrets, route, r.Method, r.PathValue("path"), r.URL.RawQuery, r.Header, r.Body)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, proxy.ErrTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		log.Printf("Proxy route %s failed: %v", name, err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Del("Content-Type")
+	for key, value := range resp.Header {
+		w.Header().Set(key, value)
+	}
+	if resp.Cached {
+		w.Header().Set("X-Proxy-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Proxy-Cache", "MISS")
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// handleListProxyRoutes lists upstream proxy routes (admin/owner only)
+func (s *Server) handleListProxyRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view proxy routes")
+	if err != nil {
+		return // Response already sent
+	}
+
+	routes, err := proxy.ListRoutes(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"routes": routes,
+		"count":  len(routes),
+	})
+}
+
+// handlePutProxyRoute creates or replaces an upstream proxy route (admin/owner only)
+func (s *Server) handlePutProxyRoute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage proxy routes")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "put_proxy_route", "proxy_route", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	// Routes are enabled unless the request says otherwise
+	route := proxy.Route{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+	route.Name = r.PathValue("name")
+
+	if err := proxy.PutRoute(db, &route, userID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Proxy route %s -> %s saved by %s", route.Name, route.UpstreamURL, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(route)
+}
+
+// handleDeleteProxyRoute removes an upstream proxy route (admin/owner only)
+func (s *Server) handleDeleteProxyRoute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage proxy routes")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "delete_proxy_route", "proxy_route", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	name := r.PathValue("name")
+	if err := proxy.DeleteRoute(db, name); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Proxy route %s deleted by %s", name, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "proxy route deleted successfully",
+	})
+}
+
+// handleListSecrets lists secret names; values are never returned (admin/owner only)
+func (s *Server) handleListSecrets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view secrets")
+	if err != nil {
+		return // Response already sent
+	}
+
+	infos, err := secrets.List(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secrets": infos,
+		"count":   len(infos),
+	})
+}
+
+// handleSetSecret creates or replaces a secret (admin/owner only)
+func (s *Server) handleSetSecret(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage secrets")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "set_secret", "secret", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	var req SecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	vault, ok := s.secretManager(w)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := vault.Set(db, name, req.Value, userID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Secret %s set by %s", name, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "secret set successfully",
+	})
+}
+
+// handleDeleteSecret removes a secret (admin/owner only)
+func (s *Server) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage secrets")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "delete_secret", "secret", r.PathValue("name")); err != nil {
+		return // Response already sent
+	}
+
+	name := r.PathValue("name")
+	if err := secrets.Delete(db, name); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Secret %s deleted by %s", name, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "secret deleted successfully",
+	})
+}
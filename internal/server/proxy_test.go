@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/secrets"
+)
+
+func TestProxyRoutes(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/admin/secrets", srv.handleListSecrets)
+	mux.HandleFunc("PUT /{cenvID}/admin/secrets/{name}", srv.handleSetSecret)
+	mux.HandleFunc("DELETE /{cenvID}/admin/secrets/{name}", srv.handleDeleteSecret)
+	mux.HandleFunc("GET /{cenvID}/admin/secrets/key", srv.handleSecretKeyStatus)
+	mux.HandleFunc("POST /{cenvID}/admin/secrets/key/rotate", srv.handleRotateSecretKey)
+	mux.HandleFunc("GET /{cenvID}/admin/proxy-routes", srv.handleListProxyRoutes)
+	mux.HandleFunc("PUT /{cenvID}/admin/proxy-routes/{name}", srv.handlePutProxyRoute)
+	mux.HandleFunc("DELETE /{cenvID}/admin/proxy-routes/{name}", srv.handleDeleteProxyRoute)
+	mux.HandleFunc("/{cenvID}/ext/{name}/{path...}", srv.handleProxy)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "viewer", "viewerpass123", "viewer", "", ""); err != nil {
+		t.Fatalf("Failed to create viewer: %v", err)
+	}
+	viewerToken := loginAs(t, mux, cenvID, "viewer", "viewerpass123")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer upstream-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Method + " " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/secrets/partner", viewerToken, map[string]string{"value": "x"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected viewer to be refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/secrets/partner", token, map[string]string{"value": "upstream-token"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set secret: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/secrets", token, nil); strings.Contains(w.Body.String(), "upstream-token") {
+		t.Error("Secret listing must not expose values")
+	}
+
+	w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/proxy-routes/partner", token, map[string]interface{}{
+		"upstream_url": upstream.URL + "/api",
+		"auth_header":  "Authorization",
+		"auth_prefix":  "Bearer ",
+		"secret_name":  "partner",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to create route: %d %s", w.Code, w.Body.String())
+	}
+
+	t.Run("InternalUpstreamBlocked", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/ext/partner/orders", viewerToken, nil)
+		if w.Code != http.StatusBadGateway || strings.Contains(w.Body.String(), "/api/orders") {
+			t.Errorf("Expected a loopback upstream refused, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	// The test upstream is on loopback
+	srv.SetEgressAllowlist(netip.MustParsePrefix("127.0.0.0/8"))
+
+	t.Run("Forwarded", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/ext/partner/orders/7", viewerToken, map[string]string{"a": "b"})
+		if w.Code != http.StatusOK || w.Body.String() != "POST /api/orders/7" {
+			t.Errorf("Unexpected response: %d %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("Expected upstream content type, got %s", w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("RequiresAuth", func(t *testing.T) {
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/ext/partner/orders", "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("SecretInUse", func(t *testing.T) {
+		if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/secrets/partner", token, nil); w.Code == http.StatusOK {
+			t.Error("Expected secret in use to be kept")
+		}
+	})
+
+	t.Run("EncryptedAtRest", func(t *testing.T) {
+		var stored string
+		db.QueryRow(`SELECT value FROM _wce_secrets WHERE name = 'partner'`).Scan(&stored)
+		if strings.Contains(stored, "upstream-token") {
+			t.Error("Secret value stored in plaintext")
+		}
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/secrets/key", viewerToken, nil); w.Code != http.StatusForbidden {
+			t.Errorf("Expected viewer to be refused, got %d", w.Code)
+		}
+
+		var before, after secrets.KeyStatus
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/secrets/key", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to get key status: %d %s", w.Code, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &before)
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/admin/secrets/key/rotate", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to rotate key: %d %s", w.Code, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &after)
+		if after.DataKeyID == before.DataKeyID || after.Secrets != 1 {
+			t.Errorf("Unexpected rotation result: %s", w.Body.String())
+		}
+
+		w = doJSON(t, mux, "GET", "/"+cenvID+"/ext/partner/orders", viewerToken, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected route to work after rotation, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		doJSON(t, mux, "PUT", "/"+cenvID+"/admin/proxy-routes/partner", token, map[string]interface{}{
+			"upstream_url": upstream.URL, "enabled": false,
+		})
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/ext/partner/orders", viewerToken, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected disabled route to 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnknownRoute", func(t *testing.T) {
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/ext/missing/x", viewerToken, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/reporting"
+)
+
+// reportTimeout bounds delivery of a panic report to each error sink
+const reportTimeout = 10 * time.Second
+
+// requestIDKey is the context key for the id of a request
+type requestIDKey struct{}
+
+// SetErrorSinks sets the error trackers recovered panics are reported to,
+// such as a reporting.SentrySink. Panics are always logged.
+func (s *Server) SetErrorSinks(sinks ...reporting.Sink) {
+	s.errorSinks = sinks
+}
+
+// panicCounter counts recovered panics by route pattern
+type panicCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *panicCounter) add(route string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[route]++
+}
+
+// snapshot returns the counts and their routes in sorted order
+func (c *panicCounter) snapshot() (map[string]int64, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	routes := make([]string, 0, len(c.counts))
+	for route, count := range c.counts {
+		counts[route] = count
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return counts, routes
+}
+
+// headerTracker records whether a response has started, after which an
+// error response can no longer be sent
+type headerTracker struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (t *headerTracker) WriteHeader(code int) {
+	t.started = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *headerTracker) Write(b []byte) (int, error) {
+	t.started = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (t *headerTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// recoveryMiddleware assigns each request an id, echoed in X-Request-Id, and
+// turns a panic anywhere below it into a logged stack trace, a count for the
+// route in the operator metrics, reports to the error sinks and a JSON 500
+// carrying the request id. routes resolves the route pattern a request
+// matched. http.ErrAbortHandler is passed on, as net/http expects.
+func (s *Server) recoveryMiddleware(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set("X-Request-Id", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		tracker := &headerTracker{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			_, route := routes.Handler(r)
+			cenvID, _, _ := cenv.ParsePath(r.URL.Path)
+			report := &reporting.Report{
+				RequestID: id,
+				Method:    r.Method,
+				URL:       r.URL.RequestURI(),
+				Route:     route,
+				CenvID:    cenvID,
+				Message:   fmt.Sprint(recovered),
+				Stack:     string(debug.Stack()),
+				Time:      time.Now(),
+			}
+			log.Printf("Panic serving %s %s (route %q, request %s): %s\n%s",
+				r.Method, r.URL.Path, route, id, report.Message, report.Stack)
+			s.panics.add(route)
+			s.reportPanic(report)
+
+			if tracker.started {
+				return // Too late for an error response; the client sees it cut short
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":      "internal server error",
+				"request_id": id,
+			})
+		}()
+
+		next.ServeHTTP(tracker, r)
+	})
+}
+
+// reportPanic sends a report to every error sink in the background, so a
+// slow tracker does not hold up the response
+func (s *Server) reportPanic(report *reporting.Report) {
+	for _, sink := range s.errorSinks {
+		go func(sink reporting.Sink) {
+			ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+			defer cancel()
+			if err := sink.Send(ctx, report); err != nil {
+				log.Printf("Failed to report panic of request %s: %v", report.RequestID, err)
+			}
+		}(sink)
+	}
+}
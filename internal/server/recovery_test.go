@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/reporting"
+)
+
+// sinkFunc adapts a function to reporting.Sink
+type sinkFunc func(*reporting.Report) error
+
+func (f sinkFunc) Send(ctx context.Context, report *reporting.Report) error {
+	return f(report)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+	srv.SetOperatorToken("operator-secret")
+
+	reports := make(chan *reporting.Report, 4)
+	srv.SetErrorSinks(sinkFunc(func(report *reporting.Report) error {
+		reports <- report
+		return nil
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{cenvID}/boom", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["x"] = 1
+	})
+	mux.HandleFunc("GET /late", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("after writing")
+	})
+	mux.HandleFunc("GET /abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestID(r)))
+	})
+	mux.HandleFunc("GET /operator/metrics", srv.handleOperatorMetrics)
+	handler := srv.recoveryMiddleware(mux, mux)
+
+	cenvID := "123e4567-e89b-12d3-a456-426614174000"
+	req := httptest.NewRequest("GET", "/"+cenvID+"/boom", nil)
+	req.Header.Set("X-Request-Id", "client-req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var body map[string]string
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusInternalServerError || body["error"] != "internal server error" || body["request_id"] != "client-req-1" {
+		t.Errorf("Expected the error envelope, got %d %v", w.Code, body)
+	}
+	if w.Header().Get("X-Request-Id") != "client-req-1" {
+		t.Errorf("Expected the request id echoed, got %q", w.Header().Get("X-Request-Id"))
+	}
+
+	select {
+	case report := <-reports:
+		if report.Route != "GET /{cenvID}/boom" || report.CenvID != cenvID || report.RequestID != "client-req-1" ||
+			!strings.Contains(report.Message, "nil map") || !strings.Contains(report.Stack, "recovery_test.go") {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the panic to be reported")
+	}
+
+	// A response already under way is cut short rather than replaced
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/late", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("Expected the partial response untouched, got %d %q", w.Code, w.Body.String())
+	}
+	<-reports
+
+	// Handlers downstream see the same request id
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/ok", nil))
+	if id := w.Header().Get("X-Request-Id"); id == "" || w.Body.String() != id {
+		t.Errorf("Expected one request id, got header %q and handler %q", id, w.Body.String())
+	}
+
+	func() {
+		defer func() {
+			if recover() != http.ErrAbortHandler {
+				t.Error("Expected http.ErrAbortHandler to be passed on")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	}()
+
+	req = httptest.NewRequest("GET", "/operator/metrics", nil)
+	req.Header.Set("Authorization", "Bearer operator-secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	metrics := w.Body.String()
+	if !strings.Contains(metrics, `wce_http_panics_total{route="GET /{cenvID}/boom"} 1`) ||
+		!strings.Contains(metrics, `wce_http_panics_total{route="GET /late"} 1`) {
+		t.Errorf("Expected panics counted by route:\n%s", metrics)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/thetanil/wce/internal/document"
+)
+
+// scriptReference matches template.render("id") calls naming a document
+// literally
+var scriptReference = regexp.MustCompile(`template\.render\(\s*(?:id\s*=\s*)?(?:"([^"\n]+)"|'([^'\n]+)')`)
+
+// referrer is a template document or endpoint script that would break if a
+// document were deleted
+type referrer struct {
+	Type       string `json:"type"` // "document" or "endpoint"
+	Kind       string `json:"kind"` // "include", "extends" or "render"
+	DocumentID string `json:"document_id,omitempty"`
+	EndpointID int64  `json:"endpoint_id,omitempty"`
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+}
+
+// setEndpointReferences replaces the documents an endpoint is recorded as
+// rendering with those its script names
+func setEndpointReferences(db document.DB, endpointID int64, script string) error {
+	if _, err := db.Exec(`DELETE FROM _wce_endpoint_references WHERE endpoint_id = ?`, endpointID); err != nil {
+		return fmt.Errorf("failed to clear endpoint references: %w", err)
+	}
+
+	for _, match := range scriptReference.FindAllStringSubmatch(script, -1) {
+		target := strings.TrimSpace(match[1] + match[2])
+		if target == "" {
+			continue
+		}
+		if _, err := db.Exec(`
+			INSERT OR IGNORE INTO _wce_endpoint_references (endpoint_id, target_id) VALUES (?, ?)
+		`, endpointID, target); err != nil {
+			return fmt.Errorf("failed to store endpoint reference: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// documentReferrers lists the template documents and endpoints that
+// reference a document
+func documentReferrers(db *sql.DB, id string) ([]referrer, error) {
+	refs, err := document.GetReferrers(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers := []referrer{}
+	for _, ref := range refs {
+		referrers = append(referrers, referrer{Type: "document", Kind: ref.Kind, DocumentID: ref.SourceID})
+	}
+
+	rows, err := db.Query(`
+		SELECT e.id, e.method, e.path
+		FROM _wce_endpoint_references r
+		JOIN _wce_endpoints e ON e.id = r.endpoint_id
+		WHERE r.target_id = ?
+		ORDER BY e.path, e.method
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query endpoint references: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ref := referrer{Type: "endpoint", Kind: "render"}
+		if err := rows.Scan(&ref.EndpointID, &ref.Method, &ref.Path); err != nil {
+			return nil, fmt.Errorf("failed to scan endpoint reference: %w", err)
+		}
+		referrers = append(referrers, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating endpoint references: %w", err)
+	}
+
+	return referrers, nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/config"
+)
+
+// RefreshRequest exchanges a refresh token for a new token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// handleRefreshToken exchanges a refresh token issued at login for a new
+// token and refresh token, keeping the session. Both old tokens stop working,
+// so a stolen refresh token is only good until its owner next refreshes. The
+// new token carries the user's current role; disabled users are refused and
+// their session revoked.
+// Route: POST /{cenvID}/auth/refresh
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) || !s.cenvManager.Exists(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cenv not found"})
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "refresh_token is required",
+		})
+		return
+	}
+
+	db, err := s.cenvManager.GetConnection(cenvID)
+	if err != nil {
+		log.Printf("Failed to connect to cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to connect to database",
+		})
+		return
+	}
+
+	invalid := func() {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": auth.ErrInvalidRefreshToken.Error(),
+		})
+	}
+	failed := func(err error) {
+		log.Printf("Failed to refresh session in cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to refresh session",
+		})
+	}
+
+	session, err := auth.GetSessionByRefreshToken(db, req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		invalid()
+		return
+	}
+	if err != nil {
+		failed(err)
+		return
+	}
+
+	user, err := auth.GetUserByID(db, session.UserID)
+	if err != nil || !user.Enabled {
+		if err := auth.RevokeSession(db, session.TokenHash); err != nil {
+			log.Printf("Failed to revoke session of disabled user %s: %v", session.UserID, err)
+		}
+		invalid()
+		return
+	}
+
+	// A bound session can only be refreshed from its own client
+	if config.GetBool(db, "bind_sessions_to_client", false) && session.ClientFingerprint != "" &&
+		session.ClientFingerprint != auth.ClientFingerprint(r.RemoteAddr, r.UserAgent()) {
+		revokeMismatchedSession(r, db, session.TokenHash, user.UserID, user.Username, session.SessionID)
+		invalid()
+		return
+	}
+
+	sessionID, err := auth.GenerateSessionID()
+	if err != nil {
+		failed(err)
+		return
+	}
+	expiresIn := auth.DefaultSessionTimeout
+	token, err := s.jwtManager.GenerateToken(user.UserID, user.Username, cenvID, user.Role, sessionID, expiresIn)
+	if err != nil {
+		failed(err)
+		return
+	}
+
+	refreshToken, err := auth.RotateSession(db, req.RefreshToken, auth.GetTokenHash(token), expiresIn, auth.DefaultRefreshTimeout)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		invalid()
+		return
+	}
+	if err != nil {
+		failed(err)
+		return
+	}
+
+	now := clock.Now()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LoginResponse{
+		Token:            token,
+		ExpiresAt:        now.Add(expiresIn).Unix(),
+		UserID:           user.UserID,
+		Username:         user.Username,
+		Role:             user.Role,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: now.Add(auth.DefaultRefreshTimeout).Unix(),
+	})
+}
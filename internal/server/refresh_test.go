@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestRefreshToken(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/auth/refresh", srv.handleRefreshToken)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+
+	cenvID, adminToken := setupTestCenv(t, mux)
+	db, _ := manager.GetConnection(cenvID)
+	user, err := auth.CreateUser(db, "manager", "managerpass123", auth.RoleAdmin, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{"username": "manager", "password": "managerpass123"})
+	var login LoginResponse
+	json.NewDecoder(w.Body).Decode(&login)
+	if login.RefreshToken == "" || login.RefreshExpiresAt <= login.ExpiresAt {
+		t.Fatalf("Expected a refresh token outliving the token, got %+v", login)
+	}
+
+	refresh := func(refreshToken string) (int, LoginResponse) {
+		t.Helper()
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/auth/refresh", "", map[string]string{"refresh_token": refreshToken})
+		var resp LoginResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	listDocuments := func(token string) int {
+		t.Helper()
+		return doJSON(t, mux, "GET", "/"+cenvID+"/documents", token, nil).Code
+	}
+
+	if code, _ := refresh(""); code != http.StatusBadRequest {
+		t.Errorf("Expected a missing refresh token to be rejected, got %d", code)
+	}
+	if code, _ := refresh("not-a-refresh-token"); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown refresh token to be rejected, got %d", code)
+	}
+
+	// The token is rotated: the new pair works and the old pair does not
+	code, renewed := refresh(login.RefreshToken)
+	if code != http.StatusOK || renewed.Token == "" || renewed.Token == login.Token || renewed.RefreshToken == login.RefreshToken {
+		t.Fatalf("Unexpected refresh: %d %+v", code, renewed)
+	}
+	if renewed.UserID != user.UserID || renewed.Role != auth.RoleAdmin {
+		t.Errorf("Unexpected identity in refresh: %+v", renewed)
+	}
+	if code := listDocuments(renewed.Token); code != http.StatusOK {
+		t.Errorf("Expected the new token to work, got %d", code)
+	}
+	if code := listDocuments(login.Token); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old token to be replaced, got %d", code)
+	}
+	if code, _ := refresh(login.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old refresh token to be spent, got %d", code)
+	}
+
+	// A bound session refreshes only from its own client
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/bind_sessions_to_client", adminToken,
+		map[string]string{"value": "true"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set config: %d %s", w.Code, w.Body.String())
+	}
+	body, _ := json.Marshal(map[string]string{"refresh_token": renewed.RefreshToken})
+	req := httptest.NewRequest("POST", "/"+cenvID+"/auth/refresh", bytes.NewReader(body))
+	req.RemoteAddr = "203.0.113.9:4000"
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a refresh from another client to be rejected, got %d", rec.Code)
+	}
+	if code, _ := refresh(renewed.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Expected the mismatched session to be revoked, got %d", code)
+	}
+
+	// Disabled users cannot refresh
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{"username": "manager", "password": "managerpass123"})
+	json.NewDecoder(w.Body).Decode(&login)
+	if err := auth.UpdateUser(db, user.UserID, auth.RoleAdmin, "", false); err != nil {
+		t.Fatalf("Failed to disable user: %v", err)
+	}
+	if code, _ := refresh(login.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Expected a disabled user to be refused, got %d", code)
+	}
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/config"
+	starlark_pkg "github.com/thetanil/wce/internal/starlark"
+)
+
+// maxCaptureBodySize limits how much of a request body is stored in a capture
+const maxCaptureBodySize = 64 * 1024
+
+// capturedHeaders lists the request headers kept in a capture.
+// Credentials (Authorization, Cookie) are never stored.
+var capturedHeaders = []string{
+	"Accept",
+	"Accept-Language",
+	"Content-Type",
+	"User-Agent",
+	"X-Request-Id",
+}
+
+// RequestCapture is a redacted record of a failed endpoint request
+type RequestCapture struct {
+	ID         int64             `json:"id"`
+	EndpointID int64             `json:"endpoint_id"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Query      string            `json:"query,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	UserID     string            `json:"user_id,omitempty"`
+	Error      string            `json:"error"`
+	CreatedAt  int64             `json:"created_at"`
+}
+
+// bufferRequestBody reads the request body (up to the capture limit) and
+// restores it so the request can still be consumed by the handler
+func bufferRequestBody(r *http.Request) []byte {
+	if r.Body == nil {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(r.Body, maxCaptureBodySize))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	return body
+}
+
+// captureFailedRequest stores a redacted capture of a request whose script failed
+func captureFailedRequest(db *sql.DB, endpoint *Endpoint, r *http.Request, path, userID string, body []byte, execErr error) {
+	headers := make(map[string]string)
+	for _, name := range capturedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	headersJSON, _ := json.Marshal(headers)
+
+	_, err := db.Exec(`
+		INSERT INTO _wce_request_captures (endpoint_id, method, path, query, headers, body, user_id, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, endpoint.ID, r.Method, path, r.URL.RawQuery, string(headersJSON), string(body),
+		nullString(userID), execErr.Error(), time.Now().Unix())
+
+	if err != nil {
+		log.Printf("Failed to capture request for endpoint %d: %v", endpoint.ID, err)
+	}
+}
+
+// captureEnabled reports whether failed requests should be captured for replay
+func captureEnabled(db *sql.DB) bool {
+	return config.GetBool(db, "capture_failed_requests", false)
+}
+
+// nullString converts an empty string to NULL for SQLite
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// getRequestCapture loads a capture by ID
+func getRequestCapture(db *sql.DB, captureID string) (*RequestCapture, error) {
+	var c RequestCapture
+	var query, headers, body, userID sql.NullString
+
+	err := db.QueryRow(`
+		SELECT id, endpoint_id, method, path, query, headers, body, user_id, error, created_at
+		FROM _wce_request_captures
+		WHERE id = ?
+	`, captureID).Scan(&c.ID, &c.EndpointID, &c.Method, &c.Path, &query, &headers, &body, &userID, &c.Error, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Query = query.String
+	c.Body = body.String
+	c.UserID = userID.String
+	if headers.Valid {
+		json.Unmarshal([]byte(headers.String), &c.Headers)
+	}
+
+	return &c, nil
+}
+
+// handleListCaptures lists captured failed requests (admin/owner only)
+// Route: GET /{cenvID}/admin/captures?endpoint_id=
+func (s *Server) handleListCaptures(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+
+	if !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, role, err := s.authenticateAndAuthorize(r, cenvID, []string{"admin", "owner"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if role == "" {
+		http.Error(w, "Only admin or owner can view request captures", http.StatusForbidden)
+		return
+	}
+
+	query := `
+		SELECT id, endpoint_id, method, path, query, user_id, error, created_at
+		FROM _wce_request_captures
+	`
+	args := []interface{}{}
+	if endpointID := r.URL.Query().Get("endpoint_id"); endpointID != "" {
+		query += " WHERE endpoint_id = ?"
+		args = append(args, endpointID)
+	}
+	query += " ORDER BY id DESC LIMIT 100"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	captures := []RequestCapture{}
+	for rows.Next() {
+		var c RequestCapture
+		var rawQuery, userID sql.NullString
+		if err := rows.Scan(&c.ID, &c.EndpointID, &c.Method, &c.Path, &rawQuery, &userID, &c.Error, &c.CreatedAt); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		c.Query = rawQuery.String
+		c.UserID = userID.String
+		// Bodies and headers are only returned by the detail endpoint
+		captures = append(captures, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(captures)
+}
+
+// handleGetCapture returns a single captured request (admin/owner only)
+// Route: GET /{cenvID}/admin/captures/{captureID}
+func (s *Server) handleGetCapture(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+
+	if !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, role, err := s.authenticateAndAuthorize(r, cenvID, []string{"admin", "owner"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if role == "" {
+		http.Error(w, "Only admin or owner can view request captures", http.StatusForbidden)
+		return
+	}
+
+	capture, err := getRequestCapture(db, r.PathValue("captureID"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capture)
+}
+
+// handleDeleteCapture deletes a captured request (admin/owner only)
+// Route: DELETE /{cenvID}/admin/captures/{captureID}
+func (s *Server) handleDeleteCapture(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+
+	if !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, role, err := s.authenticateAndAuthorize(r, cenvID, []string{"admin", "owner"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if role == "" {
+		http.Error(w, "Only admin or owner can delete request captures", http.StatusForbidden)
+		return
+	}
+
+	identity, _ := s.identify(r, cenvID)
+	if err := s.verifyAdminRequest(w, r, db, identity.UserID, "delete_capture", "capture", r.PathValue("captureID")); err != nil {
+		return // Response already sent
+	}
+
+	result, err := db.Exec(`DELETE FROM _wce_request_captures WHERE id = ?`, r.PathValue("captureID"))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Capture deleted successfully",
+	})
+}
+
+// handleReplayCapture re-executes a captured request against the endpoint's
+// current script, or against a draft script supplied in the request body
+// Route: POST /{cenvID}/admin/captures/{captureID}/replay
+func (s *Server) handleReplayCapture(w http.ResponseWriter, r *http.Request) {
+	cenvID := r.PathValue("cenvID")
+
+	if !s.cenvManager.Exists(cenvID) {
+		http.Error(w, "Cenv not found", http.StatusNotFound)
+		return
+	}
+
+	db, role, err := s.authenticateAndAuthorize(r, cenvID, []string{"admin", "owner"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if role == "" {
+		http.Error(w, "Only admin or owner can replay requests", http.StatusForbidden)
+		return
+	}
+
+	identity, _ := s.identify(r, cenvID)
+	if err := s.verifyAdminRequest(w, r, db, identity.UserID, "replay_capture", "capture", r.PathValue("captureID")); err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Script string `json:"script"` // Optional draft script
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	capture, err := getRequestCapture(db, r.PathValue("captureID"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Replays run with the endpoint's capabilities, whether or not a draft is supplied
+	var endpoint Endpoint
+	var capabilities string
+	err = db.QueryRow(`SELECT script, capabilities FROM _wce_endpoints WHERE id = ?`, capture.EndpointID).
+		Scan(&endpoint.Script, &capabilities)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Endpoint no longer exists", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	endpoint.Capabilities = decodeCapabilities(capabilities)
+
+	script := endpoint.Script
+	if req.Script != "" {
+		script = req.Script
+	}
+
+	// Rebuild the original request
+	target := "/" + cenvID + "/star" + capture.Path
+	if capture.Query != "" {
+		target += "?" + capture.Query
+	}
+	replayReq, err := http.NewRequestWithContext(r.Context(), capture.Method, target, strings.NewReader(capture.Body))
+	if err != nil {
+		http.Error(w, "Failed to rebuild request", http.StatusInternalServerError)
+		return
+	}
+	for name, value := range capture.Headers {
+		replayReq.Header.Set(name, value)
+	}
+
+	execCtx := &starlark_pkg.ExecutionContext{
+		DB:           db,
+		UserID:       capture.UserID,
+		Request:      replayReq,
+		Timeout:      5 * time.Second,
+		Capabilities: endpoint.capabilitySet(),
+		Remote:       s.newRemoteAccess(cenvID, db),
+		HTTPClient:   s.proxyClient,
+		Mailer:       s.mailer,
+	}
+
+	logs := []starlark_pkg.LogEntry{}
+	execCtx.Log = func(entry starlark_pkg.LogEntry) error {
+		logs = append(logs, entry)
+		return nil
+	}
+
+	resp := map[string]interface{}{
+		"capture_id": capture.ID,
+		"draft":      req.Script != "",
+	}
+
+	result, err := starlark_pkg.Execute(context.Background(), script, execCtx)
+	resp["logs"] = logs
+	if err != nil {
+		resp["error"] = err.Error()
+	} else {
+		resp["status"] = result.StatusCode
+		resp["headers"] = result.Headers
+		resp["body"] = result.Body
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+)
+
+func TestRequestCaptureAndReplay(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/captures", srv.handleListCaptures)
+	mux.HandleFunc("GET /{cenvID}/admin/captures/{captureID}", srv.handleGetCapture)
+	mux.HandleFunc("DELETE /{cenvID}/admin/captures/{captureID}", srv.handleDeleteCapture)
+	mux.HandleFunc("POST /{cenvID}/admin/captures/{captureID}/replay", srv.handleReplayCapture)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path":   "/echo",
+		"method": "POST",
+		"script": "def handle_request(req):\n    fail(\"broken\")",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	listCaptures := func() []RequestCapture {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/captures", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to list captures: %d %s", w.Code, w.Body.String())
+		}
+		var captures []RequestCapture
+		json.NewDecoder(w.Body).Decode(&captures)
+		return captures
+	}
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/star/echo", token, map[string]string{"msg": "hi"})
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", w.Code)
+		}
+		if captures := listCaptures(); len(captures) != 0 {
+			t.Errorf("Expected no captures, got %d", len(captures))
+		}
+	})
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	if err := config.Set(db, "capture_failed_requests", "true", ""); err != nil {
+		t.Fatalf("Failed to enable capture: %v", err)
+	}
+
+	var captureID int64
+
+	t.Run("CaptureOnFailure", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/star/echo?debug=1", token, map[string]string{"msg": "hi"})
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", w.Code)
+		}
+
+		captures := listCaptures()
+		if len(captures) != 1 {
+			t.Fatalf("Expected 1 capture, got %d", len(captures))
+		}
+		captureID = captures[0].ID
+
+		w = doJSON(t, mux, "GET", fmt.Sprintf("/%s/admin/captures/%d", cenvID, captureID), token, nil)
+		var capture RequestCapture
+		json.NewDecoder(w.Body).Decode(&capture)
+
+		if capture.Path != "/echo" || capture.Query != "debug=1" {
+			t.Errorf("Unexpected path/query: %s ? %s", capture.Path, capture.Query)
+		}
+		if capture.Body != `{"msg":"hi"}` {
+			t.Errorf("Unexpected body: %s", capture.Body)
+		}
+		if _, ok := capture.Headers["Authorization"]; ok {
+			t.Error("Authorization header must not be captured")
+		}
+		if capture.Headers["Content-Type"] != "application/json" {
+			t.Errorf("Expected Content-Type header to be captured, got %v", capture.Headers)
+		}
+	})
+
+	t.Run("ReplayCurrentScript", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", fmt.Sprintf("/%s/admin/captures/%d/replay", cenvID, captureID), token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["error"] == nil {
+			t.Errorf("Expected replay against current script to fail, got %v", resp)
+		}
+	})
+
+	t.Run("ReplayDraftScript", func(t *testing.T) {
+		draft := "def handle_request(req):\n    return {\"status\": 200, \"body\": req.query[\"debug\"]}"
+		w := doJSON(t, mux, "POST", fmt.Sprintf("/%s/admin/captures/%d/replay", cenvID, captureID), token, map[string]string{
+			"script": draft,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["error"] != nil {
+			t.Fatalf("Expected draft replay to succeed, got %v", resp["error"])
+		}
+		if resp["status"] != float64(200) || resp["body"] != "1" {
+			t.Errorf("Unexpected replay result: %v", resp)
+		}
+	})
+
+	t.Run("DeleteCapture", func(t *testing.T) {
+		path := fmt.Sprintf("/%s/admin/captures/%d", cenvID, captureID)
+		if w := doJSON(t, mux, "DELETE", path, token, nil); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		if w := doJSON(t, mux, "GET", path, token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after delete, got %d", w.Code)
+		}
+	})
+}
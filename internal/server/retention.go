@@ -0,0 +1,266 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/cluster"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/retention"
+)
+
+// purgeRetainedData deletes, in every cenv with a retention policy, the
+// audit entries, sessions and logs older than the policy keeps. With a
+// coordinator, cenvs whose write lease another instance holds are left to it.
+// Returns the failures, after purging every cenv it can.
+func (s *Server) purgeRetainedData() error {
+	cenvIDs, err := s.cenvManager.List()
+	if err != nil {
+		return fmt.Errorf("failed to list cenvs: %w", err)
+	}
+
+	var failures []error
+	for _, cenvID := range cenvIDs {
+		if s.coordinator != nil {
+			if _, err := s.coordinator.Claim(cenvID); err != nil {
+				if !errors.Is(err, cluster.ErrLeaseHeld) {
+					failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+				}
+				continue
+			}
+		}
+
+		db, err := s.cenvManager.GetConnection(cenvID)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+			continue
+		}
+		policy := retention.LoadPolicy(db)
+		if policy.IsZero() {
+			continue
+		}
+
+		purged, err := retention.Purge(db, policy, clock.Now())
+		if err != nil {
+			log.Printf("Retention purge of cenv %s: %v", cenvID, err)
+			failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+		}
+		for table, n := range purged {
+			log.Printf("Purged %d rows past retention from %s in cenv %s", n, table, cenvID)
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+// handleGetRetention reports the cenv's retention policy and the stored
+// reports of erased users (admin/owner only)
+// Route: GET /{cenvID}/admin/retention
+func (s *Server) handleGetRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view retention")
+	if err != nil {
+		return // Response already sent
+	}
+
+	erasures, err := retention.ListErasures(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policy":   retention.LoadPolicy(db),
+		"erasures": erasures,
+	})
+}
+
+// handlePurgeRetention applies the cenv's retention policy now, rather than
+// at the next maintenance sweep, and reports the rows deleted (admin/owner
+// only)
+// Route: POST /{cenvID}/admin/retention/purge
+func (s *Server) handlePurgeRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "purge data")
+	if err != nil {
+		return // Response already sent
+	}
+	if err := s.verifyAdminRequest(w, r, db, userID, "purge_retention", "cenv", cenvID); err != nil {
+		return // Response already sent
+	}
+
+	policy := retention.LoadPolicy(db)
+	purged, err := retention.Purge(db, policy, clock.Now())
+	if err != nil {
+		log.Printf("Retention purge of cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to purge data"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policy": policy,
+		"purged": purged,
+	})
+}
+
+// handleEraseUser erases a user's personal data on request and returns the
+// completion report, which is also kept (admin/owner only; not the owner or
+// the caller). The account remains, disabled and renamed to a pseudonym, so
+// documents and logs that must be kept still refer to it.
+// Route: POST /{cenvID}/admin/users/{username}/erase
+func (s *Server) handleEraseUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	callerID, _, db, err := s.requireUserManager(w, r, "erase users")
+	if err != nil {
+		return // Response already sent
+	}
+
+	user, err := auth.GetUserByUsername(db, r.PathValue("username"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	if user.UserID == callerID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "your own account cannot be erased; ask another admin",
+		})
+		return
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, callerID, "erase_user", "user", user.UserID); err != nil {
+		return // Response already sent
+	}
+
+	report, err := retention.EraseUser(db, user.UserID, callerID, clock.Now())
+	if errors.Is(err, retention.ErrEraseOwner) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to erase user %s in cenv %s: %v", user.UserID, r.PathValue("cenvID"), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to erase user"})
+		return
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{"erasure_id": report.ID})
+	if err := audit.Record(db, audit.Entry{
+		UserID:       callerID,
+		Action:       "user_erased",
+		ResourceType: "user",
+		ResourceID:   user.UserID,
+		Details:      details,
+		IPAddress:    r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to audit erasure of user %s: %v", user.UserID, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// userDataFileName is the archive entry of a personal data export that
+// holds the user's records from the system tables
+const userDataFileName = "wce-user.json"
+
+// handleExportMyData streams an archive of the caller's data, for data
+// portability: wce-user.json with their profile, sessions, grants, login
+// attempts, audit entries and endpoint change comments, then the documents
+// they created and the export manifest. ?format=tar returns a
+// gzip-compressed tarball instead of a ZIP.
+// Route: GET /{cenvID}/me/export
+func (s *Server) handleExportMyData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = document.ExportFormatZip
+	}
+	if !document.IsValidExportFormat(format) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "format must be 'zip' or 'tar'",
+		})
+		return
+	}
+
+	data, err := retention.ExportUser(db, userID, clock.Now())
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	if err := audit.Record(db, audit.Entry{
+		UserID:       userID,
+		Action:       "export_user_data",
+		ResourceType: "user",
+		ResourceID:   userID,
+		IPAddress:    r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to audit data export of user %s: %v", userID, err)
+	}
+
+	filename := data.Profile.Username + "-data"
+	if format == document.ExportFormatTar {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.tar.gz"`)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// The archive is streamed, so a failure part way can only cut it short
+	file := document.ExportFile{Name: userDataFileName, Content: content}
+	if _, err := document.ExportAuthoredDocuments(r.Context(), db, w, userID, format, file); err != nil {
+		log.Printf("Data export of user %s from cenv %s failed: %v", userID, cenvID, err)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/retention"
+)
+
+func TestEraseUserAndRetention(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/me/sessions", srv.handleListMySessions)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("POST /{cenvID}/admin/users/{username}/erase", srv.handleEraseUser)
+	mux.HandleFunc("GET /{cenvID}/admin/retention", srv.handleGetRetention)
+	mux.HandleFunc("POST /{cenvID}/admin/retention/purge", srv.handlePurgeRetention)
+
+	cenvID, adminToken := setupTestCenv(t, mux)
+	db, _ := manager.GetConnection(cenvID)
+	bob, err := auth.CreateUser(db, "bob", "bobpass123", "editor", "bob@example.com", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bobToken := loginAs(t, mux, cenvID, "bob", "bobpass123")
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/"+retention.SessionDaysConfigKey, adminToken, map[string]string{"value": "-1"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a negative retention refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/"+retention.AuditDaysConfigKey, adminToken, map[string]string{"value": "365"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set retention: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/retention/purge", adminToken, nil); w.Code != http.StatusOK {
+		t.Errorf("Failed to purge: %d %s", w.Code, w.Body.String())
+	}
+
+	// Only admins erase, and not the owner or themselves
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/users/admin/erase", bobToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an editor refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/users/admin/erase", adminToken, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected erasing yourself refused, got %d", w.Code)
+	}
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/users/bob/erase", adminToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to erase user: %d %s", w.Code, w.Body.String())
+	}
+	var report retention.ErasureReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if report.UserID != bob.UserID || report.Deleted["_wce_sessions"] != 1 || report.Anonymized["_wce_users"] != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", bobToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the erased user signed out, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{"username": "bob", "password": "bobpass123"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the erased user unable to log in, got %d", w.Code)
+	}
+	if _, err := auth.GetUserByUsername(db, "bob"); err == nil {
+		t.Error("Expected the username gone")
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/retention", adminToken, nil)
+	var status struct {
+		Policy   retention.Policy          `json:"policy"`
+		Erasures []retention.ErasureReport `json:"erasures"`
+	}
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.Policy.AuditDays != 365 || len(status.Erasures) != 1 || status.Erasures[0].ID != report.ID {
+		t.Errorf("Unexpected retention status: %+v", status)
+	}
+}
+
+func TestExportMyData(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/me/export", srv.handleExportMyData)
+
+	cenvID, _ := setupTestCenv(t, mux)
+	db, _ := manager.GetConnection(cenvID)
+	bob, _ := auth.CreateUser(db, "bob", "bobpass123", "editor", "bob@example.com", "")
+	document.CreateDocument(db, "notes/bob.txt", "bob's note", "text/plain", bob.UserID, false, true)
+	bobToken := loginAs(t, mux, cenvID, "bob", "bobpass123")
+
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/export", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an export without login refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/export?format=rar", bobToken, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown format refused, got %d", w.Code)
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/me/export", bobToken, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Failed to export: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Export is not a valid ZIP: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	var data retention.UserData
+	if err := json.Unmarshal(files[userDataFileName], &data); err != nil {
+		t.Fatalf("Invalid %s: %v", userDataFileName, err)
+	}
+	if data.Profile.Username != "bob" || len(data.Sessions) != 1 || len(data.LoginAttempts) != 1 {
+		t.Errorf("Unexpected user data: %+v", data)
+	}
+	if string(files["notes/bob.txt"]) != "bob's note" {
+		t.Errorf("Expected the authored document exported, got %q", files["notes/bob.txt"])
+	}
+	if _, ok := files[document.ExportManifestName]; !ok {
+		t.Error("Expected the manifest in the export")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package server
+
+import "fmt"
+
+// setReusePort is unsupported here; use systemd socket activation instead
+func setReusePort(fd uintptr) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// maxRowHistoryEntries bounds how many history entries are returned per request
+const maxRowHistoryEntries = 100
+
+// handleSetTableHistory enables or disables row history on a user table
+func (s *Server) handleSetTableHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireTableManager(w, r, cenvID, table)
+	if err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if req.Enabled {
+		err = tables.EnableHistory(db, table)
+	} else {
+		err = tables.DisableHistory(db, table)
+	}
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Row history on %s set to %t by %s", table, req.Enabled, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":   table,
+		"history": req.Enabled,
+	})
+}
+
+// handleRowHistory lists the recorded changes to a row, newest first.
+// Past images cannot be checked against row policies, so users subject to
+// read policies on the table cannot view its history.
+func (s *Server) handleRowHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+	rowID := r.PathValue("rowID")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, table)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to check permissions",
+		})
+		return
+	}
+	if !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "insufficient permissions to read table " + table,
+		})
+		return
+	}
+
+	if role != authz.RoleOwner && role != authz.RoleAdmin {
+		policies, err := authz.GetRowPolicies(db, userID, table, "read")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "failed to check row policies",
+			})
+			return
+		}
+		if len(policies) > 0 {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "row history is unavailable for tables with row policies",
+			})
+			return
+		}
+	}
+
+	limit := maxRowHistoryEntries
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	entries, err := tables.RowHistory(db, table, rowID, limit)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"history": entries,
+		"count":   len(entries),
+	})
+}
+
+// handleRestoreRow undoes a recorded change, returning the row to its prior state
+func (s *Server) handleRestoreRow(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+	rowID := r.PathValue("rowID")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireTableManager(w, r, cenvID, table)
+	if err != nil {
+		return // Response already sent
+	}
+
+	entryID, err := strconv.ParseInt(r.PathValue("entryID"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid history entry id",
+		})
+		return
+	}
+
+	if err := tables.RestoreRow(db, table, rowID, entryID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Row %s of %s restored from history entry %d by %s", rowID, table, entryID, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "row restored successfully",
+	})
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/scan"
+)
+
+// scanTimeout bounds how long a single background scan may take
+const scanTimeout = 2 * time.Minute
+
+// maxScanBytes bounds the content streamed to the scanner. Larger documents
+// are quarantined for review rather than served unscanned.
+const maxScanBytes = 256 << 20
+
+// errScanTooLarge is returned when content exceeds maxScanBytes
+var errScanTooLarge = fmt.Errorf("content exceeds the scan limit of %d bytes", maxScanBytes)
+
+// SetScanner configures the malware scanner used for binary document writes.
+// A nil scanner disables scanning.
+func (s *Server) SetScanner(scanner scan.Scanner) {
+	s.scanner = scanner
+}
+
+// queueDocumentScan marks a binary document as pending and scans it in the
+// background. If the scan cannot be queued the document is quarantined, so
+// it is never served unscanned.
+func (s *Server) queueDocumentScan(db *sql.DB, doc *document.Document) {
+	if s.scanner == nil || !doc.IsBinary {
+		return
+	}
+
+	if err := document.MarkScanPending(db, doc.ID, doc.Version); err != nil {
+		log.Printf("Failed to queue scan for document %s: %v", doc.ID, err)
+		s.quarantineUnscanned(db, doc.ID, doc.Version, err)
+		return
+	}
+
+	go s.runDocumentScan(db, doc)
+}
+
+// quarantineUnscanned holds a document that could not be scanned
+func (s *Server) quarantineUnscanned(db *sql.DB, docID string, version int, cause error) {
+	if err := document.QuarantineUnscanned(db, docID, version, "scan error: "+cause.Error()); err != nil {
+		log.Printf("Failed to quarantine unscanned document %s: %v", docID, err)
+		return
+	}
+	log.Printf("Document %s (version %d) quarantined: scan error: %v", docID, version, cause)
+}
+
+// openDocumentContent returns a reader of a binary document's decoded
+// content, streaming documents from blob storage
+func openDocumentContent(ctx context.Context, db *sql.DB, doc *document.Document) (io.ReadCloser, error) {
+	if !doc.IsBlob() {
+		return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(doc.Content))), nil
+	}
+	return document.OpenBlob(ctx, db, doc.ID)
+}
+
+// scanLimitReader fails with errScanTooLarge once more than its limit is read
+type scanLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *scanLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, errScanTooLarge
+	}
+	return n, err
+}
+
+// runDocumentScan streams the document to the scanner and records the
+// verdict. Scanner failures quarantine the document so it is never served
+// unscanned.
+func (s *Server) runDocumentScan(db *sql.DB, doc *document.Document) {
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+
+	content, err := openDocumentContent(ctx, db, doc)
+	if err != nil {
+		log.Printf("Failed to read document %s for scanning: %v", doc.ID, err)
+		s.quarantineUnscanned(db, doc.ID, doc.Version, err)
+		return
+	}
+	defer content.Close()
+
+	infected := true
+	signature := ""
+
+	result, err := s.scanner.Scan(ctx, doc.ID, &scanLimitReader{r: content, remaining: maxScanBytes})
+	if err != nil {
+		log.Printf("Scan of document %s failed: %v", doc.ID, err)
+		signature = "scan error: " + err.Error()
+	} else {
+		infected = result.Infected
+		signature = result.Signature
+	}
+
+	if err := document.RecordScanResult(db, doc.ID, doc.Version, infected, signature); err != nil {
+		log.Printf("Failed to record scan result for document %s: %v", doc.ID, err)
+		return
+	}
+
+	if infected {
+		log.Printf("Document %s (version %d) quarantined: %s", doc.ID, doc.Version, signature)
+	}
+}
+
+// handleListQuarantine lists quarantined documents (admin/owner only)
+func (s *Server) handleListQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "review quarantined documents")
+	if err != nil {
+		return // Response already sent
+	}
+
+	records, err := document.ListQuarantined(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list quarantined documents",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"documents": records,
+		"count":     len(records),
+	})
+}
+
+// handleReviewQuarantine releases or deletes a quarantined document (admin/owner only)
+func (s *Server) handleReviewQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	docID := r.PathValue("docID")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "review quarantined documents")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "review_quarantine", "document", docID); err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Action string `json:"action"` // "release" or "delete"
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	switch req.Action {
+	case "release":
+		err = document.ReleaseQuarantined(db, docID, userID)
+	case "delete":
+		err = document.DeleteDocument(db, docID, userID)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "action must be 'release' or 'delete'",
+		})
+		return
+	}
+
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Quarantined document %s reviewed by %s: %s", docID, userID, req.Action)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "document " + req.Action + "d successfully",
+	})
+}
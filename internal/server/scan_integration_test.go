@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/scan"
+)
+
+// fakeScanner flags any content containing "EICAR"
+type fakeScanner struct{}
+
+func (fakeScanner) Scan(ctx context.Context, name string, r io.Reader) (*scan.Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(data), "EICAR") {
+		return &scan.Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return &scan.Result{Infected: false}, nil
+}
+
+// waitForScan polls until the document's scan is no longer pending
+func waitForScan(t *testing.T, manager *cenv.Manager, cenvID, docID string) *document.ScanRecord {
+	t.Helper()
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		rec, err := document.GetScanRecord(db, docID)
+		if err != nil {
+			t.Fatalf("Failed to get scan record: %v", err)
+		}
+		if rec != nil && rec.Status != document.ScanStatusPending {
+			return rec
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Scan of %s did not complete", docID)
+	return nil
+}
+
+func TestScanFailsClosed(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+	srv.SetScanner(fakeScanner{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	cenvID, _ := setupTestCenv(t, mux)
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+
+	admin, err := auth.GetUserByUsername(db, "admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	// A document whose content cannot be read is quarantined
+	doc, err := document.CreateDocument(db, "files/bad.bin", base64.StdEncoding.EncodeToString([]byte("x")), "application/octet-stream", admin.UserID, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	doc.Content = "not base64!"
+	srv.queueDocumentScan(db, doc)
+	rec := waitForScan(t, manager, cenvID, "files/bad.bin")
+	if rec.Status != document.ScanStatusQuarantined || !strings.HasPrefix(rec.Signature, "scan error: ") {
+		t.Errorf("Expected unreadable content to be quarantined, got %+v", rec)
+	}
+
+	// Content is streamed up to the scan limit, and more fails the scan
+	if data, err := io.ReadAll(&scanLimitReader{r: strings.NewReader("abcd"), remaining: 4}); err != nil || string(data) != "abcd" {
+		t.Errorf("Expected content at the limit to be read, got %q %v", data, err)
+	}
+	if _, err := io.ReadAll(&scanLimitReader{r: strings.NewReader("abcde"), remaining: 4}); err != errScanTooLarge {
+		t.Errorf("Expected errScanTooLarge, got %v", err)
+	}
+}
+
+func TestBinaryUploadQuarantine(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+	srv.SetScanner(fakeScanner{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("GET /{cenvID}/admin/quarantine", srv.handleListQuarantine)
+	mux.HandleFunc("POST /{cenvID}/admin/quarantine/{docID...}", srv.handleReviewQuarantine)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	upload := func(id, content string) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id":           id,
+			"content":      base64.StdEncoding.EncodeToString([]byte(content)),
+			"content_type": "application/octet-stream",
+			"is_binary":    true,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to upload %s: %d %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	upload("files/clean.bin", "harmless")
+	upload("files/infected.bin", "EICAR")
+
+	if rec := waitForScan(t, manager, cenvID, "files/clean.bin"); rec.Status != document.ScanStatusClean {
+		t.Errorf("Expected clean status, got %s", rec.Status)
+	}
+	if rec := waitForScan(t, manager, cenvID, "files/infected.bin"); rec.Status != document.ScanStatusQuarantined {
+		t.Errorf("Expected quarantined status, got %s", rec.Status)
+	}
+
+	t.Run("CleanDocumentServed", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/files/clean.bin", token, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("QuarantinedDocumentBlocked", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/documents/files/infected.bin", token, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ListQuarantine", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/quarantine", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "files/infected.bin") {
+			t.Errorf("Expected infected document in quarantine list: %s", w.Body.String())
+		}
+	})
+
+	t.Run("ReleaseDocument", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/quarantine/files/infected.bin", token, map[string]string{
+			"action": "release",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "GET", "/"+cenvID+"/documents/files/infected.bin", token, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected released document to be served, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/secrets"
+)
+
+// MasterKeyFile is the local master key file, kept in the storage directory
+// unless a KMS wrapper is configured
+const MasterKeyFile = "master.key"
+
+// SetSecretKeys configures the master key wrapper that protects each cenv's
+// secrets data key, e.g. a KMS plug-in. Data keys wrapped by the previous
+// wrapper must still be unwrappable by the new one; they are re-wrapped on
+// next use.
+func (s *Server) SetSecretKeys(keys secrets.KeyWrapper) {
+	s.secrets = secrets.NewManager(keys)
+}
+
+// secretManager returns the secrets manager, responding with an error if no
+// master key could be loaded
+func (s *Server) secretManager(w http.ResponseWriter) (*secrets.Manager, bool) {
+	if s.secrets == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "secret storage is not configured",
+		})
+		return nil, false
+	}
+	return s.secrets, true
+}
+
+// handleSecretKeyStatus describes the cenv's secrets data key (admin/owner only)
+// Route: GET /{cenvID}/admin/secrets/key
+func (s *Server) handleSecretKeyStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view secrets")
+	if err != nil {
+		return // Response already sent
+	}
+
+	vault, ok := s.secretManager(w)
+	if !ok {
+		return
+	}
+
+	status, err := vault.Status(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleRotateSecretKey replaces the cenv's secrets data key and re-encrypts
+// every secret with it (admin/owner only)
+// Route: POST /{cenvID}/admin/secrets/key/rotate
+func (s *Server) handleRotateSecretKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "manage secrets")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "rotate_secret_key", "secrets", ""); err != nil {
+		return // Response already sent
+	}
+
+	vault, ok := s.secretManager(w)
+	if !ok {
+		return
+	}
+
+	status, err := vault.Rotate(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Secrets data key for cenv %s rotated by %s (%d secrets re-encrypted)", cenvID, userID, status.Secrets)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
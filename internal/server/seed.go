@@ -0,0 +1,147 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/seed"
+)
+
+// SeedRequest selects which fixtures to re-apply
+type SeedRequest struct {
+	Fixture   string `json:"fixture"`   // Optional; all fixtures when empty
+	Overwrite bool   `json:"overwrite"` // Update existing rows and documents to match
+}
+
+// installSeedFixture applies a fixture document when an owner or admin
+// stores it. Fixtures written by other roles wait for POST /admin/seed, since
+// applying them could write to tables the author has no access to.
+func (s *Server) installSeedFixture(db *sql.DB, doc *document.Document, userID, role string) {
+	if !seed.IsFixture(doc.ID) || (role != authz.RoleOwner && role != authz.RoleAdmin) {
+		return
+	}
+
+	pending, err := seed.NeedsApply(db, doc.ID, doc.Content)
+	if err != nil {
+		log.Printf("Failed to check seed fixture %s: %v", doc.ID, err)
+		return
+	}
+	if !pending {
+		return
+	}
+
+	run, err := seed.Apply(db, doc.ID, userID, false)
+	if err != nil {
+		log.Printf("Failed to apply seed fixture %s: %v", doc.ID, err)
+	}
+	if run != nil {
+		for _, seeded := range run.Documents {
+			s.queueDocumentScan(db, seeded)
+		}
+	}
+}
+
+// handleListSeedRuns lists the last application of each seed fixture (admin/owner only)
+func (s *Server) handleListSeedRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view seed fixtures")
+	if err != nil {
+		return // Response already sent
+	}
+
+	runs, err := seed.ListRuns(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"runs":  runs,
+		"count": len(runs),
+	})
+}
+
+// handleApplySeed re-applies one or all seed fixtures (admin/owner only)
+func (s *Server) handleApplySeed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "apply seed fixtures")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "apply_seed", "cenv", cenvID); err != nil {
+		return // Response already sent
+	}
+
+	// An empty body re-applies every fixture
+	var req SeedRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "invalid request body",
+			})
+			return
+		}
+	}
+
+	var runs []*seed.Run
+	if req.Fixture != "" {
+		run, err := seed.Apply(db, req.Fixture, userID, req.Overwrite)
+		if run == nil {
+			writeDocumentError(w, err)
+			return
+		}
+		runs = []*seed.Run{run}
+	} else {
+		runs, err = seed.ApplyAll(db, userID, req.Overwrite)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+	}
+
+	failed := 0
+	for _, run := range runs {
+		if run.Status == seed.StatusFailed {
+			failed++
+		}
+		for _, seeded := range run.Documents {
+			s.queueDocumentScan(db, seeded)
+		}
+	}
+
+	log.Printf("Seed fixtures applied by %s: %d run, %d failed", userID, len(runs), failed)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"runs":   runs,
+		"count":  len(runs),
+		"failed": failed,
+	})
+}
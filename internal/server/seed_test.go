@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/seed"
+)
+
+func TestSeedFixtures(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("GET /{cenvID}/admin/seed", srv.handleListSeedRuns)
+	mux.HandleFunc("POST /{cenvID}/admin/seed", srv.handleApplySeed)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE statuses (code TEXT PRIMARY KEY, label TEXT)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	countStatuses := func() int {
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM statuses`).Scan(&count)
+		return count
+	}
+
+	t.Run("InstalledOnWrite", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id":           "seeds/statuses",
+			"content":      `{"tables": [{"table": "statuses", "rows": [{"code": "open", "label": "Open"}]}]}`,
+			"content_type": "application/json",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		if countStatuses() != 1 {
+			t.Errorf("Expected fixture applied on create, got %d rows", countStatuses())
+		}
+
+		w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/seeds/statuses", token, map[string]string{
+			"content": `{"tables": [{"table": "statuses", "rows": [{"code": "open", "label": "Open"}, {"code": "done", "label": "Done"}]}]}`,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if countStatuses() != 2 {
+			t.Errorf("Expected fixture re-applied on update, got %d rows", countStatuses())
+		}
+	})
+
+	t.Run("Reapply", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/seed", editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+
+		db.Exec(`DELETE FROM statuses WHERE code = 'done'`)
+		db.Exec(`UPDATE statuses SET label = 'Edited' WHERE code = 'open'`)
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/admin/seed", token, SeedRequest{Overwrite: true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Runs   []seed.Run `json:"runs"`
+			Failed int        `json:"failed"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Runs) != 1 || resp.Runs[0].RowsInserted != 1 || resp.Runs[0].RowsUpdated != 1 {
+			t.Errorf("Unexpected runs: %+v", resp.Runs)
+		}
+
+		var label string
+		db.QueryRow(`SELECT label FROM statuses WHERE code = 'open'`).Scan(&label)
+		if label != "Open" || countStatuses() != 2 {
+			t.Errorf("Expected seed data restored, got label %q and %d rows", label, countStatuses())
+		}
+
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/admin/seed", token, SeedRequest{Fixture: "seeds/missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for unknown fixture, got %d", w.Code)
+		}
+	})
+
+	t.Run("ListRuns", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/seed", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Runs []seed.Run `json:"runs"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Runs) != 1 || resp.Runs[0].Status != seed.StatusApplied {
+			t.Errorf("Unexpected runs: %+v", resp.Runs)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/thetanil/wce/internal/sqlite"
+	"github.com/thetanil/wce/internal/template"
+)
+
+// Outcomes of a startup check. A warning is logged; a failure stops the
+// server from starting.
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// Check is the outcome of one startup check
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// SelfCheckReport aggregates the startup checks
+type SelfCheckReport struct {
+	Checks []Check `json:"checks"`
+}
+
+// OK reports whether no check failed
+func (r *SelfCheckReport) OK() bool {
+	return len(r.Failures()) == 0
+}
+
+// Failures returns the checks that failed
+func (r *SelfCheckReport) Failures() []Check {
+	var failed []Check
+	for _, check := range r.Checks {
+		if check.Status == CheckFail {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// String formats the report one check per line
+func (r *SelfCheckReport) String() string {
+	var b strings.Builder
+	for _, check := range r.Checks {
+		fmt.Fprintf(&b, "  [%-4s] %-10s %s\n", check.Status, check.Name, check.Detail)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (r *SelfCheckReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// SelfCheck verifies the environment the server needs before it starts:
+// writable storage and temporary directories, an SQLite build with FTS5 and
+// WAL support, the embedded template library, sane settings and a free port.
+// Start refuses to run when any check fails, so a broken deployment shows
+// up in the startup log instead of as 500s on the first search or render.
+func (s *Server) SelfCheck() *SelfCheckReport {
+	report := &SelfCheckReport{}
+	storageOK := s.checkStorage(report)
+	s.checkSQLite(report, storageOK)
+	s.checkTempDir(report)
+	s.checkAssets(report)
+	s.checkConfig(report)
+	s.checkPort(report)
+	return report
+}
+
+// checkStorage verifies the storage directory exists and is writable by
+// creating and removing a file in it
+func (s *Server) checkStorage(report *SelfCheckReport) bool {
+	dir := s.cenvManager.StorageDir()
+	info, err := os.Stat(dir)
+	if err != nil {
+		report.add("storage", CheckFail, "storage directory %s: %v", dir, err)
+		return false
+	}
+	if !info.IsDir() {
+		report.add("storage", CheckFail, "storage directory %s is not a directory", dir)
+		return false
+	}
+
+	probe, err := os.CreateTemp(dir, ".selfcheck-*")
+	if err != nil {
+		report.add("storage", CheckFail, "storage directory %s is not writable: %v", dir, err)
+		return false
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if info.Mode().Perm()&0002 != 0 {
+		report.add("storage", CheckWarn, "storage directory %s is world-writable (%v)", dir, info.Mode().Perm())
+		return true
+	}
+	report.add("storage", CheckOK, "%s is writable", dir)
+	return true
+}
+
+// checkSQLite verifies the SQLite build supports FTS5, which document search
+// needs (build with -tags fts5 or purego), and WAL journaling on the storage
+// filesystem, which cenv databases are opened with
+func (s *Server) checkSQLite(report *SelfCheckReport, storageOK bool) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		report.add("sqlite", CheckFail, "failed to open SQLite: %v", err)
+		return
+	}
+	defer db.Close()
+
+	var version string
+	if err := db.QueryRow(`SELECT sqlite_version()`).Scan(&version); err != nil {
+		report.add("sqlite", CheckFail, "failed to query SQLite: %v", err)
+		return
+	}
+	report.add("sqlite", CheckOK, "SQLite %s", version)
+
+	if _, err := db.Exec(`CREATE VIRTUAL TABLE selfcheck USING fts5(content)`); err != nil {
+		report.add("fts5", CheckFail, "FTS5 unavailable, document search will fail (build with -tags fts5 or purego): %v", err)
+	} else {
+		report.add("fts5", CheckOK, "FTS5 available")
+	}
+
+	if !storageOK {
+		report.add("wal", CheckFail, "not checked: storage directory unusable")
+		return
+	}
+	mode, err := walMode(s.cenvManager.StorageDir())
+	if err != nil {
+		report.add("wal", CheckFail, "failed to test WAL journaling: %v", err)
+	} else if mode != "wal" {
+		report.add("wal", CheckFail, "WAL journaling unsupported on the storage filesystem (journal mode %q)", mode)
+	} else {
+		report.add("wal", CheckOK, "WAL journaling supported")
+	}
+}
+
+// walMode switches a scratch database in dir to WAL and returns the journal
+// mode SQLite reports, which stays "delete" where WAL is unsupported
+func walMode(dir string) (string, error) {
+	file, err := os.CreateTemp(dir, ".selfcheck-*.db")
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+	file.Close()
+	defer func() {
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			os.Remove(path + suffix)
+		}
+	}()
+
+	db, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var mode string
+	if err := db.QueryRow(`PRAGMA journal_mode = WAL`).Scan(&mode); err != nil {
+		return "", err
+	}
+	return strings.ToLower(mode), nil
+}
+
+// checkAssets verifies the embedded template library loads
+func (s *Server) checkAssets(report *SelfCheckReport) {
+	if err := template.SourceLoaded(); err != nil {
+		report.add("assets", CheckFail, "embedded template library: %v", err)
+		return
+	}
+	report.add("assets", CheckOK, "embedded template library loads")
+}
+
+// checkTempDir verifies the temporary directory is writable: snapshots of
+// remote cenvs are built there and SQLite spills large sorts to it. With a
+// read-only root filesystem it must point into the storage directory.
+func (s *Server) checkTempDir(report *SelfCheckReport) {
+	dir := os.TempDir()
+	probe, err := os.CreateTemp(dir, ".selfcheck-*")
+	if err != nil {
+		report.add("tempdir", CheckFail, "temporary directory %s is not writable (set TMPDIR): %v", dir, err)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	report.add("tempdir", CheckOK, "%s is writable", dir)
+}
+
+// checkConfig reports settings that are invalid or leave features off
+func (s *Server) checkConfig(report *SelfCheckReport) {
+	var failures, warnings []string
+	if s.port < 0 || s.port > 65535 {
+		failures = append(failures, fmt.Sprintf("port %d out of range", s.port))
+	}
+	if s.jwtManager == nil {
+		failures = append(failures, "no JWT secret")
+	}
+	if s.drainTimeout <= 0 {
+		warnings = append(warnings, "shutdown does not wait for in-flight requests")
+	}
+	if s.sweepInterval <= 0 {
+		warnings = append(warnings, "background maintenance disabled")
+	}
+	if s.secrets == nil {
+		warnings = append(warnings, "secret storage disabled")
+	}
+
+	switch {
+	case len(failures) > 0:
+		report.add("config", CheckFail, "%s", strings.Join(append(failures, warnings...), "; "))
+	case len(warnings) > 0:
+		report.add("config", CheckWarn, "%s", strings.Join(warnings, "; "))
+	default:
+		report.add("config", CheckOK, "settings valid")
+	}
+}
+
+// checkPort verifies the port can be bound by binding and releasing it.
+// A socket passed by systemd needs no check, and with SO_REUSEPORT the port
+// is expected to be held by the process being replaced.
+func (s *Server) checkPort(report *SelfCheckReport) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err == nil && pid == os.Getpid() {
+		report.add("port", CheckOK, "socket passed by systemd")
+		return
+	}
+	if s.reusePort {
+		report.add("port", CheckOK, "port %d shared with SO_REUSEPORT", s.port)
+		return
+	}
+	if s.port == 0 {
+		report.add("port", CheckOK, "ephemeral port")
+		return
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	if err != nil {
+		report.add("port", CheckFail, "port %d unavailable: %v", s.port, err)
+		return
+	}
+	listener.Close()
+	report.add("port", CheckOK, "port %d available", s.port)
+}
+
+// runSelfCheck runs the startup checks, logs the report and returns an
+// error naming the failed checks
+func (s *Server) runSelfCheck() error {
+	report := s.SelfCheck()
+	log.Printf("Startup self-check:\n%s", report)
+	if failures := report.Failures(); len(failures) > 0 {
+		names := make([]string, len(failures))
+		for i, check := range failures {
+			names[i] = check.Name
+		}
+		return fmt.Errorf("startup self-check failed: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func checkStatus(report *SelfCheckReport, name string) string {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check.Status
+		}
+	}
+	return ""
+}
+
+func TestSelfCheck(t *testing.T) {
+	dir := t.TempDir()
+	srv := New(0, cenv.NewManager(dir))
+
+	report := srv.SelfCheck()
+	for _, name := range []string{"storage", "sqlite", "wal", "tempdir", "assets", "config", "port"} {
+		if status := checkStatus(report, name); status != CheckOK && status != CheckWarn {
+			t.Errorf("Expected %s check to pass, got %q:\n%s", name, status, report)
+		}
+	}
+	if checkStatus(report, "fts5") == "" {
+		t.Error("Expected an fts5 check")
+	}
+
+	// Scratch files are cleaned up
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".selfcheck-") {
+			t.Errorf("Scratch file left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestSelfCheckFailures(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer busy.Close()
+
+	srv := New(busy.Addr().(*net.TCPAddr).Port, cenv.NewManager(file))
+	report := srv.SelfCheck()
+	if report.OK() {
+		t.Fatalf("Expected the self-check to fail:\n%s", report)
+	}
+	for _, name := range []string{"storage", "wal", "port"} {
+		if status := checkStatus(report, name); status != CheckFail {
+			t.Errorf("Expected %s check to fail, got %q", name, status)
+		}
+	}
+
+	if err := srv.Start(); err == nil || !strings.Contains(err.Error(), "storage") {
+		t.Errorf("Expected Start to refuse to run, got %v", err)
+	}
+}
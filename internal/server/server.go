@@ -4,17 +4,29 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/thetanil/wce/internal/alerts"
 	"github.com/thetanil/wce/internal/auth"
 	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/cluster"
+	"github.com/thetanil/wce/internal/mail"
+	"github.com/thetanil/wce/internal/reporting"
+	"github.com/thetanil/wce/internal/scan"
+	"github.com/thetanil/wce/internal/secrets"
 )
 
 // Server represents the WCE HTTP server
@@ -23,21 +35,63 @@ type Server struct {
 	port        int
 	cenvManager *cenv.Manager
 	jwtManager  *auth.JWTManager
-	jwtSecret   string
+	scanner     scan.Scanner
+	secrets     *secrets.Manager
+	proxyClient *http.Client // Dials through the egress guard
+	monitor     *alerts.Monitor
+	coordinator *cluster.Coordinator
+	jobs        *jobRegistry
+	ephemeral   *clientLimiter // Ephemeral cenvs created per client network
+	errorSinks  []reporting.Sink
+	panics      panicCounter
+
+	operatorToken string
+	reusePort     bool
+	drainTimeout  time.Duration
+	sweepInterval time.Duration
+	draining      atomic.Bool // Set once shutdown starts; /health reports it
+	waking        sync.Map    // cenvID -> struct{}, archived cenvs being restored
+
+	mailer                   mail.Mailer // Password reset, verification and script emails, when set
+	publicURL                string      // Base of links in emails
+	requireEmailVerification bool
 }
 
 // New creates a new Server instance
 func New(port int, cenvManager *cenv.Manager) *Server {
-	// Generate a random JWT secret if not provided
-	// In production, this should be loaded from environment or config
-	jwtSecret := generateRandomSecret()
+	s := &Server{
+		port:          port,
+		cenvManager:   cenvManager,
+		monitor:       alerts.NewMonitor(),
+		jobs:          newJobRegistry(),
+		ephemeral:     newClientLimiter(ephemeralPerClient, ephemeralClientWindow),
+		drainTimeout:  DefaultDrainTimeout,
+		sweepInterval: DefaultSweepInterval,
+	}
+
+	// Tokens are signed with keys kept in the storage directory, so they
+	// outlive restarts, unless secrets are configured with SetJWTSecrets
+	jwtKeys, err := auth.LoadOrCreateJWTKeys(filepath.Join(cenvManager.StorageDir(), JWTKeyFile))
+	if err != nil {
+		log.Printf("JWT keys not persisted, restarts will sign everyone out: %v", err)
+		jwtKeys = auth.StaticJWTKeys(generateRandomSecret())
+	}
+	s.jwtManager = auth.NewJWTManagerWithKeys(jwtKeys)
+
+	// Outbound requests to cenv-configured URLs cannot reach internal
+	// addresses unless SetEgressAllowlist lets them
+	s.SetEgressAllowlist()
 
-	return &Server{
-		port:        port,
-		cenvManager: cenvManager,
-		jwtManager:  auth.NewJWTManager(jwtSecret),
-		jwtSecret:   jwtSecret,
+	// Secrets are encrypted under a local master key unless a KMS wrapper
+	// is configured with SetSecretKeys
+	keys, err := secrets.LoadOrCreateLocalKeys(filepath.Join(cenvManager.StorageDir(), MasterKeyFile))
+	if err != nil {
+		log.Printf("Secret storage disabled: %v", err)
+	} else {
+		s.secrets = secrets.NewManager(keys)
 	}
+
+	return s
 }
 
 // generateRandomSecret generates a random secret for JWT signing
@@ -49,70 +103,48 @@ func generateRandomSecret() string {
 
 // Start starts the HTTP server with graceful shutdown support
 func (s *Server) Start() error {
-	// Create router
-	mux := http.NewServeMux()
-
-	// Register routes with specific patterns
-	// Pattern matching priority: most specific to least specific
-	mux.HandleFunc("GET /health", s.handleHealth)
-	mux.HandleFunc("/new", s.handleNewCenv)
-	mux.HandleFunc("POST /{cenvID}/login", s.handleLogin)
-
-	// Permission management endpoints (admin only)
-	mux.HandleFunc("GET /{cenvID}/admin/permissions", s.handleListPermissions)
-	mux.HandleFunc("POST /{cenvID}/admin/permissions", s.handleGrantPermission)
-	mux.HandleFunc("DELETE /{cenvID}/admin/permissions", s.handleRevokePermission)
-	mux.HandleFunc("GET /{cenvID}/admin/policies", s.handleListPolicies)
-	mux.HandleFunc("POST /{cenvID}/admin/policies", s.handleCreatePolicy)
-
-	// Document API endpoints
-	// Note: Order matters - more specific routes must come first
-	// The {docID...} pattern captures paths with slashes (e.g., "pages/home", "api/users/list")
-	mux.HandleFunc("GET /{cenvID}/documents/search", s.handleSearchDocuments)
-	mux.HandleFunc("POST /{cenvID}/documents", s.handleCreateDocument)
-	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", s.handleGetDocument)
-	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", s.handleUpdateDocument)
-	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", s.handleDeleteDocument)
-	mux.HandleFunc("GET /{cenvID}/documents", s.handleListDocuments)
-
-	// Starlark endpoint management (admin only)
-	mux.HandleFunc("GET /{cenvID}/admin/endpoints", s.handleListEndpoints)
-	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}", s.handleGetEndpoint)
-	mux.HandleFunc("POST /{cenvID}/admin/endpoints", s.handleCreateEndpoint)
-	mux.HandleFunc("DELETE /{cenvID}/admin/endpoints/{endpointID}", s.handleDeleteEndpoint)
-
-	// Starlark endpoint execution (matches /star/* paths)
-	mux.HandleFunc("/{cenvID}/star/{starPath...}", s.handleExecuteStarlarkEndpoint)
-
-	// Template endpoints
-	mux.HandleFunc("GET /{cenvID}/templates", s.handleListTemplates)
-	mux.HandleFunc("POST /{cenvID}/templates/preview", s.handlePreviewTemplate)
-	mux.HandleFunc("GET /{cenvID}/pages/{path...}", s.handleRenderPage)
-
-	// Match both /{cenvID}/ and /{cenvID}/path/to/resource
-	mux.HandleFunc("/{cenvID}/{path...}", s.handleCenvRequest)
+	// Refuse to start with broken storage, SQLite or settings
+	if err := s.runSelfCheck(); err != nil {
+		return err
+	}
 
-	// Wrap with logging middleware
-	handler := loggingMiddleware(mux)
+	// Move cenvs onto the storage volumes their registry attributes select
+	if err := s.cenvManager.LoadRegistry(); err != nil {
+		return fmt.Errorf("failed to apply storage registry: %w", err)
+	}
 
 	// Configure HTTP server
 	s.httpServer = &http.Server{
 		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      handler,
+		Handler:      s.Handler(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Use the socket passed by systemd, or bind the port ourselves
+	listener, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
 	// Channel to listen for errors coming from the listener
 	serverErrors := make(chan error, 1)
 
 	// Start the server
 	go func() {
-		log.Printf("Starting WCE server on http://localhost:%d", s.port)
-		serverErrors <- s.httpServer.ListenAndServe()
+		log.Printf("Starting WCE server on http://%s", listener.Addr())
+		serverErrors <- s.httpServer.Serve(listener)
 	}()
 
+	// Remove expired documents and archive idle cenvs in the background
+	// until shutdown
+	stopMaintenance := make(chan struct{})
+	defer close(stopMaintenance)
+	if s.sweepInterval > 0 {
+		go s.runMaintenance(stopMaintenance)
+	}
+
 	// Channel to listen for interrupt signal to terminate
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
@@ -124,9 +156,11 @@ func (s *Server) Start() error {
 
 	case sig := <-shutdown:
 		log.Printf("Received signal %v, starting graceful shutdown", sig)
+		s.draining.Store(true)
 
-		// Give outstanding requests 30 seconds to complete
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Stop accepting connections and let outstanding requests complete.
+		// A replacement process sharing the socket takes new connections meanwhile.
+		ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
 		defer cancel()
 
 		// Attempt graceful shutdown
@@ -136,15 +170,266 @@ func (s *Server) Start() error {
 			return fmt.Errorf("could not gracefully shutdown server: %w", err)
 		}
 
+		// Hand write leases to the other instances without waiting for expiry
+		if s.coordinator != nil {
+			if err := s.coordinator.Close(); err != nil {
+				log.Printf("Failed to release write leases: %v", err)
+			}
+		}
+
 		log.Println("Server stopped gracefully")
 	}
 
 	return nil
 }
 
+// Handler returns the server's routes wrapped in its middleware, for
+// embedders that run their own http.Server. Start serves the same handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Register routes with specific patterns
+	// Pattern matching priority: most specific to least specific
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("/new", s.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", s.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/auth/refresh", s.handleRefreshToken)
+	mux.HandleFunc("POST /{cenvID}/token", s.handleIssueToken)
+
+	// Sign out, and list or revoke the caller's own sessions
+	mux.HandleFunc("POST /{cenvID}/logout", s.handleLogout)
+	mux.HandleFunc("GET /{cenvID}/me/sessions", s.handleListMySessions)
+	mux.HandleFunc("DELETE /{cenvID}/me/sessions/{id}", s.handleRevokeMySession)
+	mux.HandleFunc("POST /{cenvID}/me/password", s.handleChangePassword)
+	mux.HandleFunc("GET /{cenvID}/me/export", s.handleExportMyData)
+
+	// Password resets and email verification, without logging in
+	mux.HandleFunc("POST /{cenvID}/password/forgot", s.handleForgotPassword)
+	mux.HandleFunc("POST /{cenvID}/password/reset", s.handleResetPassword)
+	mux.HandleFunc("POST /{cenvID}/me/email/verify", s.handleRequestEmailVerification)
+	mux.HandleFunc("GET /{cenvID}/email/verify", s.handleVerifyEmail)
+
+	// Operator API for cenv storage reporting, background job health and
+	// JWT key rotation, enabled by SetOperatorToken
+	mux.HandleFunc("GET /operator/cenvs", s.handleOperatorListCenvs)
+	mux.HandleFunc("GET /operator/cenvs/{cenvID}", s.handleOperatorGetCenv)
+	mux.HandleFunc("GET /operator/jobs", s.handleOperatorJobs)
+	mux.HandleFunc("GET /operator/metrics", s.handleOperatorMetrics) // Prometheus text format
+	mux.HandleFunc("GET /operator/jwt/keys", s.handleOperatorJWTKeys)
+	mux.HandleFunc("POST /operator/jwt/keys/rotate", s.handleRotateJWTKey)
+
+	// OAuth device flow for CLI login: the device polls while the user
+	// confirms the code in a browser
+	mux.HandleFunc("POST /{cenvID}/device/code", s.handleDeviceCode)
+	mux.HandleFunc("POST /{cenvID}/device/token", s.handleDeviceToken)
+	mux.HandleFunc("GET /{cenvID}/device", s.handleDevicePage)
+	mux.HandleFunc("POST /{cenvID}/device", s.handleDeviceConfirm)
+
+	// Permission management endpoints (admin only)
+	mux.HandleFunc("GET /{cenvID}/admin/permissions", s.handleListPermissions)
+	mux.HandleFunc("POST /{cenvID}/admin/permissions", s.handleGrantPermission)
+	mux.HandleFunc("DELETE /{cenvID}/admin/permissions", s.handleRevokePermission)
+	mux.HandleFunc("GET /{cenvID}/admin/permissions/{userID}/{table}", s.handleGetPermission)
+	mux.HandleFunc("PUT /{cenvID}/admin/permissions/{userID}/{table}", s.handlePutPermission)
+	mux.HandleFunc("DELETE /{cenvID}/admin/permissions/{userID}/{table}", s.handleDeletePermission)
+	mux.HandleFunc("GET /{cenvID}/admin/policies", s.handleListPolicies)
+	mux.HandleFunc("POST /{cenvID}/admin/policies", s.handleCreatePolicy)
+
+	// User management (admin only); PUT creates or updates idempotently
+	mux.HandleFunc("GET /{cenvID}/admin/users", s.handleListUsers)
+	mux.HandleFunc("GET /{cenvID}/admin/users/{username}", s.handleGetUser)
+	mux.HandleFunc("PUT /{cenvID}/admin/users/{username}", s.handlePutUser)
+	mux.HandleFunc("DELETE /{cenvID}/admin/users/{username}", s.handleDeleteUser)
+	mux.HandleFunc("POST /{cenvID}/admin/users/{username}/password-reset", s.handleCreatePasswordReset)
+	mux.HandleFunc("POST /{cenvID}/admin/users/{username}/erase", s.handleEraseUser)
+
+	// Declarative management: cenv read-back and a dry-run diff of desired state
+	mux.HandleFunc("GET /{cenvID}/admin/cenv", s.handleGetCenv)
+	mux.HandleFunc("POST /{cenvID}/admin/plan", s.handlePlan)
+
+	// User table schema builder and introspection
+	mux.HandleFunc("GET /{cenvID}/api/tables", s.handleListTables)
+	mux.HandleFunc("POST /{cenvID}/api/tables", s.handleCreateTable)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}", s.handleDescribeTable)
+
+	// User table index management (owner/admin or grant permission on the table)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}/indexes", s.handleListIndexes)
+	mux.HandleFunc("POST /{cenvID}/api/tables/{table}/indexes", s.handleManageIndex)
+
+	// Row history for user tables (opt-in per table)
+	mux.HandleFunc("PUT /{cenvID}/api/tables/{table}/history", s.handleSetTableHistory)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}/rows/{rowID}/history", s.handleRowHistory)
+	mux.HandleFunc("POST /{cenvID}/api/tables/{table}/rows/{rowID}/history/{entryID}/restore", s.handleRestoreRow)
+
+	// Soft delete for user tables (deleted_at marks removed rows until purged)
+	mux.HandleFunc("PUT /{cenvID}/api/tables/{table}/soft-delete", s.handleSetSoftDelete)
+	mux.HandleFunc("POST /{cenvID}/api/tables/{table}/purge", s.handlePurgeDeleted)
+
+	// Quarantine review for binary documents flagged by the malware scanner
+	mux.HandleFunc("GET /{cenvID}/admin/quarantine", s.handleListQuarantine)
+	mux.HandleFunc("POST /{cenvID}/admin/quarantine/{docID...}", s.handleReviewQuarantine)
+
+	// Content type serving policies for raw document responses
+	mux.HandleFunc("GET /{cenvID}/admin/mime-policies", s.handleListMIMEPolicies)
+	mux.HandleFunc("PUT /{cenvID}/admin/mime-policies", s.handleSetMIMEPolicy)
+	mux.HandleFunc("DELETE /{cenvID}/admin/mime-policies", s.handleDeleteMIMEPolicy)
+
+	// Seed fixtures (JSON documents under seeds/)
+	mux.HandleFunc("GET /{cenvID}/admin/seed", s.handleListSeedRuns)
+	mux.HandleFunc("POST /{cenvID}/admin/seed", s.handleApplySeed)
+
+	// Cross-cenv sharing: grants issued by this cenv and tokens it holds
+	mux.HandleFunc("GET /{cenvID}/admin/shares", s.handleListShares)
+	mux.HandleFunc("POST /{cenvID}/admin/shares", s.handleCreateShare)
+	mux.HandleFunc("DELETE /{cenvID}/admin/shares/{shareID}", s.handleRevokeShare)
+	mux.HandleFunc("GET /{cenvID}/admin/remotes", s.handleListRemotes)
+	mux.HandleFunc("POST /{cenvID}/admin/remotes", s.handleAddRemote)
+	mux.HandleFunc("DELETE /{cenvID}/admin/remotes/{remoteID}", s.handleDeleteRemote)
+
+	// Secrets (write-only values used server-side)
+	mux.HandleFunc("GET /{cenvID}/admin/secrets", s.handleListSecrets)
+	mux.HandleFunc("PUT /{cenvID}/admin/secrets/{name}", s.handleSetSecret)
+	mux.HandleFunc("DELETE /{cenvID}/admin/secrets/{name}", s.handleDeleteSecret)
+	mux.HandleFunc("GET /{cenvID}/admin/secrets/key", s.handleSecretKeyStatus)
+	mux.HandleFunc("POST /{cenvID}/admin/secrets/key/rotate", s.handleRotateSecretKey)
+
+	// Upstream proxy routes, served at /{cenvID}/ext/{name}/...
+	mux.HandleFunc("GET /{cenvID}/admin/proxy-routes", s.handleListProxyRoutes)
+	mux.HandleFunc("PUT /{cenvID}/admin/proxy-routes/{name}", s.handlePutProxyRoute)
+	mux.HandleFunc("DELETE /{cenvID}/admin/proxy-routes/{name}", s.handleDeleteProxyRoute)
+	mux.HandleFunc("/{cenvID}/ext/{name}/{path...}", s.handleProxy)
+
+	// Feature flags, read by scripts and templates
+	mux.HandleFunc("GET /{cenvID}/admin/flags", s.handleListFlags)
+	mux.HandleFunc("PUT /{cenvID}/admin/flags/{name}", s.handlePutFlag)
+	mux.HandleFunc("DELETE /{cenvID}/admin/flags/{name}", s.handleDeleteFlag)
+	mux.HandleFunc("PUT /{cenvID}/admin/flags/{name}/overrides/{userID}", s.handleSetFlagOverride)
+	mux.HandleFunc("DELETE /{cenvID}/admin/flags/{name}/overrides/{userID}", s.handleDeleteFlagOverride)
+	mux.HandleFunc("GET /{cenvID}/flags", s.handleMyFlags) // Flags on for the caller
+
+	// Hook scripts run after document writes
+	mux.HandleFunc("GET /{cenvID}/admin/hooks", s.handleListHooks)
+	mux.HandleFunc("GET /{cenvID}/admin/hooks/{name}", s.handleGetHook)
+	mux.HandleFunc("PUT /{cenvID}/admin/hooks/{name}", s.handlePutHook)
+	mux.HandleFunc("DELETE /{cenvID}/admin/hooks/{name}", s.handleDeleteHook)
+
+	// Cenv configuration
+	mux.HandleFunc("GET /{cenvID}/admin/config", s.handleListConfig)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", s.handleSetConfig)
+
+	// Audit trail of signed admin mutations
+	mux.HandleFunc("GET /{cenvID}/admin/audit", s.handleListAudit)
+	mux.HandleFunc("GET /{cenvID}/admin/compliance/export", s.handleExportCompliance)
+
+	// Data retention: the policy, erasure reports and purging on demand
+	mux.HandleFunc("GET /{cenvID}/admin/retention", s.handleGetRetention)
+	mux.HandleFunc("POST /{cenvID}/admin/retention/purge", s.handlePurgeRetention)
+
+	// Alerts raised for failed login bursts, new countries and escalations
+	mux.HandleFunc("GET /{cenvID}/admin/alerts", s.handleListAlerts)
+
+	// Query plans and index suggestions from the slow query log
+	mux.HandleFunc("POST /{cenvID}/admin/sql/explain", s.handleExplainSQL)
+	mux.HandleFunc("GET /{cenvID}/admin/sql/advisor", s.handleIndexAdvisor)
+	mux.HandleFunc("DELETE /{cenvID}/admin/sql/slow-queries", s.handleClearSlowQueries)
+
+	// Space usage by table and largest documents
+	mux.HandleFunc("GET /{cenvID}/admin/storage", s.handleStorageReport)
+	mux.HandleFunc("GET /{cenvID}/admin/duplicates", s.handleDuplicateReport)
+	mux.HandleFunc("GET /{cenvID}/usage", s.handleStorageUsage) // Storage used against the size limit and quota
+
+	// Document API endpoints
+	// Note: Order matters - more specific routes must come first
+	// The {docID...} pattern captures paths with slashes (e.g., "pages/home", "api/users/list")
+	// Revisions are addressed under the document path: {docID}/versions[/{n}[/restore]]
+	// Differences between revisions are read from {docID}/diff?from=&to=
+	// The link graph is read from {docID}/links and {docID}/backlinks
+	// A document's tag list is replaced with PUT {docID}/tags
+	mux.HandleFunc("GET /{cenvID}/documents/search", s.handleSearchDocuments)
+	mux.HandleFunc("POST /{cenvID}/admin/search/reindex", s.handleReindexSearch) // Rebuild with the search_tokenizer config
+	mux.HandleFunc("GET /{cenvID}/documents/changes", s.handleDocumentChanges)   // Change feed, ?since=&limit=
+	mux.HandleFunc("POST /{cenvID}/documents", s.handleCreateDocument)
+	mux.HandleFunc("POST /{cenvID}/documents/reorder", s.handleReorderDocuments) // Position order under a prefix, {"prefix", "ids"}
+	mux.HandleFunc("POST /{cenvID}/documents/import", s.handleImportDocuments)   // Multipart ZIP upload, ?prefix=&overwrite=&dry_run=
+	mux.HandleFunc("GET /{cenvID}/documents/export", s.handleExportDocuments)    // ZIP with manifest, ?prefix=&format=tar
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", s.handleGetDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", s.handleUpdateDocument)
+	mux.HandleFunc("DELETE /{cenvID}/documents/{docID...}", s.handleDeleteDocument)
+	mux.HandleFunc("POST /{cenvID}/documents/{docID...}", s.handleDocumentAction) // {docID}/versions/{n}/restore, {docID}/move, {docID}/copy, {docID}/pin, {docID}/unpin
+	mux.HandleFunc("GET /{cenvID}/documents", s.handleListDocuments)              // ?prefix=&sort= flat, or ?mode=tree&path= folder children
+	mux.HandleFunc("GET /{cenvID}/tags", s.handleListTags)
+	mux.HandleFunc("POST /{cenvID}/tags/{tag}/rename", s.handleRenameTag)
+	mux.HandleFunc("DELETE /{cenvID}/tags/{tag}", s.handleDeleteTag)
+	mux.HandleFunc("GET /{cenvID}/collections", s.handleListCollections)
+	mux.HandleFunc("GET /{cenvID}/collections/{name}", s.handleRunCollection) // Saved search results, ?limit=&cursor=
+	mux.HandleFunc("PUT /{cenvID}/collections/{name}", s.handlePutCollection)
+	mux.HandleFunc("DELETE /{cenvID}/collections/{name}", s.handleDeleteCollection)
+	mux.HandleFunc("GET /{cenvID}/collections/{name}/items", s.handleListCollectionItems) // Typed collection items, ?limit=&cursor=
+	mux.HandleFunc("POST /{cenvID}/collections/{name}/items", s.handleCreateCollectionItem)
+	mux.HandleFunc("GET /{cenvID}/collections/{name}/items/{itemID}", s.handleGetCollectionItem)
+	mux.HandleFunc("PUT /{cenvID}/collections/{name}/items/{itemID}", s.handlePutCollectionItem)
+	mux.HandleFunc("DELETE /{cenvID}/collections/{name}/items/{itemID}", s.handleDeleteCollectionItem)
+
+	// Starlark endpoint management (admin only)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints", s.handleListEndpoints)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}", s.handleGetEndpoint)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", s.handleCreateEndpoint)
+	mux.HandleFunc("DELETE /{cenvID}/admin/endpoints/{endpointID}", s.handleDeleteEndpoint)
+	mux.HandleFunc("PUT /{cenvID}/admin/endpoints/{endpointID}/mock", s.handleSetEndpointMock)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}/debug", s.handleDebugEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}/logs", s.handleListEndpointLogs)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints/{endpointID}/test", s.handleTestEndpoint)
+
+	// Endpoint change review (editors propose, admin/owner approve)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoint-changes", s.handleListEndpointChanges)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoint-changes", s.handleSubmitEndpointChange)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoint-changes/{changeID}", s.handleGetEndpointChange)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoint-changes/{changeID}", s.handleReviewEndpointChange)
+
+	// Captured failed requests and replay
+	mux.HandleFunc("GET /{cenvID}/admin/captures", s.handleListCaptures)
+	mux.HandleFunc("GET /{cenvID}/admin/captures/{captureID}", s.handleGetCapture)
+	mux.HandleFunc("DELETE /{cenvID}/admin/captures/{captureID}", s.handleDeleteCapture)
+	mux.HandleFunc("POST /{cenvID}/admin/captures/{captureID}/replay", s.handleReplayCapture)
+
+	// OpenAPI document for the cenv's Starlark endpoints
+	mux.HandleFunc("GET /{cenvID}/openapi.json", s.handleOpenAPI)
+
+	// Starlark endpoint execution (matches /star/* paths)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", s.handleExecuteStarlarkEndpoint)
+
+	// Template endpoints
+	mux.HandleFunc("GET /{cenvID}/templates", s.handleListTemplates)
+	mux.HandleFunc("POST /{cenvID}/templates/preview", s.handlePreviewTemplate)
+	mux.HandleFunc("GET /{cenvID}/pages/{path...}", s.handleRenderPage)
+
+	// Static assets: public, raw documents under assets/ with cache headers
+	mux.HandleFunc("GET /{cenvID}/assets/{path...}", s.handleServeAsset)
+
+	// Sitemap and feeds of the documents under feed_prefix, public
+	mux.HandleFunc("GET /{cenvID}/sitemap.xml", s.handleSitemap)
+	mux.HandleFunc("GET /{cenvID}/feed.xml", s.handleRSSFeed)
+	mux.HandleFunc("GET /{cenvID}/atom.xml", s.handleAtomFeed)
+
+	// Match both /{cenvID}/ and /{cenvID}/path/to/resource
+	mux.HandleFunc("/{cenvID}/{path...}", s.handleCenvRequest)
+
+	// Wrap with authentication of the request identity, token scope checks,
+	// compliance capture, lease coordination (multi-instance only), restoring
+	// archived cenvs, panic recovery and logging middleware
+	return loggingMiddleware(s.recoveryMiddleware(mux, s.wakeMiddleware(s.coordinationMiddleware(
+		s.complianceMiddleware(mux, s.identityMiddleware(s.scopeMiddleware(mux)))))))
+}
+
 // handleHealth handles the health check endpoint
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if s.draining.Load() {
+		// Load balancers stop routing here while in-flight requests finish
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining","service":"wce"}`))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"ok","service":"wce"}`))
 }
@@ -158,10 +443,15 @@ type NewCenvRequest struct {
 
 // NewCenvResponse represents the response for a new cenv creation
 type NewCenvResponse struct {
-	CenvID   string `json:"cenv_id"`
-	CenvURL  string `json:"cenv_url"`
-	Username string `json:"username"`
-	Message  string `json:"message"`
+	CenvID    string `json:"cenv_id"`
+	CenvURL   string `json:"cenv_url"`
+	Username  string `json:"username"`
+	Message   string `json:"message"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // When an ephemeral cenv is dropped
+
+	// Set when the owner must verify their email address before logging in
+	VerifyEmail bool `json:"verify_email,omitempty"`
 }
 
 // handleNewCenv handles requests to create a new cenv
@@ -212,6 +502,14 @@ func (s *Server) handleNewCenv(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.requireEmailVerification && !strings.Contains(req.Email, "@") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "email is required, and must be verified before logging in",
+		})
+		return
+	}
+
 	// Generate a new cenv ID
 	cenvID, err := auth.GenerateUUID()
 	if err != nil {
@@ -223,8 +521,41 @@ func (s *Server) handleNewCenv(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create the cenv database
-	if err := s.cenvManager.Create(cenvID); err != nil {
+	// Create the cenv database, in memory only with ?ephemeral=true
+	ephemeral := r.URL.Query().Get("ephemeral") == "true"
+	if ephemeral {
+		var ttl time.Duration
+		if raw := r.URL.Query().Get("ttl"); raw != "" {
+			ttl, err = time.ParseDuration(raw)
+			if err != nil || ttl <= 0 || ttl > cenv.MaxEphemeralTTL {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": fmt.Sprintf("ttl must be a duration up to %s, e.g. 30m", cenv.MaxEphemeralTTL),
+				})
+				return
+			}
+		}
+		if !s.ephemeral.Allow(auth.ClientPrefix(r.RemoteAddr)) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(ephemeralClientWindow/time.Second)))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("at most %d ephemeral cenvs may be created per %s", ephemeralPerClient, ephemeralClientWindow),
+			})
+			return
+		}
+		err = s.cenvManager.CreateEphemeral(cenvID, ttl)
+	} else {
+		err = s.cenvManager.Create(cenvID)
+	}
+	if errors.Is(err, cenv.ErrEphemeralLimit) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "too many ephemeral cenvs, try again later",
+		})
+		return
+	}
+	if err != nil {
 		log.Printf("Failed to create cenv %s: %v", cenvID, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -257,6 +588,19 @@ func (s *Server) handleNewCenv(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Created new cenv %s with owner %s (%s)", cenvID, user.Username, user.UserID)
 
+	// Confirm the owner's address when email is set up; with verification
+	// required, the owner cannot log in until they do
+	message := "Cenv created successfully. Please login to access your environment."
+	verifyEmail := false
+	if s.mailer != nil && user.Email != "" {
+		if err := s.sendEmailVerification(db, cenvID, user, s.requireEmailVerification); err != nil {
+			log.Printf("Failed to send email verification for cenv %s: %v", cenvID, err)
+		} else if s.requireEmailVerification {
+			verifyEmail = true
+			message = "Cenv created successfully. Verify your email address with the link sent to it, then login."
+		}
+	}
+
 	// Build the cenv URL
 	host := r.Host
 	if host == "" {
@@ -269,13 +613,19 @@ func (s *Server) handleNewCenv(w http.ResponseWriter, r *http.Request) {
 	cenvURL := fmt.Sprintf("%s://%s/%s/", scheme, host, cenvID)
 
 	// Return success response
+	resp := NewCenvResponse{
+		CenvID:      cenvID,
+		CenvURL:     cenvURL,
+		Username:    user.Username,
+		Message:     message,
+		VerifyEmail: verifyEmail,
+	}
+	if expiresAt, ok := s.cenvManager.EphemeralExpiry(cenvID); ok {
+		resp.Ephemeral = true
+		resp.ExpiresAt = expiresAt.Unix()
+	}
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(NewCenvResponse{
-		CenvID:   cenvID,
-		CenvURL:  cenvURL,
-		Username: user.Username,
-		Message:  "Cenv created successfully. Please login to access your environment.",
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 // LoginRequest represents the request body for login
@@ -291,6 +641,10 @@ type LoginResponse struct {
 	UserID    string `json:"user_id"`
 	Username  string `json:"username"`
 	Role      string `json:"role"`
+
+	// Exchanged for a new token with POST /{cenvID}/auth/refresh
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresAt int64  `json:"refresh_expires_at,omitempty"`
 }
 
 // handleLogin handles user login requests
@@ -350,7 +704,18 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	// Get user by username
 	user, err := auth.GetUserByUsername(db, req.Username)
+	if err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+		// A database failure is not a failed login attempt
+		log.Printf("Failed to look up user in cenv %s: %v", cenvID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to look up user",
+		})
+		return
+	}
 	if err != nil {
+		s.recordLogin(r, db, cenvID, req.Username, "", false)
+
 		// Don't reveal whether user exists or not
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -361,6 +726,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	// Check if user is enabled
 	if !user.Enabled {
+		s.recordLogin(r, db, cenvID, req.Username, user.UserID, false)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "account disabled",
@@ -370,6 +736,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	// Verify password
 	if err := auth.VerifyPassword(req.Password, user.PasswordHash); err != nil {
+		s.recordLogin(r, db, cenvID, req.Username, user.UserID, false)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "invalid credentials",
@@ -377,6 +744,14 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.awaitingEmailVerification(db, cenvID, user) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "email address not verified; use the link sent to it",
+		})
+		return
+	}
+
 	// Generate session ID
 	sessionID, err := auth.GenerateSessionID()
 	if err != nil {
@@ -418,6 +793,18 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	refreshToken, err := auth.IssueRefreshToken(db, tokenHash, auth.DefaultRefreshTimeout)
+	if err != nil {
+		log.Printf("Failed to issue refresh token: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to create session",
+		})
+		return
+	}
+
+	s.recordLogin(r, db, cenvID, req.Username, user.UserID, true)
+
 	// Update last login timestamp
 	if err := auth.UpdateLastLogin(db, user.UserID); err != nil {
 		log.Printf("Failed to update last login for user %s: %v", user.UserID, err)
@@ -427,14 +814,16 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	log.Printf("User %s logged in to cenv %s", user.Username, cenvID)
 
 	// Return success response with token
-	expiresAt := time.Now().Add(expiresIn).Unix()
+	expiresAt := clock.Now().Add(expiresIn).Unix()
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		UserID:    user.UserID,
-		Username:  user.Username,
-		Role:      user.Role,
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		UserID:           user.UserID,
+		Username:         user.Username,
+		Role:             user.Role,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: clock.Now().Add(auth.DefaultRefreshTimeout).Unix(),
 	})
 }
 
@@ -461,8 +850,7 @@ func (s *Server) handleCenvRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get database connection
-	db, err := s.cenvManager.GetConnection(cenvID)
-	if err != nil {
+	if _, err := s.cenvManager.GetConnection(cenvID); err != nil {
 		log.Printf("Failed to connect to cenv %s: %v", cenvID, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -471,63 +859,18 @@ func (s *Server) handleCenvRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "missing authorization header",
-		})
-		return
-	}
-
-	// Check for Bearer token format
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "invalid authorization header format",
-		})
-		return
-	}
-
-	token := parts[1]
-
-	// Validate token
-	claims, err := s.jwtManager.ValidateToken(token)
+	identity, err := s.identify(r, cenvID)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "invalid token",
-		})
-		return
-	}
-
-	// Verify token is for this cenv
-	if claims.CenvID != cenvID {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "token not valid for this cenv",
-		})
-		return
-	}
-
-	// Check if session is still valid (not revoked)
-	tokenHash := auth.GetTokenHash(token)
-	valid, err := auth.IsSessionValid(db, tokenHash)
-	if err != nil {
-		log.Printf("Failed to validate session: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "failed to validate session",
-		})
-		return
-	}
-
-	if !valid {
-		w.WriteHeader(http.StatusUnauthorized)
+		message := err.Error()
+		if errors.Is(err, errSessionCheck) {
+			log.Printf("Failed to validate session: %v", err)
+			message = errSessionCheck.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "session expired or revoked",
+			"error": message,
 		})
 		return
 	}
@@ -546,9 +889,10 @@ func (s *Server) handleCenvRequest(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"cenv_id":  cenvID,
 		"path":     remainingPath,
-		"user_id":  claims.UserID,
-		"username": claims.Username,
-		"role":     claims.Role,
+		"user_id":  identity.UserID,
+		"username": identity.Username,
+		"role":     identity.Role,
+		"identity": identity,
 		"message":  "Authenticated request - handler to be implemented",
 	})
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"testing"
 	"time"
@@ -669,6 +670,22 @@ func testDocumentCRUD(t *testing.T, baseURL string) {
 		t.Error("Expected to find 'test/searchable' in search results for 'xylophone'")
 	}
 
+	// FTS syntax characters in user input are searched literally
+	for query, status := range map[string]int{`keyword: "xylophone`: http.StatusOK, "NOT xylophone": http.StatusBadRequest} {
+		req, _ = http.NewRequest("GET", fmt.Sprintf("%s/%s/documents/search?q=%s", baseURL, cenvID, url.QueryEscape(query)), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to search documents: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Errorf("Expected status %d for search %q, got %d", status, query, resp.StatusCode)
+		}
+	}
+
 	t.Log("Document search (FTS5) successful")
 
 	// Step 7: Delete the document
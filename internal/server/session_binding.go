@@ -0,0 +1,69 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/config"
+)
+
+// sessionValid reports whether the session behind token is live. When the
+// cenv sets 'bind_sessions_to_client', the request must also come from the
+// client the session was created by (see auth.ClientFingerprint). A session
+// used from anywhere else is revoked and the attempt is audited. Sessions
+// created before fingerprinting are not bound.
+func (s *Server) sessionValid(r *http.Request, db *sql.DB, claims *auth.Claims, token string) (bool, error) {
+	tokenHash := auth.GetTokenHash(token)
+	valid, err := auth.IsSessionValid(db, tokenHash)
+	if err != nil || !valid {
+		return false, err
+	}
+	if err := auth.TouchSession(db, tokenHash); err != nil {
+		log.Printf("Failed to record use of session for user %s: %v", claims.UserID, err)
+	}
+
+	if !config.GetBool(db, "bind_sessions_to_client", false) {
+		return true, nil
+	}
+
+	recorded, err := auth.GetSessionFingerprint(db, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	if recorded == "" || recorded == auth.ClientFingerprint(r.RemoteAddr, r.UserAgent()) {
+		return true, nil
+	}
+
+	revokeMismatchedSession(r, db, tokenHash, claims.UserID, claims.Username, claims.SessionID)
+	return false, nil
+}
+
+// revokeMismatchedSession revokes a session used from a client other than
+// the one it is bound to and audits the attempt
+func revokeMismatchedSession(r *http.Request, db *sql.DB, tokenHash, userID, username, sessionID string) {
+	if err := auth.RevokeSession(db, tokenHash); err != nil {
+		log.Printf("Failed to revoke mismatched session for user %s: %v", userID, err)
+	}
+
+	details, _ := json.Marshal(map[string]string{
+		"method": r.Method,
+		"uri":    r.URL.RequestURI(),
+		"reason": "client fingerprint mismatch",
+	})
+	if err := audit.Record(db, audit.Entry{
+		UserID:       userID,
+		Username:     username,
+		Action:       "session_binding_mismatch",
+		ResourceType: "session",
+		ResourceID:   sessionID,
+		Details:      details,
+		IPAddress:    r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to audit session binding mismatch: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestSessionBinding(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/documents", srv.handleListDocuments)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("GET /{cenvID}/admin/audit", srv.handleListAudit)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	// get sends a request from the given client; httptest requests default
+	// to 192.0.2.1 with no user agent, the same client setupTestCenv used
+	get := func(token, remoteAddr, userAgent string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/"+cenvID+"/documents", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if remoteAddr != "" {
+			req.RemoteAddr = remoteAddr
+		}
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Unbound by default: any client may use the token
+	if code := get(token, "203.0.113.9:4000", "curl/8.0"); code != http.StatusOK {
+		t.Fatalf("Expected unbound session to be accepted, got %d", code)
+	}
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/bind_sessions_to_client", token,
+		map[string]string{"value": "yes please"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid value to be rejected, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/bind_sessions_to_client", token,
+		map[string]string{"value": "true"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set config: %d %s", w.Code, w.Body.String())
+	}
+
+	// Same network prefix and user agent
+	if code := get(token, "192.0.2.77:5555", ""); code != http.StatusOK {
+		t.Errorf("Expected same-network request to be accepted, got %d", code)
+	}
+
+	// A different network revokes the session, even for the original client
+	if code := get(token, "203.0.113.9:4000", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected foreign request to be rejected, got %d", code)
+	}
+	if code := get(token, "", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected session to be revoked after mismatch, got %d", code)
+	}
+
+	// A different user agent on the same network is also a mismatch
+	token = loginAs(t, mux, cenvID, "admin", "adminpass123")
+	if code := get(token, "", "stolen-cookie-jar/1.0"); code != http.StatusUnauthorized {
+		t.Errorf("Expected user agent mismatch to be rejected, got %d", code)
+	}
+
+	token = loginAs(t, mux, cenvID, "admin", "adminpass123")
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/audit?action=session_binding_mismatch", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to list audit log: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Entries) != 2 {
+		t.Fatalf("Expected 2 audited mismatches, got %d", len(resp.Entries))
+	}
+	if resp.Entries[1].IPAddress != "203.0.113.9:4000" || resp.Entries[1].ResourceType != "session" {
+		t.Errorf("Unexpected audit entry: %+v", resp.Entries[1])
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// SessionInfo describes one of the caller's sessions: a login, a refreshed
+// login or an issued token
+type SessionInfo struct {
+	ID        string `json:"id"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	LastUsed  int64  `json:"last_used,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Current   bool   `json:"current"` // The session of the listing request
+}
+
+// bearerTokenHash returns the hash of the request's bearer token, which
+// identifies its session
+func bearerTokenHash(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return auth.GetTokenHash(token)
+}
+
+// handleLogout revokes the session of the request's token, along with its
+// refresh token
+// Route: POST /{cenvID}/logout
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := auth.RevokeSession(db, bearerTokenHash(r)); err != nil {
+		log.Printf("Failed to log out user %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to revoke session",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "logged out",
+	})
+}
+
+// handleListMySessions lists the caller's live sessions, most recently used
+// first, so a user can spot devices they no longer use
+// Route: GET /{cenvID}/me/sessions
+func (s *Server) handleListMySessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	sessions, err := auth.ListUserSessions(db, userID)
+	if err != nil {
+		log.Printf("Failed to list sessions of user %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to list sessions",
+		})
+		return
+	}
+
+	current := bearerTokenHash(r)
+	list := make([]SessionInfo, len(sessions))
+	for i, session := range sessions {
+		list[i] = SessionInfo{
+			ID:        session.SessionID,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			LastUsed:  session.LastUsed,
+			IPAddress: session.IPAddress,
+			UserAgent: session.UserAgent,
+			Current:   session.TokenHash == current,
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": list,
+		"count":    len(list),
+	})
+}
+
+// handleRevokeMySession revokes one of the caller's sessions, signing out
+// the device that holds it
+// Route: DELETE /{cenvID}/me/sessions/{id}
+func (s *Server) handleRevokeMySession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, _, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := auth.RevokeUserSession(db, userID, r.PathValue("id")); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "session revoked",
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestSessionSelfManagement(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	defer manager.CloseAll()
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/auth/refresh", srv.handleRefreshToken)
+	mux.HandleFunc("POST /{cenvID}/logout", srv.handleLogout)
+	mux.HandleFunc("GET /{cenvID}/me/sessions", srv.handleListMySessions)
+	mux.HandleFunc("DELETE /{cenvID}/me/sessions/{id}", srv.handleRevokeMySession)
+
+	cenvID, adminToken := setupTestCenv(t, mux)
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := auth.CreateUser(db, "viewer", "viewerpass123", auth.RoleViewer, "", ""); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	laptop := loginAs(t, mux, cenvID, "viewer", "viewerpass123")
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{"username": "viewer", "password": "viewerpass123"})
+	var phone LoginResponse
+	json.NewDecoder(w.Body).Decode(&phone)
+
+	listSessions := func(token string) []SessionInfo {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to list sessions: %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Sessions []SessionInfo `json:"sessions"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Sessions
+	}
+
+	// Only the caller's own sessions are listed, the current one marked
+	sessions := listSessions(laptop)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", sessions)
+	}
+	var current, other SessionInfo
+	for _, session := range sessions {
+		if session.Current {
+			current = session
+		} else {
+			other = session
+		}
+		if session.IPAddress == "" || session.LastUsed == 0 {
+			t.Errorf("Expected client details, got %+v", session)
+		}
+	}
+	if current.ID == "" || other.ID == "" {
+		t.Fatalf("Expected one current session, got %+v", sessions)
+	}
+
+	// Other users' sessions cannot be revoked
+	adminSessions := listSessions(adminToken)
+	if len(adminSessions) != 1 {
+		t.Fatalf("Expected the admin's own session, got %+v", adminSessions)
+	}
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/me/sessions/"+adminSessions[0].ID, laptop, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected another user's session to be not found, got %d", w.Code)
+	}
+
+	// Revoking the phone's session signs it out, refresh token included
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/me/sessions/"+other.ID, laptop, nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to revoke session: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", phone.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked token to be refused, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/auth/refresh", "", map[string]string{"refresh_token": phone.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked refresh token to be refused, got %d", w.Code)
+	}
+	if sessions := listSessions(laptop); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("Expected only the current session left, got %+v", sessions)
+	}
+
+	// Logging out ends the current session
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/logout", laptop, nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to log out: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/me/sessions", laptop, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the token to stop working after logout, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/logout", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected logout without a token to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/share"
+)
+
+// ShareRequest grants another cenv read access to a table or document prefix
+type ShareRequest struct {
+	GranteeCenv string `json:"grantee_cenv"`
+	ScopeType   string `json:"scope_type"`
+	Scope       string `json:"scope"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+}
+
+// RemoteTokenRequest stores a token another cenv issued to this one
+type RemoteTokenRequest struct {
+	SourceCenv string `json:"source_cenv"`
+	Token      string `json:"token"`
+}
+
+// remoteAccess serves a cenv's remote module by presenting the tokens it
+// holds to the source cenv
+type remoteAccess struct {
+	manager *cenv.Manager
+	cenvID  string
+	db      *sql.DB
+}
+
+// newRemoteAccess creates the remote module backend for a cenv's scripts
+func (s *Server) newRemoteAccess(cenvID string, db *sql.DB) *remoteAccess {
+	return &remoteAccess{manager: s.cenvManager, cenvID: cenvID, db: db}
+}
+
+// open resolves the access this cenv holds in a source cenv
+func (ra *remoteAccess) open(sourceCenv string) (*sql.DB, *share.Access, error) {
+	if sourceCenv == ra.cenvID || !cenv.IsValidUUID(sourceCenv) || !ra.manager.Exists(sourceCenv) {
+		return nil, nil, fmt.Errorf("no shares from cenv %s", sourceCenv)
+	}
+
+	tokens, err := share.Tokens(ra.db, sourceCenv)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("no shares from cenv %s", sourceCenv)
+	}
+
+	sourceDB, err := ra.manager.GetConnection(sourceCenv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open cenv %s", sourceCenv)
+	}
+
+	access, err := share.Authenticate(sourceDB, ra.cenvID, tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	if access.Empty() {
+		return nil, nil, fmt.Errorf("no shares from cenv %s", sourceCenv)
+	}
+
+	return sourceDB, access, nil
+}
+
+// Query runs a read-only query against shared tables in a source cenv
+func (ra *remoteAccess) Query(ctx context.Context, sourceCenv, sql string, params []interface{}) ([]string, [][]interface{}, error) {
+	sourceDB, access, err := ra.open(sourceCenv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return share.Query(ctx, sourceDB, access, sql, params)
+}
+
+// Document reads a shared document from a source cenv
+func (ra *remoteAccess) Document(ctx context.Context, sourceCenv, id string) (map[string]interface{}, error) {
+	sourceDB, access, err := ra.open(sourceCenv)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := share.GetDocument(sourceDB, access, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":           doc.ID,
+		"content":      doc.Content,
+		"content_type": doc.ContentType,
+		"is_binary":    doc.IsBinary,
+		"version":      doc.Version,
+		"modified_at":  doc.ModifiedAt,
+	}, nil
+}
+
+// handleListShares lists the shares this cenv has granted (admin/owner only)
+func (s *Server) handleListShares(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view shares")
+	if err != nil {
+		return // Response already sent
+	}
+
+	shares, err := share.ListShares(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"shares": shares,
+		"count":  len(shares),
+	})
+}
+
+// handleCreateShare grants another cenv read access. The token is only
+// returned here; the grantee's admin registers it under /admin/remotes.
+func (s *Server) handleCreateShare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "create shares")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "create_share", "share", ""); err != nil {
+		return // Response already sent
+	}
+
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if req.GranteeCenv == cenvID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "cannot share with the same cenv",
+		})
+		return
+	}
+
+	created, token, err := share.CreateShare(db, req.GranteeCenv, req.ScopeType, req.Scope, userID, req.ExpiresAt)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Share %d of %s %s granted to cenv %s by %s", created.ID, created.ScopeType, created.Scope, created.GranteeCenv, userID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"share": created,
+		"token": token,
+	})
+}
+
+// handleRevokeShare revokes a share (admin/owner only)
+func (s *Server) handleRevokeShare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "revoke shares")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "revoke_share", "share", r.PathValue("shareID")); err != nil {
+		return // Response already sent
+	}
+
+	shareID, err := strconv.ParseInt(r.PathValue("shareID"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid share id",
+		})
+		return
+	}
+
+	if err := share.RevokeShare(db, shareID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Share %d revoked by %s", shareID, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "share revoked successfully",
+	})
+}
+
+// handleListRemotes lists the share tokens this cenv holds (admin/owner only)
+func (s *Server) handleListRemotes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view remote tokens")
+	if err != nil {
+		return // Response already sent
+	}
+
+	remotes, err := share.ListRemoteTokens(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"remotes": remotes,
+		"count":   len(remotes),
+	})
+}
+
+// handleAddRemote stores a share token issued by another cenv (admin/owner only)
+func (s *Server) handleAddRemote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "add remote tokens")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "add_remote", "remote", ""); err != nil {
+		return // Response already sent
+	}
+
+	var req RemoteTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	remote, err := share.AddRemoteToken(db, req.SourceCenv, req.Token, userID)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Remote token for cenv %s added by %s", remote.SourceCenv, userID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(remote)
+}
+
+// handleDeleteRemote removes a stored share token (admin/owner only)
+func (s *Server) handleDeleteRemote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "delete remote tokens")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "delete_remote", "remote", r.PathValue("remoteID")); err != nil {
+		return // Response already sent
+	}
+
+	remoteID, err := strconv.ParseInt(r.PathValue("remoteID"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid remote id",
+		})
+		return
+	}
+
+	if err := share.DeleteRemoteToken(db, remoteID); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Remote token %d deleted by %s", remoteID, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "remote token deleted successfully",
+	})
+}
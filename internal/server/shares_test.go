@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestCrossCenvSharing(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("GET /{cenvID}/admin/shares", srv.handleListShares)
+	mux.HandleFunc("POST /{cenvID}/admin/shares", srv.handleCreateShare)
+	mux.HandleFunc("DELETE /{cenvID}/admin/shares/{shareID}", srv.handleRevokeShare)
+	mux.HandleFunc("GET /{cenvID}/admin/remotes", srv.handleListRemotes)
+	mux.HandleFunc("POST /{cenvID}/admin/remotes", srv.handleAddRemote)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	sourceID, sourceToken := setupTestCenv(t, mux)
+	granteeID, granteeToken := setupTestCenv(t, mux)
+
+	sourceDB, err := manager.GetConnection(sourceID)
+	if err != nil {
+		t.Fatalf("Failed to open cenv: %v", err)
+	}
+	sourceDB.Exec(`CREATE TABLE stock (sku TEXT PRIMARY KEY, qty INTEGER)`)
+	sourceDB.Exec(`CREATE TABLE payroll (name TEXT, salary INTEGER)`)
+	sourceDB.Exec(`INSERT INTO stock VALUES ('A-1', 3)`)
+
+	script := fmt.Sprintf("def handle_request(req):\n"+
+		"    return response(remote.query(%q, req.query[\"sql\"]))", sourceID)
+	if w := doJSON(t, mux, "POST", "/"+granteeID+"/admin/endpoints", granteeToken, map[string]interface{}{
+		"path": "/stock", "method": "GET", "script": script,
+	}); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+	}
+
+	query := func(sql string) int {
+		target := "/" + granteeID + "/star/stock?sql=" + strings.ReplaceAll(sql, " ", "+")
+		return doJSON(t, mux, "GET", target, granteeToken, nil).Code
+	}
+
+	t.Run("NoShareYet", func(t *testing.T) {
+		if code := query("SELECT * FROM stock"); code != http.StatusInternalServerError {
+			t.Errorf("Expected query to fail without a share, got %d", code)
+		}
+	})
+
+	if w := doJSON(t, mux, "POST", "/"+sourceID+"/admin/shares", sourceToken, map[string]string{
+		"grantee_cenv": sourceID, "scope_type": "table", "scope": "stock",
+	}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected self-share to be rejected, got %d", w.Code)
+	}
+
+	w := doJSON(t, mux, "POST", "/"+sourceID+"/admin/shares", sourceToken, map[string]string{
+		"grantee_cenv": granteeID, "scope_type": "table", "scope": "stock",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create share: %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Share struct {
+			ID int64 `json:"id"`
+		} `json:"share"`
+		Token string `json:"token"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+
+	if w := doJSON(t, mux, "POST", "/"+granteeID+"/admin/remotes", granteeToken, map[string]string{
+		"source_cenv": sourceID, "token": created.Token,
+	}); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to add remote: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+granteeID+"/admin/remotes", granteeToken, nil)
+	if strings.Contains(w.Body.String(), created.Token) {
+		t.Error("Remote listing must not expose the token")
+	}
+
+	t.Run("SharedTable", func(t *testing.T) {
+		if !sqlite.AuthorizerSupported {
+			t.Skip("the SQLite driver built in does not support authorizers")
+		}
+		w := doJSON(t, mux, "GET", "/"+granteeID+"/star/stock?sql=SELECT+sku,qty+FROM+stock", granteeToken, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected shared query to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+		var rows []map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&rows)
+		if len(rows) != 1 || rows[0]["sku"] != "A-1" {
+			t.Errorf("Unexpected rows: %v", rows)
+		}
+	})
+
+	t.Run("UnsharedTable", func(t *testing.T) {
+		if code := query("SELECT * FROM payroll"); code != http.StatusInternalServerError {
+			t.Errorf("Expected unshared table to be refused, got %d", code)
+		}
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		if w := doJSON(t, mux, "DELETE", fmt.Sprintf("/%s/admin/shares/%d", sourceID, created.Share.ID), sourceToken, nil); w.Code != http.StatusOK {
+			t.Fatalf("Failed to revoke share: %d %s", w.Code, w.Body.String())
+		}
+		if code := query("SELECT * FROM stock"); code != http.StatusInternalServerError {
+			t.Errorf("Expected revoked share to be refused, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/audit"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/clock"
+)
+
+func TestSignedAdminRequests(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/policies", srv.handleCreatePolicy)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("GET /{cenvID}/admin/audit", srv.handleListAudit)
+
+	cenvID, token := setupTestCenv(t, mux)
+	path := "/" + cenvID + "/admin/config/slow_query_ms"
+	body := []byte(`{"value":"150"}`)
+
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("PUT", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	req := newRequest()
+	req.Header.Set("Authorization", "Bearer "+token)
+	if w := send(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unsigned request, got %d", w.Code)
+	}
+
+	req = newRequest()
+	signRequest(req, token, []byte(`{"value":"0"}`))
+	if w := send(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when the body differs from the signed one, got %d", w.Code)
+	}
+
+	req = newRequest()
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(headerTimestamp, stale)
+	req.Header.Set(headerNonce, "0123456789abcdef")
+	req.Header.Set(headerSignature, requestSignature(token, "PUT", path, stale, "0123456789abcdef", body))
+	if w := send(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for stale timestamp, got %d", w.Code)
+	}
+
+	req = newRequest()
+	signRequest(req, token, body)
+	signed := req.Header.Clone()
+	if w := send(req); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for signed request, got %d: %s", w.Code, w.Body.String())
+	}
+
+	replay := newRequest()
+	replay.Header = signed
+	if w := send(replay); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for replayed request, got %d", w.Code)
+	}
+
+	// Every attempt is audited with the full request
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/audit?action=set_config", token, nil)
+	var resp struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Entries) != 5 {
+		t.Fatalf("Expected 5 audited attempts, got %d", len(resp.Entries))
+	}
+	var accepted struct {
+		Accepted bool            `json:"accepted"`
+		Body     json.RawMessage `json:"body"`
+		Nonce    string          `json:"nonce"`
+	}
+	json.Unmarshal(resp.Entries[1].Details, &accepted)
+	if !accepted.Accepted || string(accepted.Body) != string(body) || accepted.Nonce == "" {
+		t.Errorf("Unexpected audit details for accepted request: %s", resp.Entries[1].Details)
+	}
+	if resp.Entries[1].ResourceID != "slow_query_ms" || resp.Entries[1].Username != "admin" {
+		t.Errorf("Unexpected audit entry: %+v", resp.Entries[1])
+	}
+	var rejected struct {
+		Reason string `json:"reason"`
+	}
+	json.Unmarshal(resp.Entries[0].Details, &rejected)
+	if rejected.Reason != "request nonce already used" {
+		t.Errorf("Expected replay rejection to be audited, got %s", resp.Entries[0].Details)
+	}
+
+	// Cenvs can opt out of mandatory signing; requests are still audited
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/require_signed_admin_requests", token, map[string]string{"value": "false"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	req = httptest.NewRequest("POST", "/"+cenvID+"/admin/policies", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if w := send(req); w.Code == http.StatusUnauthorized {
+		t.Errorf("Expected unsigned request to pass once signing is optional, got %d", w.Code)
+	}
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/audit?action=create_policy", token, nil)
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Entries) != 1 {
+		t.Errorf("Expected unsigned request to be audited, got %d entries", len(resp.Entries))
+	}
+}
+
+func TestUnsignedAdminMutationsRejected(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+
+	cenvID, token := setupTestCenv(t, mux)
+	tests := []struct {
+		pattern string
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"PUT /{cenvID}/admin/users/{username}", "/admin/users/bob", srv.handlePutUser},
+		{"DELETE /{cenvID}/admin/users/{username}", "/admin/users/bob", srv.handleDeleteUser},
+		{"POST /{cenvID}/admin/quarantine/{docID...}", "/admin/quarantine/doc", srv.handleReviewQuarantine},
+		{"PUT /{cenvID}/admin/mime-policies", "/admin/mime-policies", srv.handleSetMIMEPolicy},
+		{"DELETE /{cenvID}/admin/mime-policies", "/admin/mime-policies", srv.handleDeleteMIMEPolicy},
+		{"POST /{cenvID}/admin/seed", "/admin/seed", srv.handleApplySeed},
+		{"POST /{cenvID}/admin/shares", "/admin/shares", srv.handleCreateShare},
+		{"DELETE /{cenvID}/admin/shares/{shareID}", "/admin/shares/share", srv.handleRevokeShare},
+		{"POST /{cenvID}/admin/remotes", "/admin/remotes", srv.handleAddRemote},
+		{"DELETE /{cenvID}/admin/remotes/{remoteID}", "/admin/remotes/remote", srv.handleDeleteRemote},
+		{"PUT /{cenvID}/admin/secrets/{name}", "/admin/secrets/key", srv.handleSetSecret},
+		{"DELETE /{cenvID}/admin/secrets/{name}", "/admin/secrets/key", srv.handleDeleteSecret},
+		{"PUT /{cenvID}/admin/proxy-routes/{name}", "/admin/proxy-routes/api", srv.handlePutProxyRoute},
+		{"DELETE /{cenvID}/admin/proxy-routes/{name}", "/admin/proxy-routes/api", srv.handleDeleteProxyRoute},
+		{"PUT /{cenvID}/admin/flags/{name}", "/admin/flags/beta", srv.handlePutFlag},
+		{"DELETE /{cenvID}/admin/flags/{name}", "/admin/flags/beta", srv.handleDeleteFlag},
+		{"PUT /{cenvID}/admin/flags/{name}/overrides/{userID}", "/admin/flags/beta/overrides/user", srv.handleSetFlagOverride},
+		{"DELETE /{cenvID}/admin/flags/{name}/overrides/{userID}", "/admin/flags/beta/overrides/user", srv.handleDeleteFlagOverride},
+		{"DELETE /{cenvID}/admin/hooks/{name}", "/admin/hooks/hook", srv.handleDeleteHook},
+		{"DELETE /{cenvID}/admin/sql/slow-queries", "/admin/sql/slow-queries", srv.handleClearSlowQueries},
+		{"POST /{cenvID}/admin/search/reindex", "/admin/search/reindex", srv.handleReindexSearch},
+		{"DELETE /{cenvID}/admin/endpoints/{endpointID}", "/admin/endpoints/endpoint", srv.handleDeleteEndpoint},
+		{"PUT /{cenvID}/admin/endpoints/{endpointID}/mock", "/admin/endpoints/endpoint/mock", srv.handleSetEndpointMock},
+		{"POST /{cenvID}/admin/endpoints/{endpointID}/test", "/admin/endpoints/endpoint/test", srv.handleTestEndpoint},
+		{"DELETE /{cenvID}/admin/captures/{captureID}", "/admin/captures/capture", srv.handleDeleteCapture},
+		{"POST /{cenvID}/admin/captures/{captureID}/replay", "/admin/captures/capture/replay", srv.handleReplayCapture},
+	}
+	for _, tt := range tests {
+		mux.HandleFunc(tt.pattern, tt.handler)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			method, _, _ := strings.Cut(tt.pattern, " ")
+			req := httptest.NewRequest(method, "/"+cenvID+tt.path, bytes.NewReader([]byte(`{}`)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401 for unsigned request, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidTimestampUsesClock(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	defer clock.Set(clock.NewFake(now))()
+
+	if !validTimestamp(strconv.FormatInt(now.Unix(), 10)) {
+		t.Error("Expected the fake clock's time to be valid")
+	}
+	if validTimestamp(strconv.FormatInt(time.Now().Unix(), 10)) {
+		t.Error("Expected the wall clock's time to be outside the window")
+	}
+	if validTimestamp(strconv.FormatInt(now.Add(signatureWindow+time.Second).Unix(), 10)) {
+		t.Error("Expected a timestamp past the window to be refused")
+	}
+}
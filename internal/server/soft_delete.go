@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// handleSetSoftDelete enables or disables soft delete on a user table
+func (s *Server) handleSetSoftDelete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireTableManager(w, r, cenvID, table)
+	if err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if req.Enabled {
+		err = tables.EnableSoftDelete(db, table, userID)
+	} else {
+		err = tables.DisableSoftDelete(db, table)
+	}
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Soft delete on %s set to %t by %s", table, req.Enabled, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":       table,
+		"soft_delete": req.Enabled,
+	})
+}
+
+// handlePurgeDeleted permanently removes soft deleted rows from a user table
+func (s *Server) handlePurgeDeleted(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireTableManager(w, r, cenvID, table)
+	if err != nil {
+		return // Response already sent
+	}
+
+	// An empty body purges every soft deleted row
+	var req struct {
+		Before int64 `json:"before"` // Optional Unix timestamp cutoff
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "invalid request body",
+			})
+			return
+		}
+	}
+
+	purged, err := tables.PurgeDeleted(db, table, req.Before)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Purged %d deleted rows from %s by %s", purged, table, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":  table,
+		"purged": purged,
+	})
+}
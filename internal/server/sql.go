@@ -0,0 +1,199 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/sqlplan"
+)
+
+// maxSlowQueryEntries bounds the slow query log; the oldest entries are pruned first
+const maxSlowQueryEntries = 1000
+
+// maxAdvisedQueries bounds how many distinct statements the advisor explains
+const maxAdvisedQueries = 100
+
+// newSlowQueryRecorder returns a query hook that records Starlark database calls
+// slower than the 'slow_query_ms' config value, or nil when the log is disabled
+func newSlowQueryRecorder(db *sql.DB, endpointID int64) func(string, time.Duration) {
+	threshold := config.GetInt(db, "slow_query_ms", 100)
+	if threshold <= 0 {
+		return nil
+	}
+
+	return func(query string, elapsed time.Duration) {
+		if elapsed < time.Duration(threshold)*time.Millisecond {
+			return
+		}
+
+		_, err := db.Exec(`
+			INSERT INTO _wce_slow_queries (endpoint_id, sql, duration_ms, created_at)
+			VALUES (?, ?, ?, ?)
+		`, endpointID, query, float64(elapsed)/float64(time.Millisecond), time.Now().Unix())
+		if err != nil {
+			log.Printf("Failed to record slow query for endpoint %d: %v", endpointID, err)
+			return
+		}
+
+		_, err = db.Exec(`
+			DELETE FROM _wce_slow_queries
+			WHERE id <= (SELECT MAX(id) FROM _wce_slow_queries) - ?
+		`, maxSlowQueryEntries)
+		if err != nil {
+			log.Printf("Failed to prune slow query log: %v", err)
+		}
+	}
+}
+
+// handleExplainSQL returns SQLite's query plan for a statement without running it (admin/owner only)
+func (s *Server) handleExplainSQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "explain queries")
+	if err != nil {
+		return // Response already sent
+	}
+
+	var req struct {
+		SQL    string        `json:"sql"`
+		Params []interface{} `json:"params"` // Optional; missing parameters are NULL
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	plan, err := sqlplan.Explain(db, req.SQL, req.Params)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sql":  req.SQL,
+		"plan": plan,
+	})
+}
+
+// handleIndexAdvisor suggests indexes for user tables based on the slow query log (admin/owner only)
+func (s *Server) handleIndexAdvisor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view index suggestions")
+	if err != nil {
+		return // Response already sent
+	}
+
+	queries, err := listSlowQueries(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to read slow query log",
+		})
+		return
+	}
+
+	suggestions, err := sqlplan.Advise(db, queries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to analyze slow queries",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"suggestions": suggestions,
+		"queries":     queries,
+	})
+}
+
+// handleClearSlowQueries empties the slow query log, e.g. after adding indexes (admin/owner only)
+func (s *Server) handleClearSlowQueries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "clear the slow query log")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "clear_slow_queries", "slow_queries", ""); err != nil {
+		return // Response already sent
+	}
+
+	if _, err := db.Exec(`DELETE FROM _wce_slow_queries`); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to clear slow query log",
+		})
+		return
+	}
+
+	log.Printf("Slow query log for cenv %s cleared by %s", cenvID, userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "slow query log cleared",
+	})
+}
+
+// listSlowQueries aggregates the slow query log by statement, slowest in total first
+func listSlowQueries(db *sql.DB) ([]sqlplan.SlowQuery, error) {
+	rows, err := db.Query(`
+		SELECT sql, COUNT(*), AVG(duration_ms), MAX(duration_ms)
+		FROM _wce_slow_queries
+		GROUP BY sql
+		ORDER BY SUM(duration_ms) DESC
+		LIMIT ?
+	`, maxAdvisedQueries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queries := []sqlplan.SlowQuery{}
+	for rows.Next() {
+		var q sqlplan.SlowQuery
+		if err := rows.Scan(&q.SQL, &q.Calls, &q.AvgMS, &q.MaxMS); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+
+	return queries, rows.Err()
+}
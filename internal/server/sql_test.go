@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/sqlplan"
+)
+
+func TestExplainAndIndexAdvisor(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("POST /{cenvID}/admin/sql/explain", srv.handleExplainSQL)
+	mux.HandleFunc("GET /{cenvID}/admin/sql/advisor", srv.handleIndexAdvisor)
+	mux.HandleFunc("DELETE /{cenvID}/admin/sql/slow-queries", srv.handleClearSlowQueries)
+	mux.HandleFunc("/{cenvID}/star/{starPath...}", srv.handleExecuteStarlarkEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, total REAL)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	t.Run("Explain", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/sql/explain", token, map[string]interface{}{
+			"sql": "SELECT * FROM orders WHERE status = ?",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Explain failed: %d %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Plan []sqlplan.PlanStep `json:"plan"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Plan) != 1 || resp.Plan[0].Detail != "SCAN orders" {
+			t.Errorf("Unexpected plan: %+v", resp.Plan)
+		}
+	})
+
+	t.Run("ExplainInvalid", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/sql/explain", token, map[string]interface{}{
+			"sql": "SELECT 1; DROP TABLE orders",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for multiple statements, got %d", w.Code)
+		}
+	})
+
+	t.Run("Advisor", func(t *testing.T) {
+		for _, sqlText := range []string{
+			"SELECT * FROM orders WHERE status = ?",
+			"SELECT * FROM _wce_users WHERE role = 'admin'",
+		} {
+			if _, err := db.Exec(`INSERT INTO _wce_slow_queries (sql, duration_ms, created_at) VALUES (?, 250, 0)`, sqlText); err != nil {
+				t.Fatalf("Failed to seed slow query log: %v", err)
+			}
+		}
+
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/sql/advisor", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Advisor failed: %d %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Suggestions []sqlplan.Suggestion `json:"suggestions"`
+			Queries     []sqlplan.SlowQuery  `json:"queries"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+
+		if len(resp.Queries) != 2 {
+			t.Errorf("Expected 2 analyzed queries, got %d", len(resp.Queries))
+		}
+		if len(resp.Suggestions) != 1 || resp.Suggestions[0].Table != "orders" {
+			t.Fatalf("Expected one suggestion for orders, got %+v", resp.Suggestions)
+		}
+
+		w = doJSON(t, mux, "DELETE", "/"+cenvID+"/admin/sql/slow-queries", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Clear failed: %d %s", w.Code, w.Body.String())
+		}
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM _wce_slow_queries`).Scan(&count)
+		if count != 0 {
+			t.Errorf("Expected empty slow query log, got %d entries", count)
+		}
+	})
+
+	t.Run("RecordsEndpointQueries", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/slow_query_ms", token, map[string]string{"value": "1"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to set config: %d %s", w.Code, w.Body.String())
+		}
+
+		script := "def handle_request(req):\n" +
+			"    db.query(\"WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 300000) SELECT COUNT(*) AS c FROM n\")\n" +
+			"    db.query(\"SELECT 1\")\n" +
+			"    return response(\"ok\")"
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path": "/slow", "method": "GET", "script": script,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create endpoint: %d %s", w.Code, w.Body.String())
+		}
+
+		req := httptest.NewRequest("GET", "/"+cenvID+"/star/slow", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Endpoint failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		var slow, fast int
+		db.QueryRow(`SELECT COUNT(*) FROM _wce_slow_queries WHERE sql LIKE 'WITH RECURSIVE%' AND endpoint_id IS NOT NULL`).Scan(&slow)
+		db.QueryRow(`SELECT COUNT(*) FROM _wce_slow_queries WHERE sql = 'SELECT 1'`).Scan(&fast)
+		if slow != 1 || fast != 0 {
+			t.Errorf("Expected only the slow query recorded, got slow=%d fast=%d", slow, fast)
+		}
+	})
+
+	t.Run("EditorForbidden", func(t *testing.T) {
+		if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+			t.Fatalf("Failed to create editor: %v", err)
+		}
+		editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/sql/advisor", editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+	})
+}
@@ -3,11 +3,15 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
 	starlark_pkg "github.com/thetanil/wce/internal/starlark"
 )
 
@@ -23,6 +27,24 @@ type Endpoint struct {
 	ModifiedAt  int64  `json:"modified_at"`
 	CreatedBy   string `json:"created_by"`
 	ModifiedBy  string `json:"modified_by"`
+
+	// Optional JSON Schemas published in the cenv's OpenAPI document
+	RequestSchema  json.RawMessage `json:"request_schema,omitempty"`
+	ResponseSchema json.RawMessage `json:"response_schema,omitempty"`
+
+	// Mock response served instead of executing the script
+	Mock *EndpointMock `json:"mock,omitempty"`
+
+	// Builtins the script may use (see starlark.AllCapabilities)
+	Capabilities []string `json:"capabilities"`
+}
+
+// EndpointMock describes a canned response for an endpoint
+type EndpointMock struct {
+	Enabled     bool            `json:"enabled"`
+	Status      int             `json:"status"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
 }
 
 // handleExecuteStarlarkEndpoint executes a Starlark endpoint
@@ -52,7 +74,7 @@ func (s *Server) handleExecuteStarlarkEndpoint(w http.ResponseWriter, r *http.Re
 	}
 
 	// Find matching endpoint
-	endpoint, userID, err := s.findAndAuthenticateEndpoint(db, r, starPath)
+	endpoint, identity, err := s.findAndAuthenticateEndpoint(db, r, cenvID, starPath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
@@ -68,22 +90,61 @@ func (s *Server) handleExecuteStarlarkEndpoint(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Serve the canned response while the endpoint is in mock mode
+	if endpoint.Mock != nil && endpoint.Mock.Enabled {
+		writeMockResponse(w, endpoint.Mock)
+		return
+	}
+
+	// Keep a copy of the body in case the failure needs to be captured for replay
+	capture := captureEnabled(db)
+	var body []byte
+	if capture {
+		body = bufferRequestBody(r)
+	}
+
+	var userID string
+	if identity != nil {
+		userID = identity.UserID
+	}
+
+	// Correlate script log entries with this request
+	reqID := requestID(r)
+	w.Header().Set("X-Request-Id", reqID)
+	logger := newEndpointLogger(db, endpoint.ID, reqID)
+
 	// Execute the Starlark script
 	execCtx := &starlark_pkg.ExecutionContext{
-		DB:      db,
-		UserID:  userID,
-		Request: r,
-		Timeout: 5 * time.Second,
+		DB:           db,
+		UserID:       userID,
+		Identity:     identity,
+		Request:      r,
+		Timeout:      5 * time.Second,
+		Capabilities: endpoint.capabilitySet(),
+		Log:          logger,
+		OnQuery:      newSlowQueryRecorder(db, endpoint.ID),
+		Remote:       s.newRemoteAccess(cenvID, db),
+		HTTPClient:   s.proxyClient,
+		Mailer:       s.mailer,
 	}
 
 	result, err := starlark_pkg.Execute(r.Context(), endpoint.Script, execCtx)
 	if err != nil {
+		logger(starlark_pkg.LogEntry{Level: starlark_pkg.LogLevelError, Message: err.Error()})
+		if capture {
+			captureFailedRequest(db, endpoint, r, starPath, userID, body, err)
+		}
 		http.Error(w, fmt.Sprintf("Script execution error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	writeEndpointResponse(w, result.StatusCode, result.Headers, result.Body)
+}
+
+// writeEndpointResponse writes a Starlark endpoint response
+func writeEndpointResponse(w http.ResponseWriter, status int, headers map[string]string, body interface{}) {
 	// Set headers
-	for key, value := range result.Headers {
+	for key, value := range headers {
 		w.Header().Set(key, value)
 	}
 
@@ -93,51 +154,57 @@ func (s *Server) handleExecuteStarlarkEndpoint(w http.ResponseWriter, r *http.Re
 	}
 
 	// Write status code
-	w.WriteHeader(result.StatusCode)
+	w.WriteHeader(status)
 
 	// Write body
-	if result.Body != nil {
-		// If body is already a string, write it directly
-		if bodyStr, ok := result.Body.(string); ok {
+	if body != nil {
+		// Strings and bytes are written directly
+		if bodyStr, ok := body.(string); ok {
 			w.Write([]byte(bodyStr))
+		} else if bodyBytes, ok := body.([]byte); ok {
+			w.Write(bodyBytes)
 		} else {
 			// Otherwise, encode as JSON
-			json.NewEncoder(w).Encode(result.Body)
+			json.NewEncoder(w).Encode(body)
 		}
 	}
 }
 
-// findAndAuthenticateEndpoint finds a matching endpoint and authenticates the request
-func (s *Server) findAndAuthenticateEndpoint(db *sql.DB, r *http.Request, path string) (*Endpoint, string, error) {
-	// Try to authenticate the request
-	token := r.Header.Get("Authorization")
-	var userID string
+// writeMockResponse writes an endpoint's mock response
+func writeMockResponse(w http.ResponseWriter, mock *EndpointMock) {
+	var body interface{}
+	if len(mock.Body) > 0 {
+		json.Unmarshal(mock.Body, &body)
+	}
 
-	if token != "" {
-		// Remove "Bearer " prefix
-		if len(token) > 7 && token[:7] == "Bearer " {
-			token = token[7:]
-		}
+	headers := map[string]string{"X-WCE-Mock": "true"}
+	if mock.ContentType != "" {
+		headers["Content-Type"] = mock.ContentType
+	}
 
-		// Validate token
-		claims, err := s.jwtManager.ValidateToken(token)
-		if err == nil {
-			// Verify session is valid
-			valid, _ := auth.IsSessionValid(db, auth.GetTokenHash(token))
-			if valid {
-				userID = claims.UserID
-			}
-		}
+	status := mock.Status
+	if status == 0 {
+		status = http.StatusOK
 	}
 
-	// If authentication failed, userID will be empty (anonymous access)
-	// Some endpoints might allow anonymous access
+	writeEndpointResponse(w, status, headers, body)
+}
+
+// findAndAuthenticateEndpoint finds a matching endpoint and identifies the
+// request. Requests without a valid token run anonymously, with a nil
+// identity; some endpoints allow anonymous access.
+func (s *Server) findAndAuthenticateEndpoint(db *sql.DB, r *http.Request, cenvID, path string) (*Endpoint, *auth.Identity, error) {
+	identity, _ := s.identify(r, cenvID)
 
 	// Find matching endpoint
 	// First try exact match
 	var endpoint Endpoint
+	var mock EndpointMock
+	var mockBody, mockContentType sql.NullString
+	var capabilities string
 	err := db.QueryRow(`
-		SELECT id, path, method, script, description, enabled, created_at, modified_at, created_by, modified_by
+		SELECT id, path, method, script, description, enabled, created_at, modified_at, created_by, modified_by,
+		       mock_enabled, mock_status, mock_body, mock_content_type, capabilities
 		FROM _wce_endpoints
 		WHERE path = ? AND (method = ? OR method = '*') AND enabled = 1
 		ORDER BY method DESC
@@ -153,17 +220,48 @@ func (s *Server) findAndAuthenticateEndpoint(db *sql.DB, r *http.Request, path s
 		&endpoint.ModifiedAt,
 		&endpoint.CreatedBy,
 		&endpoint.ModifiedBy,
+		&mock.Enabled,
+		&mock.Status,
+		&mockBody,
+		&mockContentType,
+		&capabilities,
 	)
 
 	if err == sql.ErrNoRows {
-		return nil, userID, nil
+		return nil, identity, nil
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
 	}
 
+	if mock.Enabled {
+		mock.Body = json.RawMessage(mockBody.String)
+		mock.ContentType = mockContentType.String
+		endpoint.Mock = &mock
+	}
+	endpoint.Capabilities = decodeCapabilities(capabilities)
+
+	return &endpoint, identity, nil
+}
+
+// decodeCapabilities parses the stored capabilities column.
+// Invalid values grant nothing.
+func decodeCapabilities(data string) []string {
+	caps, err := starlark_pkg.DecodeCapabilities(data)
 	if err != nil {
-		return nil, "", fmt.Errorf("database error: %w", err)
+		return []string{}
 	}
+	return caps.Names()
+}
 
-	return &endpoint, userID, nil
+// capabilitySet returns the capabilities granted to the endpoint's script
+func (ep *Endpoint) capabilitySet() starlark_pkg.Capabilities {
+	caps, err := starlark_pkg.ParseCapabilities(ep.Capabilities)
+	if err != nil {
+		return starlark_pkg.Capabilities{}
+	}
+	return caps
 }
 
 // handleListEndpoints lists all Starlark endpoints
@@ -177,24 +275,7 @@ func (s *Server) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate request
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Authorization required", http.StatusUnauthorized)
-		return
-	}
-
-	if len(token) > 7 && token[:7] == "Bearer " {
-		token = token[7:]
-	}
-
-	claims, err := s.jwtManager.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	if claims.CenvID != cenvID {
-		http.Error(w, "Invalid token for this cenv", http.StatusForbidden)
+	if _, ok := s.requireIdentity(w, r, cenvID); !ok {
 		return
 	}
 
@@ -205,16 +286,9 @@ func (s *Server) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if session is valid
-	valid, err := auth.IsSessionValid(db, auth.GetTokenHash(token))
-	if err != nil || !valid {
-		http.Error(w, "Session expired", http.StatusUnauthorized)
-		return
-	}
-
 	// List endpoints
 	rows, err := db.Query(`
-		SELECT id, path, method, description, enabled, created_at, modified_at, created_by, modified_by
+		SELECT id, path, method, description, enabled, created_at, modified_at, created_by, modified_by, capabilities
 		FROM _wce_endpoints
 		ORDER BY path, method
 	`)
@@ -227,6 +301,7 @@ func (s *Server) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
 	endpoints := []Endpoint{}
 	for rows.Next() {
 		var ep Endpoint
+		var capabilities string
 		err := rows.Scan(
 			&ep.ID,
 			&ep.Path,
@@ -237,11 +312,13 @@ func (s *Server) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
 			&ep.ModifiedAt,
 			&ep.CreatedBy,
 			&ep.ModifiedBy,
+			&capabilities,
 		)
 		if err != nil {
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
+		ep.Capabilities = decodeCapabilities(capabilities)
 		// Don't include script content in list
 		endpoints = append(endpoints, ep)
 	}
@@ -262,24 +339,7 @@ func (s *Server) handleGetEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate request
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Authorization required", http.StatusUnauthorized)
-		return
-	}
-
-	if len(token) > 7 && token[:7] == "Bearer " {
-		token = token[7:]
-	}
-
-	claims, err := s.jwtManager.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	if claims.CenvID != cenvID {
-		http.Error(w, "Invalid token for this cenv", http.StatusForbidden)
+	if _, ok := s.requireIdentity(w, r, cenvID); !ok {
 		return
 	}
 
@@ -290,17 +350,15 @@ func (s *Server) handleGetEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if session is valid
-	valid, err := auth.IsSessionValid(db, auth.GetTokenHash(token))
-	if err != nil || !valid {
-		http.Error(w, "Session expired", http.StatusUnauthorized)
-		return
-	}
-
 	// Get endpoint
 	var ep Endpoint
+	var requestSchema, responseSchema sql.NullString
+	var mock EndpointMock
+	var mockBody, mockContentType sql.NullString
+	var capabilities string
 	err = db.QueryRow(`
-		SELECT id, path, method, script, description, enabled, created_at, modified_at, created_by, modified_by
+		SELECT id, path, method, script, description, enabled, created_at, modified_at, created_by, modified_by,
+		       request_schema, response_schema, mock_enabled, mock_status, mock_body, mock_content_type, capabilities
 		FROM _wce_endpoints
 		WHERE id = ?
 	`, endpointID).Scan(
@@ -314,6 +372,13 @@ func (s *Server) handleGetEndpoint(w http.ResponseWriter, r *http.Request) {
 		&ep.ModifiedAt,
 		&ep.CreatedBy,
 		&ep.ModifiedBy,
+		&requestSchema,
+		&responseSchema,
+		&mock.Enabled,
+		&mock.Status,
+		&mockBody,
+		&mockContentType,
+		&capabilities,
 	)
 
 	if err == sql.ErrNoRows {
@@ -326,6 +391,19 @@ func (s *Server) handleGetEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if requestSchema.Valid {
+		ep.RequestSchema = json.RawMessage(requestSchema.String)
+	}
+	if responseSchema.Valid {
+		ep.ResponseSchema = json.RawMessage(responseSchema.String)
+	}
+	if mockBody.Valid || mock.Enabled {
+		mock.Body = json.RawMessage(mockBody.String)
+		mock.ContentType = mockContentType.String
+		ep.Mock = &mock
+	}
+	ep.Capabilities = decodeCapabilities(capabilities)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ep)
 }
@@ -341,29 +419,13 @@ func (s *Server) handleCreateEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate request
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Authorization required", http.StatusUnauthorized)
-		return
-	}
-
-	if len(token) > 7 && token[:7] == "Bearer " {
-		token = token[7:]
-	}
-
-	claims, err := s.jwtManager.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	identity, ok := s.requireIdentity(w, r, cenvID)
+	if !ok {
 		return
 	}
 
-	if claims.CenvID != cenvID {
-		http.Error(w, "Invalid token for this cenv", http.StatusForbidden)
-		return
-	}
-
-	userID := claims.UserID
-	role := claims.Role
+	userID := identity.UserID
+	role := identity.Role
 
 	// Get database connection
 	db, err := s.cenvManager.GetConnection(cenvID)
@@ -372,17 +434,16 @@ func (s *Server) handleCreateEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if session is valid
-	valid, err := auth.IsSessionValid(db, auth.GetTokenHash(token))
-	if err != nil || !valid {
-		http.Error(w, "Session expired", http.StatusUnauthorized)
+	// Admin and owner can create endpoints; editors only when the cenv allows it,
+	// and their endpoints are capped to the capabilities granted to editors
+	isEditor := role == "editor" && config.GetBool(db, "allow_editor_endpoints", false)
+	if role != "admin" && role != "owner" && !isEditor {
+		http.Error(w, "Only admin or owner can create endpoints", http.StatusForbidden)
 		return
 	}
 
-	// Only admin and owner can create endpoints
-	if role != "admin" && role != "owner" {
-		http.Error(w, "Only admin or owner can create endpoints", http.StatusForbidden)
-		return
+	if err := s.verifyAdminRequest(w, r, db, userID, "deploy_endpoint", "endpoint", ""); err != nil {
+		return // Response already sent
 	}
 
 	// Parse request body
@@ -392,6 +453,12 @@ func (s *Server) handleCreateEndpoint(w http.ResponseWriter, r *http.Request) {
 		Script      string `json:"script"`
 		Description string `json:"description"`
 		Enabled     *bool  `json:"enabled"` // pointer to distinguish between false and not provided
+
+		RequestSchema  json.RawMessage `json:"request_schema"`
+		ResponseSchema json.RawMessage `json:"response_schema"`
+
+		// Granted builtins; omitted keeps the current grant (all for new endpoints)
+		Capabilities *[]string `json:"capabilities"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -405,6 +472,49 @@ func (s *Server) handleCreateEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Static checks: hard errors block saving, warnings are returned to the caller
+	lint := starlark_pkg.Lint(req.Script)
+	if !lint.OK() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "script failed static checks",
+			"errors":   lint.Errors,
+			"warnings": lint.Warnings,
+		})
+		return
+	}
+
+	// Schemas must be JSON objects when provided
+	requestSchema, err := schemaParam(req.RequestSchema)
+	if err != nil {
+		http.Error(w, "request_schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	responseSchema, err := schemaParam(req.ResponseSchema)
+	if err != nil {
+		http.Error(w, "response_schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Resolve granted capabilities
+	var capabilities starlark_pkg.Capabilities // nil = all
+	if req.Capabilities != nil {
+		capabilities, err = starlark_pkg.ParseCapabilities(*req.Capabilities)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if isEditor {
+		editorCaps, err := starlark_pkg.DecodeCapabilities(config.GetString(db, "editor_endpoint_capabilities", "[]"))
+		if err != nil {
+			editorCaps = starlark_pkg.Capabilities{}
+		}
+		capabilities = capabilities.Intersect(editorCaps)
+	}
+	keepCapabilities := req.Capabilities == nil && !isEditor
+
 	// Default enabled to true if not specified
 	enabled := true
 	if req.Enabled != nil {
@@ -412,29 +522,93 @@ func (s *Server) handleCreateEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert or update endpoint
+	err = saveEndpoint(db, &endpointWrite{
+		Path:             req.Path,
+		Method:           req.Method,
+		Script:           req.Script,
+		Description:      req.Description,
+		RequestSchema:    requestSchema,
+		ResponseSchema:   responseSchema,
+		Capabilities:     capabilities,
+		KeepCapabilities: keepCapabilities,
+		Enabled:          enabled,
+		UserID:           userID,
+	})
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The id is stable across updates by path and method, so clients can read
+	// the endpoint back with GET /admin/endpoints/{endpointID}
+	var endpointID int64
+	if err := db.QueryRow(`SELECT id FROM _wce_endpoints WHERE path = ? AND method = ?`, req.Path, req.Method).Scan(&endpointID); err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"message": "Endpoint created/updated successfully",
+		"id":      endpointID,
+		"path":    req.Path,
+		"method":  req.Method,
+	}
+	if req.Capabilities != nil || isEditor {
+		resp["capabilities"] = capabilities.Names()
+	}
+	if len(lint.Warnings) > 0 {
+		resp["warnings"] = lint.Warnings
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// endpointWrite holds the fields written when creating or updating an endpoint
+type endpointWrite struct {
+	Path           string
+	Method         string
+	Script         string
+	Description    string
+	RequestSchema  interface{} // JSON text or nil, see schemaParam
+	ResponseSchema interface{}
+
+	Capabilities     starlark_pkg.Capabilities // nil = all
+	KeepCapabilities bool                      // Leave an existing endpoint's grant unchanged
+
+	Enabled bool
+	UserID  string
+}
+
+// saveEndpoint inserts an endpoint or updates the one with the same path and
+// method, and records the documents its script renders
+func saveEndpoint(db document.DB, ew *endpointWrite) error {
 	now := time.Now().Unix()
-	_, err = db.Exec(`
-		INSERT INTO _wce_endpoints (path, method, script, description, enabled, created_at, modified_at, created_by, modified_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	_, err := db.Exec(`
+		INSERT INTO _wce_endpoints (path, method, script, description, request_schema, response_schema,
+			capabilities, enabled, created_at, modified_at, created_by, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(path, method) DO UPDATE SET
 			script = excluded.script,
 			description = excluded.description,
+			request_schema = excluded.request_schema,
+			response_schema = excluded.response_schema,
+			capabilities = CASE WHEN ? THEN capabilities ELSE excluded.capabilities END,
 			enabled = excluded.enabled,
 			modified_at = excluded.modified_at,
 			modified_by = excluded.modified_by
-	`, req.Path, req.Method, req.Script, req.Description, enabled, now, now, userID, userID)
-
+	`, ew.Path, ew.Method, ew.Script, ew.Description, ew.RequestSchema, ew.ResponseSchema,
+		ew.Capabilities.Encode(), ew.Enabled, now, now, ew.UserID, ew.UserID, ew.KeepCapabilities)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Endpoint created/updated successfully",
-		"path":    req.Path,
-		"method":  req.Method,
-	})
+	var id int64
+	if err := db.QueryRow(`SELECT id FROM _wce_endpoints WHERE path = ? AND method = ?`, ew.Path, ew.Method).Scan(&id); err != nil {
+		return err
+	}
+	return setEndpointReferences(db, id, ew.Script)
 }
 
 // handleDeleteEndpoint deletes a Starlark endpoint
@@ -460,6 +634,11 @@ func (s *Server) handleDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	identity, _ := s.identify(r, cenvID)
+	if err := s.verifyAdminRequest(w, r, db, identity.UserID, "delete_endpoint", "endpoint", endpointID); err != nil {
+		return // Response already sent
+	}
+
 	// Delete endpoint
 	result, err := db.Exec(`DELETE FROM _wce_endpoints WHERE id = ?`, endpointID)
 	if err != nil {
@@ -479,24 +658,40 @@ func (s *Server) handleDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// authenticateAndAuthorize is a helper to authenticate and check role
-func (s *Server) authenticateAndAuthorize(r *http.Request, cenvID string, allowedRoles []string) (*sql.DB, string, error) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		return nil, "", fmt.Errorf("authorization required")
+// requireIdentity identifies a request for the endpoint management
+// handlers, writing their plain-text error response when that fails
+func (s *Server) requireIdentity(w http.ResponseWriter, r *http.Request, cenvID string) (*auth.Identity, bool) {
+	identity, err := s.identify(r, cenvID)
+	if err != nil {
+		message, status := endpointAuthError(err)
+		http.Error(w, message, status)
+		return nil, false
 	}
+	return identity, true
+}
 
-	if len(token) > 7 && token[:7] == "Bearer " {
-		token = token[7:]
-	}
+// endpointAuthError returns the message and status the endpoint management
+// handlers answer a failed identification with
+func endpointAuthError(err error) (string, int) {
+	switch {
+	case errors.Is(err, errMissingAuthorization):
+		return "Authorization required", http.StatusUnauthorized
+	case errors.Is(err, errWrongCenv):
+		return "Invalid token for this cenv", http.StatusForbidden
+	case errors.Is(err, errSessionInvalid):
+		return "Session expired", http.StatusUnauthorized
+	case errors.Is(err, errSessionCheck):
+		return "Database error", http.StatusInternalServerError
+	}
+	return "Invalid token", http.StatusUnauthorized
+}
 
-	claims, err := s.jwtManager.ValidateToken(token)
+// authenticateAndAuthorize is a helper to authenticate and check role
+func (s *Server) authenticateAndAuthorize(r *http.Request, cenvID string, allowedRoles []string) (*sql.DB, string, error) {
+	identity, err := s.identify(r, cenvID)
 	if err != nil {
-		return nil, "", fmt.Errorf("invalid token")
-	}
-
-	if claims.CenvID != cenvID {
-		return nil, "", fmt.Errorf("invalid token for this cenv")
+		message, _ := endpointAuthError(err)
+		return nil, "", errors.New(strings.ToLower(message))
 	}
 
 	db, err := s.cenvManager.GetConnection(cenvID)
@@ -504,12 +699,7 @@ func (s *Server) authenticateAndAuthorize(r *http.Request, cenvID string, allowe
 		return nil, "", fmt.Errorf("database error")
 	}
 
-	valid, err := auth.IsSessionValid(db, auth.GetTokenHash(token))
-	if err != nil || !valid {
-		return nil, "", fmt.Errorf("session expired")
-	}
-
-	role := claims.Role
+	role := identity.Role
 
 	// Check if role is allowed
 	if len(allowedRoles) > 0 {
@@ -9,7 +9,6 @@ import (
 	"testing"
 
 	"github.com/thetanil/wce/internal/cenv"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // TestStarlarkIntegration tests the full Starlark endpoint flow
@@ -112,7 +111,7 @@ func TestStarlarkIntegration(t *testing.T) {
 		bodyBytes, _ := json.Marshal(endpoint)
 
 		req := httptest.NewRequest("POST", "/"+cenvID+"/admin/endpoints", bytes.NewReader(bodyBytes))
-		req.Header.Set("Authorization", "Bearer "+token)
+		signRequest(req, token, bodyBytes)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -169,7 +168,7 @@ func TestStarlarkIntegration(t *testing.T) {
 		bodyBytes, _ := json.Marshal(endpoint)
 
 		req := httptest.NewRequest("POST", "/"+cenvID+"/admin/endpoints", bytes.NewReader(bodyBytes))
-		req.Header.Set("Authorization", "Bearer "+token)
+		signRequest(req, token, bodyBytes)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -280,7 +279,7 @@ func TestStarlarkIntegration(t *testing.T) {
 		bodyBytes, _ := json.Marshal(endpoint)
 
 		req := httptest.NewRequest("POST", "/"+cenvID+"/admin/endpoints", bytes.NewReader(bodyBytes))
-		req.Header.Set("Authorization", "Bearer "+token)
+		signRequest(req, token, bodyBytes)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -314,7 +313,7 @@ func TestStarlarkIntegration(t *testing.T) {
 		bodyBytes, _ := json.Marshal(endpoint)
 
 		req := httptest.NewRequest("POST", "/"+cenvID+"/admin/endpoints", bytes.NewReader(bodyBytes))
-		req.Header.Set("Authorization", "Bearer "+token)
+		signRequest(req, token, bodyBytes)
 		w := httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -354,7 +353,7 @@ func TestStarlarkIntegration(t *testing.T) {
 
 		// Delete it
 		req = httptest.NewRequest("DELETE", "/"+cenvID+"/admin/endpoints/"+fmt.Sprint(endpointID), nil)
-		req.Header.Set("Authorization", "Bearer "+token)
+		signRequest(req, token, nil)
 		w = httptest.NewRecorder()
 		mux.ServeHTTP(w, req)
 
@@ -376,3 +375,50 @@ func TestStarlarkIntegration(t *testing.T) {
 		}
 	})
 }
+
+// TestEndpointLinting tests static checks when saving endpoints
+func TestEndpointLinting(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	t.Run("BlocksHardErrors", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path":   "/broken",
+			"method": "GET",
+			"script": "def handle_request(req):\n    return undefined_fn()",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if errs, ok := resp["errors"].([]interface{}); !ok || len(errs) == 0 {
+			t.Errorf("Expected lint errors in response, got %v", resp)
+		}
+	})
+
+	t.Run("ReturnsWarnings", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+			"path":   "/promote",
+			"method": "POST",
+			"script": "def handle_request(req):\n    db.execute(\"DELETE FROM _wce_sessions\", [])\n    return response({})",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if warnings, ok := resp["warnings"].([]interface{}); !ok || len(warnings) != 1 {
+			t.Errorf("Expected one warning, got %v", resp["warnings"])
+		}
+	})
+}
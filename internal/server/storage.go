@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// handleStorageReport breaks down a cenv's space usage by table and lists its
+// largest documents, so owners can find what to delete when nearing quota
+// (admin/owner only). ?limit= caps the documents listed (default 20, max 100).
+func (s *Server) handleStorageReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view storage usage")
+	if err != nil {
+		return // Response already sent
+	}
+
+	storage, err := tables.StorageReport(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to measure storage",
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	documents, err := document.LargestDocuments(db, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to measure documents",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"storage":   storage,
+		"documents": documents,
+	})
+}
+
+// handleStorageUsage reports document storage used against the document size
+// limit and storage quota, to anyone who can read documents
+func (s *Server) handleStorageUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	usage, err := document.StorageUsage(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to measure storage",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(usage)
+}
+
+// handleDuplicateReport lists groups of documents with identical content and
+// the bytes each group wastes, so owners can clean up copies (admin/owner
+// only). ?prefix= limits the scan to document ids under a prefix.
+func (s *Server) handleDuplicateReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireAdmin(w, r, cenvID, "view duplicate documents")
+	if err != nil {
+		return // Response already sent
+	}
+
+	groups, err := document.FindDuplicates(r.Context(), db, r.URL.Query().Get("prefix"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to scan documents",
+		})
+		return
+	}
+
+	var wasted int64
+	for _, group := range groups {
+		wasted += group.WastedBytes
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"duplicates":   groups,
+		"wasted_bytes": wasted,
+	})
+}
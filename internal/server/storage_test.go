@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/config"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+func TestStorageReport(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/admin/storage", srv.handleStorageReport)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for id, size := range map[string]int{"big.txt": 5000, "small.txt": 10} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": strings.Repeat("x", size), "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/storage?limit=1", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report struct {
+		Storage   tables.Storage          `json:"storage"`
+		Documents []document.DocumentSize `json:"documents"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Storage.TotalBytes <= 0 {
+		t.Errorf("Expected total bytes, got %+v", report.Storage)
+	}
+	found := false
+	for _, table := range report.Storage.Tables {
+		if table.Name == "_wce_documents" && table.Rows == 2 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected _wce_documents with 2 rows, got %+v", report.Storage.Tables)
+	}
+	if len(report.Documents) != 1 || report.Documents[0].ID != "big.txt" || report.Documents[0].ContentBytes != 5000 {
+		t.Errorf("Expected big.txt as the largest document, got %+v", report.Documents)
+	}
+
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/storage", editorToken, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for editor, got %d", w.Code)
+	}
+}
+
+func TestStorageLimits(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("GET /{cenvID}/usage", srv.handleStorageUsage)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	config.Set(db, document.MaxDocumentSizeConfigKey, "1", "")
+	config.Set(db, document.StorageQuotaConfigKey, "2", "")
+
+	create := func(id string, size int) *httptest.ResponseRecorder {
+		return doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": strings.Repeat("x", size), "content_type": "text/plain",
+		})
+	}
+
+	w := create("huge.txt", 1<<20+1)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d: %s", w.Code, w.Body.String())
+	}
+	var limitResp map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&limitResp)
+	if limitResp["limit"] != document.LimitDocumentSize || limitResp["max_bytes"] != float64(1<<20) {
+		t.Errorf("Unexpected limit response: %v", limitResp)
+	}
+
+	for _, id := range []string{"a.txt", "b.txt"} {
+		if w := create(id, 1<<20-10); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := create("c.txt", 100); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507 over quota, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, mux, "PUT", "/"+cenvID+"/documents/a.txt", token, map[string]string{"content": strings.Repeat("y", 100)})
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507 for an update keeping the old version, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/usage", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var usage document.Usage
+	json.NewDecoder(w.Body).Decode(&usage)
+	want := document.Usage{
+		Documents: 2, UsedBytes: 2 * (1<<20 - 10), QuotaBytes: 2 << 20, AvailableBytes: 20, MaxDocumentBytes: 1 << 20,
+	}
+	if usage != want {
+		t.Errorf("Expected %+v, got %+v", want, usage)
+	}
+
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/usage", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+}
+
+func TestDuplicateReport(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/admin/duplicates", srv.handleDuplicateReport)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for id, content := range map[string]string{
+		"pages/a.txt": "same", "pages/b.txt": "same", "drafts/a.txt": "same", "pages/c.txt": "other",
+	} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": content, "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/duplicates", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report struct {
+		Duplicates  []document.DuplicateGroup `json:"duplicates"`
+		WastedBytes int64                     `json:"wasted_bytes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Duplicates) != 1 || len(report.Duplicates[0].IDs) != 3 || report.Duplicates[0].Size != 4 || report.WastedBytes != 8 {
+		t.Errorf("Expected one group of 3 copies wasting 8 bytes, got %+v", report)
+	}
+
+	w = doJSON(t, mux, "GET", "/"+cenvID+"/admin/duplicates?prefix=pages/", token, nil)
+	json.NewDecoder(w.Body).Decode(&report)
+	if len(report.Duplicates) != 1 || strings.Join(report.Duplicates[0].IDs, ",") != "pages/a.txt,pages/b.txt" {
+		t.Errorf("Expected pages/ duplicates only, got %+v", report.Duplicates)
+	}
+
+	db, _ := manager.GetConnection(cenvID)
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+	if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/duplicates", editorToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for editor, got %d", w.Code)
+	}
+}
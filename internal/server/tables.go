@@ -0,0 +1,297 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// IndexRequest represents a request to create or drop an index
+type IndexRequest struct {
+	Action  string   `json:"action"` // "create" or "drop"
+	Name    string   `json:"name"`   // Optional for create
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// requireTableManager authenticates the request and checks that the user may
+// manage the table's schema (owner/admin, or a grant permission on the table)
+func (s *Server) requireTableManager(w http.ResponseWriter, r *http.Request, cenvID, table string) (string, *sql.DB, error) {
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if tables.IsReservedName(table) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "system tables cannot be modified",
+		})
+		return "", nil, fmt.Errorf("reserved table: %s", table)
+	}
+
+	canGrant, err := authz.CanGrant(db, userID, role, table)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to check permissions",
+		})
+		return "", nil, err
+	}
+	if !canGrant {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "insufficient permissions to manage table " + table,
+		})
+		return "", nil, fmt.Errorf("insufficient permissions")
+	}
+
+	return userID, db, nil
+}
+
+// writeTableError maps table management errors to HTTP status codes
+func writeTableError(w http.ResponseWriter, err error) {
+	switch {
+	case strings.Contains(err.Error(), "not found"):
+		w.WriteHeader(http.StatusNotFound)
+	case strings.Contains(err.Error(), "already exists"):
+		w.WriteHeader(http.StatusConflict)
+	case strings.HasPrefix(err.Error(), "failed"), strings.HasPrefix(err.Error(), "error"):
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// handleListTables lists the user tables the caller can read
+func (s *Server) handleListTables(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	names, err := tables.ListTables(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	readable := []string{}
+	for _, name := range names {
+		if ok, err := authz.CanRead(db, userID, role, name); err == nil && ok {
+			readable = append(readable, name)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tables": readable,
+		"count":  len(readable),
+	})
+}
+
+// handleCreateTable creates a user table with the schema builder (admin/owner only)
+func (s *Server) handleCreateTable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "create tables")
+	if err != nil {
+		return // Response already sent
+	}
+
+	var def tables.TableDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if err := tables.CreateTable(db, &def); err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	schema, err := tables.DescribeTable(db, def.Name)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	log.Printf("Table %s created by %s", def.Name, userID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schema)
+}
+
+// handleDescribeTable returns a user table's columns, indexes and relationships.
+// Relationships from tables the caller cannot read are omitted.
+func (s *Server) handleDescribeTable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, table)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "failed to check permissions",
+		})
+		return
+	}
+	if !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "insufficient permissions to read table " + table,
+		})
+		return
+	}
+
+	schema, err := tables.DescribeTable(db, table)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	referencedBy := []tables.Relationship{}
+	for _, rel := range schema.ReferencedBy {
+		if ok, err := authz.CanRead(db, userID, role, rel.Table); err == nil && ok {
+			referencedBy = append(referencedBy, rel)
+		}
+	}
+	schema.ReferencedBy = referencedBy
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schema)
+}
+
+// handleListIndexes lists the indexes on a user table
+func (s *Server) handleListIndexes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	_, db, err := s.requireTableManager(w, r, cenvID, table)
+	if err != nil {
+		return // Response already sent
+	}
+
+	indexes, err := tables.ListIndexes(db, table)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"indexes": indexes,
+		"count":   len(indexes),
+	})
+}
+
+// handleManageIndex creates or drops an index on a user table
+func (s *Server) handleManageIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	table := r.PathValue("table")
+
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireTableManager(w, r, cenvID, table)
+	if err != nil {
+		return // Response already sent
+	}
+
+	var req IndexRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	switch req.Action {
+	case "create":
+		index, err := tables.CreateIndex(db, table, req.Name, req.Columns, req.Unique)
+		if err != nil {
+			writeTableError(w, err)
+			return
+		}
+
+		log.Printf("Index %s created on %s by %s", index.Name, table, userID)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(index)
+
+	case "drop":
+		if err := tables.DropIndex(db, table, req.Name); err != nil {
+			writeTableError(w, err)
+			return
+		}
+
+		log.Printf("Index %s dropped from %s by %s", req.Name, table, userID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "index dropped successfully",
+		})
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "action must be 'create' or 'drop'",
+		})
+	}
+}
@@ -0,0 +1,530 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+func TestTableIndexAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}/indexes", srv.handleListIndexes)
+	mux.HandleFunc("POST /{cenvID}/api/tables/{table}/indexes", srv.handleManageIndex)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE products (id INTEGER PRIMARY KEY, sku TEXT, name TEXT)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	editor, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	indexesPath := "/" + cenvID + "/api/tables/products/indexes"
+
+	t.Run("AdminCreatesIndex", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", indexesPath, token, IndexRequest{
+			Action: "create", Columns: []string{"sku"}, Unique: true,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var index tables.Index
+		json.NewDecoder(w.Body).Decode(&index)
+		if index.Name != "idx_products_sku" || !index.Unique {
+			t.Errorf("Unexpected index: %+v", index)
+		}
+
+		w = doJSON(t, mux, "POST", indexesPath, token, IndexRequest{
+			Action: "create", Name: "idx_products_sku", Columns: []string{"name"},
+		})
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for duplicate name, got %d", w.Code)
+		}
+	})
+
+	t.Run("ListIndexes", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", indexesPath, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Indexes []tables.Index `json:"indexes"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Indexes) != 1 || resp.Indexes[0].Columns[0] != "sku" {
+			t.Errorf("Unexpected indexes: %+v", resp.Indexes)
+		}
+	})
+
+	t.Run("EditorNeedsGrant", func(t *testing.T) {
+		body := IndexRequest{Action: "create", Columns: []string{"name"}}
+
+		w := doJSON(t, mux, "POST", indexesPath, editorToken, body)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("Expected 403 without grant, got %d", w.Code)
+		}
+
+		if err := authz.GrantPermission(db, editor.UserID, "products", true, true, false, true); err != nil {
+			t.Fatalf("Failed to grant permission: %v", err)
+		}
+
+		w = doJSON(t, mux, "POST", indexesPath, editorToken, body)
+		if w.Code != http.StatusCreated {
+			t.Errorf("Expected 201 with grant, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			path   string
+			body   IndexRequest
+			status int
+		}{
+			{"SystemTable", "/" + cenvID + "/api/tables/_wce_users/indexes", IndexRequest{Action: "create", Columns: []string{"role"}}, http.StatusForbidden},
+			{"MissingTable", "/" + cenvID + "/api/tables/missing/indexes", IndexRequest{Action: "create", Columns: []string{"a"}}, http.StatusNotFound},
+			{"MissingColumn", indexesPath, IndexRequest{Action: "create", Columns: []string{"price"}}, http.StatusNotFound},
+			{"ReservedName", indexesPath, IndexRequest{Action: "create", Name: "_wce_idx", Columns: []string{"name"}}, http.StatusBadRequest},
+			{"BadAction", indexesPath, IndexRequest{Action: "rebuild"}, http.StatusBadRequest},
+			{"DropMissing", indexesPath, IndexRequest{Action: "drop", Name: "nope"}, http.StatusNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := doJSON(t, mux, "POST", tt.path, token, tt.body)
+				if w.Code != tt.status {
+					t.Errorf("Expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("DropIndex", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", indexesPath, token, IndexRequest{Action: "drop", Name: "idx_products_sku"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'idx_products_sku'`).Scan(&count)
+		if count != 0 {
+			t.Error("Expected index to be dropped")
+		}
+	})
+}
+
+func TestTableSchemaAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("GET /{cenvID}/api/tables", srv.handleListTables)
+	mux.HandleFunc("POST /{cenvID}/api/tables", srv.handleCreateTable)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}", srv.handleDescribeTable)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	editor, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	tablesPath := "/" + cenvID + "/api/tables"
+
+	t.Run("CreateTables", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", tablesPath, token, tables.TableDefinition{
+			Name: "authors",
+			Columns: []tables.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: true},
+				{Name: "name", Type: "TEXT", NotNull: true},
+			},
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "POST", tablesPath, token, tables.TableDefinition{
+			Name: "books",
+			Columns: []tables.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: true},
+				{Name: "author_id", Type: "INTEGER"},
+				{Name: "title", Type: "TEXT"},
+			},
+			Relationships: []tables.Relationship{
+				{Column: "author_id", References: "authors", OnDelete: tables.ActionCascade},
+			},
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var schema tables.TableSchema
+		json.NewDecoder(w.Body).Decode(&schema)
+		if len(schema.Relationships) != 1 || schema.Relationships[0].OnDelete != tables.ActionCascade {
+			t.Errorf("Unexpected relationships: %+v", schema.Relationships)
+		}
+	})
+
+	t.Run("CreateValidation", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", tablesPath, token, tables.TableDefinition{
+			Name:    "authors",
+			Columns: []tables.Column{{Name: "id", Type: "INTEGER"}},
+		})
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for existing table, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "POST", tablesPath, token, tables.TableDefinition{
+			Name:          "reviews",
+			Columns:       []tables.Column{{Name: "user_id", Type: "TEXT"}},
+			Relationships: []tables.Relationship{{Column: "user_id", References: "_wce_users"}},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for system table reference, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "POST", tablesPath, editorToken, tables.TableDefinition{
+			Name:    "notes",
+			Columns: []tables.Column{{Name: "id", Type: "INTEGER"}},
+		})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+	})
+
+	t.Run("CascadeEnforced", func(t *testing.T) {
+		// Every pooled connection must enforce foreign keys
+		for i := 0; i < 3; i++ {
+			conn, err := db.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("Failed to get connection: %v", err)
+			}
+			var enabled bool
+			conn.QueryRowContext(context.Background(), `PRAGMA foreign_keys`).Scan(&enabled)
+			conn.Close()
+			if !enabled {
+				t.Fatal("Expected foreign keys enabled on pooled connection")
+			}
+		}
+
+		db.Exec(`INSERT INTO authors (id, name) VALUES (1, 'Le Guin')`)
+		db.Exec(`INSERT INTO books (author_id, title) VALUES (1, 'The Dispossessed')`)
+		if _, err := db.Exec(`INSERT INTO books (author_id, title) VALUES (99, 'Orphan')`); err == nil {
+			t.Error("Expected foreign key violation")
+		}
+		db.Exec(`DELETE FROM authors WHERE id = 1`)
+
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM books`).Scan(&count)
+		if count != 0 {
+			t.Errorf("Expected cascade delete, %d books remain", count)
+		}
+	})
+
+	t.Run("Introspection", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", tablesPath+"/authors", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var schema tables.TableSchema
+		json.NewDecoder(w.Body).Decode(&schema)
+		if len(schema.ReferencedBy) != 1 || schema.ReferencedBy[0].Table != "books" {
+			t.Errorf("Expected books to reference authors, got %+v", schema.ReferencedBy)
+		}
+	})
+
+	t.Run("EditorSeesReadableTablesOnly", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", tablesPath+"/authors", editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("Expected 403 without read permission, got %d", w.Code)
+		}
+
+		authz.GrantPermission(db, editor.UserID, "authors", true, false, false, false)
+
+		w = doJSON(t, mux, "GET", tablesPath+"/authors", editorToken, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200 with read permission, got %d", w.Code)
+		}
+		var schema tables.TableSchema
+		json.NewDecoder(w.Body).Decode(&schema)
+		if len(schema.ReferencedBy) != 0 {
+			t.Errorf("Relationships from unreadable tables must be hidden, got %+v", schema.ReferencedBy)
+		}
+
+		w = doJSON(t, mux, "GET", tablesPath, editorToken, nil)
+		var list struct {
+			Tables []string `json:"tables"`
+		}
+		json.NewDecoder(w.Body).Decode(&list)
+		if len(list.Tables) != 1 || list.Tables[0] != "authors" {
+			t.Errorf("Expected only authors, got %v", list.Tables)
+		}
+	})
+}
+
+func TestRowHistoryAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/api/tables", srv.handleCreateTable)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}", srv.handleDescribeTable)
+	mux.HandleFunc("PUT /{cenvID}/api/tables/{table}/history", srv.handleSetTableHistory)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}/rows/{rowID}/history", srv.handleRowHistory)
+	mux.HandleFunc("POST /{cenvID}/api/tables/{table}/rows/{rowID}/history/{entryID}/restore", srv.handleRestoreRow)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	editor, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	tablePath := "/" + cenvID + "/api/tables/tasks"
+	historyPath := tablePath + "/rows/1/history"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/api/tables", token, tables.TableDefinition{
+		Name: "tasks",
+		Columns: []tables.Column{
+			{Name: "id", Type: "INTEGER", PrimaryKey: true},
+			{Name: "title", Type: "TEXT"},
+		},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("Enable", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", tablePath+"/history", editorToken, map[string]bool{"enabled": true})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "PUT", tablePath+"/history", token, map[string]bool{"enabled": true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "GET", tablePath, token, nil)
+		var schema tables.TableSchema
+		json.NewDecoder(w.Body).Decode(&schema)
+		if !schema.History {
+			t.Error("Expected history reported by introspection")
+		}
+	})
+
+	db.Exec(`INSERT INTO tasks (id, title) VALUES (1, 'draft')`)
+	db.Exec(`UPDATE tasks SET title = 'final' WHERE id = 1`)
+
+	var entries []tables.HistoryEntry
+	t.Run("List", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", historyPath, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			History []tables.HistoryEntry `json:"history"`
+			Count   int                   `json:"count"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Count != 2 || resp.History[0].Operation != tables.OperationUpdate {
+			t.Fatalf("Unexpected history: %+v", resp)
+		}
+		entries = resp.History
+
+		w = doJSON(t, mux, "GET", historyPath+"?limit=1", token, nil)
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Count != 1 {
+			t.Errorf("Expected limit to apply, got %d entries", resp.Count)
+		}
+	})
+
+	t.Run("ReadPermissions", func(t *testing.T) {
+		w := doJSON(t, mux, "GET", historyPath, editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 without read permission, got %d", w.Code)
+		}
+
+		authz.GrantPermission(db, editor.UserID, "tasks", true, false, false, false)
+		w = doJSON(t, mux, "GET", historyPath, editorToken, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected 200 with read permission, got %d", w.Code)
+		}
+
+		if err := authz.CreateRowPolicy(db, "tasks", editor.UserID, "read", "id > 1", editor.UserID); err != nil {
+			t.Fatalf("Failed to create row policy: %v", err)
+		}
+		w = doJSON(t, mux, "GET", historyPath, editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 under row policies, got %d", w.Code)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		restorePath := historyPath + "/" + strconv.FormatInt(entries[0].ID, 10) + "/restore"
+
+		w := doJSON(t, mux, "POST", restorePath, editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "POST", restorePath, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var title string
+		db.QueryRow(`SELECT title FROM tasks WHERE id = 1`).Scan(&title)
+		if title != "draft" {
+			t.Errorf("Expected restored title, got %q", title)
+		}
+
+		w = doJSON(t, mux, "POST", historyPath+"/99999/restore", token, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for unknown entry, got %d", w.Code)
+		}
+	})
+}
+
+func TestSoftDeleteAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/api/tables", srv.handleCreateTable)
+	mux.HandleFunc("GET /{cenvID}/api/tables/{table}", srv.handleDescribeTable)
+	mux.HandleFunc("PUT /{cenvID}/api/tables/{table}/soft-delete", srv.handleSetSoftDelete)
+	mux.HandleFunc("POST /{cenvID}/api/tables/{table}/purge", srv.handlePurgeDeleted)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	db, err := manager.GetConnection(cenvID)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := auth.CreateUser(db, "editor", "editorpass123", "editor", "", ""); err != nil {
+		t.Fatalf("Failed to create editor: %v", err)
+	}
+	editorToken := loginAs(t, mux, cenvID, "editor", "editorpass123")
+
+	tablePath := "/" + cenvID + "/api/tables/items"
+
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/api/tables", token, tables.TableDefinition{
+		Name: "items",
+		Columns: []tables.Column{
+			{Name: "id", Type: "INTEGER", PrimaryKey: true},
+			{Name: "name", Type: "TEXT"},
+		},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	db.Exec(`INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b')`)
+
+	t.Run("Enable", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", tablePath+"/soft-delete", editorToken, map[string]bool{"enabled": true})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "PUT", tablePath+"/soft-delete", token, map[string]bool{"enabled": true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "GET", tablePath, token, nil)
+		var schema tables.TableSchema
+		json.NewDecoder(w.Body).Decode(&schema)
+		if !schema.SoftDelete {
+			t.Error("Expected soft delete reported by introspection")
+		}
+	})
+
+	t.Run("RewrittenDelete", func(t *testing.T) {
+		query, err := authz.ValidateAndRewriteQuery(db, "", authz.RoleOwner, "DELETE FROM items WHERE id = 1")
+		if err != nil {
+			t.Fatalf("ValidateAndRewriteQuery failed: %v", err)
+		}
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Rewritten delete failed: %v", err)
+		}
+
+		query, _ = authz.ValidateAndRewriteQuery(db, "", authz.RoleOwner, "SELECT COUNT(*) FROM items")
+		var live, total int
+		db.QueryRow(query).Scan(&live)
+		db.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&total)
+		if live != 1 || total != 2 {
+			t.Errorf("Expected 1 live of 2 rows, got %d of %d", live, total)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", tablePath+"/purge", editorToken, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for editor, got %d", w.Code)
+		}
+
+		w = doJSON(t, mux, "POST", tablePath+"/purge", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Purged int64 `json:"purged"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Purged != 1 {
+			t.Errorf("Expected 1 row purged, got %d", resp.Purged)
+		}
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		w := doJSON(t, mux, "PUT", tablePath+"/soft-delete", token, map[string]bool{"enabled": false})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = doJSON(t, mux, "POST", tablePath+"/purge", token, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 when soft delete is off, got %d", w.Code)
+		}
+	})
+}
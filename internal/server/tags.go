@@ -0,0 +1,158 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+// setDocumentTags replaces the tags of a document with the list in the
+// request body, addressed as PUT {docID}/tags. Write permission has already
+// been checked.
+func (s *Server) setDocumentTags(w http.ResponseWriter, r *http.Request, db *sql.DB, docID string) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tags == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "request body must be {\"tags\": [...]}",
+		})
+		return
+	}
+
+	tags, err := document.SetDocumentTags(db, docID, req.Tags)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":   docID,
+		"tags": tags,
+	})
+}
+
+// handleListTags lists every tag in use with its document count
+func (s *Server) handleListTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return // Response already sent
+	}
+
+	canRead, err := authz.CanRead(db, userID, role, "_wce_documents")
+	if err != nil || !canRead {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot read documents",
+		})
+		return
+	}
+
+	tags, err := document.ListTags(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tags":  tags,
+		"count": len(tags),
+	})
+}
+
+// handleRenameTag renames a tag on every document, merging it into the
+// tag named by {"to": ...} where both are present
+func (s *Server) handleRenameTag(w http.ResponseWriter, r *http.Request) {
+	db, ok := s.authorizeTagWrite(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "request body must be {\"to\": \"new-tag\"}",
+		})
+		return
+	}
+
+	count, err := document.RenameTag(db, r.PathValue("tag"), req.To)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tag":       req.To,
+		"documents": count,
+	})
+}
+
+// handleDeleteTag removes a tag from every document
+func (s *Server) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	db, ok := s.authorizeTagWrite(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := document.DeleteTag(db, r.PathValue("tag"))
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted":   true,
+		"documents": count,
+	})
+}
+
+// authorizeTagWrite authenticates a request that changes tags across
+// documents, sending the error response when it is not allowed
+func (s *Server) authorizeTagWrite(w http.ResponseWriter, r *http.Request) (*sql.DB, bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return nil, false
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return nil, false // Response already sent
+	}
+
+	canWrite, err := authz.CanWrite(db, userID, role, "_wce_documents")
+	if err != nil || !canWrite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "permission denied: cannot write documents",
+		})
+		return nil, false
+	}
+	return db, true
+}
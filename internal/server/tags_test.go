@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/document"
+)
+
+func TestTagAPI(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("PUT /{cenvID}/documents/{docID...}", srv.handleUpdateDocument)
+	mux.HandleFunc("GET /{cenvID}/tags", srv.handleListTags)
+	mux.HandleFunc("POST /{cenvID}/tags/{tag}/rename", srv.handleRenameTag)
+	mux.HandleFunc("DELETE /{cenvID}/tags/{tag}", srv.handleDeleteTag)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	for _, id := range []string{"posts/a", "posts/b"} {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/documents", token, map[string]interface{}{
+			"id": id, "content": "content of " + id, "content_type": "text/plain",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/a/tags", token, map[string]interface{}{
+		"tags": []string{"Draft", "go"},
+	})
+	var set struct {
+		Tags []string `json:"tags"`
+	}
+	json.NewDecoder(w.Body).Decode(&set)
+	if w.Code != http.StatusOK || len(set.Tags) != 2 || set.Tags[0] != "draft" {
+		t.Fatalf("Unexpected tag update: %d %+v", w.Code, set)
+	}
+	doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/b/tags", token, map[string]interface{}{"tags": []string{"go"}})
+
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/missing/tags", token, map[string]interface{}{"tags": []string{"go"}}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 tagging a missing document, got %d", w.Code)
+	}
+	if w := doJSON(t, mux, "PUT", "/"+cenvID+"/documents/posts/a/tags", token, map[string]interface{}{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a tag list, got %d", w.Code)
+	}
+
+	listTags := func() []document.TagCount {
+		t.Helper()
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/tags", token, nil)
+		var resp struct {
+			Tags []document.TagCount `json:"tags"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Tags
+	}
+	if tags := listTags(); len(tags) != 2 || tags[1].Tag != "go" || tags[1].Documents != 2 {
+		t.Errorf("Unexpected tag counts: %+v", tags)
+	}
+
+	if w := doJSON(t, mux, "POST", "/"+cenvID+"/tags/go/rename", token, map[string]string{"to": "golang"}); w.Code != http.StatusOK {
+		t.Errorf("Expected rename to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/tags/draft", token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected delete to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if tags := listTags(); len(tags) != 1 || tags[0].Tag != "golang" || tags[0].Documents != 2 {
+		t.Errorf("Unexpected tags after rename and delete: %+v", tags)
+	}
+	if w := doJSON(t, mux, "DELETE", "/"+cenvID+"/tags/draft", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unused tag, got %d", w.Code)
+	}
+}
@@ -4,11 +4,12 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
-	"github.com/thetanil/wce/internal/auth"
 	"github.com/thetanil/wce/internal/template"
 )
 
@@ -49,15 +50,13 @@ func (s *Server) handleRenderPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add user info if authenticated (optional for pages)
-	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
-		claims, err := s.extractAndValidateClaims(r, cenvID)
-		if err == nil {
-			// User is authenticated
-			variables["user"] = map[string]interface{}{
-				"id":       claims.UserID,
-				"username": claims.Username,
-				"role":     claims.Role,
-			}
+	identity, _ := s.identify(r, cenvID)
+	variables["identity"] = identity.Map()
+	if identity != nil {
+		variables["user"] = map[string]interface{}{
+			"id":       identity.UserID,
+			"username": identity.Username,
+			"role":     identity.Role,
 		}
 	}
 
@@ -66,22 +65,26 @@ func (s *Server) handleRenderPage(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	// Render template
-	html, err := template.RenderTemplateFromDB(ctx, db, templateID, variables)
+	rendered, err := template.RenderDocument(ctx, db, templateID, variables)
 	if err != nil {
+		var budgetErr *template.BudgetError
 		// Check if it's a "template not found" error
 		if err.Error() == fmt.Sprintf("template not found: %s", templateID) {
 			http.Error(w, "Page not found", http.StatusNotFound)
+		} else if errors.As(err, &budgetErr) {
+			log.Printf("Render of %s stopped: %v", templateID, err)
+			http.Error(w, "Template render budget exceeded", http.StatusServiceUnavailable)
 		} else {
 			http.Error(w, fmt.Sprintf("Template render error: %v", err), http.StatusInternalServerError)
 		}
 		return
 	}
 
-	// Return rendered HTML
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Return the rendered output as the template's output type
+	w.Header().Set("Content-Type", rendered.ContentType)
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(html))
+	w.Write([]byte(rendered.Content))
 }
 
 // handlePreviewTemplate renders a template without saving it
@@ -96,7 +99,7 @@ func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Require authentication
-	claims, err := s.extractAndValidateClaims(r, cenvID)
+	identity, err := s.identify(r, cenvID)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -104,8 +107,9 @@ func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request body
 	var req struct {
-		Template string                 `json:"template"` // Template source
-		Context  map[string]interface{} `json:"context"`  // Optional context data
+		Template    string                 `json:"template"`     // Template source
+		Context     map[string]interface{} `json:"context"`      // Optional context data
+		ContentType string                 `json:"content_type"` // Output type, picks autoescaping; default text/html
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -125,10 +129,11 @@ func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
 
 	// Add default context variables
 	req.Context["user"] = map[string]interface{}{
-		"id":       claims.UserID,
-		"username": claims.Username,
-		"role":     claims.Role,
+		"id":       identity.UserID,
+		"username": identity.Username,
+		"role":     identity.Role,
 	}
+	req.Context["identity"] = identity.Map()
 
 	// Get database connection for template loader
 	db, err := s.cenvManager.GetConnection(cenvID)
@@ -141,9 +146,14 @@ func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
+	contentType := "text/html; charset=utf-8"
+	if req.ContentType != "" {
+		contentType = req.ContentType
+	}
 	renderCtx := &template.RenderContext{
-		Variables: req.Context,
-		Loader:    template.DocumentLoader(db),
+		Variables:   req.Context,
+		Loader:      template.DocumentLoader(db),
+		ContentType: contentType,
 	}
 
 	// Render template
@@ -151,6 +161,17 @@ func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		// Return error in JSON format for easier debugging
 		w.Header().Set("Content-Type", "application/json")
+		var budgetErr *template.BudgetError
+		if errors.As(err, &budgetErr) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Template render budget exceeded",
+				"message": err.Error(),
+				"budget":  budgetErr.Budget,
+				"limit":   budgetErr.Limit,
+			})
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error":   "Template render error",
@@ -159,8 +180,9 @@ func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return rendered HTML
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Return the rendered output
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(html))
 }
@@ -177,7 +199,7 @@ func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Require authentication
-	_, err := s.extractAndValidateClaims(r, cenvID)
+	_, err := s.identify(r, cenvID)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -244,48 +266,3 @@ func queryParamsToMap(r *http.Request) map[string]interface{} {
 	}
 	return result
 }
-
-// extractAndValidateClaims extracts and validates JWT claims from request
-// This is a helper function that duplicates some logic from handleCenvRequest
-// but is useful for endpoints that optionally require authentication
-func (s *Server) extractAndValidateClaims(r *http.Request, cenvID string) (*auth.Claims, error) {
-	// Extract token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return nil, fmt.Errorf("missing Authorization header")
-	}
-
-	// Expect "Bearer <token>"
-	var token string
-	if _, err := fmt.Sscanf(authHeader, "Bearer %s", &token); err != nil {
-		return nil, fmt.Errorf("invalid Authorization header format")
-	}
-
-	// Validate token
-	claims, err := s.jwtManager.ValidateToken(token)
-	if err != nil {
-		return nil, fmt.Errorf("invalid token: %w", err)
-	}
-
-	// Verify cenv_id matches
-	if claims.CenvID != cenvID {
-		return nil, fmt.Errorf("token cenv_id mismatch")
-	}
-
-	// Verify session is still valid
-	db, err := s.cenvManager.GetConnection(cenvID)
-	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
-	}
-
-	tokenHash := auth.GetTokenHash(token)
-	valid, err := auth.IsSessionValid(db, tokenHash)
-	if err != nil {
-		return nil, fmt.Errorf("session check error: %w", err)
-	}
-	if !valid {
-		return nil, fmt.Errorf("session expired or revoked")
-	}
-
-	return claims, nil
-}
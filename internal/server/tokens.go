@@ -0,0 +1,214 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// MaxScopedTokenLifetime bounds how long an issued third-party token lasts
+const MaxScopedTokenLifetime = 90 * 24 * time.Hour
+
+// TokenRequest asks for a scoped token acting as the caller
+type TokenRequest struct {
+	Scope     string `json:"scope"`      // Space-separated, e.g. "documents:read star:execute"
+	ExpiresIn int64  `json:"expires_in"` // Seconds; defaults to the session timeout
+}
+
+// TokenResponse follows the OAuth 2.0 token response shape
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// handleIssueToken exchanges the caller's session token for a token limited
+// to the requested scopes, to hand to an external integration. The new token
+// acts as the caller, so it never exceeds the caller's role (admin/owner only).
+// Route: POST /{cenvID}/token
+func (s *Server) handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return
+	}
+
+	userID, db, err := s.requireAdmin(w, r, cenvID, "issue scoped tokens")
+	if err != nil {
+		return // Response already sent
+	}
+
+	// A scoped token cannot mint further tokens
+	identity, err := s.identify(r, cenvID)
+	if err != nil || identity.IsScoped() {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "scoped tokens cannot issue tokens",
+		})
+		return
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, userID, "issue_token", "token", ""); err != nil {
+		return // Response already sent
+	}
+
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	scopes, err := auth.ParseScopes(req.Scope)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	expiresIn := auth.DefaultSessionTimeout
+	if req.ExpiresIn != 0 {
+		expiresIn = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if expiresIn <= 0 || expiresIn > MaxScopedTokenLifetime {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "expires_in must be between 1 and 7776000 seconds",
+		})
+		return
+	}
+
+	token, err := s.issueToken(r, db, identity.UserID, identity.Username, cenvID, identity.Role, scopes, expiresIn)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	scope := strings.Join(scopes, " ")
+	log.Printf("Scoped token (%s) issued by %s in cenv %s", scope, identity.Username, cenvID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expiresIn / time.Second),
+		Scope:       scope,
+	})
+}
+
+// issueToken creates a token and the session that makes it revocable like
+// any login. nil scopes issue an unrestricted token.
+func (s *Server) issueToken(r *http.Request, db *sql.DB, userID, username, cenvID, role string, scopes []string, expiresIn time.Duration) (string, error) {
+	sessionID, err := auth.GenerateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to create session")
+	}
+
+	token, err := s.jwtManager.GenerateScopedToken(userID, username, cenvID, role, sessionID, scopes, expiresIn)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token")
+	}
+
+	if _, err := auth.CreateSession(db, userID, auth.GetTokenHash(token), r.RemoteAddr, r.UserAgent(), expiresIn); err != nil {
+		return "", fmt.Errorf("failed to create session")
+	}
+
+	return token, nil
+}
+
+// requiredScope returns the scope a scoped token needs for a request to a
+// cenv path such as "/documents/x". It returns "" for routes scoped tokens
+// may not use at all, such as login and token exchange.
+func requiredScope(method, path string) string {
+	read := method == http.MethodGet || method == http.MethodHead
+
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	switch segments[0] {
+	case "documents", "usage", "tags", "collections":
+		if read {
+			return auth.ScopeDocumentsRead
+		}
+		return auth.ScopeDocumentsWrite
+	case "api":
+		if len(segments) > 1 && segments[1] == "tables" {
+			if read {
+				return auth.ScopeTablesRead
+			}
+			return auth.ScopeTablesWrite
+		}
+	case "star", "openapi.json":
+		return auth.ScopeStarExecute
+	case "pages":
+		return auth.ScopePagesRead
+	case "templates":
+		// Previews render arbitrary template source
+		if read {
+			return auth.ScopePagesRead
+		}
+		return auth.ScopeAdminWrite
+	case "ext":
+		return auth.ScopeProxyUse
+	case "me":
+		if read {
+			return auth.ScopeAccountRead
+		}
+		return auth.ScopeAccountWrite
+	case "flags":
+		return auth.ScopeAccountRead
+	case "admin":
+		// The debugger runs scripts, writes included, over a GET
+		if read && segments[len(segments)-1] != "debug" {
+			return auth.ScopeAdminRead
+		}
+		return auth.ScopeAdminWrite
+	}
+
+	return ""
+}
+
+// scopeMiddleware refuses requests whose token is scoped and lacks the scope
+// for the route group. The check is made where identityMiddleware resolves
+// the token; requests without a scope failure pass through to the handlers.
+func (s *Server) scopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var scopeErr *scopeError
+		if result, ok := r.Context().Value(identityKey{}).(*identityResult); ok && errors.As(result.err, &scopeErr) {
+			writeScopeError(w, scopeErr)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeScopeError sends the 403 insufficient_scope response for err
+func writeScopeError(w http.ResponseWriter, err *scopeError) {
+	challenge := `Bearer error="insufficient_scope"`
+	if err.scope != "" {
+		challenge += `, scope="` + err.scope + `"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestScopedTokens(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/token", srv.handleIssueToken)
+	mux.HandleFunc("GET /{cenvID}/documents/{docID...}", srv.handleGetDocument)
+	mux.HandleFunc("POST /{cenvID}/documents", srv.handleCreateDocument)
+	mux.HandleFunc("GET /{cenvID}/admin/config", srv.handleListConfig)
+	mux.HandleFunc("GET /{cenvID}/admin/endpoints/{endpointID}/debug", srv.handleDebugEndpoint)
+	mux.HandleFunc("GET /{cenvID}/tags", srv.handleListTags)
+	mux.HandleFunc("GET /{cenvID}/collections", srv.handleListCollections)
+	mux.HandleFunc("GET /{cenvID}/flags", srv.handleMyFlags)
+	mux.HandleFunc("GET /{cenvID}/me/sessions", srv.handleListMySessions)
+	handler := srv.identityMiddleware(srv.scopeMiddleware(mux))
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	do := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		signRequest(req, token, data)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	issue := func(token, scope string) *httptest.ResponseRecorder {
+		return do("POST", "/"+cenvID+"/token", token, map[string]interface{}{"scope": scope, "expires_in": 3600})
+	}
+
+	if w := do("POST", "/"+cenvID+"/documents", token, map[string]interface{}{"id": "notes/a", "content": "hello", "content_type": "text/plain"}); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create document: %d %s", w.Code, w.Body.String())
+	}
+
+	w := issue(token, "documents:read admin:none")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to issue token: %d %s", w.Code, w.Body.String())
+	}
+	var resp TokenResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.TokenType != "Bearer" || resp.ExpiresIn != 3600 || resp.Scope != "documents:read admin:none" {
+		t.Errorf("Unexpected token response: %s", w.Body.String())
+	}
+	scoped := resp.AccessToken
+
+	t.Run("AllowedScope", func(t *testing.T) {
+		if w := do("GET", "/"+cenvID+"/documents/notes/a", scoped, nil); w.Code != http.StatusOK {
+			t.Errorf("Expected read to be allowed, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("MissingScope", func(t *testing.T) {
+		w := do("POST", "/"+cenvID+"/documents", scoped, map[string]interface{}{"id": "notes/b", "content": "x", "content_type": "text/plain"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected write to be refused, got %d", w.Code)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Bearer error="insufficient_scope", scope="documents:write"` {
+			t.Errorf("Unexpected challenge: %s", got)
+		}
+
+		if w := do("GET", "/"+cenvID+"/admin/config", scoped, nil); w.Code != http.StatusForbidden {
+			t.Errorf("Expected admin route to be refused, got %d", w.Code)
+		}
+	})
+
+	t.Run("RouteGroups", func(t *testing.T) {
+		w := issue(token, "documents:read account:read")
+		var resp TokenResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		for _, path := range []string{"/tags", "/collections", "/flags", "/me/sessions"} {
+			if w := do("GET", "/"+cenvID+path, resp.AccessToken, nil); w.Code != http.StatusOK {
+				t.Errorf("Expected %s to be allowed, got %d %s", path, w.Code, w.Body.String())
+			}
+		}
+		for _, path := range []string{"/flags", "/me/sessions"} {
+			if w := do("GET", "/"+cenvID+path, scoped, nil); w.Code != http.StatusForbidden {
+				t.Errorf("Expected %s to need account:read, got %d", path, w.Code)
+			}
+		}
+	})
+
+	t.Run("QueryToken", func(t *testing.T) {
+		// The debugger takes its token from the query, after the middleware
+		w := issue(token, "admin:read")
+		var resp TokenResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		req := httptest.NewRequest("GET", "/"+cenvID+"/admin/endpoints/missing/debug?token="+resp.AccessToken, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected the scope to be checked for ?token=, got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("NoTokenExchange", func(t *testing.T) {
+		if w := issue(scoped, "documents:read"); w.Code != http.StatusForbidden {
+			t.Errorf("Expected scoped token to be refused, got %d", w.Code)
+		}
+		// Also refused when the handler is reached without the middleware
+		req := httptest.NewRequest("POST", "/"+cenvID+"/token", bytes.NewReader([]byte(`{"scope":"documents:read"}`)))
+		signRequest(req, scoped, []byte(`{"scope":"documents:read"}`))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected handler to refuse scoped token, got %d", w.Code)
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		if w := issue(token, "documents:delete"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected unknown scope to be rejected, got %d", w.Code)
+		}
+		w := do("POST", "/"+cenvID+"/token", token, map[string]interface{}{"scope": "documents:read", "expires_in": -1})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected invalid lifetime to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnscopedToken", func(t *testing.T) {
+		if w := do("GET", "/"+cenvID+"/admin/config", token, nil); w.Code != http.StatusOK {
+			t.Errorf("Expected login token to be unrestricted, got %d", w.Code)
+		}
+	})
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, path, scope string
+	}{
+		{"GET", "/documents/a/b", "documents:read"},
+		{"DELETE", "/documents/a", "documents:write"},
+		{"GET", "/documents/search", "documents:read"},
+		{"POST", "/api/tables", "tables:write"},
+		{"GET", "/api/tables/t", "tables:read"},
+		{"POST", "/star/hook", "star:execute"},
+		{"GET", "/pages/home", "pages:read"},
+		{"POST", "/templates/preview", "admin:write"},
+		{"GET", "/ext/partner/x", "proxy:use"},
+		{"GET", "/admin/audit", "admin:read"},
+		{"PUT", "/admin/config/x", "admin:write"},
+		{"GET", "/admin/endpoints/e/debug", "admin:write"},
+		{"POST", "/tags/x/rename", "documents:write"},
+		{"GET", "/collections/c/items", "documents:read"},
+		{"PUT", "/collections/c", "documents:write"},
+		{"GET", "/flags", "account:read"},
+		{"GET", "/me/sessions", "account:read"},
+		{"POST", "/me/password", "account:write"},
+		{"POST", "/token", ""},
+		{"POST", "/login", ""},
+		{"GET", "/", ""},
+	}
+
+	for _, tt := range tests {
+		if got := requiredScope(tt.method, tt.path); got != tt.scope {
+			t.Errorf("requiredScope(%s %s) = %q, want %q", tt.method, tt.path, got, tt.scope)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// withTransaction runs fn in one transaction bound to the request, for
+// handlers whose writes span several statements. It commits when fn returns
+// nil and rolls back when fn returns an error, panics or the client goes
+// away, so the request leaves all of its writes or none. Document functions
+// given the transaction join it; work with side effects outside the
+// database, such as queueing scans, belongs after the commit.
+func withTransaction(r *http.Request, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after a commit
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/authz"
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// UserResource is the read-back form of a user. Every field accepted by
+// PUT is returned so declarative clients can detect drift.
+type UserResource struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Email     string `json:"email"`
+	Enabled   bool   `json:"enabled"`
+	CreatedAt int64  `json:"created_at"`
+	InvitedBy string `json:"invited_by,omitempty"`
+	LastLogin int64  `json:"last_login,omitempty"`
+}
+
+// PutUserRequest is the desired state of a user. Password is required when
+// the user is created and optional afterwards.
+type PutUserRequest struct {
+	Role     string `json:"role"`
+	Email    string `json:"email"`
+	Enabled  *bool  `json:"enabled"` // Defaults to true
+	Password string `json:"password,omitempty"`
+}
+
+func userResource(user *auth.User) UserResource {
+	return UserResource{
+		UserID:    user.UserID,
+		Username:  user.Username,
+		Role:      user.Role,
+		Email:     user.Email,
+		Enabled:   user.Enabled,
+		CreatedAt: user.CreatedAt,
+		InvitedBy: user.InvitedBy,
+		LastLogin: user.LastLogin,
+	}
+}
+
+// requireUserManager authenticates the request and requires the owner or admin role.
+// Returns (userID, role, db, error)
+func (s *Server) requireUserManager(w http.ResponseWriter, r *http.Request, action string) (string, string, *sql.DB, error) {
+	cenvID := r.PathValue("cenvID")
+	if !cenv.IsValidUUID(cenvID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid cenv id"})
+		return "", "", nil, fmt.Errorf("invalid cenv id")
+	}
+
+	userID, role, db, err := s.requireAuth(w, r, cenvID)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if role != authz.RoleOwner && role != authz.RoleAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "only owner or admin can " + action,
+		})
+		return "", "", nil, fmt.Errorf("insufficient role: %s", role)
+	}
+
+	return userID, role, db, nil
+}
+
+// handleListUsers lists all users of the cenv (admin/owner only)
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, _, db, err := s.requireUserManager(w, r, "list users")
+	if err != nil {
+		return // Response already sent
+	}
+
+	users, err := auth.ListUsers(db)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	resources := make([]UserResource, 0, len(users))
+	for i := range users {
+		resources = append(resources, userResource(&users[i]))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": resources,
+	})
+}
+
+// handleGetUser returns a single user by username (admin/owner only)
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, _, db, err := s.requireUserManager(w, r, "view users")
+	if err != nil {
+		return // Response already sent
+	}
+
+	user, err := auth.GetUserByUsername(db, r.PathValue("username"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(userResource(user))
+}
+
+// handlePutUser creates or updates a user to match the request (admin/owner only).
+// Repeating the same request leaves the user unchanged. Responds 201 when the
+// user was created and 200 otherwise, with the full user in both cases.
+func (s *Server) handlePutUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	callerID, callerRole, db, err := s.requireUserManager(w, r, "manage users")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, callerID, "put_user", "user", r.PathValue("username")); err != nil {
+		return // Response already sent
+	}
+
+	username := r.PathValue("username")
+
+	var req PutUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if !auth.IsValidRole(req.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "role must be 'owner', 'admin', 'editor', or 'viewer'",
+		})
+		return
+	}
+	if req.Password != "" && len(req.Password) < 8 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "password must be at least 8 characters",
+		})
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	existing, err := auth.GetUserByUsername(db, username)
+	if err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+		writeTableError(w, err)
+		return
+	}
+
+	// The owner is fixed at cenv creation: nobody else can become owner, and
+	// the owner account keeps its role and stays enabled
+	isOwner := existing != nil && existing.Role == authz.RoleOwner
+	if (req.Role == authz.RoleOwner) != isOwner || (isOwner && !enabled) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "the owner role cannot be assigned, removed or disabled",
+		})
+		return
+	}
+	if isOwner && callerRole != authz.RoleOwner {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "only the owner can change the owner account",
+		})
+		return
+	}
+
+	if existing == nil {
+		if !isValidUsername(username) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "username must be 1-64 characters of letters, digits, '.', '_' or '-'",
+			})
+			return
+		}
+		if req.Password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "password is required to create a user",
+			})
+			return
+		}
+
+		user, err := auth.CreateUser(db, username, req.Password, req.Role, req.Email, callerID)
+		if err == nil && !enabled {
+			err = auth.UpdateUser(db, user.UserID, user.Role, user.Email, false)
+			user.Enabled = false
+		}
+		if err != nil {
+			log.Printf("Failed to create user %s: %v", username, err)
+			writeTableError(w, err)
+			return
+		}
+
+		s.recordRoleChange(db, r.PathValue("cenvID"), callerID, username, "", user.Role)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(userResource(user))
+		return
+	}
+
+	if existing.UserID == callerID && (req.Role != existing.Role || !enabled) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "you cannot change your own role or disable yourself",
+		})
+		return
+	}
+
+	if err := auth.UpdateUser(db, existing.UserID, req.Role, req.Email, enabled); err != nil {
+		writeTableError(w, err)
+		return
+	}
+	s.recordRoleChange(db, r.PathValue("cenvID"), callerID, username, existing.Role, req.Role)
+	if req.Password != "" {
+		if err := auth.SetPassword(db, existing, req.Password); err != nil {
+			writeTableError(w, err)
+			return
+		}
+	}
+
+	user, err := auth.GetUserByID(db, existing.UserID)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(userResource(user))
+}
+
+// handleDeleteUser deletes a user (admin/owner only). The owner and the
+// caller cannot be deleted.
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	callerID, _, db, err := s.requireUserManager(w, r, "delete users")
+	if err != nil {
+		return // Response already sent
+	}
+
+	if err := s.verifyAdminRequest(w, r, db, callerID, "delete_user", "user", r.PathValue("username")); err != nil {
+		return // Response already sent
+	}
+
+	user, err := auth.GetUserByUsername(db, r.PathValue("username"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+
+	if user.Role == authz.RoleOwner || user.UserID == callerID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "the owner and your own account cannot be deleted",
+		})
+		return
+	}
+
+	if err := auth.DeleteUser(db, user.UserID); err != nil {
+		if errors.Is(err, auth.ErrUserReferenced) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		writeTableError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "user deleted successfully",
+	})
+}
+
+// isValidUsername reports whether name is usable in a /admin/users/{username} path
+func isValidUsername(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestUserResources(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("POST /{cenvID}/auth/refresh", srv.handleRefreshToken)
+	mux.HandleFunc("GET /{cenvID}/admin/users", srv.handleListUsers)
+	mux.HandleFunc("GET /{cenvID}/admin/users/{username}", srv.handleGetUser)
+	mux.HandleFunc("PUT /{cenvID}/admin/users/{username}", srv.handlePutUser)
+	mux.HandleFunc("DELETE /{cenvID}/admin/users/{username}", srv.handleDeleteUser)
+	mux.HandleFunc("GET /{cenvID}/admin/permissions/{userID}/{table}", srv.handleGetPermission)
+	mux.HandleFunc("PUT /{cenvID}/admin/permissions/{userID}/{table}", srv.handlePutPermission)
+	mux.HandleFunc("DELETE /{cenvID}/admin/permissions/{userID}/{table}", srv.handleDeletePermission)
+
+	cenvID, token := setupTestCenv(t, mux)
+	base := "/" + cenvID + "/admin/users/"
+
+	desired := map[string]interface{}{
+		"role": "editor", "email": "bob@example.com", "password": "bobpass123",
+	}
+
+	var created UserResource
+	w := doJSON(t, mux, "PUT", base+"bob", token, desired)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	if created.UserID == "" || created.Role != "editor" || !created.Enabled {
+		t.Fatalf("Unexpected user: %+v", created)
+	}
+
+	t.Run("IdempotentPut", func(t *testing.T) {
+		bobToken := loginAs(t, mux, cenvID, "bob", "bobpass123")
+
+		var again UserResource
+		w := doJSON(t, mux, "PUT", base+"bob", token, desired)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		json.NewDecoder(w.Body).Decode(&again)
+		again.LastLogin = 0 // Set by the login above
+		if again != created {
+			t.Errorf("Expected identical read-back, got %+v want %+v", again, created)
+		}
+
+		// Resending the same password must not log the user out
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/users", bobToken, nil); w.Code != http.StatusForbidden {
+			t.Errorf("Expected bob's session to survive as a non-admin, got %d", w.Code)
+		}
+	})
+
+	t.Run("DemotionRevokesSessions", func(t *testing.T) {
+		if w := doJSON(t, mux, "PUT", base+"dave", token, map[string]interface{}{"role": "admin", "password": "davepass123"}); w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var login LoginResponse
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{"username": "dave", "password": "davepass123"})
+		json.NewDecoder(w.Body).Decode(&login)
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/users", login.Token, nil); w.Code != http.StatusOK {
+			t.Fatalf("Expected dave to act as admin, got %d", w.Code)
+		}
+
+		if w := doJSON(t, mux, "PUT", base+"dave", token, map[string]interface{}{"role": "viewer"}); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/users", login.Token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the admin token to stop working after demotion, got %d", w.Code)
+		}
+		w = doJSON(t, mux, "POST", "/"+cenvID+"/auth/refresh", "", map[string]string{"refresh_token": login.RefreshToken})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the refresh token to stop working after demotion, got %d", w.Code)
+		}
+	})
+
+	t.Run("ReadBack", func(t *testing.T) {
+		var got UserResource
+		w := doJSON(t, mux, "GET", base+"bob", token, nil)
+		json.NewDecoder(w.Body).Decode(&got)
+		if w.Code != http.StatusOK || got.Email != "bob@example.com" {
+			t.Errorf("Unexpected read-back: %d %+v", w.Code, got)
+		}
+		if w := doJSON(t, mux, "GET", base+"nobody", token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("OwnerProtected", func(t *testing.T) {
+		if w := doJSON(t, mux, "PUT", base+"carol", token, map[string]interface{}{"role": "owner", "password": "carolpass123"}); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected owner role to be refused, got %d", w.Code)
+		}
+		if w := doJSON(t, mux, "PUT", base+"admin", token, map[string]interface{}{"role": "admin"}); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected owner demotion to be refused, got %d", w.Code)
+		}
+		if w := doJSON(t, mux, "DELETE", base+"admin", token, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected owner deletion to be refused, got %d", w.Code)
+		}
+	})
+
+	t.Run("Permission", func(t *testing.T) {
+		path := "/" + cenvID + "/admin/permissions/" + created.UserID + "/orders"
+		if w := doJSON(t, mux, "GET", path, token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 before grant, got %d", w.Code)
+		}
+
+		for i := 0; i < 2; i++ {
+			w := doJSON(t, mux, "PUT", path, token, map[string]bool{"can_read": true})
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+		}
+
+		var perm GrantPermissionRequest
+		w := doJSON(t, mux, "GET", path, token, nil)
+		json.NewDecoder(w.Body).Decode(&perm)
+		if !perm.CanRead || perm.CanWrite || perm.TableName != "orders" {
+			t.Errorf("Unexpected permission: %+v", perm)
+		}
+
+		if w := doJSON(t, mux, "PUT", "/"+cenvID+"/admin/permissions/missing/orders", token, map[string]bool{"can_read": true}); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for unknown user, got %d", w.Code)
+		}
+
+		if w := doJSON(t, mux, "DELETE", path, token, nil); w.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", w.Code)
+		}
+		if w := doJSON(t, mux, "DELETE", path, token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on second delete, got %d", w.Code)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if w := doJSON(t, mux, "DELETE", base+"bob", token, nil); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := doJSON(t, mux, "GET", base+"bob", token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after delete, got %d", w.Code)
+		}
+	})
+}
+
+func TestPlan(t *testing.T) {
+	manager := cenv.NewManager(t.TempDir())
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	mux.HandleFunc("PUT /{cenvID}/admin/users/{username}", srv.handlePutUser)
+	mux.HandleFunc("POST /{cenvID}/admin/endpoints", srv.handleCreateEndpoint)
+	mux.HandleFunc("PUT /{cenvID}/admin/config/{key}", srv.handleSetConfig)
+	mux.HandleFunc("GET /{cenvID}/admin/cenv", srv.handleGetCenv)
+	mux.HandleFunc("POST /{cenvID}/admin/plan", srv.handlePlan)
+
+	cenvID, token := setupTestCenv(t, mux)
+
+	doJSON(t, mux, "PUT", "/"+cenvID+"/admin/users/bob", token, map[string]interface{}{"role": "viewer", "password": "bobpass123"})
+	doJSON(t, mux, "PUT", "/"+cenvID+"/admin/users/dave", token, map[string]interface{}{"role": "viewer", "password": "davepass123"})
+	doJSON(t, mux, "PUT", "/"+cenvID+"/admin/config/slow_query_ms", token, map[string]string{"value": "150"})
+
+	helloScript := "def handle_request(req):\n    return response(\"hi\")"
+	w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/endpoints", token, map[string]interface{}{
+		"path": "/hello", "method": "GET", "script": helloScript,
+	})
+	var deployed struct {
+		ID int64 `json:"id"`
+	}
+	json.NewDecoder(w.Body).Decode(&deployed)
+	if w.Code != http.StatusCreated || deployed.ID == 0 {
+		t.Fatalf("Expected endpoint id in response, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("Cenv", func(t *testing.T) {
+		var got struct {
+			CenvID string            `json:"cenv_id"`
+			Owner  string            `json:"owner"`
+			Config map[string]string `json:"config"`
+		}
+		w := doJSON(t, mux, "GET", "/"+cenvID+"/admin/cenv", token, nil)
+		json.NewDecoder(w.Body).Decode(&got)
+		if got.CenvID != cenvID || got.Owner != "admin" || got.Config["slow_query_ms"] != "150" {
+			t.Errorf("Unexpected cenv read-back: %+v", got)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/plan", token, map[string]interface{}{
+			"config": map[string]string{"slow_query_ms": "250", "site_title": "Docs"},
+			"users": []map[string]interface{}{
+				{"username": "bob", "role": "editor"},
+				{"username": "erin", "role": "viewer"},
+			},
+			"endpoints": []map[string]interface{}{
+				{"path": "/hello", "method": "GET", "script": helloScript},
+			},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var result plan
+		json.NewDecoder(w.Body).Decode(&result)
+
+		got := map[string]string{}
+		for _, change := range result.Changes {
+			got[change.Resource+":"+change.Key] = change.Action
+		}
+		want := map[string]string{
+			"config:site_title":    PlanCreate,
+			"config:slow_query_ms": PlanUpdate,
+			"user:bob":             PlanUpdate,
+			"user:erin":            PlanCreate,
+			"user:dave":            PlanDelete,
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+		for key, action := range want {
+			if got[key] != action {
+				t.Errorf("Expected %s to %s, got %q", key, action, got[key])
+			}
+		}
+		if result.Summary.Unchanged != 1 {
+			t.Errorf("Expected the endpoint to be unchanged, got %+v", result.Summary)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		w := doJSON(t, mux, "POST", "/"+cenvID+"/admin/plan", token, map[string]interface{}{
+			"users": []map[string]interface{}{{"username": "bob", "role": "superuser"}},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+}
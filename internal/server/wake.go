@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/cluster"
+)
+
+// wakeRetrySeconds is how long clients are asked to wait for a restore
+const wakeRetrySeconds = 5
+
+// wakeMiddleware records use of each cenv, deferring its archiving, and
+// restores archived cenvs on their next request. Restoring runs in the
+// background; until it finishes clients get a 503 "waking up" response.
+func (s *Server) wakeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cenvID, _, ok := cenv.ParsePath(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.cenvManager.IsArchived(cenvID) {
+			s.cenvManager.Touch(cenvID)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.wake(cenvID)
+		renderWakingPage(w, r)
+	})
+}
+
+// wake starts restoring an archived cenv unless a restore is already running.
+// With a coordinator, the instance holding the cenv's write lease restores it.
+func (s *Server) wake(cenvID string) {
+	if _, running := s.waking.LoadOrStore(cenvID, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer s.waking.Delete(cenvID)
+
+		if s.coordinator != nil {
+			if _, err := s.coordinator.Claim(cenvID); err != nil {
+				if !errors.Is(err, cluster.ErrLeaseHeld) {
+					log.Printf("Failed to claim write lease for cenv %s: %v", cenvID, err)
+				}
+				return
+			}
+		}
+
+		start := time.Now()
+		if err := s.cenvManager.Restore(cenvID); err != nil {
+			log.Printf("Failed to restore archived cenv %s: %v", cenvID, err)
+			return
+		}
+		log.Printf("Restored archived cenv %s in %v", cenvID, time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// wakingPage tells browsers the cenv is being restored and reloads
+var wakingPage = template.Must(template.New("waking").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta http-equiv="refresh" content="{{.}}"><title>Waking up</title></head>
+<body>
+<h1>Waking up</h1>
+<p>This environment was archived after a period of inactivity and is being restored. The page reloads in {{.}} seconds.</p>
+</body>
+</html>
+`))
+
+// renderWakingPage answers a request for a cenv being restored: an HTML page
+// for browsers, JSON otherwise
+func renderWakingPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(wakeRetrySeconds))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		wakingPage.Execute(w, wakeRetrySeconds)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":  "cenv is waking up from archive, retry shortly",
+		"status": "waking",
+	})
+}
+
+// archiveIdleCenvs moves cenvs idle for the registry's archive policy into
+// cold storage. With a coordinator, cenvs whose write lease another instance
+// holds are left to it. Returns the failures, after archiving every cenv it
+// can.
+func (s *Server) archiveIdleCenvs() error {
+	idle, err := s.cenvManager.IdleCenvs(time.Now())
+	if err != nil {
+		log.Printf("Failed to find idle cenvs: %v", err)
+		return fmt.Errorf("failed to find idle cenvs: %w", err)
+	}
+
+	var failures []error
+	for _, cenvID := range idle {
+		if s.coordinator != nil {
+			if _, err := s.coordinator.Claim(cenvID); err != nil {
+				if !errors.Is(err, cluster.ErrLeaseHeld) {
+					log.Printf("Failed to claim write lease for cenv %s: %v", cenvID, err)
+					failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+				}
+				continue
+			}
+		}
+
+		if err := s.cenvManager.Archive(cenvID); err != nil {
+			log.Printf("Failed to archive idle cenv %s: %v", cenvID, err)
+			failures = append(failures, fmt.Errorf("cenv %s: %w", cenvID, err))
+			continue
+		}
+		log.Printf("Archived idle cenv %s", cenvID)
+	}
+
+	return errors.Join(failures...)
+}
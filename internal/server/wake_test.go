@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+func TestWakeArchivedCenv(t *testing.T) {
+	storageDir := t.TempDir()
+	registry := `{"archive": {"path": "` + filepath.Join(t.TempDir(), "cold") + `", "idle_days": 1}}`
+	if err := os.WriteFile(filepath.Join(storageDir, cenv.RegistryFile), []byte(registry), 0600); err != nil {
+		t.Fatalf("Failed to write registry: %v", err)
+	}
+
+	manager := cenv.NewManager(storageDir)
+	if err := manager.LoadRegistry(); err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	srv := New(0, manager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /new", srv.handleNewCenv)
+	mux.HandleFunc("POST /{cenvID}/login", srv.handleLogin)
+	cenvID, _ := setupTestCenv(t, mux)
+	handler := srv.wakeMiddleware(mux)
+
+	// A cenv in use is not idle
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+cenvID+"/", nil))
+	srv.archiveIdleCenvs()
+	if manager.IsArchived(cenvID) {
+		t.Fatal("Expected a cenv in use not to be archived")
+	}
+
+	if err := manager.Archive(cenvID); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/"+cenvID+"/", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected 503 with Retry-After, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Waking up") {
+		t.Errorf("Expected waking up page, got %s", w.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for manager.IsArchived(cenvID) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if manager.IsArchived(cenvID) {
+		t.Fatal("Expected the cenv to be restored")
+	}
+
+	w = doJSON(t, mux, "POST", "/"+cenvID+"/login", "", map[string]string{
+		"username": "admin", "password": "adminpass123",
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected login to a restored cenv to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,117 @@
+package share
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thetanil/wce/internal/cenv"
+)
+
+// RemoteToken is a share token another cenv issued to this one.
+// The token itself is never returned once stored.
+type RemoteToken struct {
+	ID         int64  `json:"id"`
+	SourceCenv string `json:"source_cenv"`
+	CreatedAt  int64  `json:"created_at"`
+	CreatedBy  string `json:"created_by,omitempty"`
+}
+
+// AddRemoteToken stores a token issued by a source cenv
+func AddRemoteToken(db *sql.DB, sourceCenv, token, userID string) (*RemoteToken, error) {
+	if !cenv.IsValidUUID(sourceCenv) {
+		return nil, fmt.Errorf("invalid source cenv id: %s", sourceCenv)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token cannot be empty")
+	}
+
+	var createdBy interface{}
+	if userID != "" {
+		createdBy = userID
+	}
+
+	now := time.Now().Unix()
+	result, err := db.Exec(`
+		INSERT INTO _wce_remote_tokens (source_cenv, token, created_at, created_by)
+		VALUES (?, ?, ?, ?)
+	`, sourceCenv, token, now, createdBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, fmt.Errorf("token already exists for cenv %s", sourceCenv)
+		}
+		return nil, fmt.Errorf("failed to store remote token: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return &RemoteToken{ID: id, SourceCenv: sourceCenv, CreatedAt: now, CreatedBy: userID}, nil
+}
+
+// ListRemoteTokens lists stored tokens without their values
+func ListRemoteTokens(db *sql.DB) ([]RemoteToken, error) {
+	rows, err := db.Query(`
+		SELECT id, source_cenv, created_at, created_by
+		FROM _wce_remote_tokens
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote tokens: %w", err)
+	}
+	defer rows.Close()
+
+	remotes := []RemoteToken{}
+	for rows.Next() {
+		var remote RemoteToken
+		var createdBy sql.NullString
+		if err := rows.Scan(&remote.ID, &remote.SourceCenv, &remote.CreatedAt, &createdBy); err != nil {
+			return nil, fmt.Errorf("failed to scan remote token: %w", err)
+		}
+		remote.CreatedBy = createdBy.String
+		remotes = append(remotes, remote)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating remote tokens: %w", err)
+	}
+
+	return remotes, nil
+}
+
+// DeleteRemoteToken removes a stored token
+func DeleteRemoteToken(db *sql.DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM _wce_remote_tokens WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete remote token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("remote token not found: %d", id)
+	}
+
+	return nil
+}
+
+// Tokens returns the stored tokens for a source cenv
+func Tokens(db *sql.DB, sourceCenv string) ([]string, error) {
+	rows, err := db.Query(`SELECT token FROM _wce_remote_tokens WHERE source_cenv = ?`, sourceCenv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load remote tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return nil, fmt.Errorf("failed to scan remote token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
@@ -0,0 +1,344 @@
+// Package share lets one cenv grant another cenv's scripts read access to a
+// single table or document prefix.
+//
+// The sharing cenv creates a share for a grantee cenv and receives a token.
+// The grantee's admin stores that token in the grantee cenv, and the server
+// presents it when a grantee script calls remote.query or remote.document.
+// Scripts never see the token itself.
+package share
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/cenv"
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/sqlite"
+	"github.com/thetanil/wce/internal/tables"
+)
+
+// Share scope types
+const (
+	ScopeTable     = "table"
+	ScopeDocuments = "documents"
+)
+
+// MaxRows bounds the result size of a remote query
+const MaxRows = 1000
+
+// Share is a grant of read access to another cenv
+type Share struct {
+	ID          int64  `json:"id"`
+	GranteeCenv string `json:"grantee_cenv"`
+	ScopeType   string `json:"scope_type"`
+	Scope       string `json:"scope"`
+	CreatedAt   int64  `json:"created_at"`
+	CreatedBy   string `json:"created_by,omitempty"`
+	ExpiresAt   *int64 `json:"expires_at,omitempty"`
+	RevokedAt   *int64 `json:"revoked_at,omitempty"`
+}
+
+// Access is what a grantee may read in a sharing cenv, combined from its shares
+type Access struct {
+	tables   map[string]bool // Lowercase table names
+	prefixes []string
+}
+
+// CreateShare grants a grantee cenv read access to a table or document prefix.
+// It returns the share and its token; only a hash of the token is stored.
+func CreateShare(db *sql.DB, granteeCenv, scopeType, scope, userID string, expiresAt int64) (*Share, string, error) {
+	if !cenv.IsValidUUID(granteeCenv) {
+		return nil, "", fmt.Errorf("invalid grantee cenv id: %s", granteeCenv)
+	}
+
+	switch scopeType {
+	case ScopeTable:
+		// Shares name tables by their declared spelling
+		schema, err := tables.DescribeTable(db, scope)
+		if err != nil {
+			return nil, "", err
+		}
+		scope = schema.Name
+	case ScopeDocuments:
+		if scope == "" {
+			return nil, "", fmt.Errorf("document prefix cannot be empty")
+		}
+	default:
+		return nil, "", fmt.Errorf("scope type must be '%s' or '%s'", ScopeTable, ScopeDocuments)
+	}
+
+	now := clock.Now().Unix()
+	if expiresAt != 0 && expiresAt <= now {
+		return nil, "", fmt.Errorf("expiry must be in the future")
+	}
+
+	token, err := auth.GenerateSessionID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var expires interface{}
+	if expiresAt != 0 {
+		expires = expiresAt
+	}
+	var createdBy interface{}
+	if userID != "" {
+		createdBy = userID
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO _wce_shares (grantee_cenv, scope_type, scope, token_hash, created_at, created_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, granteeCenv, scopeType, scope, auth.GetTokenHash(token), now, createdBy, expires)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create share: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	share := &Share{
+		ID:          id,
+		GranteeCenv: granteeCenv,
+		ScopeType:   scopeType,
+		Scope:       scope,
+		CreatedAt:   now,
+		CreatedBy:   userID,
+	}
+	if expiresAt != 0 {
+		share.ExpiresAt = &expiresAt
+	}
+
+	return share, token, nil
+}
+
+// ListShares lists the shares granted by a cenv, including revoked ones
+func ListShares(db *sql.DB) ([]Share, error) {
+	rows, err := db.Query(`
+		SELECT id, grantee_cenv, scope_type, scope, created_at, created_by, expires_at, revoked_at
+		FROM _wce_shares
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares: %w", err)
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *share)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating shares: %w", err)
+	}
+
+	return shares, nil
+}
+
+// RevokeShare revokes a share; its token stops working immediately
+func RevokeShare(db *sql.DB, id int64) error {
+	result, err := db.Exec(`
+		UPDATE _wce_shares SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL
+	`, clock.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("share not found: %d", id)
+	}
+
+	return nil
+}
+
+// Authenticate resolves the access a grantee cenv has through the given
+// tokens. Tokens for revoked, expired or other grantees' shares are ignored.
+func Authenticate(db *sql.DB, granteeCenv string, tokens []string) (*Access, error) {
+	access := &Access{tables: make(map[string]bool)}
+	now := clock.Now().Unix()
+
+	for _, token := range tokens {
+		rows, err := db.Query(`
+			SELECT id, grantee_cenv, scope_type, scope, created_at, created_by, expires_at, revoked_at
+			FROM _wce_shares
+			WHERE token_hash = ? AND grantee_cenv = ? AND revoked_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > ?)
+		`, auth.GetTokenHash(token), granteeCenv, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check share token: %w", err)
+		}
+
+		for rows.Next() {
+			share, err := scanShare(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			switch share.ScopeType {
+			case ScopeTable:
+				access.tables[strings.ToLower(share.Scope)] = true
+			case ScopeDocuments:
+				access.prefixes = append(access.prefixes, share.Scope)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating shares: %w", err)
+		}
+	}
+
+	return access, nil
+}
+
+// scanShare scans a share row selected in the standard column order
+func scanShare(scanner interface{ Scan(...interface{}) error }) (*Share, error) {
+	var share Share
+	var createdBy sql.NullString
+	var expiresAt, revokedAt sql.NullInt64
+
+	err := scanner.Scan(&share.ID, &share.GranteeCenv, &share.ScopeType, &share.Scope,
+		&share.CreatedAt, &createdBy, &expiresAt, &revokedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan share: %w", err)
+	}
+
+	share.CreatedBy = createdBy.String
+	if expiresAt.Valid {
+		share.ExpiresAt = &expiresAt.Int64
+	}
+	if revokedAt.Valid {
+		share.RevokedAt = &revokedAt.Int64
+	}
+
+	return &share, nil
+}
+
+// CanReadTable reports whether the access includes a table
+func (a *Access) CanReadTable(table string) bool {
+	return a.tables[strings.ToLower(table)]
+}
+
+// CanReadDocument reports whether a document id falls under a shared prefix
+func (a *Access) CanReadDocument(id string) bool {
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether the access grants nothing
+func (a *Access) Empty() bool {
+	return len(a.tables) == 0 && len(a.prefixes) == 0
+}
+
+// Query runs a read-only query against the sharing cenv. SQLite's authorizer
+// rejects the statement at prepare time if it reads anything other than the
+// shared tables, so views, joins and subqueries cannot widen access.
+func Query(ctx context.Context, db *sql.DB, access *Access, query string, params []interface{}) ([]string, [][]interface{}, error) {
+	if len(access.tables) == 0 {
+		return nil, nil, fmt.Errorf("no tables are shared with this cenv")
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return nil, nil, fmt.Errorf("failed to set query_only: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+
+	err = sqlite.SetAuthorizer(conn, func(op int, arg1, arg2, arg3 string) int {
+		switch op {
+		case sqlite.AuthSelect, sqlite.AuthFunction:
+			return sqlite.AuthOK
+		case sqlite.AuthRead:
+			if arg3 == "main" && access.CanReadTable(arg1) {
+				return sqlite.AuthOK
+			}
+		}
+		return sqlite.AuthDeny
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// The connection returns to the pool, so it must not keep the authorizer
+	defer sqlite.SetAuthorizer(conn, nil)
+
+	rows, err := conn.QueryContext(ctx, query, params...)
+	if err != nil {
+		if strings.Contains(err.Error(), "not authorized") {
+			return nil, nil, fmt.Errorf("query reads outside the shared tables")
+		}
+		return nil, nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("query error: %w", err)
+	}
+
+	var result [][]interface{}
+	for rows.Next() {
+		if len(result) == MaxRows {
+			return nil, nil, fmt.Errorf("query returned more than %d rows", MaxRows)
+		}
+
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, nil, fmt.Errorf("query error: %w", err)
+		}
+		result = append(result, values)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return columns, result, nil
+}
+
+// GetDocument reads a document from the sharing cenv if it is under a shared
+// prefix. Binary documents are only returned once the scanner has cleared them.
+func GetDocument(db *sql.DB, access *Access, id string) (*document.Document, error) {
+	if !access.CanReadDocument(id) {
+		return nil, fmt.Errorf("document is not shared with this cenv: %s", id)
+	}
+
+	doc, err := document.GetDocument(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.IsBinary {
+		scanRecord, err := document.GetScanRecord(db, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check scan status: %w", err)
+		}
+		if !scanRecord.IsServable() {
+			return nil, fmt.Errorf("document is %s", scanRecord.Status)
+		}
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,198 @@
+package share
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+const (
+	granteeCenv = "11111111-1111-4111-8111-111111111111"
+	otherCenv   = "22222222-2222-4222-8222-222222222222"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	_, err = conn.Exec(`
+		INSERT INTO _wce_users (user_id, username, password_hash, role, created_at)
+		VALUES ('user-1', 'admin', 'x', 'owner', 0);
+		CREATE TABLE stock (sku TEXT PRIMARY KEY, qty INTEGER);
+		CREATE TABLE secrets (value TEXT);
+		CREATE VIEW secret_view AS SELECT value FROM secrets;
+		INSERT INTO stock VALUES ('A-1', 3), ('B-2', 5);
+		INSERT INTO secrets VALUES ('hunter2');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	return conn
+}
+
+func TestQuery(t *testing.T) {
+	if !sqlite.AuthorizerSupported {
+		t.Skip("the SQLite driver built in does not support authorizers")
+	}
+	conn := setupTestDB(t)
+	ctx := context.Background()
+
+	_, token, err := CreateShare(conn, granteeCenv, ScopeTable, "STOCK", "user-1", 0)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+
+	access, err := Authenticate(conn, granteeCenv, []string{token})
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	columns, rows, err := Query(ctx, conn, access, "SELECT sku, qty FROM stock WHERE qty > ? ORDER BY sku", []interface{}{1})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(columns) != 2 || len(rows) != 2 || rows[1][0] != "B-2" {
+		t.Errorf("Unexpected result: %v %v", columns, rows)
+	}
+
+	denied := []string{
+		"SELECT * FROM secrets",
+		"SELECT s.sku, x.value FROM stock s, secrets x",
+		"SELECT sku FROM stock WHERE sku IN (SELECT value FROM secrets)",
+		"SELECT * FROM secret_view",
+		"SELECT * FROM _wce_users",
+		"DELETE FROM stock",
+		"INSERT INTO stock VALUES ('C-3', 1)",
+	}
+	for _, query := range denied {
+		if _, _, err := Query(ctx, conn, access, query, nil); err == nil {
+			t.Errorf("Expected %q to be denied", query)
+		}
+	}
+
+	// The pooled connection must not keep the authorizer or read-only mode
+	var value string
+	if err := conn.QueryRow(`SELECT value FROM secrets`).Scan(&value); err != nil {
+		t.Errorf("Connection still restricted after query: %v", err)
+	}
+	if _, err := conn.Exec(`INSERT INTO stock VALUES ('C-3', 1)`); err != nil {
+		t.Errorf("Connection still read-only after query: %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	conn := setupTestDB(t)
+
+	if _, _, err := CreateShare(conn, granteeCenv, ScopeTable, "missing", "user-1", 0); err == nil {
+		t.Error("Expected error sharing a missing table")
+	}
+	if _, _, err := CreateShare(conn, granteeCenv, ScopeTable, "_wce_users", "user-1", 0); err == nil {
+		t.Error("Expected error sharing a reserved table")
+	}
+	if _, _, err := CreateShare(conn, granteeCenv, ScopeDocuments, "", "user-1", 0); err == nil {
+		t.Error("Expected error sharing an empty prefix")
+	}
+
+	revoked, revokedToken, _ := CreateShare(conn, granteeCenv, ScopeTable, "stock", "user-1", 0)
+	if err := RevokeShare(conn, revoked.ID); err != nil {
+		t.Fatalf("RevokeShare failed: %v", err)
+	}
+	if err := RevokeShare(conn, revoked.ID); err == nil {
+		t.Error("Expected error revoking twice")
+	}
+
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	defer clock.Set(fake)()
+	_, expiredToken, _ := CreateShare(conn, granteeCenv, ScopeTable, "stock", "user-1", fake.Now().Unix()+60)
+	fake.Advance(time.Minute)
+
+	_, otherToken, _ := CreateShare(conn, otherCenv, ScopeTable, "stock", "user-1", 0)
+
+	access, err := Authenticate(conn, granteeCenv, []string{revokedToken, expiredToken, otherToken, "bogus"})
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if !access.Empty() {
+		t.Error("Expected revoked, expired and foreign tokens to grant nothing")
+	}
+
+	_, docToken, _ := CreateShare(conn, granteeCenv, ScopeDocuments, "public/", "user-1", 0)
+	access, _ = Authenticate(conn, granteeCenv, []string{docToken})
+	if !access.CanReadDocument("public/readme.md") || access.CanReadDocument("private/notes") {
+		t.Error("Expected prefix access to cover only public/")
+	}
+	if _, _, err := Query(context.Background(), conn, access, "SELECT * FROM stock", nil); err == nil {
+		t.Error("Expected query to fail without a table share")
+	}
+
+	shares, err := ListShares(conn)
+	if err != nil || len(shares) != 4 {
+		t.Fatalf("Expected 4 shares, got %d (%v)", len(shares), err)
+	}
+	if shares[0].RevokedAt == nil {
+		t.Error("Expected first share to be revoked")
+	}
+}
+
+func TestGetDocument(t *testing.T) {
+	conn := setupTestDB(t)
+
+	document.CreateDocument(conn, "public/readme.md", "hello", "text/markdown", "user-1", false, false)
+	document.CreateDocument(conn, "private/notes", "secret", "text/plain", "user-1", false, false)
+
+	_, token, _ := CreateShare(conn, granteeCenv, ScopeDocuments, "public/", "user-1", 0)
+	access, _ := Authenticate(conn, granteeCenv, []string{token})
+
+	doc, err := GetDocument(conn, access, "public/readme.md")
+	if err != nil || doc.Content != "hello" {
+		t.Fatalf("Expected shared document, got %v (%v)", doc, err)
+	}
+
+	if _, err := GetDocument(conn, access, "private/notes"); err == nil || !strings.Contains(err.Error(), "not shared") {
+		t.Errorf("Expected unshared document to be refused, got %v", err)
+	}
+}
+
+func TestRemoteTokens(t *testing.T) {
+	conn := setupTestDB(t)
+
+	remote, err := AddRemoteToken(conn, otherCenv, "token-a", "user-1")
+	if err != nil {
+		t.Fatalf("AddRemoteToken failed: %v", err)
+	}
+	if _, err := AddRemoteToken(conn, otherCenv, "token-a", "user-1"); err == nil {
+		t.Error("Expected duplicate token to be rejected")
+	}
+	if _, err := AddRemoteToken(conn, "not-a-uuid", "token-b", "user-1"); err == nil {
+		t.Error("Expected invalid cenv id to be rejected")
+	}
+
+	tokens, err := Tokens(conn, otherCenv)
+	if err != nil || len(tokens) != 1 || tokens[0] != "token-a" {
+		t.Errorf("Unexpected tokens: %v (%v)", tokens, err)
+	}
+
+	if err := DeleteRemoteToken(conn, remote.ID); err != nil {
+		t.Fatalf("DeleteRemoteToken failed: %v", err)
+	}
+	remotes, _ := ListRemoteTokens(conn)
+	if len(remotes) != 0 {
+		t.Errorf("Expected no remote tokens, got %d", len(remotes))
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !purego
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName is the database/sql driver SQLite databases are opened with
+const DriverName = "sqlite3"
+
+// ForeignKeysParam is the DSN query parameter that enables foreign keys on
+// every pooled connection
+const ForeignKeysParam = "_foreign_keys=on"
+
+// AuthorizerSupported reports whether SetAuthorizer can restrict statements
+const AuthorizerSupported = true
+
+// SetAuthorizer installs (or with nil, removes) a SQLite authorizer on a
+// connection. The callback gets an action code and its arguments and
+// returns AuthOK or AuthDeny.
+func SetAuthorizer(conn *sql.Conn, callback func(int, string, string, string) int) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection: %T", driverConn)
+		}
+		sqliteConn.RegisterAuthorizer(callback)
+		return nil
+	})
+}
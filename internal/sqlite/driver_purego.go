@@ -0,0 +1,25 @@
+//go:build purego
+
+package sqlite
+
+import (
+	"database/sql"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver
+)
+
+// DriverName is the database/sql driver SQLite databases are opened with
+const DriverName = "sqlite"
+
+// ForeignKeysParam is the DSN query parameter that enables foreign keys on
+// every pooled connection
+const ForeignKeysParam = "_pragma=foreign_keys(1)"
+
+// AuthorizerSupported reports whether SetAuthorizer can restrict statements
+const AuthorizerSupported = false
+
+// SetAuthorizer returns ErrNoAuthorizer: modernc.org/sqlite does not expose
+// sqlite3_set_authorizer, so statements that need one are refused
+func SetAuthorizer(conn *sql.Conn, callback func(int, string, string, string) int) error {
+	return ErrNoAuthorizer
+}
@@ -0,0 +1,26 @@
+// Package sqlite selects the SQLite database/sql driver WCE is built with.
+// The default is github.com/mattn/go-sqlite3, which needs CGO and the fts5
+// build tag for document search. The purego build tag selects
+// modernc.org/sqlite instead, a translation of SQLite to Go with FTS5
+// built in, so the server cross-compiles with CGO disabled:
+//
+//	CGO_ENABLED=0 go build -tags purego ./cmd/wce
+//
+// Packages open databases with DriverName and ForeignKeysParam rather than
+// importing a driver, so both builds behave the same.
+package sqlite
+
+import "errors"
+
+// ErrNoAuthorizer is returned by SetAuthorizer when the driver cannot
+// restrict statements, so callers relying on it fail closed
+var ErrNoAuthorizer = errors.New("the SQLite driver built in does not support authorizers")
+
+// Authorizer action codes and results, as numbered by SQLite
+const (
+	AuthOK       = 0
+	AuthDeny     = 1
+	AuthRead     = 20
+	AuthSelect   = 21
+	AuthFunction = 31
+)
@@ -0,0 +1,115 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/auth"
+	wcedb "github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+// These tests only reach SQLite through DriverName, so running them with and
+// without the purego tag checks both drivers behave the same:
+//
+//	go test -tags fts5 ./internal/sqlite
+//	CGO_ENABLED=0 go test -tags purego ./internal/sqlite
+
+func openCenv(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(sqlite.DriverName, path+"?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(wcedb.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	user, err := auth.CreateUser(db, "owner", "ownerpass123", "owner", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return db, user.UserID
+}
+
+func TestForeignKeys(t *testing.T) {
+	db, _ := openCenv(t)
+
+	var enabled int
+	if err := db.QueryRow(`PRAGMA foreign_keys`).Scan(&enabled); err != nil || enabled != 1 {
+		t.Errorf("Expected foreign keys enabled by the DSN, got %d (%v)", enabled, err)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	db, _ := openCenv(t)
+
+	var mode string
+	if err := db.QueryRow(`PRAGMA journal_mode = WAL`).Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("Expected WAL journaling, got %q (%v)", mode, err)
+	}
+}
+
+func TestDocumentSearch(t *testing.T) {
+	db, userID := openCenv(t)
+
+	docs := map[string]string{
+		"go.md":     "Go is a programming language designed at Google",
+		"rust.md":   "Rust is a systems programming language",
+		"python.md": "Python is a language; programming in Python is fun. Python!",
+		"cafe.md":   "Notes from the café about naïve résumé parsing",
+	}
+	for id, content := range docs {
+		if _, err := document.CreateDocument(db, id, content, "text/markdown", userID, false, true); err != nil {
+			t.Fatalf("Failed to create %s: %v", id, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string // Sorted ids
+	}{
+		{"programming", []string{"go.md", "python.md", "rust.md"}},
+		{"programming language", []string{"go.md", "python.md", "rust.md"}},
+		{`"systems programming"`, []string{"rust.md"}},
+		{"prog*", []string{"go.md", "python.md", "rust.md"}},
+		{"go OR rust", []string{"go.md", "rust.md"}},
+		{"language NOT python", []string{"go.md", "rust.md"}},
+		{"content:google", []string{"go.md"}},
+		{"CAFÉ", []string{"cafe.md"}},
+		{"résumé", []string{"cafe.md"}},
+		{"haskell", nil},
+	}
+	for _, tt := range tests {
+		results, err := document.SearchDocuments(db, tt.query, 10)
+		if err != nil {
+			t.Errorf("Search %q failed: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.ID)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Search %q: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	// The search index follows updates and deletes
+	if _, err := document.UpdateDocument(db, "go.md", "Go has goroutines", userID); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if err := document.DeleteDocument(db, "rust.md", userID); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	results, err := document.SearchDocuments(db, "programming", 10)
+	if err != nil || len(results) != 1 || results[0].ID != "python.md" {
+		t.Errorf("Expected the index to follow writes, got %v (%v)", results, err)
+	}
+}
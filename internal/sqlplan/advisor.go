@@ -0,0 +1,505 @@
+package sqlplan
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SlowQuery summarizes the recorded executions of one SQL statement
+type SlowQuery struct {
+	SQL   string  `json:"sql"`
+	Calls int     `json:"calls"`
+	AvgMS float64 `json:"avg_ms"`
+	MaxMS float64 `json:"max_ms"`
+}
+
+// Suggestion is a proposed index for a user table
+type Suggestion struct {
+	Table     string   `json:"table"`
+	Columns   []string `json:"columns"`
+	CreateSQL string   `json:"create_sql"`
+	Reason    string   `json:"reason"`
+	Queries   []string `json:"queries"`
+	Calls     int      `json:"calls"`
+	TotalMS   float64  `json:"total_ms"`
+}
+
+// userTable describes a user table and the leading columns of its indexes
+type userTable struct {
+	name    string
+	columns map[string]string // Lowercase name -> declared name
+	indexes [][]string        // Lowercase column lists
+}
+
+// Advise suggests indexes for user tables that the given slow queries scan in full.
+//
+// The heuristic looks for tables the query plan scans without an index and
+// proposes an index on the columns the statement compares for equality,
+// followed by at most one range column or, failing that, the ORDER BY columns.
+// Internal _wce_ tables and statements that no longer compile are skipped.
+// Suggestions are ordered by the total time of the queries they would help.
+func Advise(db *sql.DB, queries []SlowQuery) ([]Suggestion, error) {
+	tables, err := loadUserTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	byCreateSQL := make(map[string]*Suggestion)
+	var order []string
+
+	for _, q := range queries {
+		plan, err := Explain(db, q.SQL, nil)
+		if err != nil {
+			continue
+		}
+
+		for _, candidate := range suggestForQuery(q.SQL, plan, tables) {
+			existing, ok := byCreateSQL[candidate.CreateSQL]
+			if !ok {
+				suggestion := candidate
+				existing = &suggestion
+				byCreateSQL[candidate.CreateSQL] = existing
+				order = append(order, candidate.CreateSQL)
+			}
+			existing.Queries = append(existing.Queries, q.SQL)
+			existing.Calls += q.Calls
+			existing.TotalMS += q.AvgMS * float64(q.Calls)
+		}
+	}
+
+	suggestions := make([]Suggestion, 0, len(order))
+	for _, createSQL := range order {
+		suggestions = append(suggestions, *byCreateSQL[createSQL])
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].TotalMS > suggestions[j].TotalMS
+	})
+
+	return suggestions, nil
+}
+
+// loadUserTables reads the columns and indexes of every user table, keyed by lowercase name
+func loadUserTables(db *sql.DB) (map[string]*userTable, error) {
+	rows, err := db.Query(`
+		SELECT name FROM sqlite_master
+		WHERE type = 'table'
+		AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		AND name NOT LIKE '\_wce\_%' ESCAPE '\'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tables: %w", err)
+	}
+
+	tables := make(map[string]*userTable, len(names))
+	for _, name := range names {
+		table := &userTable{name: name, columns: make(map[string]string)}
+
+		columns, err := queryStrings(db, `SELECT name FROM pragma_table_info(?)`, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range columns {
+			table.columns[strings.ToLower(column)] = column
+		}
+
+		indexes, err := queryStrings(db, `SELECT name FROM pragma_index_list(?)`, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			indexColumns, err := queryStrings(db, `SELECT name FROM pragma_index_info(?) ORDER BY seqno`, index)
+			if err != nil {
+				return nil, err
+			}
+			for i := range indexColumns {
+				indexColumns[i] = strings.ToLower(indexColumns[i])
+			}
+			table.indexes = append(table.indexes, indexColumns)
+		}
+
+		tables[strings.ToLower(name)] = table
+	}
+
+	return tables, nil
+}
+
+// queryStrings runs a query returning a single text column
+func queryStrings(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to inspect schema: %w", err)
+		}
+		// Expression index columns have no name
+		values = append(values, value.String)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+
+	return values, nil
+}
+
+// hasIndexPrefix reports whether an existing index already leads with the given columns
+func (t *userTable) hasIndexPrefix(columns []string) bool {
+	for _, index := range t.indexes {
+		if len(index) < len(columns) {
+			continue
+		}
+		match := true
+		for i, column := range columns {
+			if index[i] != strings.ToLower(column) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestForQuery proposes indexes for the full table scans in one statement's plan
+func suggestForQuery(query string, plan []PlanStep, tables map[string]*userTable) []Suggestion {
+	tokens := tokenize(query)
+	sources := tableSources(tokens)
+
+	sorts := false
+	for _, step := range plan {
+		if strings.HasPrefix(step.Detail, "USE TEMP B-TREE FOR ORDER BY") {
+			sorts = true
+		}
+	}
+
+	var suggestions []Suggestion
+	for _, step := range plan {
+		scanned, ok := scannedName(step.Detail)
+		if !ok {
+			continue
+		}
+
+		tableName, ok := sources[strings.ToLower(scanned)]
+		if !ok {
+			tableName = scanned
+		}
+		table, ok := tables[strings.ToLower(tableName)]
+		if !ok {
+			continue
+		}
+
+		scope := newColumnScope(table, scanned, sources, tables)
+		equality, ranged := predicateColumns(tokens, scope)
+
+		columns := equality
+		reason := ""
+		switch {
+		case len(ranged) > 0:
+			columns = append(columns, ranged[0])
+			reason = "full table scan filtering on " + strings.Join(columns, ", ")
+		case sorts:
+			ordering := orderByColumns(tokens, scope)
+			if len(ordering) > 0 {
+				columns = appendUnique(columns, ordering...)
+				if len(equality) > 0 {
+					reason = "full table scan filtering on " + strings.Join(equality, ", ") +
+						" and sorting by " + strings.Join(ordering, ", ")
+				} else {
+					reason = "full table scan sorting by " + strings.Join(ordering, ", ")
+				}
+			}
+		}
+		if reason == "" && len(equality) > 0 {
+			reason = "full table scan filtering on " + strings.Join(equality, ", ")
+		}
+
+		if len(columns) == 0 || table.hasIndexPrefix(columns) {
+			continue
+		}
+
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = quoteIdent(column)
+		}
+		indexName := "idx_" + strings.ToLower(table.name) + "_" + strings.ToLower(strings.Join(columns, "_"))
+
+		suggestions = append(suggestions, Suggestion{
+			Table:   table.name,
+			Columns: columns,
+			CreateSQL: fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+				quoteIdent(indexName), quoteIdent(table.name), strings.Join(quoted, ", ")),
+			Reason: reason,
+		})
+	}
+
+	return suggestions
+}
+
+// scannedName extracts the table or alias from a full scan plan step such as
+// "SCAN orders" (or "SCAN TABLE orders" on older SQLite versions).
+// Scans that use an index, subqueries and virtual tables are ignored.
+func scannedName(detail string) (string, bool) {
+	if !strings.HasPrefix(detail, "SCAN ") {
+		return "", false
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(detail, "SCAN "))
+	if len(fields) > 1 && fields[0] == "TABLE" {
+		fields = fields[1:]
+	}
+	if len(fields) == 3 && fields[1] == "AS" {
+		fields = fields[2:]
+	}
+	if len(fields) != 1 || fields[0] == "CONSTANT" || strings.HasPrefix(fields[0], "(") {
+		return "", false
+	}
+
+	return fields[0], true
+}
+
+// tableSources maps the lowercase names and aliases a statement reads from to table names
+func tableSources(tokens []token) map[string]string {
+	sources := make(map[string]string)
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if !(tok.is("FROM") || tok.is("JOIN") || tok.is("UPDATE") || tok.is("INTO")) {
+			continue
+		}
+
+		for j := i + 1; j < len(tokens) && tokens[j].isIdent(); {
+			name := tokens[j].text
+			j++
+
+			// schema.table
+			if j+1 < len(tokens) && tokens[j].is(".") && tokens[j+1].isIdent() {
+				name = tokens[j+1].text
+				j += 2
+			}
+			sources[strings.ToLower(name)] = name
+
+			if j < len(tokens) && tokens[j].is("AS") {
+				j++
+			}
+			if j < len(tokens) && tokens[j].isIdent() {
+				sources[strings.ToLower(tokens[j].text)] = name
+				j++
+			}
+
+			// Comma-separated FROM lists
+			if !tok.is("FROM") || j >= len(tokens) || !tokens[j].is(",") {
+				break
+			}
+			j++
+		}
+	}
+
+	return sources
+}
+
+// columnScope decides which column references in a statement belong to a scanned table
+type columnScope struct {
+	table     *userTable
+	qualifier string          // Lowercase table name or alias used in the plan
+	ambiguous map[string]bool // Lowercase columns also present in other referenced tables
+}
+
+// newColumnScope builds the scope for a table scanned under the given name
+func newColumnScope(table *userTable, scanned string, sources map[string]string, tables map[string]*userTable) *columnScope {
+	scope := &columnScope{
+		table:     table,
+		qualifier: strings.ToLower(scanned),
+		ambiguous: make(map[string]bool),
+	}
+
+	for name, source := range sources {
+		other, ok := tables[strings.ToLower(source)]
+		if !ok || name == scope.qualifier {
+			continue
+		}
+		// The table's own name is recorded alongside its alias, but a
+		// second alias for the same table (a self-join) is ambiguous
+		if other == table && name == strings.ToLower(table.name) {
+			continue
+		}
+		for column := range other.columns {
+			scope.ambiguous[column] = true
+		}
+	}
+
+	return scope
+}
+
+// columnRef parses a possibly qualified column reference at tokens[i],
+// returning the column's declared name and the index of the following token
+func (s *columnScope) columnRef(tokens []token, i int) (string, int, bool) {
+	if !tokens[i].isIdent() {
+		return "", i + 1, false
+	}
+
+	if i+2 < len(tokens) && tokens[i+1].is(".") && tokens[i+2].isIdent() {
+		column, ok := s.table.columns[strings.ToLower(tokens[i+2].text)]
+		return column, i + 3, ok && strings.ToLower(tokens[i].text) == s.qualifier
+	}
+
+	if i > 0 && tokens[i-1].is(".") {
+		return "", i + 1, false
+	}
+
+	name := strings.ToLower(tokens[i].text)
+	column, ok := s.table.columns[name]
+	return column, i + 1, ok && !s.ambiguous[name]
+}
+
+// comparison classifies a token as an equality or range comparison
+func comparison(tok token) (equality bool, ranged bool) {
+	switch {
+	case tok.is("=") || tok.is("==") || tok.is("IN") || tok.is("IS"):
+		return true, false
+	case tok.is("<") || tok.is("<=") || tok.is(">") || tok.is(">=") || tok.is("BETWEEN"):
+		return false, true
+	}
+	return false, false
+}
+
+// predicateColumns collects the table's columns compared in WHERE and ON clauses
+func predicateColumns(tokens []token, scope *columnScope) ([]string, []string) {
+	var equality, ranged []string
+	inPredicate := false
+
+	for i := 0; i < len(tokens); {
+		tok := tokens[i]
+
+		switch {
+		case tok.is("WHERE") || tok.is("ON"):
+			inPredicate = true
+			i++
+			continue
+		case tok.is("SELECT") || tok.is("GROUP") || tok.is("ORDER") || tok.is("LIMIT") ||
+			tok.is("HAVING") || tok.is("RETURNING") || tok.is("SET") || tok.is("VALUES") ||
+			tok.is("UNION") || tok.is("EXCEPT") || tok.is("INTERSECT") || tok.is("WINDOW"):
+			inPredicate = false
+			i++
+			continue
+		}
+
+		if !inPredicate {
+			i++
+			continue
+		}
+
+		column, next, ok := scope.columnRef(tokens, i)
+		if !ok {
+			i = next
+			continue
+		}
+
+		// Compared on either side: "col = ?" or "? = col"
+		var isEq, isRange bool
+		if next < len(tokens) {
+			isEq, isRange = comparison(tokens[next])
+		}
+		if !isEq && !isRange && i > 0 {
+			isEq, isRange = comparison(tokens[i-1])
+		}
+
+		switch {
+		case isEq:
+			equality = appendUnique(equality, column)
+		case isRange:
+			ranged = appendUnique(ranged, column)
+		}
+		i = next
+	}
+
+	// A column compared for equality is a better index prefix than a range
+	var filtered []string
+	for _, column := range ranged {
+		if !containsFold(equality, column) {
+			filtered = append(filtered, column)
+		}
+	}
+
+	return equality, filtered
+}
+
+// orderByColumns returns the ORDER BY columns when every term is a plain column of the table
+func orderByColumns(tokens []token, scope *columnScope) []string {
+	start := -1
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].is("ORDER") && tokens[i+1].is("BY") {
+			start = i + 2
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	var columns []string
+	for i := start; i < len(tokens); {
+		if tokens[i].is("LIMIT") || tokens[i].is(")") {
+			break
+		}
+
+		column, next, ok := scope.columnRef(tokens, i)
+		if !ok {
+			return nil
+		}
+		columns = appendUnique(columns, column)
+		i = next
+
+		if i < len(tokens) && (tokens[i].is("ASC") || tokens[i].is("DESC")) {
+			i++
+		}
+		if i < len(tokens) && tokens[i].is(",") {
+			i++
+		}
+	}
+
+	return columns
+}
+
+// appendUnique appends values not already present, ignoring case
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		if !containsFold(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// containsFold reports whether list contains value, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+// Package sqlplan explains SQLite query plans and suggests missing indexes.
+package sqlplan
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PlanStep is a single row of SQLite's EXPLAIN QUERY PLAN output
+type PlanStep struct {
+	ID     int    `json:"id"`
+	Parent int    `json:"parent"`
+	Detail string `json:"detail"`
+}
+
+// Explain returns the query plan for a single SQL statement.
+// The statement is never executed; missing parameters are bound as NULL.
+func Explain(db *sql.DB, query string, params []interface{}) ([]PlanStep, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	if query == "" {
+		return nil, fmt.Errorf("sql cannot be empty")
+	}
+
+	tokens := tokenize(query)
+	for _, tok := range tokens {
+		if tok.kind == tokOp && tok.text == ";" {
+			return nil, fmt.Errorf("only a single statement can be explained")
+		}
+	}
+
+	ctx := context.Background()
+
+	// EXPLAIN never runs the statement, but a read-only connection guards
+	// against anything that slips past the single-statement check
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return nil, fmt.Errorf("failed to set read-only mode: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+
+	// Bind missing parameters as NULL so statements can be explained without values
+	var inputs int
+	err = conn.Raw(func(driverConn interface{}) error {
+		stmt, err := driverConn.(driver.Conn).Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		inputs = stmt.NumInput()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain statement: %w", err)
+	}
+	// Drivers that cannot tell how many parameters a statement takes
+	// report -1, so count them the way SQLite numbers them
+	if inputs < 0 {
+		inputs = countParams(tokens)
+	}
+	for len(params) < inputs {
+		params = append(params, nil)
+	}
+
+	rows, err := conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain statement: %w", err)
+	}
+	defer rows.Close()
+
+	steps := []PlanStep{}
+	for rows.Next() {
+		var step PlanStep
+		var unused int
+		if err := rows.Scan(&step.ID, &step.Parent, &unused, &step.Detail); err != nil {
+			return nil, fmt.Errorf("failed to scan plan step: %w", err)
+		}
+		steps = append(steps, step)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to explain statement: %w", err)
+	}
+
+	return steps, nil
+}
+
+// countParams returns the highest parameter number in a statement. A bare
+// ? takes the next number, ?NNN takes NNN and each distinct named
+// parameter takes the next number the first time it appears.
+func countParams(tokens []token) int {
+	count := 0
+	named := map[string]bool{}
+	for _, tok := range tokens {
+		if tok.kind != tokParam {
+			continue
+		}
+		switch {
+		case tok.text == "?":
+			count++
+		case tok.text[0] == '?':
+			if n, err := strconv.Atoi(tok.text[1:]); err == nil && n > count {
+				count = n
+			}
+		case !named[tok.text]:
+			named[tok.text] = true
+			count++
+		}
+	}
+	return count
+}
+
+// tokenKind classifies SQL tokens
+type tokenKind int
+
+const (
+	tokWord   tokenKind = iota // Keyword or bare identifier
+	tokQuoted                  // Quoted identifier
+	tokString                  // String or blob literal
+	tokNumber                  // Numeric literal
+	tokParam                   // Bound parameter
+	tokOp                      // Operator or punctuation
+)
+
+// token is a lexical element of a SQL statement
+type token struct {
+	kind tokenKind
+	text string // Identifier text is unquoted
+}
+
+// isIdent reports whether the token can name a table, alias or column
+func (t token) isIdent() bool {
+	return t.kind == tokQuoted || (t.kind == tokWord && !sqlKeywords[strings.ToUpper(t.text)])
+}
+
+// is reports whether the token is the given keyword or operator
+func (t token) is(text string) bool {
+	return (t.kind == tokWord || t.kind == tokOp) && strings.EqualFold(t.text, text)
+}
+
+// sqlKeywords are words that never act as identifiers in the advisor's heuristics
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true,
+	"CROSS": true, "NATURAL": true, "ON": true, "USING": true, "AS": true, "IN": true,
+	"IS": true, "NULL": true, "LIKE": true, "GLOB": true, "BETWEEN": true, "ORDER": true,
+	"BY": true, "GROUP": true, "HAVING": true, "LIMIT": true, "OFFSET": true, "ASC": true,
+	"DESC": true, "UNION": true, "ALL": true, "EXCEPT": true, "INTERSECT": true,
+	"INSERT": true, "INTO": true, "VALUES": true, "UPDATE": true, "SET": true,
+	"DELETE": true, "RETURNING": true, "WITH": true, "DISTINCT": true, "CASE": true,
+	"WHEN": true, "THEN": true, "ELSE": true, "END": true, "EXISTS": true, "COLLATE": true,
+	"INDEXED": true, "WINDOW": true, "OVER": true, "DEFAULT": true, "REPLACE": true,
+	"CONFLICT": true, "DO": true, "NOTHING": true, "ESCAPE": true,
+}
+
+// tokenize splits a SQL statement into tokens, dropping whitespace and comments
+func tokenize(query string) []token {
+	var tokens []token
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			var text strings.Builder
+			j := i + 1
+			for j < len(query) {
+				if query[j] == closing {
+					// Doubled quotes escape themselves
+					if closing != ']' && j+1 < len(query) && query[j+1] == closing {
+						text.WriteByte(closing)
+						j += 2
+						continue
+					}
+					break
+				}
+				text.WriteByte(query[j])
+				j++
+			}
+			kind := tokQuoted
+			if c == '\'' {
+				kind = tokString
+			}
+			tokens = append(tokens, token{kind: kind, text: text.String()})
+			i = j + 1
+
+		case c == '?' || c == ':' || c == '@' || c == '$':
+			j := i + 1
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokParam, text: query[i:j]})
+			i = j
+
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(query) && (isWordByte(query[j]) || query[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: query[i:j]})
+			i = j
+
+		case isWordByte(c):
+			j := i + 1
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			// x'...' is a blob literal
+			if j-i == 1 && (c == 'x' || c == 'X') && j < len(query) && query[j] == '\'' {
+				end := strings.IndexByte(query[j+1:], '\'')
+				if end < 0 {
+					return tokens
+				}
+				tokens = append(tokens, token{kind: tokString, text: query[j+1 : j+1+end]})
+				i = j + end + 2
+				continue
+			}
+			tokens = append(tokens, token{kind: tokWord, text: query[i:j]})
+			i = j
+
+		default:
+			op := operatorAt(query[i:])
+			tokens = append(tokens, token{kind: tokOp, text: op})
+			i += len(op)
+		}
+	}
+
+	return tokens
+}
+
+// operatorAt returns the operator at the start of s, preferring two-character operators
+func operatorAt(s string) string {
+	for _, op := range []string{"<=", ">=", "<>", "!=", "==", "||", "<<", ">>"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return s[:1]
+}
+
+// isWordByte reports whether c can appear in a bare identifier
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// quoteIdent quotes an identifier for use in generated SQL
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
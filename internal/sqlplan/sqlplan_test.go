@@ -0,0 +1,191 @@
+package sqlplan
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+		CREATE TABLE customers (id INTEGER PRIMARY KEY, email TEXT, region TEXT);
+		CREATE TABLE orders (
+			id INTEGER PRIMARY KEY,
+			customer_id INTEGER,
+			status TEXT,
+			total REAL,
+			created_at INTEGER
+		);
+		CREATE INDEX idx_customers_email ON customers(email);
+		CREATE TABLE _wce_internal (id INTEGER PRIMARY KEY, name TEXT);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	return db
+}
+
+func TestExplain(t *testing.T) {
+	db := setupTestDB(t)
+
+	plan, err := Explain(db, "SELECT * FROM customers WHERE email = ?;", nil)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(plan) != 1 || !strings.Contains(plan[0].Detail, "USING INDEX idx_customers_email") {
+		t.Errorf("Expected index search, got %+v", plan)
+	}
+
+	plan, err = Explain(db, "SELECT * FROM orders WHERE status = 'open'", nil)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if len(plan) != 1 || plan[0].Detail != "SCAN orders" {
+		t.Errorf("Expected full scan, got %+v", plan)
+	}
+}
+
+func TestExplain_DoesNotExecute(t *testing.T) {
+	db := setupTestDB(t)
+
+	if _, err := Explain(db, "DELETE FROM customers", nil); err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if _, err := Explain(db, "SELECT 1; DROP TABLE customers", nil); err == nil {
+		t.Error("Expected error for multiple statements")
+	}
+	if _, err := Explain(db, "SELECT ';' -- ;", nil); err != nil {
+		t.Errorf("Semicolons in literals and comments should be allowed: %v", err)
+	}
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'customers'`).Scan(&count)
+	if count != 1 {
+		t.Error("Explained statements must not run")
+	}
+}
+
+func TestCountParams(t *testing.T) {
+	for query, want := range map[string]int{
+		"SELECT 1":                          0,
+		"SELECT ?, ?":                       2,
+		"SELECT ?3, ?":                      4,
+		"SELECT :a, @b, :a, $c":             3,
+		"SELECT '?', ? -- ?":                1,
+		"SELECT * FROM t WHERE a = ?2 OR ?": 3,
+	} {
+		if got := countParams(tokenize(query)); got != want {
+			t.Errorf("countParams(%q) = %d, want %d", query, got, want)
+		}
+	}
+}
+
+func TestExplain_Invalid(t *testing.T) {
+	db := setupTestDB(t)
+
+	for _, query := range []string{"", "SELECT * FROM missing", "NOT SQL"} {
+		if _, err := Explain(db, query, nil); err == nil {
+			t.Errorf("Expected error for %q", query)
+		}
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	db := setupTestDB(t)
+
+	tests := []struct {
+		name    string
+		sql     string
+		table   string
+		columns []string
+	}{
+		{"Equality", "SELECT * FROM orders WHERE status = ?", "orders", []string{"status"}},
+		{"EqualityThenRange", "SELECT * FROM orders WHERE created_at > ? AND customer_id = ?", "orders", []string{"customer_id", "created_at"}},
+		{"Sort", "SELECT * FROM orders ORDER BY created_at DESC LIMIT 10", "orders", []string{"created_at"}},
+		{"EqualityThenSort", "SELECT * FROM orders WHERE status = 'open' ORDER BY created_at", "orders", []string{"status", "created_at"}},
+		{"Alias", "SELECT o.total FROM orders AS o WHERE o.customer_id = 7", "orders", []string{"customer_id"}},
+		{"Update", "UPDATE orders SET status = 'closed' WHERE created_at < ?", "orders", []string{"created_at"}},
+		{"Join", "SELECT * FROM customers c JOIN orders o ON o.customer_id = c.id WHERE c.region = 'eu'", "orders", []string{"customer_id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestions, err := Advise(db, []SlowQuery{{SQL: tt.sql, Calls: 1, AvgMS: 10}})
+			if err != nil {
+				t.Fatalf("Advise failed: %v", err)
+			}
+			if len(suggestions) != 1 {
+				t.Fatalf("Expected 1 suggestion, got %+v", suggestions)
+			}
+			if suggestions[0].Table != tt.table || !reflect.DeepEqual(suggestions[0].Columns, tt.columns) {
+				t.Errorf("Expected index on %s%v, got %s%v",
+					tt.table, tt.columns, suggestions[0].Table, suggestions[0].Columns)
+			}
+		})
+	}
+}
+
+func TestAdvise_NoSuggestion(t *testing.T) {
+	db := setupTestDB(t)
+
+	for _, query := range []string{
+		"SELECT * FROM customers WHERE email = ?", // Already indexed
+		"SELECT * FROM orders",                    // Needs every row
+		"SELECT * FROM orders WHERE id = 3",       // Primary key
+		"SELECT * FROM _wce_internal WHERE name = 'x'",
+		"SELECT * FROM dropped WHERE a = 1",
+	} {
+		suggestions, err := Advise(db, []SlowQuery{{SQL: query, Calls: 1, AvgMS: 10}})
+		if err != nil {
+			t.Fatalf("Advise failed: %v", err)
+		}
+		if len(suggestions) != 0 {
+			t.Errorf("Expected no suggestion for %q, got %+v", query, suggestions)
+		}
+	}
+}
+
+func TestAdvise_MergesAndRanks(t *testing.T) {
+	db := setupTestDB(t)
+
+	suggestions, err := Advise(db, []SlowQuery{
+		{SQL: "SELECT * FROM customers WHERE region = ?", Calls: 2, AvgMS: 5},
+		{SQL: "SELECT * FROM orders WHERE status = ?", Calls: 3, AvgMS: 20},
+		{SQL: "SELECT id FROM orders WHERE status = 'open'", Calls: 1, AvgMS: 40},
+	})
+	if err != nil {
+		t.Fatalf("Advise failed: %v", err)
+	}
+
+	if len(suggestions) != 2 {
+		t.Fatalf("Expected 2 suggestions, got %+v", suggestions)
+	}
+
+	first := suggestions[0]
+	if first.Table != "orders" || len(first.Queries) != 2 || first.Calls != 4 || first.TotalMS != 100 {
+		t.Errorf("Unexpected top suggestion: %+v", first)
+	}
+	if first.CreateSQL != `CREATE INDEX "idx_orders_status" ON "orders" ("status")` {
+		t.Errorf("Unexpected create statement: %s", first.CreateSQL)
+	}
+
+	// The suggested index must be valid and remove the scan
+	if _, err := db.Exec(first.CreateSQL); err != nil {
+		t.Fatalf("Suggested index failed to create: %v", err)
+	}
+	suggestions, _ = Advise(db, []SlowQuery{{SQL: "SELECT * FROM orders WHERE status = ?", Calls: 1, AvgMS: 1}})
+	if len(suggestions) != 0 {
+		t.Errorf("Expected no suggestion after creating index, got %+v", suggestions)
+	}
+}
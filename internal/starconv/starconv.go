@@ -0,0 +1,240 @@
+// Package starconv converts values between Go and Starlark, with the same
+// semantics for the script runtime and the template renderer.
+package starconv
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// MaxDepth bounds nesting when converting values, so a list that contains
+// itself fails instead of recursing forever
+const MaxDepth = 100
+
+// ToGo converts a Starlark value to a Go value. Ints become int64, or
+// *big.Int when they do not fit one, bytes []byte, tuples and sets lists,
+// and structs maps of their fields. Values that are not data, such as
+// functions, and dicts with non-string keys are an error rather than being
+// turned into strings.
+func ToGo(val starlark.Value) (interface{}, error) {
+	return toGo(val, 0, false)
+}
+
+// ToGoKeeping converts a Starlark value like ToGo, but keeps the values ToGo
+// rejects as Starlark values, which ToStarlark passes back unchanged. It
+// suits contexts that hold whatever a script or template computed, such as
+// template variables.
+func ToGoKeeping(val starlark.Value) interface{} {
+	value, _ := toGo(val, 0, true)
+	return value
+}
+
+// toGo converts val, returning it unchanged where it cannot be converted
+// when keep is set
+func toGo(val starlark.Value, depth int, keep bool) (interface{}, error) {
+	if depth > MaxDepth {
+		if keep {
+			return val, nil
+		}
+		return nil, fmt.Errorf("value is nested more than %d levels deep", MaxDepth)
+	}
+
+	switch v := val.(type) {
+	case nil, starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	case *starlark.List, starlark.Tuple, *starlark.Set:
+		result := []interface{}{}
+		iter := v.(starlark.Iterable).Iterate()
+		defer iter.Done()
+		var item starlark.Value
+		for iter.Next(&item) {
+			goItem, err := toGo(item, depth+1, keep)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, goItem)
+		}
+		return result, nil
+	case *starlark.Dict:
+		result := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				if keep {
+					return val, nil
+				}
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			value, err := toGo(item[1], depth+1, keep)
+			if err != nil {
+				return nil, err
+			}
+			result[string(key)] = value
+		}
+		return result, nil
+	case *starlarkstruct.Struct:
+		result := make(map[string]interface{})
+		for _, name := range v.AttrNames() {
+			attr, err := v.Attr(name)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toGo(attr, depth+1, keep)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = value
+		}
+		return result, nil
+	default:
+		if keep {
+			return val, nil
+		}
+		return nil, fmt.Errorf("cannot convert %s to a Go value", val.Type())
+	}
+}
+
+// ToStarlark converts a Go value to a Starlark value. Starlark values pass
+// through unchanged, every integer type and *big.Int becomes an int, []byte
+// bytes, json.Number an int or float as written, time.Time an RFC 3339
+// string, and any slice, array or string-keyed map (such as http.Header) a
+// list or dict. Other types are an error rather than being formatted as
+// strings.
+func ToStarlark(val interface{}) (starlark.Value, error) {
+	return toStarlark(val, 0)
+}
+
+func toStarlark(val interface{}, depth int) (starlark.Value, error) {
+	if depth > MaxDepth {
+		return nil, fmt.Errorf("value is nested more than %d levels deep", MaxDepth)
+	}
+
+	switch v := val.(type) {
+	case nil:
+		return starlark.None, nil
+	case starlark.Value:
+		return v, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []byte:
+		return starlark.Bytes(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case *big.Int:
+		if v == nil {
+			return starlark.None, nil
+		}
+		return starlark.MakeBigInt(v), nil
+	case json.Number:
+		return numberToStarlark(v)
+	case time.Time:
+		return starlark.String(v.Format(time.RFC3339Nano)), nil
+	case []interface{}:
+		list := make([]starlark.Value, len(v))
+		for i, item := range v {
+			value, err := toStarlark(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return starlark.NewList(list), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			value, err := toStarlark(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(key), value)
+		}
+		return dict, nil
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return starlark.Bool(rv.Bool()), nil
+	case reflect.String:
+		return starlark.String(rv.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return starlark.MakeUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(rv.Float()), nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return starlark.None, nil
+		}
+		return toStarlark(rv.Elem().Interface(), depth+1)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return starlark.NewList(nil), nil
+		}
+		list := make([]starlark.Value, rv.Len())
+		for i := range list {
+			value, err := toStarlark(rv.Index(i).Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return starlark.NewList(list), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %T to a Starlark value: keys must be strings", val)
+		}
+		dict := starlark.NewDict(rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			value, err := toStarlark(iter.Value().Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(iter.Key().String()), value)
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to a Starlark value", val)
+}
+
+// numberToStarlark converts a JSON number to an int when it is written as
+// one, however large, and to a float otherwise
+func numberToStarlark(n json.Number) (starlark.Value, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return starlark.MakeInt64(i), nil
+	}
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		return starlark.MakeBigInt(i), nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", n)
+	}
+	return starlark.Float(f), nil
+}
@@ -0,0 +1,209 @@
+package starconv
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// goValue is a random tree of the Go values the conversion layer produces
+type goValue struct {
+	v interface{}
+}
+
+func (goValue) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(goValue{randomGoValue(r, size, 0)})
+}
+
+func randomGoValue(r *rand.Rand, size, depth int) interface{} {
+	kinds := 10
+	if depth >= 3 {
+		kinds = 8 // Leaves only
+	}
+	switch r.Intn(kinds) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 1
+	case 2:
+		return r.Int63() - r.Int63()
+	case 3:
+		// Beyond int64 either way
+		n := new(big.Int).Lsh(big.NewInt(r.Int63()+1), uint(64+r.Intn(64)))
+		if r.Intn(2) == 1 {
+			n.Neg(n)
+		}
+		return n
+	case 4:
+		return r.NormFloat64() * math.Pow(10, float64(r.Intn(20)))
+	case 5:
+		return randomString(r, size)
+	case 6:
+		b := make([]byte, r.Intn(size+1))
+		r.Read(b)
+		return b
+	case 7:
+		return randomString(r, size) + "\x00\xff"
+	case 8:
+		list := make([]interface{}, r.Intn(4))
+		for i := range list {
+			list[i] = randomGoValue(r, size, depth+1)
+		}
+		return list
+	default:
+		dict := map[string]interface{}{}
+		for i := r.Intn(4); i > 0; i-- {
+			dict[randomString(r, size)] = randomGoValue(r, size, depth+1)
+		}
+		return dict
+	}
+}
+
+func randomString(r *rand.Rand, size int) string {
+	runes := []rune("aZ09 _-\"'\\<>&é日本🙂\n")
+	s := make([]rune, r.Intn(size+1))
+	for i := range s {
+		s[i] = runes[r.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+func TestConvert_GoRoundTrip(t *testing.T) {
+	roundTrip := func(value goValue) bool {
+		sv, err := ToStarlark(value.v)
+		if err != nil {
+			t.Logf("ToStarlark(%#v): %v", value.v, err)
+			return false
+		}
+		back, err := ToGo(sv)
+		if err != nil {
+			t.Logf("ToGo(%s): %v", sv, err)
+			return false
+		}
+		if !reflect.DeepEqual(back, value.v) {
+			t.Logf("Round trip of %#v gave %#v", value.v, back)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConvert_StarlarkRoundTrip(t *testing.T) {
+	roundTrip := func(value goValue) bool {
+		sv, err := ToStarlark(value.v)
+		if err != nil {
+			return false
+		}
+		back, err := ToGo(sv)
+		if err != nil {
+			return false
+		}
+		again, err := ToStarlark(back)
+		if err != nil {
+			return false
+		}
+		equal, err := starlark.Equal(sv, again)
+		if err != nil || !equal {
+			t.Logf("Round trip of %s gave %s", sv, again)
+			return false
+		}
+		return sv.Type() == again.Type()
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConvert_GoTypes(t *testing.T) {
+	when := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{int32(-7), "-7"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{float32(1.5), "1.5"},
+		{[]string{"a", "b"}, `["a", "b"]`},
+		{http.Header{"Accept": {"text/html", "application/json"}}, `{"Accept": ["text/html", "application/json"]}`},
+		{map[string]int{"n": 1}, `{"n": 1}`},
+		{[]byte("raw"), `b"raw"`},
+		{when, `"2025-03-01T12:30:00Z"`},
+		{(*big.Int)(nil), "None"},
+	}
+	for _, tt := range tests {
+		got, err := ToStarlark(tt.in)
+		if err != nil {
+			t.Errorf("ToStarlark(%#v) failed: %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ToStarlark(%#v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []interface{}{struct{}{}, func() {}, map[int]string{1: "a"}, make(chan int)} {
+		if got, err := ToStarlark(in); err == nil {
+			t.Errorf("Expected %T not to convert, got %s", in, got)
+		}
+	}
+}
+
+func TestConvert_Unconvertible(t *testing.T) {
+	cyclic := starlark.NewList(nil)
+	cyclic.Append(cyclic)
+	intKeys := starlark.NewDict(1)
+	intKeys.SetKey(starlark.MakeInt(1), starlark.String("a"))
+
+	for _, value := range []starlark.Value{
+		starlark.NewBuiltin("f", nil),
+		intKeys,
+		cyclic,
+	} {
+		if got, err := ToGo(value); err == nil {
+			t.Errorf("Expected %s not to convert, got %#v", value.Type(), got)
+		}
+	}
+
+	// Tuples, sets and structs are data
+	set := starlark.NewSet(2)
+	set.Insert(starlark.String("x"))
+	for _, value := range []starlark.Value{starlark.Tuple{starlark.MakeInt(1)}, set} {
+		if _, err := ToGo(value); err != nil {
+			t.Errorf("Expected %s to convert: %v", value.Type(), err)
+		}
+	}
+}
+
+func TestToGoKeeping(t *testing.T) {
+	fn := starlark.NewBuiltin("f", nil)
+	intKeys := starlark.NewDict(1)
+	intKeys.SetKey(starlark.MakeInt(1), starlark.String("a"))
+	list := starlark.NewList([]starlark.Value{starlark.MakeInt(1), fn, intKeys})
+
+	got, ok := ToGoKeeping(list).([]interface{})
+	if !ok || len(got) != 3 {
+		t.Fatalf("Expected a list of 3, got %#v", ToGoKeeping(list))
+	}
+	if got[0] != int64(1) || got[1] != fn || got[2] != intKeys {
+		t.Errorf("Expected data converted and the rest kept, got %#v", got)
+	}
+
+	// Kept values convert back unchanged
+	back, err := ToStarlark(got)
+	if err != nil {
+		t.Fatalf("ToStarlark failed: %v", err)
+	}
+	if equal, err := starlark.Equal(back, list); err != nil || !equal {
+		t.Errorf("Expected %s, got %s", list, back)
+	}
+}
@@ -0,0 +1,88 @@
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Capability names for optional builtins a script may be granted
+const (
+	CapabilityDBWrite = "db_write" // db.execute and writes through db.query
+	CapabilityHTTP    = "http"     // outbound HTTP requests
+	CapabilityMail    = "mail"     // sending mail
+	CapabilityKV      = "kv"       // key-value store access
+	CapabilityRemote  = "remote"   // reading data other cenvs have shared
+)
+
+// AllCapabilities lists every known capability
+var AllCapabilities = []string{CapabilityDBWrite, CapabilityHTTP, CapabilityMail, CapabilityKV, CapabilityRemote}
+
+// Capabilities is the set of capabilities granted to a script.
+// A nil set is unrestricted.
+type Capabilities map[string]bool
+
+// ParseCapabilities builds a capability set from names, rejecting unknown names
+func ParseCapabilities(names []string) (Capabilities, error) {
+	caps := make(Capabilities, len(names))
+	for _, name := range names {
+		if !isKnownCapability(name) {
+			return nil, fmt.Errorf("unknown capability: %s", name)
+		}
+		caps[name] = true
+	}
+	return caps, nil
+}
+
+// DecodeCapabilities parses a JSON array of capability names as stored in the database
+func DecodeCapabilities(data string) (Capabilities, error) {
+	var names []string
+	if err := json.Unmarshal([]byte(data), &names); err != nil {
+		return nil, fmt.Errorf("invalid capabilities: %w", err)
+	}
+	return ParseCapabilities(names)
+}
+
+// isKnownCapability checks if a capability name is supported
+func isKnownCapability(name string) bool {
+	for _, known := range AllCapabilities {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the capability is granted
+func (c Capabilities) Allows(name string) bool {
+	return c == nil || c[name]
+}
+
+// Intersect returns the capabilities granted by both sets
+func (c Capabilities) Intersect(other Capabilities) Capabilities {
+	result := make(Capabilities)
+	for _, name := range AllCapabilities {
+		if c.Allows(name) && other.Allows(name) {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// Names returns the granted capability names in sorted order
+func (c Capabilities) Names() []string {
+	names := []string{}
+	for _, name := range AllCapabilities {
+		if c.Allows(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Encode returns the JSON array stored in the database
+func (c Capabilities) Encode() string {
+	data, _ := json.Marshal(c.Names())
+	return string(data)
+}
@@ -0,0 +1,130 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := ParseCapabilities([]string{CapabilityKV, CapabilityDBWrite})
+	if err != nil {
+		t.Fatalf("ParseCapabilities failed: %v", err)
+	}
+	if !caps.Allows(CapabilityDBWrite) || caps.Allows(CapabilityHTTP) {
+		t.Errorf("Unexpected capabilities: %v", caps.Names())
+	}
+	if caps.Encode() != `["db_write","kv"]` {
+		t.Errorf("Unexpected encoding: %s", caps.Encode())
+	}
+
+	if _, err := ParseCapabilities([]string{"shell"}); err == nil {
+		t.Error("Expected error for unknown capability")
+	}
+}
+
+func TestDecodeCapabilities(t *testing.T) {
+	caps, err := DecodeCapabilities(`["db_write","http","kv","mail"]`)
+	if err != nil {
+		t.Fatalf("DecodeCapabilities failed: %v", err)
+	}
+	if got := caps.Encode(); got != `["db_write","http","kv","mail"]` {
+		t.Errorf("Unexpected encoding: %s", got)
+	}
+
+	if _, err := DecodeCapabilities(`["db_write","shell"]`); err == nil {
+		t.Error("Expected error for unknown capability")
+	}
+	if _, err := DecodeCapabilities(`not json`); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestCapabilities_Intersect(t *testing.T) {
+	var all Capabilities // nil is unrestricted
+	readOnly := Capabilities{}
+
+	if got := all.Intersect(readOnly).Names(); len(got) != 0 {
+		t.Errorf("Expected no capabilities, got %v", got)
+	}
+
+	remote := Capabilities{CapabilityRemote: true}
+	if got := all.Intersect(remote).Names(); len(got) != 1 || got[0] != CapabilityRemote {
+		t.Errorf("Expected [remote], got %v", got)
+	}
+}
+
+func TestExecute_ReadOnlyCapabilities(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE test_items (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO test_items (name) VALUES ('existing')`)
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	run := func(script string) (*ExecutionResult, error) {
+		execCtx := &ExecutionContext{
+			Request:      httptest.NewRequest("GET", "/test", nil),
+			DB:           db,
+			Capabilities: Capabilities{},
+		}
+		return Execute(context.Background(), script, execCtx)
+	}
+
+	t.Run("QueryAllowed", func(t *testing.T) {
+		result, err := run(`
+def handle_request(req):
+    rows = db.query("SELECT name FROM test_items")
+    return response(rows[0]["name"])
+`)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if result.Body != "existing" {
+			t.Errorf("Expected 'existing', got %v", result.Body)
+		}
+	})
+
+	t.Run("ExecuteRejected", func(t *testing.T) {
+		_, err := run(`
+def handle_request(req):
+    db.execute("INSERT INTO test_items (name) VALUES ('x')")
+    return response("ok")
+`)
+		if err == nil || !strings.Contains(err.Error(), CapabilityDBWrite) {
+			t.Errorf("Expected capability error, got %v", err)
+		}
+	})
+
+	t.Run("WriteThroughQueryRejected", func(t *testing.T) {
+		_, err := run(`
+def handle_request(req):
+    db.query("DELETE FROM test_items RETURNING id")
+    return response("ok")
+`)
+		if err == nil {
+			t.Error("Expected write through db.query to fail")
+		}
+	})
+
+	// The pooled connection must be writable again afterwards
+	if _, err := db.Exec(`INSERT INTO test_items (name) VALUES ('after')`); err != nil {
+		t.Errorf("Connection left in query_only mode: %v", err)
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM test_items").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 rows, got %d", count)
+	}
+}
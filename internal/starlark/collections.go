@@ -0,0 +1,55 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// buildCollectionsModule creates the collections module:
+// collections.run(name, limit?, cursor?) runs a saved search
+func buildCollectionsModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("collections"), starlark.StringDict{
+		"run": starlark.NewBuiltin("collections.run", makeCollectionsRunFunc(ctx, execCtx)),
+	})
+}
+
+// makeCollectionsRunFunc creates the collections.run function. It returns
+// a struct with the page of results, each a dict of document fields, and
+// the next_cursor to pass back for the following page ("" after the last).
+func makeCollectionsRunFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name, cursor string
+		var limit int
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "limit?", &limit, "cursor?", &cursor); err != nil {
+			return nil, err
+		}
+		if execCtx.DB == nil {
+			return nil, fmt.Errorf("%s: no database available", fn.Name())
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		_, results, next, err := document.RunCollection(execCtx.DB, name, cursor, limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		items := make([]starlark.Value, len(results))
+		for i, result := range results {
+			if items[i], err = starconv.ToStarlark(result.Map()); err != nil {
+				return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+			}
+		}
+		return starlarkstruct.FromStringDict(starlark.String("collection"), starlark.StringDict{
+			"results":     starlark.NewList(items),
+			"next_cursor": starlark.String(next),
+		}), nil
+	}
+}
@@ -0,0 +1,59 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+
+	wcedb "github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestExecute_CollectionsRun(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(wcedb.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	for _, id := range []string{"posts/a", "posts/b", "posts/c"} {
+		if _, err := document.CreateDocument(db, id, "post "+id, "text/plain", "user-1", false, true); err != nil {
+			t.Fatalf("CreateDocument failed: %v", err)
+		}
+	}
+	if _, err := document.SaveCollection(db, document.Collection{Name: "posts", Prefix: "posts/", PageSize: 2}, ""); err != nil {
+		t.Fatalf("SaveCollection failed: %v", err)
+	}
+
+	script := `
+def handle_request(req):
+    first = collections.run("posts")
+    rest = collections.run("posts", cursor=first.next_cursor)
+    return response({"ids": [doc["id"] for doc in first.results + rest.results]})
+`
+	result, err := Execute(context.Background(), script, &ExecutionContext{
+		DB:      db,
+		Request: httptest.NewRequest("GET", "/test", nil),
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	ids := result.Body.(map[string]interface{})["ids"].([]interface{})
+	if len(ids) != 3 || ids[0] != "posts/a" || ids[2] != "posts/c" {
+		t.Errorf("Unexpected ids: %v", ids)
+	}
+
+	script = `
+def handle_request(req):
+    return response(collections.run("missing"))
+`
+	if _, err := Execute(context.Background(), script, &ExecutionContext{DB: db, Request: httptest.NewRequest("GET", "/test", nil)}); err == nil {
+		t.Error("Expected running a missing collection to fail")
+	}
+}
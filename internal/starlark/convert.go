@@ -0,0 +1,26 @@
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// listToGo converts query parameters, which may be a list or a tuple
+func listToGo(val starlark.Value) ([]interface{}, error) {
+	if val == nil || val == starlark.None {
+		return []interface{}{}, nil
+	}
+	switch val.(type) {
+	case *starlark.List, starlark.Tuple:
+	default:
+		return nil, fmt.Errorf("params must be a list, got %s", val.Type())
+	}
+	goVal, err := starconv.ToGo(val)
+	if err != nil {
+		return nil, err
+	}
+	return goVal.([]interface{}), nil
+}
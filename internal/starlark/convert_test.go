@@ -0,0 +1,92 @@
+package starlark
+
+import (
+	"context"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"go.starlark.net/starlark"
+)
+
+func TestConvert_JSONRoundTrip(t *testing.T) {
+	// Ints keep their exact value through json.encode and json.decode
+	roundTrip := func(a int64, shift uint8) bool {
+		n := new(big.Int).Lsh(big.NewInt(a), uint(shift))
+		value := starlark.NewList([]starlark.Value{starlark.MakeInt64(a), starlark.MakeBigInt(n)})
+
+		encoded, err := jsonEncode(nil, starlark.NewBuiltin("json.encode", nil), starlark.Tuple{value}, nil)
+		if err != nil {
+			return false
+		}
+		decoded, err := jsonDecode(nil, starlark.NewBuiltin("json.decode", nil), starlark.Tuple{encoded}, nil)
+		if err != nil {
+			return false
+		}
+		equal, err := starlark.Equal(value, decoded)
+		return err == nil && equal
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecute_ConversionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"function body", "def handle_request(req):\n    return response(handle_request)", "cannot convert function"},
+		{"non-string header", "def handle_request(req):\n    return {\"headers\": {\"X-Count\": 1}}", "response header X-Count must be a string"},
+		{"int dict keys", "def handle_request(req):\n    return response({1: 2})", "dict keys must be strings"},
+		{"log field", "def handle_request(req):\n    log.info(\"x\", f=len)\n    return response({})", "field f"},
+		{"json.encode", "def handle_request(req):\n    return response(json.encode([len]))", "json.encode"},
+	}
+
+	for _, tt := range tests {
+		execCtx := &ExecutionContext{Request: httptest.NewRequest("GET", "/test", nil)}
+		_, err := Execute(context.Background(), tt.script, execCtx)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestExecute_ExactValues(t *testing.T) {
+	script := `
+def handle_request(req):
+    big = 1 << 70
+    decoded = json.decode('{"n": 123456789012345678901234567890, "f": 1.5, "i": 3}')
+    return response({
+        "big": big,
+        "decoded_int": type(decoded["i"]),
+        "decoded_big": decoded["n"] == 123456789012345678901234567890,
+        "tuple": (1, "a"),
+        "bytes": b"raw",
+    })
+`
+	execCtx := &ExecutionContext{Request: httptest.NewRequest("GET", "/test", nil)}
+	result, err := Execute(context.Background(), script, execCtx)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	body := result.Body.(map[string]interface{})
+	want := new(big.Int).Lsh(big.NewInt(1), 70)
+	if got, ok := body["big"].(*big.Int); !ok || got.Cmp(want) != 0 {
+		t.Errorf("Expected big int %s, got %#v", want, body["big"])
+	}
+	if body["decoded_int"] != "int" || body["decoded_big"] != true {
+		t.Errorf("Expected json.decode to keep ints exact, got %v and %v", body["decoded_int"], body["decoded_big"])
+	}
+	if !reflect.DeepEqual(body["tuple"], []interface{}{int64(1), "a"}) {
+		t.Errorf("Unexpected tuple: %#v", body["tuple"])
+	}
+	if !reflect.DeepEqual(body["bytes"], []byte("raw")) {
+		t.Errorf("Unexpected bytes: %#v", body["bytes"])
+	}
+}
@@ -0,0 +1,166 @@
+package starlark
+
+import (
+	"sort"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+// DebugAction tells a paused script how to proceed
+type DebugAction int
+
+const (
+	// DebugContinue runs until the next breakpoint
+	DebugContinue DebugAction = iota
+	// DebugStep runs until the next line
+	DebugStep
+	// DebugStop aborts execution
+	DebugStop
+)
+
+// maxLocalValueLen truncates rendered local values sent to the debugger
+const maxLocalValueLen = 1024
+
+// DebugEvent describes the point at which a script paused
+type DebugEvent struct {
+	Line     int32             `json:"line"`
+	Col      int32             `json:"col"`
+	Function string            `json:"function"`
+	Depth    int               `json:"depth"`
+	Locals   map[string]string `json:"locals"`
+}
+
+// Debugger pauses script execution at breakpoints or on every line.
+// Pause is called on the script's goroutine and blocks execution until it returns.
+//
+// Lines are resolved from the interpreter's line table, which only records
+// instructions that can fail (calls, operators, loops, indexing), so lines
+// that merely assign constants are not stopped on.
+type Debugger struct {
+	Pause func(event *DebugEvent) DebugAction
+
+	mu          sync.Mutex
+	breakpoints map[int32]bool
+	stepping    bool
+	lastLine    int32
+	lastDepth   int
+}
+
+// NewDebugger creates a debugger that stops at the given lines.
+// If stepping is true, execution also stops at the first line.
+func NewDebugger(breakpoints []int32, stepping bool, pause func(*DebugEvent) DebugAction) *Debugger {
+	d := &Debugger{
+		Pause:    pause,
+		stepping: stepping,
+	}
+	d.SetBreakpoints(breakpoints)
+	return d
+}
+
+// SetBreakpoints replaces the set of breakpoint lines.
+// It is safe to call while the script is running.
+func (d *Debugger) SetBreakpoints(lines []int32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints = make(map[int32]bool, len(lines))
+	for _, line := range lines {
+		d.breakpoints[line] = true
+	}
+}
+
+// Breakpoints returns the current breakpoint lines in ascending order
+func (d *Debugger) Breakpoints() []int32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lines := make([]int32, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// installStepHooks runs hooks before every interpreter instruction.
+// The interpreter invokes OnMaxSteps once the step budget is reached,
+// so a budget of one more step turns it into a per-instruction hook.
+func installStepHooks(thread *starlark.Thread, hooks []func(*starlark.Thread)) {
+	if len(hooks) == 0 {
+		return
+	}
+
+	thread.OnMaxSteps = func(thread *starlark.Thread) {
+		for _, hook := range hooks {
+			hook(thread)
+		}
+		thread.SetMaxExecutionSteps(thread.ExecutionSteps() + 1)
+	}
+	thread.SetMaxExecutionSteps(1)
+}
+
+// onStep checks whether execution has reached a new line that should pause
+func (d *Debugger) onStep(thread *starlark.Thread) {
+	depth := thread.CallStackDepth()
+	if depth == 0 {
+		return
+	}
+
+	frame := thread.DebugFrame(0)
+	pos := frame.Position()
+	if !pos.IsValid() || pos.Filename() != scriptFilename {
+		return
+	}
+
+	// Only consider each line once per visit, not once per instruction
+	if pos.Line == d.lastLine && depth == d.lastDepth {
+		return
+	}
+	d.lastLine = pos.Line
+	d.lastDepth = depth
+
+	d.mu.Lock()
+	pause := d.stepping || d.breakpoints[pos.Line]
+	d.mu.Unlock()
+
+	if !pause {
+		return
+	}
+
+	event := &DebugEvent{
+		Line:     pos.Line,
+		Col:      pos.Col,
+		Function: frame.Callable().Name(),
+		Depth:    depth,
+		Locals:   frameLocals(frame),
+	}
+
+	action := d.Pause(event)
+
+	d.mu.Lock()
+	d.stepping = action == DebugStep
+	d.mu.Unlock()
+
+	if action == DebugStop {
+		thread.Cancel("stopped by debugger")
+	}
+}
+
+// frameLocals renders the assigned local variables of a frame
+func frameLocals(frame starlark.DebugFrame) map[string]string {
+	locals := make(map[string]string, frame.NumLocals())
+	for i := 0; i < frame.NumLocals(); i++ {
+		binding, value := frame.Local(i)
+		if value == nil {
+			continue // Not yet assigned
+		}
+
+		rendered := value.String()
+		if len(rendered) > maxLocalValueLen {
+			rendered = rendered[:maxLocalValueLen] + "..."
+		}
+		locals[binding.Name] = rendered
+	}
+	return locals
+}
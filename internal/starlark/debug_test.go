@@ -0,0 +1,95 @@
+package starlark
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+const debugScript = `def handle_request(req):
+    total = 0
+    for i in range(3):
+        total = total + i
+    return {"status": 200, "body": str(total)}
+`
+
+func TestDebugger_Breakpoint(t *testing.T) {
+	var events []*DebugEvent
+	debugger := NewDebugger([]int32{4}, false, func(event *DebugEvent) DebugAction {
+		events = append(events, event)
+		return DebugContinue
+	})
+
+	execCtx := &ExecutionContext{
+		Request:  httptest.NewRequest("GET", "/test", nil),
+		Debugger: debugger,
+	}
+
+	result, err := Execute(context.Background(), debugScript, execCtx)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Body != "3" {
+		t.Errorf("Expected body '3', got %v", result.Body)
+	}
+
+	// At least one pause per loop iteration
+	if len(events) < 3 {
+		t.Fatalf("Expected at least 3 pauses, got %d", len(events))
+	}
+	for _, event := range events {
+		if event.Line != 4 || event.Function != "handle_request" {
+			t.Errorf("Unexpected pause at %s line %d", event.Function, event.Line)
+		}
+	}
+	for i, want := range []string{"0", "1", "2"} {
+		if events[i].Locals["i"] != want {
+			t.Errorf("Pause %d: expected i=%s, got locals %v", i, want, events[i].Locals)
+		}
+	}
+	if events[2].Locals["total"] != "1" {
+		t.Errorf("Unexpected locals at third pause: %v", events[2].Locals)
+	}
+}
+
+func TestDebugger_Step(t *testing.T) {
+	var lines []int32
+	debugger := NewDebugger(nil, true, func(event *DebugEvent) DebugAction {
+		lines = append(lines, event.Line)
+		return DebugStep
+	})
+
+	execCtx := &ExecutionContext{
+		Request:  httptest.NewRequest("GET", "/test", nil),
+		Debugger: debugger,
+	}
+
+	if _, err := Execute(context.Background(), debugScript, execCtx); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	seen := make(map[int32]bool)
+	for _, line := range lines {
+		seen[line] = true
+	}
+	for _, line := range []int32{3, 4, 5} {
+		if !seen[line] {
+			t.Errorf("Expected to step through line %d, stepped through %v", line, lines)
+		}
+	}
+}
+
+func TestDebugger_Stop(t *testing.T) {
+	debugger := NewDebugger([]int32{4}, false, func(event *DebugEvent) DebugAction {
+		return DebugStop
+	})
+
+	execCtx := &ExecutionContext{
+		Request:  httptest.NewRequest("GET", "/test", nil),
+		Debugger: debugger,
+	}
+
+	if _, err := Execute(context.Background(), debugScript, execCtx); err == nil {
+		t.Error("Expected stopped script to return an error")
+	}
+}
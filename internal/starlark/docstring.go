@@ -0,0 +1,73 @@
+package starlark
+
+import (
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// HandlerDocstring returns the docstring of the script's handle_request function.
+// By convention the first line is a summary and the remaining lines a description.
+// Returns an empty string if the script does not parse or has no docstring.
+func HandlerDocstring(script string) string {
+	f, err := syntax.Parse(scriptFilename, script, 0)
+	if err != nil {
+		return ""
+	}
+
+	for _, stmt := range f.Stmts {
+		def, ok := stmt.(*syntax.DefStmt)
+		if !ok || def.Name.Name != "handle_request" || len(def.Body) == 0 {
+			continue
+		}
+
+		expr, ok := def.Body[0].(*syntax.ExprStmt)
+		if !ok {
+			return ""
+		}
+
+		lit, ok := expr.X.(*syntax.Literal)
+		if !ok || lit.Token != syntax.STRING {
+			return ""
+		}
+
+		doc, _ := lit.Value.(string)
+		return strings.TrimSpace(doc)
+	}
+
+	return ""
+}
+
+// SplitDocstring splits a docstring into its summary line and remaining description
+func SplitDocstring(doc string) (summary, description string) {
+	parts := strings.SplitN(doc, "\n", 2)
+	summary = strings.TrimSpace(parts[0])
+	if len(parts) == 2 {
+		description = dedent(parts[1])
+	}
+	return summary, description
+}
+
+// dedent removes common leading whitespace from all non-blank lines
+func dedent(text string) string {
+	lines := strings.Split(text, "\n")
+
+	indent := -1
+	for _, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
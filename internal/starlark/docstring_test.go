@@ -0,0 +1,43 @@
+package starlark
+
+import "testing"
+
+func TestHandlerDocstring(t *testing.T) {
+	script := `
+def helper():
+    """Not this one."""
+    return 1
+
+def handle_request(req):
+    """List all widgets.
+
+    Returns every widget visible to the caller.
+    Supports the ?limit= query parameter.
+    """
+    return response([])
+`
+	doc := HandlerDocstring(script)
+	summary, description := SplitDocstring(doc)
+
+	if summary != "List all widgets." {
+		t.Errorf("Expected summary 'List all widgets.', got '%s'", summary)
+	}
+	expected := "Returns every widget visible to the caller.\nSupports the ?limit= query parameter."
+	if description != expected {
+		t.Errorf("Expected description %q, got %q", expected, description)
+	}
+}
+
+func TestHandlerDocstring_Missing(t *testing.T) {
+	tests := []string{
+		"def handle_request(req):\n    return response({})",
+		"def other(req):\n    \"\"\"Doc.\"\"\"\n    return 1",
+		"def handle_request(req:", // Syntax error
+	}
+
+	for _, script := range tests {
+		if doc := HandlerDocstring(script); doc != "" {
+			t.Errorf("Expected no docstring for %q, got %q", script, doc)
+		}
+	}
+}
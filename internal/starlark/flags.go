@@ -0,0 +1,46 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/flags"
+)
+
+// buildFlagsModule creates the flags module: flags.enabled(name) reports
+// whether a feature flag is on for the user the script runs for
+func buildFlagsModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("flags"), starlark.StringDict{
+		"enabled": starlark.NewBuiltin("flags.enabled", makeFlagsEnabledFunc(ctx, execCtx)),
+	})
+}
+
+// makeFlagsEnabledFunc creates the flags.enabled function. Unknown flags
+// are off.
+func makeFlagsEnabledFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+			return nil, err
+		}
+		if execCtx.DB == nil {
+			return nil, fmt.Errorf("%s: no database available", fn.Name())
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		var userID string
+		if identity := execCtx.identity(); identity != nil {
+			userID = identity.UserID
+		}
+		enabled, err := flags.Enabled(execCtx.DB, name, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		return starlark.Bool(enabled), nil
+	}
+}
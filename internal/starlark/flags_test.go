@@ -0,0 +1,53 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+
+	wcedb "github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/flags"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestExecute_FlagsEnabled(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(wcedb.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO _wce_users (user_id, username, password_hash, created_at) VALUES ('user-1', 'one', 'x', 0)`); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := flags.Put(db, &flags.Flag{Name: "beta", Rollout: 100}, ""); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := flags.SetOverride(db, "beta", "user-1", true); err != nil {
+		t.Fatalf("SetOverride failed: %v", err)
+	}
+
+	script := `
+def handle_request(req):
+    return response({"beta": flags.enabled("beta"), "missing": flags.enabled("missing")})
+`
+	for userID, want := range map[string]bool{"user-1": true, "": false} {
+		result, err := Execute(context.Background(), script, &ExecutionContext{
+			DB:      db,
+			UserID:  userID,
+			Request: httptest.NewRequest("GET", "/test", nil),
+		})
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		body := result.Body.(map[string]interface{})
+		if body["beta"] != want || body["missing"] != false {
+			t.Errorf("User %q: unexpected flags %v", userID, body)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package starlark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// maxHTTPResponseBytes bounds the response body http.get and http.post read
+const maxHTTPResponseBytes = 1 << 20
+
+// buildHTTPModule creates the http module: http.get(url, headers?) and
+// http.post(url, body?, headers?). Both return a struct with status,
+// headers and body.
+func buildHTTPModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("http"), starlark.StringDict{
+		"get":  starlark.NewBuiltin("http.get", makeHTTPFunc(ctx, execCtx, http.MethodGet)),
+		"post": starlark.NewBuiltin("http.post", makeHTTPFunc(ctx, execCtx, http.MethodPost)),
+	})
+}
+
+// checkHTTP reports why the http module cannot be used, if it cannot
+func checkHTTP(execCtx *ExecutionContext, name string) error {
+	if !execCtx.Capabilities.Allows(CapabilityHTTP) {
+		return fmt.Errorf("%s requires the %s capability", name, CapabilityHTTP)
+	}
+	if execCtx.HTTPClient == nil {
+		return fmt.Errorf("%s: outbound HTTP is not available", name)
+	}
+	return nil
+}
+
+// makeHTTPFunc creates the http function for a method. A body that is not a
+// string is sent as JSON.
+func makeHTTPFunc(ctx context.Context, execCtx *ExecutionContext, method string) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkHTTP(execCtx, fn.Name()); err != nil {
+			return nil, err
+		}
+
+		var url string
+		var bodyVal starlark.Value
+		var headers *starlark.Dict
+		var err error
+		if method == http.MethodPost {
+			err = starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &url, "body?", &bodyVal, "headers?", &headers)
+		} else {
+			err = starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &url, "headers?", &headers)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var body io.Reader
+		contentType := ""
+		switch v := bodyVal.(type) {
+		case nil, starlark.NoneType:
+		case starlark.String:
+			body = bytes.NewReader([]byte(string(v)))
+		default:
+			goVal, err := starconv.ToGo(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+			}
+			data, err := json.Marshal(goVal)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+			}
+			body = bytes.NewReader(data)
+			contentType = "application/json"
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if headers != nil {
+			for _, item := range headers.Items() {
+				name, ok1 := starlark.AsString(item[0])
+				value, ok2 := starlark.AsString(item[1])
+				if !ok1 || !ok2 {
+					return nil, fmt.Errorf("%s: headers must map strings to strings", fn.Name())
+				}
+				req.Header.Set(name, value)
+			}
+		}
+
+		resp, err := execCtx.HTTPClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		if len(data) > maxHTTPResponseBytes {
+			return nil, fmt.Errorf("%s: response body exceeds %d bytes", fn.Name(), maxHTTPResponseBytes)
+		}
+
+		respHeaders := starlark.NewDict(len(resp.Header))
+		for name := range resp.Header {
+			respHeaders.SetKey(starlark.String(name), starlark.String(resp.Header.Get(name)))
+		}
+		return starlarkstruct.FromStringDict(starlark.String("http_response"), starlark.StringDict{
+			"status":  starlark.MakeInt(resp.StatusCode),
+			"headers": respHeaders,
+			"body":    starlark.String(data),
+		}), nil
+	}
+}
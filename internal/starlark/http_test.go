@@ -0,0 +1,69 @@
+package starlark
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExecute_HTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+		w.Header().Set("X-Token", r.Header.Get("X-Token"))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	run := func(caps Capabilities, client *http.Client, script string) (*ExecutionResult, error) {
+		return Execute(context.Background(), script, &ExecutionContext{
+			Request:      httptest.NewRequest("GET", "/test", nil),
+			Capabilities: caps,
+			HTTPClient:   client,
+		})
+	}
+	script := `
+def handle_request(req):
+    get = http.get("` + upstream.URL + `", headers={"X-Token": "abc"})
+    post = http.post("` + upstream.URL + `", {"n": 1})
+    return response({
+        "get": [get.status, get.headers["X-Method"], get.headers["X-Token"]],
+        "post": [post.status, post.headers["X-Content-Type"], post.body],
+    })
+`
+
+	t.Run("Granted", func(t *testing.T) {
+		result, err := run(Capabilities{CapabilityHTTP: true}, upstream.Client(), script)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		body := result.Body.(map[string]interface{})
+		get := body["get"].([]interface{})
+		if get[0] != int64(202) || get[1] != "GET" || get[2] != "abc" {
+			t.Errorf("Unexpected get result: %v", get)
+		}
+		post := body["post"].([]interface{})
+		if post[1] != "application/json" || post[2] != `{"n":1}` {
+			t.Errorf("Unexpected post result: %v", post)
+		}
+	})
+
+	t.Run("NotGranted", func(t *testing.T) {
+		_, err := run(Capabilities{}, upstream.Client(), script)
+		if err == nil || !strings.Contains(err.Error(), CapabilityHTTP) {
+			t.Errorf("Expected capability error, got %v", err)
+		}
+	})
+
+	t.Run("NoClient", func(t *testing.T) {
+		_, err := run(nil, nil, script)
+		if err == nil || !strings.Contains(err.Error(), "not available") {
+			t.Errorf("Expected unavailable error, got %v", err)
+		}
+	})
+}
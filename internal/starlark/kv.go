@@ -0,0 +1,126 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/clock"
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// buildKVModule creates the kv module, a key-value store shared by the
+// cenv's scripts: kv.get(key, default?), kv.set(key, value) and
+// kv.delete(key). Values are stored as JSON.
+func buildKVModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("kv"), starlark.StringDict{
+		"get":    starlark.NewBuiltin("kv.get", makeKVGetFunc(ctx, execCtx)),
+		"set":    starlark.NewBuiltin("kv.set", makeKVSetFunc(ctx, execCtx)),
+		"delete": starlark.NewBuiltin("kv.delete", makeKVDeleteFunc(ctx, execCtx)),
+	})
+}
+
+// checkKV reports why the kv module cannot be used, if it cannot
+func checkKV(execCtx *ExecutionContext, name string) error {
+	if !execCtx.Capabilities.Allows(CapabilityKV) {
+		return fmt.Errorf("%s requires the %s capability", name, CapabilityKV)
+	}
+	if execCtx.DB == nil {
+		return fmt.Errorf("%s: no database available", name)
+	}
+	return nil
+}
+
+// makeKVGetFunc creates the kv.get function. Missing keys return default,
+// or None.
+func makeKVGetFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkKV(execCtx, fn.Name()); err != nil {
+			return nil, err
+		}
+
+		var key string
+		var def starlark.Value = starlark.None
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "default?", &def); err != nil {
+			return nil, err
+		}
+
+		var data string
+		err := execCtx.DB.QueryRowContext(ctx, `SELECT value FROM _wce_kv WHERE key = ?`, key).Scan(&data)
+		if err == sql.ErrNoRows {
+			return def, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(data))
+		decoder.UseNumber()
+		var goVal interface{}
+		if err := decoder.Decode(&goVal); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		return starconv.ToStarlark(goVal)
+	}
+}
+
+// makeKVSetFunc creates the kv.set function, replacing any value stored
+// under the key
+func makeKVSetFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkKV(execCtx, fn.Name()); err != nil {
+			return nil, err
+		}
+
+		var key string
+		var val starlark.Value
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "value", &val); err != nil {
+			return nil, err
+		}
+
+		goVal, err := starconv.ToGo(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		data, err := json.Marshal(goVal)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		_, err = execCtx.DB.ExecContext(ctx, `
+			INSERT INTO _wce_kv (key, value, modified_at) VALUES (?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET value = excluded.value, modified_at = excluded.modified_at
+		`, key, string(data), clock.Now().Unix())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		return starlark.None, nil
+	}
+}
+
+// makeKVDeleteFunc creates the kv.delete function, which reports whether
+// the key was set
+func makeKVDeleteFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkKV(execCtx, fn.Name()); err != nil {
+			return nil, err
+		}
+
+		var key string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key); err != nil {
+			return nil, err
+		}
+
+		result, err := execCtx.DB.ExecContext(ctx, `DELETE FROM _wce_kv WHERE key = ?`, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		deleted, _ := result.RowsAffected()
+		return starlark.Bool(deleted > 0), nil
+	}
+}
@@ -0,0 +1,83 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	wcedb "github.com/thetanil/wce/internal/db"
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestExecute_KV(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:?"+sqlite.ForeignKeysParam)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(wcedb.Schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	run := func(caps Capabilities, script string) (*ExecutionResult, error) {
+		return Execute(context.Background(), script, &ExecutionContext{
+			DB:           db,
+			Request:      httptest.NewRequest("GET", "/test", nil),
+			Capabilities: caps,
+		})
+	}
+	kvOnly := Capabilities{CapabilityKV: true}
+
+	result, err := run(kvOnly, `
+def handle_request(req):
+    kv.set("visits", {"count": 1, "pages": ["/"]})
+    kv.set("visits", {"count": 2, "pages": ["/", "/about"]})
+    kv.set("gone", True)
+    return response({
+        "visits": kv.get("visits"),
+        "missing": kv.get("missing", "none"),
+        "deleted": [kv.delete("gone"), kv.delete("gone")],
+        "gone": kv.get("gone"),
+    })
+`)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	body := result.Body.(map[string]interface{})
+	visits := body["visits"].(map[string]interface{})
+	if visits["count"] != int64(2) || len(visits["pages"].([]interface{})) != 2 {
+		t.Errorf("Unexpected visits: %v", visits)
+	}
+	if body["missing"] != "none" || body["gone"] != nil {
+		t.Errorf("Unexpected values: %v", body)
+	}
+	if deleted := body["deleted"].([]interface{}); deleted[0] != true || deleted[1] != false {
+		t.Errorf("Unexpected delete results: %v", deleted)
+	}
+
+	// Values persist across runs
+	result, err = run(kvOnly, `
+def handle_request(req):
+    return response(kv.get("visits")["count"])
+`)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Body != int64(2) {
+		t.Errorf("Expected 2, got %v", result.Body)
+	}
+
+	// db_write does not grant kv
+	_, err = run(Capabilities{CapabilityDBWrite: true}, `
+def handle_request(req):
+    kv.set("visits", 0)
+    return response("ok")
+`)
+	if err == nil || !strings.Contains(err.Error(), CapabilityKV) {
+		t.Errorf("Expected capability error, got %v", err)
+	}
+}
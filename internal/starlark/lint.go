@@ -0,0 +1,106 @@
+package starlark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// LintIssue describes a problem found by Lint
+type LintIssue struct {
+	Line    int32  `json:"line"`
+	Col     int32  `json:"col"`
+	Message string `json:"message"`
+}
+
+// LintResult holds the findings of a static check.
+// Errors block saving a script; warnings are advisory.
+type LintResult struct {
+	Errors   []LintIssue `json:"errors,omitempty"`
+	Warnings []LintIssue `json:"warnings,omitempty"`
+}
+
+// OK reports whether the script has no hard errors
+func (r *LintResult) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// systemTableWrite matches SQL statements that modify _wce_ system tables
+var systemTableWrite = regexp.MustCompile(`(?i)\b(INSERT\s+(OR\s+\w+\s+)?INTO|REPLACE\s+INTO|UPDATE(\s+OR\s+\w+)?|DELETE\s+FROM|DROP\s+TABLE(\s+IF\s+EXISTS)?|ALTER\s+TABLE)\s+["'\x60\[]?_wce_`)
+
+// Lint statically checks an endpoint script: syntax, undefined names,
+// the handle_request entry point and obvious writes to _wce_ system tables.
+func Lint(script string) *LintResult {
+	return lint(script, "handle_request", "a request")
+}
+
+// LintHook statically checks a hook script like Lint, with handle_event as
+// its entry point
+func LintHook(script string) *LintResult {
+	return lint(script, "handle_event", "an event")
+}
+
+// lint checks a script whose entry point takes one argument, described for
+// messages as argument
+func lint(script, entry, argument string) *LintResult {
+	result := &LintResult{}
+
+	f, err := syntax.LegacyFileOptions().Parse(scriptFilename, script, 0)
+	if err != nil {
+		var syntaxErr syntax.Error
+		if errors.As(err, &syntaxErr) {
+			result.Errors = append(result.Errors, issueAt(syntaxErr.Pos, syntaxErr.Msg))
+		} else {
+			result.Errors = append(result.Errors, LintIssue{Message: err.Error()})
+		}
+		return result
+	}
+
+	// Resolve names against the sandbox environment
+	predeclared := buildPredeclared(context.Background(), &ExecutionContext{})
+	if err := resolve.File(f, predeclared.Has, starlark.Universe.Has); err != nil {
+		var resolveErrs resolve.ErrorList
+		if errors.As(err, &resolveErrs) {
+			for _, e := range resolveErrs {
+				result.Errors = append(result.Errors, issueAt(e.Pos, e.Msg))
+			}
+		} else {
+			result.Errors = append(result.Errors, LintIssue{Message: err.Error()})
+		}
+	}
+
+	// Check the entry point
+	var handler *syntax.DefStmt
+	for _, stmt := range f.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && def.Name.Name == entry {
+			handler = def
+		}
+	}
+	if handler == nil {
+		result.Errors = append(result.Errors, LintIssue{Message: fmt.Sprintf("script must define a '%s' function", entry)})
+	} else if len(handler.Params) == 0 {
+		result.Errors = append(result.Errors, issueAt(handler.Def, fmt.Sprintf("%s must accept %s argument", entry, argument)))
+	}
+
+	// Flag string literals that look like writes to system tables
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if lit, ok := n.(*syntax.Literal); ok && lit.Token == syntax.STRING {
+			if s, ok := lit.Value.(string); ok && systemTableWrite.MatchString(s) {
+				result.Warnings = append(result.Warnings, issueAt(lit.TokenPos, "query appears to modify a _wce_ system table"))
+			}
+		}
+		return true
+	})
+
+	return result
+}
+
+// issueAt creates a LintIssue at a source position
+func issueAt(pos syntax.Position, msg string) LintIssue {
+	return LintIssue{Line: pos.Line, Col: pos.Col, Message: msg}
+}
@@ -0,0 +1,84 @@
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint_Valid(t *testing.T) {
+	script := `
+def handle_request(req):
+    rows = db.query("SELECT * FROM items", [])
+    return response({"items": rows, "count": len(rows)})
+`
+	result := Lint(script)
+	if !result.OK() {
+		t.Errorf("Expected no errors, got %+v", result.Errors)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %+v", result.Warnings)
+	}
+}
+
+func TestLint_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		message string
+	}{
+		{"SyntaxError", "def handle_request(req:\n    return 1", "got"},
+		{"UndefinedName", "def handle_request(req):\n    return shell.run('x')", "undefined: shell"},
+		{"MissingHandler", "def handler(req):\n    return response({})", "handle_request"},
+		{"NoArguments", "def handle_request():\n    return response({})", "request argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Lint(tt.script)
+			if result.OK() {
+				t.Fatal("Expected lint errors")
+			}
+
+			found := false
+			for _, issue := range result.Errors {
+				if strings.Contains(issue.Message, tt.message) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected error containing %q, got %+v", tt.message, result.Errors)
+			}
+		})
+	}
+}
+
+func TestLintHook(t *testing.T) {
+	if result := LintHook("def handle_event(event):\n    log.info(event.document_id)\n"); !result.OK() {
+		t.Errorf("Expected no errors, got %+v", result.Errors)
+	}
+	if result := LintHook("def handle_request(req):\n    return response({})\n"); result.OK() || !strings.Contains(result.Errors[0].Message, "handle_event") {
+		t.Errorf("Expected a missing handle_event to be reported, got %+v", result.Errors)
+	}
+	if result := LintHook("def handle_event():\n    pass\n"); result.OK() || !strings.Contains(result.Errors[0].Message, "an event argument") {
+		t.Errorf("Expected a missing argument to be reported, got %+v", result.Errors)
+	}
+}
+
+func TestLint_SystemTableWrite(t *testing.T) {
+	script := `
+def handle_request(req):
+    db.execute("UPDATE _wce_users SET role = 'owner'", [])
+    db.query("SELECT * FROM _wce_documents", [])
+    return response({})
+`
+	result := Lint(script)
+	if !result.OK() {
+		t.Fatalf("Expected no errors, got %+v", result.Errors)
+	}
+	if len(result.Warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %+v", result.Warnings)
+	}
+	if result.Warnings[0].Line != 3 {
+		t.Errorf("Expected warning on line 3, got %d", result.Warnings[0].Line)
+	}
+}
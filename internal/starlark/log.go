@@ -0,0 +1,100 @@
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// Log levels available to scripts, in increasing severity
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// logLevels orders levels by severity
+var logLevels = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelWarn:  2,
+	LogLevelError: 3,
+}
+
+// maxLogMessageLen truncates log messages emitted by scripts
+const maxLogMessageLen = 4096
+
+// LogEntry is a structured log entry emitted by a script
+type LogEntry struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// IsValidLogLevel checks if a level name is supported
+func IsValidLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
+
+// LogLevelEnabled reports whether entries at level pass the minimum level.
+// An unknown minimum behaves like "info".
+func LogLevelEnabled(minLevel, level string) bool {
+	min, ok := logLevels[minLevel]
+	if !ok {
+		min = logLevels[LogLevelInfo]
+	}
+	return logLevels[level] >= min
+}
+
+// buildLogModule creates the log module: log.debug/info/warn/error(msg, **fields)
+func buildLogModule(execCtx *ExecutionContext) *starlarkstruct.Struct {
+	members := starlark.StringDict{}
+	for _, level := range []string{LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError} {
+		members[level] = starlark.NewBuiltin("log."+level, makeLogFunc(execCtx, level))
+	}
+	return starlarkstruct.FromStringDict(starlark.String("log"), members)
+}
+
+// makeLogFunc creates a log function for a level
+func makeLogFunc(execCtx *ExecutionContext, level string) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var msg starlark.Value
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, nil, 1, &msg); err != nil {
+			return nil, err
+		}
+
+		message, ok := starlark.AsString(msg)
+		if !ok {
+			message = msg.String()
+		}
+		if len(message) > maxLogMessageLen {
+			message = message[:maxLogMessageLen] + "..."
+		}
+
+		entry := LogEntry{Level: level, Message: message}
+		if len(kwargs) > 0 {
+			entry.Fields = make(map[string]interface{}, len(kwargs))
+			for _, kv := range kwargs {
+				key, _ := starlark.AsString(kv[0])
+				value, err := starconv.ToGo(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("%s: field %s: %w", fn.Name(), key, err)
+				}
+				entry.Fields[key] = value
+			}
+		}
+
+		if execCtx.Log != nil {
+			if err := execCtx.Log(entry); err != nil {
+				return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+			}
+		}
+
+		return starlark.None, nil
+	}
+}
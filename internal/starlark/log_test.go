@@ -0,0 +1,85 @@
+package starlark
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExecute_Log(t *testing.T) {
+	var entries []LogEntry
+	execCtx := &ExecutionContext{
+		Request: httptest.NewRequest("GET", "/test", nil),
+		Log: func(entry LogEntry) error {
+			entries = append(entries, entry)
+			return nil
+		},
+	}
+
+	script := `
+def handle_request(req):
+    log.debug("starting")
+    log.warn("slow lookup", table="orders", ms=120)
+    return response("ok")
+`
+
+	if _, err := Execute(context.Background(), script, execCtx); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Level != LogLevelDebug || entries[0].Message != "starting" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Level != LogLevelWarn || entries[1].Fields["table"] != "orders" || entries[1].Fields["ms"] != int64(120) {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+}
+
+func TestExecute_LogWithoutSink(t *testing.T) {
+	execCtx := &ExecutionContext{Request: httptest.NewRequest("GET", "/test", nil)}
+
+	script := `
+def handle_request(req):
+    log.info("discarded")
+    return response("ok")
+`
+
+	if _, err := Execute(context.Background(), script, execCtx); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+}
+
+func TestExecute_LogSinkError(t *testing.T) {
+	execCtx := &ExecutionContext{
+		Request: httptest.NewRequest("GET", "/test", nil),
+		Log: func(entry LogEntry) error {
+			return errors.New("log limit reached")
+		},
+	}
+
+	script := `
+def handle_request(req):
+    log.error("boom")
+    return response("ok")
+`
+
+	if _, err := Execute(context.Background(), script, execCtx); err == nil {
+		t.Error("Expected sink error to abort the script")
+	}
+}
+
+func TestLogLevelEnabled(t *testing.T) {
+	if LogLevelEnabled(LogLevelWarn, LogLevelInfo) {
+		t.Error("info should not pass a warn minimum")
+	}
+	if !LogLevelEnabled(LogLevelWarn, LogLevelError) {
+		t.Error("error should pass a warn minimum")
+	}
+	if LogLevelEnabled("bogus", LogLevelDebug) {
+		t.Error("unknown minimum should behave like info")
+	}
+}
@@ -0,0 +1,42 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/mail"
+)
+
+// buildMailModule creates the mail module: mail.send(to, subject, body)
+// sends a plain text email through the server's mailer
+func buildMailModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("mail"), starlark.StringDict{
+		"send": starlark.NewBuiltin("mail.send", makeMailSendFunc(ctx, execCtx)),
+	})
+}
+
+// makeMailSendFunc creates the mail.send function. It returns once the
+// mailer has accepted the message.
+func makeMailSendFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if !execCtx.Capabilities.Allows(CapabilityMail) {
+			return nil, fmt.Errorf("%s requires the %s capability", fn.Name(), CapabilityMail)
+		}
+		if execCtx.Mailer == nil {
+			return nil, fmt.Errorf("%s: email is not configured on this server", fn.Name())
+		}
+
+		var to, subject, body string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "to", &to, "subject", &subject, "body", &body); err != nil {
+			return nil, err
+		}
+
+		if err := execCtx.Mailer.Send(ctx, &mail.Message{To: to, Subject: subject, Body: body}); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		return starlark.None, nil
+	}
+}
@@ -0,0 +1,55 @@
+package starlark
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/mail"
+)
+
+// recordingMailer keeps the messages it is asked to send
+type recordingMailer struct {
+	sent []*mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg *mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestExecute_MailSend(t *testing.T) {
+	script := `
+def handle_request(req):
+    mail.send("ann@example.com", "Welcome", "Hello Ann")
+    return response("sent")
+`
+	run := func(caps Capabilities, mailer mail.Mailer) error {
+		_, err := Execute(context.Background(), script, &ExecutionContext{
+			Request:      httptest.NewRequest("GET", "/test", nil),
+			Capabilities: caps,
+			Mailer:       mailer,
+		})
+		return err
+	}
+
+	mailer := &recordingMailer{}
+	if err := run(Capabilities{CapabilityMail: true}, mailer); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "ann@example.com" ||
+		mailer.sent[0].Subject != "Welcome" || mailer.sent[0].Body != "Hello Ann" {
+		t.Errorf("Unexpected messages: %+v", mailer.sent)
+	}
+
+	if err := run(Capabilities{}, mailer); err == nil || !strings.Contains(err.Error(), CapabilityMail) {
+		t.Errorf("Expected capability error, got %v", err)
+	}
+	if err := run(nil, nil); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("Expected unconfigured error, got %v", err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Errorf("Expected no more messages, got %d", len(mailer.sent))
+	}
+}
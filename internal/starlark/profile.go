@@ -0,0 +1,192 @@
+package starlark
+
+import (
+	"sort"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// FunctionProfile reports time spent in a Starlark function
+type FunctionProfile struct {
+	Name    string  `json:"name"`
+	Calls   int     `json:"calls"`
+	SelfMS  float64 `json:"self_ms"`  // Time executing the function's own code and builtins it called
+	TotalMS float64 `json:"total_ms"` // Time including Starlark functions it called
+
+	self, total time.Duration
+	lastStep    uint64
+}
+
+// QueryProfile reports time spent in calls to db.query or db.execute with the same SQL
+type QueryProfile struct {
+	SQL     string  `json:"sql"`
+	Calls   int     `json:"calls"`
+	Rows    int64   `json:"rows"` // Rows returned or affected
+	TotalMS float64 `json:"total_ms"`
+	MaxMS   float64 `json:"max_ms"`
+	Lines   []int32 `json:"lines"` // Script lines issuing the statement
+
+	total, max time.Duration
+}
+
+// Profile is the report produced by a Profiler
+type Profile struct {
+	TotalMS   float64           `json:"total_ms"`
+	Steps     uint64            `json:"steps"`
+	Functions []FunctionProfile `json:"functions"` // Sorted by total time, descending
+	Queries   []QueryProfile    `json:"queries"`   // Sorted by total time, descending
+}
+
+// Profiler records where a script spends its time.
+// It instruments every interpreter step, so it is meant for test runs only.
+type Profiler struct {
+	start     time.Time
+	last      time.Time
+	steps     uint64
+	lastDepth int
+	stack     []*FunctionProfile // Functions on the call stack at the previous step, innermost first
+
+	functions map[string]*FunctionProfile
+	queries   map[string]*QueryProfile
+	order     []string // Query SQL in first-seen order, for stable output
+}
+
+// NewProfiler creates an empty profiler
+func NewProfiler() *Profiler {
+	return &Profiler{
+		functions: make(map[string]*FunctionProfile),
+		queries:   make(map[string]*QueryProfile),
+	}
+}
+
+// onStep attributes the time since the previous step to the functions
+// that were on the call stack, then records the current stack
+func (p *Profiler) onStep(thread *starlark.Thread) {
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	} else {
+		p.attribute(now.Sub(p.last))
+	}
+	p.last = now
+	p.steps++
+
+	depth := thread.CallStackDepth()
+	p.stack = p.stack[:0]
+	for i := 0; i < depth; i++ {
+		callable := thread.DebugFrame(i).Callable()
+		if _, ok := callable.(*starlark.Function); !ok {
+			continue // Builtin time is charged to the calling function
+		}
+		p.stack = append(p.stack, p.function(callable.Name()))
+	}
+
+	// A deeper stack means a function was entered since the previous step
+	if depth > p.lastDepth && len(p.stack) > 0 {
+		p.stack[0].Calls++
+	}
+	p.lastDepth = depth
+}
+
+// attribute charges elapsed time to the previously recorded stack
+func (p *Profiler) attribute(elapsed time.Duration) {
+	if len(p.stack) == 0 {
+		return
+	}
+
+	p.stack[0].self += elapsed
+	for _, fn := range p.stack {
+		// Count recursive frames once
+		if fn.lastStep == p.steps {
+			continue
+		}
+		fn.lastStep = p.steps
+		fn.total += elapsed
+	}
+}
+
+// function returns the profile entry for a function name
+func (p *Profiler) function(name string) *FunctionProfile {
+	fn, ok := p.functions[name]
+	if !ok {
+		fn = &FunctionProfile{Name: name}
+		p.functions[name] = fn
+	}
+	return fn
+}
+
+// recordQuery records a db.query or db.execute call
+func (p *Profiler) recordQuery(thread *starlark.Thread, sqlStr string, elapsed time.Duration, rows int64) {
+	q, ok := p.queries[sqlStr]
+	if !ok {
+		q = &QueryProfile{SQL: sqlStr, Lines: []int32{}}
+		p.queries[sqlStr] = q
+		p.order = append(p.order, sqlStr)
+	}
+
+	q.Calls++
+	q.Rows += rows
+	q.total += elapsed
+	if elapsed > q.max {
+		q.max = elapsed
+	}
+
+	// Frame 0 is the builtin itself; frame 1 is the calling script line
+	if thread.CallStackDepth() > 1 {
+		line := thread.CallFrame(1).Pos.Line
+		if !containsLine(q.Lines, line) {
+			q.Lines = append(q.Lines, line)
+		}
+	}
+}
+
+// containsLine checks if a line number is already recorded
+func containsLine(lines []int32, line int32) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// Report summarizes the recorded profile
+func (p *Profiler) Report() *Profile {
+	profile := &Profile{
+		TotalMS:   milliseconds(p.last.Sub(p.start)),
+		Steps:     p.steps,
+		Functions: []FunctionProfile{},
+		Queries:   []QueryProfile{},
+	}
+
+	for _, fn := range p.functions {
+		entry := *fn
+		entry.SelfMS = milliseconds(fn.self)
+		entry.TotalMS = milliseconds(fn.total)
+		profile.Functions = append(profile.Functions, entry)
+	}
+	sort.SliceStable(profile.Functions, func(i, j int) bool {
+		if profile.Functions[i].total != profile.Functions[j].total {
+			return profile.Functions[i].total > profile.Functions[j].total
+		}
+		return profile.Functions[i].Name < profile.Functions[j].Name
+	})
+
+	for _, sqlStr := range p.order {
+		q := *p.queries[sqlStr]
+		q.TotalMS = milliseconds(q.total)
+		q.MaxMS = milliseconds(q.max)
+		profile.Queries = append(profile.Queries, q)
+	}
+	sort.SliceStable(profile.Queries, func(i, j int) bool {
+		return profile.Queries[i].total > profile.Queries[j].total
+	})
+
+	return profile
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
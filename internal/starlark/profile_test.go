@@ -0,0 +1,92 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestProfiler(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO items (name) VALUES ('a'), ('b'), ('c')`)
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	script := `
+def lookup(id):
+    return db.query("SELECT name FROM items WHERE id = ?", [id])
+
+def square(n):
+    return n * n
+
+def handle_request(req):
+    names = []
+    for i in range(1, 4):
+        names.append(lookup(i)[0]["name"])
+    total = 0
+    for i in range(10):
+        total += square(i)
+    all = db.query("SELECT * FROM items")
+    return response({"names": names, "total": total, "count": len(all)})
+`
+
+	profiler := NewProfiler()
+	execCtx := &ExecutionContext{
+		Request:  httptest.NewRequest("GET", "/test", nil),
+		DB:       db,
+		Profiler: profiler,
+	}
+
+	if _, err := Execute(context.Background(), script, execCtx); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	profile := profiler.Report()
+	if profile.Steps == 0 {
+		t.Error("Expected steps to be recorded")
+	}
+
+	functions := make(map[string]FunctionProfile)
+	for _, fn := range profile.Functions {
+		functions[fn.Name] = fn
+	}
+
+	if functions["lookup"].Calls != 3 {
+		t.Errorf("Expected 3 calls to lookup, got %d", functions["lookup"].Calls)
+	}
+	if functions["square"].Calls != 10 {
+		t.Errorf("Expected 10 calls to square, got %d", functions["square"].Calls)
+	}
+	if functions["handle_request"].TotalMS < functions["square"].TotalMS {
+		t.Errorf("handle_request total (%f) should include square (%f)",
+			functions["handle_request"].TotalMS, functions["square"].TotalMS)
+	}
+
+	if len(profile.Queries) != 2 {
+		t.Fatalf("Expected 2 distinct queries, got %d", len(profile.Queries))
+	}
+	for _, q := range profile.Queries {
+		switch q.SQL {
+		case "SELECT name FROM items WHERE id = ?":
+			if q.Calls != 3 || q.Rows != 3 || len(q.Lines) != 1 || q.Lines[0] != 3 {
+				t.Errorf("Unexpected lookup query profile: %+v", q)
+			}
+		case "SELECT * FROM items":
+			if q.Calls != 1 || q.Rows != 3 || q.Lines[0] != 15 {
+				t.Errorf("Unexpected scan query profile: %+v", q)
+			}
+		default:
+			t.Errorf("Unexpected query: %s", q.SQL)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// Remote reads data another cenv has shared with the executing cenv.
+// The server resolves which tokens to present; scripts only name the cenv.
+type Remote interface {
+	Query(ctx context.Context, cenvID, sql string, params []interface{}) (columns []string, rows [][]interface{}, err error)
+	Document(ctx context.Context, cenvID, id string) (map[string]interface{}, error)
+}
+
+// buildRemoteModule creates the remote module: remote.query(cenv, sql, params?)
+// and remote.document(cenv, id)
+func buildRemoteModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("remote"), starlark.StringDict{
+		"query":    starlark.NewBuiltin("remote.query", makeRemoteQueryFunc(ctx, execCtx)),
+		"document": starlark.NewBuiltin("remote.document", makeRemoteDocumentFunc(ctx, execCtx)),
+	})
+}
+
+// checkRemote reports why the remote module cannot be used, if it cannot
+func checkRemote(execCtx *ExecutionContext, name string) error {
+	if !execCtx.Capabilities.Allows(CapabilityRemote) {
+		return fmt.Errorf("%s requires the %s capability", name, CapabilityRemote)
+	}
+	if execCtx.Remote == nil {
+		return fmt.Errorf("%s: remote access is not available", name)
+	}
+	return nil
+}
+
+// makeRemoteQueryFunc creates the remote.query function
+func makeRemoteQueryFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkRemote(execCtx, fn.Name()); err != nil {
+			return nil, err
+		}
+
+		var cenvID, sqlStr string
+		var paramsVal starlark.Value
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "cenv", &cenvID, "sql", &sqlStr, "params?", &paramsVal); err != nil {
+			return nil, err
+		}
+
+		params, err := listToGo(paramsVal)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		columns, rows, err := execCtx.Remote.Query(ctx, cenvID, sqlStr, params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		result := starlark.NewList([]starlark.Value{})
+		for _, values := range rows {
+			rowDict := starlark.NewDict(len(columns))
+			for i, col := range columns {
+				value, err := starconv.ToStarlark(values[i])
+				if err != nil {
+					return nil, fmt.Errorf("%s: column %s: %w", fn.Name(), col, err)
+				}
+				rowDict.SetKey(starlark.String(col), value)
+			}
+			result.Append(rowDict)
+		}
+
+		return result, nil
+	}
+}
+
+// makeRemoteDocumentFunc creates the remote.document function
+func makeRemoteDocumentFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkRemote(execCtx, fn.Name()); err != nil {
+			return nil, err
+		}
+
+		var cenvID, id string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "cenv", &cenvID, "id", &id); err != nil {
+			return nil, err
+		}
+
+		doc, err := execCtx.Remote.Document(ctx, cenvID, id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		value, err := starconv.ToStarlark(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		return value, nil
+	}
+}
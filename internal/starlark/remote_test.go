@@ -0,0 +1,95 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRemote serves one shared cenv from memory
+type fakeRemote struct {
+	cenvID  string
+	queries []string
+}
+
+func (f *fakeRemote) Query(ctx context.Context, cenvID, sql string, params []interface{}) ([]string, [][]interface{}, error) {
+	if cenvID != f.cenvID {
+		return nil, nil, fmt.Errorf("no shares from cenv %s", cenvID)
+	}
+	f.queries = append(f.queries, sql)
+	return []string{"sku", "qty"}, [][]interface{}{
+		{"A-1", int64(3)},
+		{"B-2", params[0]},
+	}, nil
+}
+
+func (f *fakeRemote) Document(ctx context.Context, cenvID, id string) (map[string]interface{}, error) {
+	if cenvID != f.cenvID {
+		return nil, fmt.Errorf("no shares from cenv %s", cenvID)
+	}
+	return map[string]interface{}{"id": id, "content": "shared"}, nil
+}
+
+func TestExecute_Remote(t *testing.T) {
+	remote := &fakeRemote{cenvID: "source"}
+	execCtx := &ExecutionContext{
+		Request: httptest.NewRequest("GET", "/test", nil),
+		Remote:  remote,
+	}
+
+	script := `
+def handle_request(req):
+    rows = remote.query("source", "SELECT sku, qty FROM stock", [7])
+    doc = remote.document("source", "public/readme.md")
+    return response({"rows": rows, "doc": doc["content"]})
+`
+
+	result, err := Execute(context.Background(), script, execCtx)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	body := result.Body.(map[string]interface{})
+	rows := body["rows"].([]interface{})
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %v", rows)
+	}
+	if row := rows[1].(map[string]interface{}); row["sku"] != "B-2" || row["qty"] != int64(7) {
+		t.Errorf("Unexpected row: %v", row)
+	}
+	if body["doc"] != "shared" {
+		t.Errorf("Unexpected document content: %v", body["doc"])
+	}
+	if len(remote.queries) != 1 {
+		t.Errorf("Expected 1 remote query, got %d", len(remote.queries))
+	}
+}
+
+func TestExecute_RemoteErrors(t *testing.T) {
+	script := `
+def handle_request(req):
+    return response(remote.query("source", "SELECT 1"))
+`
+
+	t.Run("RequiresCapability", func(t *testing.T) {
+		execCtx := &ExecutionContext{
+			Request:      httptest.NewRequest("GET", "/test", nil),
+			Remote:       &fakeRemote{cenvID: "source"},
+			Capabilities: Capabilities{CapabilityDBWrite: true},
+		}
+		_, err := Execute(context.Background(), script, execCtx)
+		if err == nil || !strings.Contains(err.Error(), CapabilityRemote) {
+			t.Errorf("Expected capability error, got %v", err)
+		}
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		execCtx := &ExecutionContext{Request: httptest.NewRequest("GET", "/test", nil)}
+		_, err := Execute(context.Background(), script, execCtx)
+		if err == nil || !strings.Contains(err.Error(), "not available") {
+			t.Errorf("Expected unavailable error, got %v", err)
+		}
+	})
+}
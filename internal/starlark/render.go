@@ -0,0 +1,59 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/starconv"
+	"github.com/thetanil/wce/internal/template"
+)
+
+// buildTemplateModule creates the template module: template.render(id, variables?)
+// renders a template document with the same engine as pages, for email
+// bodies and other non-HTML output
+func buildTemplateModule(ctx context.Context, execCtx *ExecutionContext) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("template"), starlark.StringDict{
+		"render": starlark.NewBuiltin("template.render", makeTemplateRenderFunc(ctx, execCtx)),
+	})
+}
+
+// makeTemplateRenderFunc creates the template.render function. It returns
+// a struct with the rendered content and its content_type. Templates get
+// the script's identity unless the variables name one.
+func makeTemplateRenderFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var id string
+		var variablesVal *starlark.Dict
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "id", &id, "variables?", &variablesVal); err != nil {
+			return nil, err
+		}
+		if execCtx.DB == nil {
+			return nil, fmt.Errorf("%s: no database available", fn.Name())
+		}
+
+		variables := map[string]interface{}{}
+		if variablesVal != nil {
+			converted, err := starconv.ToGo(variablesVal)
+			if err != nil {
+				return nil, fmt.Errorf("%s: variables: %w", fn.Name(), err)
+			}
+			variables = converted.(map[string]interface{})
+		}
+		if _, ok := variables["identity"]; !ok {
+			variables["identity"] = execCtx.identity().Map()
+		}
+
+		rendered, err := template.RenderDocument(ctx, execCtx.DB, id, variables)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		return starlarkstruct.FromStringDict(starlark.String("rendered"), starlark.StringDict{
+			"content":      starlark.String(rendered.Content),
+			"content_type": starlark.String(rendered.ContentType),
+		}), nil
+	}
+}
@@ -0,0 +1,68 @@
+package starlark
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func TestExecute_TemplateRender(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE _wce_documents (
+			id TEXT PRIMARY KEY,
+			content TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			metadata TEXT NOT NULL DEFAULT '{}',
+			compression TEXT
+		)
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO _wce_documents (id, content, content_type) VALUES (?, ?, ?)`,
+		"emails/receipt.txt", "Thanks {{ name }}, order {{ order.id }} is on its way.", "text/plain+jinja")
+	if err != nil {
+		t.Fatalf("Failed to insert template: %v", err)
+	}
+
+	script := `
+def handle_request(req):
+    body = template.render("emails/receipt.txt", {"name": "Ann & Bob", "order": {"id": 42}})
+    return response({"content": body.content, "content_type": body.content_type})
+`
+
+	execCtx := &ExecutionContext{
+		DB:      db,
+		Request: httptest.NewRequest("GET", "/test", nil),
+	}
+	result, err := Execute(context.Background(), script, execCtx)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	body := result.Body.(map[string]interface{})
+	if body["content"] != "Thanks Ann & Bob, order 42 is on its way." {
+		t.Errorf("Unexpected content: %q", body["content"])
+	}
+	if body["content_type"] != "text/plain; charset=utf-8" {
+		t.Errorf("Unexpected content type: %q", body["content_type"])
+	}
+
+	script = `
+def handle_request(req):
+    template.render("emails/missing.txt")
+    return response({})
+`
+	if _, err := Execute(context.Background(), script, execCtx); err == nil {
+		t.Error("Expected rendering a missing template to fail")
+	}
+}
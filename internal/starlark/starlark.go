@@ -6,18 +6,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/mail"
+	"github.com/thetanil/wce/internal/starconv"
 )
 
+// scriptFilename is the name scripts are compiled under, as seen in positions
+const scriptFilename = "script.star"
+
 // ExecutionContext holds the context for executing a Starlark script
 type ExecutionContext struct {
-	DB      *sql.DB
-	UserID  string
-	Request *http.Request
-	Timeout time.Duration
+	DB       *sql.DB
+	UserID   string
+	Identity *auth.Identity // Who the request is made by; nil is UserID alone
+	Request  *http.Request
+	Timeout  time.Duration
+	Debugger *Debugger // Optional; pauses execution at breakpoints
+	Profiler *Profiler // Optional; records time per function and query
+
+	// Capabilities restricts optional builtins; nil grants all
+	Capabilities Capabilities
+
+	// Log receives entries from the log module; nil discards them.
+	// Returning an error aborts the script.
+	Log func(entry LogEntry) error
+
+	// OnQuery is called after each successful db.query and db.execute; optional
+	OnQuery func(sql string, elapsed time.Duration)
+
+	// Remote serves the remote module; nil disables it
+	Remote Remote
+
+	// HTTPClient makes the http module's requests; nil disables it
+	HTTPClient *http.Client
+
+	// Mailer sends the mail module's messages; nil disables it
+	Mailer mail.Mailer
 }
 
 // ExecutionResult holds the result of executing a Starlark script
@@ -38,41 +68,94 @@ func Execute(ctx context.Context, script string, execCtx *ExecutionContext) (*Ex
 	execCtx2, cancel := context.WithTimeout(ctx, execCtx.Timeout)
 	defer cancel()
 
+	thread, handleRequest, err := load(execCtx2, script, "handle_request", execCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Call handle_request with request context
+	requestObj := buildRequestObject(execCtx)
+	args := starlark.Tuple{requestObj}
+	result, err := starlark.Call(thread, handleRequest, args, nil)
+	if err != nil {
+		return nil, fmt.Errorf("handle_request error: %w", err)
+	}
+
+	// Parse the result
+	return parseResult(result)
+}
+
+// Event describes a change a hook script is notified of
+type Event struct {
+	Name       string // e.g. on_document_created
+	DocumentID string
+	Actor      string // user_id of who made the change
+}
+
+// ExecuteHook runs a hook script's handle_event(event) function. The event
+// has name, document_id and actor fields; the return value is ignored.
+// execCtx.Request is not used.
+func ExecuteHook(ctx context.Context, script string, execCtx *ExecutionContext, event *Event) error {
+	if execCtx.Timeout == 0 {
+		execCtx.Timeout = 5 * time.Second
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, execCtx.Timeout)
+	defer cancel()
+
+	thread, handleEvent, err := load(hookCtx, script, "handle_event", execCtx)
+	if err != nil {
+		return err
+	}
+
+	eventObj := starlarkstruct.FromStringDict(starlark.String("event"), starlark.StringDict{
+		"name":        starlark.String(event.Name),
+		"document_id": starlark.String(event.DocumentID),
+		"actor":       starlark.String(event.Actor),
+	})
+	if _, err := starlark.Call(thread, handleEvent, starlark.Tuple{eventObj}, nil); err != nil {
+		return fmt.Errorf("handle_event error: %w", err)
+	}
+	return nil
+}
+
+// load executes a script's top level and returns the thread to call into it
+// with and its entry point function
+func load(ctx context.Context, script, entry string, execCtx *ExecutionContext) (*starlark.Thread, starlark.Callable, error) {
 	// Create thread with execution context
 	thread := &starlark.Thread{
 		Name: "wce-script",
 	}
 
+	var hooks []func(*starlark.Thread)
+	if execCtx.Debugger != nil {
+		hooks = append(hooks, execCtx.Debugger.onStep)
+	}
+	if execCtx.Profiler != nil {
+		hooks = append(hooks, execCtx.Profiler.onStep)
+	}
+	installStepHooks(thread, hooks)
+
 	// Build predeclared environment with safe builtins only
-	predeclared := buildPredeclared(execCtx2, execCtx)
+	predeclared := buildPredeclared(ctx, execCtx)
 
 	// Execute the script
-	globals, err := starlark.ExecFile(thread, "script.star", script, predeclared)
+	globals, err := starlark.ExecFile(thread, scriptFilename, script, predeclared)
 	if err != nil {
-		return nil, fmt.Errorf("script execution error: %w", err)
+		return nil, nil, fmt.Errorf("script execution error: %w", err)
 	}
 
-	// Check if the script returned a handle_request function
-	handleRequestVal, ok := globals["handle_request"]
+	// Check that the script defines its entry point
+	entryVal, ok := globals[entry]
 	if !ok {
-		return nil, fmt.Errorf("script must define a 'handle_request' function")
+		return nil, nil, fmt.Errorf("script must define a '%s' function", entry)
 	}
 
-	handleRequest, ok := handleRequestVal.(starlark.Callable)
+	fn, ok := entryVal.(starlark.Callable)
 	if !ok {
-		return nil, fmt.Errorf("handle_request must be a function")
-	}
-
-	// Call handle_request with request context
-	requestObj := buildRequestObject(execCtx)
-	args := starlark.Tuple{requestObj}
-	result, err := starlark.Call(thread, handleRequest, args, nil)
-	if err != nil {
-		return nil, fmt.Errorf("handle_request error: %w", err)
+		return nil, nil, fmt.Errorf("%s must be a function", entry)
 	}
-
-	// Parse the result
-	return parseResult(result)
+	return thread, fn, nil
 }
 
 // buildPredeclared creates the predeclared environment for Starlark scripts
@@ -90,7 +173,48 @@ func buildPredeclared(ctx context.Context, execCtx *ExecutionContext) starlark.S
 		}),
 		// Response builder
 		"response": starlark.NewBuiltin("response", makeResponseFunc()),
+		// Structured logging
+		"log": buildLogModule(execCtx),
+		// Data shared by other cenvs
+		"remote": buildRemoteModule(ctx, execCtx),
+		// Outbound HTTP requests
+		"http": buildHTTPModule(ctx, execCtx),
+		// Sending mail
+		"mail": buildMailModule(ctx, execCtx),
+		// Key-value store
+		"kv": buildKVModule(ctx, execCtx),
+		// Template documents rendered to their output content type
+		"template": buildTemplateModule(ctx, execCtx),
+		// Saved searches
+		"collections": buildCollectionsModule(ctx, execCtx),
+		// Feature flags
+		"flags": buildFlagsModule(ctx, execCtx),
+		// Who the request is made by
+		"identity": buildIdentity(execCtx),
+	}
+}
+
+// identity returns who a script runs for: the request's identity, or one
+// with just the user id for runs without a token, such as replays
+func (execCtx *ExecutionContext) identity() *auth.Identity {
+	if execCtx.Identity != nil {
+		return execCtx.Identity
+	}
+	if execCtx.UserID == "" {
+		return nil
 	}
+	return &auth.Identity{UserID: execCtx.UserID}
+}
+
+// buildIdentity creates the identity struct: authenticated, id, username,
+// role, scopes and session_id, empty for anonymous requests
+func buildIdentity(execCtx *ExecutionContext) *starlarkstruct.Struct {
+	fields := starlark.StringDict{}
+	for key, value := range execCtx.identity().Map() {
+		converted, _ := starconv.ToStarlark(value)
+		fields[key] = converted
+	}
+	return starlarkstruct.FromStringDict(starlark.String("identity"), fields)
 }
 
 // buildRequestObject creates a Starlark object representing the HTTP request
@@ -118,14 +242,37 @@ func buildRequestObject(execCtx *ExecutionContext) *starlarkstruct.Struct {
 	userDict.SetKey(starlark.String("id"), starlark.String(execCtx.UserID))
 
 	return starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
-		"method":  starlark.String(req.Method),
-		"path":    starlark.String(req.URL.Path),
-		"query":   queryParams,
-		"headers": headers,
-		"user":    userDict,
+		"method":      starlark.String(req.Method),
+		"path":        starlark.String(req.URL.Path),
+		"query":       queryParams,
+		"headers":     headers,
+		"user":        userDict,
+		"query_all":   starlark.NewBuiltin("query_all", makeValuesFunc(req.URL.Query(), false)),
+		"headers_all": starlark.NewBuiltin("headers_all", makeValuesFunc(req.Header, true)),
 	})
 }
 
+// makeValuesFunc creates req.query_all and req.headers_all, which return
+// every value of a repeated query parameter or header as a list. query and
+// headers hold only the first. Header names are matched case-insensitively.
+func makeValuesFunc(values map[string][]string, header bool) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+			return nil, err
+		}
+		if header {
+			key = http.CanonicalHeaderKey(key)
+		}
+
+		list := make([]starlark.Value, len(values[key]))
+		for i, value := range values[key] {
+			list[i] = starlark.String(value)
+		}
+		return starlark.NewList(list), nil
+	}
+}
+
 // makeQueryFunc creates the db.query function
 func makeQueryFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
 	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -138,58 +285,107 @@ func makeQueryFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlar
 		}
 
 		// Convert params to Go values
-		params := []interface{}{}
-		if paramsVal != nil {
-			if list, ok := paramsVal.(*starlark.List); ok {
-				for i := 0; i < list.Len(); i++ {
-					val := list.Index(i)
-					params = append(params, starlarkToGo(val))
-				}
-			}
+		params, err := listToGo(paramsVal)
+		if err != nil {
+			return nil, fmt.Errorf("db.query: %w", err)
 		}
 
-		// Execute query
-		rows, err := execCtx.DB.QueryContext(ctx, sqlStr, params...)
-		if err != nil {
-			return nil, fmt.Errorf("query error: %w", err)
+		// Without the db_write capability, queries run on a connection
+		// that SQLite itself refuses to write through
+		var q queryer = execCtx.DB
+		if !execCtx.Capabilities.Allows(CapabilityDBWrite) {
+			conn, err := execCtx.DB.Conn(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("query error: %w", err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+				return nil, fmt.Errorf("query error: %w", err)
+			}
+			defer conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+
+			q = conn
 		}
-		defer rows.Close()
 
-		// Get column names
-		columns, err := rows.Columns()
+		start := time.Now()
+		result, err := queryRows(ctx, q, sqlStr, params)
 		if err != nil {
 			return nil, err
 		}
 
-		// Build result list
-		result := starlark.NewList([]starlark.Value{})
-		for rows.Next() {
-			// Create slice to hold values
-			values := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range values {
-				valuePtrs[i] = &values[i]
-			}
+		elapsed := time.Since(start)
+		if execCtx.Profiler != nil {
+			execCtx.Profiler.recordQuery(thread, sqlStr, elapsed, int64(result.Len()))
+		}
+		if execCtx.OnQuery != nil {
+			execCtx.OnQuery(sqlStr, elapsed)
+		}
 
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return nil, err
-			}
+		return result, nil
+	}
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Conn
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRows runs a query and converts the rows to a list of dicts
+func queryRows(ctx context.Context, q queryer, sqlStr string, params []interface{}) (*starlark.List, error) {
+	// Execute query
+	rows, err := q.QueryContext(ctx, sqlStr, params...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	// Get column names
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build result list
+	result := starlark.NewList([]starlark.Value{})
+	for rows.Next() {
+		// Create slice to hold values
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, err
+		}
 
-			// Build row dict
-			rowDict := starlark.NewDict(len(columns))
-			for i, col := range columns {
-				rowDict.SetKey(starlark.String(col), goToStarlark(values[i]))
+		// Build row dict
+		rowDict := starlark.NewDict(len(columns))
+		for i, col := range columns {
+			value, err := starconv.ToStarlark(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("query error: column %s: %w", col, err)
 			}
-			result.Append(rowDict)
+			rowDict.SetKey(starlark.String(col), value)
 		}
+		result.Append(rowDict)
+	}
 
-		return result, nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
 	}
+
+	return result, nil
 }
 
 // makeExecuteFunc creates the db.execute function
 func makeExecuteFunc(ctx context.Context, execCtx *ExecutionContext) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
 	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if !execCtx.Capabilities.Allows(CapabilityDBWrite) {
+			return nil, fmt.Errorf("db.execute requires the %s capability", CapabilityDBWrite)
+		}
+
 		// Parse arguments: db.execute(sql, params)
 		var sqlStr string
 		var paramsVal starlark.Value
@@ -199,26 +395,31 @@ func makeExecuteFunc(ctx context.Context, execCtx *ExecutionContext) func(*starl
 		}
 
 		// Convert params to Go values
-		params := []interface{}{}
-		if paramsVal != nil {
-			if list, ok := paramsVal.(*starlark.List); ok {
-				for i := 0; i < list.Len(); i++ {
-					val := list.Index(i)
-					params = append(params, starlarkToGo(val))
-				}
-			}
+		params, err := listToGo(paramsVal)
+		if err != nil {
+			return nil, fmt.Errorf("db.execute: %w", err)
 		}
 
 		// Execute statement
+		start := time.Now()
 		result, err := execCtx.DB.ExecContext(ctx, sqlStr, params...)
 		if err != nil {
 			return nil, fmt.Errorf("execute error: %w", err)
 		}
 
+		elapsed := time.Since(start)
+
 		// Get rows affected
 		rowsAffected, _ := result.RowsAffected()
 		lastInsertID, _ := result.LastInsertId()
 
+		if execCtx.Profiler != nil {
+			execCtx.Profiler.recordQuery(thread, sqlStr, elapsed, rowsAffected)
+		}
+		if execCtx.OnQuery != nil {
+			execCtx.OnQuery(sqlStr, elapsed)
+		}
+
 		// Return result dict
 		resultDict := starlark.NewDict(2)
 		resultDict.SetKey(starlark.String("rows_affected"), starlark.MakeInt64(rowsAffected))
@@ -267,7 +468,10 @@ func jsonEncode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tup
 		return nil, err
 	}
 
-	goVal := starlarkToGo(val)
+	goVal, err := starconv.ToGo(val)
+	if err != nil {
+		return nil, fmt.Errorf("json.encode: %w", err)
+	}
 	jsonBytes, err := json.Marshal(goVal)
 	if err != nil {
 		return nil, err
@@ -283,12 +487,18 @@ func jsonDecode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tup
 		return nil, err
 	}
 
+	// Numbers stay exact, so integers decode as ints however large
+	decoder := json.NewDecoder(strings.NewReader(jsonStr))
+	decoder.UseNumber()
 	var goVal interface{}
-	if err := json.Unmarshal([]byte(jsonStr), &goVal); err != nil {
+	if err := decoder.Decode(&goVal); err != nil {
 		return nil, err
 	}
+	if decoder.More() {
+		return nil, fmt.Errorf("json.decode: unexpected data after the value")
+	}
 
-	return goToStarlark(goVal), nil
+	return starconv.ToStarlark(goVal)
 }
 
 // parseResult converts a Starlark value to an ExecutionResult
@@ -315,8 +525,14 @@ func parseResult(val starlark.Value) (*ExecutionResult, error) {
 	if headersVal, found, _ := dict.Get(starlark.String("headers")); found {
 		if headersDict, ok := headersVal.(*starlark.Dict); ok {
 			for _, item := range headersDict.Items() {
-				key := item[0].(starlark.String).GoString()
-				value := item[1].(starlark.String).GoString()
+				key, ok := starlark.AsString(item[0])
+				if !ok {
+					return nil, fmt.Errorf("response header names must be strings, got %s", item[0].Type())
+				}
+				value, ok := starlark.AsString(item[1])
+				if !ok {
+					return nil, fmt.Errorf("response header %s must be a string, got %s", key, item[1].Type())
+				}
 				result.Headers[key] = value
 			}
 		}
@@ -324,79 +540,12 @@ func parseResult(val starlark.Value) (*ExecutionResult, error) {
 
 	// Get body
 	if bodyVal, found, _ := dict.Get(starlark.String("body")); found {
-		result.Body = starlarkToGo(bodyVal)
-	}
-
-	return result, nil
-}
-
-// starlarkToGo converts a Starlark value to a Go value
-func starlarkToGo(val starlark.Value) interface{} {
-	switch v := val.(type) {
-	case starlark.NoneType:
-		return nil
-	case starlark.Bool:
-		return bool(v)
-	case starlark.Int:
-		i, _ := v.Int64()
-		return i
-	case starlark.Float:
-		return float64(v)
-	case starlark.String:
-		return v.GoString()
-	case *starlark.List:
-		result := make([]interface{}, v.Len())
-		for i := 0; i < v.Len(); i++ {
-			result[i] = starlarkToGo(v.Index(i))
-		}
-		return result
-	case *starlark.Dict:
-		result := make(map[string]interface{})
-		for _, item := range v.Items() {
-			key := starlarkToGo(item[0])
-			value := starlarkToGo(item[1])
-			if keyStr, ok := key.(string); ok {
-				result[keyStr] = value
-			}
+		body, err := starconv.ToGo(bodyVal)
+		if err != nil {
+			return nil, fmt.Errorf("response body: %w", err)
 		}
-		return result
-	default:
-		return v.String()
+		result.Body = body
 	}
-}
 
-// goToStarlark converts a Go value to a Starlark value
-func goToStarlark(val interface{}) starlark.Value {
-	if val == nil {
-		return starlark.None
-	}
-
-	switch v := val.(type) {
-	case bool:
-		return starlark.Bool(v)
-	case int:
-		return starlark.MakeInt(v)
-	case int64:
-		return starlark.MakeInt64(v)
-	case float64:
-		return starlark.Float(v)
-	case string:
-		return starlark.String(v)
-	case []byte:
-		return starlark.String(string(v))
-	case []interface{}:
-		list := make([]starlark.Value, len(v))
-		for i, item := range v {
-			list[i] = goToStarlark(item)
-		}
-		return starlark.NewList(list)
-	case map[string]interface{}:
-		dict := starlark.NewDict(len(v))
-		for key, value := range v {
-			dict.SetKey(starlark.String(key), goToStarlark(value))
-		}
-		return dict
-	default:
-		return starlark.String(fmt.Sprint(v))
-	}
+	return result, nil
 }
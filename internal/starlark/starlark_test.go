@@ -4,10 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/thetanil/wce/internal/auth"
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 func TestExecute_SimpleScript(t *testing.T) {
@@ -123,9 +126,106 @@ def handle_request(req):
 	}
 }
 
+func TestExecute_RepeatedQueryAndHeaders(t *testing.T) {
+	script := `
+def handle_request(req):
+    return response({
+        "tag": req.query["tag"],
+        "tags": req.query_all("tag"),
+        "missing": req.query_all("missing"),
+        "accept": req.headers["Accept"],
+        "accepts": req.headers_all("accept"),
+    })
+`
+
+	req := httptest.NewRequest("GET", "/api/items?tag=a&tag=b", nil)
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	result, err := Execute(context.Background(), script, &ExecutionContext{Request: req})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	body := result.Body.(map[string]interface{})
+	if body["tag"] != "a" || body["accept"] != "text/html" {
+		t.Errorf("Expected single-value accessors to keep the first value, got %v and %v", body["tag"], body["accept"])
+	}
+	if !reflect.DeepEqual(body["tags"], []interface{}{"a", "b"}) {
+		t.Errorf("Unexpected query_all: %#v", body["tags"])
+	}
+	if !reflect.DeepEqual(body["missing"], []interface{}{}) {
+		t.Errorf("Expected no values for a missing parameter, got %#v", body["missing"])
+	}
+	if !reflect.DeepEqual(body["accepts"], []interface{}{"text/html", "application/json"}) {
+		t.Errorf("Unexpected headers_all: %#v", body["accepts"])
+	}
+}
+
+func TestExecute_Identity(t *testing.T) {
+	script := `
+def handle_request(req):
+    return response({
+        "authenticated": identity.authenticated,
+        "id": identity.id,
+        "role": identity.role,
+        "scopes": identity.scopes,
+    })
+`
+
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	execCtx := &ExecutionContext{
+		Request:  req,
+		Identity: &auth.Identity{UserID: "user-1", Username: "alice", Role: "editor", Scopes: []string{"read"}},
+	}
+	result, err := Execute(context.Background(), script, execCtx)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	want := map[string]interface{}{
+		"authenticated": true, "id": "user-1", "role": "editor", "scopes": []interface{}{"read"},
+	}
+	if !reflect.DeepEqual(result.Body, want) {
+		t.Errorf("Unexpected identity: %#v", result.Body)
+	}
+
+	// Anonymous requests see an unauthenticated identity
+	result, err = Execute(context.Background(), script, &ExecutionContext{Request: req})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if body := result.Body.(map[string]interface{}); body["authenticated"] != false || body["id"] != "" {
+		t.Errorf("Expected anonymous identity, got %#v", body)
+	}
+}
+
+func TestExecuteHook(t *testing.T) {
+	script := `
+def handle_event(event):
+    if event.name != "on_document_updated" or event.document_id != "notes/a.md":
+        fail("unexpected event: %s %s" % (event.name, event.document_id))
+    if event.actor != identity.id:
+        fail("actor %s is not the identity %s" % (event.actor, identity.id))
+`
+	event := &Event{Name: "on_document_updated", DocumentID: "notes/a.md", Actor: "user-1"}
+	if err := ExecuteHook(context.Background(), script, &ExecutionContext{UserID: "user-1"}, event); err != nil {
+		t.Errorf("ExecuteHook failed: %v", err)
+	}
+
+	event.DocumentID = "notes/b.md"
+	err := ExecuteHook(context.Background(), script, &ExecutionContext{UserID: "user-1"}, event)
+	if err == nil || !strings.Contains(err.Error(), "unexpected event") {
+		t.Errorf("Expected the hook's failure, got %v", err)
+	}
+
+	err = ExecuteHook(context.Background(), "def handle_request(req):\n    pass\n", &ExecutionContext{}, event)
+	if err == nil || err.Error() != "script must define a 'handle_event' function" {
+		t.Errorf("Expected a missing handle_event to fail, got %v", err)
+	}
+}
+
 func TestExecute_DatabaseQuery(t *testing.T) {
 	// Create in-memory database
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open database: %v", err)
 	}
@@ -183,7 +283,7 @@ def handle_request(req):
 
 func TestExecute_DatabaseExecute(t *testing.T) {
 	// Create in-memory database
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open database: %v", err)
 	}
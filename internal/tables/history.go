@@ -0,0 +1,379 @@
+package tables
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Row history operations
+const (
+	OperationInsert = "INSERT"
+	OperationUpdate = "UPDATE"
+	OperationDelete = "DELETE"
+)
+
+// HistoryEntry is a recorded change to a row of a user table
+type HistoryEntry struct {
+	ID        int64           `json:"id"`
+	Table     string          `json:"table"`
+	RowID     string          `json:"row_id"`
+	Operation string          `json:"operation"`
+	Before    json.RawMessage `json:"before"` // null for INSERT
+	After     json.RawMessage `json:"after"`  // null for DELETE
+	ChangedAt int64           `json:"changed_at"`
+}
+
+// execQueryer is satisfied by both *sql.DB and *sql.Tx
+type execQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// historyTrigger returns the name of the history trigger for an operation
+func historyTrigger(table, operation string) string {
+	return "_wce_history_" + table + "_" + strings.ToLower(operation)
+}
+
+// rowKey returns the column identifying rows in history: the single-column
+// primary key if there is one, otherwise the rowid
+func rowKey(q execQueryer, table string) (string, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_table_info(?) WHERE pk > 0`, table)
+	if err != nil {
+		return "", fmt.Errorf("failed to query primary key: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", fmt.Errorf("failed to scan primary key: %w", err)
+		}
+		keys = append(keys, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating primary key: %w", err)
+	}
+
+	if len(keys) == 1 {
+		return keys[0], nil
+	}
+	return "rowid", nil
+}
+
+// rowImage builds a json_object() expression of every column of a trigger row.
+// BLOB values are hex encoded since JSON cannot hold them.
+func rowImage(columns []string, alias string) string {
+	parts := make([]string, 0, len(columns)*2)
+	for _, column := range columns {
+		ref := fmt.Sprintf(`%s."%s"`, alias, column)
+		parts = append(parts, quoteLiteral(column),
+			fmt.Sprintf(`CASE WHEN typeof(%s) = 'blob' THEN hex(%s) ELSE %s END`, ref, ref, ref))
+	}
+	return "json_object(" + strings.Join(parts, ", ") + ")"
+}
+
+// installHistoryTriggers (re)creates the history triggers for a table from its current columns
+func installHistoryTriggers(q execQueryer, table string) error {
+	if err := dropHistoryTriggers(q, table); err != nil {
+		return err
+	}
+
+	columnRows, err := q.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return fmt.Errorf("failed to query columns: %w", err)
+	}
+	var columns []string
+	for columnRows.Next() {
+		var name string
+		if err := columnRows.Scan(&name); err != nil {
+			columnRows.Close()
+			return fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	columnRows.Close()
+	if err := columnRows.Err(); err != nil {
+		return fmt.Errorf("error iterating columns: %w", err)
+	}
+
+	key, err := rowKey(q, table)
+	if err != nil {
+		return err
+	}
+	keyRef := func(alias string) string {
+		if key == "rowid" {
+			return alias + ".rowid"
+		}
+		return fmt.Sprintf(`%s."%s"`, alias, key)
+	}
+
+	triggers := []struct {
+		operation string
+		row       string
+		before    string
+		after     string
+	}{
+		{OperationInsert, keyRef("NEW"), "NULL", rowImage(columns, "NEW")},
+		{OperationUpdate, keyRef("NEW"), rowImage(columns, "OLD"), rowImage(columns, "NEW")},
+		{OperationDelete, keyRef("OLD"), rowImage(columns, "OLD"), "NULL"},
+	}
+
+	// Identifiers are validated by callers, so they are safe to interpolate
+	for _, trigger := range triggers {
+		statement := fmt.Sprintf(`
+			CREATE TRIGGER "%s" AFTER %s ON "%s"
+			BEGIN
+				INSERT INTO _wce_row_history (table_name, row_id, operation, before_image, after_image, changed_at)
+				VALUES (%s, CAST(%s AS TEXT), '%s', %s, %s, strftime('%%s', 'now'));
+			END
+		`, historyTrigger(table, trigger.operation), trigger.operation, table,
+			quoteLiteral(table), trigger.row, trigger.operation, trigger.before, trigger.after)
+
+		if _, err := q.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create history trigger: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// dropHistoryTriggers removes the history triggers for a table, if present
+func dropHistoryTriggers(q execQueryer, table string) error {
+	for _, operation := range []string{OperationInsert, OperationUpdate, OperationDelete} {
+		if _, err := q.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s"`, historyTrigger(table, operation))); err != nil {
+			return fmt.Errorf("failed to drop history trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+// HistoryEnabled reports whether history triggers are installed on a table
+func HistoryEnabled(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'trigger' AND tbl_name = ? COLLATE NOCASE AND name LIKE '\_wce\_history\_%' ESCAPE '\'
+	`, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check history triggers: %w", err)
+	}
+	return count > 0, nil
+}
+
+// EnableHistory installs history triggers on a user table.
+// Calling it again refreshes the triggers after the table's columns change.
+func EnableHistory(db *sql.DB, table string) error {
+	if err := validateUserTable(db, table); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := installHistoryTriggers(tx, table); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DisableHistory removes history triggers from a user table.
+// Previously recorded history is kept.
+func DisableHistory(db *sql.DB, table string) error {
+	if err := validateUserTable(db, table); err != nil {
+		return err
+	}
+	return dropHistoryTriggers(db, table)
+}
+
+// RowHistory lists the recorded changes to a row, newest first
+func RowHistory(db *sql.DB, table, rowID string, limit int) ([]HistoryEntry, error) {
+	if err := validateUserTable(db, table); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, table_name, row_id, operation, before_image, after_image, changed_at
+		FROM _wce_row_history
+		WHERE table_name = ? COLLATE NOCASE AND row_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, table, rowID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query row history: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating row history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// scanHistoryEntry scans a history row selected in the standard column order
+func scanHistoryEntry(scanner interface{ Scan(...interface{}) error }) (*HistoryEntry, error) {
+	var entry HistoryEntry
+	var before, after sql.NullString
+
+	err := scanner.Scan(&entry.ID, &entry.Table, &entry.RowID, &entry.Operation, &before, &after, &entry.ChangedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Before = json.RawMessage("null")
+	if before.Valid {
+		entry.Before = json.RawMessage(before.String)
+	}
+	entry.After = json.RawMessage("null")
+	if after.Valid {
+		entry.After = json.RawMessage(after.String)
+	}
+
+	return &entry, nil
+}
+
+// RestoreRow undoes a recorded change by returning the row to its state before
+// that change: rows created by the change are deleted, others are rewritten
+// from the before image. The restore is itself recorded in the row's history.
+func RestoreRow(db *sql.DB, table, rowID string, entryID int64) error {
+	if err := validateUserTable(db, table); err != nil {
+		return err
+	}
+
+	entry, err := scanHistoryEntry(db.QueryRow(`
+		SELECT id, table_name, row_id, operation, before_image, after_image, changed_at
+		FROM _wce_row_history
+		WHERE id = ? AND table_name = ? COLLATE NOCASE AND row_id = ?
+	`, entryID, table, rowID))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("history entry not found: %d", entryID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query history entry: %w", err)
+	}
+
+	schema, err := DescribeTable(db, table)
+	if err != nil {
+		return err
+	}
+
+	key, err := rowKey(db, schema.Name)
+	if err != nil {
+		return err
+	}
+	keyRef := `"` + key + `"`
+	if key == "rowid" {
+		keyRef = "rowid"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if entry.Operation == OperationInsert {
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE %s = ?`, schema.Name, keyRef), rowID)
+		if err != nil {
+			return fmt.Errorf("failed to restore row: %w", err)
+		}
+		return commitRestore(tx)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(entry.Before))
+	decoder.UseNumber()
+	var image map[string]interface{}
+	if err := decoder.Decode(&image); err != nil {
+		return fmt.Errorf("failed to decode history entry: %w", err)
+	}
+
+	// Only columns that still exist are restored
+	var columns, assignments []string
+	var values []interface{}
+	for _, column := range schema.Columns {
+		value, ok := image[column.Name]
+		if !ok {
+			continue
+		}
+		value, err := restoredValue(column, value)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, `"`+column.Name+`"`)
+		assignments = append(assignments, `"`+column.Name+`" = ?`)
+		values = append(values, value)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("history entry has no columns in common with table %s", schema.Name)
+	}
+
+	// Update in place rather than REPLACE, which would fire ON DELETE actions
+	result, err := tx.Exec(fmt.Sprintf(`UPDATE "%s" SET %s WHERE %s = ?`,
+		schema.Name, strings.Join(assignments, ", "), keyRef), append(values, rowID)...)
+	if err != nil {
+		return fmt.Errorf("failed to restore row: %w", err)
+	}
+
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`,
+			schema.Name, strings.Join(columns, ", "), placeholders), values...)
+		if err != nil {
+			return fmt.Errorf("failed to restore row: %w", err)
+		}
+	}
+
+	return commitRestore(tx)
+}
+
+// commitRestore commits a row restore
+func commitRestore(tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// restoredValue converts a value from a history image back to a column value
+func restoredValue(column Column, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case string:
+		// BLOB values were hex encoded when recorded
+		if strings.EqualFold(column.Type, "BLOB") {
+			if data, err := hex.DecodeString(v); err == nil {
+				return data, nil
+			}
+		}
+		return v, nil
+	}
+	return value, nil
+}
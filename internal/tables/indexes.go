@@ -0,0 +1,170 @@
+package tables
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Index describes an index on a user table
+type Index struct {
+	Name    string   `json:"name"`
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+	Origin  string   `json:"origin"` // "c" (CREATE INDEX), "u" (UNIQUE constraint), "pk" (PRIMARY KEY)
+}
+
+// ListIndexes lists the indexes on a user table
+func ListIndexes(db *sql.DB, table string) ([]Index, error) {
+	if err := validateUserTable(db, table); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT name, "unique", origin FROM pragma_index_list(?) ORDER BY name`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+
+	indexes := []Index{}
+	for rows.Next() {
+		index := Index{Table: table}
+		if err := rows.Scan(&index.Name, &index.Unique, &index.Origin); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating indexes: %w", err)
+	}
+
+	for i := range indexes {
+		columns, err := indexColumns(db, indexes[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		indexes[i].Columns = columns
+	}
+
+	return indexes, nil
+}
+
+// indexColumns returns the columns of an index in key order
+func indexColumns(db *sql.DB, index string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_index_info(?) ORDER BY seqno`, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query index columns: %w", err)
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan index column: %w", err)
+		}
+		// Expression columns have no name
+		columns = append(columns, name.String)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating index columns: %w", err)
+	}
+
+	return columns, nil
+}
+
+// CreateIndex creates an index on columns of a user table.
+// An empty name defaults to idx_<table>_<columns>.
+func CreateIndex(db *sql.DB, table, name string, columns []string, unique bool) (*Index, error) {
+	if err := validateUserTable(db, table); err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("at least one column is required")
+	}
+
+	existing, err := tableColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make([]string, 0, len(columns))
+	seen := make(map[string]bool)
+	for _, column := range columns {
+		declared, ok := existing[strings.ToLower(column)]
+		if !ok {
+			return nil, fmt.Errorf("column not found: %s", column)
+		}
+		if seen[strings.ToLower(column)] {
+			return nil, fmt.Errorf("duplicate column: %s", column)
+		}
+		seen[strings.ToLower(column)] = true
+		resolved = append(resolved, declared)
+	}
+
+	if name == "" {
+		name = strings.ToLower("idx_" + table + "_" + strings.Join(resolved, "_"))
+	}
+	if !IsValidIdentifier(name) {
+		return nil, fmt.Errorf("invalid index name: %s", name)
+	}
+	if IsReservedName(name) {
+		return nil, fmt.Errorf("index name is reserved: %s", name)
+	}
+
+	// Identifiers are validated above, so they are safe to interpolate
+	statement := "CREATE INDEX"
+	if unique {
+		statement = "CREATE UNIQUE INDEX"
+	}
+	_, err = db.Exec(fmt.Sprintf(`%s "%s" ON "%s" (%s)`,
+		statement, name, table, `"`+strings.Join(resolved, `", "`)+`"`))
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return nil, fmt.Errorf("index already exists: %s", name)
+		}
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, fmt.Errorf("existing rows violate uniqueness on %s", strings.Join(resolved, ", "))
+		}
+		return nil, fmt.Errorf("failed to create index: %w", err)
+	}
+
+	return &Index{Name: name, Table: table, Columns: resolved, Unique: unique, Origin: "c"}, nil
+}
+
+// DropIndex drops an index created on a user table.
+// Indexes backing PRIMARY KEY or UNIQUE constraints cannot be dropped.
+func DropIndex(db *sql.DB, table, name string) error {
+	if err := validateUserTable(db, table); err != nil {
+		return err
+	}
+	if !IsValidIdentifier(name) {
+		return fmt.Errorf("invalid index name: %s", name)
+	}
+	if IsReservedName(name) {
+		return fmt.Errorf("index name is reserved: %s", name)
+	}
+
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'index' AND name = ? AND tbl_name = ?
+	`, name, table).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check index: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("index not found: %s", name)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`DROP INDEX "%s"`, name)); err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+
+	return nil
+}
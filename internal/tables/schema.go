@@ -0,0 +1,478 @@
+package tables
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Column types accepted by the schema builder (SQLite type affinities)
+var columnTypes = map[string]bool{
+	"INTEGER": true,
+	"REAL":    true,
+	"TEXT":    true,
+	"BLOB":    true,
+	"NUMERIC": true,
+}
+
+// Referential actions for ON DELETE and ON UPDATE
+const (
+	ActionNoAction   = "NO ACTION"
+	ActionRestrict   = "RESTRICT"
+	ActionCascade    = "CASCADE"
+	ActionSetNull    = "SET NULL"
+	ActionSetDefault = "SET DEFAULT"
+)
+
+// IsValidAction checks if a referential action is supported
+func IsValidAction(action string) bool {
+	switch action {
+	case ActionNoAction, ActionRestrict, ActionCascade, ActionSetNull, ActionSetDefault:
+		return true
+	}
+	return false
+}
+
+// Column defines or describes a table column
+type Column struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	NotNull    bool    `json:"not_null"`
+	PrimaryKey bool    `json:"primary_key"`
+	Unique     bool    `json:"unique,omitempty"`
+	Default    *string `json:"default,omitempty"` // Stored as a text literal; affinity converts it
+}
+
+// Relationship is a foreign key from a column to a column of another (or the same) table
+type Relationship struct {
+	Table            string `json:"table,omitempty"` // Referencing table (set on introspection)
+	Column           string `json:"column"`
+	References       string `json:"references"`
+	ReferencesColumn string `json:"references_column,omitempty"` // Defaults to the referenced primary key
+	OnDelete         string `json:"on_delete,omitempty"`         // Defaults to NO ACTION
+	OnUpdate         string `json:"on_update,omitempty"`         // Defaults to NO ACTION
+}
+
+// TableDefinition describes a table to create with the schema builder
+type TableDefinition struct {
+	Name          string         `json:"name"`
+	Columns       []Column       `json:"columns"`
+	Relationships []Relationship `json:"relationships"`
+	History       bool           `json:"history"` // Record row changes in _wce_row_history
+}
+
+// TableSchema describes an existing user table
+type TableSchema struct {
+	Name          string         `json:"name"`
+	Columns       []Column       `json:"columns"`
+	Indexes       []Index        `json:"indexes"`
+	Relationships []Relationship `json:"relationships"` // Foreign keys declared on this table
+	ReferencedBy  []Relationship `json:"referenced_by"` // Foreign keys in other tables pointing here
+	History       bool           `json:"history"`       // Whether row changes are recorded
+	SoftDelete    bool           `json:"soft_delete"`   // Whether deletes set deleted_at instead
+}
+
+// CreateTable creates a user table from a definition
+func CreateTable(db *sql.DB, def *TableDefinition) error {
+	if !IsValidIdentifier(def.Name) {
+		return fmt.Errorf("invalid table name: %s", def.Name)
+	}
+	if IsReservedName(def.Name) {
+		return fmt.Errorf("table name is reserved: %s", def.Name)
+	}
+	if len(def.Columns) == 0 {
+		return fmt.Errorf("at least one column is required")
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = ? COLLATE NOCASE`, def.Name).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check table: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("table already exists: %s", def.Name)
+	}
+
+	// Validate columns and build their definitions
+	columns := make(map[string]Column, len(def.Columns))
+	var definitions []string
+	var primaryKey []string
+
+	for _, column := range def.Columns {
+		if !IsValidIdentifier(column.Name) {
+			return fmt.Errorf("invalid column name: %s", column.Name)
+		}
+		if _, exists := columns[strings.ToLower(column.Name)]; exists {
+			return fmt.Errorf("duplicate column: %s", column.Name)
+		}
+
+		column.Type = strings.ToUpper(column.Type)
+		if !columnTypes[column.Type] {
+			return fmt.Errorf("invalid column type for %s: %s", column.Name, column.Type)
+		}
+		columns[strings.ToLower(column.Name)] = column
+
+		definition := fmt.Sprintf(`"%s" %s`, column.Name, column.Type)
+		if column.NotNull {
+			definition += " NOT NULL"
+		}
+		if column.Unique {
+			definition += " UNIQUE"
+		}
+		if column.Default != nil {
+			definition += " DEFAULT " + quoteLiteral(*column.Default)
+		}
+		definitions = append(definitions, definition)
+
+		if column.PrimaryKey {
+			primaryKey = append(primaryKey, `"`+column.Name+`"`)
+		}
+	}
+
+	if len(primaryKey) > 0 {
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(primaryKey, ", ")+")")
+	}
+
+	// Validate relationships and build their constraints
+	for i := range def.Relationships {
+		rel := &def.Relationships[i]
+
+		column, ok := columns[strings.ToLower(rel.Column)]
+		if !ok {
+			return fmt.Errorf("relationship column not found: %s", rel.Column)
+		}
+
+		if err := resolveReference(db, def, rel); err != nil {
+			return err
+		}
+
+		if rel.OnDelete == "" {
+			rel.OnDelete = ActionNoAction
+		}
+		if rel.OnUpdate == "" {
+			rel.OnUpdate = ActionNoAction
+		}
+		rel.OnDelete = strings.ToUpper(rel.OnDelete)
+		rel.OnUpdate = strings.ToUpper(rel.OnUpdate)
+		if !IsValidAction(rel.OnDelete) {
+			return fmt.Errorf("invalid on_delete action: %s", rel.OnDelete)
+		}
+		if !IsValidAction(rel.OnUpdate) {
+			return fmt.Errorf("invalid on_update action: %s", rel.OnUpdate)
+		}
+		if (rel.OnDelete == ActionSetNull || rel.OnUpdate == ActionSetNull) && column.NotNull {
+			return fmt.Errorf("column %s is NOT NULL and cannot use SET NULL", column.Name)
+		}
+
+		definitions = append(definitions, fmt.Sprintf(
+			`FOREIGN KEY ("%s") REFERENCES "%s" ("%s") ON DELETE %s ON UPDATE %s`,
+			column.Name, rel.References, rel.ReferencesColumn, rel.OnDelete, rel.OnUpdate,
+		))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Identifiers are validated above, so they are safe to interpolate
+	statement := fmt.Sprintf("CREATE TABLE \"%s\" (\n    %s\n)", def.Name, strings.Join(definitions, ",\n    "))
+	if _, err := tx.Exec(statement); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	if def.History {
+		if err := installHistoryTriggers(tx, def.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// resolveReference validates a relationship target and fills in the referenced column.
+// The referenced column must be a primary key or unique, as SQLite requires.
+func resolveReference(db *sql.DB, def *TableDefinition, rel *Relationship) error {
+	if !IsValidIdentifier(rel.References) {
+		return fmt.Errorf("invalid referenced table: %s", rel.References)
+	}
+
+	// Self-references are checked against the definition being created
+	if strings.EqualFold(rel.References, def.Name) {
+		rel.References = def.Name
+		singleKey := countPrimaryKey(def.Columns) == 1
+
+		for _, column := range def.Columns {
+			implicit := rel.ReferencesColumn == "" && column.PrimaryKey && singleKey
+			if !implicit && !strings.EqualFold(column.Name, rel.ReferencesColumn) {
+				continue
+			}
+			if !column.Unique && !(column.PrimaryKey && singleKey) {
+				return fmt.Errorf("referenced column %s.%s must be a primary key or unique", def.Name, column.Name)
+			}
+			rel.ReferencesColumn = column.Name
+			return nil
+		}
+
+		if rel.ReferencesColumn == "" {
+			return fmt.Errorf("table %s has no single-column primary key to reference", def.Name)
+		}
+		return fmt.Errorf("referenced column not found: %s.%s", def.Name, rel.ReferencesColumn)
+	}
+
+	if err := validateUserTable(db, rel.References); err != nil {
+		return err
+	}
+
+	target, err := DescribeTable(db, rel.References)
+	if err != nil {
+		return err
+	}
+	rel.References = target.Name
+
+	keyed := keyedColumns(target)
+	if rel.ReferencesColumn == "" {
+		var primaryKey []string
+		for _, column := range target.Columns {
+			if column.PrimaryKey {
+				primaryKey = append(primaryKey, column.Name)
+			}
+		}
+		if len(primaryKey) != 1 {
+			return fmt.Errorf("table %s has no single-column primary key to reference", target.Name)
+		}
+		rel.ReferencesColumn = primaryKey[0]
+		return nil
+	}
+
+	for _, column := range target.Columns {
+		if strings.EqualFold(column.Name, rel.ReferencesColumn) {
+			if !keyed[strings.ToLower(column.Name)] {
+				return fmt.Errorf("referenced column %s.%s must be a primary key or unique", target.Name, column.Name)
+			}
+			rel.ReferencesColumn = column.Name
+			return nil
+		}
+	}
+
+	return fmt.Errorf("referenced column not found: %s.%s", target.Name, rel.ReferencesColumn)
+}
+
+// countPrimaryKey counts the primary key columns of a definition
+func countPrimaryKey(columns []Column) int {
+	count := 0
+	for _, column := range columns {
+		if column.PrimaryKey {
+			count++
+		}
+	}
+	return count
+}
+
+// keyedColumns returns the lowercase names of columns that uniquely identify a row
+func keyedColumns(schema *TableSchema) map[string]bool {
+	keyed := make(map[string]bool)
+
+	if countPrimaryKey(schema.Columns) == 1 {
+		for _, column := range schema.Columns {
+			if column.PrimaryKey {
+				keyed[strings.ToLower(column.Name)] = true
+			}
+		}
+	}
+
+	for _, index := range schema.Indexes {
+		if index.Unique && len(index.Columns) == 1 {
+			keyed[strings.ToLower(index.Columns[0])] = true
+		}
+	}
+
+	return keyed
+}
+
+// DescribeTable returns the columns, indexes and relationships of a user table
+func DescribeTable(db *sql.DB, table string) (*TableSchema, error) {
+	if err := validateUserTable(db, table); err != nil {
+		return nil, err
+	}
+
+	// Use the declared spelling of the table name
+	if err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE`, table).Scan(&table); err != nil {
+		return nil, fmt.Errorf("failed to query table: %w", err)
+	}
+
+	schema := &TableSchema{Name: table}
+
+	rows, err := db.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	for rows.Next() {
+		var column Column
+		var defaultValue sql.NullString
+		var pk int
+		if err := rows.Scan(&column.Name, &column.Type, &column.NotNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		column.PrimaryKey = pk > 0
+		if defaultValue.Valid {
+			value := unquoteLiteral(defaultValue.String)
+			column.Default = &value
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating columns: %w", err)
+	}
+
+	schema.Indexes, err = ListIndexes(db, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := range schema.Columns {
+		for _, index := range schema.Indexes {
+			if index.Unique && len(index.Columns) == 1 && strings.EqualFold(index.Columns[0], schema.Columns[i].Name) {
+				schema.Columns[i].Unique = true
+			}
+		}
+	}
+
+	schema.Relationships, err = foreignKeys(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.ReferencedBy, err = referencingKeys(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.History, err = HistoryEnabled(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.SoftDelete, err = SoftDeleteEnabled(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// foreignKeys returns the single-column foreign keys declared on a table
+func foreignKeys(db *sql.DB, table string) ([]Relationship, error) {
+	rows, err := db.Query(`
+		SELECT "table", "from", "to", on_delete, on_update
+		FROM pragma_foreign_key_list(?)
+		WHERE id IN (SELECT id FROM pragma_foreign_key_list(?) GROUP BY id HAVING COUNT(*) = 1)
+		ORDER BY id
+	`, table, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	relationships := []Relationship{}
+	for rows.Next() {
+		rel := Relationship{Table: table}
+		var to sql.NullString
+		if err := rows.Scan(&rel.References, &rel.Column, &to, &rel.OnDelete, &rel.OnUpdate); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key: %w", err)
+		}
+		rel.ReferencesColumn = to.String
+		relationships = append(relationships, rel)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating foreign keys: %w", err)
+	}
+	rows.Close()
+
+	// A NULL target column means the referenced table's primary key
+	for i := range relationships {
+		if relationships[i].ReferencesColumn != "" {
+			continue
+		}
+		err := db.QueryRow(`SELECT name FROM pragma_table_info(?) WHERE pk = 1`, relationships[i].References).
+			Scan(&relationships[i].ReferencesColumn)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to resolve referenced column: %w", err)
+		}
+	}
+
+	return relationships, nil
+}
+
+// referencingKeys returns the foreign keys in user tables that point at a table
+func referencingKeys(db *sql.DB, table string) ([]Relationship, error) {
+	names, err := ListTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships := []Relationship{}
+	for _, name := range names {
+		keys, err := foreignKeys(db, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if strings.EqualFold(key.References, table) {
+				relationships = append(relationships, key)
+			}
+		}
+	}
+
+	return relationships, nil
+}
+
+// ListTables lists the names of all user tables
+func ListTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT name FROM sqlite_master
+		WHERE type = 'table'
+		AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		AND name NOT LIKE '\_wce\_%' ESCAPE '\'
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tables: %w", err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tables: %w", err)
+	}
+
+	return names, nil
+}
+
+// quoteLiteral quotes a value as a SQL string literal
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// unquoteLiteral reverses quoteLiteral; other default expressions are returned as-is
+func unquoteLiteral(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value
+}
@@ -0,0 +1,152 @@
+package tables
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SoftDeleteColumn marks a row as deleted in soft delete tables.
+// It holds the Unix timestamp of the delete, or NULL for live rows.
+const SoftDeleteColumn = "deleted_at"
+
+// SoftDeleteEnabled reports whether a table uses soft delete
+func SoftDeleteEnabled(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM _wce_soft_delete_tables WHERE table_name = ?`, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check soft delete: %w", err)
+	}
+	return count > 0, nil
+}
+
+// SoftDeleteTables lists the tables that use soft delete, ordered by name
+func SoftDeleteTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT table_name FROM _wce_soft_delete_tables ORDER BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list soft delete tables: %w", err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan soft delete table: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating soft delete tables: %w", err)
+	}
+	return names, nil
+}
+
+// EnableSoftDelete makes deletes on a user table set its deleted_at column
+// instead of removing rows. The column is added if the table lacks one.
+func EnableSoftDelete(db *sql.DB, table, userID string) error {
+	if err := validateUserTable(db, table); err != nil {
+		return err
+	}
+
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+
+	history, err := HistoryEnabled(db, table)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, ok := columns[SoftDeleteColumn]; !ok {
+		_, err := tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN %s INTEGER`, table, SoftDeleteColumn))
+		if err != nil {
+			return fmt.Errorf("failed to add %s column: %w", SoftDeleteColumn, err)
+		}
+
+		// History images are built from the column list, so include the new column
+		if history {
+			if err := installHistoryTriggers(tx, table); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO _wce_soft_delete_tables (table_name, enabled_at, enabled_by)
+		VALUES (?, ?, ?)
+	`, table, time.Now().Unix(), nullIfEmpty(userID))
+	if err != nil {
+		return fmt.Errorf("failed to enable soft delete: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DisableSoftDelete returns a table to hard deletes.
+// The deleted_at column and any soft deleted rows are kept.
+func DisableSoftDelete(db *sql.DB, table string) error {
+	if err := validateUserTable(db, table); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`DELETE FROM _wce_soft_delete_tables WHERE table_name = ?`, table); err != nil {
+		return fmt.Errorf("failed to disable soft delete: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeDeleted permanently removes soft deleted rows from a table.
+// If before is non-zero, only rows deleted at or before that Unix time are removed.
+func PurgeDeleted(db *sql.DB, table string, before int64) (int64, error) {
+	if err := validateUserTable(db, table); err != nil {
+		return 0, err
+	}
+
+	enabled, err := SoftDeleteEnabled(db, table)
+	if err != nil {
+		return 0, err
+	}
+	if !enabled {
+		return 0, fmt.Errorf("soft delete is not enabled on table %s", table)
+	}
+
+	query := fmt.Sprintf(`DELETE FROM "%s" WHERE %s IS NOT NULL`, table, SoftDeleteColumn)
+	var args []interface{}
+	if before > 0 {
+		query += fmt.Sprintf(` AND %s <= ?`, SoftDeleteColumn)
+		args = append(args, before)
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted rows: %w", err)
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	return purged, nil
+}
+
+// nullIfEmpty converts an empty string to NULL for SQLite
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package tables
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TableSize is the space a table and its indexes take in a cenv database
+type TableSize struct {
+	Name      string `json:"name"`
+	Rows      int64  `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+	Estimated bool   `json:"estimated"` // Bytes summed from column values rather than pages
+}
+
+// Storage breaks down a cenv database's file by table. FreeBytes is space in
+// the file no table uses, which VACUUM returns to the filesystem.
+type Storage struct {
+	PageSize   int64       `json:"page_size"`
+	PageCount  int64       `json:"page_count"`
+	FreePages  int64       `json:"free_pages"`
+	TotalBytes int64       `json:"total_bytes"`
+	FreeBytes  int64       `json:"free_bytes"`
+	Tables     []TableSize `json:"tables"` // Largest first
+}
+
+// StorageReport measures every table in the database, WCE's own included.
+// Table sizes come from the dbstat virtual table when SQLite is built with
+// it, counting each table's indexes against it; otherwise they are estimated
+// from the stored length of every column value.
+func StorageReport(db *sql.DB) (*Storage, error) {
+	storage := &Storage{}
+	for pragma, value := range map[string]*int64{
+		"page_size":      &storage.PageSize,
+		"page_count":     &storage.PageCount,
+		"freelist_count": &storage.FreePages,
+	} {
+		if err := db.QueryRow("PRAGMA " + pragma).Scan(value); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pragma, err)
+		}
+	}
+	storage.TotalBytes = storage.PageSize * storage.PageCount
+	storage.FreeBytes = storage.PageSize * storage.FreePages
+
+	rows, err := db.Query(`
+		SELECT name, sql FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var names []string
+	virtual := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var createSQL sql.NullString
+		if err := rows.Scan(&name, &createSQL); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		names = append(names, name)
+		virtual[name] = strings.HasPrefix(strings.ToUpper(createSQL.String), "CREATE VIRTUAL")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	pageBytes, err := tablePageBytes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.Tables = []TableSize{}
+	for _, name := range names {
+		// Virtual tables (full-text indexes) keep their data in shadow
+		// tables, which are listed and measured themselves
+		if virtual[name] {
+			continue
+		}
+
+		size := TableSize{Name: name}
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + quoteIdentifier(name)).Scan(&size.Rows); err != nil {
+			return nil, fmt.Errorf("failed to count rows of %s: %w", name, err)
+		}
+		if pageBytes != nil {
+			size.Bytes = pageBytes[name]
+		} else {
+			size.Estimated = true
+			if size.Bytes, err = estimateTableBytes(db, name); err != nil {
+				return nil, err
+			}
+		}
+		storage.Tables = append(storage.Tables, size)
+	}
+
+	sort.SliceStable(storage.Tables, func(i, j int) bool {
+		return storage.Tables[i].Bytes > storage.Tables[j].Bytes
+	})
+	return storage, nil
+}
+
+// tablePageBytes returns the bytes of pages each table and its indexes use,
+// or nil when SQLite is built without dbstat
+func tablePageBytes(db *sql.DB) (map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT m.tbl_name, SUM(d.pgsize)
+		FROM dbstat d JOIN sqlite_master m ON m.name = d.name
+		GROUP BY m.tbl_name
+	`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table: dbstat") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read page usage: %w", err)
+	}
+	defer rows.Close()
+
+	pageBytes := map[string]int64{}
+	for rows.Next() {
+		var name string
+		var bytes int64
+		if err := rows.Scan(&name, &bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan page usage: %w", err)
+		}
+		pageBytes[name] = bytes
+	}
+	return pageBytes, rows.Err()
+}
+
+// estimateTableBytes sums the stored length of every value in a table
+func estimateTableBytes(db *sql.DB, table string) (int64, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+	var lengths []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan column: %w", err)
+		}
+		lengths = append(lengths, "COALESCE(length(CAST("+quoteIdentifier(column)+" AS BLOB)), 0)")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+	if len(lengths) == 0 {
+		return 0, nil
+	}
+
+	var bytes int64
+	query := `SELECT COALESCE(SUM(` + strings.Join(lengths, " + ") + `), 0) FROM ` + quoteIdentifier(table)
+	if err := db.QueryRow(query).Scan(&bytes); err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", table, err)
+	}
+	return bytes, nil
+}
+
+// quoteIdentifier quotes a table or column name read from the schema, which
+// unlike user input is not restricted to plain identifiers
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
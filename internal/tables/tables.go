@@ -0,0 +1,68 @@
+// Package tables manages the schema of user-defined tables in a cenv database.
+package tables
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// identifierRegex restricts table, column and index names to plain identifiers
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// IsValidIdentifier checks if a name can be used unquoted as a table, column or index name
+func IsValidIdentifier(name string) bool {
+	return identifierRegex.MatchString(name)
+}
+
+// IsReservedName checks if a name belongs to WCE or SQLite internals
+func IsReservedName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(lower, "_wce_") || strings.HasPrefix(lower, "sqlite_")
+}
+
+// validateUserTable checks that a table name is valid, not reserved, and exists
+func validateUserTable(db *sql.DB, table string) error {
+	if !IsValidIdentifier(table) {
+		return fmt.Errorf("invalid table name: %s", table)
+	}
+	if IsReservedName(table) {
+		return fmt.Errorf("table name is reserved: %s", table)
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE`, table).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check table: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("table not found: %s", table)
+	}
+
+	return nil
+}
+
+// tableColumns returns the column names of a table, keyed by lowercase name
+func tableColumns(db *sql.DB, table string) (map[string]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns[strings.ToLower(name)] = name
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating columns: %w", err)
+	}
+
+	return columns, nil
+}
@@ -0,0 +1,480 @@
+package tables
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/thetanil/wce/internal/sqlite"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, Customer TEXT, ref TEXT UNIQUE);
+		CREATE TABLE _wce_internal (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE _wce_soft_delete_tables (
+			table_name TEXT PRIMARY KEY COLLATE NOCASE, enabled_at INTEGER NOT NULL, enabled_by TEXT
+		);
+		CREATE TABLE _wce_row_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, table_name TEXT NOT NULL, row_id TEXT NOT NULL,
+			operation TEXT NOT NULL, before_image TEXT, after_image TEXT, changed_at INTEGER NOT NULL
+		);
+		INSERT INTO orders (status, Customer, ref) VALUES ('open', 'a', 'r1'), ('open', 'b', 'r2');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	return db
+}
+
+func TestCreateAndDropIndex(t *testing.T) {
+	db := setupTestDB(t)
+
+	index, err := CreateIndex(db, "orders", "", []string{"status", "customer"}, false)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if index.Name != "idx_orders_status_customer" {
+		t.Errorf("Expected default name, got %s", index.Name)
+	}
+	if index.Columns[1] != "Customer" {
+		t.Errorf("Expected declared column name, got %s", index.Columns[1])
+	}
+
+	indexes, err := ListIndexes(db, "orders")
+	if err != nil {
+		t.Fatalf("ListIndexes failed: %v", err)
+	}
+	found := false
+	for _, idx := range indexes {
+		if idx.Name == index.Name {
+			found = true
+			if len(idx.Columns) != 2 || idx.Unique || idx.Origin != "c" {
+				t.Errorf("Unexpected index: %+v", idx)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Created index missing from %+v", indexes)
+	}
+
+	if _, err := CreateIndex(db, "orders", index.Name, []string{"status"}, false); err == nil {
+		t.Error("Expected error for duplicate index name")
+	}
+
+	if err := DropIndex(db, "orders", index.Name); err != nil {
+		t.Fatalf("DropIndex failed: %v", err)
+	}
+	if err := DropIndex(db, "orders", index.Name); err == nil {
+		t.Error("Expected error dropping missing index")
+	}
+}
+
+func TestCreateIndex_Unique(t *testing.T) {
+	db := setupTestDB(t)
+
+	if _, err := CreateIndex(db, "orders", "", []string{"status"}, true); err == nil {
+		t.Error("Expected error when existing rows are not unique")
+	}
+
+	index, err := CreateIndex(db, "orders", "orders_customer", []string{"Customer"}, true)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if !index.Unique {
+		t.Error("Expected unique index")
+	}
+
+	if _, err := db.Exec(`INSERT INTO orders (status, Customer) VALUES ('open', 'a')`); err == nil {
+		t.Error("Expected unique index to reject duplicate")
+	}
+}
+
+func TestIndexValidation(t *testing.T) {
+	db := setupTestDB(t)
+
+	tests := []struct {
+		name    string
+		table   string
+		index   string
+		columns []string
+	}{
+		{"SystemTable", "_wce_internal", "", []string{"name"}},
+		{"MissingTable", "missing", "", []string{"a"}},
+		{"InvalidTable", "orders; DROP TABLE orders", "", []string{"status"}},
+		{"MissingColumn", "orders", "", []string{"nope"}},
+		{"DuplicateColumn", "orders", "", []string{"status", "STATUS"}},
+		{"NoColumns", "orders", "", nil},
+		{"InvalidName", "orders", "bad name", []string{"status"}},
+		{"ReservedName", "orders", "sqlite_mine", []string{"status"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CreateIndex(db, tt.table, tt.index, tt.columns, false); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+
+	// Indexes backing constraints are reserved
+	indexes, _ := ListIndexes(db, "orders")
+	for _, idx := range indexes {
+		if idx.Origin == "u" {
+			if err := DropIndex(db, "orders", idx.Name); err == nil {
+				t.Errorf("Expected error dropping constraint index %s", idx.Name)
+			}
+		}
+	}
+}
+
+func TestCreateTable_Relationships(t *testing.T) {
+	db := setupTestDB(t)
+	db.Exec(`PRAGMA foreign_keys = ON`)
+
+	err := CreateTable(db, &TableDefinition{
+		Name: "order_items",
+		Columns: []Column{
+			{Name: "id", Type: "integer", PrimaryKey: true},
+			{Name: "order_id", Type: "INTEGER", NotNull: true},
+			{Name: "order_ref", Type: "TEXT"},
+			{Name: "parent_id", Type: "INTEGER"},
+			{Name: "quantity", Type: "INTEGER", NotNull: true, Default: strPtr("1")},
+		},
+		Relationships: []Relationship{
+			{Column: "order_id", References: "orders", OnDelete: "cascade"},
+			{Column: "order_ref", References: "orders", ReferencesColumn: "ref", OnDelete: ActionSetNull},
+			{Column: "parent_id", References: "order_items"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+
+	schema, err := DescribeTable(db, "order_items")
+	if err != nil {
+		t.Fatalf("DescribeTable failed: %v", err)
+	}
+
+	if len(schema.Columns) != 5 || !schema.Columns[0].PrimaryKey || !schema.Columns[1].NotNull {
+		t.Errorf("Unexpected columns: %+v", schema.Columns)
+	}
+	if schema.Columns[4].Default == nil || *schema.Columns[4].Default != "1" {
+		t.Errorf("Expected default '1', got %v", schema.Columns[4].Default)
+	}
+
+	expected := map[string]Relationship{
+		"order_id":  {Table: "order_items", Column: "order_id", References: "orders", ReferencesColumn: "id", OnDelete: ActionCascade, OnUpdate: ActionNoAction},
+		"order_ref": {Table: "order_items", Column: "order_ref", References: "orders", ReferencesColumn: "ref", OnDelete: ActionSetNull, OnUpdate: ActionNoAction},
+		"parent_id": {Table: "order_items", Column: "parent_id", References: "order_items", ReferencesColumn: "id", OnDelete: ActionNoAction, OnUpdate: ActionNoAction},
+	}
+	if len(schema.Relationships) != len(expected) {
+		t.Fatalf("Expected %d relationships, got %+v", len(expected), schema.Relationships)
+	}
+	for _, rel := range schema.Relationships {
+		if rel != expected[rel.Column] {
+			t.Errorf("Expected %+v, got %+v", expected[rel.Column], rel)
+		}
+	}
+
+	orders, err := DescribeTable(db, "ORDERS")
+	if err != nil {
+		t.Fatalf("DescribeTable failed: %v", err)
+	}
+	if orders.Name != "orders" || len(orders.ReferencedBy) != 2 {
+		t.Errorf("Expected 2 incoming relationships on orders, got %+v", orders.ReferencedBy)
+	}
+
+	// Cascades are enforced by SQLite
+	db.Exec(`INSERT INTO order_items (order_id, order_ref) VALUES (1, 'r1')`)
+	if _, err := db.Exec(`DELETE FROM orders WHERE id = 1`); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM order_items`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected cascade delete, %d rows remain", count)
+	}
+}
+
+func TestCreateTable_Validation(t *testing.T) {
+	db := setupTestDB(t)
+
+	id := Column{Name: "id", Type: "INTEGER", PrimaryKey: true}
+	tests := []struct {
+		name string
+		def  TableDefinition
+	}{
+		{"ReservedName", TableDefinition{Name: "_wce_things", Columns: []Column{id}}},
+		{"InvalidName", TableDefinition{Name: "bad-name", Columns: []Column{id}}},
+		{"Exists", TableDefinition{Name: "Orders", Columns: []Column{id}}},
+		{"NoColumns", TableDefinition{Name: "things"}},
+		{"BadType", TableDefinition{Name: "things", Columns: []Column{{Name: "x", Type: "VARCHAR(10); DROP"}}}},
+		{"DuplicateColumn", TableDefinition{Name: "things", Columns: []Column{id, {Name: "ID", Type: "TEXT"}}}},
+		{"MissingFKColumn", TableDefinition{Name: "things", Columns: []Column{id},
+			Relationships: []Relationship{{Column: "order_id", References: "orders"}}}},
+		{"SystemTarget", TableDefinition{Name: "things", Columns: []Column{id, {Name: "owner", Type: "TEXT"}},
+			Relationships: []Relationship{{Column: "owner", References: "_wce_internal"}}}},
+		{"MissingTarget", TableDefinition{Name: "things", Columns: []Column{id, {Name: "x", Type: "INTEGER"}},
+			Relationships: []Relationship{{Column: "x", References: "missing"}}}},
+		{"NotKeyedTarget", TableDefinition{Name: "things", Columns: []Column{id, {Name: "s", Type: "TEXT"}},
+			Relationships: []Relationship{{Column: "s", References: "orders", ReferencesColumn: "status"}}}},
+		{"BadAction", TableDefinition{Name: "things", Columns: []Column{id, {Name: "x", Type: "INTEGER"}},
+			Relationships: []Relationship{{Column: "x", References: "orders", OnDelete: "EXPLODE"}}}},
+		{"SetNullOnNotNull", TableDefinition{Name: "things", Columns: []Column{id, {Name: "x", Type: "INTEGER", NotNull: true}},
+			Relationships: []Relationship{{Column: "x", References: "orders", OnDelete: ActionSetNull}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CreateTable(db, &tt.def); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestRowHistory(t *testing.T) {
+	db := setupTestDB(t)
+
+	err := CreateTable(db, &TableDefinition{
+		Name: "notes",
+		Columns: []Column{
+			{Name: "id", Type: "INTEGER", PrimaryKey: true},
+			{Name: "body", Type: "TEXT"},
+			{Name: "data", Type: "BLOB"},
+		},
+		History: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+
+	schema, err := DescribeTable(db, "notes")
+	if err != nil {
+		t.Fatalf("DescribeTable failed: %v", err)
+	}
+	if !schema.History {
+		t.Error("Expected history enabled")
+	}
+
+	db.Exec(`INSERT INTO notes (id, body, data) VALUES (1, 'first', x'00ff')`)
+	db.Exec(`UPDATE notes SET body = 'second', data = x'01' WHERE id = 1`)
+
+	entries, err := RowHistory(db, "notes", "1", 10)
+	if err != nil {
+		t.Fatalf("RowHistory failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Operation != OperationUpdate || entries[1].Operation != OperationInsert {
+		t.Errorf("Expected newest first, got %s then %s", entries[0].Operation, entries[1].Operation)
+	}
+	if string(entries[1].Before) != "null" {
+		t.Errorf("Expected null before image for insert, got %s", entries[1].Before)
+	}
+
+	var before map[string]interface{}
+	json.Unmarshal(entries[0].Before, &before)
+	if before["body"] != "first" || before["data"] != "00FF" {
+		t.Errorf("Unexpected before image: %v", before)
+	}
+
+	t.Run("RestoreUpdate", func(t *testing.T) {
+		if err := RestoreRow(db, "notes", "1", entries[0].ID); err != nil {
+			t.Fatalf("RestoreRow failed: %v", err)
+		}
+
+		var body string
+		var data []byte
+		db.QueryRow(`SELECT body, data FROM notes WHERE id = 1`).Scan(&body, &data)
+		if body != "first" || !bytes.Equal(data, []byte{0x00, 0xff}) {
+			t.Errorf("Expected original row, got %q %x", body, data)
+		}
+	})
+
+	t.Run("RestoreDelete", func(t *testing.T) {
+		db.Exec(`DELETE FROM notes WHERE id = 1`)
+		latest, _ := RowHistory(db, "notes", "1", 1)
+		if len(latest) != 1 || latest[0].Operation != OperationDelete || string(latest[0].After) != "null" {
+			t.Fatalf("Expected delete entry, got %+v", latest)
+		}
+
+		if err := RestoreRow(db, "notes", "1", latest[0].ID); err != nil {
+			t.Fatalf("RestoreRow failed: %v", err)
+		}
+
+		var body string
+		db.QueryRow(`SELECT body FROM notes WHERE id = 1`).Scan(&body)
+		if body != "first" {
+			t.Errorf("Expected deleted row restored, got %q", body)
+		}
+	})
+
+	t.Run("RestoreInsert", func(t *testing.T) {
+		if err := RestoreRow(db, "notes", "1", entries[1].ID); err != nil {
+			t.Fatalf("RestoreRow failed: %v", err)
+		}
+
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&count)
+		if count != 0 {
+			t.Errorf("Expected inserted row removed, %d rows remain", count)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if err := RestoreRow(db, "notes", "2", entries[0].ID); err == nil {
+			t.Error("Expected error for entry of another row")
+		}
+		if err := RestoreRow(db, "orders", "1", entries[0].ID); err == nil {
+			t.Error("Expected error for entry of another table")
+		}
+		if err := EnableHistory(db, "_wce_internal"); err == nil {
+			t.Error("Expected error for reserved table")
+		}
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		if err := DisableHistory(db, "notes"); err != nil {
+			t.Fatalf("DisableHistory failed: %v", err)
+		}
+		if enabled, _ := HistoryEnabled(db, "notes"); enabled {
+			t.Error("Expected history disabled")
+		}
+
+		before, _ := RowHistory(db, "notes", "5", 10)
+		db.Exec(`INSERT INTO notes (id, body) VALUES (5, 'untracked')`)
+		after, _ := RowHistory(db, "notes", "5", 10)
+		if len(after) != len(before) {
+			t.Error("Expected no history recorded after disabling")
+		}
+	})
+
+	t.Run("RowidTable", func(t *testing.T) {
+		if err := EnableHistory(db, "orders"); err != nil {
+			t.Fatalf("EnableHistory failed: %v", err)
+		}
+		db.Exec(`UPDATE orders SET status = 'closed' WHERE ref = 'r2'`)
+
+		entries, err := RowHistory(db, "orders", "2", 10)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("Expected 1 entry, got %d (%v)", len(entries), err)
+		}
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := EnableHistory(db, "orders"); err != nil {
+		t.Fatalf("EnableHistory failed: %v", err)
+	}
+	if err := EnableSoftDelete(db, "orders", ""); err != nil {
+		t.Fatalf("EnableSoftDelete failed: %v", err)
+	}
+	// Enabling twice is a no-op
+	if err := EnableSoftDelete(db, "Orders", ""); err != nil {
+		t.Fatalf("EnableSoftDelete repeat failed: %v", err)
+	}
+	if names, err := SoftDeleteTables(db); err != nil || len(names) != 1 || names[0] != "orders" {
+		t.Errorf("Expected soft delete on orders only, got %v: %v", names, err)
+	}
+
+	schema, err := DescribeTable(db, "orders")
+	if err != nil {
+		t.Fatalf("DescribeTable failed: %v", err)
+	}
+	if !schema.SoftDelete || schema.Columns[len(schema.Columns)-1].Name != SoftDeleteColumn {
+		t.Errorf("Expected soft delete with %s column, got %+v", SoftDeleteColumn, schema)
+	}
+
+	// History triggers are refreshed to capture the new column
+	db.Exec(`UPDATE orders SET deleted_at = 100 WHERE id = 1`)
+	entries, _ := RowHistory(db, "orders", "1", 1)
+	var after map[string]interface{}
+	if len(entries) == 1 {
+		json.Unmarshal(entries[0].After, &after)
+	}
+	if after[SoftDeleteColumn] != float64(100) {
+		t.Errorf("Expected %s in history image, got %v", SoftDeleteColumn, after)
+	}
+
+	db.Exec(`UPDATE orders SET deleted_at = 200 WHERE id = 2`)
+
+	purged, err := PurgeDeleted(db, "orders", 150)
+	if err != nil {
+		t.Fatalf("PurgeDeleted failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 row purged before cutoff, got %d", purged)
+	}
+
+	purged, err = PurgeDeleted(db, "orders", 0)
+	if err != nil || purged != 1 {
+		t.Errorf("Expected remaining row purged, got %d (%v)", purged, err)
+	}
+
+	if err := DisableSoftDelete(db, "orders"); err != nil {
+		t.Fatalf("DisableSoftDelete failed: %v", err)
+	}
+	if enabled, _ := SoftDeleteEnabled(db, "orders"); enabled {
+		t.Error("Expected soft delete disabled")
+	}
+	if _, err := PurgeDeleted(db, "orders", 0); err == nil {
+		t.Error("Expected purge to require soft delete")
+	}
+	if err := EnableSoftDelete(db, "_wce_internal", ""); err == nil {
+		t.Error("Expected error for reserved table")
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestStorageReport(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Larger than a few pages, so it is the largest table whether sizes are
+	// measured with dbstat or estimated
+	if _, err := db.Exec(`INSERT INTO _wce_internal (name) VALUES (?)`, strings.Repeat("x", 20000)); err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+
+	storage, err := StorageReport(db)
+	if err != nil {
+		t.Fatalf("StorageReport failed: %v", err)
+	}
+	if storage.PageSize <= 0 || storage.TotalBytes != storage.PageSize*storage.PageCount {
+		t.Errorf("Expected page totals, got %+v", storage)
+	}
+	if storage.FreeBytes != storage.PageSize*storage.FreePages {
+		t.Errorf("Expected free bytes from free pages, got %+v", storage)
+	}
+
+	sizes := map[string]TableSize{}
+	for _, table := range storage.Tables {
+		sizes[table.Name] = table
+	}
+	if len(sizes) != 4 {
+		t.Errorf("Expected 4 tables including internal ones, got %+v", storage.Tables)
+	}
+	if sizes["orders"].Rows != 2 || sizes["_wce_internal"].Rows != 1 {
+		t.Errorf("Expected row counts, got %+v", storage.Tables)
+	}
+	if storage.Tables[0].Name != "_wce_internal" || storage.Tables[0].Bytes < 20000 {
+		t.Errorf("Expected _wce_internal largest, got %+v", storage.Tables)
+	}
+}
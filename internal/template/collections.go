@@ -0,0 +1,66 @@
+package template
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// collectionsValue is the collections variable of template documents.
+// Looking a name up in it runs that saved search and yields the first page
+// of results, as in {% for post in collections.recent_posts %}. Results are
+// kept for the rest of the render, so a collection used twice is queried
+// once. Unknown collections are undefined, like any missing variable.
+type collectionsValue struct {
+	db      *sql.DB
+	results map[string]starlark.Value
+}
+
+var _ starlark.Mapping = (*collectionsValue)(nil)
+
+func newCollectionsValue(db *sql.DB) *collectionsValue {
+	return &collectionsValue{db: db, results: map[string]starlark.Value{}}
+}
+
+func (c *collectionsValue) String() string       { return "<collections>" }
+func (c *collectionsValue) Type() string         { return "collections" }
+func (c *collectionsValue) Freeze()              {}
+func (c *collectionsValue) Truth() starlark.Bool { return starlark.True }
+func (c *collectionsValue) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: collections")
+}
+
+// Get runs the named collection
+func (c *collectionsValue) Get(key starlark.Value) (starlark.Value, bool, error) {
+	name, ok := starlark.AsString(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if value, ok := c.results[name]; ok {
+		return value, true, nil
+	}
+
+	_, results, _, err := document.RunCollection(c.db, name, "", 0)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "collection not found") {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	items := make([]interface{}, len(results))
+	for i, result := range results {
+		items[i] = result.Map()
+	}
+	value, err := starconv.ToStarlark(items)
+	if err != nil {
+		return nil, false, err
+	}
+	c.results[name] = value
+	return value, true, nil
+}
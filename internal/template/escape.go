@@ -0,0 +1,75 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"strings"
+)
+
+// Autoescape modes applied to {{ }} output
+const (
+	EscapeHTML = "html" // HTML entities, also right for XML
+	EscapeJS   = "js"   // Escapes for a JavaScript or JSON string literal
+	EscapeNone = "none" // Output verbatim, for plain text
+)
+
+// IsValidEscapeMode reports whether mode is one of the Escape* modes
+func IsValidEscapeMode(mode string) bool {
+	return mode == EscapeHTML || mode == EscapeJS || mode == EscapeNone
+}
+
+// EscapeModeFor picks the autoescape mode for output of a content type.
+// HTML and XML escape as HTML, JSON and JavaScript as JS string content and
+// other text types not at all; unknown types default to HTML.
+func EscapeModeFor(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return EscapeHTML
+	}
+
+	switch {
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/javascript", mediaType == "text/javascript":
+		return EscapeJS
+	case mediaType == "text/html", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/xml":
+		return EscapeHTML
+	case strings.HasPrefix(mediaType, "text/"):
+		return EscapeNone
+	default:
+		return EscapeHTML
+	}
+}
+
+// parseEscapeMode reads the argument of {% autoescape %}: a mode name,
+// quoted or not, or Jinja's true and false
+func parseEscapeMode(arg string) (string, error) {
+	mode := strings.ToLower(extractQuoted(arg))
+	switch mode {
+	case "", "true":
+		return EscapeHTML, nil
+	case "false":
+		return EscapeNone, nil
+	}
+	if !IsValidEscapeMode(mode) {
+		return "", fmt.Errorf("invalid autoescape mode: %s", arg)
+	}
+	return mode, nil
+}
+
+// escapeOutput escapes a rendered value for the given mode
+func escapeOutput(mode, s string) string {
+	switch mode {
+	case EscapeNone:
+		return s
+	case EscapeJS:
+		// JSON string escaping is valid in JavaScript too; quotes of either
+		// kind and <, > and & come out as \u escapes
+		encoded, _ := json.Marshal(s)
+		return strings.ReplaceAll(string(encoded[1:len(encoded)-1]), "'", `\u0027`)
+	default:
+		return html.EscapeString(s)
+	}
+}
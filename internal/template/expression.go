@@ -0,0 +1,342 @@
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// evalSimpleExpression evaluates a literal or a variable reference with
+// attribute and index access: 5, 1.5, 'text', [1, 2], {'a': 1}, true, none,
+// user.name, items[0], prices['apple'] or rows[0].cells[-1]. Names, keys and
+// indexes that do not exist evaluate to the empty string, as does anything
+// this evaluator does not understand.
+func evalSimpleExpression(thread *starlark.Thread, expr string, context *starlark.Dict) (starlark.Value, error) {
+	p := &exprParser{src: expr, context: context}
+	value, err := p.parseValue()
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.src) {
+			err = p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+	if err != nil || value == nil {
+		return starlark.String(""), nil
+	}
+	return value, nil
+}
+
+// exprParser reads one value from a template expression. A nil value stands
+// for an undefined variable, key or index.
+type exprParser struct {
+	src     string
+	pos     int
+	context *starlark.Dict
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("expression %s: %s", p.src, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n' || p.src[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// consume skips spaces and then c, reporting whether c was there
+func (p *exprParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseValue reads a literal or name followed by any attribute and index
+// accesses
+func (p *exprParser) parseValue() (starlark.Value, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+
+	var value starlark.Value
+	var err error
+	switch c := p.src[p.pos]; {
+	case c == '"' || c == '\'':
+		value, err = p.parseString()
+	case c == '[':
+		value, err = p.parseList()
+	case c == '{':
+		value, err = p.parseDict()
+	case c == '-' || isDigit(c):
+		value, err = p.parseNumber()
+	case isIdentStart(c):
+		value = p.parseName()
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseAccess(value)
+}
+
+// parseAccess applies the .attr and [index] accesses following a value
+func (p *exprParser) parseAccess(value starlark.Value) (starlark.Value, error) {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '.':
+			p.pos++
+			start := p.pos
+			for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected an attribute name")
+			}
+			name := p.src[start:p.pos]
+			if i, err := strconv.Atoi(name); err == nil {
+				value = index(value, starlark.MakeInt(i))
+			} else {
+				value = attr(value, name)
+			}
+
+		case '[':
+			p.pos++
+			key, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(']') {
+				return nil, p.errorf("expected ']'")
+			}
+			value = index(value, key)
+
+		default:
+			return value, nil
+		}
+	}
+	return value, nil
+}
+
+// attr looks name up as a key of a dict or other mapping, falling back to
+// the attributes of values such as structs. It returns nil when neither
+// exists.
+func attr(value starlark.Value, name string) starlark.Value {
+	if v := index(value, starlark.String(name)); v != nil {
+		return v
+	}
+	if object, ok := value.(starlark.HasAttrs); ok {
+		// Methods cannot be called from templates, so only data attributes
+		// are looked up
+		if v, err := object.Attr(name); err == nil && v != nil {
+			if _, method := v.(*starlark.Builtin); !method {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// index looks key up in a dict or other mapping, or an integer position in a list, tuple or
+// string (negative positions count from the end). It returns nil when the
+// key or position does not exist.
+func index(value, key starlark.Value) starlark.Value {
+	if value == nil || key == nil {
+		return nil
+	}
+
+	if dict, ok := value.(*starlark.Dict); ok {
+		if v, found, err := dict.Get(key); err == nil && found {
+			return v
+		}
+		return nil
+	}
+
+	// Other mappings, such as collections, look keys up themselves
+	if mapping, ok := value.(starlark.Mapping); ok {
+		if v, found, err := mapping.Get(key); err == nil && found {
+			return v
+		}
+		return nil
+	}
+
+	if seq, ok := value.(starlark.Indexable); ok {
+		pos, ok := key.(starlark.Int)
+		if !ok {
+			return nil
+		}
+		i, ok := pos.Int64()
+		if !ok {
+			return nil
+		}
+		if i < 0 {
+			i += int64(seq.Len())
+		}
+		if i < 0 || i >= int64(seq.Len()) {
+			return nil
+		}
+		return seq.Index(int(i))
+	}
+
+	return nil
+}
+
+func (p *exprParser) parseString() (starlark.Value, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return starlark.String(sb.String()), nil
+		case c == '\\' && p.pos < len(p.src):
+			sb.WriteByte(p.src[p.pos])
+			p.pos++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+func (p *exprParser) parseNumber() (starlark.Value, error) {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+		p.pos++
+	}
+	isFloat := false
+	if p.pos+1 < len(p.src) && p.src[p.pos] == '.' && isDigit(p.src[p.pos+1]) {
+		isFloat = true
+		p.pos++
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		isFloat = true
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+
+	text := p.src[start:p.pos]
+	if isFloat {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", text)
+		}
+		return starlark.Float(f), nil
+	}
+	i, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", text)
+	}
+	return starlark.MakeInt64(i), nil
+}
+
+func (p *exprParser) parseList() (starlark.Value, error) {
+	p.pos++ // [
+	var items []starlark.Value
+	for !p.consume(']') {
+		if len(items) > 0 && !p.consume(',') {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+		if p.consume(']') {
+			break // Trailing comma
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, undefinedToEmpty(item))
+	}
+	return starlark.NewList(items), nil
+}
+
+func (p *exprParser) parseDict() (starlark.Value, error) {
+	p.pos++ // {
+	dict := starlark.NewDict(0)
+	for !p.consume('}') {
+		if dict.Len() > 0 && !p.consume(',') {
+			return nil, p.errorf("expected ',' or '}'")
+		}
+		if p.consume('}') {
+			break // Trailing comma
+		}
+		key, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(':') {
+			return nil, p.errorf("expected ':'")
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := dict.SetKey(undefinedToEmpty(key), undefinedToEmpty(value)); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	}
+	return dict, nil
+}
+
+// parseName reads a boolean or none literal, or looks a variable up
+func (p *exprParser) parseName() starlark.Value {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+
+	name := p.src[start:p.pos]
+	switch name {
+	case "true", "True":
+		return starlark.True
+	case "false", "False":
+		return starlark.False
+	case "none", "None":
+		return starlark.None
+	}
+
+	value, found, err := p.context.Get(starlark.String(name))
+	if err != nil || !found {
+		return nil
+	}
+	return value
+}
+
+// undefinedToEmpty stores undefined values inside literals as empty strings
+func undefinedToEmpty(value starlark.Value) starlark.Value {
+	if value == nil {
+		return starlark.String("")
+	}
+	return value
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
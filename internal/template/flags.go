@@ -0,0 +1,59 @@
+package template
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.starlark.net/starlark"
+
+	"github.com/thetanil/wce/internal/flags"
+)
+
+// flagsValue is the flags variable of template documents. Looking a name
+// up in it yields whether that feature flag is on for the rendering user,
+// as in {% if flags.new_nav %}; unknown flags are off. Answers are kept
+// for the rest of the render.
+type flagsValue struct {
+	db      *sql.DB
+	userID  string
+	enabled map[string]bool
+}
+
+var _ starlark.Mapping = (*flagsValue)(nil)
+
+func newFlagsValue(db *sql.DB, userID string) *flagsValue {
+	return &flagsValue{db: db, userID: userID, enabled: map[string]bool{}}
+}
+
+func (f *flagsValue) String() string       { return "<flags>" }
+func (f *flagsValue) Type() string         { return "flags" }
+func (f *flagsValue) Freeze()              {}
+func (f *flagsValue) Truth() starlark.Bool { return starlark.True }
+func (f *flagsValue) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: flags")
+}
+
+// Get evaluates the named flag
+func (f *flagsValue) Get(key starlark.Value) (starlark.Value, bool, error) {
+	name, ok := starlark.AsString(key)
+	if !ok {
+		return nil, false, nil
+	}
+	enabled, ok := f.enabled[name]
+	if !ok {
+		var err error
+		if enabled, err = flags.Enabled(f.db, name, f.userID); err != nil {
+			return nil, false, err
+		}
+		f.enabled[name] = enabled
+	}
+	return starlark.Bool(enabled), true, nil
+}
+
+// variablesUserID returns the user id of the identity variable templates
+// are given, or "" for anonymous renders
+func variablesUserID(variables map[string]interface{}) string {
+	identity, _ := variables["identity"].(map[string]interface{})
+	userID, _ := identity["id"].(string)
+	return userID
+}
@@ -6,7 +6,7 @@ import (
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 // TestJinjaIntegratedQuery demonstrates a real-world scenario:
@@ -16,7 +16,7 @@ import (
 // 4. All templates support inheritance (base layout)
 func TestJinjaIntegratedQuery(t *testing.T) {
 	// Setup: Create in-memory database
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open database: %v", err)
 	}
@@ -31,7 +31,9 @@ func TestJinjaIntegratedQuery(t *testing.T) {
 			created_at INTEGER NOT NULL,
 			modified_at INTEGER NOT NULL,
 			created_by TEXT NOT NULL,
-			modified_by TEXT NOT NULL
+			modified_by TEXT NOT NULL,
+			metadata TEXT NOT NULL DEFAULT '{}',
+			compression TEXT
 		)
 	`)
 	if err != nil {
@@ -19,6 +19,7 @@ const (
 	NodeInclude
 	NodeBlock
 	NodeExtends
+	NodeAutoescape
 )
 
 type Node struct {
@@ -85,7 +86,7 @@ func parseNodes(source string, depth int) ([]Node, error) {
 		switch tagType {
 		case "comment":
 			// Skip comment
-			endIdx := strings.Index(remaining, "#}")
+			endIdx := closingIndex(remaining, "#}")
 			if endIdx < 0 {
 				return nil, fmt.Errorf("unclosed comment")
 			}
@@ -93,7 +94,7 @@ func parseNodes(source string, depth int) ([]Node, error) {
 
 		case "var":
 			// Variable tag {{ expr }}
-			endIdx := strings.Index(remaining, "}}")
+			endIdx := closingIndex(remaining, "}}")
 			if endIdx < 0 {
 				return nil, fmt.Errorf("unclosed variable tag")
 			}
@@ -106,7 +107,7 @@ func parseNodes(source string, depth int) ([]Node, error) {
 
 		case "stmt":
 			// Statement tag {% ... %}
-			endIdx := strings.Index(remaining, "%}")
+			endIdx := closingIndex(remaining, "%}")
 			if endIdx < 0 {
 				return nil, fmt.Errorf("unclosed statement tag")
 			}
@@ -128,6 +129,16 @@ func parseNodes(source string, depth int) ([]Node, error) {
 	return nodes, nil
 }
 
+// closingIndex finds the delimiter closing the tag that tag starts with. The
+// search begins after the opening delimiter, so "{%}" is not a complete tag.
+func closingIndex(tag, closing string) int {
+	idx := strings.Index(tag[2:], closing)
+	if idx < 0 {
+		return -1
+	}
+	return idx + 2
+}
+
 func parseStatement(stmt, remaining string, depth int) (*Node, string, error) {
 	parts := strings.Fields(stmt)
 	if len(parts) == 0 {
@@ -151,7 +162,7 @@ func parseStatement(stmt, remaining string, depth int) (*Node, string, error) {
 
 	case "include":
 		// {% include "template" %}
-		templateName := extractQuoted(stmt[8:])
+		templateName := extractQuoted(strings.TrimPrefix(stmt, "include"))
 		return &Node{
 			Type:    NodeInclude,
 			Content: templateName,
@@ -159,7 +170,7 @@ func parseStatement(stmt, remaining string, depth int) (*Node, string, error) {
 
 	case "extends":
 		// {% extends "parent" %}
-		parentName := extractQuoted(stmt[8:])
+		parentName := extractQuoted(strings.TrimPrefix(stmt, "extends"))
 		return &Node{
 			Type:    NodeExtends,
 			Content: parentName,
@@ -167,10 +178,21 @@ func parseStatement(stmt, remaining string, depth int) (*Node, string, error) {
 
 	case "block":
 		// {% block name %}
-		blockName := strings.TrimSpace(stmt[6:])
+		blockName := strings.TrimSpace(strings.TrimPrefix(stmt, "block"))
+		if blockName == "" {
+			return nil, "", fmt.Errorf("block requires a name")
+		}
 		return parseBlock(blockName, remaining, depth)
 
-	case "endfor", "endif", "endblock":
+	case "autoescape":
+		// {% autoescape "js" %}, {% autoescape false %}
+		mode, err := parseEscapeMode(strings.TrimPrefix(stmt, "autoescape"))
+		if err != nil {
+			return nil, "", err
+		}
+		return parseAutoescape(mode, remaining, depth)
+
+	case "endfor", "endif", "endblock", "endautoescape":
 		// These are handled by their opening tags
 		return nil, remaining, nil
 
@@ -324,6 +346,25 @@ func parseBlock(blockName, remaining string, depth int) (*Node, string, error) {
 	return node, newRemaining, nil
 }
 
+func parseAutoescape(mode, remaining string, depth int) (*Node, string, error) {
+	// Find matching endautoescape
+	body, newRemaining, err := findBlockEnd(remaining, "{% autoescape", "{% endautoescape %}")
+	if err != nil {
+		return nil, "", err
+	}
+
+	bodyNodes, err := parseNodes(body, depth+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &Node{
+		Type:    NodeAutoescape,
+		Content: mode,
+		Body:    bodyNodes,
+	}, newRemaining, nil
+}
+
 func findBlockEnd(source, openTag, closeTag string) (string, string, error) {
 	depth := 1
 	pos := 0
@@ -3,24 +3,149 @@ package template
 import (
 	"context"
 	"fmt"
-	"html"
 	"strings"
 
 	"go.starlark.net/starlark"
+
+	"github.com/thetanil/wce/internal/starconv"
+)
+
+// RenderLimits bounds the work a single render may do, so a template
+// looping over injected data cannot exhaust CPU or memory. Zero disables a limit.
+type RenderLimits struct {
+	MaxOutputBytes    int // Total size of the rendered output
+	MaxLoopIterations int // Iterations across every for loop of the render
+}
+
+// DefaultRenderLimits applies when a RenderContext sets no limits
+var DefaultRenderLimits = RenderLimits{
+	MaxOutputBytes:    10 << 20,
+	MaxLoopIterations: 100000,
+}
+
+// Budgets reported by BudgetError
+const (
+	BudgetOutputBytes    = "output_bytes"
+	BudgetLoopIterations = "loop_iterations"
+	BudgetDeadline       = "deadline"
 )
 
-// RenderAST renders a parsed template AST with the given context
+// BudgetError reports a render stopped for exceeding one of its limits
+type BudgetError struct {
+	Budget string `json:"budget"` // One of the Budget* constants
+	Limit  int    `json:"limit"`  // Configured maximum; 0 for the deadline
+	Err    error  `json:"-"`      // Context error when Budget is BudgetDeadline
+}
+
+func (e *BudgetError) Error() string {
+	if e.Budget == BudgetDeadline {
+		return fmt.Sprintf("render budget exceeded: %v", e.Err)
+	}
+	return fmt.Sprintf("render budget exceeded: %s over %d", e.Budget, e.Limit)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return e.Err
+}
+
+// MaxIncludeDepth is how deeply includes may nest before a render fails
+const MaxIncludeDepth = 32
+
+// renderState is shared by every node of one render, including the nodes of
+// included templates, so limits apply to the render as a whole
+type renderState struct {
+	thread     *starlark.Thread
+	loader     TemplateLoader
+	limits     RenderLimits
+	output     int      // Bytes emitted so far
+	iterations int      // Loop iterations run so far
+	chain      []string // Names of the templates being rendered, outermost first
+	escape     string   // Autoescape mode in effect, one of the Escape* modes
+}
+
+// newRenderState prepares a render that escapes output as HTML
+func newRenderState(loader TemplateLoader, limits RenderLimits) *renderState {
+	return &renderState{
+		// Create Starlark thread for expression evaluation
+		thread: &starlark.Thread{Name: "template-render"},
+		loader: loader,
+		limits: limits,
+		escape: EscapeHTML,
+	}
+}
+
+// enter pushes an included template onto the chain, failing on a cycle or
+// when includes nest deeper than MaxIncludeDepth. The caller pops it with leave.
+func (st *renderState) enter(name string) error {
+	for _, entered := range st.chain {
+		if entered == name {
+			return fmt.Errorf("template include cycle: %s", strings.Join(append(st.chain, name), " -> "))
+		}
+	}
+	if len(st.chain) >= MaxIncludeDepth {
+		return fmt.Errorf("template includes nested deeper than %d: %s", MaxIncludeDepth, strings.Join(append(st.chain, name), " -> "))
+	}
+	st.chain = append(st.chain, name)
+	return nil
+}
+
+func (st *renderState) leave() {
+	st.chain = st.chain[:len(st.chain)-1]
+}
+
+// emit accounts for output produced by a leaf node
+func (st *renderState) emit(s string) (string, error) {
+	st.output += len(s)
+	if st.limits.MaxOutputBytes > 0 && st.output > st.limits.MaxOutputBytes {
+		return "", &BudgetError{Budget: BudgetOutputBytes, Limit: st.limits.MaxOutputBytes}
+	}
+	return s, nil
+}
+
+// iterate accounts for one loop iteration
+func (st *renderState) iterate() error {
+	st.iterations++
+	if st.limits.MaxLoopIterations > 0 && st.iterations > st.limits.MaxLoopIterations {
+		return &BudgetError{Budget: BudgetLoopIterations, Limit: st.limits.MaxLoopIterations}
+	}
+	return nil
+}
+
+// checkDeadline reports a cancelled or expired render context
+func checkDeadline(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &BudgetError{Budget: BudgetDeadline, Err: err}
+	}
+	return nil
+}
+
+// RenderAST renders a parsed template AST with the given context under
+// DefaultRenderLimits, escaping output as HTML
 func RenderAST(ctx context.Context, nodes []Node, context map[string]interface{}, loader TemplateLoader) (string, error) {
-	// Create Starlark thread for expression evaluation
-	thread := &starlark.Thread{Name: "template-render"}
+	return renderAST(ctx, nodes, context, newRenderState(loader, DefaultRenderLimits))
+}
 
+// renderAST renders a parsed template AST, stopping with a *BudgetError once
+// the limits of state are exceeded or ctx is done
+func renderAST(ctx context.Context, nodes []Node, context map[string]interface{}, state *renderState) (string, error) {
 	// Convert context to Starlark
-	starlarkCtx := goToStarlark(context)
+	starlarkCtx, err := goToStarlark(context)
+	if err != nil {
+		return "", err
+	}
 
+	return renderNodes(ctx, state, nodes, starlarkCtx, context)
+}
+
+// renderNodes renders a sequence of sibling nodes
+func renderNodes(ctx context.Context, state *renderState, nodes []Node, starlarkCtx *starlark.Dict, context map[string]interface{}) (string, error) {
 	var output strings.Builder
 
 	for _, node := range nodes {
-		rendered, err := renderNode(ctx, thread, node, starlarkCtx, context, loader)
+		if err := checkDeadline(ctx); err != nil {
+			return "", err
+		}
+		rendered, err := renderNode(ctx, state, node, starlarkCtx, context)
 		if err != nil {
 			return "", err
 		}
@@ -30,10 +155,12 @@ func RenderAST(ctx context.Context, nodes []Node, context map[string]interface{}
 	return output.String(), nil
 }
 
-func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlarkCtx *starlark.Dict, context map[string]interface{}, loader TemplateLoader) (string, error) {
+func renderNode(ctx context.Context, state *renderState, node Node, starlarkCtx *starlark.Dict, context map[string]interface{}) (string, error) {
+	thread := state.thread
+
 	switch node.Type {
 	case NodeText:
-		return node.Content, nil
+		return state.emit(node.Content)
 
 	case NodeVariable:
 		// Evaluate expression using Starlark
@@ -41,11 +168,12 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 		if err != nil {
 			return "", fmt.Errorf("error evaluating %s: %w", node.Expr, err)
 		}
-		// Convert to Go value first, then to string
-		goValue := starlarkToGo(value)
-		strValue := fmt.Sprintf("%v", goValue)
-		// Auto-escape HTML
-		return html.EscapeString(strValue), nil
+		strValue := display(value)
+		// Auto-escape unless the value is marked |safe
+		if isSafeExpression(node.Expr) {
+			return state.emit(strValue)
+		}
+		return state.emit(escapeOutput(state.escape, strValue))
 
 	case NodeFor:
 		// Evaluate iterable
@@ -54,23 +182,22 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 			return "", fmt.Errorf("error evaluating iterable %s: %w", node.Iterable, err)
 		}
 
-		// Convert to Go slice
-		itemsList, ok := starlarkToGo(items).([]interface{})
+		// Lists, tuples and sets convert to Go slices
+		itemsList, ok := starconv.ToGoKeeping(items).([]interface{})
 		if !ok {
-			// Try to convert other iterables
-			if list, ok := items.(*starlark.List); ok {
-				itemsList = make([]interface{}, list.Len())
-				for i := 0; i < list.Len(); i++ {
-					itemsList[i] = starlarkToGo(list.Index(i))
-				}
-			} else {
-				return "", fmt.Errorf("for loop iterable is not a list")
-			}
+			return "", fmt.Errorf("for loop iterable is not a list")
 		}
 
 		// Render loop
 		var output strings.Builder
 		for i, item := range itemsList {
+			if err := state.iterate(); err != nil {
+				return "", err
+			}
+			if err := checkDeadline(ctx); err != nil {
+				return "", err
+			}
+
 			// Create loop context
 			loopCtx := make(map[string]interface{})
 			for k, v := range context {
@@ -86,11 +213,14 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 			}
 
 			// Convert to Starlark context
-			loopStarlarkCtx := goToStarlark(loopCtx)
+			loopStarlarkCtx, err := goToStarlark(loopCtx)
+			if err != nil {
+				return "", err
+			}
 
 			// Render body (no recursion - just iterate over body nodes)
 			for _, bodyNode := range node.Body {
-				rendered, err := renderNode(ctx, thread, bodyNode, loopStarlarkCtx, loopCtx, loader)
+				rendered, err := renderNode(ctx, state, bodyNode, loopStarlarkCtx, loopCtx)
 				if err != nil {
 					return "", err
 				}
@@ -111,7 +241,7 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 		if isTruthy(result) {
 			// Render if body
 			for _, bodyNode := range node.Body {
-				rendered, err := renderNode(ctx, thread, bodyNode, starlarkCtx, context, loader)
+				rendered, err := renderNode(ctx, state, bodyNode, starlarkCtx, context)
 				if err != nil {
 					return "", err
 				}
@@ -120,7 +250,7 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 		} else if len(node.ElseBody) > 0 {
 			// Render else body
 			for _, bodyNode := range node.ElseBody {
-				rendered, err := renderNode(ctx, thread, bodyNode, starlarkCtx, context, loader)
+				rendered, err := renderNode(ctx, state, bodyNode, starlarkCtx, context)
 				if err != nil {
 					return "", err
 				}
@@ -137,20 +267,31 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 		}
 
 		// Update both contexts
-		context[node.Variable] = starlarkToGo(value)
+		context[node.Variable] = starconv.ToGoKeeping(value)
 		starlarkCtx.SetKey(starlark.String(node.Variable), value)
 		return "", nil
 
 	case NodeInclude:
 		// Load and render included template
-		if loader == nil {
+		if state.loader == nil {
 			return "", fmt.Errorf("template loader required for include")
 		}
+		if err := state.enter(node.Content); err != nil {
+			return "", err
+		}
+		defer state.leave()
 
-		includedSource, err := loader(node.Content)
+		if err := checkDeadline(ctx); err != nil {
+			return "", err
+		}
+		includedSource, err := state.loader(node.Content)
 		if err != nil {
 			return "", fmt.Errorf("failed to load template %s: %w", node.Content, err)
 		}
+		// The loader may block on the database; stop if that used up the deadline
+		if err := checkDeadline(ctx); err != nil {
+			return "", err
+		}
 
 		// Parse and render included template
 		includedNodes, err := ParseTemplate(includedSource)
@@ -158,13 +299,34 @@ func renderNode(ctx context.Context, thread *starlark.Thread, node Node, starlar
 			return "", fmt.Errorf("failed to parse included template: %w", err)
 		}
 
-		return RenderAST(ctx, includedNodes, context, loader)
+		// The include shares this render's budget
+		includedCtx, err := goToStarlark(context)
+		if err != nil {
+			return "", err
+		}
+		return renderNodes(ctx, state, includedNodes, includedCtx, context)
 
 	case NodeBlock:
 		// Render block body
 		var output strings.Builder
 		for _, bodyNode := range node.Body {
-			rendered, err := renderNode(ctx, thread, bodyNode, starlarkCtx, context, loader)
+			rendered, err := renderNode(ctx, state, bodyNode, starlarkCtx, context)
+			if err != nil {
+				return "", err
+			}
+			output.WriteString(rendered)
+		}
+		return output.String(), nil
+
+	case NodeAutoescape:
+		// Render the body under another escape mode
+		outer := state.escape
+		state.escape = node.Content
+		defer func() { state.escape = outer }()
+
+		var output strings.Builder
+		for _, bodyNode := range node.Body {
+			rendered, err := renderNode(ctx, state, bodyNode, starlarkCtx, context)
 			if err != nil {
 				return "", err
 			}
@@ -202,79 +364,11 @@ func evalExpression(thread *starlark.Thread, expr string, context *starlark.Dict
 	return evalSimpleExpression(thread, expr, context)
 }
 
-func evalSimpleExpression(thread *starlark.Thread, expr string, context *starlark.Dict) (starlark.Value, error) {
-	// String literal
-	if strings.HasPrefix(expr, `"`) || strings.HasPrefix(expr, "'") {
-		return starlark.String(strings.Trim(expr, `"'`)), nil
-	}
-
-	// Number literal
-	if strings.IndexAny(expr, "0123456789") == 0 {
-		// Try parsing as int
-		if val, err := starlark.NumberToInt(starlark.MakeInt(0)); err == nil {
-			_ = val
-			// For simplicity, just use string lookup
-		}
-	}
-
-	// Attribute access: obj.attr (can be nested: obj.attr.subattr)
-	if strings.Contains(expr, ".") {
-		parts := strings.Split(expr, ".")
-
-		// Start with the first part
-		obj, found, err := context.Get(starlark.String(parts[0]))
-		if err != nil || !found || obj == nil {
-			// DEBUG
-			//fmt.Printf("DEBUG: Failed to find '%s' in context\n", parts[0])
-			return starlark.String(""), nil
-		}
-
-		// Navigate through the remaining parts
-		for i := 1; i < len(parts); i++ {
-			if dict, ok := obj.(*starlark.Dict); ok {
-				// Starlark dict.Get() requires exact key match
-				// Try direct lookup first
-				lookupKey := starlark.String(parts[i])
-				val, found, _ := dict.Get(lookupKey)
-
-				// If not found, iterate to find by string value comparison
-				// (dict.Get might not work if keys were created differently)
-				if !found || val == nil {
-					for _, item := range dict.Items() {
-						k := item[0]
-						if kstr, ok := k.(starlark.String); ok {
-							// Compare actual string values
-							if string(kstr) == parts[i] {
-								val = item[1]
-								found = true
-								break
-							}
-						}
-					}
-
-					if !found || val == nil {
-						// Still not found
-						return starlark.String(""), nil
-					}
-				}
-
-				obj = val
-			} else {
-				// Not a dict, can't access attributes
-				return starlark.String(""), nil
-			}
-		}
-
-		return obj, nil
-	}
-
-	// Simple variable lookup
-	val, found, err := context.Get(starlark.String(expr))
-	if err != nil || !found || val == nil {
-		return starlark.String(""), nil
-	}
-
-	return val, nil
+// isSafeExpression reports whether a {{ }} expression ends with the |safe
+// filter, which disables autoescaping of its output
+func isSafeExpression(expr string) bool {
+	i := strings.LastIndex(expr, "|")
+	return i >= 0 && strings.TrimSpace(expr[i+1:]) == "safe"
 }
 
 func applyFilter(filterExpr string, value starlark.Value) (starlark.Value, error) {
@@ -291,9 +385,7 @@ func applyFilter(filterExpr string, value starlark.Value) (starlark.Value, error
 		}
 	}
 
-	// Convert value to Go value first, then to string
-	goValue := starlarkToGo(value)
-	strVal := fmt.Sprintf("%v", goValue)
+	strVal := display(value)
 
 	switch filterName {
 	case "upper":
@@ -329,6 +421,10 @@ func applyFilter(filterExpr string, value starlark.Value) (starlark.Value, error
 		}
 		return starlark.MakeInt(len(strVal)), nil
 
+	case "safe":
+		// Marks output as not needing escaping, see isSafeExpression
+		return value, nil
+
 	case "join":
 		sep := ""
 		if len(args) > 0 {
@@ -337,8 +433,7 @@ func applyFilter(filterExpr string, value starlark.Value) (starlark.Value, error
 		if list, ok := value.(*starlark.List); ok {
 			var parts []string
 			for i := 0; i < list.Len(); i++ {
-				goVal := starlarkToGo(list.Index(i))
-				parts = append(parts, fmt.Sprintf("%v", goVal))
+				parts = append(parts, display(list.Index(i)))
 			}
 			return starlark.String(strings.Join(parts, sep)), nil
 		}
@@ -377,35 +472,14 @@ func isTruthy(value starlark.Value) bool {
 	return true
 }
 
-func starlarkToGo(value starlark.Value) interface{} {
-	if value == nil || value == starlark.None {
-		return nil
-	}
-
+// display formats a value for output. Strings and bytes are written as
+// text; other values as their Go form.
+func display(value starlark.Value) string {
 	switch v := value.(type) {
-	case starlark.Bool:
-		return bool(v)
 	case starlark.String:
 		return string(v)
-	case starlark.Int:
-		i, _ := v.Int64()
-		return int(i)
-	case starlark.Float:
-		return float64(v)
-	case *starlark.List:
-		result := make([]interface{}, v.Len())
-		for i := 0; i < v.Len(); i++ {
-			result[i] = starlarkToGo(v.Index(i))
-		}
-		return result
-	case *starlark.Dict:
-		result := make(map[string]interface{})
-		for _, item := range v.Items() {
-			key := item[0].String()
-			result[key] = starlarkToGo(item[1])
-		}
-		return result
-	default:
-		return v.String()
+	case starlark.Bytes:
+		return string(v)
 	}
+	return fmt.Sprintf("%v", starconv.ToGoKeeping(value))
 }
@@ -4,49 +4,29 @@ package template
 import (
 	"context"
 	"database/sql"
+	_ "embed"
+	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"go.starlark.net/starlark"
+
+	"github.com/thetanil/wce/internal/document"
+	"github.com/thetanil/wce/internal/starconv"
 )
 
+// jinjaStarlarkSource is the Starlark implementation of the template engine,
+// built into the binary so rendering does not depend on the working directory
+//
+//go:embed jinja_iterative.star
 var jinjaStarlarkSource string
 
-func init() {
-	// Load jinja_simple.star from the same package
-	// In production, this should be embedded or bundled
-	dir, err := os.Getwd()
-	if err != nil {
-		jinjaStarlarkSource = fallbackSource()
-		return
-	}
-
-	// Try to find jinja_iterative.star relative to current directory
-	paths := []string{
-		filepath.Join(dir, "internal/starlark/jinja_iterative.star"),
-		filepath.Join(dir, "../starlark/jinja_iterative.star"),
-		"internal/starlark/jinja_iterative.star",
-	}
-
-	for _, path := range paths {
-		content, err := os.ReadFile(path)
-		if err == nil {
-			jinjaStarlarkSource = string(content)
-			return
-		}
-	}
-
-	// If we can't find it, use a minimal stub
-	jinjaStarlarkSource = fallbackSource()
-}
-
-func fallbackSource() string {
-	return `
-def render(template, context, template_loader=None):
-    return "Error: jinja_iterative.star not loaded properly"
-`
+// SourceLoaded reports an error when the embedded template library fails to
+// load, which would make every render fail
+func SourceLoaded() error {
+	_, err := loadJinjaLibrary(&starlark.Thread{Name: "template check"})
+	return err
 }
 
 // TemplateLoader is a function that loads template content by name/ID.
@@ -56,7 +36,14 @@ type TemplateLoader func(name string) (string, error)
 // RenderContext holds all the data needed for template rendering
 type RenderContext struct {
 	Variables map[string]interface{} // Template variables
-	Loader    TemplateLoader          // Template loader for extends/include
+	Loader    TemplateLoader         // Template loader for extends/include
+	Limits    *RenderLimits          // Render budget; nil uses DefaultRenderLimits
+	Name      string                 // Template document id, if any, for include cycle errors
+
+	// ContentType of the output picks the autoescape mode (see EscapeModeFor);
+	// Autoescape, when set, overrides it. Neither set escapes HTML.
+	ContentType string
+	Autoescape  string
 }
 
 // RenderTemplate renders a Jinja2-style template using Go parser + Starlark execution.
@@ -66,7 +53,8 @@ type RenderContext struct {
 //   - templateSource: The Jinja template source code
 //   - renderCtx: Rendering context with variables and loader
 //
-// Returns: Rendered HTML string or error
+// Returns: Rendered HTML string or error; a *BudgetError when the render
+// exceeds its limits or ctx is done
 func RenderTemplate(ctx context.Context, templateSource string, renderCtx *RenderContext) (string, error) {
 	// Check for timeout
 	if deadline, ok := ctx.Deadline(); ok {
@@ -83,32 +71,63 @@ func RenderTemplate(ctx context.Context, templateSource string, renderCtx *Rende
 	}
 
 	// Handle template inheritance (extends)
-	nodes, err = handleInheritance(nodes, renderCtx.Loader)
+	nodes, parent, err := handleInheritance(ctx, nodes, renderCtx.Loader)
 	if err != nil {
 		return "", fmt.Errorf("template inheritance error: %w", err)
 	}
 
+	// Templates already on the page may not be included again
+	var chain []string
+	if renderCtx.Name != "" {
+		chain = append(chain, renderCtx.Name)
+	}
+	if parent != "" && parent != renderCtx.Name {
+		chain = append(chain, parent)
+	}
+
+	limits := DefaultRenderLimits
+	if renderCtx.Limits != nil {
+		limits = *renderCtx.Limits
+	}
+
+	state := newRenderState(renderCtx.Loader, limits)
+	state.chain = chain
+	switch {
+	case renderCtx.Autoescape != "":
+		if !IsValidEscapeMode(renderCtx.Autoescape) {
+			return "", fmt.Errorf("invalid autoescape mode: %s", renderCtx.Autoescape)
+		}
+		state.escape = renderCtx.Autoescape
+	case renderCtx.ContentType != "":
+		state.escape = EscapeModeFor(renderCtx.ContentType)
+	}
+
 	// Render the AST (uses iteration, not recursion)
-	return RenderAST(ctx, nodes, renderCtx.Variables, renderCtx.Loader)
+	return renderAST(ctx, nodes, renderCtx.Variables, state)
 }
 
-func handleInheritance(nodes []Node, loader TemplateLoader) ([]Node, error) {
+// handleInheritance merges a child template's blocks into the template it
+// extends, returning the merged nodes and the name of the parent template
+func handleInheritance(ctx context.Context, nodes []Node, loader TemplateLoader) ([]Node, string, error) {
 	// Check if first node is {% extends %}
 	if len(nodes) > 0 && nodes[0].Type == NodeExtends {
 		if loader == nil {
-			return nil, fmt.Errorf("template loader required for extends")
+			return nil, "", fmt.Errorf("template loader required for extends")
+		}
+		if err := checkDeadline(ctx); err != nil {
+			return nil, "", err
 		}
 
 		// Load parent template
 		parentSource, err := loader(nodes[0].Content)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load parent template %s: %w", nodes[0].Content, err)
+			return nil, "", fmt.Errorf("failed to load parent template %s: %w", nodes[0].Content, err)
 		}
 
 		// Parse parent
 		parentNodes, err := ParseTemplate(parentSource)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse parent template: %w", err)
+			return nil, "", fmt.Errorf("failed to parse parent template: %w", err)
 		}
 
 		// Extract blocks from child
@@ -121,10 +140,10 @@ func handleInheritance(nodes []Node, loader TemplateLoader) ([]Node, error) {
 
 		// Merge blocks into parent
 		mergedNodes := mergeBlocks(parentNodes, childBlocks)
-		return mergedNodes, nil
+		return mergedNodes, nodes[0].Content, nil
 	}
 
-	return nodes, nil
+	return nodes, "", nil
 }
 
 func mergeBlocks(parentNodes []Node, childBlocks map[string][]Node) []Node {
@@ -143,11 +162,6 @@ func mergeBlocks(parentNodes []Node, childBlocks map[string][]Node) []Node {
 
 // loadJinjaLibrary loads and executes the jinja_iterative.star Starlark module
 func loadJinjaLibrary(thread *starlark.Thread) (starlark.StringDict, error) {
-	// Check if source is loaded
-	if jinjaStarlarkSource == "" || len(jinjaStarlarkSource) < 100 {
-		return nil, fmt.Errorf("jinja starlark source not loaded (len=%d)", len(jinjaStarlarkSource))
-	}
-
 	// Execute the jinja_iterative.star source
 	// Note: ExecFile returns the globals, it doesn't modify the input dict
 	globals, err := starlark.ExecFile(thread, "jinja_iterative.star", jinjaStarlarkSource, nil)
@@ -188,64 +202,31 @@ func makeStarlarkLoader(loader TemplateLoader, ctx context.Context) starlark.Cal
 	})
 }
 
-// goToStarlark converts a Go map[string]interface{} to a Starlark dict
-func goToStarlark(data map[string]interface{}) *starlark.Dict {
+// goToStarlark converts template variables to a Starlark dict
+func goToStarlark(data map[string]interface{}) (*starlark.Dict, error) {
 	dict := starlark.NewDict(len(data))
 
 	for key, value := range data {
-		starlarkKey := starlark.String(key)
-		starlarkValue := valueToStarlark(value)
-		dict.SetKey(starlarkKey, starlarkValue)
-	}
-
-	return dict
-}
-
-// valueToStarlark converts a Go value to a Starlark value
-func valueToStarlark(value interface{}) starlark.Value {
-	if value == nil {
-		return starlark.None
-	}
-
-	switch v := value.(type) {
-	case string:
-		return starlark.String(v)
-	case int:
-		return starlark.MakeInt(v)
-	case int64:
-		return starlark.MakeInt64(v)
-	case float64:
-		return starlark.Float(v)
-	case bool:
-		return starlark.Bool(v)
-	case []interface{}:
-		items := make([]starlark.Value, len(v))
-		for i, item := range v {
-			items[i] = valueToStarlark(item)
-		}
-		return starlark.NewList(items)
-	case map[string]interface{}:
-		dict := starlark.NewDict(len(v))
-		for key, val := range v {
-			dict.SetKey(starlark.String(key), valueToStarlark(val))
+		starlarkValue, err := starconv.ToStarlark(value)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %w", key, err)
 		}
-		return dict
-	default:
-		// Fallback: convert to string
-		return starlark.String(fmt.Sprintf("%v", v))
+		dict.SetKey(starlark.String(key), starlarkValue)
 	}
+
+	return dict, nil
 }
 
 // DocumentLoader creates a TemplateLoader that loads templates from the document store
 func DocumentLoader(db *sql.DB) TemplateLoader {
 	return func(name string) (string, error) {
 		// Query _wce_documents for the template
-		var content string
+		var content, compression string
 		err := db.QueryRow(`
-			SELECT content
+			SELECT content, COALESCE(compression, '')
 			FROM _wce_documents
 			WHERE id = ?
-		`, name).Scan(&content)
+		`, name).Scan(&content, &compression)
 
 		if err == sql.ErrNoRows {
 			return "", fmt.Errorf("template not found: %s", name)
@@ -254,33 +235,105 @@ func DocumentLoader(db *sql.DB) TemplateLoader {
 			return "", fmt.Errorf("database error loading template: %w", err)
 		}
 
-		return content, nil
+		return document.DecodeContent(content, compression)
 	}
 }
 
 // RenderTemplateFromDB is a convenience function that renders a template loaded from the database
 func RenderTemplateFromDB(ctx context.Context, db *sql.DB, templateID string, variables map[string]interface{}) (string, error) {
+	rendered, err := RenderDocument(ctx, db, templateID, variables)
+	if err != nil {
+		return "", err
+	}
+	return rendered.Content, nil
+}
+
+// Rendered is the output of a template document and its content type
+type Rendered struct {
+	Content     string
+	ContentType string
+}
+
+// RenderDocument renders a template document to its output content type,
+// so the same engine produces pages, JSON, XML and email bodies. The output
+// type is the "content_type" metadata field when set, or else the
+// document's content type with its "+jinja" suffix removed; it picks the
+// autoescape mode. Unless variables define them, templates get a collections
+// variable that runs saved searches by name and a flags variable with the
+// feature flags of the user in the identity variable.
+func RenderDocument(ctx context.Context, db *sql.DB, templateID string, variables map[string]interface{}) (*Rendered, error) {
 	// Load the template document
-	var templateSource string
+	var templateSource, compression, contentType, metadata string
 	err := db.QueryRow(`
-		SELECT content
+		SELECT content, COALESCE(compression, ''), content_type, metadata
 		FROM _wce_documents
 		WHERE id = ?
-	`, templateID).Scan(&templateSource)
+	`, templateID).Scan(&templateSource, &compression, &contentType, &metadata)
 
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("template not found: %s", templateID)
+		return nil, fmt.Errorf("template not found: %s", templateID)
 	}
 	if err != nil {
-		return "", fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("database error: %w", err)
 	}
+	if templateSource, err = document.DecodeContent(templateSource, compression); err != nil {
+		return nil, err
+	}
+
+	outputType := OutputContentType(contentType, []byte(metadata))
+
+	// Saved searches are available as collections.<name> and feature flags
+	// as flags.<name>
+	globals := map[string]interface{}{
+		"collections": newCollectionsValue(db),
+		"flags":       newFlagsValue(db, variablesUserID(variables)),
+	}
+	withGlobals := make(map[string]interface{}, len(variables)+len(globals))
+	for key, value := range globals {
+		withGlobals[key] = value
+	}
+	for key, value := range variables {
+		withGlobals[key] = value
+	}
+	variables = withGlobals
 
 	// Create render context with document loader
 	renderCtx := &RenderContext{
-		Variables: variables,
-		Loader:    DocumentLoader(db),
+		Variables:   variables,
+		Loader:      DocumentLoader(db),
+		Name:        templateID,
+		ContentType: outputType,
 	}
 
 	// Render the template
-	return RenderTemplate(ctx, templateSource, renderCtx)
+	content, err := RenderTemplate(ctx, templateSource, renderCtx)
+	if err != nil {
+		return nil, err
+	}
+	return &Rendered{Content: content, ContentType: outputType}, nil
+}
+
+// OutputContentType returns the content type a template document renders
+// to, given its stored content type and metadata. Template types without an
+// output type of their own, such as "text/jinja", render HTML.
+func OutputContentType(contentType string, metadata []byte) string {
+	var meta struct {
+		ContentType string `json:"content_type"`
+	}
+	if json.Unmarshal(metadata, &meta) == nil && strings.TrimSpace(meta.ContentType) != "" {
+		return strings.TrimSpace(meta.ContentType)
+	}
+
+	mediaType, params, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSuffix(strings.TrimSpace(mediaType), "+jinja")
+	if mediaType == "" || strings.Contains(mediaType, "jinja") {
+		return "text/html; charset=utf-8"
+	}
+	if params != "" {
+		return mediaType + ";" + params
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
 }
@@ -3,12 +3,17 @@ package template
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/thetanil/wce/internal/sqlite"
 )
 
 // Test basic variable rendering
@@ -400,7 +405,7 @@ func TestRenderComplex(t *testing.T) {
 // Test RenderTemplateFromDB with in-memory database
 func TestRenderTemplateFromDB(t *testing.T) {
 	// Create in-memory database
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open database: %v", err)
 	}
@@ -418,7 +423,9 @@ func TestRenderTemplateFromDB(t *testing.T) {
 			modified_at INTEGER NOT NULL,
 			created_by TEXT NOT NULL,
 			modified_by TEXT NOT NULL,
-			version INTEGER DEFAULT 1
+			version INTEGER DEFAULT 1,
+			metadata TEXT NOT NULL DEFAULT '{}',
+			compression TEXT
 		)
 	`)
 	if err != nil {
@@ -496,9 +503,247 @@ func TestRenderTimeout(t *testing.T) {
 	}
 }
 
+// Test render budget enforcement
+func TestRenderLimits(t *testing.T) {
+	ctx := context.Background()
+
+	items := make([]interface{}, 50)
+	for i := range items {
+		items[i] = i
+	}
+	variables := map[string]interface{}{"items": items}
+
+	renderCtx := &RenderContext{
+		Variables: variables,
+		Limits:    &RenderLimits{MaxLoopIterations: 60},
+	}
+	if _, err := RenderTemplate(ctx, "{% for x in items %}{{ x }}{% endfor %}", renderCtx); err != nil {
+		t.Fatalf("Expected loop within budget to render, got: %v", err)
+	}
+
+	// Iterations are counted across loops, including nested ones
+	_, err := RenderTemplate(ctx, "{% for x in items %}{% for y in items %}{{ y }}{% endfor %}{% endfor %}", renderCtx)
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Budget != BudgetLoopIterations || budgetErr.Limit != 60 {
+		t.Errorf("Expected loop iteration budget error, got: %v", err)
+	}
+
+	// Output of included templates counts toward the same budget
+	renderCtx = &RenderContext{
+		Variables: variables,
+		Loader: func(name string) (string, error) {
+			return strings.Repeat("x", 40), nil
+		},
+		Limits: &RenderLimits{MaxOutputBytes: 100},
+	}
+	if _, err := RenderTemplate(ctx, `{% include "a" %}{% include "a" %}`, renderCtx); err != nil {
+		t.Fatalf("Expected output within budget to render, got: %v", err)
+	}
+	_, err = RenderTemplate(ctx, `{% include "a" %}{% include "a" %}{% include "a" %}`, renderCtx)
+	if !errors.As(err, &budgetErr) || budgetErr.Budget != BudgetOutputBytes {
+		t.Errorf("Expected output budget error, got: %v", err)
+	}
+}
+
+// Test that a context cancelled mid-render stops it
+func TestRenderASTCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	nodes, err := ParseTemplate("Hello {{ name }}")
+	if err != nil {
+		t.Fatalf("ParseTemplate failed: %v", err)
+	}
+
+	_, err = RenderAST(ctx, nodes, map[string]interface{}{"name": "World"}, nil)
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Budget != BudgetDeadline || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected deadline budget error, got: %v", err)
+	}
+}
+
+func TestRenderCancelledMidLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The loader stands in for a slow include: the deadline passes while it runs
+	loads := 0
+	loader := func(name string) (string, error) {
+		loads++
+		cancel()
+		return "item", nil
+	}
+
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = i
+	}
+
+	_, err := RenderTemplate(ctx, "{% for i in items %}{% include 'row' %}{% endfor %}", &RenderContext{
+		Variables: map[string]interface{}{"items": items},
+		Loader:    loader,
+	})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Budget != BudgetDeadline {
+		t.Fatalf("Expected deadline budget error, got: %v", err)
+	}
+	if loads != 1 {
+		t.Errorf("Expected render to stop after the first include, loaded %d times", loads)
+	}
+
+	_, err = RenderTemplate(ctx, "{% extends 'base' %}", &RenderContext{Loader: loader})
+	if !errors.As(err, &budgetErr) || budgetErr.Budget != BudgetDeadline || loads != 1 {
+		t.Errorf("Expected extends to stop before loading the parent, got: %v", err)
+	}
+}
+
+func TestRenderIncludeCycle(t *testing.T) {
+	templates := map[string]string{
+		"self":   "{% include 'self' %}",
+		"a":      "A{% include 'b' %}",
+		"b":      "B{% include 'a' %}",
+		"row":    "[{{ x }}]",
+		"page":   "{% include 'header' %}",
+		"header": "<h1>{% include 'page' %}</h1>",
+	}
+	loader := func(name string) (string, error) {
+		if source, ok := templates[name]; ok {
+			return source, nil
+		}
+		// Every other name includes a deeper, distinct template
+		return "{% include '" + name + "x' %}", nil
+	}
+	render := func(source, name string) error {
+		_, err := RenderTemplate(context.Background(), source, &RenderContext{
+			Variables: map[string]interface{}{"x": 1},
+			Loader:    loader,
+			Name:      name,
+		})
+		return err
+	}
+
+	tests := []struct {
+		source, name, want string
+	}{
+		{"{% include 'self' %}", "", "self -> self"},
+		{"{% include 'a' %}", "", "a -> b -> a"},
+		{templates["page"], "page", "page -> header -> page"},
+	}
+	for _, tt := range tests {
+		err := render(tt.source, tt.name)
+		if err == nil || !strings.Contains(err.Error(), "include cycle: "+tt.want) {
+			t.Errorf("Render of %q: expected cycle %s, got: %v", tt.source, tt.want, err)
+		}
+	}
+
+	if err := render("{% include 'deep' %}", ""); err == nil || !strings.Contains(err.Error(), "nested deeper than") {
+		t.Errorf("Expected include depth error, got: %v", err)
+	}
+
+	// Including the same template repeatedly is not a cycle
+	if err := render("{% include 'row' %}{% include 'row' %}", ""); err != nil {
+		t.Errorf("Expected repeated include to render, got: %v", err)
+	}
+}
+
+func TestRenderLiteralsAndIndexing(t *testing.T) {
+	variables := map[string]interface{}{
+		"items":  []interface{}{"first", "second", "third"},
+		"prices": map[string]interface{}{"apple": 1.5},
+		"rows": []interface{}{
+			map[string]interface{}{"cells": []interface{}{"a", "b"}},
+		},
+		"key":   "apple",
+		"point": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{"x": starlark.MakeInt(3)}),
+		"raw":   []byte("bytes"),
+		"pair":  starlark.Tuple{starlark.String("a"), starlark.String("b")},
+		"count": json.Number("123456789012345678901234567890"),
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{{ 5 }}", "5"},
+		{"{{ -3 }}", "-3"},
+		{"{{ 2.5 }}", "2.5"},
+		{"{{ 'it\\'s' }}", "it&#39;s"},
+		{"{{ [1, 2, 3]|length }}", "3"},
+		{"{{ {'a': 1, 'b': 2}|length }}", "2"},
+		{"{{ [10, 20][1] }}", "20"},
+		{"{{ items[0] }}", "first"},
+		{"{{ items[-1] }}", "third"},
+		{"{{ items.1 }}", "second"},
+		{"{{ items[7] }}", ""},
+		{"{{ prices['apple'] }}", "1.5"},
+		{"{{ prices[key] }}", "1.5"},
+		{"{{ rows[0].cells[1] }}", "b"},
+		{"{{ missing[0].name }}", ""},
+		{"{% if items[0] %}yes{% endif %}", "yes"},
+		{"{% if false %}yes{% else %}no{% endif %}", "no"},
+		{"{% for n in [1, 2] %}{{ n }}{% endfor %}", "12"},
+		{"{{ point.x }}", "3"},
+		{"{{ key.upper }}", ""},
+		{"{{ raw }}", "bytes"},
+		{"{{ raw|upper }}", "BYTES"},
+		{"{% for n in pair %}{{ n }}{% endfor %}", "ab"},
+		{"{{ count }}", "123456789012345678901234567890"},
+	}
+
+	for _, tt := range tests {
+		got, err := RenderTemplate(context.Background(), tt.template, &RenderContext{Variables: variables})
+		if err != nil {
+			t.Errorf("Render of %q failed: %v", tt.template, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Render of %q = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestRenderAutoescape(t *testing.T) {
+	variables := map[string]interface{}{"name": `<b>"O'Brien" & co</b>`}
+
+	tests := []struct {
+		template    string
+		contentType string
+		autoescape  string
+		want        string
+	}{
+		{"{{ name }}", "", "", "&lt;b&gt;&#34;O&#39;Brien&#34; &amp; co&lt;/b&gt;"},
+		{"{{ name }}", "text/plain; charset=utf-8", "", `<b>"O'Brien" & co</b>`},
+		{`{"name": "{{ name }}"}`, "application/json", "", `{"name": "\u003cb\u003e\"O\u0027Brien\" \u0026 co\u003c/b\u003e"}`},
+		{"<n>{{ name }}</n>", "application/xml", "", "<n>&lt;b&gt;&#34;O&#39;Brien&#34; &amp; co&lt;/b&gt;</n>"},
+		{"{{ name }}", "text/html", "none", `<b>"O'Brien" & co</b>`},
+		{"{{ name|safe }}", "", "", `<b>"O'Brien" & co</b>`},
+		{"{% autoescape false %}{{ name }}{% endautoescape %}|{{ name }}", "", "", `<b>"O'Brien" & co</b>|&lt;b&gt;&#34;O&#39;Brien&#34; &amp; co&lt;/b&gt;`},
+		{"{% autoescape 'html' %}{{ name }}{% endautoescape %}", "text/plain", "", "&lt;b&gt;&#34;O&#39;Brien&#34; &amp; co&lt;/b&gt;"},
+	}
+
+	for _, tt := range tests {
+		got, err := RenderTemplate(context.Background(), tt.template, &RenderContext{
+			Variables:   variables,
+			ContentType: tt.contentType,
+			Autoescape:  tt.autoescape,
+		})
+		if err != nil {
+			t.Errorf("Render of %q failed: %v", tt.template, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Render of %q as %q = %q, want %q", tt.template, tt.contentType, got, tt.want)
+		}
+	}
+
+	if _, err := RenderTemplate(context.Background(), "{% autoescape 'sql' %}{% endautoescape %}", &RenderContext{}); err == nil {
+		t.Error("Expected an unknown autoescape mode to fail")
+	}
+}
+
 // Test error handling: template not found
 func TestRenderTemplateNotFound(t *testing.T) {
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
 	if err != nil {
 		t.Fatalf("Failed to open database: %v", err)
 	}
@@ -513,7 +758,9 @@ func TestRenderTemplateNotFound(t *testing.T) {
 			created_at INTEGER NOT NULL,
 			modified_at INTEGER NOT NULL,
 			created_by TEXT NOT NULL,
-			modified_by TEXT NOT NULL
+			modified_by TEXT NOT NULL,
+			metadata TEXT NOT NULL DEFAULT '{}',
+			compression TEXT
 		)
 	`)
 	if err != nil {
@@ -531,3 +778,121 @@ func TestRenderTemplateNotFound(t *testing.T) {
 		t.Errorf("Expected 'not found' error, got: %v", err)
 	}
 }
+
+// Test RenderDocument renders to the template's output content type
+func TestRenderDocumentOutputType(t *testing.T) {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE _wce_documents (
+			id TEXT PRIMARY KEY,
+			content TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			modified_at INTEGER NOT NULL,
+			created_by TEXT NOT NULL,
+			modified_by TEXT NOT NULL,
+			metadata TEXT NOT NULL DEFAULT '{}',
+			compression TEXT
+		)
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	now := time.Now().Unix()
+	templates := []struct {
+		id, content, contentType, metadata string
+	}{
+		{"emails/welcome.txt", "Hello {{ name }},\nwelcome aboard.", "text/plain+jinja", "{}"},
+		{"api/user.json", `{"name": "{{ name }}"}`, "application/json+jinja", "{}"},
+		{"feeds/user.xml", "<user>{{ name }}</user>", "text/jinja", `{"content_type": "application/xml"}`},
+		{"pages/user.html", "<p>{{ name }}</p>", "text/jinja", "{}"},
+	}
+	for _, tmpl := range templates {
+		_, err = db.Exec(`
+			INSERT INTO _wce_documents (id, content, content_type, created_at, modified_at, created_by, modified_by, metadata)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, tmpl.id, tmpl.content, tmpl.contentType, now, now, "test-user", "test-user", tmpl.metadata)
+		if err != nil {
+			t.Fatalf("Failed to insert %s: %v", tmpl.id, err)
+		}
+	}
+
+	tests := []struct {
+		id, wantContent, wantType string
+	}{
+		{"emails/welcome.txt", "Hello Tom & \"Jerry\",\nwelcome aboard.", "text/plain; charset=utf-8"},
+		{"api/user.json", `{"name": "Tom \u0026 \"Jerry\""}`, "application/json"},
+		{"feeds/user.xml", "<user>Tom &amp; &#34;Jerry&#34;</user>", "application/xml"},
+		{"pages/user.html", "<p>Tom &amp; &#34;Jerry&#34;</p>", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		rendered, err := RenderDocument(context.Background(), db, tt.id, map[string]interface{}{"name": `Tom & "Jerry"`})
+		if err != nil {
+			t.Errorf("RenderDocument(%s) failed: %v", tt.id, err)
+			continue
+		}
+		if rendered.Content != tt.wantContent {
+			t.Errorf("RenderDocument(%s) content = %q, want %q", tt.id, rendered.Content, tt.wantContent)
+		}
+		if rendered.ContentType != tt.wantType {
+			t.Errorf("RenderDocument(%s) content type = %q, want %q", tt.id, rendered.ContentType, tt.wantType)
+		}
+	}
+}
+
+func TestParseTemplate_TruncatedTags(t *testing.T) {
+	for _, source := range []string{"{%}", "{{}", "{#}", "{%block %}x", "{%block%}{%endblock%}"} {
+		if _, err := ParseTemplate(source); err == nil {
+			t.Errorf("Expected %q to be rejected", source)
+		}
+	}
+
+	// Statements shorter than their keyword plus a space must not panic
+	for _, source := range []string{"{%include%}", "{%extends%}"} {
+		if _, err := ParseTemplate(source); err != nil {
+			t.Errorf("ParseTemplate(%q) failed: %v", source, err)
+		}
+	}
+}
+
+// FuzzParseTemplate checks that no template source, however malformed, makes
+// the parser or renderer panic, and that text without tags renders as is
+func FuzzParseTemplate(f *testing.F) {
+	for _, seed := range []string{
+		"Hello {{ name }}!",
+		"{% for x in items %}{{ loop.index }}: {{ x|upper }}{% endfor %}",
+		"{% if name == 'a' %}a{% elif name %}b{% else %}c{% endif %}",
+		"{% set y = items|length %}{{ y + 1 }}",
+		`{% extends "base.html" %}{% block body %}{{ super() }}{% endblock %}`,
+		`{% include "partial.html" %}{# comment #}`,
+		"{% autoescape false %}{{ name }}{% endautoescape %}",
+		"{{ name['key'] }}{{ items[0] }}{{ 'a' ~ \"b\" }}",
+		"{{", "{%", "{#", "{{ }}", "{% endfor %}", "{{ name|default(",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		if _, err := ParseTemplate(source); err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		output, err := RenderTemplate(ctx, source, &RenderContext{
+			Variables: map[string]interface{}{
+				"name":  "<b>World</b>",
+				"items": []interface{}{1, "two", nil},
+			},
+		})
+		if err == nil && !strings.Contains(source, "{") && output != source {
+			t.Errorf("Text without tags rendered as %q, want %q", output, source)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+// Package websocket implements the subset of RFC 6455 needed for
+// interactive admin tools: the server handshake, a minimal client,
+// and unfragmented text/binary messages with ping/pong and close handling.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// handshakeGUID is the fixed GUID from RFC 6455 section 1.3
+const handshakeGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize limits the payload size of a single incoming frame
+const MaxMessageSize = 1 << 20
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// ErrClosed is returned when the peer closed the connection
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a WebSocket connection
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isClient bool
+
+	writeMu sync.Mutex
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value for a client key
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + handshakeGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains reports whether a comma-separated header contains a token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsUpgrade reports whether the request asks for a WebSocket upgrade
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade performs the server side of the handshake and hijacks the connection.
+// On failure an HTTP error has already been written to w.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("not a websocket upgrade request")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack connection: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+
+	if _, err := rw.WriteString(response); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to write handshake: %w", err)
+	}
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to write handshake: %w", err)
+	}
+
+	return &Conn{conn: netConn, reader: rw.Reader}, nil
+}
+
+// Dial opens a client connection to a ws:// URL
+func Dial(rawURL string, header http.Header) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	netConn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err := req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	reader := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		netConn.Close()
+		return nil, fmt.Errorf("handshake failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		netConn.Close()
+		return nil, fmt.Errorf("handshake failed: invalid accept key")
+	}
+
+	return &Conn{conn: netConn, reader: reader, isClient: true}, nil
+}
+
+// writeFrame writes a single final frame. Client frames are masked as required.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	length := len(payload)
+
+	switch {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if c.isClient {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		header = append(header, mask...)
+
+		masked := make([]byte, length)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+	return nil
+}
+
+// readFrame reads a single frame and returns its opcode and unmasked payload
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	final := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > MaxMessageSize {
+		return false, 0, nil, fmt.Errorf("frame too large: %d bytes", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return final, opcode, payload, nil
+}
+
+// ReadMessage reads the next data message, answering pings and reassembling
+// fragmented messages. Returns ErrClosed when the peer closes the connection.
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var messageType byte
+	var message []byte
+
+	for {
+		final, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if err == io.EOF {
+				return 0, nil, ErrClosed
+			}
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return 0, nil, ErrClosed
+		case OpText, OpBinary:
+			messageType = opcode
+			message = payload
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, fmt.Errorf("unexpected continuation frame")
+			}
+			if len(message)+len(payload) > MaxMessageSize {
+				return 0, nil, fmt.Errorf("message too large")
+			}
+			message = append(message, payload...)
+		default:
+			return 0, nil, fmt.Errorf("unknown opcode: %d", opcode)
+		}
+
+		if final {
+			return messageType, message, nil
+		}
+	}
+}
+
+// WriteMessage writes a single text or binary message
+func (c *Conn) WriteMessage(messageType byte, data []byte) error {
+	return c.writeFrame(messageType, data)
+}
+
+// ReadJSON reads the next message and decodes it as JSON
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteJSON encodes v as JSON and sends it as a text message
+func (c *Conn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return c.WriteMessage(OpText, data)
+}
+
+// Close sends a normal close frame and closes the underlying connection
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, []byte{0x03, 0xE8}) // 1000: normal closure
+	return c.conn.Close()
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	got := acceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	if got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key: %s", got)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	conn, err := Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	t.Run("Text", func(t *testing.T) {
+		if err := conn.WriteJSON(map[string]string{"hello": "world"}); err != nil {
+			t.Fatalf("WriteJSON failed: %v", err)
+		}
+
+		var reply map[string]string
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		if reply["hello"] != "world" {
+			t.Errorf("Unexpected reply: %v", reply)
+		}
+	})
+
+	t.Run("LargeMessage", func(t *testing.T) {
+		// Exceeds the 16-bit length encoding
+		payload := []byte(strings.Repeat("x", 70000))
+		if err := conn.WriteMessage(OpBinary, payload); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		if messageType != OpBinary || len(data) != len(payload) {
+			t.Errorf("Expected %d byte binary message, got type %d with %d bytes", len(payload), messageType, len(data))
+		}
+	})
+}
+
+func TestUpgrade_RequiresHandshake(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r)
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("Expected 426, got %d", resp.StatusCode)
+	}
+}